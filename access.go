@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+)
+
+// accessFlags are the options that restrict who can use the network
+// listeners, and how --receive connects to a broadcast that is restricted.
+type accessFlags struct {
+	tokenFile string
+	certFile  string
+	keyFile   string
+	caFile    string
+	allow     string
+	rateLimit float64
+}
+
+// readToken reads the token from its file, the token is not given directly
+// because command lines can be seen by every user.
+func (flags accessFlags) readToken() (string, error) {
+	if flags.tokenFile == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(flags.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", flags.tokenFile)
+	}
+
+	return token, nil
+}
+
+// access returns the access control of the listeners. With listening,
+// --tls-ca is only checked over tls, so it needs --tls-cert; without it is
+// what --receive checks the broadcast with.
+func (flags accessFlags) access(listening bool) (overlay.AccessControl, error) {
+	if (flags.certFile == "") != (flags.keyFile == "") {
+		return overlay.AccessControl{}, fmt.Errorf("--tls-cert and --tls-key have to be given together")
+	}
+
+	if listening && flags.caFile != "" && flags.certFile == "" {
+		return overlay.AccessControl{}, fmt.Errorf("--tls-ca needs --tls-cert, client certificates are only checked over tls")
+	}
+
+	token, err := flags.readToken()
+	if err != nil {
+		return overlay.AccessControl{}, err
+	}
+
+	access := overlay.AccessControl{
+		Token:     token,
+		RateLimit: flags.rateLimit,
+	}
+
+	if flags.allow != "" {
+		access.Commands = strings.Split(flags.allow, ",")
+	}
+
+	if flags.certFile != "" {
+		access.TLS, err = overlay.ServerTLS(flags.certFile, flags.keyFile, flags.caFile)
+		if err != nil {
+			return overlay.AccessControl{}, err
+		}
+	}
+
+	return access, nil
+}
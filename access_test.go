@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestAccessFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     accessFlags
+		listening bool
+		ok        bool
+	}{
+		{"nothing", accessFlags{}, true, true},
+		{"ca for the listeners without a certificate", accessFlags{caFile: "ca.pem"}, true, false},
+		{"ca for --receive", accessFlags{caFile: "ca.pem"}, false, true},
+		{"certificate without a key", accessFlags{certFile: "cert.pem"}, true, false},
+		{"key without a certificate", accessFlags{keyFile: "key.pem"}, false, false},
+	}
+
+	for _, test := range tests {
+		_, err := test.flags.access(test.listening)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: err %v", test.name, err)
+		}
+	}
+}
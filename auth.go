@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authFlags are the options that authenticate the requests of --url, for
+// dashboards that are only visible after logging in.
+type authFlags struct {
+	headers   []string
+	basicAuth string
+	tokenEnv  string
+	tokenFile string
+}
+
+func (flags authFlags) set() bool {
+	return len(flags.headers) > 0 || flags.basicAuth != "" || flags.tokenEnv != "" || flags.tokenFile != ""
+}
+
+// bearerToken reads the token from the environment variable or file, neither
+// can be seen by other users like command lines.
+func (flags authFlags) bearerToken() (string, error) {
+	switch {
+	case flags.tokenEnv != "":
+		token := strings.TrimSpace(os.Getenv(flags.tokenEnv))
+		if token == "" {
+			return "", fmt.Errorf("$%s is empty", flags.tokenEnv)
+		}
+
+		return token, nil
+	case flags.tokenFile != "":
+		data, err := os.ReadFile(flags.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("read bearer token: %w", err)
+		}
+
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("bearer token file %s is empty", flags.tokenFile)
+		}
+
+		return token, nil
+	}
+
+	return "", nil
+}
+
+// header returns the headers to send with every request.
+func (flags authFlags) header() (http.Header, error) {
+	authorizations := 0
+	for _, set := range []bool{flags.basicAuth != "", flags.tokenEnv != "", flags.tokenFile != ""} {
+		if set {
+			authorizations++
+		}
+	}
+
+	if authorizations > 1 {
+		return nil, fmt.Errorf("--basic-auth, --bearer-token-env and --bearer-token-file can't be combined")
+	}
+
+	header := http.Header{}
+
+	for _, line := range flags.headers {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("parse --header %q: expected \"Name: value\"", line)
+		}
+
+		header.Add(name, strings.TrimSpace(value))
+	}
+
+	if flags.basicAuth != "" {
+		if !strings.Contains(flags.basicAuth, ":") {
+			return nil, fmt.Errorf("parse --basic-auth: expected user:password")
+		}
+
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(flags.basicAuth)))
+	}
+
+	token, err := flags.bearerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	return header, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// files with other extensions are left out when a directory is given
+var imageExtensions = []string{".png", ".apng", ".jpg", ".jpeg", ".gif", ".webp", ".svg"}
+
+// sortOrder is the order the images of a directory are shown in.
+type sortOrder string
+
+const (
+	sortName sortOrder = "name"
+	// oldest first, so that new files are added at the end
+	sortMtime  sortOrder = "mtime"
+	sortSize   sortOrder = "size"
+	sortRandom sortOrder = "random"
+)
+
+var sortOrders = []sortOrder{sortName, sortMtime, sortSize, sortRandom}
+
+func parseSortOrder(name string) (sortOrder, error) {
+	for _, order := range sortOrders {
+		if string(order) == name {
+			return order, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown sort order %q", name)
+}
+
+// directoryImages lists the images in dir whose names match the glob
+// pattern match, or all of them if it is empty.
+func directoryImages(dir string, order sortOrder, match string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	type file struct {
+		path string
+		info os.FileInfo
+	}
+
+	var files []file
+	for _, entry := range entries {
+		extension := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || !slices.Contains(imageExtensions, extension) {
+			continue
+		}
+
+		if match != "" {
+			// the pattern was checked by parseMatch
+			matched, _ := filepath.Match(match, entry.Name())
+			if !matched {
+				continue
+			}
+		}
+
+		// the file may have been removed since reading the directory
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, file{path: filepath.Join(dir, entry.Name()), info: info})
+	}
+
+	switch order {
+	case sortMtime:
+		slices.SortStableFunc(files, func(a file, b file) int {
+			return a.info.ModTime().Compare(b.info.ModTime())
+		})
+	case sortSize:
+		slices.SortStableFunc(files, func(a file, b file) int {
+			return cmp.Compare(a.info.Size(), b.info.Size())
+		})
+	case sortRandom:
+		rand.Shuffle(len(files), func(i int, j int) {
+			files[i], files[j] = files[j], files[i]
+		})
+	}
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+
+	return paths, nil
+}
+
+func parseMatch(match string) error {
+	_, err := filepath.Match(match, "")
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", match, err)
+	}
+
+	return nil
+}
+
+// expandDirectories replaces the directories in args by the images in them
+// and returns the directories separately.
+func expandDirectories(args []string, order sortOrder, match string) (images []string, dirs []string, err error) {
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if arg == "-" || err != nil || !info.IsDir() {
+			// missing files are reported when they are loaded
+			images = append(images, arg)
+			continue
+		}
+
+		files, err := directoryImages(arg, order, match)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		images = append(images, files...)
+		dirs = append(dirs, arg)
+	}
+
+	return images, dirs, nil
+}
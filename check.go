@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// how long a url gets to answer when it is checked
+const checkTimeout = 10 * time.Second
+
+// options whose values are files that have to exist
+var checkedFiles = []string{"playlist", "font", "token-file", "bearer-token-file", "tls-cert", "tls-key", "tls-ca"}
+
+// options that are sent with requests to --url, which may be refused
+// without them
+var checkedAuth = []string{"header", "basic-auth", "bearer-token-env", "bearer-token-file", "presign"}
+
+// optionChecks parse the values of options the way the overlay does when it
+// starts.
+var optionChecks = map[string]func(value string) error{
+	"geometry":      func(value string) error { _, err := overlay.ParseGeometry(value); return err },
+	"at":            func(value string) error { _, err := parsePlacement(value); return err },
+	"toggle-key":    func(value string) error { _, err := overlay.ParseKeyCombo(value); return err },
+	"privacy-key":   func(value string) error { _, err := overlay.ParseKeyCombo(value); return err },
+	"scale":         func(value string) error { _, err := overlay.ParseScaleMode(value); return err },
+	"align":         func(value string) error { _, err := overlay.ParseAlignment(value); return err },
+	"filter":        func(value string) error { _, err := overlay.ParseScaleFilter(value); return err },
+	"blend":         func(value string) error { _, err := overlay.ParseBlendMode(value); return err },
+	"layout":        func(value string) error { _, err := overlay.ParseLayout(value); return err },
+	"channel":       func(value string) error { _, err := overlay.ParseChannel(value); return err },
+	"diff-channel":  func(value string) error { _, err := overlay.ParseChannel(value); return err },
+	"cvd":           func(value string) error { _, err := overlay.ParseCVD(value); return err },
+	"split":         func(value string) error { _, err := overlay.ParseSplit(value); return err },
+	"transparency":  func(value string) error { _, err := overlay.ParseTransparency(value); return err },
+	"stale-style":   func(value string) error { _, err := overlay.ParseStaleStyle(value); return err },
+	"record-format": func(value string) error { _, err := overlay.ParseRecordFormat(value); return err },
+	"solid":         func(value string) error { _, err := overlay.ParseColor(value); return err },
+	"outline":       func(value string) error { _, err := overlay.ParseColor(value); return err },
+	"blueprint":     func(value string) error { _, err := overlay.ParseColor(value); return err },
+	"text-color":    func(value string) error { _, err := overlay.ParseColor(value); return err },
+	"crop":          func(value string) error { _, err := overlay.ParseZone(value); return err },
+	"privacy-zone":  func(value string) error { _, err := overlay.ParseZone(value); return err },
+	"redact":        func(value string) error { _, err := overlay.ParseZone(value); return err },
+	"corners":       func(value string) error { _, err := overlay.ParseCorners(value); return err },
+	"guides":        func(value string) error { _, err := overlay.ParseGuides(value); return err },
+	"box":           func(value string) error { _, err := overlay.ParseBox(value); return err },
+	"line":          func(value string) error { _, err := overlay.ParseLine(value); return err },
+	"stdin-raw":     func(value string) error { _, err := overlay.ParseRawFormat(value); return err },
+	"sequence":      overlay.ParseSequence,
+	"contrast": func(value string) error {
+		if value == "auto" {
+			return nil
+		}
+
+		_, err := overlay.ParseZone(value)
+		return err
+	},
+}
+
+// checker collects the problems of a config or scene file.
+type checker struct {
+	path string
+	// the directory relative paths are relative to, the working directory
+	// if it is empty
+	dir      string
+	flags    *pflag.FlagSet
+	problems []string
+}
+
+func (c *checker) problem(where string, err error) {
+	c.problems = append(c.problems, fmt.Sprintf("%s: %s: %v", c.path, where, err))
+}
+
+func newCheckCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check <config.toml|scene.yaml>",
+		Short: "check a config or scene file without showing anything",
+		Long: `Check a config or scene file the way the overlay reads it, without
+connecting to the X server: the options and their values, the key bindings
+and geometries, and, in scenes, that the images and files exist and urls
+answer. Every problem is printed and the exit code is 1 if there was any,
+for provisioning scripts. The config file is checked with all its profiles.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				return fmt.Errorf("resolve path: %w", err)
+			}
+
+			c := &checker{path: args[0], flags: cmd.Root().Flags()}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", args[0], err)
+			}
+
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".toml":
+				c.checkConfig(string(data))
+			case ".yaml", ".yml":
+				// like the windows, which are started there
+				c.dir = filepath.Dir(path)
+				c.checkScene(string(data))
+			default:
+				return fmt.Errorf("%s: expected a config file ending in .toml or a scene ending in .yaml", args[0])
+			}
+
+			if len(c.problems) > 0 {
+				for _, problem := range c.problems {
+					fmt.Fprintln(os.Stderr, problem)
+				}
+
+				return fmt.Errorf("%s: %d problems", args[0], len(c.problems))
+			}
+
+			fmt.Printf("%s: ok\n", args[0])
+
+			return nil
+		},
+	}
+}
+
+func (c *checker) checkConfig(data string) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		c.problem("parse", err)
+		return
+	}
+
+	c.checkConfigValues("defaults", cfg.defaults)
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.profiles)) {
+		c.checkConfigValues("profile "+name, cfg.profiles[name])
+	}
+}
+
+// checkConfigValues checks the options of one table of the config.
+func (c *checker) checkConfigValues(where string, values configValues) {
+	options := map[string][]string{}
+
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		flag, err := configFlag(c.flags, c.path, key)
+		if err != nil {
+			c.problem(where, fmt.Errorf("%q can't be set in the config", key))
+			continue
+		}
+
+		options[flag.Name] = values[key]
+	}
+
+	c.checkOptions(where, options)
+}
+
+func (c *checker) checkScene(data string) {
+	s, err := parseScene(data, c.flags)
+	if err != nil {
+		c.problem("parse", err)
+		return
+	}
+
+	for _, window := range s.windows {
+		where := "window " + window.name
+
+		options := map[string][]string{}
+		for _, arg := range window.args {
+			name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			options[name] = append(options[name], value)
+		}
+
+		c.checkOptions(where, options)
+
+		auth := false
+		for _, name := range checkedAuth {
+			auth = auth || len(options[name]) > 0
+		}
+
+		for _, value := range options["url"] {
+			c.checkURL(where+": url", value, auth)
+		}
+
+		for _, source := range window.sources {
+			c.checkSource(where, source)
+		}
+	}
+}
+
+// checkOptions checks the values of options and the key bindings they make.
+func (c *checker) checkOptions(where string, options map[string][]string) {
+	for _, name := range slices.Sorted(maps.Keys(options)) {
+		flag := c.flags.Lookup(name)
+
+		for _, value := range options[name] {
+			err := checkConfigValue(flag, value)
+			if err != nil {
+				c.problem(where+": "+name, err)
+				continue
+			}
+
+			if check, ok := optionChecks[name]; ok {
+				err := check(value)
+				if err != nil {
+					c.problem(where+": "+name, err)
+				}
+			}
+
+			if slices.Contains(checkedFiles, name) {
+				c.checkFile(where+": "+name, value)
+			}
+		}
+	}
+
+	_, err := overlay.ParseKeymap(slices.Concat(overlay.DefaultBindings, options["bind"]))
+	if err != nil {
+		c.problem(where+": bind", err)
+	}
+}
+
+// checkSource checks that an image, a directory of them or a url is there.
+func (c *checker) checkSource(where string, source string) {
+	switch {
+	case source == "-":
+		// stdin is there when the window is started
+	case isImageURL(source):
+		c.checkURL(where+": source", source, false)
+	case isOverlayURI(source):
+		uri, err := parseOverlayURI(source)
+		if err != nil {
+			c.problem(where+": source", err)
+			return
+		}
+
+		c.checkFile(where+": source", uri.file)
+	default:
+		path, err := filePath(source)
+		if err != nil {
+			c.problem(where+": source", err)
+			return
+		}
+
+		c.checkFile(where+": source", path)
+	}
+}
+
+func (c *checker) checkFile(where string, path string) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.dir, path)
+	}
+
+	_, err := os.Stat(path)
+	if err != nil {
+		c.problem(where, err)
+	}
+}
+
+// checkURL checks that a url answers. Without the credentials the overlay
+// sends, if it needs any, it is only checked that the server answers.
+func (c *checker) checkURL(where string, url string, auth bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		c.problem(where, err)
+		return
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		c.problem(where, err)
+		return
+	}
+	response.Body.Close()
+
+	refused := response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden
+	if response.StatusCode >= 400 && !(auth && refused) {
+		c.problem(where, fmt.Errorf("%s answers %s", url, response.Status))
+	}
+}
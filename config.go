@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/pflag"
+)
+
+// options that choose what is shown instead of how, they would make every
+// invocation show the same thing
+var unconfigurable = []string{"window", "sequence", "magnify", "solid", "stdin-raw", "receive", "source-plugin", "clipboard", "figma", "at", "url", "presign", "source", "check", "print-runtime-deps", "header", "basic-auth", "bearer-token-env", "bearer-token-file", "profile", "help"}
+
+// configValues maps option names to their values, options that can be
+// given multiple times have several.
+type configValues map[string][]string
+
+// config is the config file, a subset of toml whose keys are the long
+// options, with - or _:
+//
+//	opacity = 0.4
+//	bind = ["ctrl+q=quit", "f=none"]
+//
+//	[profiles.review]
+//	scale = "fill"
+//	layer = "overlay"
+type config struct {
+	defaults configValues
+	profiles map[string]configValues
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "xoverlay", "config.toml"), nil
+}
+
+// readConfig returns the path of the config file and its options, with the
+// ones of profile over the defaults. There are none if there is no config
+// file and no profile was asked for.
+func readConfig(profile string) (string, configValues, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && profile == "" {
+		return path, configValues{}, nil
+	}
+
+	if err != nil {
+		return "", nil, fmt.Errorf("read config: %w", err)
+	}
+
+	cfg, err := parseConfig(string(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	values := maps.Clone(cfg.defaults)
+	if profile != "" {
+		overrides, ok := cfg.profiles[profile]
+		if !ok {
+			return "", nil, fmt.Errorf("no profile %q in %s", profile, path)
+		}
+
+		maps.Copy(values, overrides)
+	}
+
+	return path, values, nil
+}
+
+// configFlag returns the option key of the config file at path sets.
+func configFlag(flags *pflag.FlagSet, path string, key string) (*pflag.Flag, error) {
+	flag := flags.Lookup(key)
+	if flag == nil || slices.Contains(unconfigurable, key) {
+		return nil, fmt.Errorf("%s: %q can't be set in the config", path, key)
+	}
+
+	return flag, nil
+}
+
+// applyConfig sets the options of the config file and the given profile,
+// which overrides the defaults, unless they are given on the command line.
+func applyConfig(flags *pflag.FlagSet, profile string) error {
+	path, values, err := readConfig(profile)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		flag, err := configFlag(flags, path, key)
+		if err != nil {
+			return err
+		}
+
+		if flag.Changed {
+			continue
+		}
+
+		for _, value := range values[key] {
+			err := flags.Set(flag.Name, value)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", path, flag.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configBindings checks the config file again after it changed and returns
+// its key bindings, which are applied to the running overlay. The other
+// options take effect the next time it starts.
+func configBindings(flags *pflag.FlagSet, profile string) ([]string, error) {
+	path, values, err := readConfig(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []string
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		flag, err := configFlag(flags, path, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values[key] {
+			err := checkConfigValue(flag, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, flag.Name, err)
+			}
+		}
+
+		if flag.Name == "bind" {
+			bindings = values[key]
+		}
+	}
+
+	return bindings, nil
+}
+
+// checkConfigValue checks that value can be set for flag, without setting
+// it. Only the types of values are checked, not what they mean.
+func checkConfigValue(flag *pflag.Flag, value string) error {
+	var err error
+
+	switch flag.Value.Type() {
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "uint64":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "float64":
+		_, err = strconv.ParseFloat(value, 64)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+
+	return err
+}
+
+// watchConfig applies the key bindings of the config file to display when
+// it is saved, unless bindings were given on the command line. Mistakes are
+// shown in the window, the overlay keeps running as it was.
+func watchConfig(display *overlay.Window, flags *pflag.FlagSet, profile string, commandLine []string) (*fileWatcher, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return watchFile(path, func() {
+		bindings, err := configBindings(flags, profile)
+		if err != nil {
+			slog.Error("reload config", "err", err)
+			display.Notify(err.Error())
+			return
+		}
+
+		if commandLine != nil {
+			bindings = commandLine
+		}
+
+		keymap, err := overlay.ParseKeymap(slices.Concat(overlay.DefaultBindings, bindings))
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			slog.Error("reload config", "err", err)
+			display.Notify(err.Error())
+			return
+		}
+
+		err = display.SetKeymap(keymap)
+		if err != nil {
+			slog.Error("apply key bindings", "err", err)
+			return
+		}
+
+		slog.Info("reloaded config", "path", path)
+	})
+}
+
+type configParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func parseConfig(data string) (config, error) {
+	parser := &configParser{data: data, line: 1}
+
+	cfg, err := parser.parse()
+	if err != nil {
+		return config{}, fmt.Errorf("line %d: %w", parser.line, err)
+	}
+
+	return cfg, nil
+}
+
+func (parser *configParser) parse() (config, error) {
+	cfg := config{defaults: configValues{}, profiles: map[string]configValues{}}
+	table := cfg.defaults
+
+	for {
+		parser.skipSpace(true)
+		if parser.pos >= len(parser.data) {
+			return cfg, nil
+		}
+
+		if parser.data[parser.pos] == '[' {
+			end := strings.IndexAny(parser.data[parser.pos:], "]\n")
+			if end < 0 || parser.data[parser.pos+end] != ']' {
+				return config{}, fmt.Errorf("unterminated table header")
+			}
+
+			header := strings.TrimSpace(parser.data[parser.pos+1 : parser.pos+end])
+			parser.pos += end + 1
+
+			name, ok := strings.CutPrefix(header, "profiles.")
+			if !ok || name == "" {
+				return config{}, fmt.Errorf("unknown table %q, expected [profiles.<name>]", header)
+			}
+
+			if _, ok := cfg.profiles[name]; ok {
+				return config{}, fmt.Errorf("profile %q is defined twice", name)
+			}
+
+			table = configValues{}
+			cfg.profiles[name] = table
+		} else {
+			key, values, err := parser.parseKeyValue()
+			if err != nil {
+				return config{}, err
+			}
+
+			// on_show works as well as on-show, like most toml files write
+			// keys, but it is the same option
+			key = strings.ReplaceAll(key, "_", "-")
+
+			if _, ok := table[key]; ok {
+				return config{}, fmt.Errorf("%q is set twice", key)
+			}
+
+			table[key] = values
+		}
+
+		parser.skipSpace(false)
+		if parser.pos < len(parser.data) && parser.data[parser.pos] != '\n' {
+			return config{}, fmt.Errorf("unexpected %q at the end of the line", parser.data[parser.pos])
+		}
+	}
+}
+
+// skipSpace skips blanks and comments, and line breaks if newlines is set.
+func (parser *configParser) skipSpace(newlines bool) {
+	for parser.pos < len(parser.data) {
+		switch c := parser.data[parser.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			parser.pos++
+		case c == '\n' && newlines:
+			parser.pos++
+			parser.line++
+		case c == '#':
+			for parser.pos < len(parser.data) && parser.data[parser.pos] != '\n' {
+				parser.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (parser *configParser) parseKeyValue() (string, []string, error) {
+	start := parser.pos
+	for parser.pos < len(parser.data) && isKeyChar(parser.data[parser.pos]) {
+		parser.pos++
+	}
+
+	key := parser.data[start:parser.pos]
+	if key == "" {
+		return "", nil, fmt.Errorf("expected a key")
+	}
+
+	parser.skipSpace(false)
+	if parser.pos >= len(parser.data) || parser.data[parser.pos] != '=' {
+		return "", nil, fmt.Errorf("expected = after %q", key)
+	}
+
+	parser.pos++
+	parser.skipSpace(false)
+
+	if parser.pos < len(parser.data) && parser.data[parser.pos] == '[' {
+		values, err := parser.parseArray()
+		return key, values, err
+	}
+
+	value, err := parser.parseValue()
+
+	return key, []string{value}, err
+}
+
+func isKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+func (parser *configParser) parseArray() ([]string, error) {
+	// [
+	parser.pos++
+
+	values := []string{}
+	for {
+		parser.skipSpace(true)
+		if parser.pos >= len(parser.data) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+
+		if parser.data[parser.pos] == ']' {
+			parser.pos++
+			return values, nil
+		}
+
+		value, err := parser.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+
+		parser.skipSpace(true)
+		if parser.pos < len(parser.data) && parser.data[parser.pos] == ',' {
+			parser.pos++
+		}
+	}
+}
+
+// parseValue parses a string, boolean or number and returns it the way it
+// is given on the command line.
+func (parser *configParser) parseValue() (string, error) {
+	if parser.pos >= len(parser.data) {
+		return "", fmt.Errorf("expected a value")
+	}
+
+	switch parser.data[parser.pos] {
+	case '"':
+		return parser.parseString()
+	case '\'':
+		end := strings.IndexAny(parser.data[parser.pos+1:], "'\n")
+		if end < 0 || parser.data[parser.pos+1+end] != '\'' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		value := parser.data[parser.pos+1 : parser.pos+1+end]
+		parser.pos += end + 2
+
+		return value, nil
+	case '[':
+		return "", fmt.Errorf("nested arrays are not supported")
+	}
+
+	start := parser.pos
+	for parser.pos < len(parser.data) && !strings.ContainsRune(" \t\r\n,]#", rune(parser.data[parser.pos])) {
+		parser.pos++
+	}
+
+	value := parser.data[start:parser.pos]
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return "", fmt.Errorf("invalid value %q, strings have to be quoted", value)
+	}
+
+	return value, nil
+}
+
+func (parser *configParser) parseString() (string, error) {
+	var value strings.Builder
+
+	// "
+	parser.pos++
+
+	for parser.pos < len(parser.data) {
+		c := parser.data[parser.pos]
+		parser.pos++
+
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\n':
+			return "", fmt.Errorf("unterminated string")
+		case '\\':
+			if parser.pos >= len(parser.data) {
+				return "", fmt.Errorf("unterminated string")
+			}
+
+			escaped := parser.data[parser.pos]
+			parser.pos++
+
+			switch escaped {
+			case '"', '\\':
+				value.WriteByte(escaped)
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				return "", fmt.Errorf("unsupported escape \\%c", escaped)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string")
+}
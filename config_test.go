@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		defaults configValues
+		profiles map[string]configValues
+	}{
+		{
+			name:     "empty",
+			data:     "",
+			defaults: configValues{},
+		},
+		{
+			name:     "values",
+			data:     "opacity = 0.4\nclick-through = true\nscale = \"fill\"\n",
+			defaults: configValues{"opacity": {"0.4"}, "click-through": {"true"}, "scale": {"fill"}},
+		},
+		{
+			name:     "underscores",
+			data:     "on_show = 'notify-send shown'",
+			defaults: configValues{"on-show": {"notify-send shown"}},
+		},
+		{
+			name:     "escapes",
+			data:     `label = "a \"b\"\tc\\d\ne"`,
+			defaults: configValues{"label": {"a \"b\"\tc\\d\ne"}},
+		},
+		{
+			name:     "literal string",
+			data:     `font = 'C:\fonts\a "b".ttf'`,
+			defaults: configValues{"font": {`C:\fonts\a "b".ttf`}},
+		},
+		{
+			name:     "comments",
+			data:     "# the overlay\nopacity = 0.4 # mostly transparent\nlabel = \"a # b\"\n  # indented\n",
+			defaults: configValues{"opacity": {"0.4"}, "label": {"a # b"}},
+		},
+		{
+			name:     "arrays",
+			data:     "bind = [\"ctrl+q=quit\", 'f=none']\nlabel = []\n",
+			defaults: configValues{"bind": {"ctrl+q=quit", "f=none"}, "label": {}},
+		},
+		{
+			name:     "multi-line array",
+			data:     "bind = [\n  \"ctrl+q=quit\", # quit\n\n  \"f=none\",\n]\nopacity = 1\n",
+			defaults: configValues{"bind": {"ctrl+q=quit", "f=none"}, "opacity": {"1"}},
+		},
+		{
+			name:     "profiles",
+			data:     "opacity = 0.4\n\n[profiles.review]\nopacity = 1\n[ profiles.dark ]\nscale = \"fill\"\n",
+			defaults: configValues{"opacity": {"0.4"}},
+			profiles: map[string]configValues{"review": {"opacity": {"1"}}, "dark": {"scale": {"fill"}}},
+		},
+		{
+			name:     "the same key in a profile",
+			data:     "opacity = 0.4\n[profiles.review]\nopacity = 1\n",
+			defaults: configValues{"opacity": {"0.4"}},
+			profiles: map[string]configValues{"review": {"opacity": {"1"}}},
+		},
+		{
+			name:     "windows line breaks",
+			data:     "opacity = 0.4\r\nbind = [\r\n\"f=none\"\r\n]\r\n",
+			defaults: configValues{"opacity": {"0.4"}, "bind": {"f=none"}},
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := parseConfig(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		profiles := test.profiles
+		if profiles == nil {
+			profiles = map[string]configValues{}
+		}
+
+		if !reflect.DeepEqual(cfg.defaults, test.defaults) || !reflect.DeepEqual(cfg.profiles, profiles) {
+			t.Errorf("%s: %v and %v, want %v and %v", test.name, cfg.defaults, cfg.profiles, test.defaults, profiles)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"duplicate key", "opacity = 0.4\nscale = \"fill\"\nopacity = 1\n", `line 3: "opacity" is set twice`},
+		{"duplicate key with underscores", "on-show = \"a\"\non_show = \"b\"\n", `line 2: "on-show" is set twice`},
+		{"duplicate key in a profile", "[profiles.a]\nopacity = 1\nopacity = 2\n", `line 3: "opacity" is set twice`},
+		{"duplicate profile", "[profiles.a]\nopacity = 1\n\n[profiles.a]\n", `line 4: profile "a" is defined twice`},
+		{"unknown table", "[colors]\n", `line 1: unknown table "colors"`},
+		{"profile without a name", "[profiles.]\n", `line 1: unknown table "profiles."`},
+		{"unterminated table", "[profiles.a\nopacity = 1\n", "line 1: unterminated table header"},
+		{"unquoted string", "\nscale = fill\n", `line 2: invalid value "fill", strings have to be quoted`},
+		{"unterminated string", "label = \"abc\nopacity = 1\n", "line 1: unterminated string"},
+		{"unterminated literal string", "label = 'abc\n", "line 1: unterminated string"},
+		{"unsupported escape", "\n\nlabel = \"a\\qb\"\n", `line 3: unsupported escape \q`},
+		{"missing =", "opacity 0.4\n", `line 1: expected = after "opacity"`},
+		{"missing key", "= 0.4\n", "line 1: expected a key"},
+		{"missing value", "opacity =", "line 1: expected a value"},
+		{"two values", "opacity = 0.4 0.5\n", `line 1: unexpected '0' at the end of the line`},
+		{"error in a multi-line array", "bind = [\n  \"a\",\n  b,\n]\n", `line 3: invalid value "b"`},
+		{"unterminated array", "bind = [\n  \"a\",\n", "line 3: unterminated array"},
+		{"nested array", "bind = [[\"a\"]]\n", "line 1: nested arrays are not supported"},
+	}
+
+	for _, test := range tests {
+		_, err := parseConfig(test.data)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: err %v, want %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+)
+
+func newCtlCommand() *cobra.Command {
+	pid := 0
+	all := false
+	window := 0
+
+	cmd := &cobra.Command{
+		Use:   "ctl <command> [args...]",
+		Short: "control a running overlay",
+		Long: `Send a command to a running overlay over its control socket.
+
+Commands:
+  opacity <value>         set the opacity, prefix with + or - to change it relatively
+  move <x> <y>            move the window
+  resize <width> <height> resize the window
+  image <path>            show another image
+  next, previous          cycle through the images given on the command line
+  show, hide, toggle      change the visibility of the window
+  sticky, fullscreen      toggle showing the window on all desktops or filling the monitor
+  raise                   put the window above the other windows
+  split <position>        move the divider of --split, from 0 to 1
+  add <path> [geometry]   show another image in a new window of the same overlay
+  join <group>, leave     share opacity and visibility changes with the overlays of a group
+  record [path]           record the images of a live source, to --record-dir without a path
+  stop-recording          finish the recording
+  annotate <annotation>   draw "text <position> <text>", "box x,y,w,h[,color]" or "line x1,y1,x2,y2[,color]"
+  clear-annotations       remove all annotations
+  notify <message>        show a message at the top of the window for a few seconds
+  undo, redo              undo the last change of the opacity, position, transforms or annotations, or do it again
+  screenshot [path]       save the frame the overlay shows as png, to stdout with -
+  screenshot-blended [path]
+                          save it over the screen below the overlay
+  snapshot [path]         save the next frame the overlay composites as png, as it was put into the window
+  state                   print the state of the overlay as JSON
+  quit                    close the overlay
+
+An overlay can show several windows, see --at and add. Commands go to the
+first one unless --window gives the id of another, as printed by state.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			request, err := parseControlArgs(args)
+			if err != nil {
+				return err
+			}
+
+			request.Window = window
+
+			paths, err := controlTargets(pid, all)
+			if err != nil {
+				return err
+			}
+
+			// the overlay writes screenshots for stdout to a file we copy
+			// from, in the socket directory that it can write to even in
+			// its sandbox
+			toStdout := (request.Command == "screenshot" || request.Command == "snapshot") && request.Path == "-"
+			if toStdout {
+				file, err := os.CreateTemp(overlay.ControlSocketDir(), "screenshot-*.png")
+				if err != nil {
+					return fmt.Errorf("create screenshot: %w", err)
+				}
+				file.Close()
+				defer os.Remove(file.Name())
+
+				request.Path = file.Name()
+			}
+
+			for _, path := range paths {
+				response, err := overlay.SendControl(path, request)
+				if err != nil {
+					return fmt.Errorf("send command to %s: %w", path, err)
+				}
+
+				if !response.OK {
+					return fmt.Errorf("%s: %s", path, response.Error)
+				}
+
+				if response.State != nil {
+					err = json.NewEncoder(os.Stdout).Encode(response.State)
+					if err != nil {
+						return fmt.Errorf("print state: %w", err)
+					}
+				}
+
+				if toStdout {
+					err = copyScreenshot(request.Path)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&pid, "pid", 0, "pid of the overlay to control")
+	flags.BoolVar(&all, "all", false, "send the command to all running overlays")
+	flags.IntVar(&window, "window", 0, "id of the window of the overlay to control (default the first)")
+
+	return cmd
+}
+
+func copyScreenshot(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open screenshot: %w", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(os.Stdout, file)
+	if err != nil {
+		return fmt.Errorf("copy screenshot: %w", err)
+	}
+
+	return nil
+}
+
+func controlTargets(pid int, all bool) ([]string, error) {
+	if pid != 0 {
+		err := overlay.CheckControlSocketDir()
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{overlay.ControlSocketPath(pid)}, nil
+	}
+
+	paths, err := overlay.ControlSockets()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(paths) == 0:
+		return nil, fmt.Errorf("no running overlay found in %s", overlay.ControlSocketDir())
+	case len(paths) > 1 && !all:
+		return nil, fmt.Errorf("%d overlays are running, use --pid or --all", len(paths))
+	}
+
+	return paths, nil
+}
+
+func parseControlArgs(args []string) (overlay.ControlRequest, error) {
+	request := overlay.ControlRequest{Command: args[0]}
+	params := args[1:]
+
+	expect := func(n int) error {
+		if len(params) != n {
+			return fmt.Errorf("%s: expected %d arguments, got %d", request.Command, n, len(params))
+		}
+
+		return nil
+	}
+
+	parseInts := func() (int, int, error) {
+		a, err := strconv.Atoi(params[0])
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", request.Command, err)
+		}
+
+		b, err := strconv.Atoi(params[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", request.Command, err)
+		}
+
+		return a, b, nil
+	}
+
+	switch request.Command {
+	case "opacity":
+		if err := expect(1); err != nil {
+			return request, err
+		}
+
+		opacity, err := strconv.ParseFloat(params[0], 64)
+		if err != nil {
+			return request, fmt.Errorf("opacity: %w", err)
+		}
+
+		request.Opacity = &opacity
+		request.Relative = strings.HasPrefix(params[0], "+") || strings.HasPrefix(params[0], "-")
+	case "move":
+		if err := expect(2); err != nil {
+			return request, err
+		}
+
+		x, y, err := parseInts()
+		if err != nil {
+			return request, err
+		}
+
+		request.X = &x
+		request.Y = &y
+	case "resize":
+		if err := expect(2); err != nil {
+			return request, err
+		}
+
+		width, height, err := parseInts()
+		if err != nil {
+			return request, err
+		}
+
+		request.Width = &width
+		request.Height = &height
+	case "image":
+		if err := expect(1); err != nil {
+			return request, err
+		}
+
+		// the overlay most likely runs in a different working directory
+		path, err := filepath.Abs(params[0])
+		if err != nil {
+			return request, fmt.Errorf("image: %w", err)
+		}
+
+		request.Path = path
+	case "split":
+		if err := expect(1); err != nil {
+			return request, err
+		}
+
+		position, err := strconv.ParseFloat(params[0], 64)
+		if err != nil {
+			return request, fmt.Errorf("split: %w", err)
+		}
+
+		request.Position = &position
+	case "add":
+		if len(params) != 1 && len(params) != 2 {
+			return request, fmt.Errorf("add: expected a path and an optional geometry")
+		}
+
+		path, err := filepath.Abs(params[0])
+		if err != nil {
+			return request, fmt.Errorf("add: %w", err)
+		}
+
+		request.Path = path
+
+		if len(params) == 2 {
+			request.Geometry = params[1]
+		}
+	case "join":
+		if err := expect(1); err != nil {
+			return request, err
+		}
+
+		request.Group = params[0]
+	case "record":
+		if len(params) > 1 {
+			return request, fmt.Errorf("record: expected an optional path")
+		}
+
+		if len(params) == 1 {
+			path, err := filepath.Abs(params[0])
+			if err != nil {
+				return request, fmt.Errorf("record: %w", err)
+			}
+
+			request.Path = path
+		}
+	case "screenshot", "screenshot-blended", "snapshot":
+		if request.Command != "snapshot" {
+			request.Command = "screenshot"
+			request.Blended = args[0] == "screenshot-blended"
+		}
+
+		if len(params) > 1 {
+			return request, fmt.Errorf("%s: expected an optional path", args[0])
+		}
+
+		// - copies the screenshot to stdout
+		if len(params) == 1 {
+			request.Path = params[0]
+		}
+
+		if request.Path != "" && request.Path != "-" {
+			path, err := filepath.Abs(request.Path)
+			if err != nil {
+				return request, fmt.Errorf("%s: %w", args[0], err)
+			}
+
+			request.Path = path
+		}
+	case "annotate":
+		if len(params) < 2 {
+			return request, fmt.Errorf("annotate: expected text, box or line and what to draw")
+		}
+
+		request.Annotation = strings.Join(params, " ")
+	case "notify":
+		if len(params) == 0 {
+			return request, fmt.Errorf("notify: expected a message")
+		}
+
+		request.Message = strings.Join(params, " ")
+	default:
+		if err := expect(0); err != nil {
+			return request, err
+		}
+	}
+
+	return request, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+//go:embed icon.svg
+var icon []byte
+
+const desktopFileName = "xoverlay.desktop"
+
+// the types we can show, plus our own url scheme
+var desktopMimeTypes = []string{
+	"image/png",
+	"image/apng",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/svg+xml",
+	"x-scheme-handler/xoverlay",
+}
+
+func newInstallDesktopCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "install-desktop",
+		Short: "install a desktop entry and icon so that file managers can open images with xoverlay",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("find executable: %w", err)
+			}
+
+			dataDir, err := xdgDir("XDG_DATA_HOME", ".local/share")
+			if err != nil {
+				return err
+			}
+
+			configDir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+			if err != nil {
+				return err
+			}
+
+			iconPath := filepath.Join(dataDir, "icons", "hicolor", "scalable", "apps", "xoverlay.svg")
+			err = writeFile(iconPath, icon)
+			if err != nil {
+				return fmt.Errorf("install icon: %w", err)
+			}
+
+			applicationsDir := filepath.Join(dataDir, "applications")
+			err = writeFile(filepath.Join(applicationsDir, desktopFileName), []byte(desktopEntry(executable)))
+			if err != nil {
+				return fmt.Errorf("install desktop entry: %w", err)
+			}
+
+			err = registerMimeTypes(filepath.Join(configDir, "mimeapps.list"))
+			if err != nil {
+				return fmt.Errorf("register mime types: %w", err)
+			}
+
+			// the caches are only there to speed up lookups, not having
+			// the tools is fine
+			_ = exec.Command("update-desktop-database", applicationsDir).Run()
+			_ = exec.Command("gtk-update-icon-cache", "--ignore-theme-index", filepath.Join(dataDir, "icons", "hicolor")).Run()
+
+			fmt.Println("installed", filepath.Join(applicationsDir, desktopFileName))
+
+			return nil
+		},
+	}
+}
+
+func xdgDir(env string, fallback string) (string, error) {
+	if dir := os.Getenv(env); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("find home directory: %w", err)
+	}
+
+	return filepath.Join(home, fallback), nil
+}
+
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	return nil
+}
+
+func desktopEntry(executable string) string {
+	// the exec key needs reserved characters quoted and backslashes doubled
+	quoted := `"` + strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", "$", `\\$`).Replace(executable) + `"`
+
+	return strings.Join([]string{
+		"[Desktop Entry]",
+		"Type=Application",
+		"Name=xoverlay",
+		"GenericName=Image Overlay",
+		"Comment=Show an image as a transparent overlay window",
+		// uris so that xoverlay:// links reach us as well
+		"Exec=" + quoted + " %U",
+		"Icon=xoverlay",
+		"Terminal=false",
+		"Categories=Graphics;Viewer;Development;",
+		"MimeType=" + strings.Join(desktopMimeTypes, ";") + ";",
+		"",
+	}, "\n")
+}
+
+// registerMimeTypes adds xoverlay to the applications that can open images
+// and makes it the handler of the xoverlay:// scheme in a mimeapps.list
+// file, keeping everything else in it.
+func registerMimeTypes(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	list := parseMimeApps(string(content))
+
+	for _, mimeType := range desktopMimeTypes {
+		if strings.HasPrefix(mimeType, "x-scheme-handler/") {
+			list.set("Default Applications", mimeType, desktopFileName+";")
+			continue
+		}
+
+		list.add("Added Associations", mimeType, desktopFileName)
+	}
+
+	return writeFile(path, []byte(list.String()))
+}
+
+// mimeApps is a minimal editor for mimeapps.list files that keeps the order
+// and all lines it doesn't touch.
+type mimeApps struct {
+	lines []string
+}
+
+func parseMimeApps(content string) *mimeApps {
+	content = strings.TrimRight(content, "\n")
+	if content == "" {
+		return &mimeApps{}
+	}
+
+	return &mimeApps{lines: strings.Split(content, "\n")}
+}
+
+// find returns the index of the line of key in section, or the index to
+// insert it at and false.
+func (list *mimeApps) find(section string, key string) (int, bool) {
+	header := "[" + section + "]"
+	inSection := false
+	end := -1
+
+	for i, line := range list.lines {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") {
+			inSection = trimmed == header
+			if inSection {
+				end = i + 1
+			}
+
+			continue
+		}
+
+		if !inSection || trimmed == "" {
+			continue
+		}
+
+		// new keys go after the last entry, not after trailing blank lines
+		end = i + 1
+
+		name, _, ok := strings.Cut(trimmed, "=")
+		if ok && strings.TrimSpace(name) == key {
+			return i, true
+		}
+	}
+
+	if end >= 0 {
+		return end, false
+	}
+
+	if len(list.lines) > 0 {
+		list.lines = append(list.lines, "")
+	}
+	list.lines = append(list.lines, header)
+
+	return len(list.lines), false
+}
+
+func (list *mimeApps) set(section string, key string, value string) {
+	i, ok := list.find(section, key)
+	if ok {
+		list.lines[i] = key + "=" + value
+		return
+	}
+
+	list.lines = slices.Insert(list.lines, i, key+"="+value)
+}
+
+// add appends value to the list of desktop files of key if it isn't in it
+// already.
+func (list *mimeApps) add(section string, key string, value string) {
+	i, ok := list.find(section, key)
+	if !ok {
+		list.lines = slices.Insert(list.lines, i, key+"="+value+";")
+		return
+	}
+
+	_, values, _ := strings.Cut(list.lines[i], "=")
+	if slices.Contains(strings.Split(values, ";"), value) {
+		return
+	}
+
+	if values != "" && !strings.HasSuffix(values, ";") {
+		values += ";"
+	}
+
+	list.lines[i] = key + "=" + values + value + ";"
+}
+
+func (list *mimeApps) String() string {
+	return strings.Join(list.lines, "\n") + "\n"
+}
@@ -1,15 +1,19 @@
-module xoverlay
+module github.com/merlinzerbe/xoverlay
 
 go 1.24.0
 
 require (
 	github.com/jezek/xgb v1.1.1
 	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20210519020934-456a8d69b780
 	golang.org/x/image v0.28.0
 	golang.org/x/sys v0.33.0
+	golang.org/x/text v0.26.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
+	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4 // indirect
 )
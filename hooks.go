@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+)
+
+// hooks are shell commands that are run when the overlay is shown or
+// hidden, shows another image or is clicked, to integrate it with other
+// tools. What happened is in the environment:
+//
+//	XOVERLAY_EVENT   show, hide, image-change or click
+//	XOVERLAY_IMAGE   the image shown, if it came from a file
+//	XOVERLAY_PID     the pid of the overlay, for ctl --pid
+//	XOVERLAY_X       for clicks, the position in the window
+//	XOVERLAY_Y
+//	XOVERLAY_BUTTON  1 for the left button, 2 the middle and 3 the right one
+type hooks struct {
+	show        string
+	hide        string
+	imageChange string
+	click       string
+}
+
+func (h hooks) enabled() bool {
+	return h.show != "" || h.hide != "" || h.imageChange != "" || h.click != ""
+}
+
+// run runs the hooks for the events of display until it is closed. Hooks
+// run in the background, a slow one doesn't hold up the next.
+func (h hooks) run(display *overlay.Window) {
+	image := ""
+
+	for event := range display.Events() {
+		var name, command string
+		var env []string
+
+		switch event.Kind {
+		case overlay.EventShown:
+			name, command = "show", h.show
+		case overlay.EventHidden:
+			name, command = "hide", h.hide
+		case overlay.EventImage:
+			image = event.Source
+			name, command = "image-change", h.imageChange
+		case overlay.EventClick:
+			name, command = "click", h.click
+			env = []string{
+				"XOVERLAY_X=" + strconv.Itoa(event.X),
+				"XOVERLAY_Y=" + strconv.Itoa(event.Y),
+				"XOVERLAY_BUTTON=" + strconv.Itoa(event.Button),
+			}
+		}
+
+		if command == "" {
+			continue
+		}
+
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"XOVERLAY_EVENT="+name,
+			"XOVERLAY_IMAGE="+image,
+			"XOVERLAY_PID="+strconv.Itoa(os.Getpid()),
+		)
+		cmd.Env = append(cmd.Env, env...)
+
+		err := cmd.Start()
+		if err != nil {
+			slog.Error("run hook", "event", name, "err", err)
+			continue
+		}
+
+		go func() {
+			err := cmd.Wait()
+			if err != nil {
+				slog.Error("run hook", "event", name, "err", err)
+			}
+		}()
+	}
+}
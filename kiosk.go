@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// how long a kiosk waits before it starts again after an error, e.g. for
+// the X server to come back
+const kioskRestartDelay = 5 * time.Second
+
+// kioskCommand returns the command line the overlay was started with, to
+// start it again.
+func kioskCommand() ([]string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	return append([]string{executable}, os.Args[1:]...), nil
+}
+
+// restartKiosk replaces the process with a new overlay started with args,
+// a while after it failed with err. It only returns if that fails.
+func restartKiosk(args []string, err error) error {
+	slog.Error("kiosk failed, starting again", "in", kioskRestartDelay, "err", err)
+
+	time.Sleep(kioskRestartDelay)
+
+	err = syscall.Exec(args[0], args, os.Environ())
+	if err != nil {
+		return fmt.Errorf("restart kiosk: %w", err)
+	}
+
+	return nil
+}
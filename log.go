@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// setupLogging sends what the overlay logs to the file at path, or stderr if
+// it is empty, leaving out what is less important than levelName.
+func setupLogging(levelName string, path string) error {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(levelName))
+	if err != nil {
+		return fmt.Errorf("parse --log-level: unknown log level %q, expected debug, info, warn or error", levelName)
+	}
+
+	var w io.Writer = os.Stderr
+	if path != "" {
+		// kept open until the overlay exits, also within the sandbox
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+
+		w = file
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+
+	return nil
+}
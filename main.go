@@ -1,506 +1,1314 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	_ "image/jpeg"
-	_ "image/png"
-	"io"
+	"log/slog"
 	"os"
-	"sync"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/jezek/xgb"
-	"github.com/jezek/xgb/shm"
-	"github.com/jezek/xgb/xproto"
+	"github.com/merlinzerbe/xoverlay/overlay"
 	"github.com/spf13/cobra"
-	"golang.org/x/image/draw"
-	_ "golang.org/x/image/webp"
-	"golang.org/x/sys/unix"
+	"golang.org/x/image/font"
 )
 
+// exit codes, so that scripts can tell why the overlay failed
 const (
-	DepthWithAlpha = 32
-	ClassTrueColor = 4
+	exitError     = 1
+	exitNoDisplay = 3
+	exitNoVisual  = 4
+	exitBadImage  = 5
+	exitShm       = 6
 )
 
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
 }
 
-func MatchVisualInfo(depthInfos []xproto.DepthInfo, depth byte, class byte) *xproto.VisualInfo {
-	for _, depthInfo := range depthInfos {
-		if depthInfo.Depth != depth {
-			continue
-		}
-
-		for _, visual := range depthInfo.Visuals {
-			if visual.Class == class {
-				return &visual
-			}
-		}
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, overlay.ErrNoDisplay):
+		return exitNoDisplay
+	case errors.Is(err, overlay.ErrNoVisual):
+		return exitNoVisual
+	case errors.Is(err, overlay.ErrImage):
+		return exitBadImage
+	case errors.Is(err, overlay.ErrShm):
+		return exitShm
 	}
 
-	return nil
+	return exitError
 }
 
-type ImageWindow struct {
-	// X resources
-	conn          *xgb.Conn
-	screen        *xproto.ScreenInfo
-	windowID      xproto.Window
-	transparentGc xproto.Gcontext
-	imageGc       xproto.Gcontext
-
-	// the image we want to render
-	image image.Image
-
-	// bookkeeping for debounced rendering
-	imageOpacity   float64
-	windowWidth    int
-	windowHeight   int
-	nextRedraw     time.Time
-	dirty          bool
-	renderMu       sync.Mutex
-	wg             sync.WaitGroup
-	cancelRenderer context.CancelFunc
-}
+func run() error {
+	initialOpacity := 0.0
+	noAnimation := false
+	bindings := []string{}
+	nudgeStep := 0
+	opacityStep := 0.0
+	opacityMin := 0.0
+	opacityMax := 0.0
+	socketPath := ""
+	noSocket := false
+	profile := ""
+	geometryString := ""
+	var at []string
+	windowX := 0
+	windowY := 0
+	windowWidth := 0
+	windowHeight := 0
+	above := false
+	below := false
+	sticky := false
+	fullscreen := false
+	layer := ""
+	lockSize := false
+	lockAspect := false
+	check := false
+	runtimeDeps := false
+	overrideRedirect := false
+	noDecorations := false
+	renderThreads := 0
+	niceness := 0
+	idlePriority := false
+	maxCPUPercent := 0.0
+	maxRSSMB := 0
+	watch := false
+	remote := false
+	xrender := false
+	colorBits := 0
+	quirkList := ""
+	scaleName := ""
+	alignName := ""
+	filterName := ""
+	blendName := ""
+	diffGain := 0.0
+	diffChannelName := ""
+	diffBlink := time.Duration(0)
+	transparencyName := ""
+	splitName := ""
+	toggleKey := ""
+	presenterKeys := false
+	privacyKey := ""
+	var privacyZones []string
+	pixelate := 0
+	layoutName := ""
+	gap := 0
+	labels := false
+	var redactRegions []string
+	var contrastZones []string
+	webhookAddress := ""
+	group := ""
+	httpAddress := ""
+	broadcastAddress := ""
+	receiveAddress := ""
+	var network accessFlags
+	fade := time.Duration(0)
+	fadeIn := time.Duration(0)
+	mirrorWindow := ""
+	sequence := ""
+	fps := float64(overlay.DefaultFPS)
+	magnify := 0.0
+	magnifyGrid := false
+	crosshair := false
+	stdinRaw := ""
+	sourcePlugin := ""
+	clipboard := ""
+	figmaNode := ""
+	figmaToken := ""
+	figmaInterval := time.Duration(0)
+	remoteURL := ""
+	pollInterval := time.Duration(0)
+	presign := ""
+	sourceSpec := ""
+	sourceInterval := time.Duration(0)
+	var auth authFlags
+	staleAfter := time.Duration(0)
+	staleStyleName := string(overlay.StaleBadge)
+	history := 0
+	recordDir := ""
+	recordFormatName := string(overlay.RecordAPNG)
+	screenshot := ""
+	var effectPlugins []string
+	followWindow := ""
+	outputName := ""
+	showInfo := false
+	rotate := 0
+	flipH := false
+	flipV := false
+	cvdName := ""
+	invert := false
+	grayscale := false
+	channelName := ""
+	threshold := 0
+	outlineName := ""
+	blueprintName := ""
+	outlineThreshold := 0
+	autoTrim := false
+	cropString := ""
+	cornersString := ""
+	rememberCorners := false
+	restore := true
+	logLevel := ""
+	kiosk := false
+	allowBlanking := false
+	idle := time.Duration(0)
+	solid := ""
+	var kioskArgs []string
+	watchdog := ""
+	watchdogInterval := time.Duration(0)
+	logFile := ""
+	sandbox := false
+	var eventHooks hooks
+	grid := 0
+	guidesString := ""
+	text := ""
+	fontPath := ""
+	fontSize := float64(overlay.DefaultFontSize)
+	textPosition := "top-left"
+	textColorName := "white"
+	tick := time.Duration(0)
+	var boxes []string
+	var lines []string
+	ruler := false
+	picker := false
+	fullscreenOutput := ""
+	slideshowInterval := time.Duration(0)
+	crossfade := time.Duration(0)
+	once := false
+	playlistPath := ""
+	random := false
+	seed := uint64(0)
+	sortOrderName := string(sortName)
+	matchPattern := ""
 
-func (imageWindow *ImageWindow) setupX() error {
-	conn, err := xgb.NewConn()
-	if err != nil {
-		return fmt.Errorf("new conn: %w", err)
-	}
+	cmd := &cobra.Command{
+		Use:           "xoverlay <file> [files...]",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args: func(_ *cobra.Command, args []string) error {
+			if runtimeDeps {
+				return nil
+			}
 
-	imageWindow.conn = conn
+			// the options that show something else than image files
+			var sources []string
+			for _, source := range []struct {
+				flag string
+				set  bool
+			}{
+				{"--window", mirrorWindow != ""},
+				{"--stdin-raw", stdinRaw != ""},
+				{"--receive", receiveAddress != ""},
+				{"--source-plugin", sourcePlugin != ""},
+				{"--clipboard", clipboard != ""},
+				{"--figma", figmaNode != ""},
+				{"--url", remoteURL != "" || presign != ""},
+				{"--source", sourceSpec != ""},
+				{"--sequence", sequence != ""},
+				{"--magnify", magnify != 0},
+				{"--solid", solid != ""},
+			} {
+				if source.set {
+					sources = append(sources, source.flag)
+				}
+			}
 
-	setup := xproto.Setup(conn)
-	screen := setup.DefaultScreen(conn)
-	imageWindow.screen = screen
+			switch {
+			case len(sources) > 1:
+				return fmt.Errorf("%s can't be combined", strings.Join(sources, " and "))
+			case len(sources) == 1 && (len(args) > 0 || playlistPath != ""):
+				return fmt.Errorf("%s shows something else instead of images", sources[0])
+			case playlistPath != "" && len(args) > 0:
+				return fmt.Errorf("--playlist lists the images, they can't be given too")
+			case len(sources) == 0 && len(args) == 0 && playlistPath == "":
+				return fmt.Errorf("expected at least one image")
+			}
 
-	err = shm.Init(conn)
-	if err != nil {
-		return fmt.Errorf("init shm: %w", err)
-	}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runtimeDeps {
+				return printRuntimeDeps(os.Stdout)
+			}
 
-	return nil
-}
+			// bindings of the command line stay when the config changes
+			var commandLineBindings []string
+			if cmd.Flags().Changed("bind") {
+				commandLineBindings = slices.Clone(bindings)
+			}
 
-func (imageWindow *ImageWindow) loadImage(imageBytes []byte) error {
-	img, _, err := image.Decode(bytes.NewReader(imageBytes))
-	if err != nil {
-		return fmt.Errorf("decode image: %w", err)
-	}
+			// options of the command line win over the config file
+			err := applyConfig(cmd.Flags(), profile)
+			if err != nil {
+				return err
+			}
+
+			err = setupLogging(logLevel, logFile)
+			if err != nil {
+				return err
+			}
 
-	imageWindow.image = img
-	imageWindow.windowWidth = img.Bounds().Dx()
-	imageWindow.windowHeight = img.Bounds().Dy()
+			// a signage player fills the monitor, keeps playing and comes
+			// back after errors, nothing but the control socket and signals
+			// change it
+			if kiosk {
+				if once {
+					return fmt.Errorf("--kiosk plays the images over and over, it can't be combined with --once")
+				}
 
-	return nil
-}
+				if sandbox {
+					return fmt.Errorf("--kiosk starts the overlay again after errors, which --sandbox forbids")
+				}
 
-func NewImageWindow(
-	initialOpacity float64,
-	imageBytes []byte,
-) (*ImageWindow, error) {
-	imageWindow := &ImageWindow{
-		imageOpacity: initialOpacity,
-	}
+				if slices.Contains(args, "-") {
+					return fmt.Errorf("--kiosk starts the overlay again after errors, stdin can only be read once")
+				}
 
-	err := imageWindow.loadImage(imageBytes)
-	if err != nil {
-		return nil, fmt.Errorf("load image: %w", err)
-	}
+				fullscreen = fullscreenOutput == ""
+				above = !below
+			}
 
-	err = imageWindow.setupX()
-	if err != nil {
-		return nil, fmt.Errorf("setup x: %w", err)
-	}
+			if watchdogInterval <= 0 {
+				return fmt.Errorf("--watchdog-interval has to be positive")
+			}
 
-	rendererCtx, cancel := context.WithCancel(context.Background())
-	imageWindow.cancelRenderer = cancel
+			opacityGiven := cmd.Flags().Changed("opacity")
 
-	go imageWindow.startRenderer(rendererCtx)
+			// links open the image in the running overlay, or in a new one
+			// if there is none
+			if len(args) == 1 && isOverlayURI(args[0]) {
+				uri, err := parseOverlayURI(args[0])
+				if err != nil {
+					return err
+				}
 
-	return imageWindow, nil
-}
+				forwarded, err := forwardURI(uri)
+				if forwarded || err != nil {
+					return err
+				}
 
-func (display *ImageWindow) requestRedraw() {
-	display.renderMu.Lock()
-	display.dirty = true
-	display.nextRedraw = time.Now().Add(50 * time.Millisecond)
-	display.renderMu.Unlock()
-}
+				args = []string{uri.file}
+				if uri.opacity != nil {
+					initialOpacity = *uri.opacity
+					opacityGiven = true
+				}
+			}
+
+			// an image on a web server is downloaded like with --url, but
+			// only polled with --poll
+			if slices.ContainsFunc(args, isImageURL) {
+				if len(args) > 1 {
+					return fmt.Errorf("a url can only be shown on its own, not along with other images")
+				}
+
+				// set as flags, so a restarted session shows it again
+				err := cmd.Flags().Set("url", args[0])
+				if err == nil && !cmd.Flags().Changed("poll") {
+					err = cmd.Flags().Set("poll", "-1ns")
+				}
+
+				if err != nil {
+					return err
+				}
+
+				args = nil
+			}
 
-func (display *ImageWindow) startRenderer(ctx context.Context) {
-	display.wg.Add(1)
-	defer display.wg.Done()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			time.Sleep(5 * time.Millisecond)
-		}
-
-		display.renderMu.Lock()
-		dirty := display.dirty
-		nextRedraw := display.nextRedraw
-		display.renderMu.Unlock()
-
-		if dirty && time.Now().After(nextRedraw) {
-			display.renderMu.Lock()
-			display.dirty = false
-			display.renderMu.Unlock()
-
-			err := display.RenderImage()
+			// the images of a playlist are shown like the ones given on the
+			// command line
+			var playlist []overlay.Slide
+			if playlistPath != "" {
+				playlist, err = overlay.LoadPlaylist(playlistPath)
+				if err != nil {
+					return err
+				}
+
+				for _, slide := range playlist {
+					args = append(args, slide.Path)
+				}
+			}
+
+			for i, arg := range args {
+				path, err := filePath(arg)
+				if err != nil {
+					return err
+				}
+
+				args[i] = path
+			}
+
+			// stdin can only be read once, so it can't be cycled through
+			if len(args) > 1 && slices.Contains(args, "-") {
+				return fmt.Errorf("stdin can only be used as the only image")
+			}
+
+			keys, err := overlay.ParseKeymap(append(overlay.DefaultBindings, bindings...))
 			if err != nil {
-				fmt.Println("render image:", err)
+				return fmt.Errorf("parse key bindings: %w", err)
 			}
 
-		}
-	}
-}
+			var placements []placement
+			for _, value := range at {
+				p, err := parsePlacement(value)
+				if err != nil {
+					return err
+				}
 
-func (display *ImageWindow) Close() {
-	display.cancelRenderer()
-	display.conn.Close()
-	display.wg.Wait()
-}
+				placements = append(placements, p)
+			}
 
-func (display *ImageWindow) CreateWindow() error {
-	visualInfo := MatchVisualInfo(display.screen.AllowedDepths, DepthWithAlpha, ClassTrueColor)
-	if visualInfo == nil {
-		return fmt.Errorf("no visual with required parameters found")
-	}
+			var geom overlay.Geometry
+			if geometryString != "" {
+				geom, err = overlay.ParseGeometry(geometryString)
+				if err != nil {
+					return err
+				}
+			}
 
-	colorMapID, err := xproto.NewColormapId(display.conn)
-	if err != nil {
-		return fmt.Errorf("new colormap id: %w", err)
-	}
+			flags := cmd.Flags()
 
-	windowID, err := xproto.NewWindowId(display.conn)
-	if err != nil {
-		return fmt.Errorf("new window id: %w", err)
-	}
+			if flags.Changed("width") {
+				geom.Width = windowWidth
+			}
 
-	display.windowID = windowID
+			if flags.Changed("height") {
+				geom.Height = windowHeight
+			}
 
-	err = xproto.CreateColormapChecked(
-		display.conn,
-		xproto.ColormapAllocNone,
-		colorMapID,
-		display.screen.Root,
-		visualInfo.VisualId,
-	).Check()
-	if err != nil {
-		return fmt.Errorf("create colormap: %w", err)
-	}
+			if flags.Changed("x") {
+				geom.X = windowX
+				geom.XNegative = false
+				geom.HasPosition = true
+			}
 
-	mask := uint32(xproto.CwColormap | xproto.CwBorderPixel | xproto.CwBackPixel)
-	values := []uint32{
-		0, // black bg
-		0, // black border
-		uint32(colorMapID),
-	}
+			if flags.Changed("y") {
+				geom.Y = windowY
+				geom.YNegative = false
+				geom.HasPosition = true
+			}
 
-	imageWidth := display.image.Bounds().Dx()
-	imageHeight := display.image.Bounds().Dy()
-
-	err = xproto.CreateWindowChecked(
-		display.conn,
-		DepthWithAlpha,
-		windowID,
-		display.screen.Root,           // parent
-		0,                             // x
-		0,                             // y
-		uint16(imageWidth),            // width
-		uint16(imageHeight),           // height
-		0,                             // border width
-		xproto.WindowClassInputOutput, // class
-		visualInfo.VisualId,
-		mask,
-		values,
-	).Check()
-	if err != nil {
-		return fmt.Errorf("create window: %w", err)
-	}
+			if above && below {
+				return fmt.Errorf("--above and --below are mutually exclusive")
+			}
 
-	display.windowWidth = imageWidth
-	display.windowHeight = imageHeight
-
-	// This call to ChangeWindowAttributes could be factored out and
-	// included with the above CreateWindow call, but it is left here for
-	// instructive purposes. It tells X to send us events when the 'structure'
-	// of the window is changed (i.e., when it is resized, mapped, unmapped,
-	// etc.) and when a key press or a key release has been made when the
-	// window has focus.
-	// We also set the 'BackPixel' to white so that the window isn't butt ugly.
-	xproto.ChangeWindowAttributes(display.conn, display.windowID,
-		xproto.CwBackPixel|xproto.CwEventMask,
-		[]uint32{
-			0x00000000,
-			xproto.EventMaskStructureNotify |
-				xproto.EventMaskExposure |
-				xproto.EventMaskButtonPress,
-		})
-
-	err = xproto.MapWindowChecked(display.conn, windowID).Check()
-	if err != nil {
-		return fmt.Errorf("map window :%w", err)
-	}
+			if followWindow != "" && (lockSize || geom != overlay.Geometry{}) {
+				return fmt.Errorf("--follow takes the geometry of the followed window")
+			}
 
-	err = display.setClass()
-	if err != nil {
-		return fmt.Errorf("set class: %w", err)
-	}
+			if outputName != "" && fullscreenOutput != "" {
+				return fmt.Errorf("--output and --fullscreen-output are mutually exclusive")
+			}
 
-	imageGc, err := xproto.NewGcontextId(display.conn)
-	if err != nil {
-		return fmt.Errorf("new graphics context id: %w", err)
-	}
+			if followWindow != "" && (outputName != "" || fullscreenOutput != "") {
+				return fmt.Errorf("--follow can't be combined with --output or --fullscreen-output")
+			}
 
-	err = xproto.CreateGCChecked(
-		display.conn,
-		imageGc,
-		xproto.Drawable(display.windowID),
-		0,
-		[]uint32{},
-	).Check()
-	if err != nil {
-		return fmt.Errorf("create graphics context: %w", err)
-	}
+			if group != "" && noSocket {
+				return fmt.Errorf("--group finds the other overlays through their control sockets, it can't be combined with --no-socket")
+			}
 
-	display.imageGc = imageGc
+			if watch && slices.Contains(args, "-") {
+				return fmt.Errorf("--watch needs a file, not stdin")
+			}
 
-	return nil
-}
+			order, err := parseSortOrder(sortOrderName)
+			if err != nil {
+				return fmt.Errorf("parse --sort: %w", err)
+			}
 
-func (display *ImageWindow) RenderImage() error {
-	geom, err := xproto.GetGeometry(display.conn, xproto.Drawable(display.windowID)).Reply()
-	if err != nil {
-		return fmt.Errorf("get geometry: %w", err)
-	}
+			if matchPattern != "" {
+				err = parseMatch(matchPattern)
+				if err != nil {
+					return fmt.Errorf("parse --match: %w", err)
+				}
+			}
 
-	originalBounds := display.image.Bounds()
-	aspect := float64(originalBounds.Dx()) / float64(originalBounds.Dy())
+			images, dirs, err := expandDirectories(args, order, matchPattern)
+			if err != nil {
+				return err
+			}
 
-	width := int(geom.Width)
-	height := int(geom.Height)
+			if len(args) > 0 && len(images) == 0 {
+				return fmt.Errorf("no images in %s", strings.Join(dirs, ", "))
+			}
 
-	xOffset := 0
-	yOffset := 0
+			if slideshowInterval < 0 {
+				return fmt.Errorf("--slideshow has to be positive")
+			}
 
-	newAspect := float64(width) / float64(height)
+			if (once || crossfade != 0) && slideshowInterval == 0 && playlist == nil {
+				return fmt.Errorf("--once and --crossfade need --slideshow or --playlist")
+			}
 
-	if newAspect > aspect {
-		newWidth := int(aspect * float64(height))
-		xOffset = (width - newWidth) / 2
-		width = newWidth
-	} else {
-		newHeight := int(float64(width) / aspect)
-		yOffset = (height - newHeight) / 2
-		height = newHeight
-	}
+			if once && random {
+				return fmt.Errorf("--once can't be combined with --random, random images don't run out")
+			}
 
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+			if playlist != nil {
+				if len(images) != len(playlist) || len(dirs) > 0 {
+					return fmt.Errorf("--playlist lists images, not directories")
+				}
 
-	const fullAlpha = 255
-	alpha := uint8(fullAlpha * display.imageOpacity)
-	mask := image.NewUniform(color.Alpha{alpha})
+				if random {
+					return fmt.Errorf("--playlist can't be combined with --random, it has an order")
+				}
 
-	draw.NearestNeighbor.Scale(
-		img,
-		img.Bounds(),
-		display.image,
-		display.image.Bounds(),
-		draw.Over,
-		&draw.Options{
-			SrcMask: mask,
-		},
-	)
+				for _, slide := range playlist {
+					if slide.Duration == 0 && slideshowInterval == 0 {
+						return fmt.Errorf("%s has no duration in the playlist, give one or --slideshow", slide.Path)
+					}
+				}
+			}
 
-	data := make([]byte, 0, width*height*4)
-
-	for y := 0; y < height; y += 1 {
-		for x := 0; x < width; x += 1 {
-			r, g, b, a := img.At(x, y).RGBA()
-			// xorg is bgr
-			data = append(data, byte(b))
-			data = append(data, byte(g))
-			data = append(data, byte(r))
-			data = append(data, byte(a))
-		}
-	}
+			if flags.Changed("seed") && !random {
+				return fmt.Errorf("--seed needs --random")
+			}
 
-	size := len(data)
+			if fade < 0 || fadeIn < 0 {
+				return fmt.Errorf("--fade and --fade-in have to be positive")
+			}
 
-	shmID, err := unix.SysvShmGet(unix.IPC_PRIVATE, size, unix.IPC_CREAT|unix.IPC_EXCL|0o600)
-	if err != nil {
-		return fmt.Errorf("create shared memory segment: %w", err)
-	}
-	defer func() {
-		// it is important to remove the shared memory segment because it
-		// persists even if the process is destroyed.
-		_, err := unix.SysvShmCtl(shmID, unix.IPC_RMID, nil)
-		if err != nil {
-			fmt.Println("destroy shared memmory segment:", err)
-		}
-	}()
-
-	buf, err := unix.SysvShmAttach(shmID, 0, 0)
-	if err != nil {
-		return fmt.Errorf("attach to shared memory segment: %w", err)
-	}
+			if colorBits < 1 || colorBits > 8 {
+				return fmt.Errorf("--color-bits has to be between 1 and 8")
+			}
 
-	defer func() {
-		err := unix.SysvShmDetach(buf)
-		if err != nil {
-			fmt.Println("detach from shared memory segment:", err)
-		}
-	}()
+			scale, err := overlay.ParseScaleMode(scaleName)
+			if err != nil {
+				return fmt.Errorf("parse --scale: %w", err)
+			}
 
-	n := copy(buf, data)
-	if n != size {
-		return fmt.Errorf("copy failed, want %d bytes, got %d", size, n)
-	}
+			// a solid color, e.g. to dim the screen, fills the monitor
+			// unless it is placed elsewhere
+			var solidImage image.Image
+			if solid != "" {
+				c, err := overlay.ParseColor(solid)
+				if err != nil {
+					return fmt.Errorf("parse --solid: %w", err)
+				}
 
-	segID, err := shm.NewSegId(display.conn)
-	if err != nil {
-		return fmt.Errorf("new segment id: %w", err)
-	}
+				img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+				img.SetRGBA(0, 0, c)
+				solidImage = img
 
-	err = shm.AttachChecked(display.conn, segID, uint32(shmID), false).Check()
-	if err != nil {
-		return fmt.Errorf("attach to shared memory segment (X): %w", err)
-	}
+				scale = overlay.ScaleStretch
+				if geom == (overlay.Geometry{}) && fullscreenOutput == "" {
+					fullscreen = true
+				}
+			}
 
-	defer func() {
-		err = shm.DetachChecked(display.conn, segID).Check()
-		if err != nil {
-			fmt.Println("detach from shared memory (X):", err)
-		}
-	}()
-
-	err = shm.PutImageChecked(
-		display.conn,
-		xproto.Drawable(display.windowID),
-		display.imageGc,
-		uint16(width),
-		uint16(height),
-		0, // src x
-		0, // src y
-		uint16(width),
-		uint16(height),
-		int16(xOffset), // dst x
-		int16(yOffset), // dst y
-		DepthWithAlpha, // depth
-		xproto.ImageFormatZPixmap,
-		0,
-		segID,
-		0,
-	).Check()
-	if err != nil {
-		return fmt.Errorf("put image: %w", err)
-	}
+			if idle < 0 {
+				return fmt.Errorf("--idle can't be negative")
+			}
 
-	return nil
-}
+			align, err := overlay.ParseAlignment(alignName)
+			if err != nil {
+				return fmt.Errorf("parse --align: %w", err)
+			}
 
-func (display *ImageWindow) setClass() error {
-	class := "overlay\x00overlay\x00"
-
-	const format8Bit = 8
-
-	err := xproto.ChangePropertyChecked(
-		display.conn,
-		xproto.PropModeReplace,
-		display.windowID,
-		xproto.AtomWmClass,
-		xproto.AtomString,
-		format8Bit,
-		uint32(len(class)),
-		[]byte(class),
-	).Check()
-	if err != nil {
-		return fmt.Errorf("set class: %w", err)
-	}
+			layout, err := overlay.ParseLayout(layoutName)
+			if err != nil {
+				return fmt.Errorf("parse --layout: %w", err)
+			}
 
-	return nil
-}
+			// the first image opens like it was left, unless the command
+			// line or the config say otherwise
+			board := layout != overlay.LayoutNone && len(images) > 1
+			if restore && len(images) > 0 && !random && !board {
+				state, ok, err := overlay.LoadImageState(images[0])
+				if err != nil {
+					slog.Error("restore state", "err", err)
+				}
 
-func (display *ImageWindow) HandleEvents() error {
-	for {
-		ev, xerr := display.conn.WaitForEvent()
-		if ev == nil && xerr == nil {
-			return fmt.Errorf("got no event but err is nil, exiting")
-		}
-
-		switch event := ev.(type) {
-		case xproto.ConfigureNotifyEvent:
-			if display.windowWidth != int(event.Width) || display.windowHeight != int(event.Height) {
-				display.windowWidth = int(event.Width)
-				display.windowHeight = int(event.Height)
-				display.requestRedraw()
-			}
-		case xproto.ButtonPressEvent:
-			x := min(display.windowWidth, max(0, int(event.EventX)))
-			display.imageOpacity = float64(x) / float64(display.windowWidth)
-			display.requestRedraw()
-		case xproto.DestroyNotifyEvent:
-			return nil
-		}
-	}
-}
+				placed := geometryString != "" || fullscreen || followWindow != "" || outputName != "" || fullscreenOutput != ""
+				for _, name := range []string{"width", "height", "x", "y"} {
+					placed = placed || flags.Changed(name)
+				}
 
-func run() error {
-	initialOpacity := 0.0
+				if ok && !placed && state.Width > 0 && state.Height > 0 {
+					geom = state.Geometry()
+				}
 
-	cmd := &cobra.Command{
-		Use:           "xoverlay <file>",
-		SilenceErrors: true,
-		SilenceUsage:  true,
-		Args:          cobra.ExactArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
-			filename := args[0]
+				if ok && !opacityGiven {
+					initialOpacity = state.Opacity
+				}
+
+				if ok && !flags.Changed("scale") && state.Scale != "" {
+					scale = state.Scale
+				}
+			}
+
+			if gap < 0 {
+				return fmt.Errorf("--gap can't be negative")
+			}
+
+			if sequence != "" {
+				err = overlay.ParseSequence(sequence)
+				if err != nil {
+					return fmt.Errorf("parse --sequence: %w", err)
+				}
+			}
+
+			if fps <= 0 {
+				return fmt.Errorf("--fps has to be positive")
+			}
+
+			if magnify < 0 {
+				return fmt.Errorf("--magnify has to be positive")
+			}
+
+			// the loupe shows whole pixels unless another filter is asked for
+			if magnify > 0 && !cmd.Flags().Changed("filter") {
+				filterName = string(overlay.FilterNearest)
+			}
+
+			filter, err := overlay.ParseScaleFilter(filterName)
+			if err != nil {
+				return fmt.Errorf("parse --filter: %w", err)
+			}
+
+			cvd := overlay.CVDNone
+			if cvdName != "" {
+				cvd, err = overlay.ParseCVD(cvdName)
+				if err != nil {
+					return fmt.Errorf("parse --cvd: %w", err)
+				}
+			}
+
+			channel := overlay.ChannelAll
+			if channelName != "" {
+				channel, err = overlay.ParseChannel(channelName)
+				if err != nil {
+					return fmt.Errorf("parse --channel: %w", err)
+				}
+			}
+
+			if threshold < 0 || threshold > 255 {
+				return fmt.Errorf("--threshold has to be 1 to 255")
+			}
+
+			var outline color.RGBA
+			if outlineName != "" {
+				outline, err = overlay.ParseColor(outlineName)
+				if err != nil {
+					return fmt.Errorf("parse --outline: %w", err)
+				}
+
+				// drawn opaque, so that it can be told apart from the UI
+				outline.A = 0xff
+			}
+
+			var blueprint color.RGBA
+			if blueprintName != "" {
+				blueprint, err = overlay.ParseColor(blueprintName)
+				if err != nil {
+					return fmt.Errorf("parse --blueprint: %w", err)
+				}
+			}
+
+			if outlineThreshold < 1 || outlineThreshold > 255 {
+				return fmt.Errorf("--outline-threshold has to be 1 to 255")
+			}
+
+			blend, err := overlay.ParseBlendMode(blendName)
+			if err != nil {
+				return fmt.Errorf("parse --blend: %w", err)
+			}
+
+			diffChannel := overlay.ChannelAll
+			if diffChannelName != "" {
+				diffChannel, err = overlay.ParseChannel(diffChannelName)
+				if err != nil || diffChannel == overlay.ChannelAlpha {
+					return fmt.Errorf("unknown channel %q for --diff-channel, expected r, g or b", diffChannelName)
+				}
+			}
+
+			if diffGain < 1 {
+				return fmt.Errorf("--diff-gain has to be at least 1")
+			}
+
+			if opacityMin < 0 || opacityMax > 1 || opacityMin >= opacityMax {
+				return fmt.Errorf("--opacity-min and --opacity-max have to be from 0 to 1, the minimum below the maximum")
+			}
+
+			if blend != overlay.BlendDifference && (diffGain != 1 || diffChannel != overlay.ChannelAll || diffBlink != 0) {
+				return fmt.Errorf("--diff-gain, --diff-channel and --diff-blink need --blend difference")
+			}
+
+			transparency, err := overlay.ParseTransparency(transparencyName)
+			if err != nil {
+				return fmt.Errorf("parse --transparency: %w", err)
+			}
+
+			split := overlay.SplitNone
+			if splitName != "" {
+				split, err = overlay.ParseSplit(splitName)
+				if err != nil {
+					return fmt.Errorf("parse --split: %w", err)
+				}
+			}
+
+			staleStyle, err := overlay.ParseStaleStyle(staleStyleName)
+			if err != nil {
+				return fmt.Errorf("parse --stale-style: %w", err)
+			}
+
+			recordFormat, err := overlay.ParseRecordFormat(recordFormatName)
+			if err != nil {
+				return fmt.Errorf("parse --record-format: %w", err)
+			}
+
+			var figma *overlay.Figma
+			if figmaNode != "" {
+				fileKey, nodeID, err := overlay.ParseFigmaNode(figmaNode)
+				if err != nil {
+					return fmt.Errorf("parse --figma: %w", err)
+				}
+
+				if figmaToken == "" {
+					figmaToken = os.Getenv("FIGMA_TOKEN")
+				}
+
+				if figmaToken == "" {
+					return fmt.Errorf("--figma needs --figma-token or $FIGMA_TOKEN")
+				}
+
+				figma = &overlay.Figma{
+					FileKey:  fileKey,
+					NodeID:   nodeID,
+					Token:    figmaToken,
+					Interval: figmaInterval,
+				}
+			}
+
+			var urlImage *overlay.URLImage
+			if remoteURL != "" || presign != "" {
+				header, err := auth.header()
+				if err != nil {
+					return err
+				}
+
+				urlImage = &overlay.URLImage{
+					URL:      remoteURL,
+					Interval: pollInterval,
+					Presign:  strings.Fields(presign),
+					Header:   header,
+				}
+			} else if auth.set() {
+				return fmt.Errorf("--header, --basic-auth and the bearer token options only apply to --url")
+			}
+
+			var source overlay.Source
+			if sourceSpec != "" {
+				source, err = overlay.ParseSource(sourceSpec, sourceInterval)
+				if err != nil {
+					return fmt.Errorf("parse --source: %w", err)
+				}
+			}
+
+			if clipboard != "" && clipboard != "clipboard" && clipboard != "primary" {
+				return fmt.Errorf("unknown selection %q for --clipboard, expected clipboard or primary", clipboard)
+			}
+
+			// a slideshow is a presentation, unless told otherwise
+			if (slideshowInterval > 0 || playlist != nil) && !flags.Changed("presenter-keys") {
+				presenterKeys = true
+			}
+
+			var toggle *overlay.KeyCombo
+			if toggleKey != "" {
+				combo, err := overlay.ParseKeyCombo(toggleKey)
+				if err != nil {
+					return fmt.Errorf("parse --toggle-key: %w", err)
+				}
+
+				toggle = &combo
+			}
+
+			var privacy *overlay.KeyCombo
+			if privacyKey != "" {
+				combo, err := overlay.ParseKeyCombo(privacyKey)
+				if err != nil {
+					return fmt.Errorf("parse --privacy-key: %w", err)
+				}
+
+				privacy = &combo
+			}
+
+			var zones []image.Rectangle
+			for _, value := range privacyZones {
+				zone, err := overlay.ParseZone(value)
+				if err != nil {
+					return fmt.Errorf("parse --privacy-zone: %w", err)
+				}
+
+				zones = append(zones, zone)
+			}
+
+			if privacy != nil && len(zones) == 0 {
+				return fmt.Errorf("--privacy-key needs --privacy-zone")
+			}
+
+			if pixelate < 0 {
+				return fmt.Errorf("--pixelate has to be positive")
+			}
+
+			if rotate != 0 && rotate != 90 && rotate != 180 && rotate != 270 {
+				return fmt.Errorf("--rotate has to be 90, 180 or 270")
+			}
+
+			var crop image.Rectangle
+			if cropString != "" {
+				crop, err = overlay.ParseZone(cropString)
+				if err != nil {
+					return fmt.Errorf("parse --crop: %w", err)
+				}
+			}
+
+			var corners []image.Point
+			if cornersString != "" {
+				corners, err = overlay.ParseCorners(cornersString)
+				if err != nil {
+					return fmt.Errorf("parse --corners: %w", err)
+				}
+			}
+
+			if grid < 0 {
+				return fmt.Errorf("--grid has to be positive")
+			}
+
+			if tick < 0 {
+				return fmt.Errorf("--tick has to be positive")
+			}
+
+			var guides []overlay.Guide
+			if guidesString != "" {
+				guides, err = overlay.ParseGuides(guidesString)
+				if err != nil {
+					return fmt.Errorf("parse --guides: %w", err)
+				}
+			}
+
+			textColor, err := overlay.ParseColor(textColorName)
+			if err != nil {
+				return fmt.Errorf("parse --text-color: %w", err)
+			}
+
+			var annotations []overlay.Annotation
+			if text != "" {
+				annotation, err := overlay.TextAnnotation(text, textPosition, textColor)
+				if err != nil {
+					return fmt.Errorf("parse --text-position: %w", err)
+				}
+
+				annotations = append(annotations, annotation)
+			}
+
+			for _, value := range boxes {
+				annotation, err := overlay.ParseBox(value)
+				if err != nil {
+					return fmt.Errorf("parse --box: %w", err)
+				}
+
+				annotations = append(annotations, annotation)
+			}
+
+			for _, value := range lines {
+				annotation, err := overlay.ParseLine(value)
+				if err != nil {
+					return fmt.Errorf("parse --line: %w", err)
+				}
+
+				annotations = append(annotations, annotation)
+			}
 
-			var imageBytes []byte
-			var err error
-			if filename == "-" {
-				imageBytes, err = io.ReadAll(os.Stdin)
+			// the default font at the default size is loaded when needed,
+			// unless its fallbacks have to be found before the sandbox
+			// hides them
+			var face font.Face
+			var fallbackFonts []string
+			if fontPath != "" || fontSize != overlay.DefaultFontSize || sandbox {
+				fonts, err := overlay.LoadFontSet(fontPath, fontSize)
 				if err != nil {
-					return fmt.Errorf("read image bytes from stdin: %w", err)
+					return fmt.Errorf("--font: %w", err)
 				}
+
+				face = fonts
+				if sandbox {
+					fallbackFonts = fonts.Fallbacks()
+				}
+			}
+
+			var contrast []image.Rectangle
+			contrastAuto := false
+			for _, value := range contrastZones {
+				if value == "auto" {
+					contrastAuto = true
+					continue
+				}
+
+				zone, err := overlay.ParseZone(value)
+				if err != nil {
+					return fmt.Errorf("parse --contrast: %w", err)
+				}
+
+				contrast = append(contrast, zone)
+			}
+
+			var redact []image.Rectangle
+			for _, value := range redactRegions {
+				region, err := overlay.ParseZone(value)
+				if err != nil {
+					return fmt.Errorf("parse --redact: %w", err)
+				}
+
+				redact = append(redact, region)
+			}
+
+			if renderThreads < 1 {
+				return fmt.Errorf("--render-threads has to be at least 1")
+			}
+
+			if flags.Changed("nice") {
+				err = setNiceness(niceness)
+				if err != nil {
+					return fmt.Errorf("set niceness: %w", err)
+				}
+			}
+
+			if idlePriority {
+				err = setIdlePriority()
+				if err != nil {
+					return fmt.Errorf("set idle priority: %w", err)
+				}
+			}
+
+			options := overlay.Options{
+				InitialOpacity: initialOpacity,
+				Animate:        !noAnimation,
+				Keymap:         keys,
+				NudgeStep:      nudgeStep,
+				OpacityStep:    opacityStep,
+				OpacityMin:     opacityMin,
+				OpacityMax:     opacityMax,
+				RenderThreads:  renderThreads,
+				Geometry:       geom,
+				Above:          above,
+				Below:          below,
+				Sticky:         sticky,
+				Fullscreen:     fullscreen,
+				Layer:          layer,
+				LockSize:       lockSize,
+				LockAspect:     lockAspect,
+
+				OverrideRedirect: overrideRedirect,
+				NoDecorations:    noDecorations,
+
+				Remote:    remote,
+				XRender:   xrender,
+				ColorBits: colorBits,
+				Quirks:    quirkList,
+
+				Scale: scale,
+				Align: align,
+
+				Filter:       filter,
+				Blend:        blend,
+				Transparency: transparency,
+				Split:        split,
+
+				DiffGain:    diffGain,
+				DiffChannel: diffChannel,
+				DiffBlink:   diffBlink,
+
+				Fade:   fade,
+				FadeIn: fadeIn,
+
+				ToggleKey:     toggle,
+				PresenterKeys: presenterKeys,
+
+				PrivacyZones: zones,
+				PrivacyKey:   privacy,
+
+				Pixelate: pixelate,
+				Redact:   redact,
+
+				Contrast:     contrast,
+				ContrastAuto: contrastAuto,
+
+				Layout: layout,
+				Gap:    gap,
+				Labels: labels,
+
+				Limits: overlay.ResourceLimits{
+					MaxCPUPercent: maxCPUPercent,
+					MaxRSS:        int64(maxRSSMB) << 20,
+				},
+
+				Images: images,
+				Image:  solidImage,
+				Mirror: mirrorWindow,
+				Follow: followWindow,
+
+				Sequence: sequence,
+				FPS:      fps,
+
+				Magnify:     magnify,
+				MagnifyGrid: magnifyGrid,
+				Crosshair:   crosshair,
+
+				Receive: receiveAddress,
+
+				SourcePlugin: strings.Fields(sourcePlugin),
+
+				Clipboard: strings.ToUpper(clipboard),
+				Figma:     figma,
+				URL:       urlImage,
+				Source:    source,
+
+				StaleAfter: staleAfter,
+				StaleStyle: staleStyle,
+				History:    history,
+
+				RecordDir:    recordDir,
+				RecordFormat: recordFormat,
+
+				Screenshot: screenshot,
+
+				AutoTrim: autoTrim,
+
+				Crop:   crop,
+				Rotate: rotate,
+				FlipH:  flipH,
+				FlipV:  flipV,
+				CVD:    cvd,
+
+				Filters: overlay.ColorFilters{
+					Grayscale: grayscale,
+					Channel:   channel,
+					Threshold: threshold,
+					Invert:    invert,
+					Blueprint: blueprint,
+
+					Outline:          outline,
+					OutlineThreshold: outlineThreshold,
+				},
+
+				Corners:         corners,
+				RememberCorners: rememberCorners,
+				RememberState:   restore,
+
+				Idle: idle,
+
+				LockInput:        kiosk,
+				AllowBlanking:    allowBlanking,
+				Watchdog:         watchdog,
+				WatchdogInterval: watchdogInterval,
+
+				Grid:   grid,
+				Guides: guides,
+				Ruler:  ruler,
+				Picker: picker,
+
+				Annotations: annotations,
+				Font:        face,
+				TextColor:   textColor,
+				Tick:        tick,
+
+				ShowInfo: showInfo,
+
+				Random: random,
+				Seed:   seed,
+
+				Group: group,
+
+				Output:           outputName,
+				FullscreenOutput: fullscreenOutput,
+			}
+
+			access, err := network.access(webhookAddress != "" || broadcastAddress != "" || httpAddress != "")
+			if err != nil {
+				return err
+			}
+
+			if receiveAddress != "" {
+				options.ReceiveToken = access.Token
+
+				if network.certFile != "" || network.caFile != "" {
+					options.ReceiveTLS, err = overlay.ClientTLS(network.certFile, network.keyFile, network.caFile)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if stdinRaw != "" {
+				format, err := overlay.ParseRawFormat(stdinRaw)
+				if err != nil {
+					return fmt.Errorf("parse --stdin-raw: %w", err)
+				}
+
+				options.Stream = os.Stdin
+				options.StreamFormat = format
+			}
+
+			for _, command := range effectPlugins {
+				options.EffectPlugins = append(options.EffectPlugins, strings.Fields(command))
+			}
+
+			// an image read from stdin can't be restored, without a restart
+			// command we don't take part in the session
+			if slices.Contains(args, "-") || stdinRaw != "" {
+				slog.Debug("not saved with the session, the image is read from stdin")
 			} else {
-				imageBytes, err = os.ReadFile(filename)
+				// the session is restored in another working directory
+				if playlistPath != "" {
+					path, err := filepath.Abs(playlistPath)
+					if err != nil {
+						return fmt.Errorf("resolve playlist path: %w", err)
+					}
+
+					playlistPath = path
+				}
+
+				options.RestartArgs, err = restartArgs(flags)
+				if err != nil {
+					return fmt.Errorf("build restart command: %w", err)
+				}
+
+				options.RestartHasImages = playlistPath != ""
+			}
+
+			if check {
+				err = overlay.Check(overlay.WithOptions(options))
 				if err != nil {
-					return fmt.Errorf("read image bytes from file: %w", err)
+					return err
 				}
+
+				for _, p := range placements {
+					err = overlay.Check(overlay.WithOptions(options), overlay.WithImageFiles(p.path))
+					if err != nil {
+						return fmt.Errorf("%s: %w", p.path, err)
+					}
+				}
+
+				return nil
 			}
 
-			initialOpacity = min(1.0, max(0.0, initialOpacity))
+			// the options are fine, what fails from here on is the X server
+			// or the images, which a kiosk waits out
+			if kiosk {
+				kioskArgs, err = kioskCommand()
+				if err != nil {
+					return err
+				}
+			}
 
-			display, err := NewImageWindow(initialOpacity, imageBytes)
+			if sandbox {
+				switch {
+				case eventHooks.enabled():
+					return fmt.Errorf("--on-show, --on-hide, --on-image-change and --on-click run commands, which --sandbox forbids")
+				case strings.HasPrefix(sourceSpec, "command="):
+					return fmt.Errorf("--source command= runs a command, which --sandbox forbids")
+				case presign != "":
+					return fmt.Errorf("--presign runs a command, which --sandbox forbids")
+				case recordFormat == overlay.RecordWebM:
+					return fmt.Errorf("--record-format webm records with ffmpeg, which --sandbox forbids")
+				}
+			}
+
+			display, err := overlay.New(overlay.WithOptions(options))
 			if err != nil {
-				return fmt.Errorf("new display: %w", err)
+				return err
 			}
 			defer display.Close()
 
-			err = display.CreateWindow()
+			if eventHooks.enabled() {
+				go eventHooks.run(display)
+			}
+
+			configWatcher, err := watchConfig(display, flags, profile, commandLineBindings)
 			if err != nil {
-				return fmt.Errorf("create window: %w", err)
+				// e.g. there is no config directory
+				slog.Debug("watch config", "err", err)
+			} else {
+				defer configWatcher.Close()
 			}
 
-			// initial draw
-			display.requestRedraw()
+			for _, p := range placements {
+				_, err := display.Add(p.path, p.geometry)
+				if err != nil {
+					return fmt.Errorf("show %s: %w", p.path, err)
+				}
+			}
 
-			err = display.HandleEvents()
+			if !noSocket {
+				if socketPath == "" {
+					socketPath = overlay.ControlSocketPath(os.Getpid())
+				}
+
+				server, err := display.ListenControl(socketPath)
+				if err != nil {
+					return fmt.Errorf("listen on control socket: %w", err)
+				}
+				defer func() {
+					server.Close()
+					os.Remove(socketPath)
+				}()
+			}
+
+			if webhookAddress != "" {
+				server, err := display.ListenWebhook(webhookAddress, access)
+				if err != nil {
+					return fmt.Errorf("listen on webhook: %w", err)
+				}
+				defer server.Close()
+			}
+
+			if broadcastAddress != "" {
+				server, err := display.ListenBroadcast(broadcastAddress, access)
+				if err != nil {
+					return fmt.Errorf("listen for receivers: %w", err)
+				}
+				defer server.Close()
+			}
+
+			if httpAddress != "" {
+				server, err := display.ListenHTTP(httpAddress, access)
+				if err != nil {
+					return fmt.Errorf("listen on http: %w", err)
+				}
+				defer server.Close()
+			}
+
+			stopSignals := handleSignals(display, opacityStep)
+			defer stopSignals()
+
+			for _, dir := range dirs {
+				watcher, err := watchDirectory(dir, func(names []string) {
+					images, _, err := expandDirectories(args, order, matchPattern)
+					if err != nil {
+						slog.Error("rescan directory", "err", err)
+						return
+					}
+
+					display.SetImageFiles(images)
+
+					if !watch {
+						return
+					}
+
+					for _, name := range names {
+						err := display.ReloadImage(filepath.Join(dir, name))
+						if err != nil {
+							slog.Error("reload image", "err", err)
+						}
+					}
+				})
+				// the images are shown anyway, just not updated
+				if err != nil {
+					slog.Error("watch directory", "err", err)
+					continue
+				}
+				defer watcher.Close()
+			}
+
+			// frames that are rendered or rewritten are played as soon as
+			// they are written
+			if sequence != "" {
+				watcher, err := watchDirectory(filepath.Dir(sequence), func([]string) {
+					display.RescanSequence()
+				})
+				if err != nil {
+					slog.Error("watch sequence", "err", err)
+				} else {
+					defer watcher.Close()
+				}
+			}
+
+			if watch {
+				for _, filename := range args {
+					// watched above
+					if slices.Contains(dirs, filename) {
+						continue
+					}
+
+					path, err := filepath.Abs(filename)
+					if err != nil {
+						return fmt.Errorf("resolve image path: %w", err)
+					}
+
+					watcher, err := watchFile(path, func() {
+						// a half written file fails to decode, the write
+						// that completes it triggers another reload
+						err := display.ReloadImage(filename)
+						if err != nil {
+							slog.Error("reload image", "err", err)
+						}
+					})
+					if err != nil {
+						return fmt.Errorf("watch image: %w", err)
+					}
+					defer watcher.Close()
+				}
+			}
+
+			if slideshowInterval > 0 || playlist != nil {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				go display.RunSlideshow(ctx, overlay.Slideshow{
+					Interval:  slideshowInterval,
+					Crossfade: crossfade,
+					Once:      once,
+					Playlist:  playlist,
+				})
+			}
+
+			if sandbox {
+				readable := slices.Clone(args)
+				for _, p := range placements {
+					readable = append(readable, p.path)
+				}
+
+				paths := newSandboxPaths(readable, dirs, !noSocket, rememberCorners || restore)
+				if sequence != "" {
+					paths.allowRead(filepath.Dir(sequence))
+				}
+
+				for _, path := range fallbackFonts {
+					paths.allowRead(path)
+				}
+
+				// recordings and screenshots can only be saved where they
+				// were asked for
+				if recordDir != "" {
+					paths.allowWrite(recordDir)
+				}
+
+				if info, err := os.Stat(screenshot); err == nil && info.IsDir() {
+					paths.allowWrite(screenshot)
+				} else if screenshot != "" && screenshot != "-" {
+					paths.allowWrite(filepath.Dir(screenshot))
+				}
+
+				err = enterSandbox(paths)
+				if err != nil {
+					return fmt.Errorf("enter sandbox: %w", err)
+				}
+			}
+
+			err = display.Wait()
 			if err != nil {
 				return fmt.Errorf("handle events: %w", err)
 			}
@@ -511,11 +1319,179 @@ func run() error {
 
 	flags := cmd.Flags()
 
-	const defaultInitialOpacity = 0.5
+	const (
+		defaultInitialOpacity = 0.5
+		defaultNudgeStep      = 1
+		defaultOpacityStep    = 0.05
+	)
 
 	flags.Float64Var(&initialOpacity, "opacity", defaultInitialOpacity, "set the initial opacity")
+	flags.DurationVar(&fade, "fade", 0, "animate opacity changes for this long, e.g. 200ms")
+	flags.DurationVar(&fadeIn, "fade-in", 0, "fade the window in for this long when it appears")
+	flags.DurationVar(&idle, "idle", 0, "keep the window hidden until there was no input for this long, like a screensaver, and hide it again on the first input")
+	flags.StringVar(&solid, "solid", "", "show a solid color instead of an image, e.g. black to dim the screen, filling the monitor unless placed with --geometry")
+	flags.BoolVar(&noAnimation, "no-animation", false, "only show the first frame of animated images")
+	flags.StringVar(&toggleKey, "toggle-key", "", "key that shows and hides the window while other windows have the focus, e.g. super+o")
+	flags.BoolVar(&presenterKeys, "presenter-keys", false, "page with the media keys of a presenter remote while other windows have the focus, the default with --slideshow or --playlist")
+	flags.StringArrayVar(&privacyZones, "privacy-zone", nil, "screen area x,y,width,height that privacy mode covers, can be given multiple times")
+	flags.StringVar(&privacyKey, "privacy-key", "", "key that toggles privacy mode while other windows have the focus")
+	flags.IntVar(&rotate, "rotate", 0, "rotate the image clockwise by 90, 180 or 270 degrees, r rotates it further")
+	flags.BoolVar(&flipH, "flip-h", false, "mirror the image horizontally")
+	flags.BoolVar(&flipV, "flip-v", false, "mirror the image vertically")
+	flags.BoolVar(&invert, "invert", false, "invert the colors to make misaligned edges stand out, x toggles it")
+	flags.BoolVar(&grayscale, "grayscale", false, "show the image in grays")
+	flags.StringVar(&channelName, "channel", "", "show only the r, g, b or a channel as gray, shift+c cycles through them")
+	flags.StringVar(&blueprintName, "blueprint", "", "show the lines, text and shapes of the image in this translucent color and nothing else, split at --threshold, b toggles it")
+	flags.Lookup("blueprint").NoOptDefVal = "#2070ffa0"
+	flags.StringVar(&outlineName, "outline", "", "show only the edges of the image in this color, which stay legible over a running UI, o toggles it")
+	flags.Lookup("outline").NoOptDefVal = "magenta"
+	flags.IntVar(&outlineThreshold, "outline-threshold", overlay.DefaultOutlineThreshold, "how much the brightness has to change for an edge of --outline, 1 to 255")
+	flags.IntVar(&threshold, "threshold", 0, "show pixels at least this bright, 1 to 255, as white and the others as black")
+	flags.StringVar(&cvdName, "cvd", "", "show the image as it looks with protanopia, deuteranopia or tritanopia, to check a design for accessibility")
+	flags.BoolVar(&autoTrim, "auto-trim", false, "remove uniform borders like letterbox bars or margins, before --crop")
+	flags.StringVar(&cropString, "crop", "", "only show this part x,y,width,height of the image")
+	flags.StringVar(&cornersString, "corners", "", "project the image onto these window positions x1,y1,...,x4,y4 of its corners, clockwise from the top left, k drags them")
+	flags.IntVar(&pixelate, "pixelate", 0, "pixelate the image with blocks of this size, or only the --redact regions")
+	flags.StringArrayVar(&contrastZones, "contrast", nil, "label image area x,y,width,height with the WCAG contrast ratio of its text, or mark all text that fails AA with auto, can be given multiple times")
+	flags.StringArrayVar(&redactRegions, "redact", nil, "image area x,y,width,height to pixelate, can be given multiple times")
+	flags.BoolVar(&rememberCorners, "remember-corners", false, "remember the corners dragged with k for every image and restore them when it is shown")
+	flags.BoolVar(&restore, "restore", true, "remember the geometry, opacity, scale mode and zoom of every image and open it like it was left, --restore=false to start fresh")
+	flags.IntVar(&grid, "grid", 0, "draw a grid with lines this many pixels apart on top of the image, toggled with g")
+	flags.StringVar(&guidesString, "guides", "", "draw guide lines at these window positions, e.g. 100,240h;360v")
+	flags.StringVar(&text, "text", "", "draw this text over the image, {time}, {date} and {image} are replaced by the time, date and name of the image")
+	flags.StringVar(&fontPath, "font", "", "ttf or otf font of --text, or a fontconfig pattern like \"Noto Sans:bold\" (default the Go font)")
+	flags.Float64Var(&fontSize, "font-size", fontSize, "size of the font of --text in pixels")
+	flags.StringVar(&textPosition, "text-position", textPosition, "where --text goes: an alignment like bottom-right, or x,y of its top left corner")
+	flags.StringVar(&textColorName, "text-color", textColorName, "color of --text and of text added with ctl annotate, a name or #rrggbb[aa]")
+	flags.DurationVar(&tick, "tick", 0, "redraw the window this often for the {time} of --text, e.g. 100ms (default 1s if there is a {time})")
+	flags.StringArrayVar(&boxes, "box", nil, "draw a box x,y,width,height[,color] at these window positions, can be given multiple times")
+	flags.StringArrayVar(&lines, "line", nil, "draw a line x1,y1,x2,y2[,color] between these window positions, can be given multiple times")
+	flags.BoolVar(&ruler, "ruler", false, "show pixel rulers along the edges")
+	flags.BoolVar(&picker, "picker", false, "show the color of the image and the screen under the pointer, toggled with e, clicks copy them")
+	flags.BoolVar(&showInfo, "info", false, "show the info panel with the file name, size, color profile and exif data, toggled with i")
+	flags.StringArrayVar(&bindings, "bind", nil, "bind a key to an action, e.g. ctrl+q=quit or f=none")
+	flags.IntVar(&nudgeStep, "nudge-step", defaultNudgeStep, "pixels to move the window per nudge")
+	flags.Float64Var(&opacityStep, "opacity-step", defaultOpacityStep, "opacity change per key press")
+	flags.Float64Var(&opacityMin, "opacity-min", 0, "the opacity a click at the left edge sets, and the lowest the keys go")
+	flags.Float64Var(&opacityMax, "opacity-max", 1, "the opacity a click at the right edge sets, and the highest the keys go")
+	flags.StringVar(&geometryString, "geometry", "", "initial window geometry, e.g. 800x600+100+50")
+	flags.StringArrayVar(&at, "at", nil, "also show this image in a window of its own, FILE:GEOMETRY, can be given multiple times")
+	flags.IntVar(&windowX, "x", 0, "initial x position of the window")
+	flags.IntVar(&windowY, "y", 0, "initial y position of the window")
+	flags.IntVar(&windowWidth, "width", 0, "initial width of the window (default image width)")
+	flags.IntVar(&windowHeight, "height", 0, "initial height of the window (default image height)")
+	flags.BoolVar(&above, "above", false, "keep the window above other windows")
+	flags.BoolVar(&below, "below", false, "keep the window below other windows")
+	flags.BoolVar(&sticky, "sticky", false, "show the window on all virtual desktops, toggled with s")
+	flags.BoolVar(&fullscreen, "fullscreen", false, "fill the monitor with the window, toggled with f")
+	flags.BoolVar(&kiosk, "kiosk", false, "play the images as a signage player: fullscreen, above other windows, ignoring the keyboard and mouse and starting again after errors")
+	flags.StringVar(&watchdog, "watchdog", "", "request this url regularly while the overlay works, for a monitor that raises an alarm when the requests stop")
+	flags.BoolVar(&allowBlanking, "allow-blanking", false, "let the screen saver and DPMS blank the screen while an animation, a slideshow or --kiosk plays")
+	flags.DurationVar(&watchdogInterval, "watchdog-interval", overlay.DefaultWatchdogInterval, "how often --watchdog is requested")
+	flags.StringVar(&layer, "layer", "", "window type hint for the window manager: dock, overlay or normal")
+	flags.StringVar(&scaleName, "scale", string(overlay.ScaleFit), "how the image is sized within the window: fit, fill, stretch, center or tile")
+	flags.StringVar(&alignName, "align", "center", "where the image is anchored, e.g. top-left, top, right or center")
+	flags.StringVar(&filterName, "filter", string(overlay.FilterAuto), "interpolation used for scaling: auto, nearest, bilinear or catmullrom")
+	flags.StringVar(&blendName, "blend", string(overlay.BlendNormal), "blend the image with the screen below: normal, difference, multiply or screen")
+	flags.Float64Var(&diffGain, "diff-gain", 1, "multiply the differences of --blend difference, so that faint ones can be seen")
+	flags.StringVar(&diffChannelName, "diff-channel", "", "show only the difference of the r, g or b channel, as gray")
+	flags.DurationVar(&diffBlink, "diff-blink", 0, "flash the pixels that differ at all in magenta this often, e.g. 500ms")
+	flags.StringVar(&transparencyName, "transparency", string(overlay.TransparencyAuto), "how the window is made transparent: auto, argb, opacity-hint or none")
+	flags.StringVar(&splitName, "split", "", "show the image on one side of a divider that can be dragged and what is below on the other: v or h")
+	flags.StringVar(&layoutName, "layout", string(overlay.LayoutNone), "show all images at once: none, grid, hstack or vstack")
+	flags.IntVar(&gap, "gap", 8, "pixels between the images of --layout")
+	flags.BoolVar(&labels, "labels", false, "write the file names below the images of --layout")
+	flags.BoolVar(&lockSize, "lock-size", false, "keep the window at the image size, showing the image 1:1")
+	flags.BoolVar(&runtimeDeps, "print-runtime-deps", false, "print what the binary needs at runtime, like a libc, X extensions and programs, and which of them there are")
+	flags.BoolVar(&check, "check", false, "only check that the images load and the X server can show them, the exit code tells what failed")
+	flags.BoolVar(&lockAspect, "lock-aspect", false, "only let the window be resized to the aspect ratio of the image")
+	flags.BoolVar(&overrideRedirect, "override-redirect", false, "bypass the window manager, the window has no frame and can't be moved by it")
+	flags.BoolVar(&noDecorations, "no-decorations", false, "ask the window manager to not draw a titlebar and borders")
+	flags.IntVar(&renderThreads, "render-threads", runtime.GOMAXPROCS(0), "number of threads used for scaling and pixel conversion")
+	flags.IntVar(&niceness, "nice", 0, "scheduling niceness of the process, from -20 (highest priority) to 19 (lowest)")
+	flags.BoolVar(&idlePriority, "idle-priority", false, "only use CPU time nobody else wants")
+	flags.Float64Var(&maxCPUPercent, "max-cpu-percent", 0, "throttle rendering while the cpu usage exceeds this percentage of one core")
+	flags.IntVar(&maxRSSMB, "max-rss-mb", 0, "drop cached frames while the memory usage exceeds this many megabytes")
+	flags.BoolVar(&remote, "remote", false, "optimize for forwarded X connections: no shared memory and fewer redraws")
+	flags.BoolVar(&xrender, "xrender", false, "upload the image once and let the X server scale it and apply the opacity, cheap resizes and fades especially over forwarded connections")
+	flags.IntVar(&colorBits, "color-bits", 8, "bits per color channel, fewer bits compress better over forwarded connections")
+	flags.DurationVar(&slideshowInterval, "slideshow", 0, "advance to the next image after this long, e.g. 5s")
+	flags.DurationVar(&crossfade, "crossfade", 0, "fade between the images of the slideshow for this long")
+	flags.StringVar(&sortOrderName, "sort", string(sortName), "order of the images in directories: name, mtime, size or random")
+	flags.StringVar(&matchPattern, "match", "", "only show the images in directories whose names match this pattern, e.g. '*.png'")
+	flags.BoolVar(&random, "random", false, "show a random image at each slideshow interval or key press instead of the next one")
+	flags.Uint64Var(&seed, "seed", 0, "seed for --random, to show the same sequence of images again")
+	flags.StringVar(&playlistPath, "playlist", "", "play the images of a playlist file with a line \"path duration opacity transition\" per image, see the readme")
+	flags.BoolVar(&once, "once", false, "exit after the last image of the slideshow instead of starting over")
+	flags.StringVar(&logLevel, "log-level", "info", "leave out log messages less important than this: debug, info, warn or error")
+	flags.StringVar(&logFile, "log-file", "", "append the log to this file instead of writing it to stderr")
+	flags.StringVar(&quirkList, "quirks", "auto", "work around limits of vnc and xpra servers: auto, none or a list of no-argb, no-shm and small-requests")
+	flags.StringVar(&stdinRaw, "stdin-raw", "", "show raw rgba frames of this size read from stdin, e.g. 1280x720 or 1280x720@30")
+	flags.StringVar(&sequence, "sequence", "", "play the numbered images of a pattern like frames/out-%04d.png as an animation, and the frames added while it plays")
+	flags.Float64Var(&fps, "fps", fps, "frames per second of --sequence")
+	flags.Float64Var(&magnify, "magnify", 0, "show the screen around the pointer enlarged this many times instead of an image, like a loupe")
+	flags.BoolVar(&magnifyGrid, "magnify-grid", false, "draw a grid between the pixels of --magnify")
+	flags.BoolVar(&crosshair, "crosshair", false, "mark the pixel under the pointer in --magnify")
+	flags.StringVar(&mirrorWindow, "window", "", "show another window, given by id, title or class, instead of an image")
+	flags.StringVar(&outputName, "output", "", "place the window on this monitor, e.g. HDMI-1, with --geometry relative to it")
+	flags.StringVar(&fullscreenOutput, "fullscreen-output", "", "fill this monitor, e.g. DP-2, with the window")
+	flags.StringVar(&followWindow, "follow", "", "keep the window on top of another window, given by id, title or class")
+	flags.BoolVar(&watch, "watch", false, "reload the image whenever the file changes")
+	flags.StringVar(&webhookAddress, "webhook", "", "show images posted as json to this address for a while, e.g. :9000/hook")
+	flags.StringVar(&broadcastAddress, "broadcast", "", "send the image, opacity, visibility and geometry to the overlays started with --receive on this address, e.g. :7900")
+	flags.StringVar(&receiveAddress, "receive", "", "show what the overlay broadcasting on this address shows, e.g. host:7900")
+	flags.StringVar(&clipboard, "clipboard", "", "show the image that was copied, from the clipboard or with --clipboard=primary from the primary selection")
+	flags.Lookup("clipboard").NoOptDefVal = "clipboard"
+	flags.StringVar(&figmaNode, "figma", "", "show the export of a node of a figma file, FILE_KEY:NODE_ID, and update it when the file changes")
+	flags.StringVar(&figmaToken, "figma-token", "", "personal access token for --figma (default $FIGMA_TOKEN, which other users can't see like command lines)")
+	flags.DurationVar(&figmaInterval, "figma-interval", 30*time.Second, "how often --figma checks the file for changes")
+	flags.StringVar(&remoteURL, "url", "", "show the image at this url and poll it for changes, e.g. a dashboard")
+	flags.DurationVar(&pollInterval, "poll", 10*time.Second, "how often --url or an image url is polled for changes, failures back off up to 5m")
+	flags.StringVar(&presign, "presign", "", "program and arguments that print the url to poll, run again when it expired, e.g. \"aws s3 presign s3://bucket/dash.png\"")
+	flags.StringVar(&sourceSpec, "source", "", "show a built in source instead of an image: clock, clock=LAYOUT, countdown=DURATION or command=\"COMMAND\" that prints an image")
+	flags.DurationVar(&sourceInterval, "source-interval", 10*time.Second, "how often --source command=... is run again")
+	flags.StringArrayVar(&auth.headers, "header", nil, "header to send with the requests of --url, \"Name: value\", can be given multiple times")
+	flags.StringVar(&auth.basicAuth, "basic-auth", "", "user:password to log in to --url with, visible to other users like every command line")
+	flags.StringVar(&auth.tokenEnv, "bearer-token-env", "", "environment variable with a token to send to --url as Authorization: Bearer")
+	flags.StringVar(&auth.tokenFile, "bearer-token-file", "", "file with a token to send to --url as Authorization: Bearer")
+	flags.DurationVar(&staleAfter, "stale-after", 0, "mark the image of --url, --figma, --receive, --window, --stdin-raw or a source plugin as stale when nothing new arrived for this long, e.g. 30s")
+	flags.StringVar(&staleStyleName, "stale-style", staleStyleName, "how a stale image is marked: badge or desaturate")
+	flags.IntVar(&history, "history", 0, "keep the last images of a live source, that many, to step back through with , and .")
+	flags.StringVar(&recordDir, "record-dir", "", "directory ctrl+r and ctl record save recordings of a live source in (default the current directory)")
+	flags.StringVar(&recordFormatName, "record-format", recordFormatName, "format of recordings started without a path: apng, mjpeg or webm (with ffmpeg)")
+	flags.StringVar(&screenshot, "screenshot", "", "file or directory ctrl+s and ctrl+shift+s save screenshots of the overlay to, - for stdout (default the current directory)")
+	flags.StringVar(&sourcePlugin, "source-plugin", "", "show the images delivered by this program and its arguments, see the readme")
+	flags.StringArrayVar(&effectPlugins, "effect-plugin", nil, "send every image through this program and its arguments before it is shown, can be given multiple times")
+	flags.StringVar(&httpAddress, "http", "", "serve the http api on this address, e.g. 127.0.0.1:7878")
+	flags.StringVar(&network.tokenFile, "token-file", "", "file with the token requests to --http, --webhook and --broadcast have to send, and --receive sends")
+	flags.StringVar(&network.certFile, "tls-cert", "", "certificate to serve --http, --webhook and --broadcast over tls with, or to present to the broadcast with --receive")
+	flags.StringVar(&network.keyFile, "tls-key", "", "key of --tls-cert")
+	flags.StringVar(&network.caFile, "tls-ca", "", "certificate authority the other side's certificate has to be signed by, clients of the listeners need one then")
+	flags.StringVar(&network.allow, "allow", "", "comma separated list of what --http and --webhook accept, e.g. state,opacity,webhook (default all)")
+	flags.Float64Var(&network.rateLimit, "rate-limit", 0, "requests per second --http, --webhook and --broadcast accept (default unlimited)")
+	flags.StringVar(&socketPath, "socket", "", "path of the control socket (default $XDG_RUNTIME_DIR/xoverlay/<pid>.sock)")
+	flags.BoolVar(&noSocket, "no-socket", false, "don't listen on a control socket")
+	flags.StringVar(&eventHooks.show, "on-show", "", "shell command to run when the window is shown, with the event in XOVERLAY_* variables")
+	flags.StringVar(&eventHooks.hide, "on-hide", "", "shell command to run when the window is hidden")
+	flags.StringVar(&eventHooks.imageChange, "on-image-change", "", "shell command to run when another image is shown, its path is in XOVERLAY_IMAGE")
+	flags.StringVar(&eventHooks.click, "on-click", "", "shell command to run when the window is clicked, the position and button are in XOVERLAY_X, XOVERLAY_Y and XOVERLAY_BUTTON")
+	flags.BoolVar(&sandbox, "sandbox", false, "once started, only allow access to the images, the config and the state, and forbid running programs")
+	flags.StringVar(&group, "group", "", "share opacity and visibility changes with the other overlays of this group")
+	flags.StringVar(&profile, "profile", "", "use the options of this profile of the config file")
+
+	cmd.AddCommand(newCtlCommand())
+	cmd.AddCommand(newInstallDesktopCommand())
+	cmd.AddCommand(newSheetCommand())
+	cmd.AddCommand(newSnapCommand())
+	cmd.AddCommand(newTestPatternCommand())
+	cmd.AddCommand(newSceneCommand())
+	cmd.AddCommand(newCheckCommand())
 
 	err := cmd.Execute()
+	if err != nil && kioskArgs != nil {
+		return restartKiosk(kioskArgs, err)
+	}
+
 	if err != nil {
 		return fmt.Errorf("run command: %w", err)
 	}
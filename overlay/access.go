@@ -0,0 +1,227 @@
+package overlay
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessControl restricts who can use the network listeners of an overlay,
+// the HTTP API, the webhook and the broadcast, which otherwise let anyone who
+// can reach them put images onto the screen.
+type AccessControl struct {
+	// Token has to be sent with every request as "Authorization: Bearer
+	// <token>", and by receivers of a broadcast when they connect. Requests
+	// need no token if it is empty.
+	Token string
+
+	// TLS serves the listeners over TLS, and only accepts clients with a
+	// certificate if it requires one. See ServerTLS.
+	TLS *tls.Config
+
+	// Commands lists what the listeners may be used for, the paths of the
+	// HTTP API like "opacity" or "image", and "webhook". All are allowed if
+	// it is nil.
+	Commands []string
+
+	// RateLimit is the number of requests, or connections of receivers, a
+	// listener accepts per second. It is unlimited if it is 0.
+	RateLimit float64
+}
+
+// ServerTLS loads the certificate and key of a listener. Only clients with a
+// certificate signed by the certificate authority in caFile are accepted if
+// it is given.
+func ServerTLS(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// ClientTLS is ServerTLS for connecting to a listener: the certificate and
+// key are optional and presented to it, the certificate authority in caFile
+// is trusted to sign its certificate in addition to the ones of the system.
+func ClientTLS(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		err = appendCerts(pool, caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	err := appendCerts(pool, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+func appendCerts(pool *x509.CertPool, caFile string) error {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("read certificate authority: %w", err)
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return nil
+}
+
+// accessGuard applies an AccessControl to one listener.
+type accessGuard struct {
+	access  AccessControl
+	limiter *rateLimiter
+}
+
+func newAccessGuard(access AccessControl) *accessGuard {
+	guard := &accessGuard{access: access}
+	if access.RateLimit > 0 {
+		guard.limiter = newRateLimiter(access.RateLimit)
+	}
+
+	return guard
+}
+
+// listen listens on address, with TLS if it is configured.
+func (guard *accessGuard) listen(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	if guard.access.TLS != nil {
+		return tls.NewListener(listener, guard.access.TLS), nil
+	}
+
+	return listener, nil
+}
+
+func (guard *accessGuard) allowed(command string) bool {
+	return guard.access.Commands == nil || slices.Contains(guard.access.Commands, command)
+}
+
+func (guard *accessGuard) validToken(token string) bool {
+	// compared in constant time, so that the token can't be guessed from
+	// how long the comparison takes
+	return guard.access.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(guard.access.Token)) == 1
+}
+
+// check returns the status and the reason a request has to be refused with,
+// or 0 if it may go ahead.
+func (guard *accessGuard) check(command string, r *http.Request) (int, string) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	switch {
+	case !guard.validToken(token):
+		return http.StatusUnauthorized, "missing or wrong token"
+	case !guard.allowed(command):
+		return http.StatusForbidden, fmt.Sprintf("%s is not allowed", command)
+	case guard.limiter != nil && !guard.limiter.allow():
+		return http.StatusTooManyRequests, "too many requests"
+	}
+
+	return 0, ""
+}
+
+// handler only passes requests for command on to next that may go ahead.
+func (guard *accessGuard) handler(command string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, reason := guard.check(command, r)
+		if status != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(ControlResponse{Error: reason})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// rateLimiter is a token bucket that allows bursts of up to a second worth
+// of requests.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		tokens: max(1, rate),
+		last:   time.Now(),
+	}
+}
+
+func (limiter *rateLimiter) allow() bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	limiter.tokens = min(max(1, limiter.rate), limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+
+	if limiter.tokens < 1 {
+		return false
+	}
+
+	limiter.tokens--
+
+	return true
+}
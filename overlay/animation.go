@@ -0,0 +1,155 @@
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// browsers treat very short frame delays as "as fast as possible" and clamp
+// them to 100ms, we do the same so that animations play at the expected speed.
+const (
+	minFrameDelay     = 20 * time.Millisecond
+	defaultFrameDelay = 100 * time.Millisecond
+)
+
+type animationFrame struct {
+	image image.Image
+	delay time.Duration
+}
+
+func normalizeFrameDelay(delay time.Duration) time.Duration {
+	if delay < minFrameDelay {
+		return defaultFrameDelay
+	}
+
+	return delay
+}
+
+// decodeAnimation returns the fully composed frames of an animated GIF or
+// APNG and the number of times the animation should be played (0 means
+// forever). For still images it returns no frames.
+func decodeAnimation(imageBytes []byte) ([]animationFrame, int, error) {
+	switch {
+	case bytes.HasPrefix(imageBytes, []byte("GIF8")):
+		return decodeGIF(imageBytes)
+	case bytes.HasPrefix(imageBytes, pngSignature):
+		return decodeAPNG(imageBytes)
+	}
+
+	return nil, 0, nil
+}
+
+func decodeGIF(imageBytes []byte) ([]animationFrame, int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode gif: %w", err)
+	}
+
+	if len(g.Image) < 2 {
+		return nil, 0, nil
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]animationFrame, 0, len(g.Image))
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		// gif delays are in 100ths of a second
+		delay := time.Duration(g.Delay[i]) * 10 * time.Millisecond
+
+		frames = append(frames, animationFrame{
+			image: cloneRGBA(canvas),
+			delay: normalizeFrameDelay(delay),
+		})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	// the gif loop count is the number of repetitions after the first play
+	plays := 0
+	switch {
+	case g.LoopCount < 0:
+		plays = 1
+	case g.LoopCount > 0:
+		plays = g.LoopCount + 1
+	}
+
+	return frames, plays, nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+
+	return clone
+}
+
+// frameCache keeps the scaled and converted pixel data of every animation
+// frame for the current window size and opacity, so that looping animations
+// only pay the scaling cost once.
+type frameCache struct {
+	key    animationCacheKey
+	frames map[int][]byte
+}
+
+// animationCacheKey tells apart animations of the same size, every shown
+// animation is decoded anew so its first frame is a different image
+type animationCacheKey struct {
+	frameCacheKey
+	animation image.Image
+}
+
+type frameCacheKey struct {
+	width  int
+	height int
+	// where the whole scaled image lies relative to the pixels we produce,
+	// it is larger when the image is cropped
+	scaled  image.Rectangle
+	opacity float64
+	filter  ScaleFilter
+}
+
+func (cache *frameCache) get(frameIndex int, key animationCacheKey) []byte {
+	if cache.key != key {
+		return nil
+	}
+
+	return cache.frames[frameIndex]
+}
+
+func (cache *frameCache) put(frameIndex int, key animationCacheKey, data []byte) {
+	if cache.frames == nil || cache.key != key {
+		cache.key = key
+		cache.frames = map[int][]byte{}
+	}
+
+	cache.frames[frameIndex] = data
+}
@@ -0,0 +1,469 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Annotations label the overlay, e.g. "v2 mockup" during a screen recording,
+// with text, boxes and lines drawn over the image. Like the guides they are
+// placed in window pixels, no matter how the image is scaled, and are not
+// affected by the opacity.
+
+// AnnotationKind is what an annotation draws.
+type AnnotationKind string
+
+const (
+	AnnotationText AnnotationKind = "text"
+	AnnotationBox  AnnotationKind = "box"
+	AnnotationLine AnnotationKind = "line"
+)
+
+const (
+	DefaultFontSize = 16
+
+	// how thick boxes and lines are drawn
+	annotationStroke = 2
+)
+
+// Annotation is text anchored at Align and moved by From, a box from From to
+// To or a line between them. Text may contain {time}, {date} and {image},
+// which are replaced by the current time, date and the name of the image.
+type Annotation struct {
+	Kind  AnnotationKind
+	Text  string
+	Align Alignment
+	From  image.Point
+	To    image.Point
+	Color color.RGBA
+}
+
+// annotation is an Annotation with what was rendered of it.
+type annotation struct {
+	Annotation
+
+	// whether {time} and the others are drawn as they are
+	literal bool
+
+	// the text as it was rendered, and the text or line
+	rendered string
+	panel    *image.RGBA
+	origin   image.Point
+}
+
+// ParseColor parses colors like "#f80", "#ff8000", "#ff800080" or "orange",
+// and returns them premultiplied.
+func ParseColor(value string) (color.RGBA, error) {
+	if named, ok := colornames.Map[strings.ToLower(value)]; ok {
+		return named, nil
+	}
+
+	hex, ok := strings.CutPrefix(value, "#")
+	if !ok {
+		return color.RGBA{}, fmt.Errorf("unknown color %q", value)
+	}
+
+	// short forms have one digit per channel
+	if len(hex) == 3 || len(hex) == 4 {
+		var long strings.Builder
+		for _, digit := range hex {
+			long.WriteRune(digit)
+			long.WriteRune(digit)
+		}
+
+		hex = long.String()
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	number, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("color %q: expected #rrggbb or #rrggbbaa", value)
+	}
+
+	c := color.NRGBA{uint8(number >> 24), uint8(number >> 16), uint8(number >> 8), uint8(number)}
+
+	return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
+
+// ParseTextPosition parses where text goes, an alignment like "bottom-right",
+// which keeps a small margin to the edges, or the position of its top left
+// corner like "20,40".
+func ParseTextPosition(value string) (Alignment, image.Point, error) {
+	if x, y, ok := strings.Cut(value, ","); ok {
+		point, err := parsePoint(x, y)
+		if err != nil {
+			return Alignment{}, image.Point{}, fmt.Errorf("text position %q: %w", value, err)
+		}
+
+		return Alignment{}, point, nil
+	}
+
+	align, err := ParseAlignment(value)
+	if err != nil {
+		return Alignment{}, image.Point{}, err
+	}
+
+	// away from the edges it is aligned to, towards the middle
+	margin := image.Pt(infoMargin*(1-align.x), infoMargin*(1-align.y))
+
+	return align, margin, nil
+}
+
+// TextAnnotation returns an annotation that draws text at the position
+// parsed by ParseTextPosition.
+func TextAnnotation(text string, position string, c color.RGBA) (Annotation, error) {
+	align, offset, err := ParseTextPosition(position)
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	return Annotation{Kind: AnnotationText, Text: text, Align: align, From: offset, Color: c}, nil
+}
+
+// ParseBox parses boxes like "10,20,200,100,red", their position, size and
+// an optional color that is red by default.
+func ParseBox(value string) (Annotation, error) {
+	numbers, c, err := parseShape(value)
+	if err != nil {
+		return Annotation{}, fmt.Errorf("box %q: %w", value, err)
+	}
+
+	from := image.Pt(numbers[0], numbers[1])
+
+	return Annotation{Kind: AnnotationBox, From: from, To: from.Add(image.Pt(numbers[2], numbers[3])), Color: c}, nil
+}
+
+// ParseLine parses lines like "10,20,200,100,red", their start and end and
+// an optional color that is red by default.
+func ParseLine(value string) (Annotation, error) {
+	numbers, c, err := parseShape(value)
+	if err != nil {
+		return Annotation{}, fmt.Errorf("line %q: %w", value, err)
+	}
+
+	return Annotation{Kind: AnnotationLine, From: image.Pt(numbers[0], numbers[1]), To: image.Pt(numbers[2], numbers[3]), Color: c}, nil
+}
+
+// ParseAnnotation parses annotations as they are sent over the control
+// socket: "box" or "line" followed by what ParseBox and ParseLine parse, or
+// "text", a position and the text, which is drawn in c.
+func ParseAnnotation(value string, c color.RGBA) (Annotation, error) {
+	kind, spec, _ := strings.Cut(strings.TrimSpace(value), " ")
+
+	switch AnnotationKind(kind) {
+	case AnnotationBox:
+		return ParseBox(spec)
+	case AnnotationLine:
+		return ParseLine(spec)
+	case AnnotationText:
+		position, text, ok := strings.Cut(strings.TrimSpace(spec), " ")
+		if !ok {
+			return Annotation{}, fmt.Errorf("text: expected a position and the text")
+		}
+
+		return TextAnnotation(text, position, c)
+	}
+
+	return Annotation{}, fmt.Errorf("unknown annotation %q, expected text, box or line", kind)
+}
+
+// parseShape parses four numbers and an optional color.
+func parseShape(value string) ([4]int, color.RGBA, error) {
+	var numbers [4]int
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 && len(parts) != 5 {
+		return numbers, color.RGBA{}, fmt.Errorf("expected 4 numbers and an optional color")
+	}
+
+	for i := range numbers {
+		number, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+		if err != nil {
+			return numbers, color.RGBA{}, err
+		}
+
+		numbers[i] = number
+	}
+
+	c := color.RGBA{0xff, 0, 0, 0xff}
+	if len(parts) == 5 {
+		var err error
+		c, err = ParseColor(strings.TrimSpace(parts[4]))
+		if err != nil {
+			return numbers, color.RGBA{}, err
+		}
+	}
+
+	return numbers, c, nil
+}
+
+func parsePoint(x string, y string) (image.Point, error) {
+	px, err := strconv.Atoi(strings.TrimSpace(x))
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	py, err := strconv.Atoi(strings.TrimSpace(y))
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	return image.Pt(px, py), nil
+}
+
+// LoadFont loads a TrueType or OpenType font at size pixels, the Go font if
+// path is empty.
+func LoadFont(path string, size float64) (font.Face, error) {
+	data := goregular.TTF
+
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read font: %w", err)
+		}
+	}
+
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font: %w", err)
+	}
+
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("load font: %w", err)
+	}
+
+	return face, nil
+}
+
+// Annotate draws another annotation over the image.
+func (display *Window) Annotate(a Annotation) {
+	display.renderMu.Lock()
+	display.annotations = append(display.annotations, &annotation{Annotation: a})
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// ClearAnnotations removes all annotations.
+func (display *Window) ClearAnnotations() {
+	display.renderMu.Lock()
+	display.annotations = nil
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// setMessage draws text at the bottom of the window until it is replaced,
+// an empty text removes it. It is drawn as it is, like text from a chat.
+func (display *Window) setMessage(text string) {
+	var message *annotation
+	if text != "" {
+		message = &annotation{
+			Annotation: Annotation{
+				Kind:  AnnotationText,
+				Text:  text,
+				Align: Alignment{1, 2},
+				From:  image.Pt(0, -infoMargin),
+				Color: display.options.TextColor,
+			},
+			literal: true,
+		}
+	}
+
+	display.renderMu.Lock()
+	display.message = message
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+func (display *Window) hasAnnotations() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return len(display.annotations) > 0 || display.message != nil
+}
+
+// drawAnnotations draws the annotations onto buf, which holds the visible
+// part of a window of size in the byte order of X.
+func (display *Window) drawAnnotations(buf []byte, visible image.Rectangle, size image.Point) {
+	display.renderMu.Lock()
+	annotations := display.annotations
+	if display.message != nil {
+		annotations = append(slices.Clip(annotations), display.message)
+	}
+
+	source := display.source
+	display.renderMu.Unlock()
+
+	width := visible.Dx()
+	height := visible.Dy()
+
+	for _, a := range annotations {
+		switch a.Kind {
+		case AnnotationText:
+			text := a.Text
+			if !a.literal {
+				text = expandAnnotationText(text, source)
+			}
+
+			if a.panel == nil || text != a.rendered {
+				a.panel = renderAnnotationText(display.textFace(), text, a.Color)
+				a.rendered = text
+			}
+
+			bounds := a.panel.Bounds()
+			origin := placeImage(ScaleCenter, a.Align, bounds.Dx(), bounds.Dy(), size.X, size.Y).Min.Add(a.From)
+			drawPanel(buf, width, height, a.panel, origin.Sub(visible.Min))
+		case AnnotationBox:
+			drawBox(buf, visible, image.Rectangle{Min: a.From, Max: a.To}.Canon(), a.Color)
+		case AnnotationLine:
+			if a.panel == nil {
+				a.panel, a.origin = renderLine(a.From, a.To, a.Color)
+			}
+
+			drawPanel(buf, width, height, a.panel, a.origin.Sub(visible.Min))
+		}
+	}
+}
+
+// textFace returns the font of text annotations.
+func (display *Window) textFace() font.Face {
+	if display.options.Font != nil {
+		return display.options.Font
+	}
+
+	return defaultFace()
+}
+
+// defaultFace is the Go font at the default size with the fallbacks of
+// fontconfig, the embedded font always parses.
+var defaultFace = sync.OnceValue(func() font.Face {
+	face, _ := LoadFontSet("", DefaultFontSize)
+	return face
+})
+
+func expandAnnotationText(text string, source string) string {
+	if !strings.Contains(text, "{") {
+		return text
+	}
+
+	now := time.Now()
+
+	return strings.NewReplacer(
+		"{time}", now.Format(time.TimeOnly),
+		"{date}", now.Format(time.DateOnly),
+		"{image}", filepath.Base(source),
+	).Replace(text)
+}
+
+// renderAnnotationText draws the lines of text on a dark background, like
+// the labels of the images on a board.
+func renderAnnotationText(face font.Face, text string, c color.RGBA) *image.RGBA {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = withoutInvisible(visualOrder(line))
+	}
+
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, font.MeasureString(face, line).Ceil())
+	}
+
+	panel := image.NewRGBA(image.Rect(0, 0, width+2*labelPadding, len(lines)*lineHeight+2*labelPadding))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	drawer := font.Drawer{
+		Dst:  panel,
+		Src:  image.NewUniform(c),
+		Face: face,
+	}
+
+	for i, line := range lines {
+		drawer.Dot = fixed.Point26_6{
+			X: fixed.I(labelPadding),
+			Y: fixed.I(labelPadding+i*lineHeight) + metrics.Ascent,
+		}
+		drawText(&drawer, line)
+	}
+
+	return panel
+}
+
+// drawBox draws the outline of box, in window pixels, onto buf.
+func drawBox(buf []byte, visible image.Rectangle, box image.Rectangle, c color.RGBA) {
+	width := visible.Dx()
+
+	edges := []image.Rectangle{
+		image.Rect(box.Min.X, box.Min.Y, box.Max.X, box.Min.Y+annotationStroke),
+		image.Rect(box.Min.X, box.Max.Y-annotationStroke, box.Max.X, box.Max.Y),
+		image.Rect(box.Min.X, box.Min.Y+annotationStroke, box.Min.X+annotationStroke, box.Max.Y-annotationStroke),
+		image.Rect(box.Max.X-annotationStroke, box.Min.Y+annotationStroke, box.Max.X, box.Max.Y-annotationStroke),
+	}
+
+	for _, edge := range edges {
+		edge = edge.Intersect(box).Intersect(visible)
+		for y := edge.Min.Y; y < edge.Max.Y; y++ {
+			for x := edge.Min.X; x < edge.Max.X; x++ {
+				blendPixel(buf[((y-visible.Min.Y)*width+x-visible.Min.X)*4:], c)
+			}
+		}
+	}
+}
+
+// renderLine draws an antialiased line from one point to another, and
+// returns it with the window position of its top left corner.
+func renderLine(from image.Point, to image.Point, c color.RGBA) (*image.RGBA, image.Point) {
+	bounds := image.Rectangle{Min: from, Max: to}.Canon().Inset(-annotationStroke)
+	panel := image.NewRGBA(image.Rectangle{Max: bounds.Size()})
+
+	dx := float64(to.X - from.X)
+	dy := float64(to.Y - from.Y)
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return panel, bounds.Min
+	}
+
+	// the corners of the stroke, half of it on either side
+	nx := -dy / length * annotationStroke / 2
+	ny := dx / length * annotationStroke / 2
+
+	point := func(p image.Point, sign float64) (float32, float32) {
+		return float32(float64(p.X-bounds.Min.X) + 0.5 + sign*nx), float32(float64(p.Y-bounds.Min.Y) + 0.5 + sign*ny)
+	}
+
+	rasterizer := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	rasterizer.MoveTo(point(from, 1))
+	rasterizer.LineTo(point(to, 1))
+	rasterizer.LineTo(point(to, -1))
+	rasterizer.LineTo(point(from, -1))
+	rasterizer.ClosePath()
+	rasterizer.Draw(panel, panel.Bounds(), image.NewUniform(c), image.Point{})
+
+	return panel, bounds.Min
+}
@@ -0,0 +1,56 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	display := &Window{
+		renderWake: make(chan struct{}, 1),
+		now:        time.Now,
+		options:    Options{TextColor: color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+	}
+
+	display.Annotate(Annotation{Kind: AnnotationText, Text: "{image}", Align: AlignCenter})
+	display.setMessage("build {time} failed")
+
+	size := image.Pt(400, 200)
+	buf := make([]byte, size.X*size.Y*4)
+	display.drawAnnotations(buf, image.Rectangle{Max: size}, size)
+
+	message := display.message
+	if message == nil {
+		t.Fatal("no message")
+	}
+
+	if message.rendered != "build {time} failed" {
+		t.Errorf("message rendered as %q", message.rendered)
+	}
+
+	if display.annotations[0].rendered != "." {
+		t.Errorf("annotation rendered as %q, want the expanded placeholder", display.annotations[0].rendered)
+	}
+
+	// at the bottom, in the middle
+	bounds := message.panel.Bounds()
+	if bounds.Dx() >= size.X || bounds.Dy() >= size.Y/2 {
+		t.Fatalf("message of %v", bounds)
+	}
+
+	drawn := func(x int, y int) bool {
+		i := (y*size.X + x) * 4
+		return buf[i+3] != 0
+	}
+
+	if !drawn(size.X/2, size.Y-infoMargin-bounds.Dy()/2) || drawn(size.X/2, infoMargin) {
+		t.Error("message not drawn at the bottom")
+	}
+
+	display.setMessage("")
+	if display.message != nil || !display.hasAnnotations() {
+		t.Error("message not removed without the annotations")
+	}
+}
@@ -0,0 +1,282 @@
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// the largest animation that is decoded, every frame is kept composed at
+// the size of the canvas: 8192x8192 pixels, or as many frames of a smaller
+// canvas
+const (
+	maxAnimationPixels      = 8192 * 8192
+	maxAnimationFramePixels = 4 * maxAnimationPixels
+)
+
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+type apngFrame struct {
+	width, height    int
+	xOffset, yOffset int
+	delay            time.Duration
+	disposeOp        byte
+	blendOp          byte
+	data             [][]byte
+}
+
+// pngInt reads a four-byte unsigned integer of PNG, which is at most 2^31-1
+// and so fits into an int on 32 bit platforms too. Larger ones are invalid
+// and returned as -1.
+func pngInt(data []byte) int {
+	v := binary.BigEndian.Uint32(data)
+	if v > math.MaxInt32 {
+		return -1
+	}
+
+	return int(v)
+}
+
+func readPNGChunks(imageBytes []byte) ([]pngChunk, error) {
+	rest := imageBytes[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(rest) >= 12 {
+		length := pngInt(rest[0:4])
+		if length < 0 || length > len(rest)-12 {
+			return nil, fmt.Errorf("chunk length %d exceeds remaining data", length)
+		}
+
+		chunk := pngChunk{
+			typ:  string(rest[4:8]),
+			data: rest[8 : 8+length],
+		}
+
+		if crc32.ChecksumIEEE(rest[4:8+length]) != binary.BigEndian.Uint32(rest[8+length:]) {
+			return nil, fmt.Errorf("checksum mismatch in %s chunk", chunk.typ)
+		}
+
+		chunks = append(chunks, chunk)
+
+		rest = rest[12+length:]
+	}
+
+	return chunks, nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	copy(header[4:8], typ)
+	buf.Write(header[:])
+	buf.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:8])
+	crc.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
+
+func parseFctl(data []byte) (apngFrame, error) {
+	if len(data) < 26 {
+		return apngFrame{}, fmt.Errorf("fcTL chunk too short")
+	}
+
+	delayNum := binary.BigEndian.Uint16(data[20:22])
+	delayDen := binary.BigEndian.Uint16(data[22:24])
+	if delayDen == 0 {
+		delayDen = 100
+	}
+
+	width, height := pngInt(data[4:8]), pngInt(data[8:12])
+	xOffset, yOffset := pngInt(data[12:16]), pngInt(data[16:20])
+	if width < 0 || height < 0 || xOffset < 0 || yOffset < 0 {
+		return apngFrame{}, fmt.Errorf("fcTL chunk out of range")
+	}
+
+	return apngFrame{
+		width:     width,
+		height:    height,
+		xOffset:   xOffset,
+		yOffset:   yOffset,
+		delay:     time.Duration(delayNum) * time.Second / time.Duration(delayDen),
+		disposeOp: data[24],
+		blendOp:   data[25],
+	}, nil
+}
+
+// decodeAPNG splits an APNG into standalone PNG streams, one per frame, and
+// composes them according to the dispose and blend operations of each frame.
+// Regular PNG files without an acTL chunk yield no frames.
+func decodeAPNG(imageBytes []byte) ([]animationFrame, int, error) {
+	chunks, err := readPNGChunks(imageBytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read png chunks: %w", err)
+	}
+
+	var (
+		ihdr     []byte
+		shared   []pngChunk
+		animated bool
+		plays    int
+		frames   []apngFrame
+		seenIDAT bool
+	)
+
+	for _, chunk := range chunks {
+		switch chunk.typ {
+		case "IHDR":
+			ihdr = chunk.data
+		case "acTL":
+			if len(chunk.data) < 8 {
+				return nil, 0, fmt.Errorf("acTL chunk too short")
+			}
+			animated = true
+			// -1 for an invalid count, which plays forever like 0
+			plays = max(0, pngInt(chunk.data[4:8]))
+		case "fcTL":
+			frame, err := parseFctl(chunk.data)
+			if err != nil {
+				return nil, 0, err
+			}
+			frames = append(frames, frame)
+		case "IDAT":
+			seenIDAT = true
+			// the default image is only part of the animation if it is
+			// preceded by a fcTL chunk
+			if len(frames) > 0 {
+				frames[len(frames)-1].data = append(frames[len(frames)-1].data, chunk.data)
+			}
+		case "fdAT":
+			if len(frames) > 0 && len(chunk.data) >= 4 {
+				frames[len(frames)-1].data = append(frames[len(frames)-1].data, chunk.data[4:])
+			}
+		case "IEND":
+		default:
+			if !seenIDAT {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+
+	if !animated || len(frames) < 2 || len(ihdr) < 13 {
+		return nil, 0, nil
+	}
+
+	width, height := pngInt(ihdr[0:4]), pngInt(ihdr[4:8])
+	if width <= 0 || height <= 0 {
+		return nil, 0, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	if width > maxAnimationPixels/height || len(frames) > maxAnimationFramePixels/(width*height) {
+		return nil, 0, fmt.Errorf("%d frames of %dx%d pixels are too large to animate", len(frames), width, height)
+	}
+
+	bounds := image.Rect(0, 0, width, height)
+	for i, frame := range frames {
+		region := image.Rect(0, 0, frame.width, frame.height).Add(image.Pt(frame.xOffset, frame.yOffset))
+		if region.Empty() || !region.In(bounds) {
+			return nil, 0, fmt.Errorf("frame %d at %v is outside of the %dx%d canvas", i, region, width, height)
+		}
+	}
+
+	// the first frame has to decode before memory is spent on the canvas
+	first, err := decodeAPNGFrame(ihdr, shared, frames[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode frame 0: %w", err)
+	}
+
+	canvas := image.NewRGBA(bounds)
+	result := make([]animationFrame, 0, len(frames))
+
+	for i, frame := range frames {
+		img := first
+		if i > 0 {
+			img, err = decodeAPNGFrame(ihdr, shared, frame)
+			if err != nil {
+				return nil, 0, fmt.Errorf("decode frame %d: %w", i, err)
+			}
+		}
+
+		region := image.Rect(0, 0, frame.width, frame.height).Add(image.Pt(frame.xOffset, frame.yOffset))
+
+		disposeOp := frame.disposeOp
+		if i == 0 && disposeOp == apngDisposePrevious {
+			disposeOp = apngDisposeBackground
+		}
+
+		var previous *image.RGBA
+		if disposeOp == apngDisposePrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		op := draw.Over
+		if frame.blendOp == apngBlendSource {
+			op = draw.Src
+		}
+
+		draw.Draw(canvas, region, img, img.Bounds().Min, op)
+
+		result = append(result, animationFrame{
+			image: cloneRGBA(canvas),
+			delay: normalizeFrameDelay(frame.delay),
+		})
+
+		switch disposeOp {
+		case apngDisposeBackground:
+			draw.Draw(canvas, region, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+
+	return result, plays, nil
+}
+
+func decodeAPNGFrame(ihdr []byte, shared []pngChunk, frame apngFrame) (image.Image, error) {
+	frameIHDR := bytes.Clone(ihdr)
+	binary.BigEndian.PutUint32(frameIHDR[0:4], uint32(frame.width))
+	binary.BigEndian.PutUint32(frameIHDR[4:8], uint32(frame.height))
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writePNGChunk(&buf, "IHDR", frameIHDR)
+
+	for _, chunk := range shared {
+		writePNGChunk(&buf, chunk.typ, chunk.data)
+	}
+
+	writePNGChunk(&buf, "IDAT", bytes.Join(frame.data, nil))
+	writePNGChunk(&buf, "IEND", nil)
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("decode png: %w", err)
+	}
+
+	return img, nil
+}
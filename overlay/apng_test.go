@@ -0,0 +1,144 @@
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testAPNG builds a two frame animation of a width by height canvas, the
+// second frame of the size and at the offset of second.
+func testAPNG(t *testing.T, width int, height int, second image.Rectangle) []byte {
+	t.Helper()
+
+	encode := func(size image.Point, c color.RGBA) (ihdr []byte, idat []byte) {
+		img := image.NewRGBA(image.Rectangle{Max: size})
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, chunk := range chunks {
+			switch chunk.typ {
+			case "IHDR":
+				ihdr = chunk.data
+			case "IDAT":
+				idat = append(idat, chunk.data...)
+			}
+		}
+
+		return ihdr, idat
+	}
+
+	fctl := func(sequence int, region image.Rectangle) []byte {
+		data := make([]byte, 26)
+		binary.BigEndian.PutUint32(data[0:], uint32(sequence))
+		binary.BigEndian.PutUint32(data[4:], uint32(region.Dx()))
+		binary.BigEndian.PutUint32(data[8:], uint32(region.Dy()))
+		binary.BigEndian.PutUint32(data[12:], uint32(region.Min.X))
+		binary.BigEndian.PutUint32(data[16:], uint32(region.Min.Y))
+		binary.BigEndian.PutUint16(data[20:], 1)
+		binary.BigEndian.PutUint16(data[22:], 10)
+
+		return data
+	}
+
+	ihdr, first := encode(image.Pt(width, height), color.RGBA{R: 255, A: 255})
+	_, next := encode(second.Size(), color.RGBA{B: 255, A: 255})
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl, 2)
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writePNGChunk(&buf, "IHDR", ihdr)
+	writePNGChunk(&buf, "acTL", actl)
+	writePNGChunk(&buf, "fcTL", fctl(0, image.Rect(0, 0, width, height)))
+	writePNGChunk(&buf, "IDAT", first)
+	writePNGChunk(&buf, "fcTL", fctl(1, second))
+	writePNGChunk(&buf, "fdAT", append([]byte{0, 0, 0, 2}, next...))
+	writePNGChunk(&buf, "IEND", nil)
+
+	return buf.Bytes()
+}
+
+func TestDecodeAPNG(t *testing.T) {
+	data := testAPNG(t, 16, 8, image.Rect(4, 2, 8, 6))
+
+	frames, plays, err := decodeAPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 2 || plays != 0 {
+		t.Fatalf("%d frames played %d times, want 2 played forever", len(frames), plays)
+	}
+
+	last := frames[1].image
+	if last.Bounds() != image.Rect(0, 0, 16, 8) {
+		t.Errorf("bounds %v, want 16x8", last.Bounds())
+	}
+
+	if r, _, b, _ := last.At(5, 3).RGBA(); r != 0 || b != 0xffff {
+		t.Errorf("second frame not drawn over the first at 5,3")
+	}
+
+	if r, _, _, _ := last.At(0, 0).RGBA(); r != 0xffff {
+		t.Errorf("first frame not kept outside of the second at 0,0")
+	}
+}
+
+func TestDecodeAPNGBroken(t *testing.T) {
+	// a canvas of 2^31-1 by 2^31-1 pixels with valid checksums
+	chunks, err := readPNGChunks(testAPNG(t, 4, 4, image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var huge bytes.Buffer
+	huge.Write(pngSignature)
+	for _, chunk := range chunks {
+		if chunk.typ == "IHDR" {
+			chunk.data = bytes.Clone(chunk.data)
+			binary.BigEndian.PutUint32(chunk.data[0:], 0x7fffffff)
+			binary.BigEndian.PutUint32(chunk.data[4:], 0x7fffffff)
+		}
+
+		writePNGChunk(&huge, chunk.typ, chunk.data)
+	}
+
+	// in the width of the IHDR chunk
+	changed := testAPNG(t, 4, 4, image.Rect(0, 0, 4, 4))
+	changed[len(pngSignature)+8] ^= 0x40
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"changed byte", changed},
+		{"huge canvas", huge.Bytes()},
+		{"frame outside of the canvas", testAPNG(t, 8, 8, image.Rect(6, 6, 10, 10))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := decodeAPNG(test.data)
+			if err == nil {
+				t.Error("decoded without an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"sync"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// backBuffer is an off-screen pixmap that frames are rendered into before
+// they are copied onto the window in a single CopyArea, margins included, so
+// that the window never shows a frame that is only partly drawn. The pixmap
+// only grows, resizing the window back and forth doesn't create new ones.
+type backBuffer struct {
+	mu     sync.Mutex
+	pixmap xproto.Pixmap
+	// the size of the pixmap and of the frame last drawn into it
+	size  image.Point
+	frame image.Point
+}
+
+// backBufferFor returns a pixmap of at least size. Its contents are
+// undefined.
+func (display *Window) backBufferFor(size image.Point) (xproto.Pixmap, error) {
+	buffer := &display.backBuffer
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	if buffer.pixmap != 0 && size.X <= buffer.size.X && size.Y <= buffer.size.Y {
+		return buffer.pixmap, nil
+	}
+
+	if buffer.pixmap != 0 {
+		xproto.FreePixmap(display.conn, buffer.pixmap)
+		buffer.pixmap = 0
+	}
+
+	// grown in both directions at once, resizing usually changes both
+	size = image.Pt(max(size.X, buffer.size.X), max(size.Y, buffer.size.Y))
+
+	area, err := xRectangle(image.Rectangle{Max: size})
+	if err != nil {
+		return 0, fmt.Errorf("create pixmap: %w", err)
+	}
+
+	pixmap, err := xproto.NewPixmapId(display.conn)
+	if err != nil {
+		return 0, fmt.Errorf("new pixmap id: %w", err)
+	}
+
+	err = xproto.CreatePixmapChecked(display.conn, display.depth, pixmap, xproto.Drawable(display.windowID), area.Width, area.Height).Check()
+	if err != nil {
+		return 0, fmt.Errorf("create pixmap: %w", err)
+	}
+
+	buffer.pixmap = pixmap
+	buffer.size = size
+	buffer.frame = image.Point{}
+
+	return pixmap, nil
+}
+
+// clearMargins fills the parts of window outside of visible in drawable with
+// the background, transparent black.
+func (display *Window) clearMargins(drawable xproto.Drawable, gc xproto.Gcontext, window image.Rectangle, visible image.Rectangle) error {
+	// the margins are inside of the window
+	_, err := xRectangle(window)
+	if err != nil {
+		return fmt.Errorf("clear margins: %w", err)
+	}
+
+	var margins []xproto.Rectangle
+
+	add := func(r image.Rectangle) {
+		if !r.Empty() {
+			margin, _ := xRectangle(r)
+			margins = append(margins, margin)
+		}
+	}
+
+	visible = visible.Intersect(window)
+
+	if visible.Empty() {
+		add(window)
+	} else {
+		add(image.Rect(window.Min.X, window.Min.Y, window.Max.X, visible.Min.Y))
+		add(image.Rect(window.Min.X, visible.Max.Y, window.Max.X, window.Max.Y))
+		add(image.Rect(window.Min.X, visible.Min.Y, visible.Min.X, visible.Max.Y))
+		add(image.Rect(visible.Max.X, visible.Min.Y, window.Max.X, visible.Max.Y))
+	}
+
+	if len(margins) > 0 {
+		// the default foreground of a graphics context is pixel 0
+		xproto.PolyFillRectangle(display.conn, drawable, gc, margins)
+	}
+
+	return nil
+}
+
+// presentEmpty shows a frame without any of the image, e.g. when it is panned
+// out of the window.
+func (display *Window) presentEmpty(gc xproto.Gcontext, window image.Rectangle) error {
+	pixmap, err := display.backBufferFor(window.Size())
+	if err != nil {
+		return err
+	}
+
+	err = display.clearMargins(xproto.Drawable(pixmap), gc, window, image.Rectangle{})
+	if err != nil {
+		return err
+	}
+
+	return display.presentFrame(gc, window.Size())
+}
+
+// presentFrame copies the frame of size that was rendered into the back
+// buffer onto the window.
+func (display *Window) presentFrame(gc xproto.Gcontext, size image.Point) error {
+	buffer := &display.backBuffer
+
+	buffer.mu.Lock()
+	buffer.frame = size
+	pixmap := buffer.pixmap
+	buffer.mu.Unlock()
+
+	area, err := xRectangle(image.Rectangle{Max: size})
+	if err != nil {
+		return fmt.Errorf("copy frame: %w", err)
+	}
+
+	err = xproto.CopyAreaChecked(
+		display.conn,
+		xproto.Drawable(pixmap),
+		xproto.Drawable(display.windowID),
+		gc,
+		0, 0, // src
+		0, 0, // dst
+		area.Width,
+		area.Height,
+	).Check()
+	if err != nil {
+		return fmt.Errorf("copy frame: %w", err)
+	}
+
+	return nil
+}
+
+// exposed draws the last frame again where the window lost its contents,
+// the window has no background that the server would paint. Parts that the
+// frame doesn't cover, after the window grew, are cleared until the next
+// frame is rendered.
+func (display *Window) exposed(area image.Rectangle) {
+	buffer := &display.backBuffer
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	gc, err := display.resources.gc(display.depth, xproto.Drawable(display.windowID))
+	if err != nil {
+		slog.Error("redraw exposed area", "err", err)
+		return
+	}
+
+	covered := area.Intersect(image.Rectangle{Max: buffer.frame})
+	if !covered.Empty() {
+		r, err := xRectangle(covered)
+		if err != nil {
+			slog.Error("redraw exposed area", "err", err)
+			return
+		}
+
+		xproto.CopyArea(
+			display.conn,
+			xproto.Drawable(buffer.pixmap),
+			xproto.Drawable(display.windowID),
+			gc,
+			r.X, r.Y,
+			r.X, r.Y,
+			r.Width,
+			r.Height,
+		)
+	}
+
+	err = display.clearMargins(xproto.Drawable(display.windowID), gc, area, covered)
+	if err != nil {
+		slog.Error("redraw exposed area", "err", err)
+	}
+}
@@ -0,0 +1,364 @@
+package overlay
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb/composite"
+	"github.com/jezek/xgb/xproto"
+)
+
+type BlendMode string
+
+const (
+	// leave the blending to the compositor
+	BlendNormal     BlendMode = "normal"
+	BlendDifference BlendMode = "difference"
+	BlendMultiply   BlendMode = "multiply"
+	BlendScreen     BlendMode = "screen"
+)
+
+var blendModes = []BlendMode{BlendNormal, BlendDifference, BlendMultiply, BlendScreen}
+
+func ParseBlendMode(name string) (BlendMode, error) {
+	for _, mode := range blendModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown blend mode %q", name)
+}
+
+// the screen below the window is captured this often, the other windows
+// don't tell us when they change
+const backdropInterval = 250 * time.Millisecond
+
+// backdrop is the screen content below the window, which the image is
+// blended with. It is put together from the windows below ours instead of
+// read from the screen, the screen contains the overlay itself.
+type backdrop struct {
+	mu sync.Mutex
+	// bgra pixels of the area below the window, captured at size
+	pix  []byte
+	size image.Point
+
+	changed chan struct{}
+}
+
+// startBackdrop captures the screen below the window until ctx is done. It
+// has to be called after CreateWindow.
+func (display *Window) startBackdrop(ctx context.Context) error {
+	err := display.redirectWindows()
+	if err != nil {
+		return err
+	}
+
+	display.backdrop = &backdrop{changed: make(chan struct{}, 1)}
+
+	go display.runBackdrop(ctx)
+
+	return nil
+}
+
+// redirectWindows keeps the contents of all windows available even where
+// they are covered, so that the screen below ours can be put together. A
+// running compositor redirects them already.
+func (display *Window) redirectWindows() error {
+	if display.redirected {
+		return nil
+	}
+
+	err := composite.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init composite: %w", err)
+	}
+
+	_, err = composite.QueryVersion(display.conn, 0, 4).Reply()
+	if err != nil {
+		return fmt.Errorf("query composite version: %w", err)
+	}
+
+	err = composite.RedirectSubwindowsChecked(display.conn, display.screen.Root, composite.RedirectAutomatic).Check()
+	if err != nil {
+		return fmt.Errorf("redirect windows: %w", err)
+	}
+
+	display.redirected = true
+
+	return nil
+}
+
+func (display *Window) runBackdrop(ctx context.Context) {
+	for {
+		err := display.captureBackdrop()
+		if err != nil && ctx.Err() == nil {
+			slog.Error("capture screen", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-display.backdrop.changed:
+		case <-time.After(display.debounce(backdropInterval)):
+		}
+	}
+}
+
+// moved schedules a capture unless one is pending already.
+func (b *backdrop) moved() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (b *backdrop) get() ([]byte, image.Point) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.pix, b.size
+}
+
+func (display *Window) captureBackdrop() error {
+	conn := display.conn
+	root := display.screen.Root
+
+	geom, err := xproto.GetGeometry(conn, xproto.Drawable(display.windowID)).Reply()
+	if err != nil {
+		return fmt.Errorf("get geometry: %w", err)
+	}
+
+	position, err := xproto.TranslateCoordinates(conn, display.windowID, root, 0, 0).Reply()
+	if err != nil {
+		return fmt.Errorf("translate coordinates: %w", err)
+	}
+
+	region := image.Rect(0, 0, int(geom.Width), int(geom.Height)).Add(image.Pt(int(position.DstX), int(position.DstY)))
+
+	pix, err := display.captureBelow(region)
+	if err != nil {
+		return err
+	}
+
+	display.backdrop.mu.Lock()
+	display.backdrop.pix = pix
+	display.backdrop.size = region.Size()
+	display.backdrop.mu.Unlock()
+
+	display.requestRedraw()
+
+	return nil
+}
+
+// captureBelow returns the opaque bgra pixels of region of the screen, as
+// the windows below ours show it.
+func (display *Window) captureBelow(region image.Rectangle) ([]byte, error) {
+	conn := display.conn
+	root := display.screen.Root
+	pix := make([]byte, region.Dx()*region.Dy()*4)
+
+	// the wallpaper, if the program that set it tells us where it is
+	wallpaper, err := display.atom("_XROOTPMAP_ID")
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := xproto.GetProperty(conn, false, root, wallpaper, xproto.AtomPixmap, 0, 1).Reply()
+	if err == nil && len(reply.Value) == 4 {
+		pixmap := xproto.Pixmap(uint32(reply.Value[0]) | uint32(reply.Value[1])<<8 | uint32(reply.Value[2])<<16 | uint32(reply.Value[3])<<24)
+		display.drawBackdropLayer(pix, region, xproto.Drawable(pixmap), image.Point{})
+	}
+
+	top, err := display.topLevelWindow(display.windowID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := xproto.QueryTree(conn, root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("query tree: %w", err)
+	}
+
+	// children are listed from bottom to top
+	for _, child := range tree.Children {
+		if child == top {
+			break
+		}
+
+		display.drawBackdropWindow(pix, region, child)
+	}
+
+	for i := 3; i < len(pix); i += 4 {
+		pix[i] = 0xff
+	}
+
+	return pix, nil
+}
+
+// drawBackdropWindow draws the part of window within region onto pix.
+// Windows can disappear at any time, so errors just leave them out.
+func (display *Window) drawBackdropWindow(pix []byte, region image.Rectangle, window xproto.Window) {
+	conn := display.conn
+
+	attributes, err := xproto.GetWindowAttributes(conn, window).Reply()
+	if err != nil || attributes.MapState != xproto.MapStateViewable || attributes.Class == xproto.WindowClassInputOnly {
+		return
+	}
+
+	geom, err := xproto.GetGeometry(conn, xproto.Drawable(window)).Reply()
+	if err != nil {
+		return
+	}
+
+	// the pixmap of a window includes its border
+	border := int(geom.BorderWidth) * 2
+	origin := image.Pt(int(geom.X), int(geom.Y))
+	bounds := image.Rect(0, 0, int(geom.Width)+border, int(geom.Height)+border).Add(origin)
+
+	if !bounds.Overlaps(region) {
+		return
+	}
+
+	pixmap, err := xproto.NewPixmapId(conn)
+	if err != nil {
+		return
+	}
+
+	err = composite.NameWindowPixmapChecked(conn, window, pixmap).Check()
+	if err != nil {
+		return
+	}
+	defer xproto.FreePixmap(conn, pixmap)
+
+	display.drawBackdropLayer(pix, region, xproto.Drawable(pixmap), origin)
+}
+
+// drawBackdropLayer draws the part of drawable within region onto pix,
+// origin is the position of drawable on the screen.
+func (display *Window) drawBackdropLayer(pix []byte, region image.Rectangle, drawable xproto.Drawable, origin image.Point) {
+	conn := display.conn
+
+	geom, err := xproto.GetGeometry(conn, drawable).Reply()
+	if err != nil {
+		return
+	}
+
+	area := image.Rect(0, 0, int(geom.Width), int(geom.Height)).Add(origin).Intersect(region)
+	if area.Empty() {
+		return
+	}
+
+	const allPlanes = 0xffffffff
+
+	r, err := xRectangle(area.Sub(origin))
+	if err != nil {
+		return
+	}
+
+	reply, err := xproto.GetImage(
+		conn,
+		xproto.ImageFormatZPixmap,
+		drawable,
+		r.X,
+		r.Y,
+		r.Width,
+		r.Height,
+		allPlanes,
+	).Reply()
+	if err != nil || len(reply.Data) < area.Dx()*area.Dy()*4 {
+		return
+	}
+
+	rowSize := region.Dx() * 4
+	areaRowSize := area.Dx() * 4
+
+	for y := range area.Dy() {
+		src := reply.Data[y*areaRowSize : (y+1)*areaRowSize]
+		offset := (area.Min.Y-region.Min.Y+y)*rowSize + (area.Min.X-region.Min.X)*4
+		dst := pix[offset : offset+areaRowSize]
+
+		// without an alpha channel the fourth byte is garbage
+		if reply.Depth != depthWithAlpha {
+			copy(dst, src)
+			continue
+		}
+
+		// premultiplied source over destination
+		for i := 0; i < len(src); i += 4 {
+			inverse := uint32(0xff - src[i+3])
+			dst[i+0] = src[i+0] + byte(uint32(dst[i+0])*inverse/0xff)
+			dst[i+1] = src[i+1] + byte(uint32(dst[i+1])*inverse/0xff)
+			dst[i+2] = src[i+2] + byte(uint32(dst[i+2])*inverse/0xff)
+		}
+	}
+}
+
+var opaqueBlack = [4]byte{0, 0, 0, 0xff}
+
+// blendBackdrop blends the premultiplied pixels in pix, which cover rect of
+// the window, with the backdrop. The result is opaque. Parts of the window
+// that have not been captured yet are treated as black.
+func blendBackdrop(pix []byte, rect image.Rectangle, backdrop []byte, size image.Point, mode BlendMode, diff *diffView, threads int) {
+	rowSize := rect.Dx() * 4
+
+	forEachRowChunk(rect.Dy(), rowSize, threads, func(start int, end int) {
+		for y := start; y < end; y++ {
+			row := pix[y*rowSize : (y+1)*rowSize]
+			backdropY := rect.Min.Y + y
+
+			for x := range rect.Dx() {
+				i := x * 4
+				backdropX := rect.Min.X + x
+
+				b := opaqueBlack[:]
+				if backdropX < size.X && backdropY < size.Y {
+					offset := (backdropY*size.X + backdropX) * 4
+					b = backdrop[offset : offset+4]
+				}
+
+				alpha := uint32(row[i+3])
+				if diff != nil {
+					blendDifference(row[i:i+4], b, row[i:i+4], alpha, *diff)
+					continue
+				}
+
+				for c := range 3 {
+					row[i+c] = blendChannel(uint32(b[c]), uint32(row[i+c]), alpha, mode)
+				}
+				row[i+3] = 0xff
+			}
+		}
+	})
+}
+
+// blendChannel blends the premultiplied source s with coverage alpha onto
+// the opaque backdrop b, all values from 0 to 255.
+func blendChannel(b uint32, s uint32, alpha uint32, mode BlendMode) byte {
+	// the backdrop shows through where the source doesn't cover it
+	uncovered := b * (0xff - alpha) / 0xff
+
+	var result uint32
+
+	switch mode {
+	case BlendDifference:
+		covered := b * alpha / 0xff
+		if covered > s {
+			result = uncovered + covered - s
+		} else {
+			result = uncovered + s - covered
+		}
+	case BlendMultiply:
+		result = uncovered + b*s/0xff
+	case BlendScreen:
+		result = b + s - b*s/0xff
+	default:
+		result = uncovered + s
+	}
+
+	return byte(min(result, 0xff))
+}
@@ -0,0 +1,85 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// A blueprint shows the structure of a mockup in a single translucent
+// color, so that its colors don't fight with the ones of the UI below: the
+// pixels on the other side of the brightness threshold than most of the
+// image, the lines, text and shapes on a light or a dark background, are
+// drawn in the tint and the background is left out.
+
+// the threshold of blueprints if ColorFilters.Threshold isn't set
+const defaultBlueprintThreshold = 128
+
+// DefaultBlueprintColor is a translucent blue.
+var DefaultBlueprintColor = color.RGBA{0x14, 0x46, 0xa0, 0xa0}
+
+// blueprintImage returns the pixels of img on the other side of threshold
+// than most of them in tint, which is premultiplied, and transparent
+// everywhere else.
+func blueprintImage(img image.Image, tint color.RGBA, threshold int) *image.RGBA {
+	if threshold <= 0 {
+		threshold = defaultBlueprintThreshold
+	}
+
+	bounds := img.Bounds()
+
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	// transparent pixels are neither, they stay transparent
+	bright := make([]int8, len(src.Pix)/4)
+	balance := 0
+
+	for i := range bright {
+		pixel := src.Pix[i*4 : i*4+4]
+		if pixel[3] < 0x80 {
+			continue
+		}
+
+		// Rec. 601 luma, like the other filters
+		luma := (19595*int(pixel[0]) + 38470*int(pixel[1]) + 7471*int(pixel[2]) + 1<<15) >> 16
+
+		bright[i] = -1
+		if luma >= threshold {
+			bright[i] = 1
+		}
+
+		balance += int(bright[i])
+	}
+
+	// dark lines on a light background, or light ones on a dark one
+	ink := int8(-1)
+	if balance < 0 {
+		ink = 1
+	}
+
+	blueprint := image.NewRGBA(src.Rect)
+	for i, side := range bright {
+		if side == ink {
+			copy(blueprint.Pix[i*4:i*4+4], []uint8{tint.R, tint.G, tint.B, tint.A})
+		}
+	}
+
+	return blueprint
+}
+
+// toggleBlueprint shows the image as a blueprint, or as it is again.
+func (display *Window) toggleBlueprint() {
+	filters := display.currentColorFilters()
+
+	if filters.Blueprint.A > 0 {
+		filters.Blueprint = color.RGBA{}
+	} else {
+		filters.Blueprint = display.options.Filters.Blueprint
+		if filters.Blueprint.A == 0 {
+			filters.Blueprint = DefaultBlueprintColor
+		}
+	}
+
+	display.setColorFilters(filters)
+}
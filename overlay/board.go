@@ -0,0 +1,224 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Layout arranges multiple images in one window.
+type Layout string
+
+const (
+	// show one image at a time
+	LayoutNone Layout = "none"
+	// rows and columns, about as many of each
+	LayoutGrid Layout = "grid"
+	// side by side
+	LayoutHStack Layout = "hstack"
+	// on top of each other
+	LayoutVStack Layout = "vstack"
+)
+
+var layouts = []Layout{LayoutNone, LayoutGrid, LayoutHStack, LayoutVStack}
+
+func ParseLayout(name string) (Layout, error) {
+	for _, layout := range layouts {
+		if string(layout) == name {
+			return layout, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown layout %q", name)
+}
+
+// labels are drawn on a strip below each image
+const (
+	labelPadding = 3
+	labelHeight  = 13 + 2*labelPadding
+)
+
+var labelBackground = color.RGBA{0, 0, 0, 0xc0}
+
+// pixels between the thumbnails of a contact sheet
+const sheetGap = 8
+
+// board tells if the images are composed into one instead of shown one at a
+// time.
+func (options Options) board() bool {
+	return options.Layout != "" && options.Layout != LayoutNone && len(options.Images) > 1
+}
+
+// loadBoard reads all images and composes them into one. Animations only
+// show their first frame.
+func (options Options) loadBoard() (decodedImage, error) {
+	images := make([]image.Image, len(options.Images))
+	labels := make([]string, len(options.Images))
+
+	for i, filename := range options.Images {
+		decoded, err := loadImage(filename, false, image.Point{})
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("load image %s: %w", filename, err)
+		}
+
+		images[i] = decoded.image
+		if options.Labels {
+			labels[i] = filepath.Base(filename)
+		}
+	}
+
+	columns := layoutColumns(options.Layout, len(images))
+
+	return decodedImage{image: composeBoard(columns, options.Gap, images, labels)}, nil
+}
+
+// ContactSheet composes thumbnails of files, at most size pixels wide and
+// high, into a grid with the given number of columns and the file names below
+// them.
+func ContactSheet(files []string, columns int, size int) (*image.RGBA, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no images")
+	}
+
+	images := make([]image.Image, len(files))
+	labels := make([]string, len(files))
+
+	for i, filename := range files {
+		decoded, err := loadImage(filename, false, image.Pt(size, size))
+		if err != nil {
+			return nil, fmt.Errorf("load image %s: %w", filename, err)
+		}
+
+		images[i] = thumbnail(decoded, size)
+		labels[i] = filepath.Base(filename)
+	}
+
+	return composeBoard(min(columns, len(images)), sheetGap, images, labels), nil
+}
+
+// thumbnail scales the image down to fit into size by size pixels, smaller
+// images are kept as they are. svgs are rasterized at the thumbnail size.
+func thumbnail(decoded decodedImage, size int) image.Image {
+	bounds := decoded.image.Bounds()
+	if bounds.Dx() <= size && bounds.Dy() <= size {
+		return decoded.image
+	}
+
+	scale := min(float64(size)/float64(bounds.Dx()), float64(size)/float64(bounds.Dy()))
+	width := max(1, int(float64(bounds.Dx())*scale))
+	height := max(1, int(float64(bounds.Dy())*scale))
+
+	if decoded.vector != nil {
+		return rasterizeSVG(decoded.vector, width, height)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), decoded.image, bounds, draw.Src, nil)
+
+	return scaled
+}
+
+func layoutColumns(layout Layout, count int) int {
+	switch layout {
+	case LayoutGrid:
+		return int(math.Ceil(math.Sqrt(float64(count))))
+	case LayoutHStack:
+		return count
+	default:
+		return 1
+	}
+}
+
+// composeBoard puts the images into cells, every column as wide as its
+// widest image and every row as high as its highest. Images are centered in
+// their cell, labels that are not empty go below them.
+func composeBoard(columns int, gap int, images []image.Image, labels []string) *image.RGBA {
+	rows := (len(images) + columns - 1) / columns
+
+	labeled := false
+	for _, label := range labels {
+		labeled = labeled || label != ""
+	}
+
+	widths := make([]int, columns)
+	heights := make([]int, rows)
+	for i, img := range images {
+		width := img.Bounds().Dx()
+		height := img.Bounds().Dy()
+		if labeled {
+			height += labelHeight
+		}
+
+		widths[i%columns] = max(widths[i%columns], width)
+		heights[i/columns] = max(heights[i/columns], height)
+	}
+
+	// cell positions, the last entry is the size of the board plus a gap
+	xs := offsets(widths, gap)
+	ys := offsets(heights, gap)
+
+	board := image.NewRGBA(image.Rect(0, 0, xs[columns]-gap, ys[rows]-gap))
+
+	for i, img := range images {
+		column := i % columns
+		row := i / columns
+		cell := image.Rect(xs[column], ys[row], xs[column]+widths[column], ys[row]+heights[row])
+
+		imageArea := cell
+		if labeled {
+			imageArea.Max.Y -= labelHeight
+		}
+
+		bounds := img.Bounds()
+		at := image.Pt(
+			imageArea.Min.X+(imageArea.Dx()-bounds.Dx())/2,
+			imageArea.Min.Y+(imageArea.Dy()-bounds.Dy())/2,
+		)
+		draw.Draw(board, bounds.Sub(bounds.Min).Add(at), img, bounds.Min, draw.Src)
+
+		if labels[i] != "" {
+			drawLabel(board, image.Rect(cell.Min.X, imageArea.Max.Y, cell.Max.X, cell.Max.Y), labels[i])
+		}
+	}
+
+	return board
+}
+
+func offsets(sizes []int, gap int) []int {
+	result := make([]int, len(sizes)+1)
+	for i, size := range sizes {
+		result[i+1] = result[i] + size + gap
+	}
+
+	return result
+}
+
+// drawLabel writes text centered on a dark strip, cut to the width of the
+// strip.
+func drawLabel(dst *image.RGBA, strip image.Rectangle, text string) {
+	draw.Draw(dst, strip, image.NewUniform(labelBackground), image.Point{}, draw.Over)
+
+	face := basicfont.Face7x13
+	runes := []rune(text)
+	fits := max(0, (strip.Dx()-2*labelPadding)/face.Advance)
+	// the font only has latin-1, so no ellipsis character
+	if len(runes) > fits {
+		runes = append(runes[:max(0, fits-3)], []rune("...")[:min(3, fits)]...)
+	}
+
+	width := len(runes) * face.Advance
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(strip.Min.X+(strip.Dx()-width)/2, strip.Min.Y+labelPadding+face.Ascent),
+	}
+	drawer.DrawString(string(runes))
+}
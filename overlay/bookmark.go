@@ -0,0 +1,95 @@
+package overlay
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Bookmarks keep how the image is viewed, the zoom and pan, the opacity,
+// whether the guides and the info panel are shown and the color filters,
+// to go back to it later: compare one area of a large mockup, then another
+// one and back again. ctrl+alt+1 to 9 save them, alt+1 to 9 recall them.
+// They are kept while the overlay runs, for every image it shows.
+
+const maxBookmarks = 9
+
+const (
+	bookmarkPrefix     = "bookmark-"
+	saveBookmarkPrefix = "save-bookmark-"
+)
+
+type bookmark struct {
+	view       viewport
+	opacity    float64
+	showGuides bool
+	showInfo   bool
+	filters    ColorFilters
+}
+
+// bookmark returns the number of the bookmark a recalls or saves, and
+// whether it saves it.
+func (a action) bookmark() (int, bool, bool) {
+	save := strings.HasPrefix(string(a), saveBookmarkPrefix)
+
+	number, ok := strings.CutPrefix(string(a), bookmarkPrefix)
+	if save {
+		number, ok = strings.CutPrefix(string(a), saveBookmarkPrefix)
+	}
+
+	if !ok {
+		return 0, false, false
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > maxBookmarks || strconv.Itoa(n) != number {
+		return 0, false, false
+	}
+
+	return n, save, true
+}
+
+func (display *Window) saveBookmark(n int) {
+	display.renderMu.Lock()
+	display.bookmarks[n-1] = &bookmark{
+		view:       display.view,
+		opacity:    display.targetOpacity(),
+		showGuides: display.showGuides,
+		showInfo:   display.showInfo,
+		filters:    display.filters,
+	}
+	display.renderMu.Unlock()
+
+	slog.Info("saved bookmark", "bookmark", n)
+}
+
+func (display *Window) recallBookmark(n int) error {
+	display.renderMu.Lock()
+	saved := display.bookmarks[n-1]
+	display.renderMu.Unlock()
+
+	if saved == nil {
+		return fmt.Errorf("no bookmark %d", n)
+	}
+
+	display.checkpoint(bookmarkPrefix + strconv.Itoa(n))
+
+	display.fadeOpacity(saved.opacity)
+	display.shareOpacity()
+
+	display.renderMu.Lock()
+	display.view = saved.view
+	display.showGuides = saved.showGuides
+	display.showInfo = saved.showInfo
+	refilter := saved.filters != display.filters
+	display.renderMu.Unlock()
+
+	if refilter {
+		display.setColorFilters(saved.filters)
+	}
+
+	display.requestRedraw()
+
+	return nil
+}
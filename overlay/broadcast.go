@@ -0,0 +1,395 @@
+package overlay
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// changes are sent at most this often, streams and fades would otherwise
+	// encode a frame for every step
+	minBroadcastInterval = 50 * time.Millisecond
+	// receivers that don't keep up are dropped instead of holding back the
+	// others
+	broadcastWriteTimeout = 5 * time.Second
+	// how long a receiver waits before it connects again
+	receiveRetryInterval = 2 * time.Second
+	// receivers that don't introduce themselves in time are dropped
+	broadcastHelloTimeout = 5 * time.Second
+)
+
+// broadcastHello is sent by receivers right after they connect.
+type broadcastHello struct {
+	Token string `json:"token,omitempty"`
+}
+
+// broadcastFrame is sent to the receivers whenever the image or the state of
+// the broadcasting overlay changes, with only the parts that changed. The
+// protocol is a stream of JSON objects, like the control protocol.
+type broadcastFrame struct {
+	State *ControlState `json:"state,omitempty"`
+	// png encoded
+	Image []byte `json:"image,omitempty"`
+}
+
+// BroadcastServer sends the image, opacity, visibility and geometry of an
+// overlay to the overlays receiving it, on other displays or machines.
+type BroadcastServer struct {
+	listener net.Listener
+	display  *Window
+	guard    *accessGuard
+	wg       sync.WaitGroup
+	changed  chan struct{}
+	done     chan struct{}
+
+	mu        sync.Mutex
+	receivers []net.Conn
+	// what was sent last, new receivers start with it
+	state    *ControlState
+	image    image.Image
+	imagePNG []byte
+}
+
+// ListenBroadcast accepts receivers, started with Options.Receive, on
+// address, e.g. ":7900", if they pass access.
+func (display *Window) ListenBroadcast(address string, access AccessControl) (*BroadcastServer, error) {
+	guard := newAccessGuard(access)
+
+	listener, err := guard.listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &BroadcastServer{
+		listener: listener,
+		display:  display,
+		guard:    guard,
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	display.broadcastMu.Lock()
+	display.broadcast = server
+	display.broadcastMu.Unlock()
+
+	server.wg.Add(2)
+	go server.serve()
+	go server.run()
+
+	// the first frame, for the receivers to start with
+	server.notify()
+
+	return server, nil
+}
+
+func (server *BroadcastServer) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("accept receiver", "err", err)
+			continue
+		}
+
+		server.wg.Add(1)
+		go server.addReceiver(conn)
+	}
+}
+
+// addReceiver sends the current frame to conn and adds it to the receivers,
+// once it introduced itself with the right token.
+func (server *BroadcastServer) addReceiver(conn net.Conn) {
+	defer server.wg.Done()
+
+	err := server.hello(conn)
+	if err != nil {
+		slog.Warn("refuse receiver", "addr", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	frame := broadcastFrame{State: server.state, Image: server.imagePNG}
+	if frame.State != nil && sendFrame(conn, frame) != nil {
+		conn.Close()
+		return
+	}
+
+	server.receivers = append(server.receivers, conn)
+}
+
+func (server *BroadcastServer) hello(conn net.Conn) error {
+	guard := server.guard
+	if guard.limiter != nil && !guard.limiter.allow() {
+		return fmt.Errorf("too many connections")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(broadcastHelloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var hello broadcastHello
+
+	err := json.NewDecoder(conn).Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("read hello: %w", err)
+	}
+
+	if !guard.validToken(hello.Token) {
+		return fmt.Errorf("missing or wrong token")
+	}
+
+	return nil
+}
+
+func (server *BroadcastServer) run() {
+	defer server.wg.Done()
+
+	for {
+		select {
+		case <-server.changed:
+		case <-server.done:
+			return
+		}
+
+		err := server.sendChanges()
+		if err != nil {
+			slog.Error("broadcast", "err", err)
+		}
+
+		time.Sleep(minBroadcastInterval)
+	}
+}
+
+// notify schedules sending the changes unless that is pending already.
+func (server *BroadcastServer) notify() {
+	select {
+	case server.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (server *BroadcastServer) sendChanges() error {
+	state, err := server.display.state()
+	if err != nil {
+		return err
+	}
+
+	display := server.display
+	display.renderMu.Lock()
+	img := display.image
+	display.renderMu.Unlock()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	var frame broadcastFrame
+
+	if server.state == nil || *state != *server.state {
+		frame.State = state
+		server.state = state
+	}
+
+	if img != server.image {
+		var buf bytes.Buffer
+
+		encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+		err := encoder.Encode(&buf, img)
+		if err != nil {
+			return fmt.Errorf("encode image: %w", err)
+		}
+
+		frame.Image = buf.Bytes()
+		server.image = img
+		server.imagePNG = frame.Image
+	}
+
+	if frame.State == nil && frame.Image == nil {
+		return nil
+	}
+
+	receivers := server.receivers[:0]
+	for _, conn := range server.receivers {
+		err := sendFrame(conn, frame)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		receivers = append(receivers, conn)
+	}
+
+	server.receivers = receivers
+
+	return nil
+}
+
+func sendFrame(conn net.Conn, frame broadcastFrame) error {
+	conn.SetWriteDeadline(time.Now().Add(broadcastWriteTimeout))
+
+	return json.NewEncoder(conn).Encode(frame)
+}
+
+func (server *BroadcastServer) Close() {
+	server.display.broadcastMu.Lock()
+	server.display.broadcast = nil
+	server.display.broadcastMu.Unlock()
+
+	server.listener.Close()
+	close(server.done)
+	server.wg.Wait()
+
+	server.mu.Lock()
+	for _, conn := range server.receivers {
+		conn.Close()
+	}
+	server.receivers = nil
+	server.mu.Unlock()
+}
+
+// broadcastChanges tells the broadcast, if there is one, that the image or
+// the state may have changed.
+func (display *Window) broadcastChanges() {
+	display.broadcastMu.Lock()
+	server := display.broadcast
+	display.broadcastMu.Unlock()
+
+	if server != nil {
+		server.notify()
+	}
+}
+
+// startReceiving shows what the overlay broadcasting on address shows, and
+// connects again whenever the connection is lost.
+func (display *Window) startReceiving(address string) {
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+
+		for {
+			err := display.receive(display.ctx, address)
+			if display.ctx.Err() != nil {
+				return
+			}
+
+			slog.Error("receive broadcast", "err", err)
+			display.sourceFailed(address, err)
+
+			select {
+			case <-time.After(receiveRetryInterval):
+			case <-display.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (display *Window) receive(ctx context.Context, address string) error {
+	var conn net.Conn
+	var err error
+
+	if config := display.options.ReceiveTLS; config != nil {
+		dialer := tls.Dialer{Config: config}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	err = json.NewEncoder(conn).Encode(broadcastHello{Token: display.options.ReceiveToken})
+	if err != nil {
+		return fmt.Errorf("send hello: %w", err)
+	}
+
+	// interrupts the read below when the overlay is closed
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	decoder := json.NewDecoder(conn)
+
+	// only what changed on the broadcasting side is applied, so that the
+	// window can still be moved and changed here in between
+	var previous *ControlState
+
+	for {
+		var frame broadcastFrame
+
+		err := decoder.Decode(&frame)
+		if err != nil {
+			return fmt.Errorf("read frame: %w", err)
+		}
+
+		if frame.Image != nil {
+			img, err := png.Decode(bytes.NewReader(frame.Image))
+			if err != nil {
+				return fmt.Errorf("decode image: %w", err)
+			}
+
+			display.showFrame(img)
+		}
+
+		if frame.State != nil {
+			err := display.applyBroadcastState(previous, *frame.State)
+			if err != nil {
+				slog.Error("apply broadcast state", "err", err)
+			}
+
+			previous = frame.State
+		}
+	}
+}
+
+// applyBroadcastState applies what differs from the previous state, or all of
+// state for the first one.
+func (display *Window) applyBroadcastState(previous *ControlState, state ControlState) error {
+	first := previous == nil
+
+	if first || state.Opacity != previous.Opacity {
+		display.fadeOpacity(state.Opacity)
+	}
+
+	if first || state.Visible != previous.Visible {
+		err := display.setVisible(state.Visible)
+		if err != nil {
+			return err
+		}
+	}
+
+	if first || state.X != previous.X || state.Y != previous.Y {
+		err := display.moveWindow(state.X, state.Y)
+		if err != nil {
+			return fmt.Errorf("move: %w", err)
+		}
+	}
+
+	if first || state.Width != previous.Width || state.Height != previous.Height {
+		err := display.resizeWindow(state.Width, state.Height)
+		if err != nil {
+			return fmt.Errorf("resize: %w", err)
+		}
+	}
+
+	return nil
+}
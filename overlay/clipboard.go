@@ -0,0 +1,313 @@
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// X has no clipboard storage, the owner of a selection hands out its
+// contents whenever another client asks for them. Data that doesn't fit
+// into a single request is sent in chunks with the INCR protocol.
+
+// selections we own when copying something
+var clipboardSelections = []string{"CLIPBOARD", "PRIMARY"}
+
+// largest chunk sent at once, large images are sent incrementally
+const maxSelectionChunk = 256 * 1024
+
+type clipboard struct {
+	mu sync.Mutex
+	// contents by selection and target
+	contents  map[xproto.Atom]map[xproto.Atom][]byte
+	transfers map[incrTransferKey]*incrTransfer
+}
+
+type incrTransferKey struct {
+	requestor xproto.Window
+	property  xproto.Atom
+}
+
+type incrTransfer struct {
+	target xproto.Atom
+	data   []byte
+}
+
+// clipboardText returns the targets for a text.
+func clipboardText(text string) map[string][]byte {
+	data := []byte(text)
+
+	return map[string][]byte{
+		"UTF8_STRING":              data,
+		"STRING":                   data,
+		"TEXT":                     data,
+		"text/plain":               data,
+		"text/plain;charset=utf-8": data,
+	}
+}
+
+// clipboardImage returns the targets for an image.
+func clipboardImage(img image.Image) (map[string][]byte, error) {
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+
+	return map[string][]byte{"image/png": buf.Bytes()}, nil
+}
+
+// copyToClipboard makes contents, by target name, available as the
+// CLIPBOARD and the PRIMARY selection.
+func (display *Window) copyToClipboard(contents map[string][]byte) error {
+	targets := map[xproto.Atom][]byte{}
+	for name, data := range contents {
+		target, err := display.atom(name)
+		if err != nil {
+			return err
+		}
+
+		targets[target] = data
+	}
+
+	display.clipboard.mu.Lock()
+	defer display.clipboard.mu.Unlock()
+
+	if display.clipboard.contents == nil {
+		display.clipboard.contents = map[xproto.Atom]map[xproto.Atom][]byte{}
+	}
+
+	for _, name := range clipboardSelections {
+		selection, err := display.atom(name)
+		if err != nil {
+			return err
+		}
+
+		err = xproto.SetSelectionOwnerChecked(display.conn, display.windowID, selection, xproto.TimeCurrentTime).Check()
+		if err != nil {
+			return fmt.Errorf("set selection owner: %w", err)
+		}
+
+		display.clipboard.contents[selection] = targets
+	}
+
+	return nil
+}
+
+func (display *Window) handleSelectionClear(event xproto.SelectionClearEvent) {
+	display.clipboard.mu.Lock()
+	defer display.clipboard.mu.Unlock()
+
+	delete(display.clipboard.contents, event.Selection)
+}
+
+func (display *Window) handleSelectionRequest(event xproto.SelectionRequestEvent) error {
+	// obsolete clients don't name a property
+	property := event.Property
+	if property == xproto.AtomNone {
+		property = event.Target
+	}
+
+	err := display.answerSelectionRequest(event, property)
+	if err != nil {
+		property = xproto.AtomNone
+	}
+
+	notify := xproto.SelectionNotifyEvent{
+		Time:      event.Time,
+		Requestor: event.Requestor,
+		Selection: event.Selection,
+		Target:    event.Target,
+		Property:  property,
+	}
+
+	sendErr := xproto.SendEventChecked(display.conn, false, event.Requestor, 0, string(notify.Bytes())).Check()
+	if sendErr != nil {
+		return fmt.Errorf("send selection notify: %w", sendErr)
+	}
+
+	return err
+}
+
+func (display *Window) answerSelectionRequest(event xproto.SelectionRequestEvent, property xproto.Atom) error {
+	const (
+		format8Bit  = 8
+		format32Bit = 32
+	)
+
+	display.clipboard.mu.Lock()
+	targets := display.clipboard.contents[event.Selection]
+	display.clipboard.mu.Unlock()
+
+	if targets == nil {
+		return fmt.Errorf("selection is not ours")
+	}
+
+	targetsAtom, err := display.atom("TARGETS")
+	if err != nil {
+		return err
+	}
+
+	if event.Target == targetsAtom {
+		list := []xproto.Atom{targetsAtom}
+		for target := range targets {
+			list = append(list, target)
+		}
+
+		data := make([]byte, 0, len(list)*4)
+		for _, atom := range list {
+			data = append(data, byte(atom), byte(atom>>8), byte(atom>>16), byte(atom>>24))
+		}
+
+		return xproto.ChangePropertyChecked(display.conn, xproto.PropModeReplace, event.Requestor, property, xproto.AtomAtom, format32Bit, uint32(len(list)), data).Check()
+	}
+
+	data, ok := targets[event.Target]
+	if !ok {
+		return fmt.Errorf("unsupported target %d", event.Target)
+	}
+
+	if len(data) <= display.maxSelectionChunk() {
+		return xproto.ChangePropertyChecked(display.conn, xproto.PropModeReplace, event.Requestor, property, event.Target, format8Bit, uint32(len(data)), data).Check()
+	}
+
+	// the requestor deletes the property whenever it has read a chunk,
+	// which we notice through property events on its window
+	err = xproto.ChangeWindowAttributesChecked(display.conn, event.Requestor, xproto.CwEventMask, []uint32{xproto.EventMaskPropertyChange}).Check()
+	if err != nil {
+		return fmt.Errorf("select property events: %w", err)
+	}
+
+	incr, err := display.atom("INCR")
+	if err != nil {
+		return err
+	}
+
+	display.clipboard.mu.Lock()
+	if display.clipboard.transfers == nil {
+		display.clipboard.transfers = map[incrTransferKey]*incrTransfer{}
+	}
+	display.clipboard.transfers[incrTransferKey{event.Requestor, property}] = &incrTransfer{
+		target: event.Target,
+		data:   data,
+	}
+	display.clipboard.mu.Unlock()
+
+	size := uint32(len(data))
+	sizeBytes := []byte{byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)}
+
+	return xproto.ChangePropertyChecked(display.conn, xproto.PropModeReplace, event.Requestor, property, incr, format32Bit, 1, sizeBytes).Check()
+}
+
+// handlePropertyNotify sends the next chunk of an incremental transfer once
+// the requestor has read the previous one. The transfer ends with an empty
+// chunk.
+func (display *Window) handlePropertyNotify(event xproto.PropertyNotifyEvent) error {
+	const format8Bit = 8
+
+	if event.State != xproto.PropertyDelete {
+		return nil
+	}
+
+	key := incrTransferKey{event.Window, event.Atom}
+
+	display.clipboard.mu.Lock()
+	transfer, ok := display.clipboard.transfers[key]
+	if !ok {
+		display.clipboard.mu.Unlock()
+		return nil
+	}
+
+	chunk := transfer.data[:min(len(transfer.data), display.maxSelectionChunk())]
+	transfer.data = transfer.data[len(chunk):]
+
+	if len(chunk) == 0 {
+		delete(display.clipboard.transfers, key)
+	}
+	display.clipboard.mu.Unlock()
+
+	err := xproto.ChangePropertyChecked(display.conn, xproto.PropModeReplace, event.Window, event.Atom, transfer.target, format8Bit, uint32(len(chunk)), chunk).Check()
+	if err != nil {
+		return fmt.Errorf("send selection chunk: %w", err)
+	}
+
+	return nil
+}
+
+// maxSelectionChunk returns the largest property we send at once, it has to
+// fit into a single request.
+func (display *Window) maxSelectionChunk() int {
+	const changePropertyHeaderSize = 24
+
+	maxRequestSize := int(xproto.Setup(display.conn).MaximumRequestLength) * 4
+
+	return min(maxSelectionChunk, maxRequestSize-changePropertyHeaderSize)
+}
+
+// copyImage copies the image as it is shown, at its original size.
+func (display *Window) copyImage() error {
+	display.renderMu.Lock()
+	img := display.image
+	source := display.source
+	loaded := display.loaded
+	rotation := display.rotation
+	filters := display.filters
+	display.renderMu.Unlock()
+
+	if loaded.fullSize != (image.Point{}) {
+		decoded, err := loadImage(source, false, image.Point{})
+		if err != nil {
+			return err
+		}
+
+		img = display.options.prepare(decoded, rotation, filters).image
+	}
+
+	contents, err := clipboardImage(img)
+	if err != nil {
+		return err
+	}
+
+	return display.copyToClipboard(contents)
+}
+
+// copyColor copies the color of the image pixel under the pointer as
+// #rrggbb, or #rrggbbaa if it isn't opaque.
+func (display *Window) copyColor() error {
+	pointer, err := xproto.QueryPointer(display.conn, display.windowID).Reply()
+	if err != nil {
+		return fmt.Errorf("query pointer: %w", err)
+	}
+
+	point, ok := display.imagePoint(int(pointer.WinX), int(pointer.WinY))
+	if !ok {
+		return fmt.Errorf("the pointer is not over the image")
+	}
+
+	display.renderMu.Lock()
+	img := display.image
+	display.renderMu.Unlock()
+
+	c := color.NRGBAModel.Convert(img.At(point.X, point.Y)).(color.NRGBA)
+
+	return display.copyToClipboard(clipboardText(hexColor(c)))
+}
+
+// copyGeometry copies the geometry of the window in the format --geometry
+// accepts.
+func (display *Window) copyGeometry() error {
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%dx%d+%d+%d", display.windowWidth, display.windowHeight, x, y)
+
+	return display.copyToClipboard(clipboardText(text))
+}
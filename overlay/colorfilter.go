@@ -0,0 +1,204 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// ColorFilters change the colors of the image to compare it with what is
+// below: an inverted mockup over the real UI shows every misaligned edge
+// in bright colors, especially with the difference blend mode. They are
+// applied last, in the order of the fields.
+type ColorFilters struct {
+	// Grayscale shows the luminance only.
+	Grayscale bool
+	// Channel shows a single channel as gray, or all of them if it is
+	// ChannelAll.
+	Channel Channel
+	// Threshold shows pixels with a luminance of at least Threshold as
+	// white and the others as black, if it is 1 to 255.
+	Threshold int
+	// Invert inverts the colors, not the alpha.
+	Invert bool
+	// Blueprint shows the lines, text and shapes of the image in this
+	// translucent color and nothing else, split from the background at
+	// Threshold, see blueprint.go.
+	Blueprint color.RGBA
+	// Outline shows only the edges of the image in this color, opaque, if
+	// it isn't transparent, where the brightness changes by at least
+	// OutlineThreshold, see outline.go.
+	Outline          color.RGBA
+	OutlineThreshold int
+}
+
+// Channel is a channel of the image.
+type Channel string
+
+const (
+	ChannelAll   Channel = ""
+	ChannelRed   Channel = "r"
+	ChannelGreen Channel = "g"
+	ChannelBlue  Channel = "b"
+	ChannelAlpha Channel = "a"
+)
+
+// the order cycle-channel goes through them in
+var channels = []Channel{ChannelAll, ChannelRed, ChannelGreen, ChannelBlue, ChannelAlpha}
+
+func ParseChannel(name string) (Channel, error) {
+	switch name {
+	case "r", "red":
+		return ChannelRed, nil
+	case "g", "green":
+		return ChannelGreen, nil
+	case "b", "blue":
+		return ChannelBlue, nil
+	case "a", "alpha":
+		return ChannelAlpha, nil
+	}
+
+	return ChannelAll, fmt.Errorf("unknown channel %q, expected r, g, b or a", name)
+}
+
+func (filters ColorFilters) enabled() bool {
+	return filters.changesColors() || filters.Blueprint.A > 0 || filters.Outline.A > 0
+}
+
+// changesColors reports whether the filters change the color of pixels, on
+// top of the blueprint and the outline.
+func (filters ColorFilters) changesColors() bool {
+	return filters.Grayscale || filters.Channel != ChannelAll || filters.Threshold > 0 || filters.Invert
+}
+
+// apply filters the image and every frame of an animation, the blueprint
+// is made of what they left and the outline is found last.
+func (filters ColorFilters) apply(decoded decodedImage) decodedImage {
+	if !filters.enabled() {
+		return decoded
+	}
+
+	return mapFrames(decoded, func(img image.Image) image.Image {
+		if filters.changesColors() {
+			img = filters.filterImage(img)
+		}
+
+		if filters.Blueprint.A > 0 {
+			img = blueprintImage(img, filters.Blueprint, filters.Threshold)
+		}
+
+		if filters.Outline.A > 0 {
+			img = outlineImage(img, filters.Outline, filters.OutlineThreshold)
+		}
+
+		return img
+	})
+}
+
+func (filters ColorFilters) filterImage(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+
+	filtered := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(filtered, filtered.Bounds(), img, bounds.Min, draw.Src)
+
+	pix := filtered.Pix
+	for i := 0; i < len(pix); i += 4 {
+		r, g, b, a := pix[i], pix[i+1], pix[i+2], pix[i+3]
+
+		if filters.Grayscale || filters.Threshold > 0 {
+			// Rec. 601 luma, like image/color converts to gray
+			y := uint8((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+			r, g, b = y, y, y
+		}
+
+		switch filters.Channel {
+		case ChannelRed:
+			g, b = r, r
+		case ChannelGreen:
+			r, b = g, g
+		case ChannelBlue:
+			r, g = b, b
+		case ChannelAlpha:
+			// the alpha channel itself is shown, opaque
+			r, g, b, a = a, a, a, 0xff
+		}
+
+		if filters.Threshold > 0 {
+			level := uint8(0)
+			if int(r) >= filters.Threshold {
+				level = 0xff
+			}
+
+			r, g, b = level, level, level
+		}
+
+		if filters.Invert {
+			r, g, b = 0xff-r, 0xff-g, 0xff-b
+		}
+
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = r, g, b, a
+	}
+
+	return filtered
+}
+
+// setColorFilters shows the image that is loaded again with filters.
+func (display *Window) setColorFilters(filters ColorFilters) {
+	display.renderMu.Lock()
+	display.filters = filters
+	source := display.source
+	loaded := display.loaded
+	placeholder := display.placeholder
+	display.renderMu.Unlock()
+
+	if placeholder {
+		return
+	}
+
+	display.setImage(source, loaded)
+}
+
+func (display *Window) currentColorFilters() ColorFilters {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.filters
+}
+
+// toggleColorFilters turns the filters off, or on again. With none given
+// on the command line they are inverted.
+func (display *Window) toggleColorFilters() {
+	filters := display.options.Filters
+	if !filters.enabled() {
+		filters.Invert = true
+	}
+
+	if display.currentColorFilters().enabled() {
+		filters = ColorFilters{}
+	}
+
+	display.setColorFilters(filters)
+}
+
+func (display *Window) toggleInvert() {
+	filters := display.currentColorFilters()
+	filters.Invert = !filters.Invert
+
+	display.setColorFilters(filters)
+}
+
+// cycleChannel shows the next channel on its own, and all of them after the
+// alpha channel.
+func (display *Window) cycleChannel() {
+	filters := display.currentColorFilters()
+
+	for i, channel := range channels {
+		if channel == filters.Channel {
+			filters.Channel = channels[(i+1)%len(channels)]
+			break
+		}
+	}
+
+	display.setColorFilters(filters)
+}
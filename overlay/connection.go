@@ -0,0 +1,349 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xproto"
+)
+
+// the events of a window that are not handled yet, the event loop of the
+// connection waits for the window when they fill up
+const windowEventQueueSize = 256
+
+// Connection is a connection to the X server that several overlay windows
+// share. A single event loop reads its events and hands each one to the
+// window it is about, or to all of them if it is about a window that is not
+// an overlay, e.g. the root window or a followed window.
+type Connection struct {
+	conn      *xgb.Conn
+	screen    *xproto.ScreenInfo
+	resources *xResources
+
+	shmOnce sync.Once
+	shmErr  error
+
+	renderOnce sync.Once
+	renderErr  error
+
+	mu      sync.Mutex
+	windows []*Window
+	byID    map[xproto.Window]*Window
+	// handed out to the windows, 1 for the first
+	lastID int
+	closed bool
+
+	closeOnce sync.Once
+}
+
+// xEvent is an event or an error, as the event loop hands them to windows.
+type xEvent struct {
+	event xgb.Event
+	err   xgb.Error
+}
+
+// NewConnection opens a connection to the X server that overlays can be
+// created on with New.
+func NewConnection() (*Connection, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDisplay, err)
+	}
+
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+
+	connection := &Connection{
+		conn:      conn,
+		screen:    screen,
+		resources: newXResources(conn, screen.Root),
+		byID:      map[xproto.Window]*Window{},
+	}
+
+	go connection.run()
+
+	return connection, nil
+}
+
+// New shows another overlay window on the connection. It is closed when the
+// user closes it, or along with the connection.
+func (connection *Connection) New(opts ...Option) (*Window, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	display, err := connection.newWindow(options)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-display.closed
+		display.Close()
+	}()
+
+	return display, nil
+}
+
+func (connection *Connection) newWindow(options Options) (*Window, error) {
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	options.Rotate = (options.Rotate%360 + 360) % 360
+	options.InitialOpacity = min(1.0, max(0.0, options.InitialOpacity))
+
+	var random *rand.Rand
+	if options.Random {
+		random = newRandom(options.Seed)
+		if options.Image == nil && !options.board() && len(options.Images) > 1 {
+			options.firstImage = random.IntN(len(options.Images))
+		}
+	}
+
+	decoded, err := options.initialImage()
+	if err != nil {
+		return nil, err
+	}
+
+	display, err := newWindow(connection, options, decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	display.random = random
+
+	err = display.start()
+	if err != nil {
+		display.Close()
+		return nil, err
+	}
+
+	return display, nil
+}
+
+// Add shows the image at path in another overlay on the same connection,
+// with the options of this one except for what it shows and where. It is
+// closed when the user closes it, or along with the connection.
+func (display *Window) Add(path string, geometry Geometry) (*Window, error) {
+	display.renderMu.Lock()
+	options := display.options.forImage(path, geometry)
+	display.renderMu.Unlock()
+
+	added, err := display.connection.New(WithOptions(options))
+	if err != nil {
+		return nil, err
+	}
+
+	display.renderMu.Lock()
+	display.added = append(display.added, added)
+	display.renderMu.Unlock()
+
+	return added, nil
+}
+
+// forImage returns the options for an overlay that shows the image at path
+// instead, without the source of these options and what only applies to its
+// images.
+func (options Options) forImage(path string, geometry Geometry) Options {
+	options.Image = nil
+	options.Images = []string{path}
+	options.firstImage = 0
+	options.Random = false
+	options.Geometry = geometry
+
+	options.Mirror = ""
+	options.Follow = ""
+	options.Clipboard = ""
+	options.Receive = ""
+	options.SourcePlugin = nil
+	options.Figma = nil
+	options.URL = nil
+	options.Source = nil
+	options.Sequence = ""
+	options.Magnify = 0
+	options.TestPattern = ""
+	options.Stream = nil
+	options.Layout = LayoutNone
+
+	options.Output = ""
+	options.FullscreenOutput = ""
+	options.Fullscreen = false
+
+	options.Crop = image.Rectangle{}
+	options.Redact = nil
+	options.Corners = nil
+
+	// the session restores the command line, which shows the others again
+	options.RestartArgs = nil
+
+	return options
+}
+
+// Windows returns the overlay windows on the connection that are not closed
+// yet, in the order they were created.
+func (connection *Connection) Windows() []*Window {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	return slices.Clone(connection.windows)
+}
+
+// window returns the overlay with the id, as reported in ControlState.
+func (connection *Connection) window(id int) (*Window, bool) {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	for _, display := range connection.windows {
+		if display.id == id {
+			return display, true
+		}
+	}
+
+	return nil, false
+}
+
+// Close closes all windows and the connection.
+func (connection *Connection) Close() {
+	connection.closeOnce.Do(func() {
+		for _, display := range connection.Windows() {
+			if !display.ownsConnection {
+				display.Close()
+			}
+		}
+
+		connection.conn.Close()
+	})
+}
+
+// initShm initializes the shared memory extension once for all windows.
+func (connection *Connection) initShm() error {
+	connection.shmOnce.Do(func() {
+		connection.shmErr = shm.Init(connection.conn)
+	})
+
+	return connection.shmErr
+}
+
+// add starts handing the events of the window to it, once its X window was
+// created.
+func (connection *Connection) add(display *Window) error {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	if connection.closed {
+		return fmt.Errorf("the connection is closed")
+	}
+
+	connection.lastID++
+	display.id = connection.lastID
+
+	connection.windows = append(connection.windows, display)
+	connection.byID[display.windowID] = display
+
+	return nil
+}
+
+func (connection *Connection) remove(display *Window) {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	connection.windows = slices.DeleteFunc(connection.windows, func(w *Window) bool {
+		return w == display
+	})
+
+	if connection.byID[display.windowID] == display {
+		delete(connection.byID, display.windowID)
+	}
+}
+
+// run is the event loop of the connection.
+func (connection *Connection) run() {
+	for {
+		ev, xerr := connection.conn.WaitForEvent()
+		if ev == nil && xerr == nil {
+			break
+		}
+
+		connection.dispatch(xEvent{event: ev, err: xerr})
+	}
+
+	connection.mu.Lock()
+	connection.closed = true
+	windows := slices.Clone(connection.windows)
+	connection.mu.Unlock()
+
+	// the windows see the closed connection like they saw it before it was
+	// shared
+	for _, display := range windows {
+		close(display.xevents)
+	}
+}
+
+// dispatch hands item to the window it is about. Errors go to all windows,
+// only the one that is waiting for its requests to be processed looks at
+// them.
+func (connection *Connection) dispatch(item xEvent) {
+	connection.mu.Lock()
+
+	var windows []*Window
+	if target, ok := eventWindow(item.event); ok && connection.byID[target] != nil {
+		windows = []*Window{connection.byID[target]}
+	} else {
+		windows = slices.Clone(connection.windows)
+	}
+
+	connection.mu.Unlock()
+
+	for _, display := range windows {
+		select {
+		case display.xevents <- item:
+		case <-display.ctx.Done():
+		case <-display.closed:
+		}
+	}
+}
+
+// eventWindow returns the window an event is about, if it has one.
+func eventWindow(ev xgb.Event) (xproto.Window, bool) {
+	switch event := ev.(type) {
+	case xproto.ConfigureNotifyEvent:
+		return event.Window, true
+	case xproto.MapNotifyEvent:
+		return event.Window, true
+	case xproto.UnmapNotifyEvent:
+		return event.Window, true
+	case xproto.DestroyNotifyEvent:
+		return event.Window, true
+	case xproto.ExposeEvent:
+		return event.Window, true
+	case xproto.VisibilityNotifyEvent:
+		return event.Window, true
+	case xproto.PropertyNotifyEvent:
+		return event.Window, true
+	case xproto.ClientMessageEvent:
+		return event.Window, true
+	case xproto.ButtonPressEvent:
+		return event.Event, true
+	case xproto.ButtonReleaseEvent:
+		return event.Event, true
+	case xproto.MotionNotifyEvent:
+		return event.Event, true
+	case xproto.LeaveNotifyEvent:
+		return event.Event, true
+	case xproto.KeyPressEvent:
+		return event.Event, true
+	case xproto.SelectionRequestEvent:
+		return event.Owner, true
+	case xproto.SelectionClearEvent:
+		return event.Owner, true
+	}
+
+	return 0, false
+}
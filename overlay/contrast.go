@@ -0,0 +1,243 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+)
+
+// Options.Contrast labels zones of the image with the WCAG contrast ratio of
+// the text in them: the most common color is taken as the background and,
+// of the colors that cover a bit of the zone, the one that contrasts most
+// with it as the text, so that the antialiased edges of the letters don't
+// count. With Options.ContrastAuto the whole image is checked in tiles, and
+// the tiles that look like text on a background and fail AA are marked.
+//
+// The zones are in pixels of the image as it is shown, after it was cropped
+// and rotated.
+
+const (
+	// the size of the tiles Options.ContrastAuto checks
+	contrastTile = 48
+	// how much of a tile the background has to cover to be checked
+	contrastAutoBackground = 0.5
+	// how much of a zone a color has to cover to count as text
+	contrastZoneText = 0.01
+	contrastAutoText = 0.03
+
+	// the ratios WCAG 2 asks for, for normal text at AA and AAA and for
+	// large text at AA
+	contrastAA      = 4.5
+	contrastAAA     = 7
+	contrastAALarge = 3
+)
+
+var (
+	contrastPass    = color.RGBA{0x30, 0xc0, 0x50, 0xff}
+	contrastLarge   = color.RGBA{0xf0, 0xa0, 0x20, 0xff}
+	contrastFailing = color.RGBA{0xe0, 0x30, 0x30, 0xff}
+)
+
+// contrastCheck is the contrast measured in a zone, and its label once it
+// was rendered.
+type contrastCheck struct {
+	zone       image.Rectangle
+	foreground color.NRGBA
+	background color.NRGBA
+	ratio      float64
+
+	panel *image.RGBA
+}
+
+// level returns what the ratio passes and the color it is marked with.
+func (check *contrastCheck) level() (string, color.RGBA) {
+	switch {
+	case check.ratio >= contrastAAA:
+		return "AAA", contrastPass
+	case check.ratio >= contrastAA:
+		return "AA", contrastPass
+	case check.ratio >= contrastAALarge:
+		return "AA large", contrastLarge
+	}
+
+	return "fail", contrastFailing
+}
+
+func (check *contrastCheck) label() string {
+	level, _ := check.level()
+	return fmt.Sprintf("%.2f:1 %s", check.ratio, level)
+}
+
+// checkContrast measures the contrast in the zones of Options.Contrast and,
+// with Options.ContrastAuto, in the tiles of img that fail.
+func (options Options) checkContrast(img image.Image) []*contrastCheck {
+	if len(options.Contrast) == 0 && !options.ContrastAuto {
+		return nil
+	}
+
+	bounds := img.Bounds()
+
+	pixels := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(pixels, pixels.Bounds(), img, bounds.Min, draw.Src)
+
+	var checks []*contrastCheck
+
+	for _, zone := range options.Contrast {
+		zone = zone.Intersect(pixels.Bounds())
+		if zone.Empty() {
+			continue
+		}
+
+		check, ok := measureContrast(pixels, zone, 0, contrastZoneText)
+		if !ok {
+			// a single color has no contrast
+			check = &contrastCheck{zone: zone, ratio: 1}
+		}
+
+		checks = append(checks, check)
+	}
+
+	if options.ContrastAuto {
+		for y := 0; y < pixels.Rect.Dy(); y += contrastTile {
+			for x := 0; x < pixels.Rect.Dx(); x += contrastTile {
+				tile := image.Rect(x, y, x+contrastTile, y+contrastTile).Intersect(pixels.Rect)
+
+				check, ok := measureContrast(pixels, tile, contrastAutoBackground, contrastAutoText)
+				if ok && check.ratio < contrastAA {
+					checks = append(checks, check)
+				}
+			}
+		}
+	}
+
+	return checks
+}
+
+// measureContrast returns the contrast of the text in zone, if there is a
+// background that covers at least background of it and text that covers
+// at least text of it.
+func measureContrast(img *image.NRGBA, zone image.Rectangle, background float64, text float64) (*contrastCheck, bool) {
+	// colors are counted with 4 bits per channel, so that noise and
+	// compression artifacts fall together
+	var counts [4096]int
+	var sums [4096][3]int
+
+	total := 0
+
+	for y := zone.Min.Y; y < zone.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(zone.Min.X, y):img.PixOffset(zone.Max.X, y)]
+
+		for i := 0; i < len(row); i += 4 {
+			// transparent pixels show what is below, whose color is unknown
+			if row[i+3] < 0x80 {
+				continue
+			}
+
+			r, g, b := int(row[i]), int(row[i+1]), int(row[i+2])
+			bin := r>>4<<8 | g>>4<<4 | b>>4
+
+			counts[bin]++
+			sums[bin][0] += r
+			sums[bin][1] += g
+			sums[bin][2] += b
+			total++
+		}
+	}
+
+	if total == 0 {
+		return nil, false
+	}
+
+	mean := func(bin int) color.NRGBA {
+		count := counts[bin]
+		return color.NRGBA{uint8(sums[bin][0] / count), uint8(sums[bin][1] / count), uint8(sums[bin][2] / count), 0xff}
+	}
+
+	backgroundBin := 0
+	for bin, count := range counts {
+		if count > counts[backgroundBin] {
+			backgroundBin = bin
+		}
+	}
+
+	if float64(counts[backgroundBin]) < background*float64(total) {
+		return nil, false
+	}
+
+	check := &contrastCheck{zone: zone, background: mean(backgroundBin), ratio: 1}
+	found := false
+
+	for bin, count := range counts {
+		if bin == backgroundBin || count == 0 || float64(count) < text*float64(total) {
+			continue
+		}
+
+		foreground := mean(bin)
+		ratio := contrastRatio(foreground, check.background)
+
+		if ratio > check.ratio {
+			check.foreground = foreground
+			check.ratio = ratio
+			found = true
+		}
+	}
+
+	return check, found
+}
+
+// contrastRatio returns the WCAG contrast ratio of two colors, from 1 to 21.
+func contrastRatio(a color.NRGBA, b color.NRGBA) float64 {
+	lighter, darker := relativeLuminance(a), relativeLuminance(b)
+	if darker > lighter {
+		lighter, darker = darker, lighter
+	}
+
+	return (lighter + 0.05) / (darker + 0.05)
+}
+
+// relativeLuminance returns the luminance of c as WCAG defines it.
+func relativeLuminance(c color.NRGBA) float64 {
+	toLinear, _ := srgbTables()
+	return 0.2126*toLinear[c.R] + 0.7152*toLinear[c.G] + 0.0722*toLinear[c.B]
+}
+
+// contrastResults returns the checks of the image shown.
+func (display *Window) contrastResults() []*contrastCheck {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.contrast
+}
+
+// drawContrastChecks marks the zones of checks on the image of size size,
+// which is placed at placed in the window, and labels them with their
+// ratio.
+func drawContrastChecks(buf []byte, visible image.Rectangle, placed image.Rectangle, size image.Point, checks []*contrastCheck, face font.Face) {
+	toWindow := func(p image.Point) image.Point {
+		return image.Point{
+			X: placed.Min.X + p.X*placed.Dx()/size.X,
+			Y: placed.Min.Y + p.Y*placed.Dy()/size.Y,
+		}
+	}
+
+	for _, check := range checks {
+		_, c := check.level()
+		box := image.Rectangle{Min: toWindow(check.zone.Min), Max: toWindow(check.zone.Max)}
+		drawBox(buf, visible, box, c)
+
+		if check.panel == nil {
+			check.panel = renderAnnotationText(face, check.label(), c)
+		}
+
+		// below the zone, or inside it at the bottom of the window
+		origin := image.Pt(box.Min.X, box.Max.Y)
+		if origin.Y+check.panel.Bounds().Dy() > visible.Max.Y {
+			origin.Y = box.Max.Y - check.panel.Bounds().Dy()
+		}
+
+		drawPanel(buf, visible.Dx(), visible.Dy(), check.panel, origin.Sub(visible.Min))
+	}
+}
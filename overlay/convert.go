@@ -0,0 +1,161 @@
+package overlay
+
+import (
+	"encoding/binary"
+	"image"
+	"sync"
+	"unsafe"
+)
+
+// chunks smaller than this are not worth the overhead of a goroutine
+const minConvertChunk = 64 * 1024
+
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// rgbaToBGRA converts premultiplied RGBA pixels into the BGRA byte order of
+// the X server in place, splitting the work across up to threads goroutines.
+func rgbaToBGRA(pix []byte, threads int) {
+	// keep chunks aligned to 16 bytes so that the vectorized path can
+	// process every chunk but the last one without a scalar tail
+	threads = max(1, threads)
+	chunk := max(minConvertChunk, (len(pix)/threads+15)&^15)
+	if chunk >= len(pix) {
+		swizzle(pix)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(pix); start += chunk {
+		end := min(len(pix), start+chunk)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			swizzle(pix[start:end])
+		}()
+	}
+
+	wg.Wait()
+}
+
+// swizzleScalar swaps the red and blue channels one 32-bit word at a time.
+// len(pix) has to be a multiple of 4.
+func swizzleScalar(pix []byte) {
+	if len(pix) == 0 {
+		return
+	}
+
+	words := unsafe.Slice((*uint32)(unsafe.Pointer(&pix[0])), len(pix)/4)
+
+	if littleEndian {
+		for i, w := range words {
+			words[i] = w&0xff00ff00 | (w&0xff)<<16 | (w>>16)&0xff
+		}
+
+		return
+	}
+
+	for i, w := range words {
+		words[i] = w&0x00ff00ff | (w&0xff00)<<16 | (w>>16)&0xff00
+	}
+}
+
+func canWriteUnscaled(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA, *image.NRGBA:
+		return true
+	}
+
+	return false
+}
+
+// writeUnscaled writes img into dst in the BGRA byte order of the X server,
+// premultiplied and with opacity applied. img has to satisfy
+// canWriteUnscaled and dst has to be large enough for the whole image.
+func writeUnscaled(dst []byte, img image.Image, opacity float64, threads int) {
+	const fullAlpha = 255
+	alpha := uint32(fullAlpha * opacity)
+
+	bounds := img.Bounds()
+	rowSize := bounds.Dx() * 4
+
+	var pix []byte
+	var stride int
+	premultiplied := false
+
+	switch img := img.(type) {
+	case *image.RGBA:
+		pix, stride, premultiplied = img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):], img.Stride, true
+	case *image.NRGBA:
+		pix, stride = img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):], img.Stride
+	}
+
+	forEachRowChunk(bounds.Dy(), rowSize, threads, func(startRow int, endRow int) {
+		for y := startRow; y < endRow; y++ {
+			src := pix[y*stride : y*stride+rowSize]
+			row := dst[y*rowSize : (y+1)*rowSize]
+
+			if premultiplied && alpha == fullAlpha {
+				copy(row, src)
+				swizzle(row)
+				continue
+			}
+
+			for i := 0; i < len(src); i += 4 {
+				// scale every channel by the opacity, non premultiplied
+				// sources are scaled by their own alpha as well
+				a := alpha
+				if !premultiplied {
+					a = alpha * uint32(src[i+3]) / fullAlpha
+				}
+
+				row[i+0] = byte(uint32(src[i+2]) * a / fullAlpha)
+				row[i+1] = byte(uint32(src[i+1]) * a / fullAlpha)
+				row[i+2] = byte(uint32(src[i+0]) * a / fullAlpha)
+				row[i+3] = byte(uint32(src[i+3]) * alpha / fullAlpha)
+			}
+		}
+	})
+}
+
+// forEachRowChunk splits rows into chunks of roughly equal size and calls fn
+// for each of them concurrently, using up to threads goroutines.
+func forEachRowChunk(rows int, rowSize int, threads int, fn func(startRow int, endRow int)) {
+	threads = max(1, threads)
+	chunkRows := max(1, minConvertChunk/max(1, rowSize), (rows+threads-1)/threads)
+
+	if chunkRows >= rows {
+		fn(0, rows)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for start := 0; start < rows; start += chunkRows {
+		end := min(rows, start+chunkRows)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(start, end)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// reduceColorDepth drops the lowest bits of every channel so that only bits
+// bits per channel are left. Neighbouring pixels become equal much more often,
+// which compresses a lot better on forwarded connections.
+func reduceColorDepth(pix []byte, bits int, threads int) {
+	if bits >= 8 {
+		return
+	}
+
+	mask := byte(0xff << (8 - bits))
+
+	forEachRowChunk(len(pix), 1, threads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			pix[i] &= mask
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package overlay
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+// swapRedBlue is the byte by byte conversion the faster ones have to match.
+func swapRedBlue(pix []byte) []byte {
+	out := bytes.Clone(pix)
+	for i := 0; i+3 < len(out); i += 4 {
+		out[i], out[i+2] = out[i+2], out[i]
+	}
+
+	return out
+}
+
+func randomPixels(n int) []byte {
+	rng := rand.New(rand.NewPCG(1, uint64(n)))
+
+	pix := make([]byte, n*4)
+	for i := range pix {
+		pix[i] = byte(rng.Uint32())
+	}
+
+	return pix
+}
+
+func TestSwizzle(t *testing.T) {
+	// every tail after the vectorized part, with the slice starting at
+	// every offset within a 16 byte block
+	for pixels := range 70 {
+		for offset := 0; offset < 16; offset += 4 {
+			buf := randomPixels(pixels + 4)
+			pix := buf[offset : offset+pixels*4]
+			want := swapRedBlue(pix)
+
+			scalar := bytes.Clone(pix)
+			swizzleScalar(scalar)
+			if !bytes.Equal(scalar, want) {
+				t.Fatalf("swizzleScalar of %d pixels at offset %d differs", pixels, offset)
+			}
+
+			swizzle(pix)
+			if !bytes.Equal(pix, want) {
+				t.Fatalf("swizzle of %d pixels at offset %d differs", pixels, offset)
+			}
+		}
+	}
+}
+
+func TestRGBAToBGRA(t *testing.T) {
+	// odd sizes split into chunks with a tail that isn't a multiple of 4
+	// pixels
+	for _, pixels := range []int{0, 1, 3, 17, minConvertChunk/4 + 3, 3*minConvertChunk/4 - 5} {
+		for _, threads := range []int{1, 3, 8} {
+			pix := randomPixels(pixels)
+			want := swapRedBlue(pix)
+
+			rgbaToBGRA(pix, threads)
+			if !bytes.Equal(pix, want) {
+				t.Errorf("%d pixels with %d threads differ", pixels, threads)
+			}
+		}
+	}
+}
+
+func TestWriteUnscaled(t *testing.T) {
+	for _, width := range []int{1, 5, 33, 641} {
+		img := image.NewRGBA(image.Rect(0, 0, width+2, 7))
+		copy(img.Pix, randomPixels(len(img.Pix)/4))
+
+		// a sub image whose rows don't start at the beginning of the buffer
+		sub := img.SubImage(image.Rect(1, 1, width+1, 6)).(*image.RGBA)
+		bounds := sub.Bounds()
+
+		dst := make([]byte, bounds.Dx()*bounds.Dy()*4)
+		writeUnscaled(dst, sub, 1, 4)
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := sub.RGBAAt(x, y)
+				i := ((y-bounds.Min.Y)*bounds.Dx() + x - bounds.Min.X) * 4
+
+				got := color.RGBA{B: dst[i], G: dst[i+1], R: dst[i+2], A: dst[i+3]}
+				if got != c {
+					t.Fatalf("width %d: pixel %d,%d is %v, want %v", width, x, y, got, c)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkSwizzle(b *testing.B, fn func([]byte)) {
+	for _, pixels := range []int{1920, 1920 * 1080} {
+		b.Run(strconv.Itoa(pixels), func(b *testing.B) {
+			pix := randomPixels(pixels)
+			b.SetBytes(int64(len(pix)))
+
+			for b.Loop() {
+				fn(pix)
+			}
+		})
+	}
+}
+
+// BenchmarkSwizzle is the vectorized path where there is one, compare it
+// with BenchmarkSwizzleScalar.
+func BenchmarkSwizzle(b *testing.B) {
+	benchmarkSwizzle(b, swizzle)
+}
+
+func BenchmarkSwizzleScalar(b *testing.B) {
+	benchmarkSwizzle(b, swizzleScalar)
+}
+
+func BenchmarkRGBAToBGRA(b *testing.B) {
+	for _, threads := range []int{1, 4} {
+		b.Run(strconv.Itoa(threads)+"threads", func(b *testing.B) {
+			pix := randomPixels(1920 * 1080)
+			b.SetBytes(int64(len(pix)))
+
+			for b.Loop() {
+				rgbaToBGRA(pix, threads)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// CVD is a color vision deficiency that the image is shown as it looks
+// with, for checking that a design still works for everybody.
+type CVD string
+
+const (
+	CVDNone         CVD = ""
+	CVDProtanopia   CVD = "protanopia"
+	CVDDeuteranopia CVD = "deuteranopia"
+	CVDTritanopia   CVD = "tritanopia"
+)
+
+var cvds = []CVD{CVDProtanopia, CVDDeuteranopia, CVDTritanopia}
+
+func ParseCVD(name string) (CVD, error) {
+	for _, cvd := range cvds {
+		if string(cvd) == name {
+			return cvd, nil
+		}
+	}
+
+	return CVDNone, fmt.Errorf("unknown color vision deficiency %q, expected protanopia, deuteranopia or tritanopia", name)
+}
+
+// cvdMatrices simulate the deficiencies at full severity in linear RGB, from
+// Machado, Oliveira and Fernandes, "A Physiologically-based Model for
+// Simulation of Color Vision Deficiency", 2009.
+var cvdMatrices = map[CVD][3][3]float64{
+	CVDProtanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	CVDDeuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	CVDTritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// the levels linear values are quantized to before they are encoded again,
+// fine enough that dark gradients don't band
+const linearLevels = 4096
+
+// srgbTables converts 8 bit sRGB to linear light and linear light, in
+// linearLevels steps, back to sRGB.
+var srgbTables = sync.OnceValues(func() ([256]float64, [linearLevels]uint8) {
+	var toLinear [256]float64
+	for i := range toLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			toLinear[i] = c / 12.92
+		} else {
+			toLinear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+
+	var toSRGB [linearLevels]uint8
+	for i := range toSRGB {
+		l := float64(i) / (linearLevels - 1)
+		c := 12.92 * l
+		if l > 0.0031308 {
+			c = 1.055*math.Pow(l, 1/2.4) - 0.055
+		}
+
+		toSRGB[i] = uint8(math.Round(c * 255))
+	}
+
+	return toLinear, toSRGB
+})
+
+// simulateCVD shows the image as it looks with Options.CVD, after it was
+// transformed, so that it is simulated on what is shown.
+func (options Options) simulateCVD(decoded decodedImage) decodedImage {
+	matrix, ok := cvdMatrices[options.CVD]
+	if !ok {
+		return decoded
+	}
+
+	return mapFrames(decoded, func(img image.Image) image.Image {
+		return simulateCVD(img, matrix)
+	})
+}
+
+func simulateCVD(img image.Image, matrix [3][3]float64) *image.NRGBA {
+	bounds := img.Bounds()
+
+	// the matrices apply to colors, not to colors premultiplied by alpha
+	simulated := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(simulated, simulated.Bounds(), img, bounds.Min, draw.Src)
+
+	toLinear, toSRGB := srgbTables()
+
+	encode := func(l float64) uint8 {
+		l = min(1, max(0, l))
+		return toSRGB[int(l*(linearLevels-1)+0.5)]
+	}
+
+	pix := simulated.Pix
+	for i := 0; i < len(pix); i += 4 {
+		r, g, b := toLinear[pix[i]], toLinear[pix[i+1]], toLinear[pix[i+2]]
+
+		pix[i] = encode(matrix[0][0]*r + matrix[0][1]*g + matrix[0][2]*b)
+		pix[i+1] = encode(matrix[1][0]*r + matrix[1][1]*g + matrix[1][2]*b)
+		pix[i+2] = encode(matrix[2][0]*r + matrix[2][1]*g + matrix[2][2]*b)
+	}
+
+	return simulated
+}
@@ -0,0 +1,83 @@
+package overlay
+
+import (
+	"image/color"
+	"time"
+)
+
+// With the difference blend mode, pixels that match the screen below are
+// black and the others show how far off they are, which for a subpixel of
+// antialiasing is too dark to see. Options.DiffGain amplifies what differs,
+// Options.DiffChannel shows the difference of one channel on its own, and
+// Options.DiffBlink flashes every pixel that differs at all.
+
+// DiffHighlight is the color pixels that differ flash in.
+var DiffHighlight = color.RGBA{0xff, 0, 0xff, 0xff}
+
+// diffView is how a difference is shown in a frame.
+type diffView struct {
+	gain    float64
+	channel Channel
+	// whether the pixels that differ are in the highlight
+	highlight bool
+}
+
+// diffView returns how differences are shown now, and false if they are
+// shown as they are.
+func (display *Window) diffView(now time.Time) (diffView, bool) {
+	options := display.options
+
+	if options.Blend != BlendDifference {
+		return diffView{}, false
+	}
+
+	view := diffView{gain: max(1, options.DiffGain), channel: options.DiffChannel}
+
+	if options.DiffBlink > 0 {
+		view.highlight = now.UnixNano()/int64(options.DiffBlink)%2 == 0
+	}
+
+	return view, view.gain != 1 || view.channel != ChannelAll || view.highlight
+}
+
+// blendDifference blends the premultiplied source pixel s with alpha onto
+// the opaque backdrop pixel b as the difference and writes it to dst, as
+// view shows it.
+func blendDifference(dst []byte, b []byte, s []byte, alpha uint32, view diffView) {
+	var differences [3]uint32
+	var uncovered [3]uint32
+	differs := false
+
+	for c := range 3 {
+		backdrop := uint32(b[c])
+		covered := backdrop * alpha / 0xff
+		source := uint32(s[c])
+
+		uncovered[c] = backdrop * (0xff - alpha) / 0xff
+		if covered > source {
+			differences[c] = covered - source
+		} else {
+			differences[c] = source - covered
+		}
+	}
+
+	// bgra, like the window
+	switch view.channel {
+	case ChannelRed:
+		differences = [3]uint32{differences[2], differences[2], differences[2]}
+	case ChannelGreen:
+		differences = [3]uint32{differences[1], differences[1], differences[1]}
+	case ChannelBlue:
+		differences = [3]uint32{differences[0], differences[0], differences[0]}
+	}
+
+	for c := range 3 {
+		differs = differs || differences[c] > 0
+		dst[c] = byte(min(uncovered[c]+uint32(float64(differences[c])*view.gain), 0xff))
+	}
+	dst[3] = 0xff
+
+	if view.highlight && differs {
+		dst[0], dst[1], dst[2] = DiffHighlight.B, DiffHighlight.G, DiffHighlight.R
+	}
+}
@@ -0,0 +1,85 @@
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// directions of _NET_WM_MOVERESIZE
+const (
+	moveResizeSizeBottomRight = 4
+	moveResizeMove            = 8
+)
+
+// windowDrag is a move or resize with alt and a mouse button that we carry
+// out ourselves, without a window manager to hand it to.
+type windowDrag struct {
+	resize bool
+	// pointer position on the screen and window geometry when it started
+	startX int
+	startY int
+	x      int
+	y      int
+	width  int
+	height int
+}
+
+// startDrag moves the window with the pointer, or resizes it at the bottom
+// right corner. Window managers get the drag handed over, they know about
+// edges and other windows.
+func (display *Window) startDrag(event xproto.ButtonPressEvent, resize bool) error {
+	if !display.options.OverrideRedirect {
+		direction := uint32(moveResizeMove)
+		if resize {
+			direction = moveResizeSizeBottomRight
+		}
+
+		// the window manager can only grab the pointer once our implicit
+		// grab from the button press is released
+		err := xproto.UngrabPointerChecked(display.conn, event.Time).Check()
+		if err != nil {
+			return fmt.Errorf("ungrab pointer: %w", err)
+		}
+
+		return display.sendRootMessage(
+			"_NET_WM_MOVERESIZE",
+			uint32(int32(event.RootX)),
+			uint32(int32(event.RootY)),
+			direction,
+			uint32(event.Detail),
+			sourceIndicationApplication,
+		)
+	}
+
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return err
+	}
+
+	display.drag = &windowDrag{
+		resize: resize,
+		startX: int(event.RootX),
+		startY: int(event.RootY),
+		x:      x,
+		y:      y,
+		width:  display.windowWidth,
+		height: display.windowHeight,
+	}
+
+	return nil
+}
+
+// dragTo follows the pointer at x, y on the screen.
+func (display *Window) dragTo(x int, y int) error {
+	drag := display.drag
+	dx := x - drag.startX
+	dy := y - drag.startY
+
+	if drag.resize {
+		// without a window manager the aspect ratio is kept here
+		return display.resizeWindow(display.keepAspect(max(1, drag.width+dx), max(1, drag.height+dy)))
+	}
+
+	return display.moveWindow(drag.x+dx, drag.y+dy)
+}
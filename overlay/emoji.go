@@ -0,0 +1,428 @@
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// Emoji fonts like Noto Color Emoji have color bitmaps instead of outlines,
+// PNGs in the CBDT table found through the CBLC table, or in the sbix table
+// of Apple's fonts. sfnt only reads outlines, so the bitmaps are read here
+// and drawn in their own colors instead of the color of the text.
+
+// larger bitmaps are not emoji and not decoded
+const maxColorGlyphSize = 1024
+
+// colorBitmaps are the bitmap tables of a font.
+type colorBitmaps struct {
+	cblc      fontTable
+	cbdt      fontTable
+	sbix      fontTable
+	numGlyphs int
+}
+
+// colorGlyph is a color bitmap scaled to the size of the text, and where
+// its top left corner is relative to the origin of the glyph.
+type colorGlyph struct {
+	image  *image.RGBA
+	offset image.Point
+}
+
+// fontTable reads the big endian values of a table, -1 past its end.
+type fontTable []byte
+
+func (t fontTable) u8(offset int) int {
+	if offset < 0 || offset >= len(t) {
+		return -1
+	}
+
+	return int(t[offset])
+}
+
+func (t fontTable) i8(offset int) int {
+	return int(int8(t.u8(offset)))
+}
+
+func (t fontTable) u16(offset int) int {
+	if offset < 0 || offset > len(t)-2 {
+		return -1
+	}
+
+	return int(binary.BigEndian.Uint16(t[offset:]))
+}
+
+func (t fontTable) i16(offset int) int {
+	return int(int16(t.u16(offset)))
+}
+
+func (t fontTable) u32(offset int) int {
+	if offset < 0 || offset > len(t)-4 {
+		return -1
+	}
+
+	v := binary.BigEndian.Uint32(t[offset:])
+	if v > math.MaxInt32 {
+		return -1
+	}
+
+	return int(v)
+}
+
+func (t fontTable) slice(offset int, length int) []byte {
+	if offset < 0 || length < 0 || offset > len(t) || length > len(t)-offset {
+		return nil
+	}
+
+	return t[offset : offset+length]
+}
+
+// sfntTables returns the tables of the font at index in data, which is a
+// font file or a collection of them.
+func sfntTables(data []byte, index int) map[string]fontTable {
+	file := fontTable(data)
+
+	offset := 0
+	if file.u32(0) == 0x74746366 { // ttcf
+		if index >= file.u32(8) {
+			return nil
+		}
+
+		offset = file.u32(12 + 4*index)
+	}
+
+	numTables := file.u16(offset + 4)
+	tables := map[string]fontTable{}
+
+	for i := range max(0, numTables) {
+		record := offset + 12 + 16*i
+		tag := file.slice(record, 4)
+		table := file.slice(file.u32(record+8), file.u32(record+12))
+		if tag != nil && table != nil {
+			tables[string(tag)] = table
+		}
+	}
+
+	return tables
+}
+
+// parseColorBitmaps returns the bitmap tables of the font at index in
+// data, nil if it has none.
+func parseColorBitmaps(data []byte, index int, numGlyphs int) *colorBitmaps {
+	tables := sfntTables(data, index)
+
+	bitmaps := &colorBitmaps{sbix: tables["sbix"], numGlyphs: numGlyphs}
+	if tables["CBLC"] != nil && tables["CBDT"] != nil {
+		bitmaps.cblc, bitmaps.cbdt = tables["CBLC"], tables["CBDT"]
+	}
+
+	if bitmaps.cblc == nil && bitmaps.sbix == nil {
+		return nil
+	}
+
+	return bitmaps
+}
+
+// glyph returns the bitmap of glyph scaled to size pixels per em, nil if
+// it has none.
+func (bitmaps *colorBitmaps) glyph(glyph int, size float64) *colorGlyph {
+	want := int(math.Ceil(size))
+
+	img, offset, ppem := bitmaps.cbdtGlyph(glyph, want)
+	if img == nil {
+		img, offset, ppem = bitmaps.sbixGlyph(glyph, want, 0)
+	}
+
+	if img == nil || ppem <= 0 {
+		return nil
+	}
+
+	scale := size / float64(ppem)
+	bounds := img.Bounds()
+	width := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+	height := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+
+	return &colorGlyph{
+		image: scaled,
+		offset: image.Pt(
+			int(math.Round(float64(offset.X)*scale)),
+			int(math.Round(float64(offset.Y)*scale)),
+		),
+	}
+}
+
+// betterStrike reports whether a strike of ppem pixels per em is better for
+// want than the best one so far: the smallest one at least as large, or the
+// largest one if there is none.
+func betterStrike(ppem int, best int, want int) bool {
+	switch {
+	case ppem <= 0:
+		return false
+	case best <= 0:
+		return true
+	case ppem >= want:
+		return best < want || ppem < best
+	default:
+		return best < want && ppem > best
+	}
+}
+
+// cbdtGlyph returns the bitmap of glyph in the strike best for want, its top
+// left corner relative to the origin and the pixels per em of the strike.
+func (bitmaps *colorBitmaps) cbdtGlyph(glyph int, want int) (image.Image, image.Point, int) {
+	cblc := bitmaps.cblc
+
+	// the BitmapSize records of the strikes
+	strike, ppem := -1, 0
+	for i := range max(0, min(cblc.u32(4), 256)) {
+		record := 8 + 48*i
+		first, last := cblc.u16(record+40), cblc.u16(record+42)
+		if glyph < first || glyph > last {
+			continue
+		}
+
+		if size := cblc.u8(record + 44); betterStrike(size, ppem, want) {
+			strike, ppem = record, size
+		}
+	}
+
+	if strike < 0 {
+		return nil, image.Point{}, 0
+	}
+
+	array := cblc.u32(strike)
+	for i := range max(0, min(cblc.u32(strike+8), 65536)) {
+		entry := array + 8*i
+		first, last := cblc.u16(entry), cblc.u16(entry+2)
+		if glyph < first || glyph > last {
+			continue
+		}
+
+		sub := array + cblc.u32(entry+4)
+		data, metrics := bitmaps.cbdtData(sub, glyph, first)
+		img, offset := cbdtImage(cblc.u16(sub+2), data, metrics)
+		if img == nil {
+			return nil, image.Point{}, 0
+		}
+
+		return img, offset, ppem
+	}
+
+	return nil, image.Point{}, 0
+}
+
+// cbdtData returns the data of glyph in the index subtable at sub, which
+// starts at the glyph first, and the big metrics of the subtable if it has
+// them.
+func (bitmaps *colorBitmaps) cbdtData(sub int, glyph int, first int) (fontTable, fontTable) {
+	cblc := bitmaps.cblc
+	position := glyph - first
+
+	start, end := -1, -1
+	var metrics fontTable
+
+	switch cblc.u16(sub) {
+	case 1:
+		start, end = cblc.u32(sub+8+4*position), cblc.u32(sub+12+4*position)
+	case 2:
+		size := cblc.u32(sub + 8)
+		start, end = tableOffset(size, position), tableOffset(size, position+1)
+		metrics = cblc.slice(sub+12, 8)
+	case 3:
+		start, end = cblc.u16(sub+8+2*position), cblc.u16(sub+10+2*position)
+	case 4:
+		// pairs of a glyph and its offset for the glyphs that are there,
+		// and one more for the end of the last
+		for i := range max(0, min(cblc.u32(sub+8), 65536)) {
+			pair := sub + 12 + 4*i
+			if cblc.u16(pair) == glyph {
+				start, end = cblc.u16(pair+2), cblc.u16(pair+6)
+				break
+			}
+		}
+	case 5:
+		size := cblc.u32(sub + 8)
+		metrics = cblc.slice(sub+12, 8)
+		for i := range max(0, min(cblc.u32(sub+20), 65536)) {
+			if cblc.u16(sub+24+2*i) == glyph {
+				start, end = tableOffset(size, i), tableOffset(size, i+1)
+				break
+			}
+		}
+	}
+
+	if start < 0 || end < start {
+		return nil, nil
+	}
+
+	return bitmaps.cbdt.slice(cblc.u32(sub+4)+start, end-start), metrics
+}
+
+// tableOffset is the offset of the nth of the bitmaps of size bytes, -1 if
+// it doesn't fit into a table.
+func tableOffset(size int, n int) int {
+	offset := int64(size) * int64(n)
+	if size < 0 || offset > math.MaxInt32 {
+		return -1
+	}
+
+	return int(offset)
+}
+
+// cbdtImage decodes the glyph data of a CBDT table in format and returns
+// where its top left corner is relative to the origin, from the metrics in
+// the data or the big metrics of the index for format 19.
+func cbdtImage(format int, data fontTable, metrics fontTable) (image.Image, image.Point) {
+	var encoded []byte
+
+	switch format {
+	case 17:
+		metrics = data.slice(0, 5)
+		encoded = data.slice(9, data.u32(5))
+	case 18:
+		metrics = data.slice(0, 8)
+		encoded = data.slice(12, data.u32(8))
+	case 19:
+		encoded = data.slice(4, data.u32(0))
+	}
+
+	if encoded == nil || metrics == nil {
+		return nil, image.Point{}
+	}
+
+	img := decodeColorBitmap("png ", encoded)
+	if img == nil {
+		return nil, image.Point{}
+	}
+
+	// small and big metrics both start with the height, the width and the
+	// bearings, the vertical bearing from the baseline up
+	return img, image.Pt(metrics.i8(2), -metrics.i8(3))
+}
+
+// sbixGlyph returns the bitmap of glyph in the sbix strike best for want,
+// its top left corner relative to the origin and the pixels per em of the
+// strike.
+func (bitmaps *colorBitmaps) sbixGlyph(glyph int, want int, dupes int) (image.Image, image.Point, int) {
+	sbix := bitmaps.sbix
+	if glyph < 0 || glyph >= bitmaps.numGlyphs {
+		return nil, image.Point{}, 0
+	}
+
+	strike, ppem := -1, 0
+	for i := range max(0, min(sbix.u32(4), 256)) {
+		offset := sbix.u32(8 + 4*i)
+		if size := sbix.u16(offset); offset >= 0 && betterStrike(size, ppem, want) {
+			strike, ppem = offset, size
+		}
+	}
+
+	if strike < 0 {
+		return nil, image.Point{}, 0
+	}
+
+	start, end := sbix.u32(strike+4+4*glyph), sbix.u32(strike+8+4*glyph)
+	data := fontTable(sbix.slice(strike+start, end-start))
+	if start < 0 || len(data) < 8 {
+		return nil, image.Point{}, 0
+	}
+
+	graphic := string(data[4:8])
+	if graphic == "dupe" && dupes == 0 {
+		return bitmaps.sbixGlyph(data.u16(8), want, dupes+1)
+	}
+
+	img := decodeColorBitmap(graphic, data[8:])
+	if img == nil {
+		return nil, image.Point{}, 0
+	}
+
+	// the origin is the bottom left corner, from the baseline up
+	return img, image.Pt(data.i16(0), -data.i16(2)-img.Bounds().Dy()), ppem
+}
+
+// decodeColorBitmap decodes the png or jpeg of a color glyph, nil for other
+// graphics and ones that don't decode.
+func decodeColorBitmap(graphic string, data []byte) image.Image {
+	var decodeConfig func(r io.Reader) (image.Config, error)
+	var decode func(r io.Reader) (image.Image, error)
+
+	switch graphic {
+	case "png ":
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case "jpg ":
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+	default:
+		return nil
+	}
+
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width > maxColorGlyphSize || config.Height > maxColorGlyphSize {
+		return nil
+	}
+
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	return img
+}
+
+// withoutInvisible drops the characters that only change how the ones
+// around them are drawn, like the variation selector that asks for the
+// emoji of a character, which are no glyphs of their own here.
+func withoutInvisible(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 0x200b && r <= 0x200f, r >= 0x2060 && r <= 0x2064, r == 0xfeff:
+		case r >= 0xfe00 && r <= 0xfe0f, r >= 0xe0000 && r <= 0xe01ef:
+		default:
+			return r
+		}
+
+		return -1
+	}, text)
+}
+
+// drawText draws text with drawer like font.Drawer.DrawString does, color
+// glyphs like emoji in their own colors.
+func drawText(drawer *font.Drawer, text string) {
+	set, _ := drawer.Face.(*FontSet)
+
+	previous := rune(-1)
+	for _, r := range text {
+		if previous >= 0 {
+			drawer.Dot.X += drawer.Face.Kern(previous, r)
+		}
+
+		previous = r
+
+		if set != nil {
+			if glyph, advance, ok := set.colorGlyph(r); ok {
+				origin := image.Pt(drawer.Dot.X.Round(), drawer.Dot.Y.Round()).Add(glyph.offset)
+				draw.Draw(drawer.Dst, glyph.image.Bounds().Add(origin), glyph.image, image.Point{}, draw.Over)
+				drawer.Dot.X += advance
+				continue
+			}
+		}
+
+		bounds, mask, maskPoint, advance, ok := drawer.Face.Glyph(drawer.Dot, r)
+		if ok {
+			draw.DrawMask(drawer.Dst, bounds, drawer.Src, image.Point{}, mask, maskPoint, draw.Over)
+		}
+
+		drawer.Dot.X += advance
+	}
+}
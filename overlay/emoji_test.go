@@ -0,0 +1,333 @@
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var emojiRed = color.RGBA{R: 255, A: 255}
+
+// testEmojiPNG is a red bitmap of 8x6 pixels.
+func testEmojiPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{emojiRed.R, emojiRed.G, emojiRed.B, emojiRed.A})
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testCBDT builds CBLC and CBDT tables with a strike of ppem pixels per em
+// for the glyphs first to first+2, all of them the same bitmap, indexed
+// with indexFormat. The glyphs are 1 pixel to the right of the origin and
+// their top is 5 pixels above the baseline.
+func testCBDT(t *testing.T, indexFormat int, first int, ppem int) (cblc []byte, cbdt []byte) {
+	t.Helper()
+
+	be := binary.BigEndian
+	encoded := testEmojiPNG(t)
+
+	// formats 2 and 5 keep the metrics in the index and use image format
+	// 19, the others image format 17 with small metrics in the data
+	imageFormat := 17
+	if indexFormat == 2 || indexFormat == 5 {
+		imageFormat = 19
+	}
+
+	var glyph []byte
+	if imageFormat == 17 {
+		glyph = append(glyph, 6, 8, 1, 5, 9)
+	}
+
+	glyph = be.AppendUint32(glyph, uint32(len(encoded)))
+	glyph = append(glyph, encoded...)
+
+	cbdt = []byte{0, 3, 0, 0}
+	for range 3 {
+		cbdt = append(cbdt, glyph...)
+	}
+
+	size := uint32(len(glyph))
+	bigMetrics := []byte{6, 8, 1, 5, 9, 0, 0, 0}
+
+	sub := be.AppendUint16(nil, uint16(indexFormat))
+	sub = be.AppendUint16(sub, uint16(imageFormat))
+	sub = be.AppendUint32(sub, 4)
+
+	switch indexFormat {
+	case 1:
+		for i := range 4 {
+			sub = be.AppendUint32(sub, uint32(i)*size)
+		}
+	case 2:
+		sub = be.AppendUint32(sub, size)
+		sub = append(sub, bigMetrics...)
+	case 3:
+		for i := range 4 {
+			sub = be.AppendUint16(sub, uint16(uint32(i)*size))
+		}
+	case 4:
+		sub = be.AppendUint32(sub, 3)
+		for i := range 4 {
+			id := first + i
+			if i == 3 {
+				id = 0
+			}
+
+			sub = be.AppendUint16(sub, uint16(id))
+			sub = be.AppendUint16(sub, uint16(uint32(i)*size))
+		}
+	case 5:
+		sub = be.AppendUint32(sub, size)
+		sub = append(sub, bigMetrics...)
+		sub = be.AppendUint32(sub, 3)
+		for i := range 3 {
+			sub = be.AppendUint16(sub, uint16(first+i))
+		}
+	}
+
+	cblc = []byte{0, 3, 0, 0, 0, 0, 0, 1}
+
+	// the BitmapSize record, its array of subtables follows it
+	record := make([]byte, 48)
+	be.PutUint32(record[0:], 56)
+	be.PutUint32(record[8:], 1)
+	be.PutUint16(record[40:], uint16(first))
+	be.PutUint16(record[42:], uint16(first+2))
+	record[44], record[45], record[46] = byte(ppem), byte(ppem), 32
+	cblc = append(cblc, record...)
+
+	cblc = be.AppendUint16(cblc, uint16(first))
+	cblc = be.AppendUint16(cblc, uint16(first+2))
+	cblc = be.AppendUint32(cblc, 8)
+
+	return append(cblc, sub...), cbdt
+}
+
+func TestCBDTGlyph(t *testing.T) {
+	for _, indexFormat := range []int{1, 2, 3, 4, 5} {
+		cblc, cbdt := testCBDT(t, indexFormat, 5, 32)
+		bitmaps := &colorBitmaps{cblc: cblc, cbdt: cbdt}
+
+		for glyph := 5; glyph <= 7; glyph++ {
+			img, offset, ppem := bitmaps.cbdtGlyph(glyph, 16)
+			if img == nil {
+				t.Fatalf("index format %d: no bitmap for glyph %d", indexFormat, glyph)
+			}
+
+			if img.Bounds().Size() != image.Pt(8, 6) || offset != image.Pt(1, -5) || ppem != 32 {
+				t.Errorf("index format %d: %v at %v in a strike of %d", indexFormat, img.Bounds(), offset, ppem)
+			}
+		}
+
+		for _, glyph := range []int{0, 4, 8} {
+			if img, _, _ := bitmaps.cbdtGlyph(glyph, 16); img != nil {
+				t.Errorf("index format %d: a bitmap for glyph %d", indexFormat, glyph)
+			}
+		}
+	}
+}
+
+func TestCBDTBroken(t *testing.T) {
+	cblc, cbdt := testCBDT(t, 1, 5, 32)
+
+	// every shorter table only has fewer glyphs
+	for n := range len(cblc) {
+		bitmaps := &colorBitmaps{cblc: cblc[:n], cbdt: cbdt}
+		bitmaps.cbdtGlyph(6, 16)
+	}
+
+	for n := range len(cbdt) {
+		bitmaps := &colorBitmaps{cblc: cblc, cbdt: cbdt[:n]}
+		if img, _, _ := bitmaps.cbdtGlyph(7, 16); img != nil {
+			t.Errorf("bitmap from a CBDT table cut after %d bytes", n)
+		}
+	}
+}
+
+func TestSbixGlyph(t *testing.T) {
+	be := binary.BigEndian
+	encoded := testEmojiPNG(t)
+
+	// glyph 1 is the bitmap, 2 the same as 1 and 3 has none
+	strike := func(ppem int) []byte {
+		glyph := []byte{0, 2, 0xff, 0xfd}
+		glyph = append(glyph, "png "...)
+		glyph = append(glyph, encoded...)
+
+		dupe := append([]byte{0, 0, 0, 0}, "dupe"...)
+		dupe = be.AppendUint16(dupe, 1)
+
+		data := be.AppendUint16(nil, uint16(ppem))
+		data = be.AppendUint16(data, 72)
+
+		offset := 4 + 4*5
+		for _, length := range []int{0, len(glyph), len(dupe), 0, 0} {
+			data = be.AppendUint32(data, uint32(offset))
+			offset += length
+		}
+
+		data = append(data, glyph...)
+		return append(data, dupe...)
+	}
+
+	small, large := strike(20), strike(40)
+
+	sbix := []byte{0, 1, 0, 1, 0, 0, 0, 2}
+	sbix = be.AppendUint32(sbix, 16)
+	sbix = be.AppendUint32(sbix, uint32(16+len(small)))
+	sbix = append(sbix, small...)
+	sbix = append(sbix, large...)
+
+	bitmaps := &colorBitmaps{sbix: sbix, numGlyphs: 4}
+
+	tests := []struct {
+		glyph int
+		want  int
+		ppem  int
+	}{
+		{1, 16, 20},
+		{1, 30, 40},
+		{1, 60, 40},
+		{2, 16, 20},
+		{3, 16, 0},
+		{4, 16, 0},
+	}
+
+	for _, test := range tests {
+		img, offset, ppem := bitmaps.sbixGlyph(test.glyph, test.want, 0)
+		if ppem != test.ppem {
+			t.Errorf("glyph %d at %d: strike of %d, want %d", test.glyph, test.want, ppem, test.ppem)
+		}
+
+		if img == nil {
+			continue
+		}
+
+		// 3 pixels below the baseline, 6 high
+		if img.Bounds().Size() != image.Pt(8, 6) || offset != image.Pt(2, -3) {
+			t.Errorf("glyph %d: %v at %v", test.glyph, img.Bounds(), offset)
+		}
+	}
+}
+
+func TestSfntTables(t *testing.T) {
+	be := binary.BigEndian
+
+	// a font with a table "abcd" of 4 bytes, and one past the end of the
+	// file
+	font := []byte{0, 1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0}
+	font = append(font, "abcd"...)
+	font = be.AppendUint32(font, 0)
+	font = be.AppendUint32(font, 44)
+	font = be.AppendUint32(font, 4)
+	font = append(font, "efgh"...)
+	font = be.AppendUint32(font, 0)
+	font = be.AppendUint32(font, 44)
+	font = be.AppendUint32(font, 8)
+	font = append(font, 1, 2, 3, 4)
+
+	tables := sfntTables(font, 0)
+	if !bytes.Equal(tables["abcd"], []byte{1, 2, 3, 4}) || tables["efgh"] != nil {
+		t.Errorf("tables %v", tables)
+	}
+
+	// the same font as the second of a collection, with the offsets of
+	// the tables from the start of the collection
+	collection := []byte("ttcf")
+	collection = append(collection, 0, 1, 0, 0, 0, 0, 0, 2)
+	collection = be.AppendUint32(collection, 20)
+	collection = be.AppendUint32(collection, 20)
+
+	shifted := bytes.Clone(font)
+	be.PutUint32(shifted[12+8:], 44+20)
+	be.PutUint32(shifted[28+8:], 44+20)
+	collection = append(collection, shifted...)
+
+	tables = sfntTables(collection, 1)
+	if !bytes.Equal(tables["abcd"], []byte{1, 2, 3, 4}) {
+		t.Errorf("tables of the collection %v", tables)
+	}
+
+	if sfntTables(collection, 2) != nil {
+		t.Error("tables of a font the collection doesn't have")
+	}
+}
+
+func TestDrawTextColorGlyph(t *testing.T) {
+	set, err := LoadFontSet("", DefaultFontSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 'a' and the two glyphs after it are the red bitmap of a strike twice
+	// the size of the font
+	index, err := set.fonts[0].font.GlyphIndex(&set.fonts[0].buf, 'a')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cblc, cbdt := testCBDT(t, 1, int(index), 2*DefaultFontSize)
+	set.fonts[0].bitmaps = &colorBitmaps{cblc: cblc, cbdt: cbdt}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.White),
+		Face: set,
+		Dot:  fixed.P(10, 20),
+	}
+
+	drawText(&drawer, "ax")
+
+	// half of 8x6 pixels, 1/2 to the right and 5/2 above the baseline
+	want := image.Rect(11, 17, 15, 20)
+	for y := range 30 {
+		for x := range 40 {
+			if image.Pt(x, y).In(want) && dst.RGBAAt(x, y) != emojiRed {
+				t.Fatalf("pixel %d,%d of the bitmap is %v", x, y, dst.RGBAAt(x, y))
+			}
+		}
+	}
+
+	advance, _ := set.GlyphAdvance('a')
+	if got := drawer.Dot.X - fixed.I(10) - advance; got <= 0 {
+		t.Error("x has no advance")
+	}
+
+	// x is drawn in the color of the text after the advance of a
+	white := false
+	for y := range 30 {
+		for x := (fixed.I(10) + advance).Floor(); x < 40; x++ {
+			if c := dst.RGBAAt(x, y); c.R == c.G && c.R > 128 {
+				white = true
+			}
+		}
+	}
+
+	if !white {
+		t.Error("x isn't drawn as an outline")
+	}
+}
+
+func TestWithoutInvisible(t *testing.T) {
+	got := withoutInvisible("ok ❤️ \U0001f469‍\U0001f4bb")
+	if want := "ok ❤ \U0001f469\U0001f4bb"; got != want {
+		t.Errorf("withoutInvisible = %+q, want %+q", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	netWmStateRemove = 0
+	netWmStateAdd    = 1
+	netWmStateToggle = 2
+
+	// tells the window manager that the request comes from a regular
+	// application and not from a pager
+	sourceIndicationApplication = 1
+
+	gravityStatic = 10
+)
+
+func (display *Window) atom(name string) (xproto.Atom, error) {
+	display.atomsMu.Lock()
+	defer display.atomsMu.Unlock()
+
+	if atom, ok := display.atoms[name]; ok {
+		return atom, nil
+	}
+
+	reply, err := xproto.InternAtom(display.conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("intern atom %s: %w", name, err)
+	}
+
+	if display.atoms == nil {
+		display.atoms = map[string]xproto.Atom{}
+	}
+
+	display.atoms[name] = reply.Atom
+
+	return reply.Atom, nil
+}
+
+// internAtoms interns all names that are not cached yet in a single round
+// trip instead of one round trip per atom.
+func (display *Window) internAtoms(names ...string) error {
+	display.atomsMu.Lock()
+	defer display.atomsMu.Unlock()
+
+	if display.atoms == nil {
+		display.atoms = map[string]xproto.Atom{}
+	}
+
+	cookies := map[string]xproto.InternAtomCookie{}
+	for _, name := range names {
+		if _, ok := display.atoms[name]; ok {
+			continue
+		}
+
+		cookies[name] = xproto.InternAtom(display.conn, false, uint16(len(name)), name)
+	}
+
+	for name, cookie := range cookies {
+		reply, err := cookie.Reply()
+		if err != nil {
+			return fmt.Errorf("intern atom %s: %w", name, err)
+		}
+
+		display.atoms[name] = reply.Atom
+	}
+
+	return nil
+}
+
+// sendRootMessage sends a client message about our window to the root window
+// the way EWMH expects requests to the window manager to be sent.
+func (display *Window) sendRootMessage(messageType string, data ...uint32) error {
+	typeAtom, err := display.atom(messageType)
+	if err != nil {
+		return err
+	}
+
+	var values [5]uint32
+	copy(values[:], data)
+
+	event := xproto.ClientMessageEvent{
+		Format: 32,
+		Window: display.windowID,
+		Type:   typeAtom,
+		Data:   xproto.ClientMessageDataUnionData32New(values[:]),
+	}
+
+	err = xproto.SendEventChecked(
+		display.conn,
+		false,
+		display.screen.Root,
+		xproto.EventMaskSubstructureNotify|xproto.EventMaskSubstructureRedirect,
+		string(event.Bytes()),
+	).Check()
+	if err != nil {
+		return fmt.Errorf("send %s: %w", messageType, err)
+	}
+
+	return nil
+}
+
+// changeNetWmState adds, removes or toggles a _NET_WM_STATE_* property of
+// the mapped window.
+func (display *Window) changeNetWmState(mode uint32, state string) error {
+	stateAtom, err := display.atom(state)
+	if err != nil {
+		return err
+	}
+
+	return display.sendRootMessage("_NET_WM_STATE", mode, uint32(stateAtom), 0, sourceIndicationApplication)
+}
+
+// toggleSticky shows the window on all virtual desktops, or only on the
+// current one again.
+func (display *Window) toggleSticky() error {
+	return display.changeNetWmState(netWmStateToggle, "_NET_WM_STATE_STICKY")
+}
+
+func (display *Window) toggleFullscreen() error {
+	return display.changeNetWmState(netWmStateToggle, "_NET_WM_STATE_FULLSCREEN")
+}
+
+// moveWindow moves the window so that its contents end up exactly at x, y,
+// regardless of window decorations.
+func (display *Window) moveWindow(x int, y int) error {
+	left, err := xCoordinate(x)
+	if err != nil {
+		return err
+	}
+
+	top, err := xCoordinate(y)
+	if err != nil {
+		return err
+	}
+
+	// nobody would answer our request without a window manager
+	if display.options.OverrideRedirect {
+		err := xproto.ConfigureWindowChecked(
+			display.conn,
+			display.windowID,
+			xproto.ConfigWindowX|xproto.ConfigWindowY,
+			[]uint32{uint32(int32(left)), uint32(int32(top))},
+		).Check()
+		if err != nil {
+			return fmt.Errorf("configure window: %w", err)
+		}
+
+		return nil
+	}
+
+	const (
+		flagX                = 1 << 8
+		flagY                = 1 << 9
+		flagSourceIndication = sourceIndicationApplication << 12
+	)
+
+	return display.sendRootMessage(
+		"_NET_MOVERESIZE_WINDOW",
+		gravityStatic|flagX|flagY|flagSourceIndication,
+		uint32(int32(left)),
+		uint32(int32(top)),
+	)
+}
+
+// windowPosition returns the position of the window contents relative to the
+// root window.
+func (display *Window) windowPosition() (int, int, error) {
+	reply, err := xproto.TranslateCoordinates(display.conn, display.windowID, display.screen.Root, 0, 0).Reply()
+	if err != nil {
+		return 0, 0, fmt.Errorf("translate coordinates: %w", err)
+	}
+
+	return int(reply.DstX), int(reply.DstY), nil
+}
+
+// raiseWindow puts the window above its siblings, or asks the window manager
+// to.
+func (display *Window) raiseWindow() error {
+	if display.options.OverrideRedirect {
+		err := xproto.ConfigureWindowChecked(
+			display.conn,
+			display.windowID,
+			xproto.ConfigWindowStackMode,
+			[]uint32{xproto.StackModeAbove},
+		).Check()
+		if err != nil {
+			return fmt.Errorf("configure window: %w", err)
+		}
+
+		return nil
+	}
+
+	// no sibling, above all of them
+	return display.sendRootMessage("_NET_RESTACK_WINDOW", sourceIndicationApplication, 0, xproto.StackModeAbove)
+}
+
+var windowTypes = map[string][]string{
+	"normal": {"_NET_WM_WINDOW_TYPE_NORMAL"},
+	"dock":   {"_NET_WM_WINDOW_TYPE_DOCK"},
+	// there is no dedicated type for overlays, notifications are kept on top
+	// and undecorated by most window managers, utility windows are the
+	// fallback for the ones that don't know notifications
+	"overlay": {"_NET_WM_WINDOW_TYPE_NOTIFICATION", "_NET_WM_WINDOW_TYPE_UTILITY"},
+}
+
+func (display *Window) setAtomsProperty(property string, names []string) error {
+	const format32Bit = 32
+
+	propertyAtom, err := display.atom(property)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 0, len(names)*4)
+	for _, name := range names {
+		atom, err := display.atom(name)
+		if err != nil {
+			return err
+		}
+
+		data = append(data, byte(atom), byte(atom>>8), byte(atom>>16), byte(atom>>24))
+	}
+
+	// only used while setting up the window, errors are reported by the
+	// sync at the end of it
+	xproto.ChangeProperty(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		propertyAtom,
+		xproto.AtomAtom,
+		format32Bit,
+		uint32(len(names)),
+		data,
+	)
+
+	return nil
+}
+
+func (display *Window) setWindowType(layer string) error {
+	types, ok := windowTypes[layer]
+	if !ok {
+		return fmt.Errorf("unknown layer %q", layer)
+	}
+
+	return display.setAtomsProperty("_NET_WM_WINDOW_TYPE", types)
+}
+
+// initialStates returns the _NET_WM_STATE_* atoms requested by the options.
+func (display *Window) initialStates() []string {
+	var states []string
+
+	if display.options.Above {
+		states = append(states, "_NET_WM_STATE_ABOVE")
+	}
+
+	if display.options.Below {
+		states = append(states, "_NET_WM_STATE_BELOW")
+	}
+
+	if display.options.Sticky {
+		states = append(states, "_NET_WM_STATE_STICKY")
+	}
+
+	if display.options.Fullscreen || display.output != nil && display.output.fullscreen {
+		states = append(states, "_NET_WM_STATE_FULLSCREEN")
+	}
+
+	return states
+}
+
+// setNoDecorations asks the window manager to not decorate the window using
+// the Motif hints, which are understood by practically every window manager.
+func (display *Window) setNoDecorations() error {
+	const (
+		format32Bit           = 32
+		motifHintsDecorations = 1 << 1
+	)
+
+	hintsAtom, err := display.atom("_MOTIF_WM_HINTS")
+	if err != nil {
+		return err
+	}
+
+	// flags, functions, decorations, input mode, status
+	hints := []uint32{motifHintsDecorations, 0, 0, 0, 0}
+
+	data := make([]byte, 0, len(hints)*4)
+	for _, hint := range hints {
+		data = append(data, byte(hint), byte(hint>>8), byte(hint>>16), byte(hint>>24))
+	}
+
+	xproto.ChangeProperty(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		hintsAtom,
+		hintsAtom,
+		format32Bit,
+		uint32(len(hints)),
+		data,
+	)
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package overlay
+
+import (
+	"time"
+)
+
+// fade steps are rendered at most this often
+const minFadeStep = 16 * time.Millisecond
+
+// opacityFade animates the opacity towards a new value. It is advanced by
+// the renderer, which draws every step.
+type opacityFade struct {
+	active   bool
+	from     float64
+	to       float64
+	duration time.Duration
+	// set when the first step is drawn, so that a fade of a hidden window
+	// starts once it is shown
+	start    time.Time
+	lastStep time.Time
+}
+
+// easeOutCubic starts fast and slows down towards the end, which feels like
+// a direct response to the input.
+func easeOutCubic(progress float64) float64 {
+	inverse := 1 - progress
+	return 1 - inverse*inverse*inverse
+}
+
+// fadeOpacity changes the opacity gradually if --fade is set and instantly
+// otherwise.
+func (display *Window) fadeOpacity(opacity float64) {
+	if display.options.Fade <= 0 {
+		display.setOpacity(opacity)
+		return
+	}
+
+	display.renderMu.Lock()
+	display.opacityFade = opacityFade{
+		active:   true,
+		from:     display.imageOpacity,
+		to:       min(1.0, max(0.0, opacity)),
+		duration: display.options.Fade,
+	}
+	display.renderMu.Unlock()
+
+	display.wakeRenderer()
+
+	display.emit(Event{Kind: EventOpacity, Opacity: min(1.0, max(0.0, opacity))})
+}
+
+// advanceFade sets the opacity for the current step of the fade and reports
+// whether it changed.
+func (display *Window) advanceFade(now time.Time) bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	fade := &display.opacityFade
+	if !fade.active {
+		return false
+	}
+
+	if fade.start.IsZero() {
+		fade.start = now
+	}
+
+	if now.Sub(fade.lastStep) < display.debounce(minFadeStep) {
+		return false
+	}
+
+	fade.lastStep = now
+
+	progress := float64(now.Sub(fade.start)) / float64(fade.duration)
+	if progress >= 1 {
+		fade.active = false
+		display.imageOpacity = fade.to
+		return true
+	}
+
+	display.imageOpacity = fade.from + (fade.to-fade.from)*easeOutCubic(progress)
+
+	return true
+}
+
+// targetOpacity is the opacity once a running fade is over. renderMu has to
+// be held.
+func (display *Window) targetOpacity() float64 {
+	if display.opacityFade.active {
+		return display.opacityFade.to
+	}
+
+	return display.imageOpacity
+}
@@ -0,0 +1,169 @@
+package overlay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	figmaAPI = "https://api.figma.com/v1"
+	// rendering an export takes figma a while for large frames
+	figmaTimeout = time.Minute
+	// the API is rate limited per token
+	defaultFigmaInterval = 30 * time.Second
+)
+
+// Figma is a node of a figma file that is exported and shown whenever the
+// file changed, so that the overlay always shows the current design.
+type Figma struct {
+	FileKey string
+	NodeID  string
+	// a personal access token that can read the file
+	Token string
+	// how often the file is checked for changes, every 30 seconds if it is
+	// zero
+	Interval time.Duration
+}
+
+// ParseFigmaNode parses FILE_KEY:NODE_ID, the node id as the API writes it,
+// 12:34, or as it is written in links, 12-34.
+func ParseFigmaNode(value string) (fileKey string, nodeID string, err error) {
+	fileKey, nodeID, ok := strings.Cut(value, ":")
+	if !ok || fileKey == "" || nodeID == "" {
+		return "", "", fmt.Errorf("figma node %q: expected FILE_KEY:NODE_ID", value)
+	}
+
+	return fileKey, strings.ReplaceAll(nodeID, "-", ":"), nil
+}
+
+func (figma Figma) Name() string {
+	return fmt.Sprintf("figma:%s:%s", figma.FileKey, figma.NodeID)
+}
+
+// get decodes the response of the API to path into response.
+func (figma Figma) get(ctx context.Context, path string, query url.Values, response any) error {
+	ctx, cancel := context.WithTimeout(ctx, figmaTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, figmaAPI+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("X-Figma-Token", figma.Token)
+
+	reply, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", reply.Status)
+	}
+
+	return json.NewDecoder(reply.Body).Decode(response)
+}
+
+// version returns the current version of the file, which changes whenever
+// it is saved.
+func (figma Figma) version(ctx context.Context) (string, error) {
+	var file struct {
+		Version string `json:"version"`
+	}
+
+	// only the top of the document, the version is all we need
+	query := url.Values{"depth": {"1"}}
+
+	err := figma.get(ctx, "/files/"+url.PathEscape(figma.FileKey), query, &file)
+	if err != nil {
+		return "", fmt.Errorf("get file version: %w", err)
+	}
+
+	return file.Version, nil
+}
+
+// export renders the node as a png and downloads it.
+func (figma Figma) export(ctx context.Context) ([]byte, error) {
+	var images struct {
+		Err    *string           `json:"err"`
+		Images map[string]string `json:"images"`
+	}
+
+	query := url.Values{"ids": {figma.NodeID}, "format": {"png"}}
+
+	err := figma.get(ctx, "/images/"+url.PathEscape(figma.FileKey), query, &images)
+	if err != nil {
+		return nil, fmt.Errorf("export node: %w", err)
+	}
+
+	if images.Err != nil {
+		return nil, fmt.Errorf("export node: %s", *images.Err)
+	}
+
+	// null for nodes that don't exist or render to nothing
+	link := images.Images[figma.NodeID]
+	if link == "" {
+		return nil, fmt.Errorf("export node: no image for node %s", figma.NodeID)
+	}
+
+	return downloadImage(link)
+}
+
+// Run shows the export of the node, and exports it again whenever the file
+// changed.
+func (figma Figma) Run(ctx context.Context, sink SourceSink) error {
+	if figma.Interval <= 0 {
+		figma.Interval = defaultFigmaInterval
+	}
+
+	shown := ""
+
+	for {
+		version, err := figma.show(ctx, sink, shown)
+		if err != nil {
+			slog.Error("figma", "err", err)
+			sink.Fail(err)
+		} else {
+			shown = version
+		}
+
+		select {
+		case <-time.After(figma.Interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// show shows the export of the node unless the file is still at the version
+// shown, and returns the version it shows.
+func (figma Figma) show(ctx context.Context, sink SourceSink, shown string) (string, error) {
+	version, err := figma.version(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if version == shown {
+		sink.Unchanged()
+		return version, nil
+	}
+
+	imageBytes, err := figma.export(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	err = sink.ShowEncoded(imageBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
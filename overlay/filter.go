@@ -0,0 +1,55 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+type ScaleFilter string
+
+const (
+	// catmull-rom when shrinking the image, nearest neighbor when it is
+	// enlarged by a whole factor so that pixel art stays crisp
+	FilterAuto       ScaleFilter = "auto"
+	FilterNearest    ScaleFilter = "nearest"
+	FilterBilinear   ScaleFilter = "bilinear"
+	FilterCatmullRom ScaleFilter = "catmullrom"
+)
+
+var scaleFilters = []ScaleFilter{FilterAuto, FilterNearest, FilterBilinear, FilterCatmullRom}
+
+func ParseScaleFilter(name string) (ScaleFilter, error) {
+	for _, filter := range scaleFilters {
+		if string(filter) == name {
+			return filter, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown filter %q", name)
+}
+
+// resolve picks the filter used to scale an image of size src to size dst.
+func (filter ScaleFilter) resolve(src image.Point, dst image.Point) ScaleFilter {
+	if filter != FilterAuto {
+		return filter
+	}
+
+	if dst.X >= src.X && dst.Y >= src.Y && dst.X%src.X == 0 && dst.Y%src.Y == 0 {
+		return FilterNearest
+	}
+
+	return FilterCatmullRom
+}
+
+func (filter ScaleFilter) scaler() draw.Scaler {
+	switch filter {
+	case FilterBilinear:
+		return draw.BiLinear
+	case FilterCatmullRom:
+		return draw.CatmullRom
+	default:
+		return draw.NearestNeighbor
+	}
+}
@@ -0,0 +1,118 @@
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// windowFollower keeps the overlay on top of another window.
+type windowFollower struct {
+	target xproto.Window
+	// the frame the window manager put around the target, moving the frame
+	// doesn't send events to the target itself
+	frame xproto.Window
+}
+
+// topLevelWindow returns the ancestor of window that is a child of the root
+// window, which is the frame of the window if it has one.
+func (display *Window) topLevelWindow(window xproto.Window) (xproto.Window, error) {
+	for {
+		tree, err := xproto.QueryTree(display.conn, window).Reply()
+		if err != nil {
+			return 0, fmt.Errorf("query tree: %w", err)
+		}
+
+		if tree.Parent == tree.Root || tree.Parent == 0 {
+			return window, nil
+		}
+
+		window = tree.Parent
+	}
+}
+
+// targetGeometry returns the position and size of the contents of target on
+// the screen.
+func (display *Window) targetGeometry(target xproto.Window) (Geometry, error) {
+	geom, err := xproto.GetGeometry(display.conn, xproto.Drawable(target)).Reply()
+	if err != nil {
+		return Geometry{}, fmt.Errorf("get geometry: %w", err)
+	}
+
+	position, err := xproto.TranslateCoordinates(display.conn, target, display.screen.Root, 0, 0).Reply()
+	if err != nil {
+		return Geometry{}, fmt.Errorf("translate coordinates: %w", err)
+	}
+
+	return Geometry{
+		Width:       int(geom.Width),
+		Height:      int(geom.Height),
+		X:           int(position.DstX),
+		Y:           int(position.DstY),
+		HasPosition: true,
+	}, nil
+}
+
+// startFollow places the window on top of target when it is created and
+// keeps it there. It has to be called before CreateWindow.
+func (display *Window) startFollow(target xproto.Window) error {
+	geom, err := display.targetGeometry(target)
+	if err != nil {
+		return err
+	}
+
+	display.options.Geometry = geom
+
+	frame, err := display.topLevelWindow(target)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range []xproto.Window{target, frame} {
+		err = xproto.ChangeWindowAttributesChecked(display.conn, window, xproto.CwEventMask, []uint32{xproto.EventMaskStructureNotify}).Check()
+		if err != nil {
+			return fmt.Errorf("select window events: %w", err)
+		}
+	}
+
+	display.follower = &windowFollower{
+		target: target,
+		frame:  frame,
+	}
+
+	return nil
+}
+
+func (display *Window) isFollowed(window xproto.Window) bool {
+	return display.follower != nil && (display.follower.target == window || display.follower.frame == window)
+}
+
+// followTarget moves and resizes the window to match the followed window
+// again.
+func (display *Window) followTarget() error {
+	geom, err := display.targetGeometry(display.follower.target)
+	if err != nil {
+		return err
+	}
+
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return err
+	}
+
+	if x != geom.X || y != geom.Y {
+		err = display.moveWindow(geom.X, geom.Y)
+		if err != nil {
+			return fmt.Errorf("move window: %w", err)
+		}
+	}
+
+	if display.windowWidth != geom.Width || display.windowHeight != geom.Height {
+		err = display.resizeWindow(geom.Width, geom.Height)
+		if err != nil {
+			return fmt.Errorf("resize window: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,387 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Fonts are found with fontconfig, the way other programs on the desktop
+// find them: a font is a file or a pattern like "Noto Sans:bold", and the
+// fonts fontconfig sorts after it are what characters the font lacks are
+// drawn in, like CJK or arabic ones in a latin font. Without fc-match there
+// are only font files and no fallbacks.
+
+// FontSet is a font and the fonts its missing characters are drawn in,
+// which are only loaded once a character needs them.
+type FontSet struct {
+	pattern string
+	size    float64
+
+	mu    sync.Mutex
+	fonts []*setFont
+	// whether fontconfig was asked for the fallbacks yet
+	listed bool
+	byRune map[rune]*setFont
+	// the color bitmaps of runes drawn in a font that has them, nil for
+	// the ones that are outlines
+	colorGlyphs map[rune]*colorGlyph
+}
+
+// setFont is a font of a FontSet, possibly not loaded yet.
+type setFont struct {
+	path  string
+	index int
+	// the characters fontconfig says the font has, nil if it didn't say
+	charset []runeRange
+
+	loaded  bool
+	font    *sfnt.Font
+	face    font.Face
+	bitmaps *colorBitmaps
+	buf     sfnt.Buffer
+}
+
+type runeRange struct {
+	first rune
+	last  rune
+}
+
+// LoadFontSet loads the font file or fontconfig pattern at size pixels, the
+// Go font if pattern is empty.
+func LoadFontSet(pattern string, size float64) (*FontSet, error) {
+	set := &FontSet{
+		pattern:     pattern,
+		size:        size,
+		byRune:      map[rune]*setFont{},
+		colorGlyphs: map[rune]*colorGlyph{},
+	}
+
+	primary := &setFont{}
+	data := goregular.TTF
+
+	if pattern != "" {
+		if info, err := os.Stat(pattern); err == nil && info.Mode().IsRegular() {
+			primary.path = pattern
+		} else {
+			fonts, err := fontconfigFonts(pattern, false)
+			if err != nil {
+				return nil, fmt.Errorf("find font %q: %w", pattern, err)
+			}
+
+			primary = fonts[0]
+		}
+
+		var err error
+		data, err = os.ReadFile(primary.path)
+		if err != nil {
+			return nil, fmt.Errorf("read font: %w", err)
+		}
+	}
+
+	err := primary.parse(data, size)
+	if err != nil {
+		return nil, err
+	}
+
+	set.fonts = []*setFont{primary}
+
+	return set, nil
+}
+
+// parse loads the font from the data of its file.
+func (f *setFont) parse(data []byte, size float64) error {
+	f.loaded = true
+
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return fmt.Errorf("parse font: %w", err)
+	}
+
+	f.font, err = collection.Font(f.index)
+	if err != nil {
+		return fmt.Errorf("parse font: %w", err)
+	}
+
+	f.face, err = opentype.NewFace(f.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return fmt.Errorf("load font: %w", err)
+	}
+
+	f.bitmaps = parseColorBitmaps(data, f.index, f.font.NumGlyphs())
+
+	return nil
+}
+
+func (f *setFont) has(r rune) bool {
+	if f.charset != nil && !runesContain(f.charset, r) {
+		return false
+	}
+
+	index, err := f.font.GlyphIndex(&f.buf, r)
+	return err == nil && index != 0
+}
+
+// Fallbacks returns the files of the fonts missing characters are drawn
+// in, asking fontconfig for them now if it wasn't yet.
+func (set *FontSet) Fallbacks() []string {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.list()
+
+	var paths []string
+	for _, f := range set.fonts[1:] {
+		paths = append(paths, f.path)
+	}
+
+	return paths
+}
+
+// list asks fontconfig for the fallbacks once.
+func (set *FontSet) list() {
+	if set.listed {
+		return
+	}
+
+	set.listed = true
+
+	pattern := set.pattern
+	if pattern == "" || set.fonts[0].path == pattern {
+		// the fonts of a file are the same as the ones of any other
+		pattern = "sans-serif"
+	}
+
+	fonts, err := fontconfigFonts(pattern, true)
+	if err != nil {
+		slog.Debug("no fallback fonts", "err", err)
+		return
+	}
+
+	for _, f := range fonts {
+		if f.path != set.fonts[0].path || f.index != set.fonts[0].index {
+			set.fonts = append(set.fonts, f)
+		}
+	}
+}
+
+// fontFor returns the font r is drawn in, the first font if none has it.
+func (set *FontSet) fontFor(r rune) *setFont {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if f, ok := set.byRune[r]; ok {
+		return f
+	}
+
+	f := set.find(r)
+	set.byRune[r] = f
+
+	return f
+}
+
+func (set *FontSet) find(r rune) *setFont {
+	if set.fonts[0].has(r) {
+		return set.fonts[0]
+	}
+
+	set.list()
+
+	for _, f := range set.fonts[1:] {
+		if f.charset != nil && !runesContain(f.charset, r) {
+			continue
+		}
+
+		if !f.loaded {
+			data, err := os.ReadFile(f.path)
+			if err == nil {
+				err = f.parse(data, set.size)
+			}
+
+			if err != nil {
+				slog.Debug("skip fallback font", "path", f.path, "err", err)
+				continue
+			}
+		}
+
+		if f.face != nil && f.has(r) {
+			return f
+		}
+	}
+
+	return set.fonts[0]
+}
+
+// colorGlyph returns the color bitmap r is drawn as and its advance, ok is
+// false if it is drawn as an outline.
+func (set *FontSet) colorGlyph(r rune) (*colorGlyph, fixed.Int26_6, bool) {
+	f := set.fontFor(r)
+	if f.bitmaps == nil {
+		return nil, 0, false
+	}
+
+	set.mu.Lock()
+	glyph, ok := set.colorGlyphs[r]
+	if !ok {
+		index, err := f.font.GlyphIndex(&f.buf, r)
+		if err == nil && index != 0 {
+			glyph = f.bitmaps.glyph(int(index), set.size)
+		}
+
+		set.colorGlyphs[r] = glyph
+	}
+	set.mu.Unlock()
+
+	if glyph == nil {
+		return nil, 0, false
+	}
+
+	advance, _ := f.face.GlyphAdvance(r)
+
+	return glyph, advance, true
+}
+
+func (set *FontSet) Close() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for _, f := range set.fonts {
+		if f.face != nil {
+			f.face.Close()
+		}
+	}
+
+	return nil
+}
+
+func (set *FontSet) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return set.fontFor(r).face.Glyph(dot, r)
+}
+
+func (set *FontSet) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return set.fontFor(r).face.GlyphBounds(r)
+}
+
+func (set *FontSet) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return set.fontFor(r).face.GlyphAdvance(r)
+}
+
+// Kern only kerns characters of the same font.
+func (set *FontSet) Kern(r0 rune, r1 rune) fixed.Int26_6 {
+	f := set.fontFor(r0)
+	if f != set.fontFor(r1) {
+		return 0
+	}
+
+	return f.face.Kern(r0, r1)
+}
+
+// Metrics are the ones of the first font, the fallbacks are drawn on its
+// lines.
+func (set *FontSet) Metrics() font.Metrics {
+	return set.fonts[0].face.Metrics()
+}
+
+// fontconfigFonts returns the font fontconfig picks for pattern, or with
+// sort all fonts in the order it falls back to them.
+func fontconfigFonts(pattern string, sort bool) ([]*setFont, error) {
+	args := []string{"--format", "%{file}\t%{index}\t%{charset}\n"}
+	if sort {
+		args = append(args, "--sort")
+	}
+
+	out, err := exec.Command("fc-match", append(args, pattern)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("fc-match: %w", err)
+	}
+
+	fonts := parseFontconfigFonts(string(out))
+	if len(fonts) == 0 {
+		return nil, fmt.Errorf("fc-match: no font")
+	}
+
+	return fonts, nil
+}
+
+// parseFontconfigFonts parses the lines of fc-match, a file, the index of
+// the font in it and the characters it has, skipping fonts seen before.
+func parseFontconfigFonts(out string) []*setFont {
+	var fonts []*setFont
+	seen := map[string]bool{}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+
+		index, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		key := fields[0] + "\t" + fields[1]
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		f := &setFont{path: fields[0], index: index}
+		if len(fields) > 2 {
+			f.charset = parseCharset(fields[2])
+		}
+
+		fonts = append(fonts, f)
+	}
+
+	return fonts
+}
+
+// parseCharset parses a fontconfig charset, like "20-7e a0-17f 2010", nil
+// if it doesn't parse.
+func parseCharset(value string) []runeRange {
+	var ranges []runeRange
+
+	for _, field := range strings.Fields(value) {
+		first, last, isRange := strings.Cut(field, "-")
+		if !isRange {
+			last = first
+		}
+
+		from, err := strconv.ParseUint(first, 16, 32)
+		if err != nil {
+			return nil
+		}
+
+		to, err := strconv.ParseUint(last, 16, 32)
+		if err != nil || to < from {
+			return nil
+		}
+
+		ranges = append(ranges, runeRange{rune(from), rune(to)})
+	}
+
+	return ranges
+}
+
+func runesContain(ranges []runeRange, r rune) bool {
+	for _, c := range ranges {
+		if r >= c.first && r <= c.last {
+			return true
+		}
+	}
+
+	return false
+}
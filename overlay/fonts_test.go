@@ -0,0 +1,102 @@
+package overlay
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/image/font/gofont/gomono"
+)
+
+func TestParseCharset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []runeRange
+	}{
+		{"20-7e a0-17f 2010", []runeRange{{0x20, 0x7e}, {0xa0, 0x17f}, {0x2010, 0x2010}}},
+		{"", nil},
+		{"20-7e zz", nil},
+		{"7e-20", nil},
+	}
+
+	for _, test := range tests {
+		got := parseCharset(test.value)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseCharset(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseFontconfigFonts(t *testing.T) {
+	out := "/fonts/NotoSans-Regular.ttf\t0\t20-7e a0-24f\n" +
+		"/fonts/NotoSansCJK-Regular.ttc\t2\t3000-30ff 4e00-9fff\n" +
+		"/fonts/NotoSans-Regular.ttf\t0\t20-7e a0-24f\n" +
+		"/fonts/NotoSansCJK-Regular.ttc\t0\t3000-30ff 4e00-9fff\n" +
+		"/fonts/broken.ttf\tx\t20-7e\n" +
+		"\n"
+
+	fonts := parseFontconfigFonts(out)
+
+	var got []string
+	for _, f := range fonts {
+		got = append(got, f.path+":"+strconv.Itoa(f.index))
+	}
+
+	want := []string{"/fonts/NotoSans-Regular.ttf:0", "/fonts/NotoSansCJK-Regular.ttc:2", "/fonts/NotoSansCJK-Regular.ttc:0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fonts %v, want %v", got, want)
+	}
+
+	if !runesContain(fonts[1].charset, 'レ') || runesContain(fonts[1].charset, 'a') {
+		t.Errorf("charset %v", fonts[1].charset)
+	}
+}
+
+func TestFontSetFallback(t *testing.T) {
+	set, err := LoadFontSet("", DefaultFontSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a first font with only ascii, and one for the rest
+	set.fonts[0].charset = []runeRange{{0x20, 0x7e}}
+	set.listed = true
+
+	fallback := &setFont{path: "gomono"}
+	err = fallback.parse(gomono.TTF, DefaultFontSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set.fonts = append(set.fonts, fallback)
+
+	if set.fontFor('a') != set.fonts[0] {
+		t.Error("ascii isn't drawn in the first font")
+	}
+
+	if set.fontFor('é') != fallback {
+		t.Error("é isn't drawn in the fallback")
+	}
+
+	// neither has it, the first font draws its missing glyph
+	if set.fontFor('レ') != set.fonts[0] {
+		t.Error("a character no font has isn't drawn in the first font")
+	}
+
+	if set.Kern('a', 'é') != 0 {
+		t.Error("characters of different fonts are kerned")
+	}
+
+	if _, ok := set.GlyphAdvance('é'); !ok {
+		t.Error("no advance for é")
+	}
+
+	regular, err := LoadFontSet("", DefaultFontSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.Metrics() != regular.Metrics() {
+		t.Error("the metrics aren't the ones of the first font")
+	}
+}
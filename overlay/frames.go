@@ -0,0 +1,139 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"time"
+)
+
+// Tools that record or analyze what the overlay shows get every frame the
+// window composited with OnFrame, and the next one with Snapshot, as it was
+// put into the window and not read back from the screen like a screenshot.
+// Frames rendered by the X server with --xrender are read back.
+
+// how long Snapshot waits for a frame, the window may be hidden
+const snapshotTimeout = 5 * time.Second
+
+// OnFrame calls fn with every frame the window shows from now on, the size
+// of the window and premultiplied, nil stops calling it. fn is called from
+// the renderer and holds it up, the frame is its own.
+func (display *Window) OnFrame(fn func(frame image.Image)) {
+	display.renderMu.Lock()
+	display.onFrame = fn
+	display.renderMu.Unlock()
+}
+
+// wantsFrames reports whether a frame has to be handed out.
+func (display *Window) wantsFrames() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.onFrame != nil || len(display.frameWaiters) > 0
+}
+
+// emitFrame hands the frame, of the size of the window, to OnFrame and to
+// those waiting in Snapshot.
+func (display *Window) emitFrame(frame *image.RGBA) {
+	display.renderMu.Lock()
+	onFrame := display.onFrame
+	waiters := display.frameWaiters
+	display.frameWaiters = nil
+	display.renderMu.Unlock()
+
+	for _, waiter := range waiters {
+		waiter <- frame
+	}
+
+	if onFrame != nil {
+		onFrame(frame)
+	}
+}
+
+// frameFromBuffer returns the frame in buf, which holds the visible part
+// of window in the byte order of X, with the rest of the window transparent
+// like clearMargins leaves it.
+func (display *Window) frameFromBuffer(buf []byte, window image.Rectangle, visible image.Rectangle) *image.RGBA {
+	frame := image.NewRGBA(image.Rectangle{Max: window.Size()})
+	visible = visible.Sub(window.Min)
+
+	rowSize := visible.Dx() * 4
+	for y := range visible.Dy() {
+		copy(frame.Pix[frame.PixOffset(visible.Min.X, visible.Min.Y+y):], buf[y*rowSize:(y+1)*rowSize])
+	}
+
+	// windows without alpha ignore the fourth byte
+	if display.depth != depthWithAlpha {
+		for i := 3; i < len(frame.Pix); i += 4 {
+			frame.Pix[i] = 0xff
+		}
+	}
+
+	display.applyOpacityHint(frame.Pix)
+	rgbaToBGRA(frame.Pix, display.options.RenderThreads)
+
+	return frame
+}
+
+// emitServerFrame reads back a frame the X server rendered and hands it out.
+func (display *Window) emitServerFrame() {
+	frame, err := display.Screenshot(false)
+	if err != nil {
+		// those waiting give up on their own
+		return
+	}
+
+	display.emitFrame(frame)
+}
+
+// Snapshot returns the next frame the window shows. It fails if there is
+// none within a few seconds, e.g. because the window is hidden.
+func (display *Window) Snapshot() (*image.RGBA, error) {
+	waiter := make(chan *image.RGBA, 1)
+
+	display.renderMu.Lock()
+	display.frameWaiters = append(display.frameWaiters, waiter)
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	select {
+	case frame := <-waiter:
+		return frame, nil
+	case <-time.After(snapshotTimeout):
+		return nil, errors.New("snapshot: no frame was shown, is the window hidden?")
+	case <-display.ctx.Done():
+		return nil, display.ctx.Err()
+	}
+}
+
+// SaveSnapshot writes the next frame as png to path, to stdout if it is "-".
+func (display *Window) SaveSnapshot(path string) error {
+	frame, err := display.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		return writeScreenshot(os.Stdout, frame)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	err = writeScreenshot(file, frame)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// Geometry describes the requested size and position of the window. Zero
+// sizes mean "use the image size" and negative offsets are relative to the
+// right and bottom edges of the screen, like X geometry strings.
+type Geometry struct {
+	Width       int
+	Height      int
+	X           int
+	Y           int
+	XNegative   bool
+	YNegative   bool
+	HasPosition bool
+}
+
+var geometryRegexp = regexp.MustCompile(`^=?(?:(\d+)?[xX](\d+)?)?(?:([+-])(\d+)([+-])(\d+))?$`)
+
+// ParseGeometry parses X style geometry strings like "800x600+100+50",
+// "800x600", "x600" or "-0+0".
+func ParseGeometry(s string) (Geometry, error) {
+	match := geometryRegexp.FindStringSubmatch(s)
+	if match == nil || s == "" {
+		return Geometry{}, fmt.Errorf("invalid geometry %q, expected <width>x<height>{+-}<x>{+-}<y>", s)
+	}
+
+	var g Geometry
+
+	atoi := func(s string) int {
+		if s == "" {
+			return 0
+		}
+
+		// the regexp only matches digits, the only possible error is an
+		// overflow which we treat like an absurdly large value
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 1<<31 - 1
+		}
+
+		return n
+	}
+
+	g.Width = atoi(match[1])
+	g.Height = atoi(match[2])
+
+	if match[3] != "" {
+		g.HasPosition = true
+		g.XNegative = match[3] == "-"
+		g.X = atoi(match[4])
+		g.YNegative = match[5] == "-"
+		g.Y = atoi(match[6])
+	}
+
+	return g, nil
+}
+
+// resolve computes the final window rectangle for an image of the given size
+// on a screen of the given size. If only one dimension is given the other one
+// keeps the aspect ratio of the image.
+//
+// Sizes and positions are limited to what X can address, so that absurdly
+// large values don't overflow, with 32 bit ints in particular.
+func (g Geometry) resolve(imageWidth, imageHeight, screenWidth, screenHeight int) (x, y, width, height int) {
+	width = min(g.Width, math.MaxUint16)
+	height = min(g.Height, math.MaxUint16)
+
+	switch {
+	case width == 0 && height == 0:
+		width = min(imageWidth, math.MaxUint16)
+		height = min(imageHeight, math.MaxUint16)
+	case width == 0:
+		width = max(1, int(min(int64(height)*int64(imageWidth)/int64(imageHeight), math.MaxUint16)))
+	case height == 0:
+		height = max(1, int(min(int64(width)*int64(imageHeight)/int64(imageWidth), math.MaxUint16)))
+	}
+
+	x = min(g.X, math.MaxInt16)
+	if g.XNegative {
+		x = screenWidth - width - x
+	}
+
+	y = min(g.Y, math.MaxInt16)
+	if g.YNegative {
+		y = screenHeight - height - y
+	}
+
+	return clampCoordinate(x), clampCoordinate(y), width, height
+}
+
+// clampCoordinate limits v to the coordinates of the X protocol, for the
+// positions the user asks for. What is drawn uses xCoordinate instead.
+func clampCoordinate(v int) int {
+	return min(max(v, math.MinInt16), math.MaxInt16)
+}
+
+// xCoordinate returns v as a coordinate of the X protocol, which has 16
+// bits for them. Values that don't fit are an error instead of being
+// clamped, which would draw a different area than the buffers hold.
+func xCoordinate(v int) (int16, error) {
+	if v < math.MinInt16 || v > math.MaxInt16 {
+		return 0, fmt.Errorf("coordinate %d is outside of what X can address", v)
+	}
+
+	return int16(v), nil
+}
+
+// xSize returns v as a width or height of the X protocol, an error if it
+// doesn't fit into 16 bits like xCoordinate.
+func xSize(v int) (uint16, error) {
+	if v < 0 || v > math.MaxUint16 {
+		return 0, fmt.Errorf("size %d is outside of what X can address", v)
+	}
+
+	return uint16(v), nil
+}
+
+// xRectangle returns r as a rectangle of the X protocol.
+func xRectangle(r image.Rectangle) (xproto.Rectangle, error) {
+	x, err := xCoordinate(r.Min.X)
+	if err != nil {
+		return xproto.Rectangle{}, err
+	}
+
+	y, err := xCoordinate(r.Min.Y)
+	if err != nil {
+		return xproto.Rectangle{}, err
+	}
+
+	width, err := xSize(r.Dx())
+	if err != nil {
+		return xproto.Rectangle{}, err
+	}
+
+	height, err := xSize(r.Dy())
+	if err != nil {
+		return xproto.Rectangle{}, err
+	}
+
+	return xproto.Rectangle{X: x, Y: y, Width: width, Height: height}, nil
+}
+
+// pixelBytes returns the size of a buffer of width by height pixels of 4
+// bytes, or an error if it doesn't fit into an int, which on 32 bit
+// platforms happens with a large enough window before memory runs out.
+func pixelBytes(width int, height int) (int, error) {
+	if width < 0 || height < 0 || width > 0 && height > math.MaxInt/4/width {
+		return 0, fmt.Errorf("%dx%d pixels are too large for a buffer", width, height)
+	}
+
+	return width * height * 4, nil
+}
@@ -0,0 +1,175 @@
+package overlay
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestXCoordinate(t *testing.T) {
+	tests := []struct {
+		v  int
+		ok bool
+	}{
+		{0, true},
+		{-1, true},
+		{math.MaxInt16, true},
+		{math.MinInt16, true},
+		{math.MaxInt16 + 1, false},
+		{math.MinInt16 - 1, false},
+		{math.MaxInt32, false},
+		{math.MinInt32, false},
+	}
+
+	for _, test := range tests {
+		v, err := xCoordinate(test.v)
+		if (err == nil) != test.ok {
+			t.Errorf("xCoordinate(%d) = %v, want ok %v", test.v, err, test.ok)
+		}
+
+		if err == nil && int(v) != test.v {
+			t.Errorf("xCoordinate(%d) = %d", test.v, v)
+		}
+	}
+}
+
+func TestXSize(t *testing.T) {
+	tests := []struct {
+		v  int
+		ok bool
+	}{
+		{0, true},
+		{1920, true},
+		{math.MaxUint16, true},
+		{math.MaxUint16 + 1, false},
+		{-1, false},
+		{math.MaxInt32, false},
+	}
+
+	for _, test := range tests {
+		v, err := xSize(test.v)
+		if (err == nil) != test.ok {
+			t.Errorf("xSize(%d) = %v, want ok %v", test.v, err, test.ok)
+		}
+
+		if err == nil && int(v) != test.v {
+			t.Errorf("xSize(%d) = %d", test.v, v)
+		}
+	}
+}
+
+func TestXRectangle(t *testing.T) {
+	tests := []struct {
+		name string
+		r    image.Rectangle
+		ok   bool
+	}{
+		{"window", image.Rect(0, 0, 1920, 1080), true},
+		{"negative position", image.Rect(-100, -50, 100, 50), true},
+		{"largest", image.Rect(0, 0, math.MaxUint16, math.MaxUint16), true},
+		{"too wide", image.Rect(0, 0, math.MaxUint16+1, 10), false},
+		{"too high", image.Rect(0, 0, 10, math.MaxUint16+1), false},
+		{"too far right", image.Rect(math.MaxInt16+1, 0, math.MaxInt16+11, 10), false},
+		{"too far left", image.Rect(math.MinInt16-1, 0, 0, 10), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := xRectangle(test.r)
+			if (err == nil) != test.ok {
+				t.Fatalf("err = %v, want ok %v", err, test.ok)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got := image.Rect(int(r.X), int(r.Y), int(r.X)+int(r.Width), int(r.Y)+int(r.Height))
+			if got != test.r {
+				t.Errorf("converted to %v", got)
+			}
+		})
+	}
+}
+
+func TestPixelBytes(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          int64
+		ok            bool
+	}{
+		{"empty", 0, 0, 0, true},
+		{"full hd", 1920, 1080, 1920 * 1080 * 4, true},
+		{"largest window", math.MaxUint16, math.MaxUint16, math.MaxUint16 * math.MaxUint16 * 4, strconv.IntSize == 64},
+		// more than 2^31 bytes, which only fit into 64 bit ints, as with
+		// GOARCH=386
+		{"32 bit overflow", 30000, 30000, 30000 * 30000 * 4, strconv.IntSize == 64},
+		{"just below 2^31", 16383, 32768, 16383 * 32768 * 4, true},
+		{"int overflow", math.MaxInt / 2, 3, 0, false},
+		{"negative", -1, 10, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := pixelBytes(test.width, test.height)
+			if (err == nil) != test.ok {
+				t.Fatalf("err = %v, want ok %v", err, test.ok)
+			}
+
+			if err == nil && int64(got) != test.want {
+				t.Errorf("pixelBytes = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGeometryResolve(t *testing.T) {
+	tests := []struct {
+		name                string
+		geometry            string
+		imageWidth          int
+		imageHeight         int
+		x, y, width, height int
+	}{
+		{"image size", "", 800, 600, 0, 0, 800, 600},
+		{"position", "+10+20", 800, 600, 10, 20, 800, 600},
+		{"from the right", "-10-20", 800, 600, 1920 - 800 - 10, 1080 - 600 - 20, 800, 600},
+		{"width keeps aspect", "400x", 800, 600, 0, 0, 400, 300},
+		{"huge size", "99999999999x99999999999", 800, 600, 0, 0, math.MaxUint16, math.MaxUint16},
+		{"huge image", "", 100000, 10, 0, 0, math.MaxUint16, 10},
+		{"huge position", "+99999999999+99999999999", 800, 600, math.MaxInt16, math.MaxInt16, 800, 600},
+		{"huge negative position", "-99999999999-99999999999", 800, 600, 1920 - 800 - math.MaxInt16, 1080 - 600 - math.MaxInt16, 800, 600},
+		{"huge window from the right", "40000x40000-30000-30000", 800, 600, math.MinInt16, math.MinInt16, 40000, 40000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var g Geometry
+			if test.geometry != "" {
+				var err error
+				g, err = ParseGeometry(test.geometry)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			x, y, width, height := g.resolve(test.imageWidth, test.imageHeight, 1920, 1080)
+			if x != test.x || y != test.y || width != test.width || height != test.height {
+				t.Errorf("resolved to %dx%d%+d%+d, want %dx%d%+d%+d", width, height, x, y, test.width, test.height, test.x, test.y)
+			}
+
+			// what is resolved can always be sent to the server
+			_, err := xCoordinate(x)
+			if err != nil {
+				t.Error(err)
+			}
+
+			_, err = xCoordinate(y)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
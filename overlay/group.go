@@ -0,0 +1,132 @@
+package overlay
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Overlays in the same group share their opacity and visibility: changing
+// them on one member, with keys, ctl or signals, changes them on all others.
+// The members find each other through their control sockets, the changes are
+// sent to every socket and ignored by the overlays of other groups.
+
+// requests for the other members are dropped while they don't keep up
+const groupQueueSize = 16
+
+type controlGroup struct {
+	name string
+	// nil until the overlay listens on a control socket
+	requests chan ControlRequest
+}
+
+func (display *Window) groupName() string {
+	display.groupMu.Lock()
+	defer display.groupMu.Unlock()
+
+	return display.group.name
+}
+
+// joinGroup makes the overlay a member of the group name, or of no group if
+// name is empty.
+func (display *Window) joinGroup(name string) {
+	display.groupMu.Lock()
+	defer display.groupMu.Unlock()
+
+	display.group.name = name
+}
+
+// shareOpacity sends the opacity to the other members of the group.
+func (display *Window) shareOpacity() {
+	opacity := display.opacity()
+	display.shareWithGroup(ControlRequest{Command: "opacity", Opacity: &opacity})
+}
+
+// shareVisible shows or hides the other members of the group.
+func (display *Window) shareVisible(visible bool) {
+	command := "hide"
+	if visible {
+		command = "show"
+	}
+
+	display.shareWithGroup(ControlRequest{Command: command})
+}
+
+func (display *Window) shareWithGroup(request ControlRequest) {
+	display.groupMu.Lock()
+	defer display.groupMu.Unlock()
+
+	if display.group.name == "" || display.group.requests == nil {
+		return
+	}
+
+	request.FromGroup = display.group.name
+
+	select {
+	case display.group.requests <- request:
+	default:
+	}
+}
+
+// applyGroupChange applies a change made on another member of a group. It is
+// not shared again, and ignored by overlays in other groups.
+func (display *Window) applyGroupChange(request ControlRequest) error {
+	if request.FromGroup != display.groupName() {
+		return nil
+	}
+
+	switch request.Command {
+	case "opacity":
+		if request.Opacity == nil {
+			return fmt.Errorf("opacity: missing opacity")
+		}
+
+		display.fadeOpacity(*request.Opacity)
+	case "show":
+		return display.setVisible(true)
+	case "hide":
+		return display.setVisible(false)
+	default:
+		return fmt.Errorf("%s can't be shared with a group", request.Command)
+	}
+
+	return nil
+}
+
+// syncGroup sends the changes shared with the group to the other members, in
+// the order they were made.
+func (server *ControlServer) syncGroup(requests <-chan ControlRequest) {
+	defer server.wg.Done()
+
+	for {
+		select {
+		case request := <-requests:
+			server.sendToGroup(request)
+		case <-server.done:
+			return
+		}
+	}
+}
+
+func (server *ControlServer) sendToGroup(request ControlRequest) {
+	paths, err := ControlSockets()
+	if err != nil {
+		slog.Error("sync group", "err", err)
+		return
+	}
+
+	for _, path := range paths {
+		if path == server.path {
+			continue
+		}
+
+		response, err := SendControl(path, request)
+		// sockets left behind by overlays that crashed
+		if err != nil {
+			continue
+		}
+
+		if !response.OK {
+			slog.Error("sync group", "socket", path, "err", response.Error)
+		}
+	}
+}
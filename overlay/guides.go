@@ -0,0 +1,228 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// thickness of the rulers and the length of their ticks, every tenth
+	// pixel gets a tick, every hundredth a label
+	rulerSize      = 16
+	rulerTick      = 4
+	rulerHalfTick  = 8
+	rulerTickStep  = 10
+	rulerLabelStep = 100
+)
+
+// colors of the layer, premultiplied like everything drawn with the image
+// package
+var (
+	gridColor  = color.RGBA{0x40, 0x40, 0x40, 0x60}
+	guideColor = color.RGBA{0x00, 0xd0, 0xd0, 0xff}
+)
+
+// Guide is a horizontal line at Position pixels from the top of the window,
+// or a vertical one from the left.
+type Guide struct {
+	Position int
+	Vertical bool
+}
+
+// ParseGuides parses lists of guide positions that end in h for horizontal
+// and v for vertical guides, separated by semicolons, e.g. "100,240h;360v".
+func ParseGuides(value string) ([]Guide, error) {
+	var guides []Guide
+
+	for group := range strings.SplitSeq(value, ";") {
+		group = strings.TrimSpace(group)
+		positions, horizontal := strings.CutSuffix(group, "h")
+		if !horizontal {
+			var vertical bool
+			positions, vertical = strings.CutSuffix(group, "v")
+			if !vertical {
+				return nil, fmt.Errorf("guides %q: %q has to end in h or v", value, group)
+			}
+		}
+
+		for position := range strings.SplitSeq(positions, ",") {
+			number, err := strconv.Atoi(strings.TrimSpace(position))
+			if err != nil {
+				return nil, fmt.Errorf("guides %q: %w", value, err)
+			}
+
+			guides = append(guides, Guide{Position: number, Vertical: !horizontal})
+		}
+	}
+
+	return guides, nil
+}
+
+// guideLayer is what is drawn on top of the image to measure it.
+type guideLayer struct {
+	grid   int
+	guides []Guide
+	ruler  bool
+}
+
+// guideLayer returns the layer to draw, the zero value while it is hidden.
+// Without a grid, guides or rulers the toggle shows the rulers.
+func (display *Window) guideLayer() guideLayer {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if !display.showGuides {
+		return guideLayer{}
+	}
+
+	layer := guideLayer{
+		grid:   display.options.Grid,
+		guides: display.options.Guides,
+		ruler:  display.options.Ruler,
+	}
+
+	if layer.empty() {
+		layer.ruler = true
+	}
+
+	return layer
+}
+
+func (layer guideLayer) empty() bool {
+	return layer.grid <= 0 && len(layer.guides) == 0 && !layer.ruler
+}
+
+func (display *Window) toggleGuides() {
+	display.renderMu.Lock()
+	display.showGuides = !display.showGuides
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// drawGuides draws the layer onto buf, which holds the visible part of the
+// window in the byte order of X. Positions are in window pixels, so that
+// they measure the screen no matter how the image is scaled. Like the info
+// panel the layer is not affected by the opacity.
+func drawGuides(buf []byte, visible image.Rectangle, layer guideLayer) {
+	width := visible.Dx()
+
+	fill := func(rect image.Rectangle, c color.RGBA) {
+		rect = rect.Intersect(visible)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				blendPixel(buf[((y-visible.Min.Y)*width+x-visible.Min.X)*4:], c)
+			}
+		}
+	}
+
+	if layer.grid > 0 {
+		for x := visible.Min.X - visible.Min.X%layer.grid; x < visible.Max.X; x += layer.grid {
+			fill(image.Rect(x, visible.Min.Y, x+1, visible.Max.Y), gridColor)
+		}
+
+		for y := visible.Min.Y - visible.Min.Y%layer.grid; y < visible.Max.Y; y += layer.grid {
+			fill(image.Rect(visible.Min.X, y, visible.Max.X, y+1), gridColor)
+		}
+	}
+
+	for _, guide := range layer.guides {
+		if guide.Vertical {
+			fill(image.Rect(guide.Position, visible.Min.Y, guide.Position+1, visible.Max.Y), guideColor)
+		} else {
+			fill(image.Rect(visible.Min.X, guide.Position, visible.Max.X, guide.Position+1), guideColor)
+		}
+	}
+
+	if layer.ruler {
+		top := renderRuler(visible.Min.X, visible.Dx(), false)
+		left := renderRuler(visible.Min.Y, visible.Dy(), true)
+
+		for _, ruler := range []*image.RGBA{top, left} {
+			bounds := ruler.Bounds()
+			for y := bounds.Min.Y; y < min(bounds.Max.Y, visible.Dy()); y++ {
+				for x := bounds.Min.X; x < min(bounds.Max.X, width); x++ {
+					pix := ruler.Pix[ruler.PixOffset(x, y):]
+					blendPixel(buf[(y*width+x)*4:], color.RGBA{pix[0], pix[1], pix[2], pix[3]})
+				}
+			}
+		}
+	}
+}
+
+// blendPixel composites the premultiplied color c over the pixel at the
+// start of dst, which is in the byte order of X.
+func blendPixel(dst []byte, c color.RGBA) {
+	inverse := 255 - uint32(c.A)
+
+	dst[0] = byte(uint32(c.B) + uint32(dst[0])*inverse/255)
+	dst[1] = byte(uint32(c.G) + uint32(dst[1])*inverse/255)
+	dst[2] = byte(uint32(c.R) + uint32(dst[2])*inverse/255)
+	dst[3] = byte(uint32(c.A) + uint32(dst[3])*inverse/255)
+}
+
+// renderRuler draws a ruler of length pixels that starts at window position
+// start, along the top of the window or down its left side. Labels on the
+// vertical ruler are written top to bottom.
+func renderRuler(start int, length int, vertical bool) *image.RGBA {
+	size := image.Pt(length, rulerSize)
+	if vertical {
+		size = image.Pt(rulerSize, length)
+	}
+
+	ruler := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(ruler, ruler.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	drawer := font.Drawer{
+		Dst:  ruler,
+		Src:  image.White,
+		Face: face,
+	}
+
+	first := start + (rulerTickStep-start%rulerTickStep)%rulerTickStep
+	for position := first; position < start+length; position += rulerTickStep {
+		offset := position - start
+
+		tick := rulerTick
+		switch {
+		case position%rulerLabelStep == 0:
+			tick = rulerSize
+		case position%(rulerLabelStep/2) == 0:
+			tick = rulerHalfTick
+		}
+
+		line := image.Rect(offset, rulerSize-tick, offset+1, rulerSize)
+		if vertical {
+			line = image.Rect(rulerSize-tick, offset, rulerSize, offset+1)
+		}
+
+		draw.Draw(ruler, line, image.White, image.Point{}, draw.Src)
+
+		if position%rulerLabelStep != 0 {
+			continue
+		}
+
+		label := strconv.Itoa(position)
+		if !vertical {
+			drawer.Dot = fixed.P(offset+2, face.Ascent)
+			drawer.DrawString(label)
+			continue
+		}
+
+		for i, digit := range label {
+			drawer.Dot = fixed.P(1, offset+2+i*face.Height+face.Ascent)
+			drawer.DrawString(string(digit))
+		}
+	}
+
+	return ruler
+}
@@ -0,0 +1,165 @@
+package overlay
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+// ? shows the keys that are bound, as they are bound with --bind, until it
+// is pressed again or another key is.
+
+// the space between the columns of the help
+const helpColumnGap = 3
+
+// String returns the combination the way ParseKeyCombo parses it.
+func (combo KeyCombo) String() string {
+	var parts []string
+	for _, modifier := range []string{"ctrl", "alt", "super", "shift"} {
+		if combo.modifiers&modifierNames[modifier] != 0 {
+			parts = append(parts, modifier)
+		}
+	}
+
+	key := fmt.Sprintf("0x%x", combo.keysym)
+	if combo.keysym >= keysymLatin1Start && combo.keysym <= keysymLatin1End {
+		key = string(rune(combo.keysym))
+	}
+
+	for name, keysym := range namedKeysyms {
+		if keysym == combo.keysym {
+			key = name
+			break
+		}
+	}
+
+	return strings.Join(append(parts, key), "+")
+}
+
+// helpLines lists the keys of every action, in the order of actions and
+// the others by name, and the global keys.
+func (options Options) helpLines() []string {
+	keys := map[string][]string{}
+	for combo, a := range options.Keymap {
+		keys[string(a)] = append(keys[string(a)], combo.String())
+	}
+
+	if options.ToggleKey != nil {
+		keys["show-hide (global)"] = []string{options.ToggleKey.String()}
+	}
+
+	if options.PrivacyKey != nil {
+		keys["privacy (global)"] = []string{options.PrivacyKey.String()}
+	}
+
+	names := slices.SortedFunc(maps.Keys(keys), func(a string, b string) int {
+		ia, ib := slices.Index(actions, action(a)), slices.Index(actions, action(b))
+		if ia < 0 {
+			ia = len(actions)
+		}
+
+		if ib < 0 {
+			ib = len(actions)
+		}
+
+		return cmp.Or(cmp.Compare(ia, ib), strings.Compare(a, b))
+	})
+
+	width := 0
+	for _, name := range names {
+		slices.Sort(keys[name])
+		width = max(width, len(strings.Join(keys[name], ", ")))
+	}
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%-*s  %s", width, strings.Join(keys[name], ", "), name))
+	}
+
+	return lines
+}
+
+// renderHelpPanel draws lines in as many columns as it takes to fit them
+// into height.
+func renderHelpPanel(lines []string, height int) *image.RGBA {
+	face := basicfont.Face7x13
+
+	perColumn := max(1, (height-2*infoMargin-2*labelPadding)/face.Height)
+
+	var columns [][]string
+	for chunk := range slices.Chunk(lines, perColumn) {
+		columns = append(columns, chunk)
+	}
+
+	if len(columns) <= 1 {
+		return renderInfoPanel(lines)
+	}
+
+	// the columns side by side, padded to the widest line of each
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		for _, line := range column {
+			widths[i] = max(widths[i], len([]rune(line)))
+		}
+	}
+
+	rows := make([]string, perColumn)
+	for i, column := range columns {
+		for row := range rows {
+			line := ""
+			if row < len(column) {
+				line = column[row]
+			}
+
+			if i < len(columns)-1 {
+				line = fmt.Sprintf("%-*s%s", widths[i], line, strings.Repeat(" ", helpColumnGap))
+			}
+
+			rows[row] += line
+		}
+	}
+
+	return renderInfoPanel(rows)
+}
+
+// helpPanel returns the help for a window of height, nil while it is
+// hidden.
+func (display *Window) helpPanel(height int) *image.RGBA {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if !display.showHelp {
+		return nil
+	}
+
+	if display.helpCache == nil || display.helpHeight != height {
+		display.helpCache = renderHelpPanel(display.options.helpLines(), height)
+		display.helpHeight = height
+	}
+
+	return display.helpCache
+}
+
+func (display *Window) toggleHelp() {
+	display.renderMu.Lock()
+	display.showHelp = !display.showHelp
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+func (display *Window) hideHelp() {
+	display.renderMu.Lock()
+	shown := display.showHelp
+	display.showHelp = false
+	display.renderMu.Unlock()
+
+	if shown {
+		display.requestRedraw()
+	}
+}
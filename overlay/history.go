@@ -0,0 +1,196 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Live sources keep their last Options.History images, which history-back and
+// history-forward step through to review what went by, e.g. a glitch of a
+// monitored feed. Images that arrive meanwhile are kept but not shown until
+// the newest one is reached again, a timeline at the bottom shows where in
+// the history the image is.
+
+const (
+	timelineTrack = 8
+	// the frame shown is marked this wide
+	timelineMarker = 3
+)
+
+type historyFrame struct {
+	source  string
+	decoded decodedImage
+	at      time.Time
+}
+
+// frameHistory is a ring buffer of the last images of a live source.
+type frameHistory struct {
+	frames []historyFrame
+	// where the next frame goes, and how many there are
+	next  int
+	count int
+	// how many frames before the newest the one shown is, 0 while live
+	back int
+}
+
+func newFrameHistory(size int) *frameHistory {
+	return &frameHistory{frames: make([]historyFrame, size)}
+}
+
+// add keeps frame and reports whether it is to be shown, which it isn't while
+// going through the history.
+func (history *frameHistory) add(frame historyFrame) bool {
+	history.frames[history.next] = frame
+	history.next = (history.next + 1) % len(history.frames)
+	history.count = min(history.count+1, len(history.frames))
+
+	if history.back == 0 {
+		return true
+	}
+
+	// the frame shown is one further back now, unless it was dropped
+	history.back = min(history.back+1, history.count-1)
+
+	return false
+}
+
+// frame returns the frame back frames before the newest.
+func (history *frameHistory) frame(back int) historyFrame {
+	size := len(history.frames)
+	return history.frames[((history.next-1-back)%size+size)%size]
+}
+
+// step moves delta frames towards the newest one, and reports whether it
+// moved at all.
+func (history *frameHistory) step(delta int) bool {
+	back := min(max(0, history.count-1), max(0, history.back-delta))
+	if back == history.back {
+		return false
+	}
+
+	history.back = back
+
+	return true
+}
+
+// setLiveImage shows the next image of a live source, or only keeps it in
+// the history while going through it.
+func (display *Window) setLiveImage(source string, decoded decodedImage) {
+	display.markFresh()
+	display.recordImage(decoded.image)
+
+	if !display.keepFrame(source, decoded) {
+		// the timeline got longer
+		display.requestRedraw()
+		return
+	}
+
+	display.setImage(source, decoded)
+}
+
+// keepFrame keeps the image in the history, if there is one, and reports
+// whether it is to be shown.
+func (display *Window) keepFrame(source string, decoded decodedImage) bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if display.history == nil {
+		return true
+	}
+
+	return display.history.add(historyFrame{source: source, decoded: decoded, at: time.Now()})
+}
+
+// scrubHistory shows the image delta images newer than the one shown, the
+// newest one is shown live again.
+func (display *Window) scrubHistory(delta int) {
+	display.renderMu.Lock()
+	history := display.history
+	if history == nil || !history.step(delta) {
+		display.renderMu.Unlock()
+		return
+	}
+
+	frame := history.frame(history.back)
+	display.renderMu.Unlock()
+
+	display.setImage(frame.source, frame.decoded)
+}
+
+// timelinePanel returns the timeline for a window width wide, nil while the
+// newest image is shown.
+func (display *Window) timelinePanel(width int) *image.RGBA {
+	display.renderMu.Lock()
+	history := display.history
+	if history == nil || history.back == 0 {
+		display.renderMu.Unlock()
+		return nil
+	}
+
+	back := history.back
+	times := make([]time.Time, history.count)
+	for i := range times {
+		times[i] = history.frame(history.count - 1 - i).at
+	}
+	display.renderMu.Unlock()
+
+	return renderTimeline(width-2*infoMargin, times, len(times)-1-back)
+}
+
+// renderTimeline draws a tick for every time, oldest first, with the one at
+// shown marked, below when it arrived.
+func renderTimeline(width int, times []time.Time, shown int) *image.RGBA {
+	track := width - 2*labelPadding - timelineMarker
+	if track <= 0 {
+		return nil
+	}
+
+	face := basicfont.Face7x13
+	panel := image.NewRGBA(image.Rect(0, 0, width, labelHeight+timelineTrack+labelPadding))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	oldest := times[0]
+	newest := times[len(times)-1]
+	span := newest.Sub(oldest)
+
+	// where a frame is on the track, by when it arrived or evenly spread if
+	// they all arrived at once
+	position := func(i int) int {
+		x := 0
+		switch {
+		case span > 0:
+			x = int(float64(track) * float64(times[i].Sub(oldest)) / float64(span))
+		case len(times) > 1:
+			x = track * i / (len(times) - 1)
+		}
+
+		return labelPadding + x
+	}
+
+	top := labelHeight
+	for i := range times {
+		tick := image.Rect(position(i), top+timelineTrack/4, position(i)+1, top+timelineTrack*3/4)
+		draw.Draw(panel, tick, image.NewUniform(placeholderForeground), image.Point{}, draw.Src)
+	}
+
+	marker := image.Rect(position(shown)-timelineMarker/2, top, position(shown)-timelineMarker/2+timelineMarker, top+timelineTrack)
+	draw.Draw(panel, marker, image.NewUniform(staleColor), image.Point{}, draw.Src)
+
+	text := fmt.Sprintf("%d/%d  %s  -%s", shown+1, len(times), times[shown].Format("15:04:05.000"), newest.Sub(times[shown]).Round(time.Millisecond))
+
+	drawer := font.Drawer{
+		Dst:  panel,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(labelPadding, labelPadding+face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	return panel
+}
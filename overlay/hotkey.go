@@ -0,0 +1,118 @@
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// grabbed keys also have to be grabbed with caps lock and num lock, which
+// are modifiers as far as the server is concerned
+var ignoredModifiers = []uint16{0, xproto.ModMaskLock, xproto.ModMask2, xproto.ModMaskLock | xproto.ModMask2}
+
+type grabbedKey struct {
+	keycode   xproto.Keycode
+	modifiers uint16
+}
+
+// globalKeys returns the keys that work while other windows have the focus.
+func (display *Window) globalKeys() []KeyCombo {
+	if display.options.LockInput {
+		return nil
+	}
+
+	var combos []KeyCombo
+	for _, combo := range []*KeyCombo{display.options.ToggleKey, display.options.PrivacyKey} {
+		if combo != nil {
+			combos = append(combos, *combo)
+		}
+	}
+
+	return combos
+}
+
+// grabGlobalKeys grabs the global keys on the root window, so that they
+// reach us while other windows have the focus. It has to be called again
+// when the keyboard mapping changes.
+func (display *Window) grabGlobalKeys() error {
+	display.grabMu.Lock()
+	defer display.grabMu.Unlock()
+
+	display.ungrabGlobalKeys()
+
+	for _, combo := range display.globalKeys() {
+		err := display.grabKey(combo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return display.grabPresenterKeys()
+}
+
+func (display *Window) grabKey(combo KeyCombo) error {
+	setup := xproto.Setup(display.conn)
+
+	// every key that produces the combination, with shift if the keysym is
+	// on the shifted level
+	var keys []grabbedKey
+	for keycode := int(setup.MinKeycode); keycode <= int(setup.MaxKeycode); keycode++ {
+		for _, modifiers := range []uint16{combo.modifiers, combo.modifiers | xproto.ModMaskShift} {
+			if display.keyboard.lookup(xproto.Keycode(keycode), modifiers) == combo {
+				keys = append(keys, grabbedKey{keycode: xproto.Keycode(keycode), modifiers: modifiers})
+				break
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("%w 0x%x", errUnmappedKey, combo.keysym)
+	}
+
+	for _, key := range keys {
+		for _, ignored := range ignoredModifiers {
+			err := xproto.GrabKeyChecked(
+				display.conn,
+				true,
+				display.screen.Root,
+				key.modifiers|ignored,
+				key.keycode,
+				xproto.GrabModeAsync,
+				xproto.GrabModeAsync,
+			).Check()
+			if err != nil {
+				// another client has the key already
+				return fmt.Errorf("grab key: %w", err)
+			}
+		}
+
+		display.grabbedKeys = append(display.grabbedKeys, key)
+	}
+
+	return nil
+}
+
+func (display *Window) ungrabGlobalKeys() {
+	for _, key := range display.grabbedKeys {
+		for _, ignored := range ignoredModifiers {
+			xproto.UngrabKey(display.conn, key.keycode, display.screen.Root, key.modifiers|ignored)
+		}
+	}
+
+	display.grabbedKeys = nil
+}
+
+// toggleVisible hides the window if it is shown and shows it otherwise, along
+// with the other members of its group.
+func (display *Window) toggleVisible() error {
+	visible := !display.isMapped()
+
+	err := display.setVisible(visible)
+	if err != nil {
+		return err
+	}
+
+	display.shareVisible(visible)
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package overlay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long clients get to send the headers and the whole request, long
+	// enough for the largest image over a slow connection
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = 2 * time.Minute
+)
+
+// The HTTP API offers the commands of the control socket to other machines
+// and to browsers:
+//
+//	GET  /state     the state as the state command returns it
+//	POST /image     show the image in the body
+//	PUT  /opacity   {"opacity": 0.5}, or {"opacity": 0.1, "relative": true}
+//	PUT  /geometry  {"x": 0, "y": 0, "width": 800, "height": 600}, each pair
+//	                is optional
+//	POST /next, /previous, /show, /hide, /toggle, /sticky, /fullscreen
+//
+// Every request is answered with a ControlResponse that holds the state
+// after it.
+//
+// Without a token, browsers could control an overlay on 127.0.0.1 from any
+// page that is visited. Such requests are refused: ones from another origin,
+// ones for a host name, which another site can point at 127.0.0.1, and ones
+// with a body a form could post.
+
+// HTTPServer is the HTTP API of an overlay.
+type HTTPServer struct {
+	server   *http.Server
+	listener net.Listener
+	display  *Window
+	wg       sync.WaitGroup
+}
+
+// ListenHTTP serves the HTTP API on address, e.g. "127.0.0.1:7878". Anyone
+// who can reach the address controls the overlay, unless access restricts
+// it.
+func (display *Window) ListenHTTP(address string, access AccessControl) (*HTTPServer, error) {
+	guard := newAccessGuard(access)
+
+	listener, err := guard.listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &HTTPServer{
+		listener: listener,
+		display:  display,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /state", guard.handler("state", server.handle(nil)))
+	mux.HandleFunc("POST /image", guard.handler("image", server.handle(server.showImage)))
+	mux.HandleFunc("PUT /opacity", guard.handler("opacity", server.handle(server.setOpacity)))
+	mux.HandleFunc("PUT /geometry", guard.handler("geometry", server.handle(server.setGeometry)))
+
+	for _, command := range []string{"next", "previous", "show", "hide", "toggle", "sticky", "fullscreen"} {
+		mux.HandleFunc("POST /"+command, guard.handler(command, server.handle(func(*http.Request) error {
+			_, err := display.runControl(ControlRequest{Command: command})
+			return err
+		})))
+	}
+
+	var handler http.Handler = mux
+	if access.Token == "" {
+		handler = refuseBrowsers(mux, apiAccepts)
+	}
+
+	server.server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *HTTPServer) serve() {
+	defer server.wg.Done()
+
+	err := server.server.Serve(server.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("serve http", "err", err)
+	}
+}
+
+func (server *HTTPServer) Close() {
+	server.server.Close()
+	server.wg.Wait()
+}
+
+// refuseBrowsers refuses the requests a page in a browser could make without
+// the consent of the API, accepts tells which bodies it takes.
+func refuseBrowsers(next http.Handler, accepts func(r *http.Request, contentType string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := browserRequest(r, accepts)
+		if reason != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ControlResponse{Error: reason + ", send a token to allow it"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// browserRequest returns why r may come from a page in a browser, or ""
+// if it can't.
+func browserRequest(r *http.Request, accepts func(r *http.Request, contentType string) bool) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	// pages only get the ip of their own host name, unless its dns points
+	// at another one
+	if net.ParseIP(strings.Trim(host, "[]")) == nil && host != "localhost" {
+		return fmt.Sprintf("host %q is not an ip address", r.Host)
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return fmt.Sprintf("origin %q is another site", origin)
+		}
+	}
+
+	if r.Method == http.MethodGet || r.ContentLength == 0 && r.Header.Get("Content-Type") == "" {
+		return ""
+	}
+
+	// forms can post other types to any site
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if accepts(r, contentType) {
+		return ""
+	}
+
+	return fmt.Sprintf("content type %q is not accepted for %s %s", contentType, r.Method, r.URL.Path)
+}
+
+// apiAccepts tells whether the api takes a body of contentType for r.
+func apiAccepts(r *http.Request, contentType string) bool {
+	switch {
+	case contentType == "application/json" && r.Method == http.MethodPut,
+		contentType == "application/octet-stream" && r.URL.Path == "/image",
+		strings.HasPrefix(contentType, "image/") && r.URL.Path == "/image":
+		return true
+	}
+
+	return false
+}
+
+// handle runs change, if there is one, and answers with the state after it.
+func (server *HTTPServer) handle(change func(*http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		respond := func(status int, response ControlResponse) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}
+
+		if change != nil {
+			err := change(r)
+			if err != nil {
+				respond(http.StatusBadRequest, ControlResponse{Error: err.Error()})
+				return
+			}
+		}
+
+		state, err := server.display.state()
+		if err != nil {
+			respond(http.StatusInternalServerError, ControlResponse{Error: err.Error()})
+			return
+		}
+
+		respond(http.StatusOK, ControlResponse{OK: true, State: state})
+	}
+}
+
+// decodeBody decodes the JSON body of r into body.
+func decodeBody(r *http.Request, body any) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(body)
+	if err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+
+	return nil
+}
+
+func (server *HTTPServer) showImage(r *http.Request) error {
+	imageBytes, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookImageSize+1))
+	if err != nil {
+		return fmt.Errorf("read image: %w", err)
+	}
+
+	if len(imageBytes) > maxWebhookImageSize {
+		return fmt.Errorf("image is larger than %d bytes", maxWebhookImageSize)
+	}
+
+	display := server.display
+
+	decoded, err := decodeImage(imageBytes, display.options.Animate, display.decodeTarget())
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+
+	display.setImage("http", decoded)
+
+	return nil
+}
+
+// the bodies of PUT /opacity and /geometry, only these fields are taken
+// from them so that they can't run another command or reach another window
+type opacityBody struct {
+	Opacity  *float64 `json:"opacity"`
+	Relative bool     `json:"relative"`
+}
+
+type geometryBody struct {
+	X      *int `json:"x"`
+	Y      *int `json:"y"`
+	Width  *int `json:"width"`
+	Height *int `json:"height"`
+}
+
+func opacityRequest(r *http.Request) (ControlRequest, error) {
+	var body opacityBody
+
+	err := decodeBody(r, &body)
+	if err != nil {
+		return ControlRequest{}, err
+	}
+
+	return ControlRequest{Command: "opacity", Opacity: body.Opacity, Relative: body.Relative}, nil
+}
+
+// geometryRequests returns the move, the resize or both that the body asks
+// for.
+func geometryRequests(r *http.Request) ([]ControlRequest, error) {
+	var body geometryBody
+
+	err := decodeBody(r, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []ControlRequest
+	if body.X != nil || body.Y != nil {
+		requests = append(requests, ControlRequest{Command: "move", X: body.X, Y: body.Y})
+	}
+
+	if body.Width != nil || body.Height != nil {
+		requests = append(requests, ControlRequest{Command: "resize", Width: body.Width, Height: body.Height})
+	}
+
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("geometry: expected x and y, width and height, or both")
+	}
+
+	return requests, nil
+}
+
+func (server *HTTPServer) setOpacity(r *http.Request) error {
+	request, err := opacityRequest(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = server.display.runControl(request)
+
+	return err
+}
+
+func (server *HTTPServer) setGeometry(r *http.Request) error {
+	requests, err := geometryRequests(r)
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		_, err := server.display.runControl(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package overlay
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBrowserRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		host        string
+		origin      string
+		contentType string
+		body        string
+		refused     bool
+	}{
+		{"state", "GET", "/state", "127.0.0.1:7878", "", "", "", false},
+		{"localhost", "GET", "/state", "localhost:7878", "", "", "", false},
+		{"ipv6", "GET", "/state", "[::1]:7878", "", "", "", false},
+		{"curl image", "POST", "/image", "127.0.0.1:7878", "", "image/png", "png", false},
+		{"octet stream", "POST", "/image", "127.0.0.1:7878", "", "application/octet-stream", "png", false},
+		{"json opacity", "PUT", "/opacity", "127.0.0.1:7878", "", "application/json; charset=utf-8", "{}", false},
+		{"command without body", "POST", "/next", "127.0.0.1:7878", "", "", "", false},
+		{"same origin", "POST", "/next", "127.0.0.1:7878", "http://127.0.0.1:7878", "", "", false},
+
+		{"rebound host name", "GET", "/state", "attacker.example:7878", "", "", "", true},
+		{"other origin", "POST", "/next", "127.0.0.1:7878", "https://attacker.example", "", "", true},
+		{"null origin", "POST", "/next", "127.0.0.1:7878", "null", "", "", true},
+		{"form image", "POST", "/image", "127.0.0.1:7878", "", "text/plain", "png", true},
+		{"form command", "POST", "/next", "127.0.0.1:7878", "", "application/x-www-form-urlencoded", "a=b", true},
+		{"image for opacity", "PUT", "/opacity", "127.0.0.1:7878", "", "image/png", "{}", true},
+		{"json image", "POST", "/image", "127.0.0.1:7878", "", "application/json", "{}", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			r.Host = test.host
+
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+
+			reason := browserRequest(r, apiAccepts)
+			if (reason != "") != test.refused {
+				t.Errorf("browserRequest = %q, want refused %v", reason, test.refused)
+			}
+		})
+	}
+}
+
+func TestBodyRequests(t *testing.T) {
+	body := `{"command": "quit", "path": "/home/u/.bashrc", "window": 7, "from_group": "g", "opacity": 0.4, "x": 10, "width": 200}`
+
+	opacity, err := opacityRequest(httptest.NewRequest("PUT", "/opacity", strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 0.4
+	if !reflect.DeepEqual(opacity, ControlRequest{Command: "opacity", Opacity: &want}) {
+		t.Errorf("opacity request %+v", opacity)
+	}
+
+	geometry, err := geometryRequests(httptest.NewRequest("PUT", "/geometry", strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, width := 10, 200
+	wantGeometry := []ControlRequest{{Command: "move", X: &x}, {Command: "resize", Width: &width}}
+	if !reflect.DeepEqual(geometry, wantGeometry) {
+		t.Errorf("geometry requests %+v", geometry)
+	}
+
+	_, err = geometryRequests(httptest.NewRequest("PUT", "/geometry", strings.NewReader(`{"command": "quit"}`)))
+	if err == nil {
+		t.Error("a geometry without x, y, width or height")
+	}
+}
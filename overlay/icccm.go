@@ -0,0 +1,191 @@
+package overlay
+
+import (
+	"image"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// flags of the WM_SIZE_HINTS structure
+const (
+	sizeHintUSPosition  = 1 << 0
+	sizeHintUSSize      = 1 << 1
+	sizeHintPMinSize    = 1 << 4
+	sizeHintPMaxSize    = 1 << 5
+	sizeHintPAspect     = 1 << 7
+	sizeHintPWinGravity = 1 << 9
+)
+
+// sizeHints mirrors the WM_SIZE_HINTS structure used by WM_NORMAL_HINTS.
+type sizeHints struct {
+	flags      uint32
+	x, y       int32
+	width      int32
+	height     int32
+	minWidth   int32
+	minHeight  int32
+	maxWidth   int32
+	maxHeight  int32
+	widthInc   int32
+	heightInc  int32
+	minAspectX int32
+	minAspectY int32
+	maxAspectX int32
+	maxAspectY int32
+	baseWidth  int32
+	baseHeight int32
+	winGravity int32
+}
+
+func (hints sizeHints) bytes() []byte {
+	values := []int32{
+		int32(hints.flags),
+		hints.x, hints.y,
+		hints.width, hints.height,
+		hints.minWidth, hints.minHeight,
+		hints.maxWidth, hints.maxHeight,
+		hints.widthInc, hints.heightInc,
+		hints.minAspectX, hints.minAspectY,
+		hints.maxAspectX, hints.maxAspectY,
+		hints.baseWidth, hints.baseHeight,
+		hints.winGravity,
+	}
+
+	data := make([]byte, 0, len(values)*4)
+	for _, value := range values {
+		data = append(data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+	}
+
+	return data
+}
+
+// the smallest size window managers let the user resize windows to
+const minWindowSize = 16
+
+// withAspect returns the hints with the aspect ratio locked to that of
+// size.
+func (hints sizeHints) withAspect(size image.Point) sizeHints {
+	hints.flags |= sizeHintPAspect
+	hints.minAspectX = int32(size.X)
+	hints.minAspectY = int32(size.Y)
+	hints.maxAspectX = int32(size.X)
+	hints.maxAspectY = int32(size.Y)
+
+	return hints
+}
+
+// lockAspect locks the aspect ratio of the window to that of an image of
+// size, if it isn't already. The window keeps its size until it is resized.
+func (display *Window) lockAspect(size image.Point) {
+	if size.X == 0 || size.Y == 0 {
+		return
+	}
+
+	display.renderMu.Lock()
+	// the hints are set from the image when the window is created
+	if display.windowID == 0 || size.X*display.aspect.Y == size.Y*display.aspect.X && display.aspect != (image.Point{}) {
+		display.renderMu.Unlock()
+		return
+	}
+
+	display.aspect = size
+	display.hints = display.hints.withAspect(size)
+	hints := display.hints
+	display.renderMu.Unlock()
+
+	display.setNormalHints(hints)
+}
+
+// keepAspect returns width and the height that keeps the locked aspect ratio
+// at that width, or both unchanged if it isn't locked.
+func (display *Window) keepAspect(width int, height int) (int, int) {
+	display.renderMu.Lock()
+	aspect := display.aspect
+	display.renderMu.Unlock()
+
+	if aspect.X == 0 || aspect.Y == 0 {
+		return width, height
+	}
+
+	return width, max(1, width*aspect.Y/aspect.X)
+}
+
+// protocols returns the WM_PROTOCOLS we take part in.
+func (display *Window) protocols() []string {
+	protocols := []string{"WM_DELETE_WINDOW", "_NET_WM_PING"}
+	if display.options.RestartArgs != nil {
+		protocols = append(protocols, "WM_SAVE_YOURSELF")
+	}
+
+	return protocols
+}
+
+// isProtocol reports whether event is the WM_PROTOCOLS message of protocol.
+func (display *Window) isProtocol(event xproto.ClientMessageEvent, protocol string) bool {
+	protocols, err := display.atom("WM_PROTOCOLS")
+	if err != nil || event.Type != protocols {
+		return false
+	}
+
+	atom, err := display.atom(protocol)
+	if err != nil {
+		return false
+	}
+
+	return xproto.Atom(event.Data.Data32[0]) == atom
+}
+
+// answerPing sends _NET_WM_PING back to the root window, which tells the
+// window manager that we still handle events. Those that don't get marked
+// as not responding.
+func (display *Window) answerPing(event xproto.ClientMessageEvent) error {
+	event.Window = display.screen.Root
+
+	return xproto.SendEventChecked(
+		display.conn,
+		false,
+		display.screen.Root,
+		xproto.EventMaskSubstructureNotify|xproto.EventMaskSubstructureRedirect,
+		string(event.Bytes()),
+	).Check()
+}
+
+func (display *Window) setNormalHints(hints sizeHints) {
+	const format32Bit = 32
+
+	data := hints.bytes()
+
+	xproto.ChangeProperty(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		xproto.AtomWmNormalHints,
+		xproto.AtomWmSizeHints,
+		format32Bit,
+		uint32(len(data)/4),
+		data,
+	)
+}
+
+// setStringsProperty sets a property to a list of zero terminated strings,
+// e.g. WM_COMMAND.
+func (display *Window) setStringsProperty(property xproto.Atom, values ...string) {
+	const format8Bit = 8
+
+	var data []byte
+	for _, value := range values {
+		data = append(data, value...)
+		data = append(data, 0)
+	}
+
+	xproto.ChangeProperty(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		property,
+		xproto.AtomString,
+		format8Bit,
+		uint32(len(data)),
+		data,
+	)
+}
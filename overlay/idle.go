@@ -0,0 +1,106 @@
+package overlay
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jezek/xgb/screensaver"
+	"github.com/jezek/xgb/xproto"
+)
+
+// With Options.Idle the overlay is a screensaver: the window stays hidden
+// while the user is active and appears once there was no input for that
+// long, as the MIT-SCREEN-SAVER extension of the server counts it, over
+// Options.FadeIn. Whatever it shows, an image, a clock drawn with {time} or
+// a dark color at low opacity, is hidden again on the first input.
+
+// how often the input is checked while the window is shown, so that it
+// disappears right away
+const idleActivityCheck = 100 * time.Millisecond
+
+// startIdleWatch shows the window whenever the user was idle for idle.
+func (display *Window) startIdleWatch(idle time.Duration) error {
+	err := screensaver.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init screen saver extension: %w", err)
+	}
+
+	display.wg.Add(1)
+	go display.watchIdle(idle)
+
+	return nil
+}
+
+func (display *Window) watchIdle(idle time.Duration) {
+	defer display.wg.Done()
+
+	shown := false
+	// the opacity it appears with, the one it had when it was hidden
+	opacity := display.opacity()
+
+	for {
+		since, err := display.idleTime()
+
+		wait := idleActivityCheck
+		switch {
+		case err != nil:
+			if display.ctx.Err() == nil {
+				slog.Error("query idle time", "err", err)
+			}
+
+			wait = time.Second
+		case !shown && since >= idle:
+			err = display.appear(opacity)
+			shown = err == nil
+			if err != nil {
+				slog.Error("show window", "err", err)
+			}
+		case shown && since < idle:
+			opacity = display.opacity()
+
+			err = display.setVisible(false)
+			shown = err != nil
+			if err != nil {
+				slog.Error("hide window", "err", err)
+			}
+
+			wait = idle - since
+		case !shown:
+			// nothing to do until the user could be idle for long enough
+			wait = idle - since
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-display.ctx.Done():
+			return
+		}
+	}
+}
+
+// idleTime returns how long ago the last input was.
+func (display *Window) idleTime() (time.Duration, error) {
+	info, err := screensaver.QueryInfo(display.conn, xproto.Drawable(display.screen.Root)).Reply()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(info.MsSinceUserInput) * time.Millisecond, nil
+}
+
+// appear shows the window, fading in to opacity over Options.FadeIn.
+func (display *Window) appear(opacity float64) error {
+	if display.options.FadeIn > 0 {
+		display.renderMu.Lock()
+		display.imageOpacity = 0
+		display.opacityFade = opacityFade{
+			active:   true,
+			to:       opacity,
+			duration: display.options.FadeIn,
+		}
+		display.renderMu.Unlock()
+	}
+
+	return display.setVisible(true)
+}
@@ -0,0 +1,136 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// distance of the info panel from the top left corner of the image
+const infoMargin = 8
+
+// infoLines lists what the info panel shows about the current image.
+func infoLines(source string, img image.Image, frames int, info imageInfo) []string {
+	var lines []string
+	if source != "" {
+		lines = append(lines, source)
+	}
+
+	size := fmt.Sprintf("%dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	if info.format != "" {
+		size += " " + info.format
+	}
+
+	if frames > 1 {
+		size += fmt.Sprintf(", %d frames", frames)
+	}
+
+	if info.fileSize > 0 {
+		size += ", " + formatFileSize(info.fileSize)
+	}
+
+	if info.reduction > 1 {
+		size += fmt.Sprintf(", decoded at 1/%d", info.reduction)
+	}
+
+	lines = append(lines, size)
+
+	if info.profile != "" {
+		lines = append(lines, "profile: "+info.profile)
+	}
+
+	return append(lines, info.exif...)
+}
+
+func formatFileSize(size int) string {
+	const unit = 1024
+
+	switch {
+	case size < unit:
+		return fmt.Sprintf("%d B", size)
+	case size < unit*unit:
+		return fmt.Sprintf("%.1f KiB", float64(size)/unit)
+	default:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(unit*unit))
+	}
+}
+
+// renderInfoPanel draws lines on a dark background, premultiplied like
+// everything that is drawn with the image package.
+func renderInfoPanel(lines []string) *image.RGBA {
+	face := basicfont.Face7x13
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, len([]rune(line))*face.Advance)
+	}
+
+	panel := image.NewRGBA(image.Rect(0, 0, width+2*labelPadding, len(lines)*face.Height+2*labelPadding))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	drawer := font.Drawer{
+		Dst:  panel,
+		Src:  image.White,
+		Face: face,
+	}
+
+	for i, line := range lines {
+		drawer.Dot = fixed.P(labelPadding, labelPadding+i*face.Height+face.Ascent)
+		drawer.DrawString(line)
+	}
+
+	return panel
+}
+
+// drawInfoPanel composites panel over buf, which holds width by height
+// pixels in the byte order of X. The panel is not affected by the opacity of
+// the image, so it stays readable.
+func drawInfoPanel(buf []byte, width int, height int, panel *image.RGBA) {
+	drawPanel(buf, width, height, panel, image.Pt(infoMargin, infoMargin))
+}
+
+// drawPanel composites panel over buf with its top left corner at origin.
+func drawPanel(buf []byte, width int, height int, panel *image.RGBA, origin image.Point) {
+	area := panel.Bounds().Add(origin).Intersect(image.Rect(0, 0, width, height))
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			src := panel.Pix[panel.PixOffset(x-origin.X, y-origin.Y):]
+			dst := buf[(y*width+x)*4:]
+			inverse := 255 - uint32(src[3])
+
+			dst[0] = byte(uint32(src[2]) + uint32(dst[0])*inverse/255)
+			dst[1] = byte(uint32(src[1]) + uint32(dst[1])*inverse/255)
+			dst[2] = byte(uint32(src[0]) + uint32(dst[2])*inverse/255)
+			dst[3] = byte(uint32(src[3]) + uint32(dst[3])*inverse/255)
+		}
+	}
+}
+
+// infoPanel returns the panel for the current image, nil while it is hidden.
+func (display *Window) infoPanel() *image.RGBA {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if !display.showInfo {
+		return nil
+	}
+
+	if display.infoCache == nil {
+		display.infoCache = renderInfoPanel(infoLines(display.source, display.image, len(display.frames), display.info))
+	}
+
+	return display.infoCache
+}
+
+func (display *Window) toggleInfo() {
+	display.renderMu.Lock()
+	display.showInfo = !display.showInfo
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
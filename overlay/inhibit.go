@@ -0,0 +1,98 @@
+package overlay
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jezek/xgb/screensaver"
+	"github.com/jezek/xgb/xproto"
+)
+
+// While the window is shown and plays something, an animation, a slideshow
+// or anything in a kiosk, the screen saver and DPMS are kept from blanking
+// the screen, unless Options.AllowBlanking is set. The server suspends both
+// through MIT-SCREEN-SAVER 1.1 and lifts that by itself if the overlay dies,
+// and the idle time is reset like XResetScreenSaver for the desktops that
+// blank on their own when they find no input in it.
+
+// how often it is checked whether the overlay plays something
+const keepAwakeInterval = 10 * time.Second
+
+func (display *Window) startKeepAwake() {
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+
+		suspendable := display.canSuspendScreenSaver()
+		suspended := false
+
+		ticker := time.NewTicker(keepAwakeInterval)
+		defer ticker.Stop()
+
+		for {
+			playing := display.playing()
+
+			if suspendable && playing != suspended {
+				err := display.suspendScreenSaver(playing)
+				if err != nil {
+					slog.Warn("suspend screen saver", "err", err)
+				} else {
+					suspended = playing
+				}
+			}
+
+			// resetting the idle time would count as input for Options.Idle,
+			// which then hides the window
+			if playing && display.options.Idle == 0 {
+				xproto.ForceScreenSaver(display.conn, xproto.ScreenSaverReset)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-display.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// canSuspendScreenSaver reports whether the server can suspend the screen
+// saver, from MIT-SCREEN-SAVER 1.1 on.
+func (display *Window) canSuspendScreenSaver() bool {
+	err := screensaver.Init(display.conn)
+	if err != nil {
+		slog.Debug("no screen saver extension, only resetting the idle time", "err", err)
+		return false
+	}
+
+	version, err := screensaver.QueryVersion(display.conn, 1, 1).Reply()
+	if err != nil {
+		slog.Debug("query screen saver version", "err", err)
+		return false
+	}
+
+	return version.ServerMajorVersion > 1 || version.ServerMajorVersion == 1 && version.ServerMinorVersion >= 1
+}
+
+func (display *Window) suspendScreenSaver(suspend bool) error {
+	value := uint32(0)
+	if suspend {
+		value = 1
+	}
+
+	return describeXError(display.conn, screensaver.SuspendChecked(display.conn, value).Check())
+}
+
+// playing reports whether the window is shown and plays something that
+// shouldn't be blanked.
+func (display *Window) playing() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if !display.mapped {
+		return false
+	}
+
+	return display.options.LockInput || display.slideshowRunning || len(display.frames) > 1
+}
@@ -0,0 +1,500 @@
+package overlay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// The control protocol is line based: every request is a JSON object on its
+// own line and is answered with a JSON object on its own line.
+
+type ControlRequest struct {
+	Command  string   `json:"command"`
+	Opacity  *float64 `json:"opacity,omitempty"`
+	Relative bool     `json:"relative,omitempty"`
+	X        *int     `json:"x,omitempty"`
+	Y        *int     `json:"y,omitempty"`
+	Width    *int     `json:"width,omitempty"`
+	Height   *int     `json:"height,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	Position *float64 `json:"position,omitempty"`
+	Geometry string   `json:"geometry,omitempty"`
+
+	// Annotation is what annotate draws, as parsed by ParseAnnotation.
+	Annotation string `json:"annotation,omitempty"`
+
+	// Message is what notify shows.
+	Message string `json:"message,omitempty"`
+
+	// Blended screenshots show the screen below the window too.
+	Blended bool `json:"blended,omitempty"`
+
+	// Window is the id of the overlay of the process the request is for, the
+	// one that listens on the socket if it is 0.
+	Window int `json:"window,omitempty"`
+
+	// FromGroup is set on changes shared by another member of this group.
+	FromGroup string `json:"from_group,omitempty"`
+}
+
+type ControlResponse struct {
+	OK    bool          `json:"ok"`
+	Error string        `json:"error,omitempty"`
+	State *ControlState `json:"state,omitempty"`
+}
+
+type ControlState struct {
+	ID      int     `json:"id"`
+	Image   string  `json:"image"`
+	Opacity float64 `json:"opacity"`
+	Visible bool    `json:"visible"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Group   string  `json:"group,omitempty"`
+	// the file the images are recorded to
+	Recording string `json:"recording,omitempty"`
+}
+
+func ControlSocketDir() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("xoverlay-%d", os.Getuid()))
+	}
+
+	return filepath.Join(runtimeDir, "xoverlay")
+}
+
+func ControlSocketPath(pid int) string {
+	return filepath.Join(ControlSocketDir(), fmt.Sprintf("%d.sock", pid))
+}
+
+// CheckControlSocketDir makes sure the socket directory is a directory of
+// ours that nobody else can use. In the shared /tmp another user could have
+// created it first, to receive our requests and screenshots or to send us
+// theirs.
+func CheckControlSocketDir() error {
+	dir := ControlSocketDir()
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("check socket directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s is owned by another user", dir)
+	}
+
+	if info.Mode().Perm() != 0o700 {
+		return fmt.Errorf("socket directory %s has mode %#o instead of 0700", dir, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+func ControlSockets() ([]string, error) {
+	err := CheckControlSocketDir()
+	if errors.Is(err, os.ErrNotExist) {
+		// no overlay has been started yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ControlSocketDir(), "*.sock"))
+	if err != nil {
+		return nil, fmt.Errorf("list sockets: %w", err)
+	}
+
+	return paths, nil
+}
+
+type ControlServer struct {
+	listener net.Listener
+	path     string
+	display  *Window
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// ListenControl accepts control requests, e.g. from xoverlay ctl, on a unix
+// socket at path.
+func (display *Window) ListenControl(path string) (*ControlServer, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+
+	// a --socket elsewhere is where the user wants it
+	if filepath.Dir(path) == ControlSocketDir() {
+		err = CheckControlSocketDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a socket left behind by a crashed instance with the same pid
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	server := &ControlServer{
+		listener: listener,
+		path:     path,
+		display:  display,
+		done:     make(chan struct{}),
+	}
+
+	requests := make(chan ControlRequest, groupQueueSize)
+
+	display.groupMu.Lock()
+	display.group.requests = requests
+	display.groupMu.Unlock()
+
+	server.wg.Add(2)
+	go server.serve()
+	go server.syncGroup(requests)
+
+	return server, nil
+}
+
+func (server *ControlServer) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("accept control connection", "err", err)
+			continue
+		}
+
+		go server.handleConn(conn)
+	}
+}
+
+func (server *ControlServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var request ControlRequest
+
+		var response ControlResponse
+		err := json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			response = ControlResponse{Error: fmt.Sprintf("decode request: %s", err)}
+		} else {
+			response = server.display.handleControl(request)
+		}
+
+		err = encoder.Encode(response)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (server *ControlServer) Close() {
+	server.listener.Close()
+	close(server.done)
+	server.wg.Wait()
+}
+
+func (display *Window) handleControl(request ControlRequest) ControlResponse {
+	state, err := display.runControl(request)
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+
+	return ControlResponse{OK: true, State: state}
+}
+
+func (display *Window) runControl(request ControlRequest) (*ControlState, error) {
+	if request.FromGroup != "" {
+		return nil, display.applyGroupChange(request)
+	}
+
+	if request.Window != 0 && request.Window != display.id {
+		target, ok := display.connection.window(request.Window)
+		if !ok {
+			return nil, fmt.Errorf("no window %d", request.Window)
+		}
+
+		return target.runControl(request)
+	}
+
+	switch request.Command {
+	case "opacity":
+		if request.Opacity == nil {
+			return nil, fmt.Errorf("opacity: missing opacity")
+		}
+
+		opacity := *request.Opacity
+		if request.Relative {
+			opacity += display.opacity()
+		}
+
+		display.checkpoint(request.Command)
+		display.fadeOpacity(opacity)
+		display.shareOpacity()
+	case "move":
+		if request.X == nil || request.Y == nil {
+			return nil, fmt.Errorf("move: missing x or y")
+		}
+
+		display.checkpoint(request.Command)
+		err := display.moveWindow(*request.X, *request.Y)
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
+		}
+	case "resize":
+		if request.Width == nil || request.Height == nil {
+			return nil, fmt.Errorf("resize: missing width or height")
+		}
+
+		display.checkpoint(request.Command)
+		err := display.resizeWindow(*request.Width, *request.Height)
+		if err != nil {
+			return nil, fmt.Errorf("resize: %w", err)
+		}
+	case "image":
+		err := display.loadImageFile(request.Path)
+		if err != nil {
+			return nil, fmt.Errorf("image: %w", err)
+		}
+	case "next":
+		err := display.cycleImage(1)
+		if err != nil {
+			return nil, fmt.Errorf("next: %w", err)
+		}
+	case "previous":
+		err := display.cycleImage(-1)
+		if err != nil {
+			return nil, fmt.Errorf("previous: %w", err)
+		}
+	case "show", "hide":
+		visible := request.Command == "show"
+
+		err := display.setVisible(visible)
+		if err != nil {
+			return nil, err
+		}
+
+		display.shareVisible(visible)
+	case "toggle":
+		return nil, display.toggleVisible()
+	case "sticky":
+		err := display.toggleSticky()
+		if err != nil {
+			return nil, fmt.Errorf("sticky: %w", err)
+		}
+	case "raise":
+		err := display.raiseWindow()
+		if err != nil {
+			return nil, fmt.Errorf("raise: %w", err)
+		}
+	case "fullscreen":
+		display.checkpoint(request.Command)
+		err := display.toggleFullscreen()
+		if err != nil {
+			return nil, fmt.Errorf("fullscreen: %w", err)
+		}
+	case "split":
+		if request.Position == nil {
+			return nil, fmt.Errorf("split: missing position")
+		}
+
+		err := display.SetSplit(*request.Position)
+		if err != nil {
+			return nil, fmt.Errorf("split: %w", err)
+		}
+	case "add":
+		if request.Path == "" {
+			return nil, fmt.Errorf("add: missing path")
+		}
+
+		var geometry Geometry
+		if request.Geometry != "" {
+			var err error
+			geometry, err = ParseGeometry(request.Geometry)
+			if err != nil {
+				return nil, fmt.Errorf("add: %w", err)
+			}
+		}
+
+		added, err := display.Add(request.Path, geometry)
+		if err != nil {
+			return nil, fmt.Errorf("add: %w", err)
+		}
+
+		return added.state()
+	case "join":
+		if request.Group == "" {
+			return nil, fmt.Errorf("join: missing group")
+		}
+
+		display.joinGroup(request.Group)
+	case "leave":
+		display.joinGroup("")
+	case "record":
+		path := request.Path
+		if path == "" {
+			path = display.newRecordingPath()
+		}
+
+		err := display.StartRecording(path)
+		if err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+	case "stop-recording":
+		_, err := display.StopRecording()
+		if err != nil {
+			return nil, fmt.Errorf("stop-recording: %w", err)
+		}
+	case "annotate":
+		annotation, err := ParseAnnotation(request.Annotation, display.options.TextColor)
+		if err != nil {
+			return nil, fmt.Errorf("annotate: %w", err)
+		}
+
+		display.checkpoint(request.Command)
+		display.Annotate(annotation)
+	case "notify":
+		if request.Message == "" {
+			return nil, fmt.Errorf("notify: missing message")
+		}
+
+		display.Notify(request.Message)
+	case "clear-annotations":
+		display.checkpoint(request.Command)
+		display.ClearAnnotations()
+	case "undo":
+		_, err := display.Undo()
+		if err != nil {
+			return nil, err
+		}
+	case "redo":
+		_, err := display.Redo()
+		if err != nil {
+			return nil, err
+		}
+	case "screenshot":
+		path := request.Path
+		if path == "" {
+			path = display.newScreenshotPath()
+		}
+
+		err := display.SaveScreenshot(path, request.Blended)
+		if err != nil {
+			return nil, fmt.Errorf("screenshot: %w", err)
+		}
+	case "snapshot":
+		path := request.Path
+		if path == "" {
+			path = display.newScreenshotPath()
+		}
+
+		err := display.SaveSnapshot(path)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	case "quit":
+		err := display.quit()
+		if err != nil {
+			return nil, fmt.Errorf("quit: %w", err)
+		}
+	case "state":
+		state, err := display.state()
+		if err != nil {
+			return nil, fmt.Errorf("state: %w", err)
+		}
+
+		return state, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", request.Command)
+	}
+
+	return nil, nil
+}
+
+func (display *Window) state() (*ControlState, error) {
+	geom, err := xproto.GetGeometry(display.conn, xproto.Drawable(display.windowID)).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get geometry: %w", err)
+	}
+
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return nil, err
+	}
+
+	group := display.groupName()
+	recording := display.recordingPath()
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return &ControlState{
+		ID:      display.id,
+		Image:   display.source,
+		Opacity: display.targetOpacity(),
+		Visible: display.mapped,
+		X:       x,
+		Y:       y,
+		Width:   int(geom.Width),
+		Height:  int(geom.Height),
+		Group:   group,
+
+		Recording: recording,
+	}, nil
+}
+
+func SendControl(path string, request ControlRequest) (ControlResponse, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return ControlResponse{}, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return ControlResponse{}, fmt.Errorf("send request: %w", err)
+	}
+
+	var response ControlResponse
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return ControlResponse{}, fmt.Errorf("read response: %w", err)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,69 @@
+package overlay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckControlSocketDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	dir := ControlSocketDir()
+	elsewhere := filepath.Join(t.TempDir(), "elsewhere")
+
+	err := os.Mkdir(elsewhere, 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the umask doesn't take anything away from chmod
+	mkdir := func(mode os.FileMode) func() error {
+		return func() error {
+			err := os.Mkdir(dir, mode)
+			if err != nil {
+				return err
+			}
+
+			return os.Chmod(dir, mode)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		create func() error
+		ok     bool
+	}{
+		{"directory of ours", mkdir(0o700), true},
+		{"readable by others", mkdir(0o755), false},
+		{"symlink", func() error { return os.Symlink(elsewhere, dir) }, false},
+		{"file", func() error { return os.WriteFile(dir, nil, 0o600) }, false},
+	}
+
+	for _, test := range tests {
+		os.RemoveAll(dir)
+
+		err := test.create()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = CheckControlSocketDir()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: err %v", test.name, err)
+		}
+
+		_, err = ControlSockets()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: listing the sockets: err %v", test.name, err)
+		}
+	}
+
+	os.RemoveAll(dir)
+
+	paths, err := ControlSockets()
+	if err != nil || paths != nil {
+		t.Errorf("sockets %v, err %v without a directory", paths, err)
+	}
+}
@@ -0,0 +1,743 @@
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+)
+
+// jpegs are decoded at 1/2, 1/4 or 1/8 of their size when they are shown
+// that small anyway. Only the low frequencies of every 8x8 block are
+// transformed, which takes a fraction of the time and memory of decoding the
+// whole image and scaling it down afterwards. Progressive, arithmetic coded
+// and cmyk jpegs are left to image/jpeg.
+
+// huffman codes up to this long are looked up in a single step
+const jpegLookupBits = 9
+
+// unzig maps the zigzag order of the coefficients in the file to their
+// position in the block
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type jpegHuffman struct {
+	// value<<8 | code length for codes up to jpegLookupBits, 0 for longer
+	// codes
+	lookup  [1 << jpegLookupBits]uint16
+	maxCode [17]int32
+	minCode [17]int32
+	valPtr  [17]int32
+	values  []byte
+}
+
+func newJPEGHuffman(counts []byte, values []byte) (*jpegHuffman, error) {
+	h := &jpegHuffman{values: values}
+
+	code := int32(0)
+	k := int32(0)
+
+	for length := 1; length <= 16; length++ {
+		count := int32(counts[length-1])
+		h.valPtr[length] = k
+		h.minCode[length] = code
+		h.maxCode[length] = code + count - 1
+
+		// more codes than the length has room for, checked before they are
+		// written to the lookup table
+		if code+count > 1<<length {
+			return nil, fmt.Errorf("invalid huffman table")
+		}
+
+		for range count {
+			if length <= jpegLookupBits {
+				shift := jpegLookupBits - length
+				for i := code << shift; i < (code+1)<<shift; i++ {
+					h.lookup[i] = uint16(values[k])<<8 | uint16(length)
+				}
+			}
+
+			code++
+			k++
+		}
+
+		code <<= 1
+	}
+
+	return h, nil
+}
+
+// jpegBits reads the entropy coded data of a scan, with the stuffed zero
+// bytes after 0xff removed. Zeros are read once a marker is reached.
+type jpegBits struct {
+	data []byte
+	pos  int
+	acc  uint64
+	n    uint
+	// zero bytes read past the end of the scan, a lot of them mean that the
+	// data is broken
+	padding int
+}
+
+func (bits *jpegBits) fill() {
+	for bits.n <= 56 {
+		var c byte
+		switch {
+		case bits.pos >= len(bits.data):
+			bits.padding++
+		case bits.data[bits.pos] != 0xff:
+			c = bits.data[bits.pos]
+			bits.pos++
+		case bits.pos+1 < len(bits.data) && bits.data[bits.pos+1] == 0:
+			c = 0xff
+			bits.pos += 2
+		default:
+			bits.padding++
+		}
+
+		bits.acc |= uint64(c) << (56 - bits.n)
+		bits.n += 8
+	}
+}
+
+func (bits *jpegBits) consume(n uint) {
+	bits.acc <<= n
+	bits.n -= n
+}
+
+func (bits *jpegBits) decode(h *jpegHuffman) (byte, error) {
+	bits.fill()
+
+	if entry := h.lookup[bits.acc>>(64-jpegLookupBits)]; entry != 0 {
+		bits.consume(uint(entry & 0xff))
+		return byte(entry >> 8), nil
+	}
+
+	for length := jpegLookupBits + 1; length <= 16; length++ {
+		code := int32(bits.acc >> (64 - length))
+		if code <= h.maxCode[length] {
+			bits.consume(uint(length))
+			return h.values[h.valPtr[length]+code-h.minCode[length]], nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid huffman code")
+}
+
+// receiveExtend reads an s bit coefficient.
+func (bits *jpegBits) receiveExtend(s byte) int32 {
+	if s == 0 {
+		return 0
+	}
+
+	bits.fill()
+	v := int32(bits.acc >> (64 - uint(s)))
+	bits.consume(uint(s))
+
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+
+	return v
+}
+
+// restart skips to after the next restart marker.
+func (bits *jpegBits) restart() {
+	bits.acc = 0
+	bits.n = 0
+
+	for bits.pos+1 < len(bits.data) {
+		if bits.data[bits.pos] == 0xff && bits.data[bits.pos+1] >= 0xd0 && bits.data[bits.pos+1] <= 0xd7 {
+			bits.pos += 2
+			return
+		}
+
+		bits.pos++
+	}
+}
+
+type jpegComponent struct {
+	id      byte
+	h       int
+	v       int
+	quant   int
+	dcTable int
+	acTable int
+	pred    int32
+
+	// the plane the component is written to
+	pix    []byte
+	stride int
+	width  int
+	height int
+}
+
+type jpegScaledDecoder struct {
+	width      int
+	height     int
+	components []*jpegComponent
+	quant      [4][64]float32
+	dc         [4]*jpegHuffman
+	ac         [4]*jpegHuffman
+	restart    int
+	adobe      bool
+	transform  byte
+
+	// blocks are transformed to n by n pixels
+	n      int
+	cosine [8][8]float32
+}
+
+// decodeJPEGScaled decodes a jpeg at the smallest of 1/8, 1/4 and 1/2 of
+// its size that still covers target, and returns its full size as well. It
+// returns false if no reduction is possible or the jpeg is not one it
+// handles, or broken.
+func decodeJPEGScaled(data []byte, target image.Point) (image.Image, image.Point, bool) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) || target.X <= 0 || target.Y <= 0 {
+		return nil, image.Point{}, false
+	}
+
+	decoder := &jpegScaledDecoder{}
+
+	img, err := decoder.decode(data, target)
+	if err != nil || img == nil {
+		return nil, image.Point{}, false
+	}
+
+	return img, image.Pt(decoder.width, decoder.height), true
+}
+
+// decode returns a nil image without an error for jpegs that are decoded at
+// full size.
+func (decoder *jpegScaledDecoder) decode(data []byte, target image.Point) (image.Image, error) {
+	pos := 2
+
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("missing marker")
+		}
+
+		marker := data[pos+1]
+		if marker == 0xff {
+			// fill byte
+			pos++
+			continue
+		}
+
+		if marker == 0xd9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("truncated segment")
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		var err error
+
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			err = decoder.parseFrame(segment)
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			// progressive, lossless or arithmetic coded
+			return nil, nil
+		case marker == 0xc4:
+			err = decoder.parseHuffman(segment)
+		case marker == 0xdb:
+			err = decoder.parseQuant(segment)
+		case marker == 0xdd:
+			if len(segment) < 2 {
+				return nil, fmt.Errorf("short restart interval")
+			}
+
+			decoder.restart = int(binary.BigEndian.Uint16(segment))
+		case marker == 0xee:
+			if len(segment) >= 12 && bytes.HasPrefix(segment, []byte("Adobe")) {
+				decoder.adobe = true
+				decoder.transform = segment[11]
+			}
+		case marker == 0xda:
+			if !decoder.supported() {
+				return nil, nil
+			}
+
+			if !decoder.chooseScale(target) {
+				return nil, nil
+			}
+
+			return decoder.decodeScan(segment, data[pos:])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("no scan")
+}
+
+func (decoder *jpegScaledDecoder) parseFrame(segment []byte) error {
+	if len(segment) < 6 {
+		return fmt.Errorf("short frame header")
+	}
+
+	if segment[0] != 8 {
+		return fmt.Errorf("unsupported precision")
+	}
+
+	decoder.height = int(binary.BigEndian.Uint16(segment[1:]))
+	decoder.width = int(binary.BigEndian.Uint16(segment[3:]))
+	count := int(segment[5])
+
+	if len(segment) < 6+3*count {
+		return fmt.Errorf("short frame header")
+	}
+
+	decoder.components = nil
+	for i := range count {
+		c := segment[6+3*i:]
+		decoder.components = append(decoder.components, &jpegComponent{
+			id:    c[0],
+			h:     int(c[1] >> 4),
+			v:     int(c[1] & 0x0f),
+			quant: int(c[2] & 3),
+		})
+	}
+
+	return nil
+}
+
+func (decoder *jpegScaledDecoder) parseHuffman(segment []byte) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return fmt.Errorf("short huffman table")
+		}
+
+		class := segment[0] >> 4
+		index := segment[0] & 3
+		counts := segment[1:17]
+
+		total := 0
+		for _, count := range counts {
+			total += int(count)
+		}
+
+		if len(segment) < 17+total {
+			return fmt.Errorf("short huffman table")
+		}
+
+		h, err := newJPEGHuffman(counts, segment[17:17+total])
+		if err != nil {
+			return err
+		}
+
+		if class == 0 {
+			decoder.dc[index] = h
+		} else {
+			decoder.ac[index] = h
+		}
+
+		segment = segment[17+total:]
+	}
+
+	return nil
+}
+
+func (decoder *jpegScaledDecoder) parseQuant(segment []byte) error {
+	for len(segment) > 0 {
+		precision := segment[0] >> 4
+		index := segment[0] & 3
+		size := 64
+		if precision != 0 {
+			size = 128
+		}
+
+		if len(segment) < 1+size {
+			return fmt.Errorf("short quantization table")
+		}
+
+		// kept in zigzag order, like the coefficients
+		for k := range 64 {
+			if precision == 0 {
+				decoder.quant[index][k] = float32(segment[1+k])
+			} else {
+				decoder.quant[index][k] = float32(binary.BigEndian.Uint16(segment[1+2*k:]))
+			}
+		}
+
+		segment = segment[1+size:]
+	}
+
+	return nil
+}
+
+// supported reports whether the frame is grayscale or ycbcr with a
+// subsampling image.YCbCr can hold.
+func (decoder *jpegScaledDecoder) supported() bool {
+	if decoder.width == 0 || decoder.height == 0 {
+		return false
+	}
+
+	if len(decoder.components) == 1 {
+		return true
+	}
+
+	if len(decoder.components) != 3 {
+		return false
+	}
+
+	// adobe's transform 0 and components named R, G and B are rgb
+	if decoder.adobe && decoder.transform == 0 {
+		return false
+	}
+
+	components := decoder.components
+	if components[0].id == 'R' && components[1].id == 'G' && components[2].id == 'B' {
+		return false
+	}
+
+	if components[1].h != 1 || components[1].v != 1 || components[2].h != 1 || components[2].v != 1 {
+		return false
+	}
+
+	_, ok := decoder.subsampleRatio()
+
+	return ok
+}
+
+func (decoder *jpegScaledDecoder) subsampleRatio() (image.YCbCrSubsampleRatio, bool) {
+	luma := decoder.components[0]
+
+	switch [2]int{luma.h, luma.v} {
+	case [2]int{1, 1}:
+		return image.YCbCrSubsampleRatio444, true
+	case [2]int{2, 1}:
+		return image.YCbCrSubsampleRatio422, true
+	case [2]int{2, 2}:
+		return image.YCbCrSubsampleRatio420, true
+	case [2]int{1, 2}:
+		return image.YCbCrSubsampleRatio440, true
+	case [2]int{4, 1}:
+		return image.YCbCrSubsampleRatio411, true
+	case [2]int{4, 2}:
+		return image.YCbCrSubsampleRatio410, true
+	}
+
+	return 0, false
+}
+
+// chooseScale picks the strongest reduction that still covers target and
+// reports false if there is none.
+func (decoder *jpegScaledDecoder) chooseScale(target image.Point) bool {
+	for _, n := range []int{1, 2, 4} {
+		size := decoder.scaledSize(n)
+		if size.X >= target.X && size.Y >= target.Y {
+			decoder.n = n
+
+			// the inverse dct of the lowest n frequencies, evaluated at the
+			// centers of n pixels
+			for x := range n {
+				for u := range n {
+					c := 0.5
+					if u == 0 {
+						c = 0.5 / math.Sqrt2
+					}
+
+					decoder.cosine[x][u] = float32(c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/float64(2*n)))
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (decoder *jpegScaledDecoder) scaledSize(n int) image.Point {
+	return image.Pt((decoder.width*n+7)/8, (decoder.height*n+7)/8)
+}
+
+func (decoder *jpegScaledDecoder) decodeScan(header []byte, data []byte) (image.Image, error) {
+	if len(header) < 1 {
+		return nil, fmt.Errorf("short scan header")
+	}
+
+	count := int(header[0])
+	if count != len(decoder.components) || len(header) < 1+2*count {
+		// the components are spread over several scans
+		return nil, nil
+	}
+
+	scan := make([]*jpegComponent, count)
+	for i := range count {
+		var component *jpegComponent
+		for _, c := range decoder.components {
+			if c.id == header[1+2*i] {
+				component = c
+			}
+		}
+
+		if component == nil {
+			return nil, fmt.Errorf("unknown component")
+		}
+
+		component.dcTable = int(header[2+2*i] >> 4 & 3)
+		component.acTable = int(header[2+2*i] & 3)
+
+		if decoder.dc[component.dcTable] == nil || decoder.ac[component.acTable] == nil {
+			return nil, fmt.Errorf("missing huffman table")
+		}
+
+		scan[i] = component
+	}
+
+	size := decoder.scaledSize(decoder.n)
+	bounds := image.Rect(0, 0, size.X, size.Y)
+
+	var img image.Image
+
+	if count == 1 {
+		gray := image.NewGray(bounds)
+		scan[0].pix, scan[0].stride, scan[0].width, scan[0].height = gray.Pix, gray.Stride, size.X, size.Y
+		img = gray
+	} else {
+		ratio, _ := decoder.subsampleRatio()
+		ycbcr := image.NewYCbCr(bounds, ratio)
+		chromaHeight := len(ycbcr.Cb) / max(1, ycbcr.CStride)
+
+		decoder.components[0].pix, decoder.components[0].stride = ycbcr.Y, ycbcr.YStride
+		decoder.components[0].width, decoder.components[0].height = size.X, size.Y
+		decoder.components[1].pix, decoder.components[1].stride = ycbcr.Cb, ycbcr.CStride
+		decoder.components[2].pix, decoder.components[2].stride = ycbcr.Cr, ycbcr.CStride
+
+		for _, c := range decoder.components[1:] {
+			c.width, c.height = ycbcr.CStride, chromaHeight
+		}
+
+		img = ycbcr
+	}
+
+	bits := &jpegBits{data: data}
+
+	// a single component is not interleaved, every block is a unit of its
+	// own no matter the sampling factors
+	hmax, vmax := 1, 1
+	if count > 1 {
+		for _, c := range decoder.components {
+			hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+		}
+	}
+
+	mcusX := (decoder.width + 8*hmax - 1) / (8 * hmax)
+	mcusY := (decoder.height + 8*vmax - 1) / (8 * vmax)
+
+	var coefficients [64]float32
+
+	mcu := 0
+	for my := range mcusY {
+		for mx := range mcusX {
+			if decoder.restart > 0 && mcu > 0 && mcu%decoder.restart == 0 {
+				bits.restart()
+				for _, c := range scan {
+					c.pred = 0
+				}
+			}
+
+			mcu++
+
+			for _, c := range scan {
+				h, v := c.h, c.v
+				if count == 1 {
+					h, v = 1, 1
+				}
+
+				for by := range v {
+					for bx := range h {
+						err := decoder.decodeBlock(bits, c, &coefficients)
+						if err != nil {
+							return nil, err
+						}
+
+						decoder.writeBlock(c, &coefficients, (mx*h+bx)*decoder.n, (my*v+by)*decoder.n)
+					}
+				}
+			}
+		}
+
+		// well beyond what the last huffman codes of a row could read
+		if bits.padding > 1024 {
+			return nil, fmt.Errorf("truncated scan")
+		}
+	}
+
+	return img, nil
+}
+
+// decodeBlock reads the coefficients of a block, dequantized into the top
+// left n by n entries of coefficients. The other coefficients are skipped.
+func (decoder *jpegScaledDecoder) decodeBlock(bits *jpegBits, c *jpegComponent, coefficients *[64]float32) error {
+	n := decoder.n
+	quant := &decoder.quant[c.quant]
+	*coefficients = [64]float32{}
+
+	s, err := bits.decode(decoder.dc[c.dcTable])
+	if err != nil {
+		return err
+	}
+
+	if s > 16 {
+		return fmt.Errorf("invalid dc coefficient")
+	}
+
+	c.pred += bits.receiveExtend(s)
+	coefficients[0] = float32(c.pred) * quant[0]
+
+	ac := decoder.ac[c.acTable]
+	for k := 1; k < 64; k++ {
+		rs, err := bits.decode(ac)
+		if err != nil {
+			return err
+		}
+
+		run, s := int(rs>>4), rs&0x0f
+		if s == 0 {
+			if run != 15 {
+				// end of block
+				break
+			}
+
+			k += 15
+			continue
+		}
+
+		k += run
+		if k > 63 {
+			return fmt.Errorf("too many coefficients")
+		}
+
+		position := unzig[k]
+		if position/8 < n && position%8 < n {
+			coefficients[position] = float32(bits.receiveExtend(s)) * quant[k]
+		} else {
+			bits.fill()
+			bits.consume(uint(s))
+		}
+	}
+
+	return nil
+}
+
+// writeBlock transforms the low frequencies of a block into n by n pixels
+// at x, y of the plane of c.
+func (decoder *jpegScaledDecoder) writeBlock(c *jpegComponent, coefficients *[64]float32, x int, y int) {
+	n := decoder.n
+	cosine := &decoder.cosine
+
+	// rows first, then columns
+	var rows [8][8]float32
+	for v := range n {
+		for px := range n {
+			sum := float32(0)
+			for u := range n {
+				sum += coefficients[v*8+u] * cosine[px][u]
+			}
+
+			rows[v][px] = sum
+		}
+	}
+
+	for py := range n {
+		if y+py >= c.height {
+			break
+		}
+
+		row := c.pix[(y+py)*c.stride:]
+		for px := range n {
+			if x+px >= c.width {
+				break
+			}
+
+			sum := float32(128)
+			for v := range n {
+				sum += cosine[py][v] * rows[v][px]
+			}
+
+			row[x+px] = uint8(min(255, max(0, sum+0.5)))
+		}
+	}
+}
+
+// reducible reports whether images may be decoded at a reduced size. Crops
+// and redacted regions are given in the pixels of the file, and the scale
+// modes that don't scale the image show its pixels 1:1.
+func (options Options) reducible() bool {
+	switch options.Scale {
+	case ScaleFit, ScaleFill, ScaleStretch:
+	default:
+		return false
+	}
+
+	return options.Crop.Empty() && len(options.Redact) == 0 && !options.LockSize && !options.board()
+}
+
+// decodeTarget is the size images have to cover when they are loaded, zero
+// if they have to be decoded at full size.
+func (display *Window) decodeTarget() image.Point {
+	display.renderMu.Lock()
+	zoomed := display.view.scale() != 1
+	display.renderMu.Unlock()
+
+	if !display.options.reducible() || zoomed {
+		return image.Point{}
+	}
+
+	return image.Pt(display.windowWidth, display.windowHeight)
+}
+
+// loadFullResolution decodes an image that was decoded at a reduced size
+// again at full size, once it is shown larger than that. It is only done
+// once per image.
+func (display *Window) loadFullResolution() {
+	display.renderMu.Lock()
+	source := display.source
+	reduced := display.loaded.fullSize != image.Point{} && !display.fullResolution
+	display.fullResolution = true
+	display.renderMu.Unlock()
+
+	if !reduced {
+		return
+	}
+
+	go func() {
+		decoded, err := loadImage(source, display.options.Animate, image.Point{})
+		if err != nil {
+			slog.Error("load full resolution", "err", err)
+			return
+		}
+
+		display.renderMu.Lock()
+		current := display.source
+		display.renderMu.Unlock()
+
+		// another image was shown in the meantime
+		if current != source {
+			return
+		}
+
+		display.setImage(source, decoded)
+	}()
+}
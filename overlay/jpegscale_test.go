@@ -0,0 +1,233 @@
+package overlay
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testJPEG encodes a smooth image with some edges, gray or 4:2:0 ycbcr the
+// way image/jpeg writes them.
+func testJPEG(t testing.TB, width int, height int, gray bool) []byte {
+	t.Helper()
+
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				g.SetGray(x, y, color.Gray{Y: uint8((x*255/width + y*3) % 256)})
+			}
+		}
+
+		img = g
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				c := color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255}
+				// a square with hard edges
+				if x > width/3 && x < width/2 && y > height/3 && y < height/2 {
+					c = color.RGBA{R: 240, G: 240, B: 20, A: 255}
+				}
+
+				rgba.SetRGBA(x, y, c)
+			}
+		}
+
+		img = rgba
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// jpegPlane is a plane of a decoded jpeg, y, cb or cr.
+type jpegPlane struct {
+	pix    []byte
+	stride int
+	width  int
+	height int
+}
+
+// jpegPlanes returns the planes of a gray or 4:2:0 ycbcr image, the ones
+// image/jpeg writes.
+func jpegPlanes(t *testing.T, img image.Image) []jpegPlane {
+	t.Helper()
+
+	bounds := img.Bounds()
+
+	switch img := img.(type) {
+	case *image.Gray:
+		return []jpegPlane{{img.Pix, img.Stride, bounds.Dx(), bounds.Dy()}}
+	case *image.YCbCr:
+		if img.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+			t.Fatalf("unexpected subsampling %v", img.SubsampleRatio)
+		}
+
+		chromaWidth, chromaHeight := (bounds.Dx()+1)/2, (bounds.Dy()+1)/2
+
+		return []jpegPlane{
+			{img.Y, img.YStride, bounds.Dx(), bounds.Dy()},
+			{img.Cb, img.CStride, chromaWidth, chromaHeight},
+			{img.Cr, img.CStride, chromaWidth, chromaHeight},
+		}
+	}
+
+	t.Fatalf("unexpected image type %T", img)
+
+	return nil
+}
+
+// boxAverage averages the samples of plane that a sample of a reduction by
+// factor covers.
+func (plane jpegPlane) boxAverage(x int, y int, factor int) float64 {
+	sum := 0.0
+	n := 0
+
+	for sy := y * factor; sy < min((y+1)*factor, plane.height); sy++ {
+		for sx := x * factor; sx < min((x+1)*factor, plane.width); sx++ {
+			sum += float64(plane.pix[sy*plane.stride+sx])
+			n++
+		}
+	}
+
+	return sum / float64(n)
+}
+
+func TestDecodeJPEGScaled(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		gray   bool
+		target image.Point
+		// the reduction decodeJPEGScaled has to pick
+		factor int
+	}{
+		{"gray eighth", 256, 192, true, image.Pt(32, 24), 8},
+		{"gray quarter", 256, 192, true, image.Pt(40, 30), 4},
+		{"ycbcr eighth", 256, 192, false, image.Pt(20, 20), 8},
+		{"ycbcr quarter", 256, 192, false, image.Pt(64, 48), 4},
+		{"ycbcr half", 256, 192, false, image.Pt(100, 90), 2},
+		{"odd size", 203, 157, false, image.Pt(50, 39), 4},
+		{"odd size gray", 203, 157, true, image.Pt(26, 20), 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := testJPEG(t, test.width, test.height, test.gray)
+
+			full, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scaled, fullSize, ok := decodeJPEGScaled(data, test.target)
+			if !ok {
+				t.Fatal("not decoded at a reduced size")
+			}
+
+			if fullSize != image.Pt(test.width, test.height) {
+				t.Errorf("full size %v, want %dx%d", fullSize, test.width, test.height)
+			}
+
+			want := image.Pt((test.width+test.factor-1)/test.factor, (test.height+test.factor-1)/test.factor)
+			if scaled.Bounds().Size() != want {
+				t.Fatalf("size %v, want %v", scaled.Bounds().Size(), want)
+			}
+
+			fullPlanes, scaledPlanes := jpegPlanes(t, full), jpegPlanes(t, scaled)
+			if len(fullPlanes) != len(scaledPlanes) {
+				t.Fatalf("%T, image/jpeg decodes a %T", scaled, full)
+			}
+
+			// the low frequencies of a block are close to the average of its
+			// samples, apart from the ringing at hard edges
+			for i, plane := range scaledPlanes {
+				var total float64
+				worst := 0.0
+
+				for y := range plane.height {
+					for x := range plane.width {
+						diff := float64(plane.pix[y*plane.stride+x]) - fullPlanes[i].boxAverage(x, y, test.factor)
+						if diff < 0 {
+							diff = -diff
+						}
+
+						total += diff
+						worst = max(worst, diff)
+					}
+				}
+
+				mean := total / float64(plane.width*plane.height)
+				if mean > 2 || worst > 40 {
+					t.Errorf("plane %d differs from image/jpeg by %.2f on average and %.0f at most", i, mean, worst)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeJPEGScaledFullSize(t *testing.T) {
+	data := testJPEG(t, 64, 64, false)
+
+	// larger than any reduction covers
+	_, _, ok := decodeJPEGScaled(data, image.Pt(64, 64))
+	if ok {
+		t.Error("decoded at a reduced size that doesn't cover the target")
+	}
+
+	_, _, ok = decodeJPEGScaled([]byte("GIF89a"), image.Pt(8, 8))
+	if ok {
+		t.Error("decoded something that is no jpeg")
+	}
+}
+
+func TestNewJPEGHuffman(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts [16]byte
+		ok     bool
+	}{
+		{"complete", [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1}, true},
+		{"two codes of one bit", [16]byte{2}, true},
+		{"three codes of one bit", [16]byte{3}, false},
+		// more codes of nine bits than fit into the lookup table
+		{"overfull lookup", [16]byte{0, 0, 0, 0, 0, 0, 0, 255, 3}, false},
+		{"overfull long codes", [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255}, true},
+		{"overfull after complete", [16]byte{2, 1}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			total := 0
+			for _, count := range test.counts {
+				total += int(count)
+			}
+
+			_, err := newJPEGHuffman(test.counts[:], make([]byte, total))
+			if (err == nil) != test.ok {
+				t.Errorf("err = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func FuzzDecodeJPEGScaled(f *testing.F) {
+	f.Add(testJPEG(f, 64, 48, false), 8, 6)
+	f.Add(testJPEG(f, 37, 29, true), 10, 10)
+	f.Add(testJPEG(f, 120, 80, false), 60, 40)
+
+	f.Fuzz(func(t *testing.T, data []byte, width int, height int) {
+		// only that it doesn't panic, broken files are left to image/jpeg
+		decodeJPEGScaled(data, image.Pt(width%512, height%512))
+	})
+}
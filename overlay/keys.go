@@ -0,0 +1,421 @@
+package overlay
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	keysymEscape      xproto.Keysym = 0xff1b
+	keysymLeft        xproto.Keysym = 0xff51
+	keysymUp          xproto.Keysym = 0xff52
+	keysymRight       xproto.Keysym = 0xff53
+	keysymDown        xproto.Keysym = 0xff54
+	keysymKpAdd       xproto.Keysym = 0xffab
+	keysymKpSubtract  xproto.Keysym = 0xffad
+	keysymPlus        xproto.Keysym = 0x2b
+	keysymMinus       xproto.Keysym = 0x2d
+	keysymEqual       xproto.Keysym = 0x3d
+	keysymLowercaseA  xproto.Keysym = 0x61
+	keysymLowercaseZ  xproto.Keysym = 0x7a
+	keysymUppercaseA  xproto.Keysym = 0x41
+	keysymUppercaseZ  xproto.Keysym = 0x5a
+	keysymLatin1Start xproto.Keysym = 0x20
+	keysymLatin1End   xproto.Keysym = 0xff
+)
+
+// keysyms that can't be written as a single character
+var namedKeysyms = map[string]xproto.Keysym{
+	"escape":      keysymEscape,
+	"left":        keysymLeft,
+	"up":          keysymUp,
+	"right":       keysymRight,
+	"down":        keysymDown,
+	"kp_add":      keysymKpAdd,
+	"kp_subtract": keysymKpSubtract,
+	"plus":        keysymPlus,
+	"minus":       keysymMinus,
+	"equal":       keysymEqual,
+	"comma":       0x2c,
+	"period":      0x2e,
+	"space":       0x20,
+	"return":      0xff0d,
+	"tab":         0xff09,
+	"backspace":   0xff08,
+	"delete":      0xffff,
+	"home":        0xff50,
+	"end":         0xff57,
+	"page_up":     0xff55,
+	"page_down":   0xff56,
+	"f1":          0xffbe,
+	"f11":         0xffc8,
+
+	"xf86audioplay":   keysymXF86AudioPlay,
+	"xf86audioprev":   keysymXF86AudioPrev,
+	"xf86audionext":   keysymXF86AudioNext,
+	"xf86back":        keysymXF86Back,
+	"xf86forward":     keysymXF86Forward,
+	"xf86screensaver": keysymXF86ScreenSaver,
+}
+
+const modifierMask = xproto.ModMaskShift | xproto.ModMaskControl | xproto.ModMask1 | xproto.ModMask4
+
+var modifierNames = map[string]uint16{
+	"shift": xproto.ModMaskShift,
+	"ctrl":  xproto.ModMaskControl,
+	"alt":   xproto.ModMask1,
+	"super": xproto.ModMask4,
+}
+
+type action string
+
+const (
+	actionNudgeLeft   action = "nudge-left"
+	actionNudgeRight  action = "nudge-right"
+	actionNudgeUp     action = "nudge-up"
+	actionNudgeDown   action = "nudge-down"
+	actionOpacityUp   action = "opacity-up"
+	actionOpacityDown action = "opacity-down"
+	actionFullscreen  action = "fullscreen"
+	actionQuit        action = "quit"
+
+	actionNextImage     action = "next-image"
+	actionPreviousImage action = "previous-image"
+
+	actionCopyImage    action = "copy-image"
+	actionCopyColor    action = "copy-color"
+	actionCopyGeometry action = "copy-geometry"
+
+	actionTogglePrivacy action = "toggle-privacy"
+	actionToggleInfo    action = "toggle-info"
+	actionRotate        action = "rotate"
+	actionResetZoom     action = "reset-zoom"
+	actionEditCorners   action = "edit-corners"
+	actionResetCorners  action = "reset-corners"
+	actionToggleGuides  action = "toggle-guides"
+	actionTogglePicker  action = "toggle-picker"
+	actionToggleSticky  action = "toggle-sticky"
+
+	actionToggleFilters   action = "toggle-filters"
+	actionToggleInvert    action = "toggle-invert"
+	actionCycleChannel    action = "cycle-channel"
+	actionToggleOutline   action = "toggle-outline"
+	actionToggleBlueprint action = "toggle-blueprint"
+
+	actionHistoryBack    action = "history-back"
+	actionHistoryForward action = "history-forward"
+
+	actionToggleRecording action = "toggle-recording"
+
+	actionScreenshot        action = "screenshot"
+	actionScreenshotBlended action = "screenshot-blended"
+
+	actionUndo action = "undo"
+	actionRedo action = "redo"
+
+	actionBlank action = "blank"
+
+	actionToggleHelp action = "toggle-help"
+)
+
+var actions = []action{
+	actionNudgeLeft,
+	actionNudgeRight,
+	actionNudgeUp,
+	actionNudgeDown,
+	actionOpacityUp,
+	actionOpacityDown,
+	actionFullscreen,
+	actionQuit,
+	actionNextImage,
+	actionPreviousImage,
+	actionCopyImage,
+	actionCopyColor,
+	actionCopyGeometry,
+	actionTogglePrivacy,
+	actionToggleInfo,
+	actionRotate,
+	actionToggleFilters,
+	actionToggleInvert,
+	actionCycleChannel,
+	actionToggleOutline,
+	actionToggleBlueprint,
+	actionResetZoom,
+	actionEditCorners,
+	actionResetCorners,
+	actionToggleGuides,
+	actionTogglePicker,
+	actionToggleSticky,
+	actionHistoryBack,
+	actionHistoryForward,
+	actionToggleRecording,
+	actionScreenshot,
+	actionScreenshotBlended,
+	actionUndo,
+	actionRedo,
+	actionBlank,
+	actionToggleHelp,
+}
+
+type KeyCombo struct {
+	keysym    xproto.Keysym
+	modifiers uint16
+}
+
+type Keymap map[KeyCombo]action
+
+var DefaultBindings = []string{
+	"left=nudge-left",
+	"right=nudge-right",
+	"up=nudge-up",
+	"down=nudge-down",
+	"plus=opacity-up",
+	"equal=opacity-up",
+	"kp_add=opacity-up",
+	"minus=opacity-down",
+	"kp_subtract=opacity-down",
+	"f=fullscreen",
+	"s=toggle-sticky",
+	"n=next-image",
+	"page_down=next-image",
+	"p=previous-image",
+	"page_up=previous-image",
+	"xf86audionext=next-image",
+	"xf86forward=next-image",
+	"xf86audioprev=previous-image",
+	"xf86back=previous-image",
+	"xf86audioplay=blank",
+	"xf86screensaver=blank",
+	"ctrl+c=copy-image",
+	"c=copy-color",
+	"ctrl+g=copy-geometry",
+	"i=toggle-info",
+	"r=rotate",
+	"t=toggle-filters",
+	"x=toggle-invert",
+	"shift+c=cycle-channel",
+	"o=toggle-outline",
+	"b=toggle-blueprint",
+	"0=reset-zoom",
+	"k=edit-corners",
+	"shift+k=reset-corners",
+	"g=toggle-guides",
+	"e=toggle-picker",
+	"comma=history-back",
+	"period=history-forward",
+	"ctrl+r=toggle-recording",
+	"ctrl+s=screenshot",
+	"ctrl+shift+s=screenshot-blended",
+	"ctrl+z=undo",
+	"ctrl+shift+z=redo",
+	"alt+1=bookmark-1",
+	"alt+2=bookmark-2",
+	"alt+3=bookmark-3",
+	"alt+4=bookmark-4",
+	"alt+5=bookmark-5",
+	"alt+6=bookmark-6",
+	"alt+7=bookmark-7",
+	"alt+8=bookmark-8",
+	"alt+9=bookmark-9",
+	"ctrl+alt+1=save-bookmark-1",
+	"ctrl+alt+2=save-bookmark-2",
+	"ctrl+alt+3=save-bookmark-3",
+	"ctrl+alt+4=save-bookmark-4",
+	"ctrl+alt+5=save-bookmark-5",
+	"ctrl+alt+6=save-bookmark-6",
+	"ctrl+alt+7=save-bookmark-7",
+	"ctrl+alt+8=save-bookmark-8",
+	"ctrl+alt+9=save-bookmark-9",
+	"?=toggle-help",
+	"q=quit",
+	"escape=quit",
+}
+
+// ParseKeyCombo parses key combinations like "ctrl+shift+z", "Left" or "+".
+func ParseKeyCombo(combo string) (KeyCombo, error) {
+	parts := strings.Split(combo, "+")
+
+	// a trailing "+" means the plus key itself, e.g. "ctrl++"
+	if strings.HasSuffix(combo, "++") || combo == "+" {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+
+	var result KeyCombo
+
+	for _, modifier := range parts[:len(parts)-1] {
+		mask, ok := modifierNames[strings.ToLower(modifier)]
+		if !ok {
+			return KeyCombo{}, fmt.Errorf("unknown modifier %q", modifier)
+		}
+
+		result.modifiers |= mask
+	}
+
+	key := parts[len(parts)-1]
+
+	if keysym, ok := namedKeysyms[strings.ToLower(key)]; ok {
+		result.keysym = keysym
+		return result, nil
+	}
+
+	r, size := utf8.DecodeRuneInString(key)
+	if size != len(key) || xproto.Keysym(r) < keysymLatin1Start || xproto.Keysym(r) > keysymLatin1End {
+		return KeyCombo{}, fmt.Errorf("unknown key %q", key)
+	}
+
+	result.keysym = xproto.Keysym(r)
+
+	// letters are always bound by their lowercase keysym, shift has to be
+	// given explicitly
+	if result.keysym >= keysymUppercaseA && result.keysym <= keysymUppercaseZ {
+		result.keysym += keysymLowercaseA - keysymUppercaseA
+	}
+
+	return result, nil
+}
+
+func parseAction(name string) (action, error) {
+	for _, a := range actions {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+
+	// bookmark-1 to bookmark-9 and save-bookmark-1 to save-bookmark-9
+	if _, _, ok := action(name).bookmark(); ok {
+		return action(name), nil
+	}
+
+	return "", fmt.Errorf("unknown action %q", name)
+}
+
+// ParseKeymap builds a Keymap from bindings of the form "key=action". Later
+// bindings override earlier ones and binding a key to "none" removes it.
+func ParseKeymap(bindings []string) (Keymap, error) {
+	result := Keymap{}
+
+	for _, binding := range bindings {
+		combo, name, ok := strings.Cut(binding, "=")
+		if !ok {
+			return nil, fmt.Errorf("binding %q: expected key=action", binding)
+		}
+
+		key, err := ParseKeyCombo(combo)
+		if err != nil {
+			return nil, fmt.Errorf("binding %q: %w", binding, err)
+		}
+
+		if name == "none" {
+			delete(result, key)
+			continue
+		}
+
+		a, err := parseAction(name)
+		if err != nil {
+			return nil, fmt.Errorf("binding %q: %w", binding, err)
+		}
+
+		result[key] = a
+	}
+
+	return result, nil
+}
+
+// SetKeymap binds the keys of keymap from now on, in this window and the
+// windows added to it with Add.
+func (display *Window) SetKeymap(keymap Keymap) error {
+	display.renderMu.Lock()
+	display.options.Keymap = keymap
+	display.helpCache = nil
+	added := slices.Clone(display.added)
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	// the presenter keys follow their actions
+	err := display.grabGlobalKeys()
+	if err != nil {
+		return fmt.Errorf("grab global keys: %w", err)
+	}
+
+	open := display.connection.Windows()
+	for _, other := range added {
+		if !slices.Contains(open, other) {
+			continue
+		}
+
+		err := other.SetKeymap(keymap)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// boundAction returns the action bound to combo.
+func (display *Window) boundAction(combo KeyCombo) (action, bool) {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	a, ok := display.options.Keymap[combo]
+
+	return a, ok
+}
+
+type keyboardMapping struct {
+	minKeycode        xproto.Keycode
+	keysymsPerKeycode int
+	keysyms           []xproto.Keysym
+}
+
+func loadKeyboardMapping(conn *xgb.Conn) (*keyboardMapping, error) {
+	setup := xproto.Setup(conn)
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+
+	reply, err := xproto.GetKeyboardMapping(conn, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get keyboard mapping: %w", err)
+	}
+
+	return &keyboardMapping{
+		minKeycode:        setup.MinKeycode,
+		keysymsPerKeycode: int(reply.KeysymsPerKeycode),
+		keysyms:           reply.Keysyms,
+	}, nil
+}
+
+func (mapping *keyboardMapping) keysym(keycode xproto.Keycode, column int) xproto.Keysym {
+	index := int(keycode-mapping.minKeycode)*mapping.keysymsPerKeycode + column
+	if keycode < mapping.minKeycode || column >= mapping.keysymsPerKeycode || index >= len(mapping.keysyms) {
+		return 0
+	}
+
+	return mapping.keysyms[index]
+}
+
+// lookup translates a key press into the combination used for bindings.
+// Shift is consumed when it produces a different symbol (e.g. "+" on US
+// layouts), except for letters which are always reported in lowercase.
+func (mapping *keyboardMapping) lookup(keycode xproto.Keycode, state uint16) KeyCombo {
+	modifiers := state & modifierMask
+	unshifted := mapping.keysym(keycode, 0)
+
+	if modifiers&xproto.ModMaskShift == 0 {
+		return KeyCombo{keysym: unshifted, modifiers: modifiers}
+	}
+
+	isLetter := unshifted >= keysymLowercaseA && unshifted <= keysymLowercaseZ
+
+	shifted := mapping.keysym(keycode, 1)
+	if shifted == 0 || isLetter {
+		return KeyCombo{keysym: unshifted, modifiers: modifiers}
+	}
+
+	return KeyCombo{keysym: shifted, modifiers: modifiers &^ xproto.ModMaskShift}
+}
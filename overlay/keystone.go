@@ -0,0 +1,232 @@
+package overlay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The corners dragged in the keystone editor are remembered per image if
+// Options.RememberCorners is set, in a file with a line of corners and the
+// absolute path for every image:
+//
+//	120,80,1800,140,1760,1000,90,1040 /home/user/calibration.png
+
+// StateDir returns the directory state that is not worth backing up is kept
+// in, in $XDG_STATE_HOME.
+func StateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("find state directory: %w", err)
+		}
+
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "xoverlay"), nil
+}
+
+// keystonePath returns the file the corners are remembered in.
+func keystonePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "corners"), nil
+}
+
+func loadKeystones() (map[string][]image.Point, error) {
+	path, err := keystonePath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string][]image.Point{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("open corners: %w", err)
+	}
+	defer file.Close()
+
+	keystones := map[string][]image.Point{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, source, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+
+		// lines that don't parse are dropped with the next save
+		corners, err := ParseCorners(value)
+		if err != nil {
+			continue
+		}
+
+		keystones[source] = corners
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read corners: %w", err)
+	}
+
+	return keystones, nil
+}
+
+// storeKeystone remembers corners for the image file source, nil forgets
+// them.
+func storeKeystone(source string, corners []image.Point) error {
+	keystones, err := loadKeystones()
+	if err != nil {
+		return err
+	}
+
+	if corners == nil {
+		delete(keystones, source)
+	} else {
+		keystones[source] = corners
+	}
+
+	path, err := keystonePath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+
+	var content strings.Builder
+	for _, source := range slices.Sorted(maps.Keys(keystones)) {
+		fmt.Fprintf(&content, "%s %s\n", formatCorners(keystones[source]), source)
+	}
+
+	// written next to it and renamed, so that overlays saving at the same
+	// time don't leave a mix of both behind
+	temp := path + ".tmp" + fmt.Sprint(os.Getpid())
+
+	err = os.WriteFile(temp, []byte(content.String()), 0o600)
+	if err != nil {
+		return fmt.Errorf("write corners: %w", err)
+	}
+
+	err = os.Rename(temp, path)
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("write corners: %w", err)
+	}
+
+	return nil
+}
+
+// keystoneSource returns the key the corners of source are remembered by,
+// or "" if it is not a file, like a mirrored window or a posted image, or
+// they are not remembered at all.
+func (display *Window) keystoneSource(source string) string {
+	if !display.options.RememberCorners {
+		return ""
+	}
+
+	return imageFile(source)
+}
+
+// cornersFor returns the corners for the image from source, the remembered
+// ones if there are any and Options.Corners otherwise.
+func (display *Window) cornersFor(source string) []image.Point {
+	key := display.keystoneSource(source)
+	if key == "" {
+		return display.options.Corners
+	}
+
+	keystones, err := loadKeystones()
+	if err != nil {
+		slog.Error("load corners", "err", err)
+		return display.options.Corners
+	}
+
+	if corners, ok := keystones[key]; ok {
+		return corners
+	}
+
+	return display.options.Corners
+}
+
+// rememberCorners stores the corners of the current image, nil forgets them.
+func (display *Window) rememberCorners(source string, corners []image.Point) {
+	key := display.keystoneSource(source)
+	if key == "" {
+		return
+	}
+
+	err := storeKeystone(key, corners)
+	if err != nil {
+		slog.Error("remember corners", "err", err)
+	}
+}
+
+// placedCorners returns the corners of the image where the scale mode
+// places it, where the editor starts without corners.
+func (display *Window) placedCorners() []image.Point {
+	bounds := display.image.Bounds()
+	placed := placeImage(display.options.Scale, display.options.Align, bounds.Dx(), bounds.Dy(), display.windowWidth, display.windowHeight)
+
+	return []image.Point{
+		placed.Min,
+		image.Pt(placed.Max.X, placed.Min.Y),
+		placed.Max,
+		image.Pt(placed.Min.X, placed.Max.Y),
+	}
+}
+
+// nudgeCorner moves the selected corner by dx, dy while the corners are
+// edited, for adjustments finer than the mouse. It reports whether they
+// are edited.
+func (display *Window) nudgeCorner(dx int, dy int) bool {
+	display.renderMu.Lock()
+	if !display.editCorners {
+		display.renderMu.Unlock()
+		return false
+	}
+
+	corners := slices.Clone(display.corners)
+	corners[display.selectedCorner] = corners[display.selectedCorner].Add(image.Pt(dx, dy))
+
+	if convexQuad(corners) {
+		display.corners = corners
+	}
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	return true
+}
+
+// resetCorners goes back to the corners given in the options, or to the
+// scale mode without them, and forgets the remembered ones.
+func (display *Window) resetCorners() {
+	display.renderMu.Lock()
+	display.corners = display.options.Corners
+	if display.editCorners && display.corners == nil {
+		display.corners = display.placedCorners()
+	}
+	source := display.source
+	display.renderMu.Unlock()
+
+	display.rememberCorners(source, nil)
+	display.requestRedraw()
+}
@@ -0,0 +1,174 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+type ScaleMode string
+
+const (
+	// scale to fit into the window, keeping the aspect ratio
+	ScaleFit ScaleMode = "fit"
+	// scale to cover the window, keeping the aspect ratio and cropping
+	ScaleFill ScaleMode = "fill"
+	// scale to the size of the window, ignoring the aspect ratio
+	ScaleStretch ScaleMode = "stretch"
+	// show the image at its original size
+	ScaleCenter ScaleMode = "center"
+	// repeat the image at its original size
+	ScaleTile ScaleMode = "tile"
+)
+
+var scaleModes = []ScaleMode{ScaleFit, ScaleFill, ScaleStretch, ScaleCenter, ScaleTile}
+
+func ParseScaleMode(name string) (ScaleMode, error) {
+	for _, mode := range scaleModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown scale mode %q", name)
+}
+
+// Alignment anchors the image within the window. Both values are 0 for the
+// start, 1 for the center and 2 for the end.
+type Alignment struct {
+	x int
+	y int
+}
+
+var AlignCenter = Alignment{1, 1}
+
+// ParseAlignment parses alignments like "top-left", "right" or "center".
+func ParseAlignment(value string) (Alignment, error) {
+	result := AlignCenter
+
+	if value == "center" {
+		return result, nil
+	}
+
+	for _, part := range strings.Split(value, "-") {
+		switch part {
+		case "top":
+			result.y = 0
+		case "bottom":
+			result.y = 2
+		case "left":
+			result.x = 0
+		case "right":
+			result.x = 2
+		default:
+			return Alignment{}, fmt.Errorf("unknown Alignment %q", value)
+		}
+	}
+
+	return result, nil
+}
+
+// placeImage returns the rectangle the whole image covers when it is shown
+// in a window of the given size. It can extend beyond the window, e.g. when
+// filling it. For tiles it is the tile at the anchor.
+func placeImage(mode ScaleMode, align Alignment, imageWidth int, imageHeight int, width int, height int) image.Rectangle {
+	scaledWidth := imageWidth
+	scaledHeight := imageHeight
+
+	// integer math so that a window of exactly the image size is not off by
+	// one because of rounding
+	switch mode {
+	case ScaleFit:
+		if width*imageHeight > height*imageWidth {
+			scaledWidth = height * imageWidth / imageHeight
+			scaledHeight = height
+		} else {
+			scaledWidth = width
+			scaledHeight = width * imageHeight / imageWidth
+		}
+	case ScaleFill:
+		// rounded up, a gap of one pixel at the edge would be visible
+		if width*imageHeight > height*imageWidth {
+			scaledWidth = width
+			scaledHeight = (width*imageHeight + imageWidth - 1) / imageWidth
+		} else {
+			scaledWidth = (height*imageWidth + imageHeight - 1) / imageHeight
+			scaledHeight = height
+		}
+	case ScaleStretch:
+		scaledWidth = width
+		scaledHeight = height
+	}
+
+	x := (width - scaledWidth) * align.x / 2
+	y := (height - scaledHeight) * align.y / 2
+
+	return image.Rect(x, y, x+scaledWidth, y+scaledHeight)
+}
+
+// tileImage fills dst, which is width pixels wide and height pixels high,
+// with copies of tile so that one of them starts at origin.
+func tileImage(dst []byte, tile []byte, tileWidth int, tileHeight int, width int, height int, origin image.Point) {
+	tileRowSize := tileWidth * 4
+	rowSize := width * 4
+
+	startX := mod(-origin.X, tileWidth)
+
+	for y := range height {
+		ty := mod(y-origin.Y, tileHeight)
+		src := tile[ty*tileRowSize : (ty+1)*tileRowSize]
+		row := dst[y*rowSize : (y+1)*rowSize]
+
+		tx := startX
+		for x := 0; x < width; {
+			n := min(tileWidth-tx, width-x)
+			copy(row[x*4:(x+n)*4], src[tx*4:(tx+n)*4])
+			x += n
+			tx = 0
+		}
+	}
+}
+
+func mod(a int, b int) int {
+	return (a%b + b) % b
+}
+
+// cropImage returns the part of img within rect if img supports it.
+func cropImage(img image.Image, rect image.Rectangle) (image.Image, bool) {
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, false
+	}
+
+	return sub.SubImage(rect), true
+}
+
+// imagePoint maps a point in the window to the pixel of the image shown
+// there. It returns false if the point is outside of the image.
+func (display *Window) imagePoint(x int, y int) (image.Point, bool) {
+	display.renderMu.Lock()
+	img := display.image
+	view := display.view
+	display.renderMu.Unlock()
+
+	bounds := img.Bounds()
+	placed := view.apply(placeImage(display.options.Scale, display.options.Align, bounds.Dx(), bounds.Dy(), display.windowWidth, display.windowHeight))
+
+	if display.options.Scale == ScaleTile {
+		return image.Point{
+			X: bounds.Min.X + mod(x-placed.Min.X, bounds.Dx()),
+			Y: bounds.Min.Y + mod(y-placed.Min.Y, bounds.Dy()),
+		}, true
+	}
+
+	if !(image.Point{x, y}).In(placed) {
+		return image.Point{}, false
+	}
+
+	return image.Point{
+		X: bounds.Min.X + (x-placed.Min.X)*bounds.Dx()/placed.Dx(),
+		Y: bounds.Min.Y + (y-placed.Min.Y)*bounds.Dy()/placed.Dy(),
+	}, true
+}
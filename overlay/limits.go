@@ -0,0 +1,168 @@
+package overlay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	limitsSampleInterval = time.Second
+	minRenderThrottle    = 10 * time.Millisecond
+	maxRenderThrottle    = 2 * time.Second
+)
+
+type ResourceLimits struct {
+	// maximum CPU usage in percent of one core, 0 disables the limit
+	MaxCPUPercent float64
+	// maximum resident set size in bytes, 0 disables the limit
+	MaxRSS int64
+}
+
+func (limits ResourceLimits) enabled() bool {
+	return limits.MaxCPUPercent > 0 || limits.MaxRSS > 0
+}
+
+func cpuTime() (time.Duration, error) {
+	var usage unix.Rusage
+
+	err := unix.Getrusage(unix.RUSAGE_SELF, &usage)
+	if err != nil {
+		return 0, fmt.Errorf("get resource usage: %w", err)
+	}
+
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+func residentSetSize() (int64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, fmt.Errorf("read statm: %w", err)
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected statm format")
+	}
+
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse resident pages: %w", err)
+	}
+
+	return pages * int64(os.Getpagesize()), nil
+}
+
+// enforceLimits periodically samples the CPU and memory usage of the process.
+// While the CPU usage is above the limit the renderer is throttled more and
+// more, once it is below again the throttling is relaxed. Exceeding the
+// memory limit drops all cached frames.
+func (display *Window) enforceLimits(ctx context.Context, limits ResourceLimits) {
+	display.wg.Add(1)
+	defer display.wg.Done()
+
+	ticker := time.NewTicker(limitsSampleInterval)
+	defer ticker.Stop()
+
+	lastCPU, err := cpuTime()
+	if err != nil {
+		slog.Error("enforce limits", "err", err)
+		return
+	}
+
+	lastSample := time.Now()
+	cpuExceeded := false
+	rssExceeded := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if limits.MaxCPUPercent > 0 {
+			now := time.Now()
+
+			cpu, err := cpuTime()
+			if err != nil {
+				slog.Error("enforce limits", "err", err)
+				return
+			}
+
+			percent := 100 * float64(cpu-lastCPU) / float64(now.Sub(lastSample))
+			lastCPU = cpu
+			lastSample = now
+
+			exceeded := percent > limits.MaxCPUPercent
+			if exceeded && !cpuExceeded {
+				slog.Warn("cpu usage exceeds the limit, throttling rendering", "percent", int(percent), "limit", limits.MaxCPUPercent)
+			}
+
+			cpuExceeded = exceeded
+			display.adjustThrottle(exceeded)
+		}
+
+		if limits.MaxRSS > 0 {
+			rss, err := residentSetSize()
+			if err != nil {
+				slog.Error("enforce limits", "err", err)
+				return
+			}
+
+			exceeded := rss > limits.MaxRSS
+			if exceeded {
+				if !rssExceeded {
+					slog.Warn("memory usage exceeds the limit, dropping cached frames", "mb", rss>>20, "limit_mb", limits.MaxRSS>>20)
+				}
+
+				display.renderMu.Lock()
+				display.dropCaches = true
+				display.renderMu.Unlock()
+
+				display.wakeRenderer()
+			}
+
+			rssExceeded = exceeded
+		}
+	}
+}
+
+// adjustThrottle doubles the minimum time between renders while the CPU limit
+// is exceeded and halves it again once it is not.
+func (display *Window) adjustThrottle(exceeded bool) {
+	// renders that were held back may be due now
+	defer display.wakeRenderer()
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	switch {
+	case exceeded:
+		display.throttle = min(maxRenderThrottle, max(minRenderThrottle, 2*display.throttle))
+	case display.throttle <= minRenderThrottle:
+		display.throttle = 0
+	default:
+		display.throttle /= 2
+	}
+}
+
+// dropCachedFrames is called by the renderer when the memory limit has been
+// exceeded.
+func (display *Window) dropCachedFrames() {
+	display.renderMu.Lock()
+	display.frameCache = frameCache{}
+	display.renderMu.Unlock()
+	display.bandCache = bandCache{}
+
+	// the garbage collector would otherwise keep the memory around for a
+	// while before returning it to the system
+	debug.FreeOSMemory()
+}
@@ -0,0 +1,195 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// With Options.Magnify the overlay is a loupe: it follows the pointer over
+// the whole screen, which only sends motion events within our own window, by
+// querying it regularly, and shows the screen around it enlarged. The screen
+// is read like the color picker reads it, from the redirected windows below
+// the overlay, so the loupe doesn't magnify itself.
+
+// the screen is captured at most this often
+const minMagnifyInterval = 33 * time.Millisecond
+
+// the pixel grid is only drawn once the pixels are at least this large
+const minMagnifyGridCell = 4
+
+var (
+	magnifyGridColor = color.RGBA{0, 0, 0, 0x50}
+	crosshairColor   = color.RGBA{0xff, 0, 0, 0xff}
+)
+
+// magnifier is what the loupe last captured.
+type magnifier struct {
+	factor float64
+	// the pixel of the captured image the pointer is on
+	pointer image.Point
+}
+
+// startMagnifier starts following the pointer.
+func (display *Window) startMagnifier(factor float64) error {
+	err := display.redirectWindows()
+	if err != nil {
+		return err
+	}
+
+	display.renderMu.Lock()
+	display.magnifier = &magnifier{factor: factor}
+	display.renderMu.Unlock()
+
+	display.wg.Add(1)
+	go display.runMagnifier(factor)
+
+	return nil
+}
+
+func (display *Window) runMagnifier(factor float64) {
+	defer display.wg.Done()
+
+	ticker := time.NewTicker(display.debounce(minMagnifyInterval))
+	defer ticker.Stop()
+
+	for {
+		err := display.magnify(factor)
+		if err != nil && display.ctx.Err() == nil {
+			slog.Error("magnify", "err", err)
+			display.sourceFailed("magnifier", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-display.ctx.Done():
+			return
+		}
+	}
+}
+
+// magnify captures the screen around the pointer, as much of it as fills
+// the window at factor.
+func (display *Window) magnify(factor float64) error {
+	pointer, err := xproto.QueryPointer(display.conn, display.screen.Root).Reply()
+	if err != nil {
+		return fmt.Errorf("query pointer: %w", err)
+	}
+
+	display.renderMu.Lock()
+	size := image.Pt(display.windowWidth, display.windowHeight)
+	display.renderMu.Unlock()
+
+	if size.X <= 0 || size.Y <= 0 {
+		return nil
+	}
+
+	root := image.Pt(int(pointer.RootX), int(pointer.RootY))
+	screen := image.Rect(0, 0, int(display.screen.WidthInPixels), int(display.screen.HeightInPixels))
+	region := magnifyRegion(root, size, factor, screen)
+
+	pix, err := display.captureBelow(region)
+	if err != nil {
+		return fmt.Errorf("capture screen: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rectangle{Max: region.Size()})
+	copy(img.Pix, pix)
+
+	// captured in the byte order of X, swapping red and blue works both ways
+	rgbaToBGRA(img.Pix, display.options.RenderThreads)
+
+	display.renderMu.Lock()
+	display.magnifier.pointer = root.Sub(region.Min)
+	display.renderMu.Unlock()
+
+	display.setLiveImage("magnifier", decodedImage{image: img})
+
+	return nil
+}
+
+// magnifyRegion returns the part of screen that fills a window of size at
+// factor, centered on pointer but moved back onto the screen at its edges.
+func magnifyRegion(pointer image.Point, size image.Point, factor float64, screen image.Rectangle) image.Rectangle {
+	region := image.Point{
+		X: min(screen.Dx(), int(math.Ceil(float64(size.X)/factor))),
+		Y: min(screen.Dy(), int(math.Ceil(float64(size.Y)/factor))),
+	}
+
+	origin := pointer.Sub(region.Div(2))
+	origin.X = min(max(origin.X, screen.Min.X), screen.Max.X-region.X)
+	origin.Y = min(max(origin.Y, screen.Min.Y), screen.Max.Y-region.Y)
+
+	return image.Rectangle{Min: origin, Max: origin.Add(region)}
+}
+
+// magnifierDecorated reports whether a pixel grid or crosshair is drawn over
+// the magnified image.
+func (display *Window) magnifierDecorated() bool {
+	return display.options.Magnify > 0 && (display.options.MagnifyGrid || display.options.Crosshair)
+}
+
+// drawMagnifier draws the pixel grid and the crosshair onto buf, which holds
+// the visible part of the window, for img shown at placed.
+func (display *Window) drawMagnifier(buf []byte, visible image.Rectangle, img image.Image, placed image.Rectangle) {
+	display.renderMu.Lock()
+	current := display.magnifier
+	pointer := image.Point{-1, -1}
+	if current != nil && display.source == "magnifier" {
+		pointer = current.pointer
+	}
+	display.renderMu.Unlock()
+
+	size := img.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return
+	}
+
+	// where the edges of the pixels of the image are in the window
+	edgeX := func(x int) int { return placed.Min.X + x*placed.Dx()/size.X }
+	edgeY := func(y int) int { return placed.Min.Y + y*placed.Dy()/size.Y }
+
+	fill := func(area image.Rectangle, c color.RGBA) {
+		area = area.Intersect(visible)
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				blendPixel(buf[((y-visible.Min.Y)*visible.Dx()+x-visible.Min.X)*4:], c)
+			}
+		}
+	}
+
+	if display.options.MagnifyGrid && placed.Dx() >= size.X*minMagnifyGridCell {
+		for x := 1; x < size.X; x++ {
+			fill(image.Rect(edgeX(x), placed.Min.Y, edgeX(x)+1, placed.Max.Y), magnifyGridColor)
+		}
+
+		for y := 1; y < size.Y; y++ {
+			fill(image.Rect(placed.Min.X, edgeY(y), placed.Max.X, edgeY(y)+1), magnifyGridColor)
+		}
+	}
+
+	// the crosshair is cut out around the pixel under the pointer, so that
+	// its color stays visible
+	if display.options.Crosshair && pointer.In(image.Rectangle{Max: size}) {
+		pixel := image.Rect(edgeX(pointer.X), edgeY(pointer.Y), edgeX(pointer.X+1), edgeY(pointer.Y+1))
+		centerX := (pixel.Min.X + pixel.Max.X) / 2
+		centerY := (pixel.Min.Y + pixel.Max.Y) / 2
+
+		fill(image.Rect(placed.Min.X, centerY, pixel.Min.X-1, centerY+1), crosshairColor)
+		fill(image.Rect(pixel.Max.X+1, centerY, placed.Max.X, centerY+1), crosshairColor)
+		fill(image.Rect(centerX, placed.Min.Y, centerX+1, pixel.Min.Y-1), crosshairColor)
+		fill(image.Rect(centerX, pixel.Max.Y+1, centerX+1, placed.Max.Y), crosshairColor)
+
+		// the outline of the pixel
+		outline := pixel.Inset(-1)
+		fill(image.Rect(outline.Min.X, outline.Min.Y, outline.Max.X, outline.Min.Y+1), crosshairColor)
+		fill(image.Rect(outline.Min.X, outline.Max.Y-1, outline.Max.X, outline.Max.Y), crosshairColor)
+		fill(image.Rect(outline.Min.X, outline.Min.Y, outline.Min.X+1, outline.Max.Y), crosshairColor)
+		fill(image.Rect(outline.Max.X-1, outline.Min.Y, outline.Max.X, outline.Max.Y), crosshairColor)
+	}
+}
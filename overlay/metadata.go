@@ -0,0 +1,418 @@
+package overlay
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// imageInfo is what the info panel shows about an image besides its name and
+// size in pixels.
+type imageInfo struct {
+	format   string
+	fileSize int
+	profile  string
+	exif     []string
+	// 2, 4 or 8 for jpegs that were decoded at a fraction of their size
+	reduction int
+}
+
+// profiles are only decompressed up to this size to find their description
+const maxProfileSize = 4 << 20
+
+func describeImage(imageBytes []byte) imageInfo {
+	info := imageInfo{fileSize: len(imageBytes)}
+
+	if isSVG(imageBytes) {
+		info.format = "svg"
+		return info
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err == nil {
+		info.format = format
+	}
+
+	var profile, exif []byte
+	switch {
+	case bytes.HasPrefix(imageBytes, pngSignature):
+		profile, exif = pngMetadata(imageBytes)
+	case bytes.HasPrefix(imageBytes, []byte{0xff, 0xd8}):
+		profile, exif = jpegMetadata(imageBytes)
+	case len(imageBytes) >= 12 && string(imageBytes[0:4]) == "RIFF" && string(imageBytes[8:12]) == "WEBP":
+		profile, exif = webpMetadata(imageBytes)
+	}
+
+	if profile != nil {
+		info.profile = iccDescription(profile)
+	}
+
+	if exif != nil {
+		info.exif = exifHighlights(exif)
+	}
+
+	return info
+}
+
+func pngMetadata(imageBytes []byte) (profile []byte, exif []byte) {
+	chunks, err := readPNGChunks(imageBytes)
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, chunk := range chunks {
+		switch chunk.typ {
+		case "sRGB":
+			if profile == nil {
+				profile = []byte{}
+			}
+		case "iCCP":
+			// name, null, compression method, zlib stream
+			name, compressed, ok := bytes.Cut(chunk.data, []byte{0})
+			if !ok || len(compressed) < 1 {
+				continue
+			}
+
+			reader, err := zlib.NewReader(bytes.NewReader(compressed[1:]))
+			if err != nil {
+				profile = name
+				continue
+			}
+
+			data, err := io.ReadAll(io.LimitReader(reader, maxProfileSize))
+			if err != nil || iccDescription(data) == "" {
+				data = name
+			}
+
+			profile = data
+		case "eXIf":
+			exif = chunk.data
+		}
+	}
+
+	return profile, exif
+}
+
+func jpegMetadata(imageBytes []byte) (profile []byte, exif []byte) {
+	const (
+		markerStartOfScan = 0xda
+		markerApp1        = 0xe1
+		markerApp2        = 0xe2
+	)
+
+	rest := imageBytes[2:]
+	for len(rest) >= 4 && rest[0] == 0xff {
+		marker := rest[1]
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		if marker == markerStartOfScan || length < 2 || length+2 > len(rest) {
+			break
+		}
+
+		data := rest[4 : 2+length]
+		switch {
+		case marker == markerApp1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+			exif = data[6:]
+		case marker == markerApp2 && bytes.HasPrefix(data, []byte("ICC_PROFILE\x00")) && len(data) > 14:
+			// large profiles are split up into numbered segments, which
+			// come in order in practice
+			profile = append(profile, data[14:]...)
+		}
+
+		rest = rest[2+length:]
+	}
+
+	return profile, exif
+}
+
+func webpMetadata(imageBytes []byte) (profile []byte, exif []byte) {
+	rest := imageBytes[12:]
+	for len(rest) >= 8 {
+		fourcc := string(rest[0:4])
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		// negative where ints have 32 bits
+		if size < 0 || size > len(rest)-8 {
+			break
+		}
+
+		data := rest[8 : 8+size]
+		switch fourcc {
+		case "ICCP":
+			profile = data
+		case "EXIF":
+			// some writers keep the jpeg header
+			exif = bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+		}
+
+		// chunks are padded to an even size, the last one may not be
+		rest = rest[min(8+size+size%2, len(rest)):]
+	}
+
+	return profile, exif
+}
+
+// iccDescription returns the description of an icc profile. Profiles that
+// can't be parsed are described by the name they were given, an empty
+// profile means srgb.
+func iccDescription(profile []byte) string {
+	const headerSize = 128
+
+	if len(profile) == 0 {
+		return "sRGB"
+	}
+
+	if len(profile) < headerSize+4 {
+		return string(profile)
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[headerSize:]))
+	for i := range count {
+		entry := headerSize + 4 + i*12
+		if entry+12 > len(profile) {
+			break
+		}
+
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || offset > len(profile) || size < 12 || size > len(profile)-offset {
+			break
+		}
+
+		return parseICCText(profile[offset : offset+size])
+	}
+
+	return "embedded ICC profile"
+}
+
+// parseICCText reads the textDescriptionType of version 2 profiles and the
+// multiLocalizedUnicodeType of version 4, taking the first language.
+func parseICCText(tag []byte) string {
+	switch string(tag[0:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:12]))
+		if length < 0 || length > len(tag)-12 {
+			return ""
+		}
+
+		return strings.TrimRight(string(tag[12:12+length]), "\x00")
+	case "mluc":
+		if len(tag) < 28 {
+			return ""
+		}
+
+		length := int(binary.BigEndian.Uint32(tag[20:24]))
+		offset := int(binary.BigEndian.Uint32(tag[24:28]))
+		if offset < 0 || offset > len(tag) || length < 0 || length > len(tag)-offset {
+			return ""
+		}
+
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+
+	return ""
+}
+
+// exif tags shown in the info panel
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagExifIFD          = 0x8769
+	exifTagExposureTime     = 0x829a
+	exifTagFNumber          = 0x829d
+	exifTagISO              = 0x8827
+	exifTagDateTimeOriginal = 0x9003
+	exifTagFocalLength      = 0x920a
+)
+
+// exifReader reads values from the tiff structure exif data is stored in.
+type exifReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type exifEntry struct {
+	typ    uint16
+	count  uint32
+	value  []byte
+	reader *exifReader
+}
+
+func exifHighlights(data []byte) []string {
+	if len(data) < 8 {
+		return nil
+	}
+
+	reader := &exifReader{data: data, order: binary.LittleEndian}
+	if string(data[0:2]) == "MM" {
+		reader.order = binary.BigEndian
+	}
+
+	tags := reader.ifd(int(reader.order.Uint32(data[4:8])))
+	if pointer, ok := tags[exifTagExifIFD]; ok {
+		for tag, entry := range reader.ifd(int(pointer.uint())) {
+			tags[tag] = entry
+		}
+	}
+
+	var highlights []string
+
+	maker := tags[exifTagMake].string()
+	model := tags[exifTagModel].string()
+
+	// the model often repeats the make
+	camera := model
+	if !strings.HasPrefix(model, maker) {
+		camera = strings.TrimSpace(maker + " " + model)
+	}
+
+	if camera != "" {
+		highlights = append(highlights, "camera: "+camera)
+	}
+
+	if taken := tags[exifTagDateTimeOriginal].string(); taken != "" {
+		highlights = append(highlights, "taken: "+taken)
+	}
+
+	var exposure []string
+	if entry, ok := tags[exifTagExposureTime]; ok {
+		numerator, denominator := entry.rational()
+		if numerator > 0 && denominator > 0 {
+			if numerator < denominator {
+				exposure = append(exposure, fmt.Sprintf("1/%.0fs", float64(denominator)/float64(numerator)))
+			} else {
+				exposure = append(exposure, fmt.Sprintf("%.1fs", float64(numerator)/float64(denominator)))
+			}
+		}
+	}
+
+	if entry, ok := tags[exifTagFNumber]; ok {
+		numerator, denominator := entry.rational()
+		if denominator > 0 {
+			exposure = append(exposure, fmt.Sprintf("f/%.1f", float64(numerator)/float64(denominator)))
+		}
+	}
+
+	if entry, ok := tags[exifTagISO]; ok {
+		exposure = append(exposure, fmt.Sprintf("ISO %d", entry.uint()))
+	}
+
+	if entry, ok := tags[exifTagFocalLength]; ok {
+		numerator, denominator := entry.rational()
+		if denominator > 0 {
+			exposure = append(exposure, fmt.Sprintf("%.0fmm", float64(numerator)/float64(denominator)))
+		}
+	}
+
+	if len(exposure) > 0 {
+		highlights = append(highlights, "exposure: "+strings.Join(exposure, " "))
+	}
+
+	return highlights
+}
+
+// ifd reads the entries of the image file directory at offset, entries that
+// point outside of the data are left out.
+func (reader *exifReader) ifd(offset int) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if offset < 0 || offset > len(reader.data)-2 {
+		return entries
+	}
+
+	count := int(reader.order.Uint16(reader.data[offset:]))
+	for i := range count {
+		start := offset + 2 + i*12
+		if start+12 > len(reader.data) {
+			break
+		}
+
+		entry := exifEntry{
+			typ:    reader.order.Uint16(reader.data[start+2:]),
+			count:  reader.order.Uint32(reader.data[start+4:]),
+			reader: reader,
+		}
+
+		// the count is unsigned and can overflow an int with 32 bits
+		size64 := int64(exifTypeSize(entry.typ)) * int64(entry.count)
+		if size64 <= 0 || size64 > int64(len(reader.data)) {
+			continue
+		}
+
+		size := int(size64)
+
+		// values of up to 4 bytes are stored in the entry itself
+		valueOffset := start + 8
+		if size > 4 {
+			valueOffset = int(reader.order.Uint32(reader.data[start+8:]))
+		}
+
+		if valueOffset < 0 || valueOffset > len(reader.data) || size > len(reader.data)-valueOffset {
+			continue
+		}
+
+		entry.value = reader.data[valueOffset : valueOffset+size]
+		entries[reader.order.Uint16(reader.data[start:])] = entry
+	}
+
+	return entries
+}
+
+func exifTypeSize(typ uint16) int {
+	const (
+		typeByte     = 1
+		typeASCII    = 2
+		typeShort    = 3
+		typeLong     = 4
+		typeRational = 5
+	)
+
+	switch typ {
+	case typeByte, typeASCII:
+		return 1
+	case typeShort:
+		return 2
+	case typeLong:
+		return 4
+	case typeRational:
+		return 8
+	}
+
+	return 0
+}
+
+func (entry exifEntry) string() string {
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+func (entry exifEntry) uint() uint32 {
+	switch {
+	case len(entry.value) == 0:
+		return 0
+	case len(entry.value) == 1:
+		return uint32(entry.value[0])
+	case len(entry.value) < 4 || exifTypeSize(entry.typ) == 2:
+		return uint32(entry.reader.order.Uint16(entry.value))
+	default:
+		return entry.reader.order.Uint32(entry.value)
+	}
+}
+
+func (entry exifEntry) rational() (uint32, uint32) {
+	if len(entry.value) < 8 {
+		return 0, 0
+	}
+
+	return entry.reader.order.Uint32(entry.value), entry.reader.order.Uint32(entry.value[4:])
+}
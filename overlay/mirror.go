@@ -0,0 +1,286 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb/composite"
+	"github.com/jezek/xgb/damage"
+	"github.com/jezek/xgb/xproto"
+)
+
+// the mirrored window is captured at most this often, applications that
+// redraw continuously would otherwise keep us busy copying pixels
+const minMirrorInterval = 33 * time.Millisecond
+
+// windowMirror shows the contents of another window instead of an image.
+// The window is redirected offscreen with the composite extension, which
+// keeps its contents available even while it is covered, and the damage
+// extension tells us when they change.
+type windowMirror struct {
+	display *Window
+	target  xproto.Window
+	damage  damage.Damage
+
+	mu      sync.Mutex
+	pixmap  xproto.Pixmap
+	resized bool
+	changed chan struct{}
+	closed  bool
+}
+
+// findWindow finds a window by its id (decimal or hex with 0x prefix), or
+// by a part of its title or its class.
+func (display *Window) findWindow(spec string) (xproto.Window, error) {
+	if id, err := strconv.ParseUint(spec, 0, 32); err == nil {
+		return xproto.Window(id), nil
+	}
+
+	clientList, err := display.atom("_NET_CLIENT_LIST")
+	if err != nil {
+		return 0, err
+	}
+
+	reply, err := xproto.GetProperty(display.conn, false, display.screen.Root, clientList, xproto.AtomWindow, 0, 1<<16).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("get client list: %w", err)
+	}
+
+	for i := 0; i+4 <= len(reply.Value); i += 4 {
+		window := xproto.Window(uint32(reply.Value[i]) | uint32(reply.Value[i+1])<<8 | uint32(reply.Value[i+2])<<16 | uint32(reply.Value[i+3])<<24)
+
+		for _, property := range []string{"_NET_WM_NAME", "WM_NAME", "WM_CLASS"} {
+			value, err := display.stringProperty(window, property)
+			if err != nil {
+				continue
+			}
+
+			// WM_CLASS holds the instance and the class name
+			for _, part := range strings.Split(value, "\x00") {
+				if part != "" && strings.Contains(strings.ToLower(part), strings.ToLower(spec)) {
+					return window, nil
+				}
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no window matches %q", spec)
+}
+
+func (display *Window) stringProperty(window xproto.Window, property string) (string, error) {
+	propertyAtom, err := display.atom(property)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := xproto.GetProperty(display.conn, false, window, propertyAtom, xproto.GetPropertyTypeAny, 0, 1<<16).Reply()
+	if err != nil {
+		return "", fmt.Errorf("get property %s: %w", property, err)
+	}
+
+	return string(reply.Value), nil
+}
+
+// startMirror captures target once, so that the overlay starts with its
+// size, and keeps capturing it whenever it changes.
+func (display *Window) startMirror(target xproto.Window) error {
+	err := composite.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init composite: %w", err)
+	}
+
+	// naming window pixmaps needs version 0.2
+	_, err = composite.QueryVersion(display.conn, 0, 4).Reply()
+	if err != nil {
+		return fmt.Errorf("query composite version: %w", err)
+	}
+
+	err = damage.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init damage: %w", err)
+	}
+
+	_, err = damage.QueryVersion(display.conn, 1, 1).Reply()
+	if err != nil {
+		return fmt.Errorf("query damage version: %w", err)
+	}
+
+	// automatic redirection keeps the window on screen as it was
+	err = composite.RedirectWindowChecked(display.conn, target, composite.RedirectAutomatic).Check()
+	if err != nil {
+		return fmt.Errorf("redirect window: %w", err)
+	}
+
+	damageID, err := damage.NewDamageId(display.conn)
+	if err != nil {
+		return fmt.Errorf("new damage id: %w", err)
+	}
+
+	err = damage.CreateChecked(display.conn, damageID, xproto.Drawable(target), damage.ReportLevelNonEmpty).Check()
+	if err != nil {
+		return fmt.Errorf("create damage: %w", err)
+	}
+
+	// resizes give the window a new pixmap and destroying it ends the mirror
+	err = xproto.ChangeWindowAttributesChecked(display.conn, target, xproto.CwEventMask, []uint32{xproto.EventMaskStructureNotify}).Check()
+	if err != nil {
+		return fmt.Errorf("select window events: %w", err)
+	}
+
+	mirror := &windowMirror{
+		display: display,
+		target:  target,
+		damage:  damageID,
+		resized: true,
+		changed: make(chan struct{}, 1),
+	}
+
+	err = mirror.capture()
+	if err != nil {
+		return err
+	}
+
+	display.mirror = mirror
+
+	display.wg.Add(1)
+	go mirror.run()
+
+	return nil
+}
+
+func (mirror *windowMirror) run() {
+	defer mirror.display.wg.Done()
+
+	for range mirror.changed {
+		err := mirror.capture()
+		if err != nil {
+			slog.Error("capture window", "err", err)
+			mirror.display.sourceFailed(fmt.Sprintf("window:0x%x", mirror.target), err)
+		}
+
+		// every capture requests a redraw, which is debounced, so capturing
+		// faster than the debounce would keep postponing it
+		time.Sleep(max(minMirrorInterval, 2*mirror.display.debounce(redrawDebounce)))
+	}
+}
+
+func (display *Window) isMirrored(window xproto.Window) bool {
+	return display.mirror != nil && display.mirror.target == window
+}
+
+// notify schedules a capture unless one is pending already.
+func (mirror *windowMirror) notify() {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	if mirror.closed {
+		return
+	}
+
+	select {
+	case mirror.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (mirror *windowMirror) damaged() {
+	// clear the damage so that the next change is reported again
+	damage.Subtract(mirror.display.conn, mirror.damage, 0, 0)
+
+	mirror.notify()
+}
+
+func (mirror *windowMirror) configured() {
+	mirror.mu.Lock()
+	mirror.resized = true
+	mirror.mu.Unlock()
+
+	mirror.notify()
+}
+
+// destroyed stops mirroring, the last captured contents stay visible.
+func (mirror *windowMirror) destroyed() {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	if !mirror.closed {
+		mirror.closed = true
+		close(mirror.changed)
+	}
+}
+
+func (mirror *windowMirror) capture() error {
+	conn := mirror.display.conn
+
+	mirror.mu.Lock()
+	resized := mirror.resized
+	mirror.resized = false
+	mirror.mu.Unlock()
+
+	// the pixmap of a window is replaced whenever it is resized
+	if resized {
+		if mirror.pixmap != 0 {
+			xproto.FreePixmap(conn, mirror.pixmap)
+		}
+
+		pixmap, err := xproto.NewPixmapId(conn)
+		if err != nil {
+			return fmt.Errorf("new pixmap id: %w", err)
+		}
+
+		err = composite.NameWindowPixmapChecked(conn, mirror.target, pixmap).Check()
+		if err != nil {
+			return fmt.Errorf("name window pixmap: %w", err)
+		}
+
+		mirror.pixmap = pixmap
+	}
+
+	geom, err := xproto.GetGeometry(conn, xproto.Drawable(mirror.pixmap)).Reply()
+	if err != nil {
+		return fmt.Errorf("get geometry: %w", err)
+	}
+
+	const allPlanes = 0xffffffff
+
+	reply, err := xproto.GetImage(
+		conn,
+		xproto.ImageFormatZPixmap,
+		xproto.Drawable(mirror.pixmap),
+		0,
+		0,
+		geom.Width,
+		geom.Height,
+		allPlanes,
+	).Reply()
+	if err != nil {
+		return fmt.Errorf("get image: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(geom.Width), int(geom.Height)))
+	if len(reply.Data) < len(img.Pix) {
+		return fmt.Errorf("get image: expected %d bytes, got %d", len(img.Pix), len(reply.Data))
+	}
+
+	copy(img.Pix, reply.Data)
+
+	// the pixels are bgra, swapping red and blue works both ways
+	rgbaToBGRA(img.Pix, mirror.display.options.RenderThreads)
+
+	// only windows with a 32 bit visual have an alpha channel, the others
+	// leave garbage in the fourth byte
+	if reply.Depth != depthWithAlpha {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	}
+
+	mirror.display.setLiveImage(fmt.Sprintf("window:0x%x", mirror.target), decodedImage{image: img})
+
+	return nil
+}
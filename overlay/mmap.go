@@ -0,0 +1,79 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"golang.org/x/sys/unix"
+)
+
+// files at least this large are mapped into memory instead of read
+const mapThreshold = 8 << 20
+
+// loadImage reads and decodes an image file, or stdin for "-", see
+// decodeImage for target. Stdin can't be read again in full, so its images
+// are always decoded at full size. Large files
+// are mapped, the decoders read through the pages and the kernel can drop
+// them again, instead of a copy of the whole file staying on the heap next
+// to the decoded pixels until the garbage collector gets to it.
+func loadImage(filename string, animate bool, target image.Point) (decodedImage, error) {
+	if filename == "-" {
+		imageBytes, err := readImage(filename)
+		if err != nil {
+			return decodedImage{}, err
+		}
+
+		return decodeImage(imageBytes, animate, image.Point{})
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return decodedImage{}, fmt.Errorf("open image file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return decodedImage{}, fmt.Errorf("stat image file: %w", err)
+	}
+
+	if stat.Size() < mapThreshold || !stat.Mode().IsRegular() {
+		imageBytes, err := io.ReadAll(file)
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("read image bytes from file: %w", err)
+		}
+
+		return decodeImage(imageBytes, animate, target)
+	}
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(stat.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return decodedImage{}, fmt.Errorf("map image file: %w", err)
+	}
+	defer unix.Munmap(data)
+
+	// the decoders only read sequentially
+	_ = unix.Madvise(data, unix.MADV_SEQUENTIAL)
+
+	return decodeMapped(data, animate, target)
+}
+
+// decodeMapped decodes a mapped file. Reading a page past the end of a file
+// that was truncated since it was mapped faults, which is turned into an
+// error instead of crashing, editors and exports rewrite files all the time.
+// Nothing that is returned may point into data, it is unmapped afterwards.
+func decodeMapped(data []byte, animate bool, target image.Point) (decoded decodedImage, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			decoded = decodedImage{}
+			err = fmt.Errorf("file changed while decoding: %v", recovered)
+		}
+	}()
+
+	return decodeImage(data, animate, target)
+}
@@ -0,0 +1,48 @@
+package overlay
+
+import (
+	"image"
+	"time"
+)
+
+// Problems that come up while the overlay runs, e.g. an edited config file
+// that doesn't parse, are shown at the top of the window for a while as well
+// as logged, the way the errors of sources are.
+
+// how long a notice stays
+const noticeDuration = 5 * time.Second
+
+// Notify shows message at the top of the window for a few seconds, in place
+// of the one shown before.
+func (display *Window) Notify(message string) {
+	panel := renderErrorPanel(message)
+
+	display.renderMu.Lock()
+	display.notice = panel
+	display.noticeSerial++
+	serial := display.noticeSerial
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	time.AfterFunc(noticeDuration, func() {
+		display.renderMu.Lock()
+		last := display.noticeSerial == serial
+		if last {
+			display.notice = nil
+		}
+		display.renderMu.Unlock()
+
+		if last {
+			display.requestRedraw()
+		}
+	})
+}
+
+// noticePanel returns the message of Notify, nil once it is gone.
+func (display *Window) noticePanel() *image.RGBA {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.notice
+}
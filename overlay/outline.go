@@ -0,0 +1,79 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// The outline of an image is drawn where the Sobel operator finds an edge
+// in its brightness, weighted by alpha so that the shapes of icons count
+// too, and is transparent everywhere else. Over a running UI thin opaque
+// lines are easier to follow than a translucent image in full color.
+
+const DefaultOutlineThreshold = 40
+
+// DefaultOutlineColor stands out on most interfaces.
+var DefaultOutlineColor = color.RGBA{0xff, 0, 0xff, 0xff}
+
+// outlineImage returns the edges of img in c, where the gradient is at
+// least threshold, from 1 to 255, or DefaultOutlineThreshold if it is 0.
+func outlineImage(img image.Image, c color.RGBA, threshold int) *image.RGBA {
+	if threshold <= 0 {
+		threshold = DefaultOutlineThreshold
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	// the pixels are premultiplied, so the luma is already weighted by alpha
+	luma := make([]int32, width*height)
+	for i := range luma {
+		pixel := src.Pix[i*4 : i*4+3]
+		luma[i] = (19595*int32(pixel[0]) + 38470*int32(pixel[1]) + 7471*int32(pixel[2]) + 1<<15) >> 16
+	}
+
+	at := func(x int, y int) int32 {
+		x = min(max(x, 0), width-1)
+		y = min(max(y, 0), height-1)
+		return luma[y*width+x]
+	}
+
+	// the gradient of the Sobel kernels is up to 4 times the largest step
+	limit := float64(threshold) * 4
+
+	outline := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+
+			if math.Hypot(float64(gx), float64(gy)) >= limit {
+				outline.SetRGBA(x, y, c)
+			}
+		}
+	}
+
+	return outline
+}
+
+// toggleOutline shows only the outline of the image, or the image again.
+func (display *Window) toggleOutline() {
+	filters := display.currentColorFilters()
+
+	if filters.Outline.A > 0 {
+		filters.Outline = color.RGBA{}
+	} else {
+		filters.Outline = display.options.Filters.Outline
+		if filters.Outline.A == 0 {
+			filters.Outline = DefaultOutlineColor
+		}
+	}
+
+	display.setColorFilters(filters)
+}
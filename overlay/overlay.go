@@ -0,0 +1,606 @@
+// Package overlay shows images in X11 windows that stay on top of other
+// windows, with adjustable transparency. It needs a compositor for the
+// transparency to work.
+//
+// A minimal overlay that shows an image until the user closes it:
+//
+//	window, err := overlay.New(overlay.WithImage(img), overlay.WithOpacity(0.5))
+//	if err != nil {
+//		return err
+//	}
+//	defer window.Close()
+//
+//	return window.Wait()
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"os"
+	"runtime"
+)
+
+// Option configures an overlay created with New.
+type Option func(*Options)
+
+// WithOptions replaces all options, e.g. with DefaultOptions that were
+// changed as needed.
+func WithOptions(options Options) Option {
+	return func(o *Options) {
+		*o = options
+	}
+}
+
+// WithImage shows img.
+func WithImage(img image.Image) Option {
+	return func(o *Options) {
+		o.Image = img
+	}
+}
+
+// WithImageFiles shows the first of paths, the others can be switched to
+// with the next-image and previous-image actions. "-" reads an image from
+// stdin.
+func WithImageFiles(paths ...string) Option {
+	return func(o *Options) {
+		o.Images = paths
+	}
+}
+
+// WithOpacity sets the initial opacity, from 0 to 1.
+func WithOpacity(opacity float64) Option {
+	return func(o *Options) {
+		o.InitialOpacity = opacity
+	}
+}
+
+// WithGeometry sets the initial position and size of the window.
+func WithGeometry(x int, y int, width int, height int) Option {
+	return func(o *Options) {
+		o.Geometry = Geometry{Width: width, Height: height, X: x, Y: y, HasPosition: true}
+	}
+}
+
+// DefaultOptions returns the options New starts with.
+func DefaultOptions() Options {
+	keys, err := ParseKeymap(DefaultBindings)
+	if err != nil {
+		panic(fmt.Sprintf("default key bindings: %s", err))
+	}
+
+	return Options{
+		InitialOpacity: 0.5,
+		Animate:        true,
+		Keymap:         keys,
+		NudgeStep:      1,
+		OpacityStep:    0.05,
+		OpacityMin:     0,
+		OpacityMax:     1,
+		RenderThreads:  runtime.GOMAXPROCS(0),
+		ColorBits:      8,
+		Quirks:         "auto",
+		Scale:          ScaleFit,
+		Align:          AlignCenter,
+		Filter:         FilterAuto,
+		Blend:          BlendNormal,
+		Transparency:   TransparencyAuto,
+		Layout:         LayoutNone,
+		Gap:            8,
+		StaleStyle:     StaleBadge,
+		RecordFormat:   RecordAPNG,
+		TextColor:      color.RGBA{0xff, 0xff, 0xff, 0xff},
+		FPS:            DefaultFPS,
+
+		WatchdogInterval: DefaultWatchdogInterval,
+	}
+}
+
+// EventKind tells what an Event is about.
+type EventKind int
+
+const (
+	// the window was shown or hidden
+	EventShown EventKind = iota + 1
+	EventHidden
+	// the window was resized, Width and Height are set
+	EventResized
+	// the opacity changed, Opacity is set
+	EventOpacity
+	// another image is shown, Source is set if it came from a file
+	EventImage
+	// the window was closed, no events follow
+	EventClosed
+	// a mouse button was pressed in the window, X and Y are set in window
+	// pixels and Button
+	EventClick
+)
+
+// Event reports a change of the overlay, e.g. by the user.
+type Event struct {
+	Kind    EventKind
+	Width   int
+	Height  int
+	Opacity float64
+	Source  string
+	X       int
+	Y       int
+	Button  int
+}
+
+// The errors New and Check fail with, wrapped, that scripts may want to
+// tell apart.
+var (
+	// ErrNoDisplay means that there is no X server to connect to, e.g.
+	// because $DISPLAY is not set.
+	ErrNoDisplay = errors.New("connect to X")
+	// ErrNoVisual means that the X server can't show true color windows.
+	ErrNoVisual = errors.New("no visual with required parameters found")
+	// ErrImage means that the image couldn't be read or decoded.
+	ErrImage = errors.New("load image")
+	// ErrShm means that the shared memory extension doesn't work, the
+	// pixels are sent over the connection then.
+	ErrShm = errors.New("shared memory")
+)
+
+// events are dropped instead of blocking the overlay while nobody reads them
+const eventBufferSize = 64
+
+// New opens a connection to the X server and shows an overlay window.
+// Closing the window closes the connection, along with the windows added
+// to it with Add.
+func New(opts ...Option) (*Window, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	display, err := connection.newWindow(options)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	display.ownsConnection = true
+
+	return display, nil
+}
+
+// Check does what New does up to showing the window: it loads the image,
+// connects to the X server and checks that it has a visual for the window
+// and working shared memory, unless it isn't used. Nothing is shown.
+func Check(opts ...Option) error {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := options.validate()
+	if err != nil {
+		return err
+	}
+
+	_, err = options.initialImage()
+	if err != nil {
+		return err
+	}
+
+	connection, err := NewConnection()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	display := &Window{options: options}
+
+	err = display.setupX(connection)
+	if err != nil {
+		return err
+	}
+
+	_, err = display.selectVisual()
+	if err != nil {
+		return err
+	}
+
+	if options.Remote || display.quirks.noShm {
+		return nil
+	}
+
+	// setupX already fell back to sending the pixels if the extension is
+	// missing
+	err = connection.initShm()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrShm, err)
+	}
+
+	segment, err := newShmSegment(connection.conn, os.Getpagesize())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrShm, err)
+	}
+
+	return segment.Close()
+}
+
+// validate reports options that contradict each other.
+func (options Options) validate() error {
+	if _, ok := windowTypes[options.Layer]; options.Layer != "" && !ok {
+		return fmt.Errorf("unknown layer %q, expected dock, overlay or normal", options.Layer)
+	}
+
+	if options.Output != "" && options.FullscreenOutput != "" {
+		return fmt.Errorf("only one of output and fullscreen output can be set")
+	}
+
+	if options.Follow != "" && (options.Output != "" || options.FullscreenOutput != "") {
+		return fmt.Errorf("a followed window can't be placed on an output")
+	}
+
+	if options.Rotate%90 != 0 {
+		return fmt.Errorf("rotation %d is not a multiple of 90 degrees", options.Rotate)
+	}
+
+	if options.Corners != nil && !convexQuad(options.Corners) {
+		return fmt.Errorf("the corners have to form a convex shape, in order around it")
+	}
+
+	return nil
+}
+
+func (options Options) initialImage() (decodedImage, error) {
+	switch {
+	case options.asynchronous():
+		// the mirrored window, the received image, the one of the plugin or
+		// the download replaces the spinner as soon as it is there
+		return loadingPlaceholder(options.placeholderSize(), options.waitingFor(), options.Animate), nil
+	case options.Image != nil:
+		return decodedImage{image: options.Image}, nil
+	case options.Clipboard != "":
+		imageBytes, err := readSelectionImage(options.Clipboard)
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("paste image: %w", err)
+		}
+
+		decoded, err := decodeImage(imageBytes, options.Animate, image.Point{})
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("paste image: %w", err)
+		}
+
+		return decoded, nil
+	case options.TestPattern != "":
+		// drawn again once the window has its size
+		size := image.Pt(options.Geometry.Width, options.Geometry.Height)
+		if size.X == 0 || size.Y == 0 {
+			size = image.Pt(640, 480)
+		}
+
+		return decodedImage{image: renderTestPattern(options.TestPattern, 0, size, defaultGammaLevel)}, nil
+	case options.Stream != nil:
+		// black until the first frame arrives, the window gets its size
+		format := options.StreamFormat
+		return decodedImage{image: image.NewRGBA(image.Rect(0, 0, format.Width, format.Height))}, nil
+	case options.board():
+		decoded, err := options.loadBoard()
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("%w: %w", ErrImage, err)
+		}
+
+		return decoded, nil
+	case len(options.Images) > 0:
+		// the window size is only known if it is given
+		var target image.Point
+		if options.reducible() && options.Geometry.Width > 0 && options.Geometry.Height > 0 {
+			target = image.Pt(options.Geometry.Width, options.Geometry.Height)
+		}
+
+		decoded, err := loadImage(options.Images[options.firstImage], options.Animate, target)
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("%w: %w", ErrImage, err)
+		}
+
+		return decoded, nil
+	default:
+		return decodedImage{}, fmt.Errorf("no image to show")
+	}
+}
+
+// start sets up everything that needs the connection, creates the window and
+// starts handling its events.
+func (display *Window) start() error {
+	options := display.options
+
+	if options.Follow != "" {
+		target, err := display.findWindow(options.Follow)
+		if err != nil {
+			return fmt.Errorf("find window: %w", err)
+		}
+
+		err = display.startFollow(target)
+		if err != nil {
+			return fmt.Errorf("follow window: %w", err)
+		}
+	}
+
+	if options.Output != "" || options.FullscreenOutput != "" {
+		name := options.Output
+		if options.FullscreenOutput != "" {
+			name = options.FullscreenOutput
+		}
+
+		err := display.startOutput(name, options.FullscreenOutput != "")
+		if err != nil {
+			return fmt.Errorf("place window on output: %w", err)
+		}
+	}
+
+	if options.Mirror != "" {
+		target, err := display.findWindow(options.Mirror)
+		if err != nil {
+			return fmt.Errorf("find window: %w", err)
+		}
+
+		err = display.startMirror(target)
+		if err != nil {
+			return fmt.Errorf("mirror window: %w", err)
+		}
+	}
+
+	err := display.createWindow()
+	if err != nil {
+		return fmt.Errorf("create window: %w", err)
+	}
+
+	if options.XRender {
+		err = display.setupXRender()
+		// the image is scaled on the cpu instead, like without the option
+		if err != nil {
+			slog.Warn("init xrender, falling back to the cpu", "err", err)
+		}
+	}
+
+	if len(options.PrivacyZones) > 0 {
+		err = display.setupPrivacy(options.PrivacyZones)
+		if err != nil {
+			return fmt.Errorf("setup privacy zones: %w", err)
+		}
+	}
+
+	if options.Split != SplitNone {
+		err = display.setupSplit()
+		if err != nil {
+			return fmt.Errorf("split window: %w", err)
+		}
+	}
+
+	err = display.grabGlobalKeys()
+	if err != nil {
+		return fmt.Errorf("grab global keys: %w", err)
+	}
+
+	if options.Blend != BlendNormal {
+		err = display.startBackdrop(display.ctx)
+		if err != nil {
+			return fmt.Errorf("capture screen: %w", err)
+		}
+	}
+
+	// the window got its size, maybe that of a monitor
+	if options.TestPattern != "" {
+		display.showPattern()
+	}
+
+	if options.Picker {
+		err = display.togglePicker()
+		if err != nil {
+			return fmt.Errorf("show color picker: %w", err)
+		}
+	}
+
+	if len(options.EffectPlugins) > 0 {
+		err = display.startEffectPlugins(options.EffectPlugins)
+		if err != nil {
+			return fmt.Errorf("start effect plugins: %w", err)
+		}
+	}
+
+	if len(options.SourcePlugin) > 0 {
+		err = display.startSourcePlugin(options.SourcePlugin)
+		if err != nil {
+			return fmt.Errorf("start source plugin: %w", err)
+		}
+	}
+
+	if options.Figma != nil {
+		display.startSource(*options.Figma)
+	}
+
+	if options.URL != nil {
+		display.startSource(*options.URL)
+	}
+
+	if options.Source != nil {
+		display.startSource(options.Source)
+	}
+
+	display.wg.Add(1)
+	go display.runEvents()
+
+	if options.Stream != nil {
+		display.startStream(options.Stream, options.StreamFormat)
+	}
+
+	if options.Receive != "" {
+		display.startReceiving(options.Receive)
+	}
+
+	if options.Sequence != "" {
+		err = display.startSequence(options.Sequence, options.FPS)
+		if err != nil {
+			return fmt.Errorf("start sequence: %w", err)
+		}
+	}
+
+	if options.Magnify > 0 {
+		err = display.startMagnifier(options.Magnify)
+		if err != nil {
+			return fmt.Errorf("start magnifier: %w", err)
+		}
+	}
+
+	for _, a := range options.Annotations {
+		display.Annotate(a)
+	}
+
+	if options.StaleAfter > 0 && options.live() {
+		display.startStaleCheck(options.StaleAfter)
+	}
+
+	if options.Idle > 0 {
+		err = display.startIdleWatch(options.Idle)
+		if err != nil {
+			return fmt.Errorf("watch idle time: %w", err)
+		}
+	}
+
+	if !options.AllowBlanking {
+		display.startKeepAwake()
+	}
+
+	if options.Watchdog != "" {
+		display.startWatchdog(options.Watchdog, options.WatchdogInterval)
+	}
+
+	// initial draw
+	display.requestRedraw()
+
+	return nil
+}
+
+func (display *Window) runEvents() {
+	defer display.wg.Done()
+
+	err := display.handleEvents()
+	// Close ends the connection, that is not an error
+	if display.ctx.Err() != nil {
+		err = nil
+	}
+
+	display.err = err
+	display.emit(Event{Kind: EventClosed})
+
+	display.eventsMu.Lock()
+	display.eventsClosed = true
+	close(display.events)
+	display.eventsMu.Unlock()
+
+	close(display.closed)
+}
+
+func (display *Window) emit(event Event) {
+	// everything that is reported is also sent to receivers
+	display.broadcastChanges()
+
+	display.eventsMu.Lock()
+	defer display.eventsMu.Unlock()
+
+	// images and opacity still change after the window is closed, e.g.
+	// when a watched file is written
+	if display.eventsClosed {
+		return
+	}
+
+	select {
+	case display.events <- event:
+	default:
+	}
+}
+
+// Events returns the changes of the overlay. The channel is closed after
+// EventClosed.
+func (display *Window) Events() <-chan Event {
+	return display.events
+}
+
+// Wait blocks until the window is closed, by the user or by Close.
+func (display *Window) Wait() error {
+	<-display.closed
+
+	return display.err
+}
+
+// SetImage shows img instead of the current image.
+func (display *Window) SetImage(img image.Image) {
+	display.setImage("", decodedImage{image: img})
+}
+
+// Reload loads the image that is shown again from its file, all images of
+// a Layout or the frames of a Sequence that changed.
+func (display *Window) Reload() error {
+	display.renderMu.Lock()
+	source := display.source
+	display.renderMu.Unlock()
+
+	if display.options.board() {
+		return display.ReloadImage(display.options.Images[0])
+	}
+
+	if display.sequence != nil {
+		display.RescanSequence()
+		return nil
+	}
+
+	// stdin, windows, webhooks and images set by SetImage
+	info, err := os.Stat(source)
+	if source == "-" || err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("the image was not loaded from a file")
+	}
+
+	return display.loadImageFile(source)
+}
+
+// SetOpacity changes the opacity, from 0 to 1. It fades to the new opacity if
+// Options.Fade is set. The other members of Options.Group follow.
+func (display *Window) SetOpacity(opacity float64) {
+	display.fadeOpacity(opacity)
+	display.shareOpacity()
+}
+
+// Opacity returns the opacity, or the one a running fade ends at.
+func (display *Window) Opacity() float64 {
+	return display.opacity()
+}
+
+// SetGeometry moves and resizes the window.
+func (display *Window) SetGeometry(x int, y int, width int, height int) error {
+	err := display.moveWindow(x, y)
+	if err != nil {
+		return err
+	}
+
+	return display.resizeWindow(width, height)
+}
+
+// SetVisible shows or hides the window, along with the other members of
+// Options.Group.
+func (display *Window) SetVisible(visible bool) error {
+	err := display.setVisible(visible)
+	if err != nil {
+		return err
+	}
+
+	display.shareVisible(visible)
+
+	return nil
+}
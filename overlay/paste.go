@@ -0,0 +1,229 @@
+package overlay
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// image targets we ask the owner of a selection for, in order of preference
+var pasteTargets = []string{"image/png", "image/jpeg", "image/gif", "image/bmp", "image/webp", "image/svg+xml"}
+
+// how long the owner of a selection has to answer
+const selectionTimeout = 5 * time.Second
+
+// readSelectionImage returns the image in the selection, e.g. CLIPBOARD or
+// PRIMARY, as it is encoded by its owner. It uses a connection of its own
+// and is done before the window exists.
+func readSelectionImage(selectionName string) ([]byte, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDisplay, err)
+	}
+	defer conn.Close()
+
+	intern := func(name string) (xproto.Atom, error) {
+		reply, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+		if err != nil {
+			return 0, fmt.Errorf("intern atom %s: %w", name, err)
+		}
+
+		return reply.Atom, nil
+	}
+
+	selection, err := intern(selectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := xproto.GetSelectionOwner(conn, selection).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get selection owner: %w", err)
+	}
+
+	if owner.Owner == xproto.WindowNone {
+		return nil, fmt.Errorf("%s is empty", selectionName)
+	}
+
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+
+	window, err := xproto.NewWindowId(conn)
+	if err != nil {
+		return nil, fmt.Errorf("new window id: %w", err)
+	}
+
+	// the owner hands out the contents by setting a property on a window
+	// of ours, property events tell when the chunks of INCR transfers are
+	// there
+	err = xproto.CreateWindowChecked(conn, 0, window, screen.Root, 0, 0, 1, 1, 0, xproto.WindowClassInputOnly, screen.RootVisual,
+		xproto.CwEventMask, []uint32{xproto.EventMaskPropertyChange}).Check()
+	if err != nil {
+		return nil, fmt.Errorf("create window: %w", err)
+	}
+
+	events := make(chan xgb.Event)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(events)
+
+		for {
+			event, err := conn.WaitForEvent()
+			if event == nil && err == nil {
+				return
+			}
+
+			if event == nil {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	reader := selectionReader{conn: conn, window: window, selection: selection, events: events}
+
+	reader.property, err = intern("XOVERLAY_SELECTION")
+	if err != nil {
+		return nil, err
+	}
+
+	reader.incr, err = intern("INCR")
+	if err != nil {
+		return nil, err
+	}
+
+	targetsAtom, err := intern("TARGETS")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := reader.convert(targetsAtom)
+	if err != nil {
+		return nil, fmt.Errorf("list targets: %w", err)
+	}
+
+	var offered []xproto.Atom
+	for i := 0; i+4 <= len(data); i += 4 {
+		offered = append(offered, xproto.Atom(xgb.Get32(data[i:])))
+	}
+
+	for _, name := range pasteTargets {
+		target, err := intern(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(offered, target) {
+			return reader.convert(target)
+		}
+	}
+
+	return nil, fmt.Errorf("%s holds no image", selectionName)
+}
+
+// selectionReader asks the owner of a selection for its contents.
+type selectionReader struct {
+	conn      *xgb.Conn
+	window    xproto.Window
+	selection xproto.Atom
+	property  xproto.Atom
+	incr      xproto.Atom
+	events    <-chan xgb.Event
+}
+
+// wait returns the first event that match accepts.
+func (reader selectionReader) wait(match func(xgb.Event) bool) (xgb.Event, error) {
+	timeout := time.After(selectionTimeout)
+
+	for {
+		select {
+		case event, ok := <-reader.events:
+			if !ok {
+				return nil, fmt.Errorf("connection closed")
+			}
+
+			if match(event) {
+				return event, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("the owner of the selection didn't answer")
+		}
+	}
+}
+
+// convert returns the selection converted to target, in one piece or in
+// chunks with the INCR protocol if it is large.
+func (reader selectionReader) convert(target xproto.Atom) ([]byte, error) {
+	err := xproto.ConvertSelectionChecked(reader.conn, reader.window, reader.selection, target, reader.property, xproto.TimeCurrentTime).Check()
+	if err != nil {
+		return nil, fmt.Errorf("convert selection: %w", err)
+	}
+
+	event, err := reader.wait(func(event xgb.Event) bool {
+		notify, ok := event.(xproto.SelectionNotifyEvent)
+		return ok && notify.Requestor == reader.window
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if event.(xproto.SelectionNotifyEvent).Property == xproto.AtomNone {
+		return nil, fmt.Errorf("the owner of the selection refused to convert it")
+	}
+
+	kind, data, err := reader.take()
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != reader.incr {
+		return data, nil
+	}
+
+	// deleting the property by taking it started the transfer, every chunk
+	// is set as a new value of it until an empty one ends it
+	data = nil
+
+	for {
+		_, err := reader.wait(func(event xgb.Event) bool {
+			notify, ok := event.(xproto.PropertyNotifyEvent)
+			return ok && notify.Window == reader.window && notify.Atom == reader.property && notify.State == xproto.PropertyNewValue
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, chunk, err := reader.take()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chunk) == 0 {
+			return data, nil
+		}
+
+		data = append(data, chunk...)
+	}
+}
+
+// take reads and deletes the property the owner set.
+func (reader selectionReader) take() (xproto.Atom, []byte, error) {
+	// in 4 byte units, everything there is
+	const maxLength = 1<<32/4 - 1
+
+	reply, err := xproto.GetProperty(reader.conn, true, reader.window, reader.property, xproto.GetPropertyTypeAny, 0, maxLength).Reply()
+	if err != nil {
+		return 0, nil, fmt.Errorf("get property: %w", err)
+	}
+
+	return reply.Type, reply.Value, nil
+}
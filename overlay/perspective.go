@@ -0,0 +1,340 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// corner handles are drawn this large and can be grabbed a bit outside
+	// of them
+	handleSize  = 9
+	handleReach = 12
+)
+
+// ParseCorners parses the window positions of the top left, top right,
+// bottom right and bottom left corner of the image, of the form
+// "x1,y1,x2,y2,x3,y3,x4,y4".
+func ParseCorners(value string) ([]image.Point, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 8 {
+		return nil, fmt.Errorf("corners %q: expected x1,y1,x2,y2,x3,y3,x4,y4", value)
+	}
+
+	corners := make([]image.Point, 4)
+	for i, part := range parts {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("corners %q: %w", value, err)
+		}
+
+		if i%2 == 0 {
+			corners[i/2].X = number
+		} else {
+			corners[i/2].Y = number
+		}
+	}
+
+	if !convexQuad(corners) {
+		return nil, fmt.Errorf("corners %q: the corners have to form a convex shape, in order around it", value)
+	}
+
+	return corners, nil
+}
+
+func formatCorners(corners []image.Point) string {
+	parts := make([]string, 0, 2*len(corners))
+	for _, corner := range corners {
+		parts = append(parts, strconv.Itoa(corner.X), strconv.Itoa(corner.Y))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// convexQuad reports whether the four corners go around a convex shape,
+// clockwise or counterclockwise. Other shapes have no sensible projection.
+func convexQuad(corners []image.Point) bool {
+	if len(corners) != 4 {
+		return false
+	}
+
+	sign := 0
+	for i := range corners {
+		a := corners[i]
+		b := corners[(i+1)%4]
+		c := corners[(i+2)%4]
+
+		cross := (b.X-a.X)*(c.Y-b.Y) - (b.Y-a.Y)*(c.X-b.X)
+		switch {
+		case cross == 0:
+			return false
+		case sign == 0:
+			sign = cross
+		case (cross > 0) != (sign > 0):
+			return false
+		}
+	}
+
+	return true
+}
+
+// cornerBounds returns the rectangle around the corners.
+func cornerBounds(corners []image.Point) image.Rectangle {
+	bounds := image.Rectangle{Min: corners[0], Max: corners[0]}
+	for _, corner := range corners[1:] {
+		bounds.Min.X = min(bounds.Min.X, corner.X)
+		bounds.Min.Y = min(bounds.Min.Y, corner.Y)
+		bounds.Max.X = max(bounds.Max.X, corner.X)
+		bounds.Max.Y = max(bounds.Max.Y, corner.Y)
+	}
+
+	return bounds
+}
+
+// projection maps window positions back into the unit square the image
+// covers, as the rows of a 3x3 matrix in homogeneous coordinates.
+type projection [9]float64
+
+// newProjection inverts the projective mapping of the unit square onto the
+// corners, following Heckbert's "Fundamentals of Texture Mapping".
+func newProjection(corners []image.Point) projection {
+	x0, y0 := float64(corners[0].X), float64(corners[0].Y)
+	x1, y1 := float64(corners[1].X), float64(corners[1].Y)
+	x2, y2 := float64(corners[2].X), float64(corners[2].Y)
+	x3, y3 := float64(corners[3].X), float64(corners[3].Y)
+
+	dx1, dy1 := x1-x2, y1-y2
+	dx2, dy2 := x3-x2, y3-y2
+	dx3, dy3 := x0-x1+x2-x3, y0-y1+y2-y3
+
+	// parallelograms have g = h = 0 and are affine
+	den := dx1*dy2 - dx2*dy1
+	g := (dx3*dy2 - dx2*dy3) / den
+	h := (dx1*dy3 - dx3*dy1) / den
+
+	a, b, c := x1-x0+g*x1, x3-x0+h*x3, x0
+	d, e, f := y1-y0+g*y1, y3-y0+h*y3, y0
+
+	// the adjugate is the inverse up to a factor, which cancels out in the
+	// division by the homogeneous coordinate
+	return projection{
+		e - f*h, c*h - b, b*f - c*e,
+		f*g - d, a - c*g, c*d - a*f,
+		d*h - e*g, b*g - a*h, a*e - b*d,
+	}
+}
+
+// apply returns the position in the unit square that ends up at x, y, and
+// whether it is inside of it.
+func (p projection) apply(x float64, y float64) (float64, float64, bool) {
+	w := p[6]*x + p[7]*y + p[8]
+	if w == 0 {
+		return 0, 0, false
+	}
+
+	u := (p[0]*x + p[1]*y + p[2]) / w
+	v := (p[3]*x + p[4]*y + p[5]) / w
+
+	return u, v, u >= 0 && u <= 1 && v >= 0 && v <= 1
+}
+
+// warpSource is the image scaled to about the size it is warped to, so that
+// sampling it bilinearly doesn't alias. It is only scaled again when the
+// image or the size changes.
+type warpSource struct {
+	image  image.Image
+	size   image.Point
+	filter ScaleFilter
+	scaled *image.RGBA
+}
+
+func (source *warpSource) get(img image.Image, size image.Point, filter ScaleFilter) *image.RGBA {
+	size = image.Pt(max(1, size.X), max(1, size.Y))
+	if source.scaled != nil && source.image == img && source.size == size && source.filter == filter {
+		return source.scaled
+	}
+
+	scaled := image.NewRGBA(image.Rectangle{Max: size})
+	filter.scaler().Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	*source = warpSource{image: img, size: size, filter: filter, scaled: scaled}
+
+	return scaled
+}
+
+// warpImage projects src onto the corners, given in the width by height
+// pixels of dst, and writes the result in the BGRA byte order of the X
+// server with opacity applied. Everything outside of the corners is
+// transparent.
+func warpImage(dst []byte, width int, height int, src *image.RGBA, corners []image.Point, opacity float64, threads int) {
+	const fullAlpha = 255
+	alpha := uint32(fullAlpha * opacity)
+
+	p := newProjection(corners)
+	srcWidth := src.Bounds().Dx()
+	srcHeight := src.Bounds().Dy()
+	rowSize := width * 4
+
+	forEachRowChunk(height, rowSize, threads, func(startRow int, endRow int) {
+		for y := startRow; y < endRow; y++ {
+			row := dst[y*rowSize : (y+1)*rowSize]
+			clear(row)
+
+			for x := range width {
+				u, v, inside := p.apply(float64(x)+0.5, float64(y)+0.5)
+				if !inside {
+					continue
+				}
+
+				pixel := sampleBilinear(src, u*float64(srcWidth)-0.5, v*float64(srcHeight)-0.5)
+
+				i := x * 4
+				row[i+0] = byte(pixel[2] * alpha / fullAlpha)
+				row[i+1] = byte(pixel[1] * alpha / fullAlpha)
+				row[i+2] = byte(pixel[0] * alpha / fullAlpha)
+				row[i+3] = byte(pixel[3] * alpha / fullAlpha)
+			}
+		}
+	})
+}
+
+// sampleBilinear interpolates the premultiplied pixels of img around x, y,
+// which are relative to its top left corner. Positions beyond the edges
+// repeat the edge pixels.
+func sampleBilinear(img *image.RGBA, x float64, y float64) [4]uint32 {
+	bounds := img.Bounds()
+	clampX := func(v int) int { return min(bounds.Dx()-1, max(0, v)) }
+	clampY := func(v int) int { return min(bounds.Dy()-1, max(0, v)) }
+
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+	fx := uint32((x - float64(x0)) * 256)
+	fy := uint32((y - float64(y0)) * 256)
+
+	p00 := img.Pix[img.PixOffset(bounds.Min.X+clampX(x0), bounds.Min.Y+clampY(y0)):]
+	p10 := img.Pix[img.PixOffset(bounds.Min.X+clampX(x0+1), bounds.Min.Y+clampY(y0)):]
+	p01 := img.Pix[img.PixOffset(bounds.Min.X+clampX(x0), bounds.Min.Y+clampY(y0+1)):]
+	p11 := img.Pix[img.PixOffset(bounds.Min.X+clampX(x0+1), bounds.Min.Y+clampY(y0+1)):]
+
+	var pixel [4]uint32
+	for c := range pixel {
+		top := uint32(p00[c])*(256-fx) + uint32(p10[c])*fx
+		bottom := uint32(p01[c])*(256-fx) + uint32(p11[c])*fx
+		pixel[c] = (top*(256-fy) + bottom*fy) >> 16
+	}
+
+	return pixel
+}
+
+// drawCornerHandles draws a handle onto each corner of buf, which holds
+// width by height pixels in the byte order of X, the selected one filled
+// with the guide color. Like the info panel they are not affected by the
+// opacity.
+func drawCornerHandles(buf []byte, width int, height int, corners []image.Point, selected int) {
+	window := image.Rect(0, 0, width, height)
+
+	for i, corner := range corners {
+		fill := [3]byte{255, 255, 255}
+		if i == selected {
+			fill = [3]byte{guideColor.B, guideColor.G, guideColor.R}
+		}
+
+		handle := image.Rect(0, 0, handleSize, handleSize).Add(corner.Sub(image.Pt(handleSize/2, handleSize/2)))
+		area := handle.Intersect(window)
+
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				// a black outline keeps them visible on any image
+				pixel := fill
+				if x == handle.Min.X || x == handle.Max.X-1 || y == handle.Min.Y || y == handle.Max.Y-1 {
+					pixel = [3]byte{}
+				}
+
+				offset := (y*width + x) * 4
+				buf[offset+0], buf[offset+1], buf[offset+2], buf[offset+3] = pixel[0], pixel[1], pixel[2], 255
+			}
+		}
+	}
+}
+
+// toggleCorners starts or stops moving the corners of the image with the
+// mouse. Without corners yet, they start where the scale mode placed the
+// image. The corners are printed when done, to pass them to --corners, and
+// remembered for the image.
+func (display *Window) toggleCorners() {
+	display.renderMu.Lock()
+	display.editCorners = !display.editCorners
+	display.draggedCorner = -1
+
+	if display.editCorners && display.corners == nil {
+		display.corners = display.placedCorners()
+	}
+
+	editing := display.editCorners
+	corners := display.corners
+	source := display.source
+	display.renderMu.Unlock()
+
+	if !editing {
+		slog.Info("corners", "corners", formatCorners(corners))
+		display.rememberCorners(source, corners)
+	}
+
+	display.requestRedraw()
+}
+
+// startCornerDrag grabs the corner handle near x, y in the window, if there
+// is one. It reports whether corners are being edited, clicks elsewhere are
+// ignored then.
+func (display *Window) startCornerDrag(x int, y int) bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if !display.editCorners {
+		return false
+	}
+
+	for i, corner := range display.corners {
+		if abs(corner.X-x) <= handleReach && abs(corner.Y-y) <= handleReach {
+			display.draggedCorner = i
+			display.selectedCorner = i
+			break
+		}
+	}
+
+	return true
+}
+
+// dragCorner moves the grabbed corner to x, y. It reports whether a corner
+// is grabbed. Moves that would make the shape concave are left out.
+func (display *Window) dragCorner(x int, y int) bool {
+	display.renderMu.Lock()
+	if display.draggedCorner < 0 {
+		display.renderMu.Unlock()
+		return false
+	}
+
+	corners := append([]image.Point(nil), display.corners...)
+	corners[display.draggedCorner] = image.Pt(x, y)
+
+	if convexQuad(corners) {
+		display.corners = corners
+	}
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	return true
+}
+
+func (display *Window) endCornerDrag() {
+	display.renderMu.Lock()
+	display.draggedCorner = -1
+	display.renderMu.Unlock()
+}
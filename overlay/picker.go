@@ -0,0 +1,184 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// the readout is shown this far below and right of the pointer
+const pickerOffset = 16
+
+// picker is the color picker, which shows the pixel under the pointer of
+// the image and of the screen below the window. The readout is only put
+// together when rendering, pointer motion comes in much faster than that.
+type picker struct {
+	active bool
+	// pointer positions in the window and on the screen, hovering is false
+	// while the pointer is outside of the window
+	hovering bool
+	pointer  image.Point
+	root     image.Point
+
+	// what the last readout showed
+	imageColor  color.NRGBA
+	onImage     bool
+	screenColor color.NRGBA
+	onScreen    bool
+	panel       *image.RGBA
+}
+
+func hexColor(c color.NRGBA) string {
+	text := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	if c.A != 0xff {
+		text += fmt.Sprintf("%02x", c.A)
+	}
+
+	return text
+}
+
+// togglePicker shows or hides the color picker. The screen below the
+// window can only be read with the windows on it redirected.
+func (display *Window) togglePicker() error {
+	err := display.redirectWindows()
+	if err != nil {
+		slog.Error("read screen colors", "err", err)
+	}
+
+	pointer, err := xproto.QueryPointer(display.conn, display.windowID).Reply()
+	if err != nil {
+		return fmt.Errorf("query pointer: %w", err)
+	}
+
+	display.renderMu.Lock()
+	display.picker = picker{
+		active:   !display.picker.active,
+		hovering: image.Pt(int(pointer.WinX), int(pointer.WinY)).In(image.Rect(0, 0, display.windowWidth, display.windowHeight)),
+		pointer:  image.Pt(int(pointer.WinX), int(pointer.WinY)),
+		root:     image.Pt(int(pointer.RootX), int(pointer.RootY)),
+	}
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	return nil
+}
+
+func (display *Window) pickerActive() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.picker.active
+}
+
+// hover follows the pointer at x, y in the window and rootX, rootY on the
+// screen with the picker.
+func (display *Window) hover(x int, y int, rootX int, rootY int) {
+	display.renderMu.Lock()
+	if !display.picker.active {
+		display.renderMu.Unlock()
+		return
+	}
+
+	display.picker.hovering = true
+	display.picker.pointer = image.Pt(x, y)
+	display.picker.root = image.Pt(rootX, rootY)
+	display.picker.panel = nil
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// leave hides the readout once the pointer left the window.
+func (display *Window) leave() {
+	display.renderMu.Lock()
+	if !display.picker.active {
+		display.renderMu.Unlock()
+		return
+	}
+
+	display.picker.hovering = false
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// pickerPanel returns the readout for the current pointer position and where
+// it goes in the window, nil while the picker is hidden.
+func (display *Window) pickerPanel() (*image.RGBA, image.Point) {
+	display.renderMu.Lock()
+	current := display.picker
+	img := display.image
+	display.renderMu.Unlock()
+
+	if !current.active || !current.hovering {
+		return nil, image.Point{}
+	}
+
+	if current.panel != nil {
+		return current.panel, current.pointer
+	}
+
+	imageLine := "image   -"
+	point, onImage := display.imagePoint(current.pointer.X, current.pointer.Y)
+	if onImage {
+		current.imageColor = color.NRGBAModel.Convert(img.At(point.X, point.Y)).(color.NRGBA)
+		imageLine = fmt.Sprintf("image   %d,%d  %s", point.X, point.Y, hexColor(current.imageColor))
+	}
+
+	screenLine := "screen  -"
+	pix, err := display.captureBelow(image.Rectangle{Min: current.root, Max: current.root.Add(image.Pt(1, 1))})
+	if err == nil {
+		// captured in the byte order of X
+		current.screenColor = color.NRGBA{pix[2], pix[1], pix[0], 0xff}
+		screenLine = fmt.Sprintf("screen  %d,%d  %s", current.root.X, current.root.Y, hexColor(current.screenColor))
+	}
+
+	current.onImage = onImage
+	current.onScreen = err == nil
+	current.panel = renderInfoPanel([]string{imageLine, screenLine})
+
+	display.renderMu.Lock()
+	// the pointer moved on while we were reading the screen
+	if display.picker.active && display.picker.pointer == current.pointer {
+		display.picker = current
+	}
+	display.renderMu.Unlock()
+
+	return current.panel, current.pointer
+}
+
+// pickerOrigin places the readout next to the pointer, on the other side of
+// it where it would not fit into the width by height pixels of the buffer.
+func pickerOrigin(pointer image.Point, panel image.Rectangle, width int, height int) image.Point {
+	origin := pointer.Add(image.Pt(pickerOffset, pickerOffset))
+	if origin.X+panel.Dx() > width {
+		origin.X = pointer.X - pickerOffset - panel.Dx()
+	}
+
+	if origin.Y+panel.Dy() > height {
+		origin.Y = pointer.Y - pickerOffset - panel.Dy()
+	}
+
+	return origin
+}
+
+// copyPicked copies the color of the image the picker shows, or the one of
+// the screen below.
+func (display *Window) copyPicked(screen bool) error {
+	display.renderMu.Lock()
+	current := display.picker
+	display.renderMu.Unlock()
+
+	switch {
+	case screen && current.onScreen:
+		return display.copyToClipboard(clipboardText(hexColor(current.screenColor)))
+	case !screen && current.onImage:
+		return display.copyToClipboard(clipboardText(hexColor(current.imageColor)))
+	}
+
+	return fmt.Errorf("no color under the pointer")
+}
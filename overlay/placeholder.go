@@ -0,0 +1,318 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Sources that deliver their images later, e.g. downloads or plugins, show
+// a spinner until the first one arrived and a broken image with the error if
+// it doesn't. Once an image was shown, errors are drawn over it instead of
+// replacing it.
+
+const (
+	// the size of placeholders if the window size is not given
+	placeholderWidth  = 320
+	placeholderHeight = 200
+
+	spinnerDots  = 12
+	spinnerDelay = 80 * time.Millisecond
+
+	// errors are cut to this many lines of this many characters
+	errorColumns = 60
+	errorLines   = 3
+)
+
+var (
+	placeholderBackground = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	placeholderForeground = color.RGBA{0xd0, 0xd0, 0xd0, 0xff}
+	errorColor            = color.RGBA{0xe0, 0x40, 0x40, 0xff}
+)
+
+// asynchronous reports whether the image arrives after the window is shown.
+func (options Options) asynchronous() bool {
+	return options.Mirror != "" || options.Receive != "" || len(options.SourcePlugin) > 0 || options.Figma != nil || options.URL != nil || options.Source != nil || options.Sequence != "" || options.Magnify > 0
+}
+
+// waitingFor describes what an asynchronous source waits for.
+func (options Options) waitingFor() string {
+	switch {
+	case options.Mirror != "":
+		return "waiting for window " + options.Mirror
+	case options.Receive != "":
+		return "waiting for " + options.Receive
+	case len(options.SourcePlugin) > 0:
+		return "waiting for " + filepath.Base(options.SourcePlugin[0])
+	case options.Figma != nil:
+		return "exporting " + options.Figma.Name()
+	case options.URL != nil:
+		return "loading " + options.URL.Name()
+	case options.Source != nil:
+		return "waiting for " + options.Source.Name()
+	case options.Sequence != "":
+		return "waiting for " + filepath.Base(options.Sequence)
+	case options.Magnify > 0:
+		return "magnifying"
+	}
+
+	return "loading"
+}
+
+func (options Options) placeholderSize() image.Point {
+	if options.Geometry.Width > 0 && options.Geometry.Height > 0 {
+		return image.Pt(options.Geometry.Width, options.Geometry.Height)
+	}
+
+	return image.Pt(placeholderWidth, placeholderHeight)
+}
+
+// sourceFailed shows that the source of the image failed, instead of the
+// spinner if nothing arrived yet and over the last image otherwise. The
+// next image that arrives replaces it.
+func (display *Window) sourceFailed(source string, err error) {
+	display.renderMu.Lock()
+	placeholder := display.placeholder
+	size := display.image.Bounds().Size()
+	display.renderMu.Unlock()
+
+	if placeholder {
+		display.showPlaceholder(source, errorPlaceholder(size, err.Error()))
+		return
+	}
+
+	panel := renderErrorPanel(err.Error())
+
+	display.renderMu.Lock()
+	display.errorPanel = panel
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// sourceErrorPanel returns the error drawn over the image, nil if there is
+// none.
+func (display *Window) sourceErrorPanel() *image.RGBA {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.errorPanel
+}
+
+// loadingPlaceholder returns a spinner above label that turns forever, or
+// stands still without animations.
+func loadingPlaceholder(size image.Point, label string, animate bool) decodedImage {
+	if !animate {
+		return decodedImage{image: renderSpinner(size, label, 0)}
+	}
+
+	frames := make([]animationFrame, spinnerDots)
+	for i := range frames {
+		frames[i] = animationFrame{image: renderSpinner(size, label, i), delay: spinnerDelay}
+	}
+
+	return decodedImage{image: frames[0].image, frames: frames}
+}
+
+// renderSpinner draws a ring of dots that fade out behind the one at step.
+func renderSpinner(size image.Point, label string, step int) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(img, img.Bounds(), image.NewUniform(placeholderBackground), image.Point{}, draw.Src)
+
+	radius := float64(min(size.X, size.Y)) / 6
+	dotRadius := max(1.5, radius/5)
+	center := image.Pt(size.X/2, size.Y/2-basicfont.Face7x13.Height)
+
+	for i := range spinnerDots {
+		angle := 2*math.Pi*float64(i)/spinnerDots - math.Pi/2
+		dot := image.Pt(center.X+int(math.Round(radius*math.Cos(angle))), center.Y+int(math.Round(radius*math.Sin(angle))))
+
+		// the dot at step is brightest, the ones before it trail off
+		age := (step - i + spinnerDots) % spinnerDots
+		brightness := 1 - float64(age)/spinnerDots
+
+		fillCircle(img, dot, dotRadius, blendColor(placeholderBackground, placeholderForeground, brightness))
+	}
+
+	line := int(radius) + center.Y + basicfont.Face7x13.Height
+	drawLabel(img, image.Rect(0, line, size.X, line+labelHeight), label)
+
+	return img
+}
+
+// errorPlaceholder returns a broken image above message.
+func errorPlaceholder(size image.Point, message string) decodedImage {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(img, img.Bounds(), image.NewUniform(placeholderBackground), image.Point{}, draw.Src)
+
+	lines := wrapText(message, min(errorColumns, max(1, size.X/basicfont.Face7x13.Advance-2)), errorLines)
+
+	glyph := min(size.X, size.Y) / 4
+	top := (size.Y - glyph - len(lines)*labelHeight) / 2
+	drawBrokenImage(img, image.Rect((size.X-glyph)/2, top, (size.X+glyph)/2, top+glyph), errorColor)
+
+	for i, line := range lines {
+		y := top + glyph + labelPadding + i*labelHeight
+		drawLabel(img, image.Rect(0, y, size.X, y+labelHeight), line)
+	}
+
+	return decodedImage{image: img}
+}
+
+// renderErrorPanel returns a small broken image next to message, drawn over
+// the last image when its source failed later on.
+func renderErrorPanel(message string) *image.RGBA {
+	face := basicfont.Face7x13
+	lines := wrapText(message, errorColumns, errorLines)
+
+	columns := 0
+	for _, line := range lines {
+		columns = max(columns, len([]rune(line)))
+	}
+
+	glyph := face.Height
+	panel := image.NewRGBA(image.Rect(0, 0, glyph+columns*face.Advance+3*labelPadding, max(glyph+2*labelPadding, len(lines)*labelHeight)))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	drawBrokenImage(panel, image.Rect(labelPadding, labelPadding, labelPadding+glyph, labelPadding+glyph), errorColor)
+
+	drawer := font.Drawer{
+		Dst:  panel,
+		Src:  image.White,
+		Face: face,
+	}
+
+	for i, line := range lines {
+		drawer.Dot = fixed.P(glyph+2*labelPadding, labelPadding+i*labelHeight+face.Ascent)
+		drawer.DrawString(line)
+	}
+
+	return panel
+}
+
+// drawBrokenImage draws the outline of a picture, with a mountain and a sun,
+// torn apart along a zigzag line in r.
+func drawBrokenImage(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	width := float64(r.Dx())
+	height := float64(r.Dy())
+	stroke := max(1, width/12)
+
+	inPicture := func(x float64, y float64) bool {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return false
+		}
+
+		if x < stroke || y < stroke || x >= width-stroke || y >= height-stroke {
+			return true
+		}
+
+		sunX, sunY := x-0.7*width, y-0.3*height
+		if sunX*sunX+sunY*sunY < 0.01*width*width {
+			return true
+		}
+
+		// a triangle standing on the bottom edge
+		return y >= 0.45*height+math.Abs(x-0.4*width)*1.4 && y < height-stroke
+	}
+
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			x := float64(px-r.Min.X) + 0.5
+			y := float64(py-r.Min.Y) + 0.5
+
+			// the tear runs from top to bottom a little right of the
+			// middle, the right part slipped down
+			period := max(2, height/4)
+			phase := math.Mod(y, period) / period
+			tear := 0.55*width + (math.Abs(phase-0.5)-0.25)*width/4
+
+			switch {
+			case math.Abs(x-tear) < stroke/2:
+				continue
+			case x > tear:
+				y -= stroke
+			}
+
+			if inPicture(x, y) {
+				img.SetRGBA(px, py, c)
+			}
+		}
+	}
+}
+
+// fillCircle fills a circle with antialiased edges.
+func fillCircle(img *image.RGBA, center image.Point, radius float64, c color.RGBA) {
+	area := image.Rect(
+		center.X-int(radius)-1, center.Y-int(radius)-1,
+		center.X+int(radius)+2, center.Y+int(radius)+2,
+	).Intersect(img.Bounds())
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dx := float64(x-center.X) + 0.5
+			dy := float64(y-center.Y) + 0.5
+			coverage := min(1, max(0, radius-math.Sqrt(dx*dx+dy*dy)+0.5))
+			if coverage == 0 {
+				continue
+			}
+
+			img.SetRGBA(x, y, blendColor(img.RGBAAt(x, y), c, coverage))
+		}
+	}
+}
+
+// blendColor mixes two opaque colors, t is the share of to.
+func blendColor(from color.RGBA, to color.RGBA, t float64) color.RGBA {
+	mix := func(a uint8, b uint8) uint8 {
+		return uint8(math.Round(float64(a)*(1-t) + float64(b)*t))
+	}
+
+	return color.RGBA{mix(from.R, to.R), mix(from.G, to.G), mix(from.B, to.B), mix(from.A, to.A)}
+}
+
+// wrapText breaks text into at most maxLines lines of at most columns
+// characters at spaces, words that are too long are cut. The last line ends
+// with dots if there is more.
+func wrapText(text string, columns int, maxLines int) []string {
+	var lines []string
+	line := []rune{}
+
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+
+		for len(runes) > 0 {
+			if len(line) > 0 && len(line)+1+len(runes) > columns {
+				lines = append(lines, string(line))
+				line = line[:0:0]
+			}
+
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+
+			take := min(len(runes), columns-len(line))
+			line = append(line, runes[:take]...)
+			runes = runes[take:]
+		}
+	}
+
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+
+	if len(lines) > maxLines {
+		last := []rune(lines[maxLines-1])
+		last = append(last[:min(len(last), max(0, columns-3))], []rune("...")...)
+		lines = append(lines[:maxLines-1], string(last))
+	}
+
+	return lines
+}
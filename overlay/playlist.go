@@ -0,0 +1,144 @@
+package overlay
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A playlist is a slideshow with a line per image:
+//
+//	# path      duration  opacity  transition
+//	intro.png   10s       1        cut
+//	"step 1.png" 4s       0.6      800ms
+//	step2.png
+//
+// Everything after the path is optional, and "-" keeps the default of the
+// slideshow: its interval, the opacity the window has and its crossfade.
+// The transition is how the image is faded in, "cut", "crossfade" or the
+// duration of the crossfade. Relative paths are relative to the playlist.
+
+// Slide is an image of a playlist and how it is shown.
+type Slide struct {
+	Path string
+	// how long it is shown, Slideshow.Interval if zero
+	Duration time.Duration
+	// the opacity it is shown at, the one of the window if nil
+	Opacity *float64
+	// how long the crossfade to it takes, Slideshow.Crossfade if nil
+	Crossfade *time.Duration
+}
+
+// LoadPlaylist reads the slides of the playlist at path.
+func LoadPlaylist(path string) ([]Slide, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open playlist: %w", err)
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(path)
+
+	var slides []Slide
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		slide, err := parseSlide(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+
+		if !filepath.IsAbs(slide.Path) {
+			slide.Path = filepath.Join(dir, slide.Path)
+		}
+
+		slides = append(slides, slide)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+
+	if len(slides) == 0 {
+		return nil, fmt.Errorf("%s: no images", path)
+	}
+
+	return slides, nil
+}
+
+// parseSlide parses a line of a playlist, the path can be quoted if it has
+// spaces in it.
+func parseSlide(line string) (Slide, error) {
+	var slide Slide
+
+	end := strings.IndexFunc(line, unicode.IsSpace)
+	if end < 0 {
+		end = len(line)
+	}
+
+	path, rest := line[:end], line[end:]
+	if strings.HasPrefix(line, `"`) {
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return slide, fmt.Errorf("path: %w", err)
+		}
+
+		path, _ = strconv.Unquote(quoted)
+		rest = line[len(quoted):]
+	}
+
+	slide.Path = path
+
+	fields := strings.Fields(rest)
+	if len(fields) > 3 {
+		return slide, fmt.Errorf("expected a duration, opacity and transition after the path, got %d fields", len(fields))
+	}
+
+	// the fields that are left out keep the defaults
+	fields = append(fields, "-", "-", "-")
+
+	if fields[0] != "-" {
+		duration, err := time.ParseDuration(fields[0])
+		if err != nil || duration <= 0 {
+			return slide, fmt.Errorf("invalid duration %q", fields[0])
+		}
+
+		slide.Duration = duration
+	}
+
+	if fields[1] != "-" {
+		opacity, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			return slide, fmt.Errorf("invalid opacity %q, expected 0 to 1", fields[1])
+		}
+
+		slide.Opacity = &opacity
+	}
+
+	switch fields[2] {
+	case "-", "crossfade":
+	case "cut":
+		cut := time.Duration(0)
+		slide.Crossfade = &cut
+	default:
+		crossfade, err := time.ParseDuration(fields[2])
+		if err != nil || crossfade < 0 {
+			return slide, fmt.Errorf("invalid transition %q, expected cut, crossfade or its duration", fields[2])
+		}
+
+		slide.Crossfade = &crossfade
+	}
+
+	return slide, nil
+}
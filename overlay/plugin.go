@@ -0,0 +1,291 @@
+package overlay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Plugins are programs that the overlay runs and talks to over their stdin
+// and stdout, one JSON object per line, like the control protocol. Images
+// are base64 encoded in any format the overlay can decode, and always png
+// when the overlay sends them. Anything plugins write to stderr is passed
+// through.
+//
+// A source plugin delivers the images to show, a line whenever there is a
+// new one:
+//
+//	{"image": "iVBORw0KGgo..."}
+//	{"path": "/tmp/export.png"}
+//	{"error": "export failed"}
+//
+// An effect plugin is sent every image that is shown and answers with the
+// image to show instead, or with an error to show it unchanged:
+//
+//	-> {"image": "iVBORw0KGgo..."}
+//	<- {"image": "iVBORw0KGgo..."}
+
+// maxPluginMessageSize limits the lines plugins write, base64 makes images a
+// third larger.
+const maxPluginMessageSize = maxWebhookImageSize * 2
+
+type pluginMessage struct {
+	Image []byte `json:"image,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// plugin is a running plugin program.
+type plugin struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	scanner *bufio.Scanner
+
+	// one image at a time for effects
+	mu sync.Mutex
+}
+
+// startPlugin runs the program command, which is stopped when the overlay
+// is closed.
+func (display *Window) startPlugin(command []string) (*plugin, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty plugin command")
+	}
+
+	cmd := exec.CommandContext(display.ctx, command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("plugin stdin: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("plugin stdout: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("start plugin %s: %w", command[0], err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxPluginMessageSize)
+
+	return &plugin{
+		name:    filepath.Base(command[0]),
+		cmd:     cmd,
+		stdin:   stdin,
+		scanner: scanner,
+	}, nil
+}
+
+// read reads the next message, io.EOF once the plugin exited.
+func (p *plugin) read() (pluginMessage, error) {
+	if !p.scanner.Scan() {
+		err := p.scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+
+		return pluginMessage{}, err
+	}
+
+	var message pluginMessage
+
+	err := json.Unmarshal(p.scanner.Bytes(), &message)
+	if err != nil {
+		return pluginMessage{}, fmt.Errorf("decode message: %w", err)
+	}
+
+	return message, nil
+}
+
+// apply sends img through the effect plugin.
+func (p *plugin) apply(img image.Image) (image.Image, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err := encoder.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("encode image: %w", err)
+	}
+
+	err = json.NewEncoder(p.stdin).Encode(pluginMessage{Image: buf.Bytes()})
+	if err != nil {
+		return nil, fmt.Errorf("send image: %w", err)
+	}
+
+	message, err := p.read()
+	if err != nil {
+		return nil, fmt.Errorf("read image: %w", err)
+	}
+
+	if message.Error != "" {
+		return nil, errors.New(message.Error)
+	}
+
+	result, _, err := image.Decode(bytes.NewReader(message.Image))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	return result, nil
+}
+
+// startSourcePlugin shows the images the plugin delivers until it exits.
+func (display *Window) startSourcePlugin(command []string) error {
+	p, err := display.startPlugin(command)
+	if err != nil {
+		return err
+	}
+
+	// not part of the wait group, like streams, programs the plugin started
+	// may keep its stdout open after it was stopped
+	go func() {
+		for {
+			message, err := p.read()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					slog.Error("source plugin", "plugin", p.name, "err", err)
+				}
+
+				break
+			}
+
+			err = display.showPluginImage(p.name, message)
+			if err != nil {
+				slog.Error("source plugin", "plugin", p.name, "err", err)
+				display.sourceFailed("plugin:"+p.name, err)
+			}
+		}
+
+		p.stdin.Close()
+
+		err := p.cmd.Wait()
+		if err != nil && display.ctx.Err() == nil {
+			slog.Error("source plugin exited", "plugin", p.name, "err", err)
+			display.sourceFailed("plugin:"+p.name, fmt.Errorf("exited: %w", err))
+		}
+	}()
+
+	return nil
+}
+
+func (display *Window) showPluginImage(name string, message pluginMessage) error {
+	switch {
+	case message.Error != "":
+		return errors.New(message.Error)
+	case message.Path != "":
+		decoded, err := loadImage(message.Path, display.options.Animate, display.decodeTarget())
+		if err != nil {
+			return err
+		}
+
+		display.setLiveImage(message.Path, decoded)
+	case len(message.Image) > 0:
+		decoded, err := decodeImage(message.Image, display.options.Animate, image.Point{})
+		if err != nil {
+			return err
+		}
+
+		display.setLiveImage("plugin:"+name, decoded)
+	}
+
+	return nil
+}
+
+// startEffectPlugins starts the effects and applies them to the image that
+// is already shown.
+func (display *Window) startEffectPlugins(commands [][]string) error {
+	for _, command := range commands {
+		p, err := display.startPlugin(command)
+		if err != nil {
+			return err
+		}
+
+		display.effects = append(display.effects, p)
+	}
+
+	display.renderMu.Lock()
+	source := display.source
+	loaded := display.loaded
+	placeholder := display.placeholder
+	display.renderMu.Unlock()
+
+	// effects are for images, the spinner of a source stays as it is
+	if !placeholder {
+		display.setImage(source, loaded)
+	}
+
+	return nil
+}
+
+// effectCache holds the result of the effects for the image they were last
+// applied to, rotating or flipping it doesn't run them again.
+type effectCache struct {
+	mu      sync.Mutex
+	from    image.Image
+	decoded decodedImage
+}
+
+// applyEffects sends the image and every frame of it through the effect
+// plugins in order. It is shown unchanged if one of them fails.
+func (display *Window) applyEffects(decoded decodedImage) decodedImage {
+	if len(display.effects) == 0 {
+		return decoded
+	}
+
+	cache := &display.effectCache
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.from == decoded.image {
+		return cache.decoded
+	}
+
+	var failed error
+
+	result := decoded
+	for _, p := range display.effects {
+		result = mapFrames(result, func(img image.Image) image.Image {
+			if failed != nil {
+				return img
+			}
+
+			applied, err := p.apply(img)
+			if err != nil {
+				failed = fmt.Errorf("effect plugin %s: %w", p.name, err)
+				return img
+			}
+
+			return applied
+		})
+	}
+
+	if failed != nil {
+		slog.Error("apply effects", "err", failed)
+		return decoded
+	}
+
+	cache.from = decoded.image
+	cache.decoded = result
+
+	return result
+}
@@ -0,0 +1,64 @@
+package overlay
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// Presenter remotes send media keys, or forward and back, to page through
+// slides. They are bound to next-image, previous-image and blank like any
+// other key, and with Options.PresenterKeys grabbed on the root window as
+// well, so that the remote drives the overlay whatever has the focus.
+
+const (
+	keysymXF86AudioPlay   xproto.Keysym = 0x1008ff14
+	keysymXF86AudioPrev   xproto.Keysym = 0x1008ff16
+	keysymXF86AudioNext   xproto.Keysym = 0x1008ff17
+	keysymXF86Back        xproto.Keysym = 0x1008ff26
+	keysymXF86Forward     xproto.Keysym = 0x1008ff27
+	keysymXF86ScreenSaver xproto.Keysym = 0x1008ff2d
+
+	// the range of the XF86 keysyms of media and special keys
+	keysymXF86First xproto.Keysym = 0x1008ff00
+	keysymXF86Last  xproto.Keysym = 0x1008ffff
+)
+
+// the actions a remote is for
+var presenterActions = []action{actionNextImage, actionPreviousImage, actionBlank}
+
+var errUnmappedKey = errors.New("no key produces the keysym")
+
+// presenterKeys returns the media keys bound to presenterActions, which
+// nothing else needs while presenting.
+func (display *Window) presenterKeys() []KeyCombo {
+	if !display.options.PresenterKeys || display.options.LockInput {
+		return nil
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	var combos []KeyCombo
+	for combo, a := range display.options.Keymap {
+		if combo.keysym >= keysymXF86First && combo.keysym <= keysymXF86Last && slices.Contains(presenterActions, a) {
+			combos = append(combos, combo)
+		}
+	}
+
+	return combos
+}
+
+// grabPresenterKeys grabs the presenter keys there are, a remote that isn't
+// plugged in has none.
+func (display *Window) grabPresenterKeys() error {
+	for _, combo := range display.presenterKeys() {
+		err := display.grabKey(combo)
+		if err != nil && !errors.Is(err, errUnmappedKey) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/jezek/xgb/shape"
+	"github.com/jezek/xgb/xfixes"
+	"github.com/jezek/xgb/xproto"
+)
+
+// the size of the blocks the covered screen content is reduced to, large
+// enough that text can't be read anymore
+const privacyBlockSize = 16
+
+// privacyScreen covers zones of the screen with a grayscale, pixelated copy
+// of what they show, to hide sensitive content while sharing the screen.
+// Every zone is a window of its own that lets clicks through.
+type privacyScreen struct {
+	zones   []image.Rectangle
+	windows []xproto.Window
+	pixmaps []xproto.Pixmap
+}
+
+// ParseZone parses zones of the form "x,y,width,height".
+func ParseZone(value string) (image.Rectangle, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("zone %q: expected x,y,width,height", value)
+	}
+
+	var numbers [4]int
+	for i, part := range parts {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("zone %q: %w", value, err)
+		}
+
+		numbers[i] = number
+	}
+
+	if numbers[2] <= 0 || numbers[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("zone %q: width and height have to be positive", value)
+	}
+
+	return image.Rect(numbers[0], numbers[1], numbers[0]+numbers[2], numbers[1]+numbers[3]), nil
+}
+
+// setupPrivacy checks that the server can make windows click-through, so
+// that a missing extension is reported on startup and not on the first
+// toggle.
+func (display *Window) setupPrivacy(zones []image.Rectangle) error {
+	err := xfixes.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init xfixes: %w", err)
+	}
+
+	// input shapes need xfixes 2.0 and the shape extension
+	_, err = xfixes.QueryVersion(display.conn, 2, 0).Reply()
+	if err != nil {
+		return fmt.Errorf("query xfixes version: %w", err)
+	}
+
+	err = shape.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init shape: %w", err)
+	}
+
+	display.privacy = &privacyScreen{zones: zones}
+
+	return nil
+}
+
+// togglePrivacy covers the zones if they are not covered and uncovers them
+// otherwise.
+func (display *Window) togglePrivacy() error {
+	privacy := display.privacy
+	if privacy == nil {
+		return fmt.Errorf("no privacy zones configured")
+	}
+
+	if len(privacy.windows) > 0 {
+		for _, window := range privacy.windows {
+			xproto.DestroyWindow(display.conn, window)
+		}
+
+		for _, pixmap := range privacy.pixmaps {
+			xproto.FreePixmap(display.conn, pixmap)
+		}
+
+		privacy.windows = nil
+		privacy.pixmaps = nil
+
+		return nil
+	}
+
+	for _, zone := range privacy.zones {
+		err := display.coverZone(zone)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// coverZone puts a window over zone that shows a pixelated copy of the
+// content below it. The copy is taken once, content that changes later stays
+// hidden as well.
+func (display *Window) coverZone(zone image.Rectangle) error {
+	conn := display.conn
+	root := display.screen.Root
+	depth := display.screen.RootDepth
+	zone = zone.Intersect(image.Rect(0, 0, int(display.screen.WidthInPixels), int(display.screen.HeightInPixels)))
+
+	if zone.Empty() {
+		return nil
+	}
+
+	const allPlanes = 0xffffffff
+
+	area, err := xRectangle(zone)
+	if err != nil {
+		return err
+	}
+
+	reply, err := xproto.GetImage(
+		conn,
+		xproto.ImageFormatZPixmap,
+		xproto.Drawable(root),
+		area.X,
+		area.Y,
+		area.Width,
+		area.Height,
+		allPlanes,
+	).Reply()
+	if err != nil {
+		return fmt.Errorf("get image: %w", err)
+	}
+
+	if len(reply.Data) < zone.Dx()*zone.Dy()*4 {
+		return fmt.Errorf("get image: expected %d bytes, got %d", zone.Dx()*zone.Dy()*4, len(reply.Data))
+	}
+
+	pixelate(reply.Data, zone.Dx(), zone.Dy(), privacyBlockSize)
+
+	pixmap, err := xproto.NewPixmapId(conn)
+	if err != nil {
+		return fmt.Errorf("new pixmap id: %w", err)
+	}
+
+	err = xproto.CreatePixmapChecked(conn, depth, pixmap, xproto.Drawable(root), area.Width, area.Height).Check()
+	if err != nil {
+		return fmt.Errorf("create pixmap: %w", err)
+	}
+
+	display.privacy.pixmaps = append(display.privacy.pixmaps, pixmap)
+
+	gc, err := display.resources.gc(depth, xproto.Drawable(pixmap))
+	if err != nil {
+		return fmt.Errorf("get graphics context: %w", err)
+	}
+
+	err = display.putImageBands(xproto.Drawable(pixmap), depth, gc, reply.Data, zone.Dx(), zone.Dy(), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	window, err := xproto.NewWindowId(conn)
+	if err != nil {
+		return fmt.Errorf("new window id: %w", err)
+	}
+
+	// the server paints the background pixmap by itself, the window needs
+	// no events. Override redirect keeps it above the other windows and out
+	// of taskbars.
+	err = xproto.CreateWindowChecked(
+		conn,
+		xproto.WindowClassCopyFromParent,
+		window,
+		root,
+		area.X,
+		area.Y,
+		area.Width,
+		area.Height,
+		0,
+		xproto.WindowClassInputOutput,
+		display.screen.RootVisual,
+		xproto.CwBackPixmap|xproto.CwOverrideRedirect,
+		[]uint32{uint32(pixmap), 1},
+	).Check()
+	if err != nil {
+		return fmt.Errorf("create window: %w", err)
+	}
+
+	display.privacy.windows = append(display.privacy.windows, window)
+
+	err = display.clickThrough(window)
+	if err != nil {
+		return err
+	}
+
+	err = xproto.MapWindowChecked(conn, window).Check()
+	if err != nil {
+		return fmt.Errorf("map window: %w", err)
+	}
+
+	return nil
+}
+
+// clickThrough gives window an empty input shape, so that clicks reach the
+// windows below it.
+func (display *Window) clickThrough(window xproto.Window) error {
+	region, err := xfixes.NewRegionId(display.conn)
+	if err != nil {
+		return fmt.Errorf("new region id: %w", err)
+	}
+
+	xfixes.CreateRegion(display.conn, region, nil)
+	defer xfixes.DestroyRegion(display.conn, region)
+
+	err = xfixes.SetWindowShapeRegionChecked(display.conn, window, shape.SkInput, 0, 0, region).Check()
+	if err != nil {
+		return fmt.Errorf("set input shape: %w", err)
+	}
+
+	return nil
+}
+
+// pixelate replaces the bgrx pixels in pix with the gray average of the
+// blocks of blockSize pixels they belong to.
+func pixelate(pix []byte, width int, height int, blockSize int) {
+	rowSize := width * 4
+
+	for blockY := 0; blockY < height; blockY += blockSize {
+		for blockX := 0; blockX < width; blockX += blockSize {
+			block := image.Rect(blockX, blockY, min(width, blockX+blockSize), min(height, blockY+blockSize))
+
+			var sum int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					i := y*rowSize + x*4
+					// luma from blue, green and red
+					sum += 114*int(pix[i]) + 587*int(pix[i+1]) + 299*int(pix[i+2])
+				}
+			}
+
+			gray := byte(sum / (1000 * block.Dx() * block.Dy()))
+
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					i := y*rowSize + x*4
+					pix[i] = gray
+					pix[i+1] = gray
+					pix[i+2] = gray
+					pix[i+3] = 0xff
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// size of the fixed part of a PutImage request in bytes
+const putImageHeaderSize = 24
+
+// pixelBufferFor returns a buffer of at least size bytes for rendering
+// without shared memory.
+func (display *Window) pixelBufferFor(size int) []byte {
+	if len(display.pixelBuffer) < size {
+		display.pixelBuffer = make([]byte, size)
+	}
+
+	return display.pixelBuffer[:size]
+}
+
+// tileBufferFor returns a buffer of size bytes for the tile that is
+// repeated over the window.
+func (display *Window) tileBufferFor(size int) []byte {
+	if len(display.tileBuffer) < size {
+		display.tileBuffer = make([]byte, size)
+	}
+
+	return display.tileBuffer[:size]
+}
+
+// putImageBands sends data with core PutImage requests, split into bands of
+// rows that fit into the maximum request size of the server. This works over
+// connections that can't use shared memory, e.g. forwarded over ssh.
+func (display *Window) putImageBands(drawable xproto.Drawable, depth byte, gc xproto.Gcontext, data []byte, width int, height int, x int, y int) error {
+	rowSize := width * 4
+	maxRequestSize := int(xproto.Setup(display.conn).MaximumRequestLength) * 4
+	if display.quirks.smallRequests {
+		maxRequestSize = min(maxRequestSize, smallRequestSize)
+	}
+	rowsPerRequest := max(1, (maxRequestSize-putImageHeaderSize)/rowSize)
+
+	// the whole area has to fit, not only the bands
+	_, err := xRectangle(image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return fmt.Errorf("put image: %w", err)
+	}
+
+	if len(data) < rowSize*height {
+		return fmt.Errorf("put image: %d bytes for %dx%d pixels", len(data), width, height)
+	}
+
+	for startRow := 0; startRow < height; startRow += rowsPerRequest {
+		rows := min(rowsPerRequest, height-startRow)
+		band := data[startRow*rowSize : (startRow+rows)*rowSize]
+		area, _ := xRectangle(image.Rect(x, y+startRow, x+width, y+startRow+rows))
+
+		// only the last band is checked, that is a single round trip for the
+		// whole image. Errors of the other bands end up in the event queue.
+		if startRow+rows < height {
+			xproto.PutImage(
+				display.conn,
+				xproto.ImageFormatZPixmap,
+				drawable,
+				gc,
+				area.Width,
+				area.Height,
+				area.X,
+				area.Y,
+				0, // left pad
+				depth,
+				band,
+			)
+
+			continue
+		}
+
+		err := xproto.PutImageChecked(
+			display.conn,
+			xproto.ImageFormatZPixmap,
+			drawable,
+			gc,
+			area.Width,
+			area.Height,
+			area.X,
+			area.Y,
+			0, // left pad
+			depth,
+			band,
+		).Check()
+		if err != nil {
+			return fmt.Errorf("put image: %w", err)
+		}
+	}
+
+	return nil
+}
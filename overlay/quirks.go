@@ -0,0 +1,77 @@
+package overlay
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quirks work around X servers that are commonly used for remote sessions
+// and lack features we otherwise rely on.
+type quirks struct {
+	// no 32 bit visual, the window is drawn without transparency
+	noARGB bool
+	// no shared memory with the server, images are sent with core requests
+	noShm bool
+	// the server can't handle big requests well, images are sent in
+	// smaller pieces
+	smallRequests bool
+}
+
+// requests are limited to this many bytes with the smallRequests quirk
+const smallRequestSize = 64 * 1024
+
+var quirkNames = map[string]func(*quirks){
+	"no-argb":        func(q *quirks) { q.noARGB = true },
+	"no-shm":         func(q *quirks) { q.noShm = true },
+	"small-requests": func(q *quirks) { q.smallRequests = true },
+}
+
+// serverQuirks maps substrings of the vendor string to the quirks of that
+// server.
+var serverQuirks = []struct {
+	vendor string
+	quirks quirks
+}{
+	{"xpra", quirks{noShm: true, smallRequests: true}},
+	{"tigervnc", quirks{noARGB: true, noShm: true, smallRequests: true}},
+	{"realvnc", quirks{noARGB: true, noShm: true, smallRequests: true}},
+	{"tightvnc", quirks{noARGB: true, noShm: true, smallRequests: true}},
+	{"xvnc", quirks{noARGB: true, noShm: true, smallRequests: true}},
+}
+
+func detectQuirks(vendor string) quirks {
+	vendor = strings.ToLower(vendor)
+
+	for _, server := range serverQuirks {
+		if strings.Contains(vendor, server.vendor) {
+			return server.quirks
+		}
+	}
+
+	return quirks{}
+}
+
+// parseQuirks parses "auto", "none" or a comma separated list of quirks
+// like "no-shm,small-requests". Auto detects the quirks from the vendor
+// string of the server.
+func parseQuirks(value string, vendor string) (quirks, error) {
+	switch value {
+	case "", "auto":
+		return detectQuirks(vendor), nil
+	case "none":
+		return quirks{}, nil
+	}
+
+	var result quirks
+
+	for _, name := range strings.Split(value, ",") {
+		enable, ok := quirkNames[strings.TrimSpace(name)]
+		if !ok {
+			return quirks{}, fmt.Errorf("unknown quirk %q", name)
+		}
+
+		enable(&result)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,25 @@
+package overlay
+
+import (
+	"math/rand/v2"
+)
+
+// newRandom returns the source for picking random images, seeded with seed
+// so that a sequence can be repeated, or randomly if it is 0.
+func newRandom(seed uint64) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// randomIndex picks one of count images other than current.
+func randomIndex(random *rand.Rand, count int, current int) int {
+	index := random.IntN(count - 1)
+	if index >= current {
+		index++
+	}
+
+	return index
+}
@@ -0,0 +1,217 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/jezek/xgb/randr"
+)
+
+// monitor is a randr output that shows part of the screen.
+type monitor struct {
+	name string
+	area image.Rectangle
+}
+
+// outputPlacement keeps the window on the monitor of a randr output, also
+// when monitors are added, removed or rearranged.
+type outputPlacement struct {
+	name       string
+	fullscreen bool
+	// the window is centered on the monitor if no position was given
+	center bool
+	area   image.Rectangle
+}
+
+// monitors returns the outputs that currently show something, the primary
+// output first.
+func (display *Window) monitors() ([]monitor, error) {
+	resources, err := randr.GetScreenResourcesCurrent(display.conn, display.screen.Root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get screen resources: %w", err)
+	}
+
+	primary, err := randr.GetOutputPrimary(display.conn, display.screen.Root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get primary output: %w", err)
+	}
+
+	var monitors []monitor
+	for _, output := range resources.Outputs {
+		info, err := randr.GetOutputInfo(display.conn, output, resources.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("get output info: %w", err)
+		}
+
+		// disconnected or turned off
+		if info.Crtc == 0 {
+			continue
+		}
+
+		crtc, err := randr.GetCrtcInfo(display.conn, info.Crtc, resources.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("get crtc info: %w", err)
+		}
+
+		m := monitor{
+			name: string(info.Name),
+			area: image.Rect(int(crtc.X), int(crtc.Y), int(crtc.X)+int(crtc.Width), int(crtc.Y)+int(crtc.Height)),
+		}
+
+		if output == primary.Output {
+			monitors = append([]monitor{m}, monitors...)
+		} else {
+			monitors = append(monitors, m)
+		}
+	}
+
+	return monitors, nil
+}
+
+// findMonitor returns the monitor of the output name, "primary" is the
+// primary one.
+func findMonitor(monitors []monitor, name string) (monitor, error) {
+	if name == "primary" && len(monitors) > 0 {
+		return monitors[0], nil
+	}
+
+	var names []string
+	for _, m := range monitors {
+		if m.name == name {
+			return m, nil
+		}
+
+		names = append(names, m.name)
+	}
+
+	return monitor{}, fmt.Errorf("output %q not found, active outputs: %s", name, strings.Join(names, ", "))
+}
+
+// startOutput places the window on the monitor of the output name, filling
+// it if fullscreen is set. It has to be called before createWindow.
+func (display *Window) startOutput(name string, fullscreen bool) error {
+	err := randr.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init randr: %w", err)
+	}
+
+	// GetScreenResourcesCurrent and GetOutputPrimary are from 1.3
+	version, err := randr.QueryVersion(display.conn, 1, 3).Reply()
+	if err != nil {
+		return fmt.Errorf("query randr version: %w", err)
+	}
+
+	if version.MajorVersion < 1 || version.MajorVersion == 1 && version.MinorVersion < 3 {
+		return fmt.Errorf("randr %d.%d is too old, 1.3 is needed", version.MajorVersion, version.MinorVersion)
+	}
+
+	monitors, err := display.monitors()
+	if err != nil {
+		return err
+	}
+
+	m, err := findMonitor(monitors, name)
+	if err != nil {
+		return err
+	}
+
+	err = randr.SelectInputChecked(
+		display.conn,
+		display.screen.Root,
+		randr.NotifyMaskScreenChange|randr.NotifyMaskCrtcChange|randr.NotifyMaskOutputChange,
+	).Check()
+	if err != nil {
+		return fmt.Errorf("select randr events: %w", err)
+	}
+
+	display.output = &outputPlacement{
+		name:       name,
+		fullscreen: fullscreen,
+		center:     !display.options.Geometry.HasPosition,
+		area:       m.area,
+	}
+
+	// the position is always ours to choose, on the output
+	display.options.Geometry.HasPosition = true
+	if fullscreen {
+		display.options.Geometry = Geometry{Width: m.area.Dx(), Height: m.area.Dy(), HasPosition: true}
+	}
+
+	return nil
+}
+
+// placementArea is the part of the screen the geometry is relative to.
+func (display *Window) placementArea() image.Rectangle {
+	if display.output != nil {
+		return display.output.area
+	}
+
+	return image.Rect(0, 0, int(display.screen.WidthInPixels), int(display.screen.HeightInPixels))
+}
+
+// place resolves geometry to a rectangle on the screen.
+func (display *Window) place(geometry Geometry, imageWidth int, imageHeight int) (x, y, width, height int) {
+	area := display.placementArea()
+
+	x, y, width, height = geometry.resolve(imageWidth, imageHeight, area.Dx(), area.Dy())
+	if display.output != nil && display.output.center {
+		x = (area.Dx() - width) / 2
+		y = (area.Dy() - height) / 2
+	}
+
+	return clampCoordinate(area.Min.X + x), clampCoordinate(area.Min.Y + y), width, height
+}
+
+// outputsChanged moves the window when its monitor moved or changed its
+// size. If the output is gone the window goes to the primary one until it
+// is back.
+func (display *Window) outputsChanged() error {
+	monitors, err := display.monitors()
+	if err != nil {
+		return err
+	}
+
+	if len(monitors) == 0 {
+		return nil
+	}
+
+	m, err := findMonitor(monitors, display.output.name)
+	if err != nil {
+		m = monitors[0]
+	}
+
+	if m.area == display.output.area {
+		return nil
+	}
+
+	display.output.area = m.area
+
+	if display.output.fullscreen {
+		// window managers keep fullscreen windows where they are
+		err = display.changeNetWmState(netWmStateRemove, "_NET_WM_STATE_FULLSCREEN")
+		if err != nil {
+			return err
+		}
+
+		err = display.moveWindow(m.area.Min.X, m.area.Min.Y)
+		if err != nil {
+			return err
+		}
+
+		err = display.resizeWindow(m.area.Dx(), m.area.Dy())
+		if err != nil {
+			return err
+		}
+
+		return display.changeNetWmState(netWmStateAdd, "_NET_WM_STATE_FULLSCREEN")
+	}
+
+	geometry := display.options.Geometry
+	geometry.Width = display.windowWidth
+	geometry.Height = display.windowHeight
+
+	x, y, _, _ := display.place(geometry, display.windowWidth, display.windowHeight)
+
+	return display.moveWindow(x, y)
+}
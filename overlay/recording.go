@@ -0,0 +1,550 @@
+package overlay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// The images of a live source can be recorded to a file as they arrive, to
+// look at a monitored feed later. APNG and MJPEG are written directly,
+// everything else by piping the frames to ffmpeg. The time every frame
+// arrived is written next to it, to the same path with .timestamps appended,
+// one line per frame.
+
+// RecordFormat is the format of recordings that are started without a path.
+type RecordFormat string
+
+const (
+	RecordAPNG  RecordFormat = "apng"
+	RecordMJPEG RecordFormat = "mjpeg"
+	RecordWebM  RecordFormat = "webm"
+)
+
+const (
+	// frames that arrive while this many are still being written are
+	// dropped instead of holding back the source
+	recordQueueSize   = 64
+	recordJPEGQuality = 90
+)
+
+func ParseRecordFormat(name string) (RecordFormat, error) {
+	switch format := RecordFormat(name); format {
+	case RecordAPNG, RecordMJPEG, RecordWebM:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unknown record format %q, expected apng, mjpeg or webm", name)
+}
+
+func (format RecordFormat) extension() string {
+	if format == RecordAPNG {
+		return ".png"
+	}
+
+	return "." + string(format)
+}
+
+// frameWriter writes the frames of a recording, which all have the size of
+// the first one.
+type frameWriter interface {
+	writeFrame(frame *image.NRGBA, at time.Time) error
+	close(end time.Time) error
+}
+
+type recordedFrame struct {
+	image image.Image
+	at    time.Time
+}
+
+// recording writes the frames it is given in the background.
+type recording struct {
+	path   string
+	frames chan recordedFrame
+	done   chan struct{}
+
+	// only touched by the goroutine writing the frames until done
+	written int
+	err     error
+
+	// frames that didn't fit into the queue, guarded by the recording
+	// mutex of the window
+	dropped int
+}
+
+func newFrameWriter(path string, size image.Point) (frameWriter, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".apng":
+		return newAPNGWriter(path, size)
+	case ".mjpeg", ".mjpg":
+		return newMJPEGWriter(path)
+	}
+
+	return newFFmpegWriter(path, size)
+}
+
+// StartRecording writes the images of the live source to path as they
+// arrive, in the format of its extension, until StopRecording.
+func (display *Window) StartRecording(path string) error {
+	if !display.options.live() {
+		return fmt.Errorf("only streams and the images of live sources can be recorded")
+	}
+
+	display.recordingMu.Lock()
+	defer display.recordingMu.Unlock()
+
+	if display.recording != nil {
+		return fmt.Errorf("already recording to %s", display.recording.path)
+	}
+
+	// the frames are written once the first one tells their size, an
+	// existing file is refused right away
+	_, err := os.Stat(path)
+	if err == nil {
+		return fmt.Errorf("%s exists already", path)
+	}
+
+	timestamps, err := os.OpenFile(path+".timestamps", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("create timestamps: %w", err)
+	}
+
+	rec := &recording{
+		path:   path,
+		frames: make(chan recordedFrame, recordQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	go rec.run(timestamps)
+
+	display.recording = rec
+
+	return nil
+}
+
+// StopRecording finishes the recording and reports how many frames it has.
+func (display *Window) StopRecording() (int, error) {
+	rec := display.takeRecording()
+	if rec == nil {
+		return 0, fmt.Errorf("not recording")
+	}
+
+	return rec.stop()
+}
+
+// toggleRecording starts recording to a new file in the recording directory,
+// or stops the recording.
+func (display *Window) toggleRecording() error {
+	if rec := display.takeRecording(); rec != nil {
+		frames, err := rec.stop()
+		if err != nil {
+			return err
+		}
+
+		slog.Info("recorded", "frames", frames, "path", rec.path)
+
+		return nil
+	}
+
+	path := display.newRecordingPath()
+
+	err := display.StartRecording(path)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("recording", "path", path)
+
+	return nil
+}
+
+// newRecordingPath returns a path in the recording directory named after the
+// time.
+func (display *Window) newRecordingPath() string {
+	name := "xoverlay-" + time.Now().Format("2006-01-02-150405") + display.options.RecordFormat.extension()
+	return filepath.Join(display.options.RecordDir, name)
+}
+
+func (display *Window) takeRecording() *recording {
+	display.recordingMu.Lock()
+	defer display.recordingMu.Unlock()
+
+	rec := display.recording
+	display.recording = nil
+
+	return rec
+}
+
+// recordingPath returns where the images are recorded to, "" if they aren't.
+func (display *Window) recordingPath() string {
+	display.recordingMu.Lock()
+	defer display.recordingMu.Unlock()
+
+	if display.recording == nil {
+		return ""
+	}
+
+	return display.recording.path
+}
+
+// recordImage adds img to the recording, if there is one.
+func (display *Window) recordImage(img image.Image) {
+	display.recordingMu.Lock()
+	defer display.recordingMu.Unlock()
+
+	if display.recording == nil {
+		return
+	}
+
+	select {
+	case display.recording.frames <- recordedFrame{image: img, at: time.Now()}:
+	default:
+		display.recording.dropped++
+	}
+}
+
+func (rec *recording) run(timestamps *os.File) {
+	defer close(rec.done)
+
+	var writer frameWriter
+	var size image.Point
+
+	stamps := bufio.NewWriter(timestamps)
+
+	for frame := range rec.frames {
+		if rec.err != nil {
+			continue
+		}
+
+		if writer == nil {
+			size = frame.image.Bounds().Size()
+			writer, rec.err = newFrameWriter(rec.path, size)
+			if rec.err != nil {
+				continue
+			}
+		}
+
+		rec.err = writer.writeFrame(toRecordedSize(frame.image, size), frame.at)
+		if rec.err != nil {
+			continue
+		}
+
+		fmt.Fprintln(stamps, frame.at.Format(time.RFC3339Nano))
+
+		rec.written++
+	}
+
+	// the last frame was shown until now
+	if writer != nil {
+		err := writer.close(time.Now())
+		if rec.err == nil {
+			rec.err = err
+		}
+	}
+
+	err := stamps.Flush()
+	closeErr := timestamps.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if rec.err == nil && err != nil {
+		rec.err = fmt.Errorf("write timestamps: %w", err)
+	}
+}
+
+// stop writes the frames that are queued and closes the files.
+func (rec *recording) stop() (int, error) {
+	close(rec.frames)
+	<-rec.done
+
+	if rec.err != nil {
+		return rec.written, fmt.Errorf("record %s: %w", rec.path, rec.err)
+	}
+
+	if rec.dropped > 0 {
+		slog.Warn("dropped frames that arrived faster than they were written", "path", rec.path, "frames", rec.dropped)
+	}
+
+	return rec.written, nil
+}
+
+// toRecordedSize converts img to a non premultiplied image of size, the
+// frames of a recording can't change their size.
+func toRecordedSize(img image.Image, size image.Point) *image.NRGBA {
+	frame := image.NewNRGBA(image.Rectangle{Max: size})
+
+	if img.Bounds().Size() == size {
+		draw.Draw(frame, frame.Bounds(), img, img.Bounds().Min, draw.Src)
+	} else {
+		draw.ApproxBiLinear.Scale(frame, frame.Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+
+	return frame
+}
+
+// apngWriter writes an animated png whose frames are shown for as long as
+// they were shown while recording. Every frame is held back until the next
+// one tells how long that was.
+type apngWriter struct {
+	file     *os.File
+	size     image.Point
+	sequence uint32
+	frames   uint32
+
+	pending   []byte
+	pendingAt time.Time
+}
+
+// where the acTL chunk is, after the signature and IHDR
+const apngACTLOffset = 8 + 12 + 13
+
+func newAPNGWriter(path string, size image.Point) (*apngWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(size.X))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(size.Y))
+	ihdr[8] = 8 // bits per channel
+	ihdr[9] = 6 // rgba
+	writePNGChunk(&buf, "IHDR", ihdr)
+
+	// the number of frames is filled in when the recording is closed
+	writePNGChunk(&buf, "acTL", apngACTL(0))
+
+	_, err = file.Write(buf.Bytes())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+
+	return &apngWriter{file: file, size: size}, nil
+}
+
+// apngACTL returns an acTL chunk for frames that are played once.
+func apngACTL(frames uint32) []byte {
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], frames)
+	binary.BigEndian.PutUint32(actl[4:8], 1)
+
+	return actl
+}
+
+func (writer *apngWriter) writeFrame(frame *image.NRGBA, at time.Time) error {
+	err := writer.flush(at)
+	if err != nil {
+		return err
+	}
+
+	// every row is stored unfiltered, filtering costs more time than the
+	// size it saves is worth while recording
+	var data bytes.Buffer
+	compressor, err := zlib.NewWriterLevel(&data, zlib.BestSpeed)
+	if err != nil {
+		return fmt.Errorf("compress frame: %w", err)
+	}
+
+	rowSize := writer.size.X * 4
+	for y := range writer.size.Y {
+		compressor.Write([]byte{0})
+		compressor.Write(frame.Pix[y*frame.Stride : y*frame.Stride+rowSize])
+	}
+
+	err = compressor.Close()
+	if err != nil {
+		return fmt.Errorf("compress frame: %w", err)
+	}
+
+	writer.pending = data.Bytes()
+	writer.pendingAt = at
+
+	return nil
+}
+
+// flush writes the pending frame, which is shown until next.
+func (writer *apngWriter) flush(next time.Time) error {
+	if writer.pending == nil {
+		return nil
+	}
+
+	delay := next.Sub(writer.pendingAt).Milliseconds()
+
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:4], writer.sequence)
+	binary.BigEndian.PutUint32(fctl[4:8], uint32(writer.size.X))
+	binary.BigEndian.PutUint32(fctl[8:12], uint32(writer.size.Y))
+	binary.BigEndian.PutUint16(fctl[20:22], uint16(min(delay, 0xffff)))
+	binary.BigEndian.PutUint16(fctl[22:24], 1000)
+	fctl[24] = apngDisposeNone
+	fctl[25] = apngBlendSource
+	writer.sequence++
+
+	var buf bytes.Buffer
+	writePNGChunk(&buf, "fcTL", fctl)
+
+	// the first frame is the default image as well
+	if writer.frames == 0 {
+		writePNGChunk(&buf, "IDAT", writer.pending)
+	} else {
+		fdat := binary.BigEndian.AppendUint32(nil, writer.sequence)
+		writePNGChunk(&buf, "fdAT", append(fdat, writer.pending...))
+		writer.sequence++
+	}
+
+	_, err := writer.file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+
+	writer.frames++
+	writer.pending = nil
+
+	return nil
+}
+
+func (writer *apngWriter) close(end time.Time) error {
+	err := writer.finish(end)
+	closeErr := writer.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// finish writes the last frame and the end, and fills in the number of
+// frames.
+func (writer *apngWriter) finish(end time.Time) error {
+	err := writer.flush(end)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writePNGChunk(&buf, "IEND", nil)
+
+	_, err = writer.file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write end: %w", err)
+	}
+
+	buf.Reset()
+	writePNGChunk(&buf, "acTL", apngACTL(writer.frames))
+
+	_, err = writer.file.WriteAt(buf.Bytes(), apngACTLOffset)
+	if err != nil {
+		return fmt.Errorf("write frame count: %w", err)
+	}
+
+	return nil
+}
+
+// mjpegWriter writes the frames as jpegs one after the other, which most
+// players understand as motion jpeg.
+type mjpegWriter struct {
+	file   *os.File
+	buffer *bufio.Writer
+}
+
+func newMJPEGWriter(path string) (*mjpegWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+
+	return &mjpegWriter{file: file, buffer: bufio.NewWriter(file)}, nil
+}
+
+func (writer *mjpegWriter) writeFrame(frame *image.NRGBA, _ time.Time) error {
+	err := jpeg.Encode(writer.buffer, frame, &jpeg.Options{Quality: recordJPEGQuality})
+	if err != nil {
+		return fmt.Errorf("encode frame: %w", err)
+	}
+
+	return nil
+}
+
+func (writer *mjpegWriter) close(time.Time) error {
+	err := writer.buffer.Flush()
+	if err != nil {
+		writer.file.Close()
+		return fmt.Errorf("write frames: %w", err)
+	}
+
+	return writer.file.Close()
+}
+
+// ffmpegWriter pipes the raw frames to ffmpeg, which picks the codec for the
+// extension of the file. ffmpeg takes the time it reads a frame as its
+// timestamp, so that the video plays at the pace the frames arrived at.
+type ffmpegWriter struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func newFFmpegWriter(path string, size image.Point) (*ffmpegWriter, error) {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", size.X, size.Y),
+		"-use_wallclock_as_timestamps", "1",
+		"-i", "-",
+		"-fps_mode", "passthrough",
+		"-n",
+		path,
+	)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	return &ffmpegWriter{cmd: cmd, stdin: stdin}, nil
+}
+
+func (writer *ffmpegWriter) writeFrame(frame *image.NRGBA, _ time.Time) error {
+	_, err := writer.stdin.Write(frame.Pix)
+	if err != nil {
+		return fmt.Errorf("write to ffmpeg: %w", err)
+	}
+
+	return nil
+}
+
+func (writer *ffmpegWriter) close(time.Time) error {
+	writer.stdin.Close()
+
+	err := writer.cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	return nil
+}
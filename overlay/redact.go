@@ -0,0 +1,80 @@
+package overlay
+
+import (
+	"image"
+	"image/draw"
+)
+
+// block size for redacted regions when Options.Pixelate is not set
+const defaultRedactBlock = 16
+
+// redact pixelates the image, or only the regions in Options.Redact, before
+// it is shown. svgs are rasterized at their own size first, they would show
+// the hidden content again when rendered from the vector at another size.
+func (options Options) redact(decoded decodedImage) decodedImage {
+	if options.Pixelate <= 0 && len(options.Redact) == 0 {
+		return decoded
+	}
+
+	blockSize := options.Pixelate
+	if blockSize <= 0 {
+		blockSize = defaultRedactBlock
+	}
+
+	return mapFrames(decoded, func(img image.Image) image.Image {
+		return pixelateImage(img, blockSize, options.Redact)
+	})
+}
+
+// pixelateImage returns a copy of img with regions, or all of it if there are
+// none, replaced by blocks of their average color. regions are relative to the
+// top left corner of the image.
+func pixelateImage(img image.Image, blockSize int, regions []image.Rectangle) image.Image {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	if len(regions) == 0 {
+		regions = []image.Rectangle{rgba.Bounds()}
+	}
+
+	for _, region := range regions {
+		pixelateRegion(rgba, region.Intersect(rgba.Bounds()), blockSize)
+	}
+
+	return rgba
+}
+
+func pixelateRegion(img *image.RGBA, region image.Rectangle, blockSize int) {
+	for blockY := region.Min.Y; blockY < region.Max.Y; blockY += blockSize {
+		for blockX := region.Min.X; blockX < region.Max.X; blockX += blockSize {
+			block := image.Rect(blockX, blockY, min(region.Max.X, blockX+blockSize), min(region.Max.Y, blockY+blockSize))
+
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				row := img.Pix[img.PixOffset(block.Min.X, y):img.PixOffset(block.Max.X, y)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			count := block.Dx() * block.Dy()
+			average := [4]byte{
+				byte(sum[0] / count),
+				byte(sum[1] / count),
+				byte(sum[2] / count),
+				byte(sum[3] / count),
+			}
+
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				row := img.Pix[img.PixOffset(block.Min.X, y):img.PixOffset(block.Max.X, y)]
+				for i := 0; i < len(row); i += 4 {
+					copy(row[i:i+4], average[:])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package overlay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRemoteInterval = 10 * time.Second
+	// failed polls are retried less and less often, up to this
+	maxRemoteBackoff = 5 * time.Minute
+	remoteTimeout    = time.Minute
+)
+
+// URLImage is an image on a web server, e.g. a dashboard that is rendered
+// regularly, that is polled for changes. Requests are conditional, the image
+// is only downloaded again if the server reports that it changed.
+type URLImage struct {
+	URL string
+	// how often it is polled, every 10 seconds if it is zero and only until
+	// it was downloaded once if it is negative
+	Interval time.Duration
+	// Presign is a program and its arguments that prints the URL to use,
+	// e.g. "aws s3 presign s3://bucket/dashboard.png". It is run again when
+	// the signed URL expired. URL is only shown as the source then.
+	Presign []string
+	// Header is sent with every request, e.g. Authorization for dashboards
+	// behind a login
+	Header http.Header
+}
+
+// remotePoller is the state of a URLImage between polls.
+type remotePoller struct {
+	remote URLImage
+	// the URL requests go to, signed by Presign
+	url string
+	// validators of the image shown, for conditional requests
+	etag         string
+	lastModified string
+}
+
+// Name returns the URL, or the presign command for signed URLs.
+func (remote URLImage) Name() string {
+	if remote.URL != "" {
+		return remote.URL
+	}
+
+	// signed URLs contain credentials, they aren't shown
+	return "presign:" + strings.Join(remote.Presign, " ")
+}
+
+// remoteBackoff returns how long to wait after failures polls in a row
+// failed.
+func remoteBackoff(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for range failures {
+		wait *= 2
+		if wait >= maxRemoteBackoff {
+			return maxRemoteBackoff
+		}
+	}
+
+	return wait
+}
+
+// Run shows the image and polls it for changes.
+func (remote URLImage) Run(ctx context.Context, sink SourceSink) error {
+	once := remote.Interval < 0
+	if remote.Interval <= 0 {
+		remote.Interval = defaultRemoteInterval
+	}
+
+	poller := &remotePoller{remote: remote, url: remote.URL}
+
+	failures := 0
+
+	for {
+		wait := remote.Interval
+
+		err := poller.poll(ctx, sink)
+		if err != nil {
+			failures++
+			wait = remoteBackoff(remote.Interval, failures)
+			slog.Warn("poll, retrying", "url", remote.Name(), "in", wait, "err", err)
+			sink.Fail(err)
+		} else if once {
+			return nil
+		} else {
+			failures = 0
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll shows the image if it changed since the last poll.
+func (poller *remotePoller) poll(ctx context.Context, sink SourceSink) error {
+	if poller.url == "" {
+		err := poller.presign(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	imageBytes, err := poller.fetch(ctx)
+
+	// expired signatures are refused, the request is tried once more with
+	// a new one
+	var status statusError
+	if errors.As(err, &status) && (status == http.StatusForbidden || status == http.StatusUnauthorized) && len(poller.remote.Presign) > 0 {
+		err = poller.presign(ctx)
+		if err != nil {
+			return err
+		}
+
+		imageBytes, err = poller.fetch(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the image shown is still current
+	if imageBytes == nil {
+		sink.Unchanged()
+		return nil
+	}
+
+	return sink.ShowEncoded(imageBytes)
+}
+
+// presign runs the Presign program for a new URL.
+func (poller *remotePoller) presign(ctx context.Context) error {
+	presign := poller.remote.Presign
+
+	output, err := exec.CommandContext(ctx, presign[0], presign[1:]...).Output()
+	if err != nil {
+		return fmt.Errorf("presign: %w", err)
+	}
+
+	url := strings.TrimSpace(string(output))
+	if url == "" {
+		return fmt.Errorf("presign: %s printed no URL", presign[0])
+	}
+
+	poller.url = url
+
+	return nil
+}
+
+// statusError is an unexpected status of a response.
+type statusError int
+
+func (status statusError) Error() string {
+	return fmt.Sprintf("%d %s", int(status), http.StatusText(int(status)))
+}
+
+// fetch downloads the image, or returns nil if it didn't change.
+func (poller *remotePoller) fetch(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, poller.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	for name, values := range poller.remote.Header {
+		request.Header[name] = values
+	}
+
+	if poller.etag != "" {
+		request.Header.Set("If-None-Match", poller.etag)
+	}
+	if poller.lastModified != "" {
+		request.Header.Set("If-Modified-Since", poller.lastModified)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, statusError(response.StatusCode)
+	}
+
+	imageBytes, err := io.ReadAll(io.LimitReader(response.Body, maxWebhookImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+
+	if len(imageBytes) > maxWebhookImageSize {
+		return nil, fmt.Errorf("download: larger than %d bytes", maxWebhookImageSize)
+	}
+
+	err = checkImageType(response.Header.Get("Content-Type"), imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	poller.etag = response.Header.Get("ETag")
+	poller.lastModified = response.Header.Get("Last-Modified")
+
+	return imageBytes, nil
+}
+
+// checkImageType returns an error if a download of contentType isn't an
+// image, like the login page of a server that redirects there. Servers that
+// don't know better send images as application/octet-stream or text/plain, so
+// what the data looks like counts as well.
+func checkImageType(contentType string, data []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "image/") {
+		return nil
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if strings.HasPrefix(sniffed, "image/") {
+		return nil
+	}
+
+	// svgs are sniffed as xml or text, if at all
+	if sniffed != "text/html" && bytes.Contains(data[:min(len(data), 1024)], []byte("<svg")) {
+		return nil
+	}
+
+	if mediaType == "" {
+		mediaType = sniffed
+	}
+
+	return fmt.Errorf("download: expected an image, got %s", mediaType)
+}
@@ -0,0 +1,90 @@
+package overlay
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xResources hands out colormaps and graphics contexts that can be shared by
+// all windows on a connection. Colormaps only depend on the visual and
+// graphics contexts only on the depth, so creating them per window would just
+// cost round trips.
+type xResources struct {
+	conn *xgb.Conn
+	root xproto.Window
+
+	mu        sync.Mutex
+	colormaps map[xproto.Visualid]xproto.Colormap
+	gcs       map[byte]xproto.Gcontext
+}
+
+func newXResources(conn *xgb.Conn, root xproto.Window) *xResources {
+	return &xResources{
+		conn:      conn,
+		root:      root,
+		colormaps: map[xproto.Visualid]xproto.Colormap{},
+		gcs:       map[byte]xproto.Gcontext{},
+	}
+}
+
+func (resources *xResources) colormap(visual xproto.Visualid) (xproto.Colormap, error) {
+	resources.mu.Lock()
+	defer resources.mu.Unlock()
+
+	if colormap, ok := resources.colormaps[visual]; ok {
+		return colormap, nil
+	}
+
+	colormap, err := xproto.NewColormapId(resources.conn)
+	if err != nil {
+		return 0, fmt.Errorf("new colormap id: %w", err)
+	}
+
+	// creating the colormap can only fail if the visual is wrong, which the
+	// sync after creating the window reports
+	xproto.CreateColormap(
+		resources.conn,
+		xproto.ColormapAllocNone,
+		colormap,
+		resources.root,
+		visual,
+	)
+
+	resources.colormaps[visual] = colormap
+
+	return colormap, nil
+}
+
+// gc returns a graphics context for drawables of the given depth. The
+// drawable is only used to create the context the first time it is needed.
+func (resources *xResources) gc(depth byte, drawable xproto.Drawable) (xproto.Gcontext, error) {
+	resources.mu.Lock()
+	defer resources.mu.Unlock()
+
+	if gc, ok := resources.gcs[depth]; ok {
+		return gc, nil
+	}
+
+	gc, err := xproto.NewGcontextId(resources.conn)
+	if err != nil {
+		return 0, fmt.Errorf("new graphics context id: %w", err)
+	}
+
+	err = xproto.CreateGCChecked(
+		resources.conn,
+		gc,
+		drawable,
+		0,
+		[]uint32{},
+	).Check()
+	if err != nil {
+		return 0, fmt.Errorf("create graphics context: %w", err)
+	}
+
+	resources.gcs[depth] = gc
+
+	return gc, nil
+}
@@ -0,0 +1,140 @@
+package overlay
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// the scaled image is produced in bands of this many rows, bands that have
+// already been scaled with the same parameters are reused
+const bandHeight = 64
+
+type bandCacheKey struct {
+	frameCacheKey
+	source image.Image
+}
+
+// bandCache keeps the scaled and converted rows of the last rendered image.
+// Resizes that don't change the scale factor (e.g. vertical resizes of an
+// image that is limited by the window width) only scale bands that have not
+// been scaled before.
+type bandCache struct {
+	key   bandCacheKey
+	bands map[int][]byte
+}
+
+func (cache *bandCache) get(key bandCacheKey, band int) []byte {
+	if cache.key != key {
+		return nil
+	}
+
+	return cache.bands[band]
+}
+
+func (cache *bandCache) put(key bandCacheKey, band int, data []byte) {
+	if cache.bands == nil || cache.key != key {
+		cache.key = key
+		cache.bands = map[int][]byte{}
+	}
+
+	cache.bands[band] = data
+}
+
+// scaleImage scales src to the size given by key and writes it into dst in
+// the byte order expected by X. If cache is not nil, bands are looked up in
+// and added to it.
+func scaleImage(dst []byte, src image.Image, key frameCacheKey, scaler draw.Scaler, cache *bandCache, threads int) {
+	width := key.width
+	height := key.height
+	rowSize := width * 4
+	bandCount := (height + bandHeight - 1) / bandHeight
+
+	cacheKey := bandCacheKey{frameCacheKey: key, source: src}
+
+	// all missing bands are scaled in a single call because kernel scalers
+	// always process every source row, no matter how few rows we ask for
+	missing := image.Rectangle{}
+
+	for band := range bandCount {
+		var bandData []byte
+		if cache != nil {
+			bandData = cache.get(cacheKey, band)
+		}
+
+		if bandData == nil {
+			missing = missing.Union(bandRows(band, width, height))
+			continue
+		}
+
+		copy(dst[band*bandHeight*rowSize:], bandData)
+	}
+
+	if missing.Empty() {
+		return
+	}
+
+	scaleRows(dst, src, key, missing, scaler, threads)
+
+	if cache != nil {
+		for band := missing.Min.Y / bandHeight; band*bandHeight < missing.Max.Y; band++ {
+			rows := bandRows(band, width, height)
+			cache.put(cacheKey, band, bytes.Clone(dst[rows.Min.Y*rowSize:rows.Max.Y*rowSize]))
+		}
+	}
+}
+
+func bandRows(band int, width int, height int) image.Rectangle {
+	return image.Rect(0, band*bandHeight, width, min(height, (band+1)*bandHeight))
+}
+
+// scaleRows scales src to the rectangle given by key but only computes the
+// pixels within rows, which are written to the same rows of dst.
+func scaleRows(dst []byte, src image.Image, key frameCacheKey, rows image.Rectangle, scaler draw.Scaler, threads int) {
+	rowSize := key.width * 4
+
+	// scaling straight into dst saves allocating and copying a whole frame
+	img := &image.RGBA{
+		Pix:    dst[rows.Min.Y*rowSize : rows.Max.Y*rowSize],
+		Stride: rowSize,
+		Rect:   rows,
+	}
+
+	const fullAlpha = 255
+	alpha := uint8(fullAlpha * key.opacity)
+	mask := image.NewUniform(color.Alpha{alpha})
+
+	// dst still holds whatever was drawn before, so the scaled pixels have to
+	// replace it instead of being composited onto it
+	scale := func(dst draw.Image) {
+		scaler.Scale(
+			dst,
+			key.scaled,
+			src,
+			src.Bounds(),
+			draw.Src,
+			&draw.Options{
+				SrcMask: mask,
+			},
+		)
+	}
+
+	// interpolators only look at the source pixels around each destination
+	// pixel, so the rows can be split up between threads. Kernel scalers
+	// process every source row no matter how few rows we ask for and are
+	// better off in a single call.
+	if scaler == draw.NearestNeighbor || scaler == draw.ApproxBiLinear {
+		forEachRowChunk(rows.Dy(), rowSize, threads, func(startRow int, endRow int) {
+			part := image.Rect(rows.Min.X, rows.Min.Y+startRow, rows.Max.X, rows.Min.Y+endRow)
+			scale(img.SubImage(part).(*image.RGBA))
+		})
+	} else {
+		scale(img)
+	}
+
+	// the scaled image is premultiplied already, which is what X expects
+	// for 32 bit visuals, xorg is bgr though
+	rgbaToBGRA(img.Pix, threads)
+}
@@ -0,0 +1,184 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// Screenshots save the frame as the window shows it, the scaled image with
+// its opacity and everything drawn over it, to document a visual diff. The
+// frame is read back from the window, so that it is exactly what was
+// presented. Blended screenshots put it over the windows below, like the
+// compositor shows it.
+
+// Screenshot returns the frame the window shows, over the screen below it
+// with blended.
+func (display *Window) Screenshot(blended bool) (*image.RGBA, error) {
+	conn := display.conn
+
+	geom, err := xproto.GetGeometry(conn, xproto.Drawable(display.windowID)).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get geometry: %w", err)
+	}
+
+	const allPlanes = 0xffffffff
+
+	reply, err := xproto.GetImage(conn, xproto.ImageFormatZPixmap, xproto.Drawable(display.windowID), 0, 0, geom.Width, geom.Height, allPlanes).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get image: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(geom.Width), int(geom.Height)))
+	if len(reply.Data) < len(img.Pix) {
+		return nil, fmt.Errorf("get image: expected %d bytes, got %d", len(img.Pix), len(reply.Data))
+	}
+
+	copy(img.Pix, reply.Data)
+
+	// windows without alpha leave garbage in the fourth byte
+	if reply.Depth != depthWithAlpha {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	}
+
+	display.applyOpacityHint(img.Pix)
+
+	if blended {
+		err = display.blendOverScreen(img.Pix, img.Bounds().Size())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// read in the byte order of X, swapping red and blue works both ways
+	rgbaToBGRA(img.Pix, display.options.RenderThreads)
+
+	return img, nil
+}
+
+// applyOpacityHint applies the opacity to pix like the compositor does
+// with the opacity hint.
+func (display *Window) applyOpacityHint(pix []byte) {
+	if display.transparency != TransparencyOpacityHint {
+		return
+	}
+
+	display.renderMu.Lock()
+	opacity := display.imageOpacity
+	display.renderMu.Unlock()
+
+	scale := uint32(opacity * 255)
+	for i := range pix {
+		pix[i] = byte(uint32(pix[i]) * scale / 255)
+	}
+}
+
+// blendOverScreen composites the premultiplied frame in pix, of size, over
+// the screen below the window.
+func (display *Window) blendOverScreen(pix []byte, size image.Point) error {
+	err := display.redirectWindows()
+	if err != nil {
+		return fmt.Errorf("read screen: %w", err)
+	}
+
+	position, err := xproto.TranslateCoordinates(display.conn, display.windowID, display.screen.Root, 0, 0).Reply()
+	if err != nil {
+		return fmt.Errorf("translate coordinates: %w", err)
+	}
+
+	region := image.Rectangle{Max: size}.Add(image.Pt(int(position.DstX), int(position.DstY)))
+
+	below, err := display.captureBelow(region)
+	if err != nil {
+		return fmt.Errorf("capture screen: %w", err)
+	}
+
+	for i := 0; i < len(pix); i += 4 {
+		inverse := 255 - uint32(pix[i+3])
+		pix[i] = byte(uint32(pix[i]) + uint32(below[i])*inverse/255)
+		pix[i+1] = byte(uint32(pix[i+1]) + uint32(below[i+1])*inverse/255)
+		pix[i+2] = byte(uint32(pix[i+2]) + uint32(below[i+2])*inverse/255)
+		pix[i+3] = 0xff
+	}
+
+	return nil
+}
+
+// SaveScreenshot writes a screenshot as png to path, to stdout if it is "-".
+func (display *Window) SaveScreenshot(path string, blended bool) error {
+	img, err := display.Screenshot(blended)
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		return writeScreenshot(os.Stdout, img)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create screenshot: %w", err)
+	}
+
+	err = writeScreenshot(file, img)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("write screenshot: %w", err)
+	}
+
+	return nil
+}
+
+func writeScreenshot(w io.Writer, img image.Image) error {
+	err := png.Encode(w, img)
+	if err != nil {
+		return fmt.Errorf("encode screenshot: %w", err)
+	}
+
+	return nil
+}
+
+// takeScreenshot saves a screenshot to Options.Screenshot.
+func (display *Window) takeScreenshot(blended bool) error {
+	path := display.newScreenshotPath()
+
+	err := display.SaveScreenshot(path, blended)
+	if err != nil {
+		return err
+	}
+
+	// the png itself went to stdout
+	if path != "-" {
+		slog.Info("saved screenshot", "path", path)
+	}
+
+	return nil
+}
+
+// newScreenshotPath returns Options.Screenshot, or a file in it named after
+// the time if it is a directory or empty.
+func (display *Window) newScreenshotPath() string {
+	path := display.options.Screenshot
+
+	info, err := os.Stat(path)
+	if path == "" || err == nil && info.IsDir() {
+		name := "xoverlay-" + time.Now().Format("2006-01-02-150405.000") + ".png"
+		return filepath.Join(path, name)
+	}
+
+	return path
+}
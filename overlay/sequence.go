@@ -0,0 +1,229 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An image sequence, like the frames a renderer writes one by one, is played
+// as an animation at Options.FPS. RescanSequence picks up frames that were
+// added or rewritten since, so that a sequence that is still being rendered
+// can be previewed while it grows.
+
+const DefaultFPS = 24
+
+// the number in a sequence pattern, %d or %04d
+var sequenceVerb = regexp.MustCompile(`%(0?[0-9]*)d`)
+
+// imageSequence is the frames of a sequence that were loaded.
+type imageSequence struct {
+	pattern *regexp.Regexp
+	dir     string
+	delay   time.Duration
+
+	// serializes the scans of the directory
+	mu sync.Mutex
+	// the files of the loaded frames and when they were written
+	paths    []string
+	modTimes []time.Time
+}
+
+type sequenceFile struct {
+	path    string
+	number  int
+	modTime time.Time
+}
+
+// ParseSequence checks that pattern, like "frames/out-%04d.png", has a single
+// number in its file name.
+func ParseSequence(pattern string) error {
+	_, err := sequenceRegexp(pattern)
+	return err
+}
+
+// sequenceRegexp returns a regexp that matches the file names of the frames
+// of pattern, with the number as its group.
+func sequenceRegexp(pattern string) (*regexp.Regexp, error) {
+	if sequenceVerb.MatchString(filepath.Dir(pattern)) {
+		return nil, fmt.Errorf("sequence %q: the number has to be in the file name", pattern)
+	}
+
+	name := filepath.Base(pattern)
+	verbs := sequenceVerb.FindAllStringSubmatchIndex(name, -1)
+	if len(verbs) != 1 {
+		return nil, fmt.Errorf("sequence %q: expected one number like %%04d in the file name", pattern)
+	}
+
+	start, end := verbs[0][0], verbs[0][1]
+	width := name[verbs[0][2]:verbs[0][3]]
+
+	// %04d pads to at least 4 digits, %d doesn't pad at all
+	digits := `[0-9]+`
+	if digitCount, err := strconv.Atoi(width); err == nil && width[0] == '0' {
+		digits = fmt.Sprintf(`[0-9]{%d,}`, digitCount)
+	}
+
+	return regexp.Compile("^" + regexp.QuoteMeta(name[:start]) + "(" + digits + ")" + regexp.QuoteMeta(name[end:]) + "$")
+}
+
+// startSequence loads the frames of pattern that are there already.
+func (display *Window) startSequence(pattern string, fps float64) error {
+	matcher, err := sequenceRegexp(pattern)
+	if err != nil {
+		return err
+	}
+
+	display.sequence = &imageSequence{
+		pattern: matcher,
+		dir:     filepath.Dir(pattern),
+		delay:   time.Duration(float64(time.Second) / fps),
+	}
+
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+		display.RescanSequence()
+	}()
+
+	return nil
+}
+
+// RescanSequence loads the frames of the sequence that were added or
+// rewritten since it was last scanned.
+func (display *Window) RescanSequence() {
+	sequence := display.sequence
+	if sequence == nil {
+		return
+	}
+
+	sequence.mu.Lock()
+	defer sequence.mu.Unlock()
+
+	files, err := sequence.files()
+	if err != nil {
+		slog.Error("scan sequence", "err", err)
+		display.sourceFailed(display.options.Sequence, err)
+		return
+	}
+
+	// the frames before the first one that changed are kept
+	kept := 0
+	for kept < len(files) && kept < len(sequence.paths) &&
+		files[kept].path == sequence.paths[kept] && files[kept].modTime.Equal(sequence.modTimes[kept]) {
+		kept++
+	}
+
+	if kept == len(files) && kept == len(sequence.paths) {
+		return
+	}
+
+	var frames []animationFrame
+	paths := sequence.paths[:kept]
+	modTimes := sequence.modTimes[:kept]
+
+	for _, file := range files[kept:] {
+		decoded, err := loadImage(file.path, false, image.Point{})
+		// most likely still being written, it is loaded with the next scan
+		// and so are the frames after it, to keep them in order
+		if err != nil {
+			break
+		}
+
+		frames = append(frames, animationFrame{image: decoded.image, delay: sequence.delay})
+		paths = append(paths, file.path)
+		modTimes = append(modTimes, file.modTime)
+	}
+
+	sequence.paths = paths
+	sequence.modTimes = modTimes
+
+	display.setSequenceFrames(kept, frames)
+}
+
+// files returns the frames of the sequence in the directory, ordered by
+// their number.
+func (sequence *imageSequence) files() ([]sequenceFile, error) {
+	entries, err := os.ReadDir(sequence.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read sequence directory: %w", err)
+	}
+
+	var files []sequenceFile
+	for _, entry := range entries {
+		match := sequence.pattern.FindStringSubmatch(entry.Name())
+		if match == nil || !entry.Type().IsRegular() {
+			continue
+		}
+
+		number, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, sequenceFile{path: filepath.Join(sequence.dir, entry.Name()), number: number, modTime: info.ModTime()})
+	}
+
+	slices.SortFunc(files, func(a sequenceFile, b sequenceFile) int {
+		return a.number - b.number
+	})
+
+	return files, nil
+}
+
+// setSequenceFrames replaces the frames from kept on with frames, as they
+// were loaded, and keeps playing from where the animation was.
+func (display *Window) setSequenceFrames(kept int, frames []animationFrame) {
+	display.renderMu.Lock()
+	placeholder := display.placeholder
+	rotation := display.rotation
+	filters := display.filters
+	display.renderMu.Unlock()
+
+	// the first frames replace the spinner, like any other image
+	if placeholder {
+		if len(frames) > 0 {
+			display.show(display.options.Sequence, decodedImage{image: frames[0].image, frames: frames}, false)
+		}
+
+		return
+	}
+
+	// what show made of the frames that are kept
+	shown := make([]animationFrame, len(frames))
+	for i, frame := range frames {
+		decoded := display.applyEffects(decodedImage{image: frame.image})
+		decoded = display.options.prepare(decoded, rotation, filters)
+		shown[i] = animationFrame{image: decoded.image, delay: frame.delay}
+	}
+
+	display.renderMu.Lock()
+	// rotating shows the frames as they were loaded again
+	display.loaded.frames = append(display.loaded.frames[:kept:kept], frames...)
+	display.frames = append(display.frames[:kept:kept], shown...)
+	if display.frameIndex >= len(display.frames) {
+		display.frameIndex = 0
+		display.nextFrame = time.Time{}
+	}
+
+	// the last image stays when all frames were removed
+	if len(display.frames) > 0 {
+		display.image = display.frames[display.frameIndex].image
+	}
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
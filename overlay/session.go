@@ -0,0 +1,98 @@
+package overlay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// Session managers restart clients with the command in WM_COMMAND. We take
+// part in the ICCCM session protocol: when the session is saved the session
+// manager sends WM_SAVE_YOURSELF and we update WM_COMMAND with the current
+// state of the overlay, so it comes back where it was after logging in again.
+// XSMP, the protocol of libSM, isn't spoken: session managers that only
+// speak it, like the ones of GNOME, don't restart us, the ones of KDE and
+// xfce restart such legacy clients.
+
+// sessionCommand returns the command that restores the current state.
+func (display *Window) sessionCommand() ([]string, error) {
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return nil, err
+	}
+
+	display.renderMu.Lock()
+	opacity := display.targetOpacity()
+	display.renderMu.Unlock()
+
+	command := append([]string{}, display.options.RestartArgs...)
+	command = append(command,
+		"--opacity="+strconv.FormatFloat(opacity, 'f', -1, 64),
+		"--x="+strconv.Itoa(x),
+		"--y="+strconv.Itoa(y),
+		"--width="+strconv.Itoa(display.windowWidth),
+		"--height="+strconv.Itoa(display.windowHeight),
+		"--",
+	)
+
+	// the session is restored in another working directory
+	for _, image := range display.sessionImages() {
+		path, err := filepath.Abs(image)
+		if err != nil {
+			return nil, fmt.Errorf("resolve image path: %w", err)
+		}
+
+		command = append(command, path)
+	}
+
+	return command, nil
+}
+
+// sessionImages returns the images the restart command shows, none if it
+// finds them itself.
+func (display *Window) sessionImages() []string {
+	if display.options.RestartHasImages {
+		return nil
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.images
+}
+
+// setupSession sets what the session manager needs to start us again, that
+// we take part is announced in WM_PROTOCOLS. It is called before the window
+// is mapped.
+func (display *Window) setupSession() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("get hostname: %w", err)
+	}
+
+	display.setStringsProperty(xproto.AtomWmClientMachine, hostname)
+
+	command := append([]string{}, display.options.RestartArgs...)
+	command = append(command, "--")
+	command = append(command, display.sessionImages()...)
+
+	display.setStringsProperty(xproto.AtomWmCommand, command...)
+
+	return nil
+}
+
+// saveSession answers WM_SAVE_YOURSELF. The session manager waits until
+// WM_COMMAND has been written, even if it didn't change.
+func (display *Window) saveSession() error {
+	command, err := display.sessionCommand()
+	if err != nil {
+		return err
+	}
+
+	display.setStringsProperty(xproto.AtomWmCommand, command...)
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package overlay
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// Text is drawn one glyph per character from left to right, which is only
+// right for scripts written that way. visualOrder turns a line into the
+// order its glyphs are drawn in, right to left scripts like hebrew and
+// arabic reversed, and arabic letters into the forms they take next to
+// each other.
+
+// the arabic letters that have presentation forms, and the ones that are
+// treated differently
+const (
+	arabicFirst = 0x0621
+	arabicLast  = 0x064a
+	tatweel     = 0x0640
+	lam         = 0x0644
+)
+
+// arabicForms are the isolated forms of the letters from arabicFirst on,
+// followed by the final, initial and medial forms for letters that join on
+// both sides, or only the final form for letters that only join to the
+// letter before them. Zero for what has no forms.
+var arabicForms = [arabicLast - arabicFirst + 1]struct {
+	isolated rune
+	dual     bool
+}{
+	{}, // hamza, which doesn't join at all
+	{0xfe81, false}, {0xfe83, false}, {0xfe85, false}, {0xfe87, false},
+	{0xfe89, true}, {0xfe8d, false}, {0xfe8f, true}, {0xfe93, false},
+	{0xfe95, true}, {0xfe99, true}, {0xfe9d, true}, {0xfea1, true},
+	{0xfea5, true}, {0xfea9, false}, {0xfeab, false}, {0xfead, false},
+	{0xfeaf, false}, {0xfeb1, true}, {0xfeb5, true}, {0xfeb9, true},
+	{0xfebd, true}, {0xfec1, true}, {0xfec5, true}, {0xfec9, true},
+	{0xfecd, true},
+	{}, {}, {}, {}, {}, {}, // 063b to 0640, tatweel is handled on its own
+	{0xfed1, true}, {0xfed5, true}, {0xfed9, true}, {0xfedd, true},
+	{0xfee1, true}, {0xfee5, true}, {0xfee9, true}, {0xfeed, false},
+	{0xfeef, false}, {0xfef1, true},
+}
+
+// lamAlef are the isolated ligatures of lam with the alefs, the final form
+// follows each of them.
+var lamAlef = map[rune]rune{
+	0x0622: 0xfef5,
+	0x0623: 0xfef7,
+	0x0625: 0xfef9,
+	0x0627: 0xfefb,
+}
+
+// visualOrder returns line in the order its glyphs are drawn from left to
+// right, with arabic letters in their joined forms.
+func visualOrder(line string) string {
+	// latin, greek and cyrillic text is left alone without looking at it
+	// any further, that's what a clock shows every second
+	rtl := false
+	for _, r := range line {
+		if r >= 0x0590 {
+			rtl = true
+			break
+		}
+	}
+
+	if !rtl {
+		return line
+	}
+
+	runes := shapeArabic([]rune(line))
+
+	direction := paragraphDirection(runes)
+
+	var p bidi.Paragraph
+	_, err := p.SetString(string(runes), bidi.DefaultDirection(direction))
+	if err != nil {
+		return string(runes)
+	}
+
+	ordering, err := p.Order()
+	if err != nil {
+		return string(runes)
+	}
+
+	// the runs alternate between the directions, right to left runs are
+	// reversed and in a right to left paragraph the runs are as well
+	parts := make([]string, ordering.NumRuns())
+	for i := range parts {
+		run := ordering.Run(i)
+		parts[i] = run.String()
+		if run.Direction() == bidi.RightToLeft {
+			parts[i] = reverseRun(parts[i])
+		}
+	}
+
+	if direction == bidi.RightToLeft {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	return strings.Join(parts, "")
+}
+
+// reverseRun reverses a right to left run like bidi.ReverseString, which
+// mirrors brackets as well, but keeps marks like vowels after the letter
+// they are drawn over.
+func reverseRun(run string) string {
+	reversed := []rune(bidi.ReverseString(run))
+	ordered := make([]rune, 0, len(reversed))
+
+	// the marks before a letter now, which belong to it
+	marks := 0
+	for i, r := range reversed {
+		properties, _ := bidi.LookupRune(r)
+		if properties.Class() == bidi.NSM {
+			marks++
+			continue
+		}
+
+		ordered = append(ordered, r)
+		ordered = append(ordered, reversed[i-marks:i]...)
+		slices.Reverse(ordered[len(ordered)-marks:])
+		marks = 0
+	}
+
+	return string(append(ordered, reversed[len(reversed)-marks:]...))
+}
+
+// paragraphDirection is the direction of the first letter that has one,
+// left to right if there is none.
+func paragraphDirection(runes []rune) bidi.Direction {
+	for _, r := range runes {
+		properties, _ := bidi.LookupRune(r)
+		switch properties.Class() {
+		case bidi.L:
+			return bidi.LeftToRight
+		case bidi.R, bidi.AL:
+			return bidi.RightToLeft
+		}
+	}
+
+	return bidi.LeftToRight
+}
+
+// shapeArabic replaces the arabic letters of runes, in logical order, with
+// the forms they take next to the letters around them.
+func shapeArabic(runes []rune) []rune {
+	shaped := make([]rune, 0, len(runes))
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		dual, ok := arabicJoining(r)
+		if !ok || r == tatweel {
+			shaped = append(shaped, r)
+			continue
+		}
+
+		before := arabicJoinsAfter(runes, i)
+		next := i + 1
+		for next < len(runes) && arabicTransparent(runes[next]) {
+			next++
+		}
+
+		// lam and alef are always drawn as one glyph
+		if ligature, ok := lamAlef[runeAt(runes, next)]; r == lam && ok && next == i+1 {
+			if before {
+				ligature++
+			}
+
+			shaped = append(shaped, ligature)
+			i = next
+			continue
+		}
+
+		_, after := arabicJoining(runeAt(runes, next))
+		after = after && dual
+
+		form := arabicForms[r-arabicFirst].isolated
+		switch {
+		case before && after:
+			form += 3
+		case after:
+			form += 2
+		case before:
+			form++
+		}
+
+		shaped = append(shaped, form)
+	}
+
+	return shaped
+}
+
+// arabicJoining reports whether r joins to the letter before it, and
+// whether it joins to the one after it as well.
+func arabicJoining(r rune) (dual bool, ok bool) {
+	if r == tatweel {
+		return true, true
+	}
+
+	if r < arabicFirst || r > arabicLast {
+		return false, false
+	}
+
+	form := arabicForms[r-arabicFirst]
+	if form.isolated == 0 {
+		return false, false
+	}
+
+	return form.dual, true
+}
+
+// arabicJoinsAfter reports whether the letter before runes[i], skipping
+// vowel marks, joins to the letter after it.
+func arabicJoinsAfter(runes []rune, i int) bool {
+	for i--; i >= 0; i-- {
+		if arabicTransparent(runes[i]) {
+			continue
+		}
+
+		dual, _ := arabicJoining(runes[i])
+		return dual
+	}
+
+	return false
+}
+
+// arabicTransparent reports whether r is a mark that doesn't break the
+// joining of the letters around it, like the short vowels.
+func arabicTransparent(r rune) bool {
+	return r >= 0x064b && r <= 0x065f || r == 0x0670
+}
+
+func runeAt(runes []rune, i int) rune {
+	if i < len(runes) {
+		return runes[i]
+	}
+
+	return 0
+}
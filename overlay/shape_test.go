@@ -0,0 +1,62 @@
+package overlay
+
+import "testing"
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"latin", "v2 mockup 12:30", "v2 mockup 12:30"},
+		{"accents and cyrillic", "Grüße, привет", "Grüße, привет"},
+		{"cjk", "レビュー 2", "レビュー 2"},
+		{"hebrew", "שלום", "םולש"},
+		{"hebrew in latin", "abc אבג def", "abc גבא def"},
+		{"latin in hebrew", "אבג abc", "abc גבא"},
+		{"numbers in hebrew", "אבג 123 דה", "הד 123 גבא"},
+		{"mirrored brackets", "שלום (world)!", "!(world) םולש"},
+		{"arabic", "سلام", "ﻡﻼﺳ"},
+		{"arabic and time", "12:30 بيت", "ﺖﻴﺑ 12:30"},
+		{"vowel after its letter", "بَيت", "ﺖﻴﺑَ"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := visualOrder(test.line)
+			if got != test.want {
+				t.Errorf("visualOrder(%q) = %q, want %q", test.line, got, test.want)
+			}
+		})
+	}
+}
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"isolated", "ب", "ﺏ"},
+		{"initial medial final", "بيت", "ﺑﻴﺖ"},
+		// dal only joins to the letter before it, the next one starts over
+		{"right joining", "دب", "ﺩﺏ"},
+		{"after right joining", "بدب", "ﺑﺪﺏ"},
+		{"lam alef", "لا", "ﻻ"},
+		{"lam alef final", "سلا", "ﺳﻼ"},
+		{"hamza doesn't join", "بءب", "ﺏءﺏ"},
+		{"tatweel", "بـب", "ﺑـﺐ"},
+		{"through vowels", "بَب", "ﺑَﺐ"},
+		{"words", "ب ب", "ﺏ ﺏ"},
+		{"not arabic", "abc", "abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(shapeArabic([]rune(test.text)))
+			if got != test.want {
+				t.Errorf("shapeArabic(%q) = %+q, want %+q", test.text, got, test.want)
+			}
+		})
+	}
+}
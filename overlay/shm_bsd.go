@@ -0,0 +1,62 @@
+//go:build freebsd || netbsd || dragonfly
+
+package overlay
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// golang.org/x/sys has no System V shared memory on the BSDs, the system
+// calls are made directly. The constants are the same on all of them.
+
+const shmSupported = true
+
+const (
+	ipcPrivate = 0
+	ipcCreat   = 0o1000
+	ipcExcl    = 0o2000
+	ipcRmid    = 0
+)
+
+func shmCreate(size int) (int, error) {
+	id, _, errno := unix.Syscall(unix.SYS_SHMGET, ipcPrivate, uintptr(size), ipcCreat|ipcExcl|0o600)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(id), nil
+}
+
+// shmAttach maps the segment id, which is size bytes large.
+func shmAttach(id int, size int) ([]byte, error) {
+	addr, _, errno := unix.Syscall(unix.SYS_SHMAT, uintptr(id), 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// the segment is outside of the Go heap, the address stays valid until
+	// it is detached
+	data := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+
+	return unsafe.Slice((*byte)(data), size), nil
+}
+
+func shmDetach(data []byte) error {
+	_, _, errno := unix.Syscall(unix.SYS_SHMDT, uintptr(unsafe.Pointer(unsafe.SliceData(data))), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func shmRemove(id int) error {
+	_, _, errno := unix.Syscall(unix.SYS_SHMCTL, uintptr(id), ipcRmid, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !linux && !(darwin && !ios) && !freebsd && !netbsd && !dragonfly
+
+package overlay
+
+import "errors"
+
+// Elsewhere, like on OpenBSD whose libc is the only way to make system
+// calls, the pixels are sent over the connection.
+
+const shmSupported = false
+
+var errShmUnsupported = errors.New("shared memory is not supported on this platform")
+
+func shmCreate(int) (int, error) {
+	return 0, errShmUnsupported
+}
+
+func shmAttach(int, int) ([]byte, error) {
+	return nil, errShmUnsupported
+}
+
+func shmDetach([]byte) error {
+	return errShmUnsupported
+}
+
+func shmRemove(int) error {
+	return errShmUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build linux || (darwin && !ios)
+
+package overlay
+
+import "golang.org/x/sys/unix"
+
+// the System V shared memory calls of golang.org/x/sys
+
+const shmSupported = true
+
+func shmCreate(size int) (int, error) {
+	return unix.SysvShmGet(unix.IPC_PRIVATE, size, unix.IPC_CREAT|unix.IPC_EXCL|0o600)
+}
+
+func shmAttach(id int, _ int) ([]byte, error) {
+	return unix.SysvShmAttach(id, 0, 0)
+}
+
+func shmDetach(data []byte) error {
+	return unix.SysvShmDetach(data)
+}
+
+func shmRemove(id int) error {
+	_, err := unix.SysvShmCtl(id, unix.IPC_RMID, nil)
+	return err
+}
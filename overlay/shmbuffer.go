@@ -0,0 +1,107 @@
+package overlay
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/shm"
+)
+
+// shmSegment is a shared memory segment attached by both us and the X server,
+// so that images can be uploaded without sending the pixels over the socket.
+// It is kept around between renders and only replaced when it is too small.
+// The system calls are in shm_*.go for every platform.
+type shmSegment struct {
+	conn  *xgb.Conn
+	segID shm.Seg
+	data  []byte
+}
+
+func newShmSegment(conn *xgb.Conn, size int) (*shmSegment, error) {
+	shmID, err := shmCreate(size)
+	if err != nil {
+		return nil, fmt.Errorf("create shared memory segment: %w", err)
+	}
+
+	// it is important to remove the shared memory segment because it
+	// persists even if the process is destroyed. Once it is marked for
+	// removal it is destroyed as soon as both we and the X server have
+	// detached from it, so we can do that right after attaching.
+	defer func() {
+		err := shmRemove(shmID)
+		if err != nil {
+			slog.Error("destroy shared memory segment", "err", err)
+		}
+	}()
+
+	data, err := shmAttach(shmID, size)
+	if err != nil {
+		return nil, fmt.Errorf("attach to shared memory segment: %w", err)
+	}
+
+	segID, err := shm.NewSegId(conn)
+	if err != nil {
+		shmDetach(data)
+		return nil, fmt.Errorf("new segment id: %w", err)
+	}
+
+	err = shm.AttachChecked(conn, segID, uint32(shmID), false).Check()
+	if err != nil {
+		shmDetach(data)
+		return nil, fmt.Errorf("attach to shared memory segment (X): %w", describeXError(conn, err))
+	}
+
+	return &shmSegment{
+		conn:  conn,
+		segID: segID,
+		data:  data,
+	}, nil
+}
+
+func (buffer *shmSegment) Bytes() []byte {
+	return buffer.data
+}
+
+func (buffer *shmSegment) Close() error {
+	err := shm.DetachChecked(buffer.conn, buffer.segID).Check()
+	if err != nil {
+		return fmt.Errorf("detach from shared memory (X): %w", err)
+	}
+
+	err = shmDetach(buffer.data)
+	if err != nil {
+		return fmt.Errorf("detach from shared memory segment: %w", err)
+	}
+
+	return nil
+}
+
+// shmBufferFor returns a buffer of at least size bytes. The first buffer is
+// large enough for the whole screen, so that resizing the window usually
+// doesn't require a new one.
+func (display *Window) shmBufferFor(size int) (*shmSegment, error) {
+	if display.shmBuffer != nil && len(display.shmBuffer.Bytes()) >= size {
+		return display.shmBuffer, nil
+	}
+
+	if display.shmBuffer != nil {
+		err := display.shmBuffer.Close()
+		if err != nil {
+			slog.Error("close shared memory buffer", "err", err)
+		}
+
+		display.shmBuffer = nil
+	}
+
+	screenSize := int(display.screen.WidthInPixels) * int(display.screen.HeightInPixels) * 4
+
+	buffer, err := newShmSegment(display.conn, max(size, screenSize))
+	if err != nil {
+		return nil, err
+	}
+
+	display.shmBuffer = buffer
+
+	return buffer, nil
+}
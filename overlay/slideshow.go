@@ -0,0 +1,154 @@
+package overlay
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Slideshow advances to the next image every Interval, fading between the
+// images for Crossfade. With Once it stops after the last image. A Playlist
+// has a slide for every image, in the same order, that can show it for
+// longer or shorter, at another opacity or with another transition.
+type Slideshow struct {
+	Interval  time.Duration
+	Crossfade time.Duration
+	Once      bool
+	Playlist  []Slide
+}
+
+// slide returns how the image at index is shown.
+func (show Slideshow) slide(index int) (duration time.Duration, crossfade time.Duration, opacity *float64) {
+	duration = show.Interval
+	crossfade = show.Crossfade
+
+	if index < 0 || index >= len(show.Playlist) {
+		return duration, crossfade, nil
+	}
+
+	slide := show.Playlist[index]
+	if slide.Duration > 0 {
+		duration = slide.Duration
+	}
+
+	if slide.Crossfade != nil {
+		crossfade = *slide.Crossfade
+	}
+
+	return duration, crossfade, slide.Opacity
+}
+
+// RunSlideshow advances through the images every interval until ctx is
+// done. With once the window is closed after the last image has been shown.
+func (display *Window) RunSlideshow(ctx context.Context, show Slideshow) {
+	display.renderMu.Lock()
+	index := display.imageIndex
+	display.slideshowRunning = true
+	display.renderMu.Unlock()
+
+	defer func() {
+		display.renderMu.Lock()
+		display.slideshowRunning = false
+		display.renderMu.Unlock()
+	}()
+
+	if _, _, opacity := show.slide(index); opacity != nil {
+		display.setOpacity(*opacity)
+	}
+
+	for {
+		display.renderMu.Lock()
+		index := display.imageIndex
+		last := index == len(display.images)-1
+		display.renderMu.Unlock()
+
+		interval, _, _ := show.slide(index)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if last && show.Once {
+			err := display.quit()
+			if err != nil {
+				slog.Error("end slideshow", "err", err)
+			}
+
+			return
+		}
+
+		// the next slide is faded in the way it asks for, random images
+		// don't have one
+		next := -1
+		if len(show.Playlist) > 0 {
+			next = (index + 1) % len(show.Playlist)
+		}
+
+		_, crossfade, opacity := show.slide(next)
+
+		err := display.crossfade(ctx, crossfade, opacity, func() error {
+			return display.cycleImage(1)
+		})
+		if err != nil {
+			slog.Error("next slide", "err", err)
+		}
+	}
+}
+
+// crossfade fades the window out, calls change and fades it back in to to,
+// or the opacity it had before if nil, taking duration in total.
+func (display *Window) crossfade(ctx context.Context, duration time.Duration, to *float64, change func() error) error {
+	display.renderMu.Lock()
+	count := len(display.images)
+	display.renderMu.Unlock()
+
+	if duration <= 0 || count < 2 {
+		err := change()
+		if to != nil {
+			display.setOpacity(*to)
+		}
+
+		return err
+	}
+
+	opacity := display.opacity()
+
+	display.fade(ctx, opacity, 0, duration/2)
+
+	err := change()
+
+	if to != nil {
+		opacity = *to
+	}
+
+	display.fade(ctx, 0, opacity, duration/2)
+
+	return err
+}
+
+func (display *Window) fade(ctx context.Context, from float64, to float64, duration time.Duration) {
+	// every step has to outlast the redraw debounce or nothing would be
+	// drawn until the fade is over
+	step := 2 * display.debounce(redrawDebounce)
+	start := time.Now()
+
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			break
+		}
+
+		progress := float64(elapsed) / float64(duration)
+		display.setOpacity(from + (to-from)*progress)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(step):
+		}
+	}
+
+	display.setOpacity(to)
+}
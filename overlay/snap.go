@@ -0,0 +1,228 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// ErrSnapCancelled is returned by Snap when the selection is cancelled with
+// escape or the right mouse button.
+var ErrSnapCancelled = errors.New("selection cancelled")
+
+// the crosshair of the standard cursor font
+const crosshairGlyph = 34
+
+// Snap lets the user drag a rectangle over the screen with the left mouse
+// button and returns what is in it, and where it is on the screen.
+func Snap() (image.Image, image.Rectangle, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, image.Rectangle{}, fmt.Errorf("%w: %w", ErrNoDisplay, err)
+	}
+	defer conn.Close()
+
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+
+	region, err := selectRegion(conn, screen)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	region = region.Intersect(image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels)))
+	if region.Empty() {
+		return nil, image.Rectangle{}, fmt.Errorf("the selected region is outside of the screen")
+	}
+
+	img, err := captureRegion(conn, screen, region)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	return img, region, nil
+}
+
+// selectRegion grabs the pointer and draws the rectangle that is dragged
+// onto the screen, inverted so that drawing it again removes it.
+func selectRegion(conn *xgb.Conn, screen *xproto.ScreenInfo) (image.Rectangle, error) {
+	root := screen.Root
+
+	cursor, err := crosshairCursor(conn)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	defer xproto.FreeCursor(conn, cursor)
+
+	const pointerEvents = xproto.EventMaskButtonPress | xproto.EventMaskButtonRelease | xproto.EventMaskPointerMotion
+
+	grab, err := xproto.GrabPointer(conn, false, root, pointerEvents, xproto.GrabModeAsync, xproto.GrabModeAsync, xproto.WindowNone, cursor, xproto.TimeCurrentTime).Reply()
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("grab pointer: %w", err)
+	}
+	if grab.Status != xproto.GrabStatusSuccess {
+		return image.Rectangle{}, fmt.Errorf("grab pointer: another client has grabbed it")
+	}
+	defer xproto.UngrabPointer(conn, xproto.TimeCurrentTime)
+
+	// only for escape, the selection works without it
+	keyboard, err := xproto.GrabKeyboard(conn, false, root, xproto.TimeCurrentTime, xproto.GrabModeAsync, xproto.GrabModeAsync).Reply()
+	if err == nil && keyboard.Status == xproto.GrabStatusSuccess {
+		defer xproto.UngrabKeyboard(conn, xproto.TimeCurrentTime)
+	}
+
+	gc, err := xproto.NewGcontextId(conn)
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("new graphics context id: %w", err)
+	}
+
+	// drawn over the windows on the root window
+	err = xproto.CreateGCChecked(conn, gc, xproto.Drawable(root),
+		xproto.GcFunction|xproto.GcForeground|xproto.GcSubwindowMode,
+		[]uint32{xproto.GxXor, screen.WhitePixel ^ screen.BlackPixel, xproto.SubwindowModeIncludeInferiors}).Check()
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("create graphics context: %w", err)
+	}
+	defer xproto.FreeGC(conn, gc)
+
+	var start image.Point
+	var selection image.Rectangle
+	dragging := false
+
+	invert := func(r image.Rectangle) {
+		// the outline is drawn around the pixels, one less wide and high
+		outline, err := xRectangle(image.Rectangle{Min: r.Min, Max: r.Max.Sub(image.Pt(1, 1))})
+		if r.Dx() > 0 && r.Dy() > 0 && err == nil {
+			xproto.PolyRectangle(conn, xproto.Drawable(root), gc, []xproto.Rectangle{outline})
+		}
+	}
+
+	for {
+		ev, err := conn.WaitForEvent()
+		if ev == nil && err == nil {
+			return image.Rectangle{}, fmt.Errorf("connection closed")
+		}
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("select region: %w", err)
+		}
+
+		switch event := ev.(type) {
+		case xproto.ButtonPressEvent:
+			if event.Detail != buttonLeft {
+				invert(selection)
+				return image.Rectangle{}, ErrSnapCancelled
+			}
+
+			start = image.Pt(int(event.RootX), int(event.RootY))
+			dragging = true
+		case xproto.MotionNotifyEvent:
+			if !dragging {
+				continue
+			}
+
+			invert(selection)
+			// the pixel under the pointer belongs to the selection
+			selection = image.Rectangle{Min: start, Max: image.Pt(int(event.RootX), int(event.RootY))}.Canon()
+			selection.Max = selection.Max.Add(image.Pt(1, 1))
+			invert(selection)
+		case xproto.ButtonReleaseEvent:
+			if !dragging || event.Detail != buttonLeft {
+				continue
+			}
+
+			invert(selection)
+
+			// the inverted rectangle is gone before the screen is captured
+			_, err := xproto.GetInputFocus(conn).Reply()
+			if err != nil {
+				return image.Rectangle{}, fmt.Errorf("sync: %w", err)
+			}
+
+			if selection.Dx() < 2 || selection.Dy() < 2 {
+				return image.Rectangle{}, fmt.Errorf("the selected region is empty")
+			}
+
+			return selection, nil
+		case xproto.KeyPressEvent:
+			mapping, err := xproto.GetKeyboardMapping(conn, event.Detail, 1).Reply()
+			if err == nil && len(mapping.Keysyms) > 0 && mapping.Keysyms[0] == keysymEscape {
+				invert(selection)
+				return image.Rectangle{}, ErrSnapCancelled
+			}
+		}
+	}
+}
+
+// crosshairCursor returns the crosshair of the cursor font.
+func crosshairCursor(conn *xgb.Conn) (xproto.Cursor, error) {
+	const name = "cursor"
+
+	font, err := xproto.NewFontId(conn)
+	if err != nil {
+		return 0, fmt.Errorf("new font id: %w", err)
+	}
+
+	err = xproto.OpenFontChecked(conn, font, uint16(len(name)), name).Check()
+	if err != nil {
+		return 0, fmt.Errorf("open cursor font: %w", err)
+	}
+	defer xproto.CloseFont(conn, font)
+
+	cursor, err := xproto.NewCursorId(conn)
+	if err != nil {
+		return 0, fmt.Errorf("new cursor id: %w", err)
+	}
+
+	// black on white
+	err = xproto.CreateGlyphCursorChecked(conn, cursor, font, font, crosshairGlyph, crosshairGlyph+1, 0, 0, 0, 0xffff, 0xffff, 0xffff).Check()
+	if err != nil {
+		return 0, fmt.Errorf("create cursor: %w", err)
+	}
+
+	return cursor, nil
+}
+
+// captureRegion returns what is shown in region of the screen, which has to
+// be on it.
+func captureRegion(conn *xgb.Conn, screen *xproto.ScreenInfo, region image.Rectangle) (image.Image, error) {
+	const allPlanes = 0xffffffff
+
+	area, err := xRectangle(region)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := xproto.GetImage(
+		conn,
+		xproto.ImageFormatZPixmap,
+		xproto.Drawable(screen.Root),
+		area.X,
+		area.Y,
+		area.Width,
+		area.Height,
+		allPlanes,
+	).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("get image: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	if len(reply.Data) < len(img.Pix) {
+		return nil, fmt.Errorf("get image: expected %d bytes, got %d", len(img.Pix), len(reply.Data))
+	}
+
+	copy(img.Pix, reply.Data)
+
+	// the pixels are bgrx, swapping red and blue works both ways, the root
+	// window has no alpha channel
+	rgbaToBGRA(img.Pix, runtime.GOMAXPROCS(0))
+
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+
+	return img, nil
+}
@@ -0,0 +1,294 @@
+package overlay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// A Source produces the images of the overlay while it runs, instead of a
+// file, and pushes them into the window through a SourceSink whenever there
+// is a new one. The URL and figma images are sources, as are the built in
+// ones of ParseSource:
+//
+//	clock                    the time, 15:04:05
+//	clock=15:04              the time in a Go time layout
+//	countdown=10m            the time left, until 00:00
+//	command=render-chart.sh  the image the command prints, run again and again
+type Source interface {
+	// Name is shown as the source of the images.
+	Name() string
+	// Run pushes images into sink until ctx is done, or returns an error if
+	// the source can't produce any more.
+	Run(ctx context.Context, sink SourceSink) error
+}
+
+// SourceSink is where a Source pushes its images.
+type SourceSink interface {
+	// Show shows img.
+	Show(img image.Image)
+	// ShowEncoded decodes and shows an image file.
+	ShowEncoded(data []byte) error
+	// ShowText shows text in large letters.
+	ShowText(text string)
+	// Unchanged reports that the image shown is still current.
+	Unchanged()
+	// Fail shows that the source failed, until the next image arrives.
+	Fail(err error)
+}
+
+const (
+	defaultCommandInterval = 10 * time.Second
+	// a command that hangs is killed after this
+	commandTimeout = time.Minute
+	sourceFontSize = 96
+)
+
+// sourceSink pushes the images of a source into the window.
+type sourceSink struct {
+	display *Window
+	name    string
+}
+
+func (sink sourceSink) Show(img image.Image) {
+	sink.display.setLiveImage(sink.name, decodedImage{image: img})
+}
+
+func (sink sourceSink) ShowEncoded(data []byte) error {
+	display := sink.display
+
+	decoded, err := decodeImage(data, display.options.Animate, display.decodeTarget())
+	if err != nil {
+		return err
+	}
+
+	display.setLiveImage(sink.name, decoded)
+
+	return nil
+}
+
+func (sink sourceSink) ShowText(text string) {
+	sink.Show(renderSourceText(sourceFace(), text, sink.display.options.TextColor))
+}
+
+func (sink sourceSink) Unchanged() {
+	sink.display.markFresh()
+}
+
+func (sink sourceSink) Fail(err error) {
+	sink.display.sourceFailed(sink.name, err)
+}
+
+// sourceFace is the Go font large enough to read a clock from across the
+// room.
+var sourceFace = sync.OnceValue(func() font.Face {
+	face, _ := LoadFont("", sourceFontSize)
+	return face
+})
+
+// renderSourceText draws text in c on a transparent image just large enough
+// for it.
+func renderSourceText(face font.Face, text string, c color.RGBA) *image.RGBA {
+	metrics := face.Metrics()
+	width := max(1, font.MeasureString(face, text).Ceil())
+
+	img := image.NewRGBA(image.Rect(0, 0, width, metrics.Height.Ceil()))
+
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(0, metrics.Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+
+	return img
+}
+
+// startSource runs src until the window is closed.
+func (display *Window) startSource(src Source) {
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+
+		err := src.Run(display.ctx, sourceSink{display: display, name: src.Name()})
+		if err != nil && display.ctx.Err() == nil {
+			slog.Error("source", "source", src.Name(), "err", err)
+			display.sourceFailed(src.Name(), err)
+		}
+	}()
+}
+
+// ParseSource returns the built in source spec names, a command is run every
+// interval, every 10 seconds if it is zero.
+func ParseSource(spec string, interval time.Duration) (Source, error) {
+	name, value, hasValue := strings.Cut(spec, "=")
+
+	switch name {
+	case "clock":
+		layout := "15:04:05"
+		if hasValue && value != "" {
+			layout = value
+		}
+
+		return clockSource{layout: layout}, nil
+	case "countdown":
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid countdown %q, expected a duration like 10m", value)
+		}
+
+		return countdownSource{duration: duration}, nil
+	case "command":
+		args := strings.Fields(value)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("source command: no command")
+		}
+
+		if interval <= 0 {
+			interval = defaultCommandInterval
+		}
+
+		return commandSource{args: args, interval: interval}, nil
+	}
+
+	return nil, fmt.Errorf("unknown source %q, expected clock, countdown=DURATION or command=COMMAND", spec)
+}
+
+// clockSource shows the time.
+type clockSource struct {
+	layout string
+}
+
+func (clock clockSource) Name() string {
+	return "clock"
+}
+
+func (clock clockSource) Run(ctx context.Context, sink SourceSink) error {
+	for {
+		now := time.Now()
+		sink.ShowText(now.Format(clock.layout))
+
+		// ticks on the second, so that it doesn't lag behind other clocks
+		select {
+		case <-time.After(now.Truncate(time.Second).Add(time.Second).Sub(now)):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// countdownSource shows the time left from the start on.
+type countdownSource struct {
+	duration time.Duration
+}
+
+func (countdown countdownSource) Name() string {
+	return "countdown"
+}
+
+func (countdown countdownSource) Run(ctx context.Context, sink SourceSink) error {
+	end := time.Now().Add(countdown.duration)
+
+	for {
+		// rounded up, so that 00:00 is only shown when the time is up
+		left := max(0, time.Until(end)+time.Second-1).Truncate(time.Second)
+		sink.ShowText(formatCountdown(left))
+
+		if left == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Until(end.Add(-left + time.Second))):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// formatCountdown returns left as 04:59, or 1:04:59 from an hour on.
+func formatCountdown(left time.Duration) string {
+	seconds := int(left / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
+
+// commandSource shows the image a command prints on its output, e.g. a
+// script that renders a chart, and runs it again every interval.
+type commandSource struct {
+	args     []string
+	interval time.Duration
+}
+
+func (command commandSource) Name() string {
+	return "command:" + strings.Join(command.args, " ")
+}
+
+func (command commandSource) Run(ctx context.Context, sink SourceSink) error {
+	var shown []byte
+
+	for {
+		output, err := command.run(ctx)
+		if err == nil && bytes.Equal(output, shown) {
+			sink.Unchanged()
+		} else if err == nil {
+			err = sink.ShowEncoded(output)
+			if err == nil {
+				shown = output
+			}
+		}
+
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("source command", "command", command.Name(), "err", err)
+			sink.Fail(err)
+		}
+
+		select {
+		case <-time.After(command.interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// run runs the command once and returns what it printed.
+func (command commandSource) run(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command.args[0], command.args[1:]...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message != "" {
+			return nil, fmt.Errorf("run %s: %w: %s", command.args[0], err, message)
+		}
+
+		return nil, fmt.Errorf("run %s: %w", command.args[0], err)
+	}
+
+	if len(output) == 0 {
+		return nil, fmt.Errorf("run %s: printed no image", command.args[0])
+	}
+
+	return output, nil
+}
@@ -0,0 +1,171 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/jezek/xgb/shape"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Split divides the window into a half that shows the image and a half that
+// shows what is below, to wipe between them like a before and after slider.
+type Split string
+
+const (
+	SplitNone Split = ""
+	// the image is on the left of a vertical divider
+	SplitVertical Split = "v"
+	// the image is above a horizontal divider
+	SplitHorizontal Split = "h"
+)
+
+const (
+	// thickness of the divider, and how far from it a click still grabs it
+	splitWidth = 2
+	splitReach = 8
+)
+
+func ParseSplit(name string) (Split, error) {
+	switch split := Split(name); split {
+	case SplitVertical, SplitHorizontal:
+		return split, nil
+	}
+
+	return "", fmt.Errorf("unknown split %q, expected v or h", name)
+}
+
+// setupSplit checks that the server can shape windows, the half without the
+// image is cut out of the window so that it doesn't cover what is below in
+// any transparency mode, clicks included.
+func (display *Window) setupSplit() error {
+	err := shape.Init(display.conn)
+	if err != nil {
+		return fmt.Errorf("init shape: %w", err)
+	}
+
+	display.renderMu.Lock()
+	display.splitPosition = 0.5
+	display.renderMu.Unlock()
+
+	return nil
+}
+
+// splitSide returns the part of window that shows the image with the
+// divider at position, which always keeps the divider to grab.
+func (display *Window) splitSide(window image.Rectangle, position float64) image.Rectangle {
+	side := window
+
+	if display.options.Split == SplitVertical {
+		side.Max.X = max(splitWidth, int(position*float64(window.Dx())))
+	} else {
+		side.Max.Y = max(splitWidth, int(position*float64(window.Dy())))
+	}
+
+	return side.Intersect(window)
+}
+
+// splitDivider returns the divider at the edge of side.
+func (display *Window) splitDivider(side image.Rectangle) image.Rectangle {
+	divider := side
+
+	if display.options.Split == SplitVertical {
+		divider.Min.X = side.Max.X - splitWidth
+	} else {
+		divider.Min.Y = side.Max.Y - splitWidth
+	}
+
+	return divider
+}
+
+// setSplitShape cuts the window down to side, if it changed since it was
+// last set. Only used by the render loop.
+func (display *Window) setSplitShape(side image.Rectangle) error {
+	if side == display.splitShape {
+		return nil
+	}
+
+	rect, err := xRectangle(side)
+	if err != nil {
+		return fmt.Errorf("set window shape: %w", err)
+	}
+
+	err = shape.RectanglesChecked(display.conn, shape.SoSet, shape.SkBounding, xproto.ClipOrderingUnsorted, display.windowID, 0, 0, []xproto.Rectangle{rect}).Check()
+	if err != nil {
+		return fmt.Errorf("set window shape: %w", err)
+	}
+
+	display.splitShape = side
+
+	return nil
+}
+
+// drawSplitDivider draws the divider onto buf, which holds the visible part
+// of the window in the byte order of X.
+func drawSplitDivider(buf []byte, visible image.Rectangle, divider image.Rectangle) {
+	width := visible.Dx()
+	divider = divider.Intersect(visible)
+
+	for y := divider.Min.Y; y < divider.Max.Y; y++ {
+		for x := divider.Min.X; x < divider.Max.X; x++ {
+			blendPixel(buf[((y-visible.Min.Y)*width+x-visible.Min.X)*4:], guideColor)
+		}
+	}
+}
+
+// SetSplit moves the divider to position, from 0 at the left or top of the
+// window to 1 at the right or bottom.
+func (display *Window) SetSplit(position float64) error {
+	if display.options.Split == SplitNone {
+		return fmt.Errorf("the window is not split")
+	}
+
+	display.renderMu.Lock()
+	display.splitPosition = min(1, max(0, position))
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+
+	return nil
+}
+
+// splitAt returns the position of the divider if it was at x, y in the
+// window.
+func (display *Window) splitAt(x int, y int) float64 {
+	if display.options.Split == SplitVertical {
+		return float64(x) / float64(display.windowWidth)
+	}
+
+	return float64(y) / float64(display.windowHeight)
+}
+
+// startSplitDrag grabs the divider if x, y in the window is near it, and
+// reports whether it did.
+func (display *Window) startSplitDrag(x int, y int) bool {
+	if display.options.Split == SplitNone {
+		return false
+	}
+
+	display.renderMu.Lock()
+	position := display.splitPosition
+	display.renderMu.Unlock()
+
+	window := image.Rect(0, 0, display.windowWidth, display.windowHeight)
+	reach := display.splitDivider(display.splitSide(window, position)).Inset(-splitReach)
+
+	display.splitDragging = image.Pt(x, y).In(reach)
+
+	return display.splitDragging
+}
+
+// dragSplit moves the grabbed divider to x, y. It reports whether the
+// divider is grabbed.
+func (display *Window) dragSplit(x int, y int) bool {
+	if !display.splitDragging {
+		return false
+	}
+
+	display.SetSplit(display.splitAt(x, y))
+
+	return true
+}
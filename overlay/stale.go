@@ -0,0 +1,157 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The image of a live source, a stream or what polls or receives its images,
+// is marked as stale when nothing new arrived for Options.StaleAfter, so that
+// e.g. a camera that hangs isn't mistaken for a quiet one. Polls that find the
+// image unchanged count as new.
+
+// StaleStyle is how a stale image is marked.
+type StaleStyle string
+
+const (
+	// StaleBadge draws a small badge with the time of the last image in the
+	// top right corner.
+	StaleBadge StaleStyle = "badge"
+	// StaleDesaturate shows the image in grays.
+	StaleDesaturate StaleStyle = "desaturate"
+)
+
+const (
+	// how often the age of the image is checked at most and at least
+	minStaleCheck = 100 * time.Millisecond
+	maxStaleCheck = time.Second
+)
+
+var staleColor = color.RGBA{0xe0, 0xa0, 0x30, 0xff}
+
+func ParseStaleStyle(name string) (StaleStyle, error) {
+	switch style := StaleStyle(name); style {
+	case StaleBadge, StaleDesaturate:
+		return style, nil
+	}
+
+	return "", fmt.Errorf("unknown stale style %q, expected badge or desaturate", name)
+}
+
+// live reports whether the image keeps being replaced by its source.
+func (options Options) live() bool {
+	return options.asynchronous() || options.Stream != nil
+}
+
+// markFresh records that the source delivered or confirmed the image.
+func (display *Window) markFresh() {
+	display.renderMu.Lock()
+	display.freshAt = time.Now()
+	wasStale := display.stalePanel != nil
+	display.stalePanel = nil
+	display.renderMu.Unlock()
+
+	if wasStale {
+		display.requestRedraw()
+	}
+}
+
+// startStaleCheck marks the image as stale once it is older than after.
+func (display *Window) startStaleCheck(after time.Duration) {
+	check := min(maxStaleCheck, max(minStaleCheck, after/10))
+
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+
+		ticker := time.NewTicker(check)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-display.ctx.Done():
+				return
+			}
+
+			display.renderMu.Lock()
+			// spinners and broken images aren't old images
+			stale := !display.placeholder && !display.freshAt.IsZero() && time.Since(display.freshAt) > after
+			changed := stale && display.stalePanel == nil
+			if changed {
+				display.stalePanel = renderStalePanel(display.freshAt)
+			}
+			display.renderMu.Unlock()
+
+			if changed {
+				display.requestRedraw()
+			}
+		}
+	}()
+}
+
+// staleBadge returns the badge drawn over a stale image, nil if the image is
+// not stale or not marked with a badge.
+func (display *Window) staleBadge() *image.RGBA {
+	if display.options.StaleStyle != StaleBadge {
+		return nil
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.stalePanel
+}
+
+// staleDesaturated reports whether the image is stale and shown in grays.
+func (display *Window) staleDesaturated() bool {
+	if display.options.StaleStyle != StaleDesaturate {
+		return false
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.stalePanel != nil
+}
+
+// renderStalePanel returns a dot next to the time the image arrived.
+func renderStalePanel(since time.Time) *image.RGBA {
+	face := basicfont.Face7x13
+	text := "stale since " + since.Format(time.TimeOnly)
+
+	dot := face.Ascent * 2 / 3
+	panel := image.NewRGBA(image.Rect(0, 0, dot+len(text)*face.Advance+3*labelPadding, labelHeight))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(labelBackground), image.Point{}, draw.Src)
+
+	center := image.Pt(labelPadding+dot/2, panel.Bounds().Dy()/2)
+	fillCircle(panel, center, float64(dot)/2, staleColor)
+
+	drawer := font.Drawer{
+		Dst:  panel,
+		Src:  image.NewUniform(staleColor),
+		Face: face,
+		Dot:  fixed.P(dot+2*labelPadding, labelPadding+face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	return panel
+}
+
+// desaturate replaces every pixel of buf, in BGRA order, by its luma.
+func desaturate(pix []byte, threads int) {
+	forEachRowChunk(len(pix)/4, 4, threads, func(start int, end int) {
+		for i := start * 4; i < end*4; i += 4 {
+			gray := byte((29*uint32(pix[i]) + 150*uint32(pix[i+1]) + 77*uint32(pix[i+2])) >> 8)
+			pix[i], pix[i+1], pix[i+2] = gray, gray, gray
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package overlay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// With Options.RememberState the window geometry, opacity, scale mode and
+// zoom are stored for every image when another one is shown or the overlay
+// is closed, in state.json in StateDir, by the hash of the absolute path of
+// the image:
+//
+//	{"3b0c…": {"path": "/home/user/mockup.png", "x": 100, "y": 50, …}}
+//
+// The zoom is restored whenever the image is shown again, the rest is for
+// opening it again with LoadImageState, before the window is created.
+
+// ImageState is how an image was last shown.
+type ImageState struct {
+	Path    string    `json:"path"`
+	X       int       `json:"x"`
+	Y       int       `json:"y"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Opacity float64   `json:"opacity"`
+	Scale   ScaleMode `json:"scale"`
+	Zoom    float64   `json:"zoom,omitempty"`
+	OffsetX float64   `json:"offset_x,omitempty"`
+	OffsetY float64   `json:"offset_y,omitempty"`
+}
+
+// Geometry returns where the window was.
+func (state ImageState) Geometry() Geometry {
+	return Geometry{
+		Width:       state.Width,
+		Height:      state.Height,
+		X:           state.X,
+		Y:           state.Y,
+		HasPosition: true,
+	}
+}
+
+func (state ImageState) view() viewport {
+	return viewport{zoom: state.Zoom, offsetX: state.OffsetX, offsetY: state.OffsetY}
+}
+
+// imageStatePath returns the file the state of the images is stored in.
+func imageStatePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// imageStateKey returns the key the state of the image at the absolute path
+// is stored by.
+func imageStateKey(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// imageFile returns the absolute path of source, or "" if it is not a file,
+// like a mirrored window or a posted image.
+func imageFile(source string) string {
+	if source == "" || source == "-" {
+		return ""
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+
+	path, err := filepath.Abs(source)
+	if err != nil {
+		return ""
+	}
+
+	return path
+}
+
+func loadImageStates() (map[string]ImageState, error) {
+	path, err := imageStatePath()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]ImageState{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+
+	states := map[string]ImageState{}
+
+	err = json.Unmarshal(content, &states)
+	if err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+
+	return states, nil
+}
+
+// LoadImageState returns the state stored for the image file source, and
+// whether there is one.
+func LoadImageState(source string) (ImageState, bool, error) {
+	path := imageFile(source)
+	if path == "" {
+		return ImageState{}, false, nil
+	}
+
+	states, err := loadImageStates()
+	if err != nil {
+		return ImageState{}, false, err
+	}
+
+	state, ok := states[imageStateKey(path)]
+
+	return state, ok, nil
+}
+
+// storeImageState stores state for the image at state.Path.
+func storeImageState(state ImageState) error {
+	states, err := loadImageStates()
+	if err != nil {
+		return err
+	}
+
+	states[imageStateKey(state.Path)] = state
+
+	path, err := imageStatePath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(states, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	// written next to it and renamed like the corners, so that overlays
+	// closed at the same time don't leave a mix of both behind
+	temp := path + ".tmp" + fmt.Sprint(os.Getpid())
+
+	err = os.WriteFile(temp, append(content, '\n'), 0o600)
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+
+	err = os.Rename(temp, path)
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("write state: %w", err)
+	}
+
+	return nil
+}
+
+// stateFile returns the file the image from source is remembered by, or ""
+// if it is not remembered.
+func (display *Window) stateFile(source string) string {
+	if !display.options.RememberState {
+		return ""
+	}
+
+	return imageFile(source)
+}
+
+// rememberedView returns the zoom stored for the image from source, and
+// whether there is one.
+func (display *Window) rememberedView(source string) (viewport, bool) {
+	if display.stateFile(source) == "" {
+		return viewport{}, false
+	}
+
+	state, ok, err := LoadImageState(source)
+	if err != nil {
+		slog.Error("load state", "err", err)
+		return viewport{}, false
+	}
+
+	return state.view(), ok
+}
+
+// rememberState stores how the image from source is shown, while the window
+// still shows it.
+func (display *Window) rememberState(source string) {
+	path := display.stateFile(source)
+	if path == "" {
+		return
+	}
+
+	x, y, err := display.windowPosition()
+	if err != nil {
+		slog.Error("remember state", "err", err)
+		return
+	}
+
+	display.renderMu.Lock()
+	state := ImageState{
+		Path:    path,
+		X:       x,
+		Y:       y,
+		Width:   display.windowWidth,
+		Height:  display.windowHeight,
+		Opacity: display.targetOpacity(),
+		Scale:   display.options.Scale,
+		Zoom:    display.view.zoom,
+		OffsetX: display.view.offsetX,
+		OffsetY: display.view.offsetY,
+	}
+	display.renderMu.Unlock()
+
+	err = storeImageState(state)
+	if err != nil {
+		slog.Error("remember state", "err", err)
+	}
+}
+
+// rememberFinalState stores the state of the current image once, before
+// the window goes away.
+func (display *Window) rememberFinalState() {
+	display.stateSaved.Do(func() {
+		display.renderMu.Lock()
+		source := display.source
+		display.renderMu.Unlock()
+
+		display.rememberState(source)
+	})
+}
@@ -0,0 +1,144 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// RawFormat describes a stream of uncompressed rgba frames, like ffmpeg
+// writes them with -f rawvideo -pix_fmt rgba. Frames are shown as they
+// arrive, or FPS times per second if it is set.
+type RawFormat struct {
+	Width  int
+	Height int
+	FPS    float64
+}
+
+var rawFormatRegexp = regexp.MustCompile(`^(\d+)[xX](\d+)(?:@(\d+(?:\.\d+)?))?$`)
+
+// ParseRawFormat parses frame formats like "1280x720" or "1280x720@30".
+func ParseRawFormat(s string) (RawFormat, error) {
+	match := rawFormatRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return RawFormat{}, fmt.Errorf("invalid frame format %q, expected <width>x<height>[@<fps>]", s)
+	}
+
+	// the regexp only matches digits, only overflows fail
+	width, errWidth := strconv.Atoi(match[1])
+	height, errHeight := strconv.Atoi(match[2])
+	if errWidth != nil || errHeight != nil || width == 0 || height == 0 || width*height > 1<<28 {
+		return RawFormat{}, fmt.Errorf("invalid frame size %q", s)
+	}
+
+	format := RawFormat{Width: width, Height: height}
+
+	if match[3] != "" {
+		format.FPS, _ = strconv.ParseFloat(match[3], 64)
+		if format.FPS == 0 {
+			return RawFormat{}, fmt.Errorf("frame rate has to be positive")
+		}
+	}
+
+	return format, nil
+}
+
+// startStream shows the frames read from reader until it ends, the last
+// frame stays visible.
+func (display *Window) startStream(reader io.Reader, format RawFormat) {
+	// not part of the wait group, a read from a pipe can't be interrupted
+	// and would keep Close waiting for the next frame
+	go func() {
+		err := display.readStream(reader, format)
+		if err != nil {
+			slog.Error("read frames", "err", err)
+		}
+	}()
+}
+
+func (display *Window) readStream(reader io.Reader, format RawFormat) error {
+	var interval time.Duration
+	if format.FPS > 0 {
+		interval = time.Duration(float64(time.Second) / format.FPS)
+	}
+
+	next := time.Now()
+
+	for {
+		// every frame gets its own image, the renderer may still be
+		// scaling the previous one and caches it by identity
+		img := image.NewNRGBA(image.Rect(0, 0, format.Width, format.Height))
+
+		_, err := io.ReadFull(reader, img.Pix)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if display.ctx.Err() != nil {
+			return nil
+		}
+
+		if interval > 0 {
+			time.Sleep(time.Until(next))
+			// a stream that falls behind is shown as fast as it arrives
+			// instead of catching up in a burst
+			next = next.Add(interval)
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+		}
+
+		display.showFrame(img)
+	}
+}
+
+// showFrame replaces the image by the next frame of a stream. Unlike
+// setImage it skips the redraw debounce, which would hold back every frame
+// of a stream that is faster than it, and no image event is emitted.
+func (display *Window) showFrame(img image.Image) {
+	display.markFresh()
+	display.recordImage(img)
+
+	display.renderMu.Lock()
+	rotation := display.rotation
+	filters := display.filters
+	source := display.source
+	display.renderMu.Unlock()
+
+	if !display.keepFrame(source, decodedImage{image: img}) {
+		display.requestRedraw()
+		return
+	}
+
+	decoded := display.options.prepare(decodedImage{image: img}, rotation, filters)
+
+	display.renderMu.Lock()
+	display.loaded = decodedImage{image: img}
+	display.image = decoded.image
+	display.streamFrame = true
+	display.renderMu.Unlock()
+
+	display.wakeRenderer()
+	display.broadcastChanges()
+}
+
+// takeStreamFrame reports whether a new frame of a stream arrived since the
+// last call.
+func (display *Window) takeStreamFrame() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	streamed := display.streamFrame
+	display.streamFrame = false
+
+	return streamed
+}
@@ -0,0 +1,83 @@
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// used when an svg doesn't say how big it is
+const defaultSVGSize = 512
+
+// how far into the file we look for the svg element, xml declarations and
+// comments usually come first
+const svgSniffLength = 4096
+
+func isSVG(imageBytes []byte) bool {
+	head := bytes.TrimLeft(imageBytes, "\ufeff \t\r\n")
+	head = head[:min(len(head), svgSniffLength)]
+
+	if bytes.HasPrefix(head, []byte("<svg")) {
+		return true
+	}
+
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<svg"))
+}
+
+func decodeSVG(imageBytes []byte) (*oksvg.SvgIcon, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(imageBytes), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("parse svg: %w", err)
+	}
+
+	return icon, nil
+}
+
+// svgSize returns the size an svg is shown at by default.
+func svgSize(icon *oksvg.SvgIcon) (int, int) {
+	width := int(math.Ceil(icon.ViewBox.W))
+	height := int(math.Ceil(icon.ViewBox.H))
+
+	if width <= 0 || height <= 0 {
+		return defaultSVGSize, defaultSVGSize
+	}
+
+	return width, height
+}
+
+func rasterizeSVG(icon *oksvg.SvgIcon, width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	icon.SetTarget(0, 0, float64(width), float64(height))
+
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+
+	return img
+}
+
+// vectorRaster keeps the last rasterization of an svg, so that changing the
+// opacity doesn't rasterize it again.
+type vectorRaster struct {
+	icon   *oksvg.SvgIcon
+	width  int
+	height int
+	image  *image.RGBA
+}
+
+func (raster *vectorRaster) get(icon *oksvg.SvgIcon, width int, height int) *image.RGBA {
+	if raster.icon != icon || raster.width != width || raster.height != height {
+		*raster = vectorRaster{
+			icon:   icon,
+			width:  width,
+			height: height,
+			image:  rasterizeSVG(icon, width, height),
+		}
+	}
+
+	return raster.image
+}
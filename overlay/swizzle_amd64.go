@@ -0,0 +1,22 @@
+//go:build amd64 && !purego
+
+package overlay
+
+import "golang.org/x/sys/cpu"
+
+// swizzleSSSE3 swaps the red and blue channels of 4 pixels per instruction.
+// len(pix) has to be a multiple of 16.
+//
+//go:noescape
+func swizzleSSSE3(pix []byte)
+
+func swizzle(pix []byte) {
+	if !cpu.X86.HasSSSE3 {
+		swizzleScalar(pix)
+		return
+	}
+
+	vectorized := len(pix) &^ 15
+	swizzleSSSE3(pix[:vectorized])
+	swizzleScalar(pix[vectorized:])
+}
@@ -0,0 +1,27 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// byte indices that turn RGBA into BGRA for 4 pixels
+DATA swizzleMask<>+0(SB)/8, $0x0704050603000102
+DATA swizzleMask<>+8(SB)/8, $0x0f0c0d0e0b08090a
+GLOBL swizzleMask<>(SB), RODATA|NOPTR, $16
+
+// func swizzleSSSE3(pix []byte)
+TEXT ·swizzleSSSE3(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), SI
+	MOVQ pix_len+8(FP), CX
+	MOVOU swizzleMask<>(SB), X1
+
+loop:
+	CMPQ CX, $16
+	JB   done
+	MOVOU (SI), X0
+	PSHUFB X1, X0
+	MOVOU X0, (SI)
+	ADDQ $16, SI
+	SUBQ $16, CX
+	JMP  loop
+
+done:
+	RET
@@ -0,0 +1,7 @@
+//go:build !amd64 || purego
+
+package overlay
+
+func swizzle(pix []byte) {
+	swizzleScalar(pix)
+}
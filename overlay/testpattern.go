@@ -0,0 +1,374 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+type TestPattern string
+
+const (
+	// color bars as in SMPTE EG 1
+	PatternSMPTE TestPattern = "smpte"
+	// lines every 32 pixels with a circle, for geometry and overscan
+	PatternGrid TestPattern = "grid"
+	// ramps of gray and the primaries, for banding and black levels
+	PatternGradient TestPattern = "gradient"
+	// stripes one to four pixels wide and a star, for focus and scaling
+	PatternSharpness TestPattern = "sharpness"
+	// solid black, the other solid colors are a key press away
+	PatternDeadPixel TestPattern = "dead-pixel"
+	// gray patches next to alternating black and white pixels, the arrow
+	// keys change the gray until both look the same
+	PatternGamma TestPattern = "gamma"
+)
+
+var testPatterns = []TestPattern{PatternSMPTE, PatternGrid, PatternGradient, PatternSharpness, PatternDeadPixel, PatternGamma}
+
+// the gray the gamma pattern starts with, which matches alternating pixels
+// on a display with a gamma of 2.2
+const defaultGammaLevel = 186
+
+func ParseTestPattern(name string) (TestPattern, error) {
+	for _, pattern := range testPatterns {
+		if string(pattern) == name {
+			return pattern, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown test pattern %q", name)
+}
+
+// solid colors that next-image and previous-image cycle through after the
+// pattern, showing stuck and dead pixels
+var solidColors = []color.RGBA{
+	{0x00, 0x00, 0x00, 0xff},
+	{0xff, 0xff, 0xff, 0xff},
+	{0xff, 0x00, 0x00, 0xff},
+	{0x00, 0xff, 0x00, 0xff},
+	{0x00, 0x00, 0xff, 0xff},
+	{0x80, 0x80, 0x80, 0xff},
+}
+
+// renderTestPattern draws the pattern at size, or the solid color before
+// index if index is not 0. Patterns are drawn at the size of the window,
+// scaling them would defeat their purpose. gray is the level of the patches
+// of the gamma pattern.
+func renderTestPattern(pattern TestPattern, index int, size image.Point, gray int) *image.RGBA {
+	size = image.Pt(max(1, size.X), max(1, size.Y))
+	img := image.NewRGBA(image.Rectangle{Max: size})
+
+	if index > 0 {
+		draw.Draw(img, img.Bounds(), image.NewUniform(solidColors[index-1]), image.Point{}, draw.Src)
+		return img
+	}
+
+	switch pattern {
+	case PatternSMPTE:
+		drawColorBars(img)
+	case PatternGrid:
+		drawGridPattern(img)
+	case PatternGradient:
+		drawGradients(img)
+	case PatternSharpness:
+		drawSharpness(img)
+	case PatternGamma:
+		drawGammaProbe(img, gray)
+	default:
+		draw.Draw(img, img.Bounds(), image.NewUniform(solidColors[0]), image.Point{}, draw.Src)
+	}
+
+	return img
+}
+
+// showPattern draws the test pattern, or the solid color the user cycled to,
+// at the size of the window again.
+func (display *Window) showPattern() {
+	display.renderMu.Lock()
+	index := display.patternIndex
+	gray := display.gammaLevel
+	size := image.Pt(display.windowWidth, display.windowHeight)
+	display.renderMu.Unlock()
+
+	display.setImage("", decodedImage{image: renderTestPattern(display.options.TestPattern, index, size, gray)})
+}
+
+// adjustGamma changes the gray of the gamma pattern by delta. It reports
+// whether the gamma pattern is shown.
+func (display *Window) adjustGamma(delta int) bool {
+	display.renderMu.Lock()
+	if display.options.TestPattern != PatternGamma || display.patternIndex != 0 {
+		display.renderMu.Unlock()
+		return false
+	}
+
+	display.gammaLevel = min(254, max(1, display.gammaLevel+delta))
+	display.renderMu.Unlock()
+
+	display.showPattern()
+
+	return true
+}
+
+// cyclePattern goes delta steps through the pattern and the solid colors.
+func (display *Window) cyclePattern(delta int) {
+	count := len(solidColors) + 1
+
+	display.renderMu.Lock()
+	display.patternIndex = ((display.patternIndex+delta)%count + count) % count
+	display.renderMu.Unlock()
+
+	display.showPattern()
+}
+
+// drawBands fills the rows from top to bottom of img with columns of
+// colors, each taking its share of the width.
+func drawBands(img *image.RGBA, top int, bottom int, colors []color.RGBA, widths []float64) {
+	width := img.Bounds().Dx()
+	left := 0
+	position := 0.0
+
+	for i, c := range colors {
+		position += widths[i]
+		right := int(math.Round(position * float64(width)))
+		if i == len(colors)-1 {
+			right = width
+		}
+
+		draw.Draw(img, image.Rect(left, top, right, bottom), image.NewUniform(c), image.Point{}, draw.Src)
+		left = right
+	}
+}
+
+func drawColorBars(img *image.RGBA) {
+	height := img.Bounds().Dy()
+	bars := height * 2 / 3
+	castellations := bars + height/12
+
+	seventh := 1.0 / 7
+	sevenths := []float64{seventh, seventh, seventh, seventh, seventh, seventh, seventh}
+
+	drawBands(img, 0, bars, []color.RGBA{
+		{0xbf, 0xbf, 0xbf, 0xff},
+		{0xbf, 0xbf, 0x00, 0xff},
+		{0x00, 0xbf, 0xbf, 0xff},
+		{0x00, 0xbf, 0x00, 0xff},
+		{0xbf, 0x00, 0xbf, 0xff},
+		{0xbf, 0x00, 0x00, 0xff},
+		{0x00, 0x00, 0xbf, 0xff},
+	}, sevenths)
+
+	drawBands(img, bars, castellations, []color.RGBA{
+		{0x00, 0x00, 0xbf, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+		{0xbf, 0x00, 0xbf, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+		{0x00, 0xbf, 0xbf, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+		{0xbf, 0xbf, 0xbf, 0xff},
+	}, sevenths)
+
+	// -I, white, +Q, black and the pluge below, which tells black from
+	// slightly darker and slightly brighter
+	quarter := 5.0 / 4 * seventh
+	third := seventh / 3
+	drawBands(img, castellations, height, []color.RGBA{
+		{0x00, 0x21, 0x4c, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+		{0x32, 0x00, 0x6a, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+		{0x09, 0x09, 0x09, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+		{0x1d, 0x1d, 0x1d, 0xff},
+		{0x13, 0x13, 0x13, 0xff},
+	}, []float64{quarter, quarter, quarter, 5*seventh - 3*quarter, third, third, third, seventh})
+}
+
+func drawGridPattern(img *image.RGBA) {
+	const (
+		step      = 32
+		majorStep = 4 * step
+	)
+
+	bounds := img.Bounds()
+	draw.Draw(img, bounds, image.NewUniform(solidColors[0]), image.Point{}, draw.Src)
+
+	minor := color.RGBA{0x60, 0x60, 0x60, 0xff}
+	major := color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+	// centered, so that the lines are symmetric and one goes through the
+	// middle of the screen
+	center := image.Pt(bounds.Dx()/2, bounds.Dy()/2)
+
+	for x := center.X % step; x < bounds.Dx(); x += step {
+		c := minor
+		if (x-center.X)%majorStep == 0 {
+			c = major
+		}
+
+		draw.Draw(img, image.Rect(x, 0, x+1, bounds.Dy()), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	for y := center.Y % step; y < bounds.Dy(); y += step {
+		c := minor
+		if (y-center.Y)%majorStep == 0 {
+			c = major
+		}
+
+		draw.Draw(img, image.Rect(0, y, bounds.Dx(), y+1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	// the edges show whether anything is cut off
+	for _, edge := range []image.Rectangle{
+		image.Rect(0, 0, bounds.Dx(), 1),
+		image.Rect(0, bounds.Dy()-1, bounds.Dx(), bounds.Dy()),
+		image.Rect(0, 0, 1, bounds.Dy()),
+		image.Rect(bounds.Dx()-1, 0, bounds.Dx(), bounds.Dy()),
+	} {
+		draw.Draw(img, edge, image.NewUniform(major), image.Point{}, draw.Src)
+	}
+
+	// a circle that turns into an ellipse when the aspect ratio is wrong
+	radius := float64(min(bounds.Dx(), bounds.Dy())) / 2 * 0.9
+	steps := int(2 * math.Pi * radius * 2)
+	for i := range steps {
+		angle := 2 * math.Pi * float64(i) / float64(steps)
+		x := center.X + int(math.Round(radius*math.Cos(angle)))
+		y := center.Y + int(math.Round(radius*math.Sin(angle)))
+		img.SetRGBA(x, y, major)
+	}
+}
+
+func drawGradients(img *image.RGBA) {
+	bounds := img.Bounds()
+	ramps := []color.RGBA{
+		{0xff, 0xff, 0xff, 0xff},
+		{0xff, 0x00, 0x00, 0xff},
+		{0x00, 0xff, 0x00, 0xff},
+		{0x00, 0x00, 0xff, 0xff},
+	}
+
+	// the last ramp is gray again, stepped in 16 levels instead of smooth
+	rows := len(ramps) + 1
+	for y := range bounds.Dy() {
+		ramp := y * rows / bounds.Dy()
+
+		for x := range bounds.Dx() {
+			level := x * 255 / max(1, bounds.Dx()-1)
+
+			c := solidColors[1]
+			if ramp < len(ramps) {
+				c = ramps[ramp]
+			} else {
+				level = x * 16 / bounds.Dx() * 255 / 15
+			}
+
+			img.SetRGBA(x, y, color.RGBA{
+				byte(int(c.R) * level / 255),
+				byte(int(c.G) * level / 255),
+				byte(int(c.B) * level / 255),
+				0xff,
+			})
+		}
+	}
+}
+
+func drawSharpness(img *image.RGBA) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	// stripes one to four pixels wide, vertical on the left half getting
+	// wider from top to bottom, horizontal on the right half getting wider
+	// from left to right
+	half := width / 2
+	for y := range height {
+		for x := range width {
+			stripe := 1 + y*4/height
+			position := x
+			if x >= half {
+				stripe = 1 + (x-half)*4/max(1, width-half)
+				position = y
+			}
+
+			c := solidColors[0]
+			if position/stripe%2 == 0 {
+				c = solidColors[1]
+			}
+
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	// a siemens star in the middle, its spokes blur together where the
+	// resolution ends
+	const spokes = 72
+
+	center := image.Pt(width/2, height/2)
+	radius := min(width, height) / 4
+
+	for y := center.Y - radius; y < center.Y+radius; y++ {
+		for x := center.X - radius; x < center.X+radius; x++ {
+			dx := float64(x-center.X) + 0.5
+			dy := float64(y-center.Y) + 0.5
+			if dx*dx+dy*dy > float64(radius*radius) {
+				continue
+			}
+
+			angle := math.Atan2(dy, dx) + math.Pi
+			c := solidColors[0]
+			if int(angle/(2*math.Pi)*spokes)%2 == 0 {
+				c = solidColors[1]
+			}
+
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawGammaProbe draws patches of gray next to alternating black and white
+// pixels, which look like 50% gray from a distance. The gray that looks the
+// same tells the gamma of the display, as long as every pixel of the
+// pattern is a pixel of the screen.
+func drawGammaProbe(img *image.RGBA, gray int) {
+	bounds := img.Bounds()
+	draw.Draw(img, bounds, image.NewUniform(solidColors[5]), image.Point{}, draw.Src)
+
+	// a checkerboard and horizontal and vertical lines, displays blur them
+	// differently
+	patterns := []func(x int, y int) bool{
+		func(x int, y int) bool { return (x+y)%2 == 0 },
+		func(x int, y int) bool { return y%2 == 0 },
+		func(x int, y int) bool { return x%2 == 0 },
+	}
+
+	patch := color.RGBA{byte(gray), byte(gray), byte(gray), 0xff}
+	columnWidth := bounds.Dx() / len(patterns)
+	area := image.Rect(0, 0, columnWidth, bounds.Dy()-labelHeight).Inset(columnWidth / 8)
+
+	for i, alternate := range patterns {
+		column := area.Add(image.Pt(i*columnWidth, 0))
+
+		for y := column.Min.Y; y < column.Max.Y; y++ {
+			for x := column.Min.X; x < column.Max.X; x++ {
+				c := solidColors[0]
+				if alternate(x, y) {
+					c = solidColors[1]
+				}
+
+				img.SetRGBA(x, y, c)
+			}
+		}
+
+		inner := column.Inset(min(column.Dx(), column.Dy()) / 4)
+		draw.Draw(img, inner, image.NewUniform(patch), image.Point{}, draw.Src)
+	}
+
+	// the gray is linear light of 50% at this gamma
+	gamma := math.Log(0.5) / math.Log(float64(gray)/255)
+	text := fmt.Sprintf("gray %d matches the pattern at gamma %.2f, up and down change it", gray, gamma)
+	drawLabel(img, image.Rect(0, bounds.Dy()-labelHeight, bounds.Dx(), bounds.Dy()), text)
+}
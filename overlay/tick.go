@@ -0,0 +1,54 @@
+package overlay
+
+import (
+	"strings"
+	"time"
+)
+
+// Content that changes with the time, like the {time} of text annotations, is
+// redrawn by the renderer on a tick. The ticks fall on multiples of the tick
+// interval, so that a clock changes with the second and not somewhere in
+// between.
+
+// clockTick is the tick of text that shows the time, unless Options.Tick
+// is set.
+const clockTick = time.Second
+
+// tickInterval returns how often the window is redrawn for the time, 0 for
+// not at all. renderMu has to be held.
+func (display *Window) tickInterval() time.Duration {
+	if display.options.Tick > 0 {
+		return display.options.Tick
+	}
+
+	if display.options.Blend == BlendDifference && display.options.DiffBlink > 0 {
+		return display.options.DiffBlink
+	}
+
+	for _, a := range display.annotations {
+		if strings.Contains(a.Text, "{time}") {
+			return clockTick
+		}
+	}
+
+	return 0
+}
+
+// advanceTick reports whether a tick is due and schedules the next one.
+func (display *Window) advanceTick(now time.Time) bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	tick := display.tickInterval()
+	if tick == 0 {
+		display.nextTick = time.Time{}
+		return false
+	}
+
+	due := !display.nextTick.IsZero() && !now.Before(display.nextTick)
+	if due || display.nextTick.IsZero() {
+		display.nextTick = now.Truncate(tick).Add(tick)
+	}
+
+	return due
+}
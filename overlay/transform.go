@@ -0,0 +1,108 @@
+package overlay
+
+import (
+	"image"
+	"image/draw"
+)
+
+// prepare turns a freshly decoded image into what is shown: redacted first,
+// so that the regions are in the pixels of the file, then trimmed, cropped,
+// flipped and rotated by rotation degrees on top of Options.Rotate, shown
+// as it looks with Options.CVD and last filtered.
+func (options Options) prepare(decoded decodedImage, rotation int, filters ColorFilters) decodedImage {
+	decoded = options.redact(decoded)
+	decoded = options.trim(decoded)
+
+	rotate := (options.Rotate + rotation) % 360
+	if !options.Crop.Empty() || rotate != 0 || options.FlipH || options.FlipV {
+		decoded = mapFrames(decoded, func(img image.Image) image.Image {
+			return transformImage(img, options.Crop, rotate, options.FlipH, options.FlipV)
+		})
+	}
+
+	decoded = options.simulateCVD(decoded)
+
+	return filters.apply(decoded)
+}
+
+// mapFrames replaces the image and every frame of an animation with what f
+// makes of them. svgs are rasterized at their own size.
+func mapFrames(decoded decodedImage, f func(image.Image) image.Image) decodedImage {
+	mapped := decodedImage{
+		image:    f(decoded.image),
+		plays:    decoded.plays,
+		info:     decoded.info,
+		fullSize: decoded.fullSize,
+	}
+
+	for _, frame := range decoded.frames {
+		mapped.frames = append(mapped.frames, animationFrame{
+			image: f(frame.image),
+			delay: frame.delay,
+		})
+	}
+
+	return mapped
+}
+
+// transformImage crops img to crop, relative to its top left corner, and
+// rotates it clockwise by rotate degrees, which is a multiple of 90. The
+// flips are applied last, so that they are horizontal and vertical as shown.
+func transformImage(img image.Image, crop image.Rectangle, rotate int, flipH bool, flipV bool) image.Image {
+	bounds := img.Bounds()
+	if cropped := crop.Add(bounds.Min).Intersect(bounds); !cropped.Empty() {
+		bounds = cropped
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	srcWidth := bounds.Dx()
+	srcHeight := bounds.Dy()
+
+	width, height := srcWidth, srcHeight
+	if rotate == 90 || rotate == 270 {
+		width, height = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			rotatedX, rotatedY := x, y
+			if flipH {
+				rotatedX = width - 1 - x
+			}
+
+			if flipV {
+				rotatedY = height - 1 - y
+			}
+
+			// the source pixel that ends up at rotatedX, rotatedY
+			srcX, srcY := rotatedX, rotatedY
+			switch rotate {
+			case 90:
+				srcX, srcY = rotatedY, srcHeight-1-rotatedX
+			case 180:
+				srcX, srcY = srcWidth-1-rotatedX, srcHeight-1-rotatedY
+			case 270:
+				srcX, srcY = srcWidth-1-rotatedY, rotatedX
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(srcX, srcY):])
+		}
+	}
+
+	return dst
+}
+
+// rotate turns the image by another 90 degrees clockwise.
+func (display *Window) rotate() {
+	display.renderMu.Lock()
+	display.rotation = (display.rotation + 90) % 360
+	source := display.source
+	loaded := display.loaded
+	display.renderMu.Unlock()
+
+	display.setImage(source, loaded)
+}
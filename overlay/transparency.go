@@ -0,0 +1,114 @@
+package overlay
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// Transparency is how the window is made see-through.
+type Transparency string
+
+const (
+	// argb with a compositing manager, otherwise opacity-hint
+	TransparencyAuto Transparency = "auto"
+	// a 32 bit window that the compositing manager blends with the screen
+	TransparencyARGB Transparency = "argb"
+	// an opaque window with _NET_WM_WINDOW_OPACITY, which compositors like
+	// xcompmgr apply to the whole window
+	TransparencyOpacityHint Transparency = "opacity-hint"
+	// an opaque window, less opacity only darkens the image
+	TransparencyNone Transparency = "none"
+)
+
+var transparencies = []Transparency{TransparencyAuto, TransparencyARGB, TransparencyOpacityHint, TransparencyNone}
+
+func ParseTransparency(name string) (Transparency, error) {
+	for _, transparency := range transparencies {
+		if string(transparency) == name {
+			return transparency, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown transparency %q", name)
+}
+
+// compositorRunning reports whether a compositing manager owns the
+// _NET_WM_CM_Sn selection of our screen. Without one a 32 bit window is
+// shown on black instead of the screen below it.
+func (display *Window) compositorRunning() (bool, error) {
+	selection, err := display.atom(fmt.Sprintf("_NET_WM_CM_S%d", display.conn.DefaultScreen))
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := xproto.GetSelectionOwner(display.conn, selection).Reply()
+	if err != nil {
+		return false, fmt.Errorf("get selection owner: %w", err)
+	}
+
+	return reply.Owner != xproto.WindowNone, nil
+}
+
+// resolveTransparency picks the mode for auto. Servers without a 32 bit
+// visual are left alone, they are usually vnc servers without a compositor
+// that would look at the hint anyway.
+func (display *Window) resolveTransparency() (Transparency, error) {
+	transparency := display.options.Transparency
+	if transparency != TransparencyAuto && transparency != "" {
+		return transparency, nil
+	}
+
+	if display.quirks.noARGB {
+		return TransparencyNone, nil
+	}
+
+	running, err := display.compositorRunning()
+	if err != nil {
+		return "", err
+	}
+
+	if !running {
+		return TransparencyOpacityHint, nil
+	}
+
+	return TransparencyARGB, nil
+}
+
+// setOpacityHint sets _NET_WM_WINDOW_OPACITY if it changed since it was last
+// set.
+func (display *Window) setOpacityHint(opacity float64) error {
+	const format32Bit = 32
+
+	value := uint32(math.Round(opacity * math.MaxUint32))
+	if display.opacityHintSet && display.opacityHint == value {
+		return nil
+	}
+
+	hintAtom, err := display.atom("_NET_WM_WINDOW_OPACITY")
+	if err != nil {
+		return err
+	}
+
+	data := []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
+
+	err = xproto.ChangePropertyChecked(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		hintAtom,
+		xproto.AtomCardinal,
+		format32Bit,
+		1,
+		data,
+	).Check()
+	if err != nil {
+		return fmt.Errorf("set opacity hint: %w", err)
+	}
+
+	display.opacityHint = value
+	display.opacityHintSet = true
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// channels may differ this much from the border color, jpeg artifacts and
+// dithering make borders only nearly uniform
+const trimTolerance = 8
+
+// trim removes uniform borders, like letterbox bars or the margins of an
+// exported screenshot, if Options.AutoTrim is set. The borders are detected
+// on the first frame of an animation and removed from all of them, so that
+// every frame keeps the same size.
+func (options Options) trim(decoded decodedImage) decodedImage {
+	if !options.AutoTrim {
+		return decoded
+	}
+
+	bounds := decoded.image.Bounds()
+	trimmed := trimBounds(decoded.image)
+	if trimmed == bounds {
+		return decoded
+	}
+
+	return mapFrames(decoded, func(img image.Image) image.Image {
+		rect := trimmed.Sub(bounds.Min).Add(img.Bounds().Min)
+		if cropped, ok := cropImage(img, rect); ok {
+			return cropped
+		}
+
+		rgba := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, rect.Min, draw.Src)
+
+		return rgba
+	})
+}
+
+// trimBounds returns the part of img inside its uniform borders. Every side
+// is trimmed by the color of its own first row or column, letterboxed
+// screenshots often have bars of another color than their margins. An image
+// that is uniform as a whole is not trimmed at all.
+func trimBounds(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return bounds
+	}
+
+	at := func(x int, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+
+	matches := func(rect image.Rectangle, reference color.NRGBA) bool {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if !similarColor(at(x, y), reference) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	trimmed := bounds
+
+	top := at(trimmed.Min.X, trimmed.Min.Y)
+	for trimmed.Dy() > 0 && matches(image.Rect(trimmed.Min.X, trimmed.Min.Y, trimmed.Max.X, trimmed.Min.Y+1), top) {
+		trimmed.Min.Y++
+	}
+
+	if trimmed.Empty() {
+		return bounds
+	}
+
+	bottom := at(trimmed.Min.X, trimmed.Max.Y-1)
+	for trimmed.Dy() > 0 && matches(image.Rect(trimmed.Min.X, trimmed.Max.Y-1, trimmed.Max.X, trimmed.Max.Y), bottom) {
+		trimmed.Max.Y--
+	}
+
+	// the sides start below the top and bottom borders
+	left := at(trimmed.Min.X, trimmed.Min.Y)
+	for trimmed.Dx() > 0 && matches(image.Rect(trimmed.Min.X, trimmed.Min.Y, trimmed.Min.X+1, trimmed.Max.Y), left) {
+		trimmed.Min.X++
+	}
+
+	right := at(trimmed.Max.X-1, trimmed.Min.Y)
+	for trimmed.Dx() > 0 && matches(image.Rect(trimmed.Max.X-1, trimmed.Min.Y, trimmed.Max.X, trimmed.Max.Y), right) {
+		trimmed.Max.X--
+	}
+
+	if trimmed.Empty() {
+		return bounds
+	}
+
+	return trimmed
+}
+
+func similarColor(a color.NRGBA, b color.NRGBA) bool {
+	return abs(int(a.R)-int(b.R)) <= trimTolerance &&
+		abs(int(a.G)-int(b.G)) <= trimTolerance &&
+		abs(int(a.B)-int(b.B)) <= trimTolerance &&
+		abs(int(a.A)-int(b.A)) <= trimTolerance
+}
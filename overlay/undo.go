@@ -0,0 +1,235 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"slices"
+	"time"
+)
+
+// Changes made while the overlay is shown, with the keys, the mouse or
+// annotations over the control socket, can be undone with ctrl+z and done
+// again with ctrl+shift+z: the opacity, the position and size of the window,
+// the rotation, the color filters, the corners, the zoom and the
+// annotations. Before every change the state is pushed onto the undo stack.
+// The stacks start over with every image, its corners and zoom are its own.
+
+const (
+	// how many changes can be undone
+	maxUndo = 100
+	// changes of the same kind in quick succession, like scrolling to zoom,
+	// are undone at once
+	undoCoalesce = time.Second
+)
+
+// the actions that change what undo restores
+var undoableActions = []action{
+	actionNudgeLeft,
+	actionNudgeRight,
+	actionNudgeUp,
+	actionNudgeDown,
+	actionOpacityUp,
+	actionOpacityDown,
+	actionFullscreen,
+	actionRotate,
+	actionToggleFilters,
+	actionToggleInvert,
+	actionCycleChannel,
+	actionToggleOutline,
+	actionToggleBlueprint,
+	actionResetZoom,
+	actionResetCorners,
+}
+
+// undoState is what undo restores.
+type undoState struct {
+	opacity       float64
+	position      image.Point
+	width, height int
+	rotation      int
+	filters       ColorFilters
+	corners       []image.Point
+	view          viewport
+	showGuides    bool
+	showInfo      bool
+	annotations   []*annotation
+}
+
+func (state undoState) equal(other undoState) bool {
+	return state.opacity == other.opacity &&
+		state.position == other.position &&
+		state.width == other.width &&
+		state.height == other.height &&
+		state.rotation == other.rotation &&
+		state.filters == other.filters &&
+		slices.Equal(state.corners, other.corners) &&
+		state.view == other.view &&
+		state.showGuides == other.showGuides &&
+		state.showInfo == other.showInfo &&
+		slices.Equal(state.annotations, other.annotations)
+}
+
+// undoHistory are the states changes can be undone and done again to.
+type undoHistory struct {
+	undo []undoState
+	redo []undoState
+	// the kind and time of the last checkpoint, for coalescing
+	kind string
+	at   time.Time
+}
+
+// captureState returns the state as it is now.
+func (display *Window) captureState() (undoState, error) {
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return undoState{}, err
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return undoState{
+		opacity:     display.targetOpacity(),
+		position:    image.Pt(x, y),
+		width:       display.windowWidth,
+		height:      display.windowHeight,
+		rotation:    display.rotation,
+		filters:     display.filters,
+		corners:     slices.Clone(display.corners),
+		view:        display.view,
+		showGuides:  display.showGuides,
+		showInfo:    display.showInfo,
+		annotations: slices.Clone(display.annotations),
+	}, nil
+}
+
+// checkpoint remembers the state before a change of kind, so that the
+// change can be undone. Changes done again are forgotten.
+func (display *Window) checkpoint(kind string) {
+	state, err := display.captureState()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	history := &display.undo
+
+	coalesced := kind == history.kind && now.Sub(history.at) < undoCoalesce
+	history.kind = kind
+	history.at = now
+
+	if coalesced || len(history.undo) > 0 && history.undo[len(history.undo)-1].equal(state) {
+		return
+	}
+
+	history.undo = append(history.undo, state)
+	if len(history.undo) > maxUndo {
+		history.undo = slices.Delete(history.undo, 0, len(history.undo)-maxUndo)
+	}
+
+	history.redo = nil
+}
+
+// resetUndo forgets the changes, for another image.
+func (display *Window) resetUndo() {
+	display.renderMu.Lock()
+	display.undo = undoHistory{}
+	display.renderMu.Unlock()
+}
+
+// Undo undoes the last change, it reports whether there was one.
+func (display *Window) Undo() (bool, error) {
+	return display.stepUndo(true)
+}
+
+// Redo does the last undone change again, it reports whether there was
+// one.
+func (display *Window) Redo() (bool, error) {
+	return display.stepUndo(false)
+}
+
+// stepUndo goes back to the last state before a change, or forward to the
+// last undone one.
+func (display *Window) stepUndo(back bool) (bool, error) {
+	current, err := display.captureState()
+	if err != nil {
+		return false, err
+	}
+
+	display.renderMu.Lock()
+	history := &display.undo
+
+	from, to := &history.undo, &history.redo
+	if !back {
+		from, to = to, from
+	}
+
+	// states that are the same as now were changes that didn't change
+	// anything, like a nudge against the edge
+	for len(*from) > 0 && (*from)[len(*from)-1].equal(current) {
+		*from = (*from)[:len(*from)-1]
+	}
+
+	if len(*from) == 0 {
+		display.renderMu.Unlock()
+		return false, nil
+	}
+
+	state := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, current)
+
+	// the next change starts a new step
+	history.kind = ""
+	display.renderMu.Unlock()
+
+	return true, display.restoreState(state)
+}
+
+// restoreState brings back state.
+func (display *Window) restoreState(state undoState) error {
+	display.setOpacity(state.opacity)
+	display.shareOpacity()
+
+	display.renderMu.Lock()
+	movedCorners := !slices.Equal(state.corners, display.corners)
+	retransform := state.rotation != display.rotation || state.filters != display.filters
+	resized := state.width != display.windowWidth || state.height != display.windowHeight
+	display.corners = state.corners
+	display.view = state.view
+	display.showGuides = state.showGuides
+	display.showInfo = state.showInfo
+	display.annotations = state.annotations
+	display.rotation = state.rotation
+	source := display.source
+	display.renderMu.Unlock()
+
+	if movedCorners {
+		display.rememberCorners(source, state.corners)
+	}
+
+	// shows the image again with the rotation as well
+	if retransform {
+		display.setColorFilters(state.filters)
+	}
+
+	if resized {
+		err := display.resizeWindow(state.width, state.height)
+		if err != nil {
+			return fmt.Errorf("undo: %w", err)
+		}
+	}
+
+	err := display.moveWindow(state.position.X, state.position.Y)
+	if err != nil {
+		return fmt.Errorf("undo: %w", err)
+	}
+
+	display.requestRedraw()
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package overlay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// With Options.Watchdog a signage player reports that it is alive to a
+// monitor like healthchecks.io or uptime kuma, which raises an alarm when the
+// pings stop. Only an overlay that still works pings: the X server answers
+// and the last frame was rendered without an error.
+
+const DefaultWatchdogInterval = time.Minute
+
+// a ping that takes longer than this counts as missed
+const watchdogTimeout = 10 * time.Second
+
+func (display *Window) startWatchdog(url string, interval time.Duration) {
+	display.wg.Add(1)
+
+	go func() {
+		defer display.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := display.alive()
+			if err == nil {
+				err = pingWatchdog(display.ctx, url)
+			}
+
+			if err != nil && display.ctx.Err() == nil {
+				slog.Warn("watchdog", "err", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-display.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// alive returns why the overlay doesn't work, or nil if it does.
+func (display *Window) alive() error {
+	// any round trip shows that the connection is still up
+	_, err := xproto.GetInputFocus(display.conn).Reply()
+	if err != nil {
+		return fmt.Errorf("not pinging, the X server doesn't answer: %w", err)
+	}
+
+	display.renderMu.Lock()
+	renderErr := display.renderErr
+	display.renderMu.Unlock()
+
+	if renderErr != nil {
+		return fmt.Errorf("not pinging, rendering failed: %w", renderErr)
+	}
+
+	return nil
+}
+
+func pingWatchdog(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, watchdogTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	defer response.Body.Close()
+
+	// read so that the connection is reused for the next ping
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("ping: %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package overlay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	defaultWebhookDuration = 5 * time.Second
+	webhookDownloadTimeout = 10 * time.Second
+	// larger images are refused instead of filling up the memory
+	maxWebhookImageSize = 64 << 20
+)
+
+// webhookRequest is the JSON body posted to the webhook. The image is either
+// downloaded from ImageURL or given base64 encoded in Image.
+type webhookRequest struct {
+	ImageURL string   `json:"image_url,omitempty"`
+	Image    []byte   `json:"image,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Opacity  *float64 `json:"opacity,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+}
+
+type webhookResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// WebhookServer shows posted images as temporary overlays and goes back to
+// what was shown before once their duration has passed.
+type WebhookServer struct {
+	server   *http.Server
+	listener net.Listener
+	display  *Window
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// what was shown before the first of the temporary overlays
+	previous *shownImage
+	restore  *time.Timer
+}
+
+type shownImage struct {
+	source  string
+	decoded decodedImage
+	opacity float64
+}
+
+// parseWebhookAddress splits addresses like ":9000/hook" into the address
+// to listen on and the path of the webhook.
+func parseWebhookAddress(address string) (string, string) {
+	host, path, ok := strings.Cut(address, "/")
+	if !ok {
+		return address, "/"
+	}
+
+	return host, "/" + path
+}
+
+// ListenWebhook accepts webhook requests on address, e.g. ":9000/hook", that
+// pass access.
+func (display *Window) ListenWebhook(address string, access AccessControl) (*WebhookServer, error) {
+	host, path := parseWebhookAddress(address)
+
+	guard := newAccessGuard(access)
+
+	listener, err := guard.listen(host)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &WebhookServer{
+		listener: listener,
+		display:  display,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+path, guard.handler("webhook", server.handle))
+
+	var handler http.Handler = mux
+	if access.Token == "" {
+		handler = refuseBrowsers(mux, webhookAccepts)
+	}
+
+	server.server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *WebhookServer) serve() {
+	defer server.wg.Done()
+
+	err := server.server.Serve(server.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("serve webhook", "err", err)
+	}
+}
+
+func (server *WebhookServer) Close() {
+	server.server.Close()
+	server.wg.Wait()
+
+	server.mu.Lock()
+	if server.restore != nil {
+		server.restore.Stop()
+	}
+	server.mu.Unlock()
+}
+
+func (server *WebhookServer) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := server.show(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(webhookResponse{Error: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(webhookResponse{OK: true})
+}
+
+// webhookAccepts tells whether a webhook body is JSON, which pages can only
+// post to another site if it allows them.
+func webhookAccepts(r *http.Request, contentType string) bool {
+	return contentType == "application/json"
+}
+
+func (server *WebhookServer) show(r *http.Request) error {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !webhookAccepts(r, contentType) {
+		return fmt.Errorf("content type %q is not json", contentType)
+	}
+
+	var request webhookRequest
+
+	err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookImageSize*2)).Decode(&request)
+	if err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+
+	duration := defaultWebhookDuration
+	if request.Duration != "" {
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil {
+			return fmt.Errorf("parse duration: %w", err)
+		}
+	}
+
+	imageBytes := request.Image
+	source := "webhook"
+
+	if request.ImageURL != "" {
+		imageBytes, err = downloadImage(request.ImageURL)
+		if err != nil {
+			return err
+		}
+
+		source = request.ImageURL
+	}
+
+	display := server.display
+
+	var decoded *decodedImage
+	if len(imageBytes) > 0 {
+		result, err := decodeImage(imageBytes, display.options.Animate, image.Point{})
+		if err != nil {
+			return fmt.Errorf("decode image: %w", err)
+		}
+
+		decoded = &result
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.previous == nil {
+		server.previous = display.shownImage()
+	}
+
+	if decoded != nil {
+		display.setImage(source, *decoded)
+	}
+
+	if request.Opacity != nil {
+		display.fadeOpacity(*request.Opacity)
+	}
+
+	// drawn over the image, and in the title for taskbars and window
+	// switchers
+	if request.Text != "" {
+		display.setMessage(request.Text)
+		display.setTitle(request.Text)
+	}
+
+	if server.restore != nil {
+		server.restore.Stop()
+	}
+
+	server.restore = time.AfterFunc(duration, server.restorePrevious)
+
+	return nil
+}
+
+// restorePrevious goes back to what was shown before the temporary overlays.
+func (server *WebhookServer) restorePrevious() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	previous := server.previous
+	if previous == nil {
+		return
+	}
+
+	server.previous = nil
+	server.restore = nil
+
+	server.display.setImage(previous.source, previous.decoded)
+	server.display.fadeOpacity(previous.opacity)
+	server.display.setMessage("")
+	server.display.setTitle("")
+}
+
+func downloadImage(url string) ([]byte, error) {
+	client := http.Client{Timeout: webhookDownloadTimeout}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("download image: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download image: %s", response.Status)
+	}
+
+	imageBytes, err := io.ReadAll(io.LimitReader(response.Body, maxWebhookImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download image: %w", err)
+	}
+
+	if len(imageBytes) > maxWebhookImageSize {
+		return nil, fmt.Errorf("download image: larger than %d bytes", maxWebhookImageSize)
+	}
+
+	// an error page isn't decoded
+	err = checkImageType(response.Header.Get("Content-Type"), imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageBytes, nil
+}
+
+func (display *Window) shownImage() *shownImage {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return &shownImage{
+		source: display.source,
+		// redacted and transformed again when it is restored
+		decoded: display.loaded,
+		opacity: display.targetOpacity(),
+	}
+}
+
+// setTitle sets the window title, an empty title removes it.
+func (display *Window) setTitle(title string) {
+	name, err := display.atom("_NET_WM_NAME")
+	if err != nil {
+		slog.Error("set title", "err", err)
+		return
+	}
+
+	if title == "" {
+		xproto.DeleteProperty(display.conn, display.windowID, name)
+		return
+	}
+
+	utf8String, err := display.atom("UTF8_STRING")
+	if err != nil {
+		slog.Error("set title", "err", err)
+		return
+	}
+
+	const format8Bit = 8
+
+	xproto.ChangeProperty(display.conn, xproto.PropModeReplace, display.windowID, name, utf8String, format8Bit, uint32(len(title)), []byte(title))
+}
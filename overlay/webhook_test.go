@@ -0,0 +1,89 @@
+package overlay
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookRefusesBrowsers(t *testing.T) {
+	body := `{"text": "build failed"}`
+
+	tests := []struct {
+		name        string
+		host        string
+		origin      string
+		contentType string
+		refused     bool
+	}{
+		{"json", "127.0.0.1:9000", "", "application/json", false},
+		{"form", "127.0.0.1:9000", "", "application/x-www-form-urlencoded", true},
+		{"text form", "127.0.0.1:9000", "", "text/plain", true},
+		{"other origin", "127.0.0.1:9000", "https://attacker.example", "application/json", true},
+		{"rebound host name", "attacker.example:9000", "", "application/json", true},
+	}
+
+	for _, test := range tests {
+		passed := false
+		handler := refuseBrowsers(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			passed = true
+		}), webhookAccepts)
+
+		r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+		r.Host = test.host
+		r.Header.Set("Content-Type", test.contentType)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if passed == test.refused || (w.Code == http.StatusForbidden) != test.refused {
+			t.Errorf("%s: status %d, passed %v, want refused %v", test.name, w.Code, passed, test.refused)
+		}
+	}
+
+	// with a token the body still has to be json
+	r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/plain")
+
+	err := (&WebhookServer{}).show(r)
+	if err == nil {
+		t.Error("a text/plain body is shown")
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/badge.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(encoded.Bytes())
+		case "/login":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>sign in</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	data, err := downloadImage(server.URL + "/badge.png")
+	if err != nil || !bytes.Equal(data, encoded.Bytes()) {
+		t.Errorf("image: %d bytes, err %v", len(data), err)
+	}
+
+	_, err = downloadImage(server.URL + "/login")
+	if err == nil {
+		t.Error("a login page is downloaded as the image")
+	}
+}
@@ -0,0 +1,2571 @@
+package overlay
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/damage"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xproto"
+	"github.com/srwiley/oksvg"
+	"golang.org/x/image/font"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	depthWithAlpha = 32
+	depthOpaque    = 24
+	classTrueColor = 4
+)
+
+func matchVisualInfo(depthInfos []xproto.DepthInfo, depth byte, class byte) *xproto.VisualInfo {
+	for _, depthInfo := range depthInfos {
+		if depthInfo.Depth != depth {
+			continue
+		}
+
+		for _, visual := range depthInfo.Visuals {
+			if visual.Class == class {
+				return &visual
+			}
+		}
+	}
+
+	return nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+type Options struct {
+	InitialOpacity float64
+	Animate        bool
+	Keymap         Keymap
+	NudgeStep      int
+	OpacityStep    float64
+	OpacityMin     float64
+	OpacityMax     float64
+	RenderThreads  int
+	Limits         ResourceLimits
+	Geometry       Geometry
+	Above          bool
+	Below          bool
+	Layer          string
+	LockSize       bool
+
+	// LockAspect lets the window only be resized to the aspect ratio of the
+	// image.
+	LockAspect bool
+
+	// Sticky keeps the window on all virtual desktops, Fullscreen asks the
+	// window manager to fill the monitor with it.
+	Sticky     bool
+	Fullscreen bool
+
+	// LockInput ignores the keyboard and the mouse, for kiosks where only
+	// the control socket and signals may change the overlay. The window
+	// still takes the clicks, they don't reach the windows below.
+	LockInput bool
+
+	// AllowBlanking lets the screen saver and DPMS blank the screen while
+	// an animation, a slideshow or a kiosk plays, see inhibit.go.
+	AllowBlanking bool
+
+	// Watchdog is a URL that is requested every WatchdogInterval while the
+	// X server answers and frames are rendered, for monitors that raise an
+	// alarm when the requests stop.
+	Watchdog         string
+	WatchdogInterval time.Duration
+
+	// OverrideRedirect bypasses the window manager entirely, NoDecorations
+	// asks it to not draw a frame around the window.
+	OverrideRedirect bool
+	NoDecorations    bool
+
+	// Remote renders without shared memory and redraws less often, for
+	// connections forwarded over the network. ColorBits is the number of
+	// bits kept per color channel.
+	Remote    bool
+	ColorBits int
+
+	// XRender uploads the image once and lets the server scale it and apply
+	// the opacity, while nothing else is drawn over it.
+	XRender bool
+
+	// Quirks is "auto", "none" or a comma separated list of quirks.
+	Quirks string
+
+	// Scale is how the image is sized within the window, Align where it is
+	// anchored when it doesn't cover the whole window.
+	Scale ScaleMode
+	Align Alignment
+
+	// Filter is the interpolation used when the image is scaled.
+	Filter ScaleFilter
+
+	// Blend is how the image is combined with the screen below the window.
+	Blend BlendMode
+
+	// With the difference blend mode, DiffGain multiplies the differences,
+	// DiffChannel shows the one of a color channel only and DiffBlink
+	// flashes the pixels that differ at all this often, see diffview.go.
+	DiffGain    float64
+	DiffChannel Channel
+	DiffBlink   time.Duration
+
+	// Transparency is how the window is made see-through, auto picks one
+	// depending on whether a compositing manager is running.
+	Transparency Transparency
+
+	// Split shows the image at full opacity on one side of a divider that
+	// can be dragged, and nothing on the other.
+	Split Split
+
+	// Fade is how long opacity changes take, FadeIn how long the window
+	// takes to appear.
+	Fade   time.Duration
+	FadeIn time.Duration
+
+	// Idle keeps the window hidden until there was no input for this long,
+	// like a screensaver, and hides it again on the first input.
+	Idle time.Duration
+
+	// ToggleKey shows and hides the window from anywhere, nil if unset.
+	ToggleKey *KeyCombo
+
+	// PresenterKeys grabs the media keys bound to next-image,
+	// previous-image and blank, so that a presenter remote works from
+	// anywhere, see presenter.go.
+	PresenterKeys bool
+
+	// PrivacyZones are covered with pixelated windows while privacy mode is
+	// on, PrivacyKey toggles it from anywhere.
+	PrivacyZones []image.Rectangle
+	PrivacyKey   *KeyCombo
+
+	// Pixelate replaces the image with blocks of this size, or only the
+	// Redact regions if there are any. The regions are in image pixels.
+	Pixelate int
+	Redact   []image.Rectangle
+
+	// Layout composes multiple Images into one window instead of showing
+	// one at a time, with Gap pixels between them and their file names
+	// below them if Labels is set.
+	Layout Layout
+	Gap    int
+	Labels bool
+
+	// AutoTrim removes uniform borders, like letterbox bars, first.
+	AutoTrim bool
+
+	// Crop cuts the image down to this rectangle, in image pixels, if it is
+	// not empty. It is then rotated clockwise by Rotate degrees, a multiple
+	// of 90, and flipped.
+	Crop   image.Rectangle
+	Rotate int
+	FlipH  bool
+	FlipV  bool
+
+	// CVD shows the image as it looks with a color vision deficiency.
+	CVD CVD
+
+	// Filters change the colors of the image, for comparing it with what
+	// is below.
+	Filters ColorFilters
+
+	// Contrast labels these zones of the image with the contrast ratio of
+	// their text, ContrastAuto marks the text anywhere in it that fails
+	// WCAG AA, see contrast.go.
+	Contrast     []image.Rectangle
+	ContrastAuto bool
+
+	// Corners projects the image onto these four window positions, of its
+	// top left, top right, bottom right and bottom left corner, instead of
+	// placing it with the scale mode. They have to form a convex shape.
+	Corners []image.Point
+
+	// RememberCorners stores the corners edited for an image and projects
+	// it onto them whenever it is shown again.
+	RememberCorners bool
+
+	// RememberState stores the geometry, opacity, scale mode and zoom of an
+	// image when another one is shown or the window is closed, and zooms
+	// into it like before whenever it is shown again.
+	RememberState bool
+
+	// Grid draws lines every Grid window pixels on top of the image, along
+	// with the Guides and pixel rulers along the edges if Ruler is set. The
+	// toggle-guides action hides and shows them.
+	Grid   int
+	Guides []Guide
+	Ruler  bool
+
+	// Picker starts with the color picker shown, toggle-picker shows and
+	// hides it.
+	Picker bool
+
+	// ShowInfo shows the info panel from the start, it is toggled with the
+	// toggle-info action.
+	ShowInfo bool
+
+	// Group shares the opacity and visibility with the other overlays of
+	// this group, once the overlay listens on a control socket.
+	Group string
+
+	// Random shows a random image instead of the next or previous one, with
+	// the random numbers seeded with Seed if it is not 0.
+	Random bool
+	Seed   uint64
+
+	// the image that is shown first, picked in New
+	firstImage int
+
+	// Output places the window on the monitor of this randr output, with
+	// Geometry relative to it, FullscreenOutput fills that monitor. The
+	// window moves along when monitors change.
+	Output           string
+	FullscreenOutput string
+
+	// RestartArgs is the command a session manager uses to start the
+	// overlay again, without the images and the state. Session management
+	// is disabled if it is nil. The images are appended to it unless
+	// RestartHasImages, like with a playlist that lists them.
+	RestartArgs      []string
+	RestartHasImages bool
+
+	// Image is shown if it is set, otherwise the first of Images, which are
+	// file names. Mirror shows another window instead, Follow keeps the
+	// overlay on top of another window. Windows are given by id, or by a
+	// part of their title or class.
+	Image  image.Image
+	Images []string
+	Mirror string
+	Follow string
+
+	// Clipboard shows the image in this selection, CLIPBOARD or PRIMARY,
+	// instead, e.g. a screenshot that was copied.
+	Clipboard string
+
+	// Receive shows what the overlay broadcasting on this address shows,
+	// see ListenBroadcast, and follows its opacity, visibility and
+	// geometry. ReceiveToken and ReceiveTLS are the token and the TLS
+	// configuration the broadcast requires, if any.
+	Receive      string
+	ReceiveToken string
+	ReceiveTLS   *tls.Config
+
+	// SourcePlugin is a program and its arguments that delivers the images
+	// to show, EffectPlugins are programs that every image is sent through
+	// in order. See plugin.go for the protocol.
+	SourcePlugin  []string
+	EffectPlugins [][]string
+
+	// Figma shows the export of a node of a figma file, and updates it when
+	// the file changes.
+	Figma *Figma
+
+	// URL shows an image on a web server, and updates it when it changes.
+	URL *URLImage
+
+	// Source produces the images while the overlay runs, like a clock or a
+	// command that renders a chart, see ParseSource.
+	Source Source
+
+	// TestPattern shows a test pattern drawn at the size of the window
+	// instead of an image, next-image and previous-image cycle through
+	// solid colors.
+	TestPattern TestPattern
+
+	// Stream shows the raw frames read from it instead of an image.
+	Stream       io.Reader
+	StreamFormat RawFormat
+
+	// StaleAfter marks the image of a live source as stale when nothing new
+	// arrived for this long, StaleStyle is how. Zero never does.
+	StaleAfter time.Duration
+	StaleStyle StaleStyle
+
+	// History keeps the last images of a live source, that many, to step
+	// back through with history-back and history-forward.
+	History int
+
+	// RecordDir is where the toggle-recording action records the images of
+	// a live source to, in RecordFormat.
+	RecordDir    string
+	RecordFormat RecordFormat
+
+	// Sequence plays the numbered images of a pattern like
+	// "frames/out-%04d.png" as an animation at FPS frames per second.
+	Sequence string
+	FPS      float64
+
+	// Magnify shows the screen around the pointer enlarged this many times
+	// instead of an image, with a grid between its pixels with MagnifyGrid
+	// and the pixel under the pointer marked with Crosshair.
+	Magnify     float64
+	MagnifyGrid bool
+	Crosshair   bool
+
+	// Screenshot is where the screenshot actions save the frame, a file, a
+	// directory or "-" for stdout. Files in the current directory are named
+	// after the time if it is empty.
+	Screenshot string
+
+	// Tick redraws the window this often, for text that shows the time.
+	// Zero redraws every second if there is such text.
+	Tick time.Duration
+
+	// Annotations are drawn over the image, text in Font, the Go font when
+	// nil. TextColor is the color of text annotated over the control socket.
+	Annotations []Annotation
+	Font        font.Face
+	TextColor   color.RGBA
+}
+
+type Window struct {
+	options Options
+
+	// the connection the window shares with the other overlays on it, which
+	// is closed along with the window if it was opened for it by New, and the
+	// id of the window among them
+	connection     *Connection
+	ownsConnection bool
+	id             int
+
+	// X resources
+	conn          *xgb.Conn
+	screen        *xproto.ScreenInfo
+	windowID      xproto.Window
+	transparentGc xproto.Gcontext
+	resources     *xResources
+	keyboard      *keyboardMapping
+	grabbedKeys   []grabbedKey
+	quirks        quirks
+	depth         byte
+	visual        xproto.Visualid
+	transparency  Transparency
+	useShm        bool
+	atoms         map[string]xproto.Atom
+	atomsMu       sync.Mutex
+	// guards keyboard and grabbedKeys, keys are grabbed by SetKeymap too
+	grabMu sync.Mutex
+
+	// the group the opacity and visibility are shared with
+	group   controlGroup
+	groupMu sync.Mutex
+
+	// sends the changes to other overlays, nil if it doesn't
+	broadcast   *BroadcastServer
+	broadcastMu sync.Mutex
+
+	// the events the connection handed to the window, and those that
+	// arrived while waiting for the startup requests
+	xevents       chan xEvent
+	pendingEvents []xgb.Event
+
+	// the image we want to render and where it came from
+	source string
+	image  image.Image
+
+	// the size hints set on the window, and the aspect ratio they lock it
+	// to, zero if they don't
+	hints  sizeHints
+	aspect image.Point
+
+	// the image is a spinner or broken image shown for the source, and the
+	// error of the source drawn over the image otherwise
+	placeholder bool
+	errorPanel  *image.RGBA
+
+	// when the source last delivered or confirmed the image, and the badge
+	// of a stale image, nil while it isn't
+	freshAt    time.Time
+	stalePanel *image.RGBA
+
+	// drawn over the image, and the text of the last webhook request over
+	// them
+	annotations []*annotation
+	message     *annotation
+
+	// the last images of a live source, nil without a history
+	history *frameHistory
+
+	// writes the images of a live source to a file, nil while it doesn't
+	recording   *recording
+	recordingMu sync.Mutex
+
+	// svgs are rasterized again whenever the size changes
+	vector       *oksvg.SvgIcon
+	vectorRaster vectorRaster
+
+	// what we offer to other clients as the clipboard
+	clipboard clipboard
+
+	// set when showing another window instead of an image
+	mirror *windowMirror
+	// set when the window is kept on top of another window
+	follower *windowFollower
+	// the screen below the window, only captured for blend modes
+	backdrop *backdrop
+	// nil without privacy zones
+	privacy *privacyScreen
+	// set when the window is placed on a specific monitor
+	output *outputPlacement
+
+	// the image as it was decoded, before redacting and transforming it
+	loaded decodedImage
+	// rotation in degrees added with the rotate action
+	rotation int
+	// the color filters shown, Options.Filters until they are toggled
+	filters ColorFilters
+	// the contrast measured in the image shown
+	contrast []*contrastCheck
+	// the changes that can be undone and done again
+	undo undoHistory
+	// the views saved to recall them, see bookmark.go
+	bookmarks [maxBookmarks]*bookmark
+
+	// who gets the frames that are shown, see frames.go
+	onFrame      func(frame image.Image)
+	frameWaiters []chan *image.RGBA
+
+	// the images given on the command line that can be cycled through
+	images     []string
+	imageIndex int
+	// picks the next image instead of going through them in order if set
+	random *rand.Rand
+	// the solid color shown instead of the test pattern, 0 for the pattern,
+	// and the gray of the gamma pattern
+	patternIndex int
+	gammaLevel   int
+
+	// whether RunSlideshow goes through the images
+	slideshowRunning bool
+
+	// animation state, only used for animated images
+	frames     []animationFrame
+	plays      int
+	playsDone  int
+	frameIndex int
+	nextFrame  time.Time
+	frameCache frameCache
+	bandCache  bandCache
+	shmBuffer  *shmSegment
+
+	// a frame of the stream arrived and has not been rendered yet
+	streamFrame bool
+	// the full resolution of a reduced image was requested already
+	fullResolution bool
+
+	// set while the window is moved or resized with the mouse in
+	// override redirect mode
+	drag *windowDrag
+
+	// where the corners of the image are projected to, nil to place it
+	// with the scale mode, the corner that is dragged, -1 if none, and the
+	// one that is moved with the nudge keys
+	corners        []image.Point
+	editCorners    bool
+	draggedCorner  int
+	selectedCorner int
+	warpSource     warpSource
+
+	// zoom and pan, and the last pointer position while panning
+	view    viewport
+	panning bool
+	panFrom image.Point
+
+	// the info panel, rendered again when the image changes
+	info      imageInfo
+	showInfo  bool
+	infoCache *image.RGBA
+
+	// the help lists the keys, rendered for a window of helpHeight
+	showHelp   bool
+	helpCache  *image.RGBA
+	helpHeight int
+
+	// the message of Notify and how many there were, to hide only the last
+	notice       *image.RGBA
+	noticeSerial int
+
+	// windows added with Add, which get the key bindings of this one
+	added []*Window
+
+	// the grid, guides and rulers are drawn
+	showGuides bool
+
+	// the divider of the split window as a fraction of its size, whether
+	// it is dragged, and the shape last set for it by the render loop
+	splitPosition float64
+	splitDragging bool
+	splitShape    image.Rectangle
+
+	// the color picker, and whether the windows below are redirected so
+	// that it can read them
+	picker     picker
+	redirected bool
+
+	// the frames of Options.Sequence that were loaded, nil without one
+	sequence *imageSequence
+
+	// what the loupe captured, nil without Options.Magnify
+	magnifier *magnifier
+
+	// frames are rendered into it before they are shown
+	backBuffer backBuffer
+
+	// the running effect plugins, and what they made of the last image
+	effects     []*plugin
+	effectCache effectCache
+
+	// scales and blends the image on the server, nil if it isn't
+	xrender *xrenderer
+
+	// used instead of the shared memory segment in remote mode
+	pixelBuffer []byte
+	// the converted tile when tiling the image
+	tileBuffer []byte
+
+	// the last _NET_WM_WINDOW_OPACITY we set, only used by the render loop
+	opacityHint    uint32
+	opacityHintSet bool
+
+	// bookkeeping for debounced rendering
+	imageOpacity  float64
+	opacityFade   opacityFade
+	windowWidth   int
+	windowHeight  int
+	nextRedraw    time.Time
+	nextTick      time.Time
+	dirty         bool
+	previewRedraw bool
+	settleRedraw  time.Time
+	lastResize    time.Time
+	mapped        bool
+	obscured      bool
+	throttle      time.Duration
+	lastRender    time.Time
+	renderErr     error
+	dropCaches    bool
+	renderMu      sync.Mutex
+	wg            sync.WaitGroup
+
+	// wakes up the renderer when the bookkeeping above changed
+	renderWake chan struct{}
+
+	// how the renderer tells the time and renders, time.Now and
+	// renderImage unless a test replaces them
+	now    func() time.Time
+	render func(highQuality bool) error
+
+	// done once the window is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	events       chan Event
+	eventsMu     sync.Mutex
+	eventsClosed bool
+	closed       chan struct{}
+	closeOnce    sync.Once
+	stateSaved   sync.Once
+	err          error
+}
+
+func (imageWindow *Window) setupX(connection *Connection) error {
+	conn := connection.conn
+
+	imageWindow.connection = connection
+	imageWindow.conn = conn
+	imageWindow.screen = connection.screen
+	imageWindow.resources = connection.resources
+
+	var err error
+	imageWindow.quirks, err = parseQuirks(imageWindow.options.Quirks, xproto.Setup(conn).Vendor)
+	if err != nil {
+		return err
+	}
+
+	imageWindow.useShm = shmSupported && !imageWindow.options.Remote && !imageWindow.quirks.noShm
+
+	slog.Debug("connect", "vendor", xproto.Setup(conn).Vendor, "shm", imageWindow.useShm)
+
+	if imageWindow.useShm {
+		err = connection.initShm()
+		// e.g. nested servers like Xephyr built without the extension, the
+		// pixels are sent over the connection instead
+		if err != nil {
+			slog.Warn("init shm, falling back to sending the pixels", "err", err)
+			imageWindow.useShm = false
+		}
+	}
+
+	keyboard, err := loadKeyboardMapping(conn)
+	if err != nil {
+		return fmt.Errorf("load keyboard mapping: %w", err)
+	}
+
+	imageWindow.keyboard = keyboard
+
+	return nil
+}
+
+type decodedImage struct {
+	image  image.Image
+	frames []animationFrame
+	plays  int
+
+	// set for svgs, which are rasterized at the size they are shown at
+	vector *oksvg.SvgIcon
+
+	// shown in the info panel
+	info imageInfo
+
+	// the size of the file's image if it was decoded smaller, see
+	// decodeJPEGScaled
+	fullSize image.Point
+}
+
+// decodeImage decodes an image. If target is not zero, jpegs that are
+// larger than it are decoded at a reduced size that still covers it.
+func decodeImage(imageBytes []byte, animate bool, target image.Point) (decodedImage, error) {
+	decoded, err := decodePixels(imageBytes, animate, target)
+	if err != nil {
+		return decodedImage{}, err
+	}
+
+	decoded.info = describeImage(imageBytes)
+	if decoded.fullSize.X > 0 {
+		decoded.info.reduction = int(math.Round(float64(decoded.fullSize.X) / float64(decoded.image.Bounds().Dx())))
+	}
+
+	return decoded, nil
+}
+
+func decodePixels(imageBytes []byte, animate bool, target image.Point) (decodedImage, error) {
+	if isSVG(imageBytes) {
+		icon, err := decodeSVG(imageBytes)
+		if err != nil {
+			return decodedImage{}, err
+		}
+
+		width, height := svgSize(icon)
+
+		return decodedImage{
+			image:  rasterizeSVG(icon, width, height),
+			vector: icon,
+		}, nil
+	}
+
+	if animate {
+		frames, plays, err := decodeAnimation(imageBytes)
+		if err != nil {
+			return decodedImage{}, fmt.Errorf("decode animation: %w", err)
+		}
+
+		if len(frames) > 0 {
+			return decodedImage{
+				image:  frames[0].image,
+				frames: frames,
+				plays:  plays,
+			}, nil
+		}
+	}
+
+	if img, fullSize, ok := decodeJPEGScaled(imageBytes, target); ok {
+		return decodedImage{image: img, fullSize: fullSize}, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return decodedImage{}, fmt.Errorf("decode image: %w", err)
+	}
+
+	return decodedImage{image: img}, nil
+}
+
+func readImage(filename string) ([]byte, error) {
+	if filename == "-" {
+		imageBytes, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read image bytes from stdin: %w", err)
+		}
+
+		return imageBytes, nil
+	}
+
+	imageBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read image bytes from file: %w", err)
+	}
+
+	return imageBytes, nil
+}
+
+func (display *Window) setImage(source string, decoded decodedImage) {
+	display.show(source, decoded, false)
+}
+
+// showPlaceholder shows a spinner or broken image, which effects and
+// transformations are not applied to.
+func (display *Window) showPlaceholder(source string, decoded decodedImage) {
+	display.show(source, decoded, true)
+}
+
+func (display *Window) show(source string, decoded decodedImage, placeholder bool) {
+	display.renderMu.Lock()
+	display.loaded = decoded
+	rotation := display.rotation
+	filters := display.filters
+	display.renderMu.Unlock()
+
+	if !placeholder {
+		decoded = display.applyEffects(decoded)
+		decoded = display.options.prepare(decoded, rotation, filters)
+	}
+
+	display.renderMu.Lock()
+	previous := display.source
+	changed := source != previous
+	display.renderMu.Unlock()
+
+	var contrast []*contrastCheck
+	if !placeholder {
+		contrast = display.options.checkContrast(decoded.image)
+	}
+
+	// every image has corners and a zoom of its own
+	var corners []image.Point
+	var view viewport
+	var remembered bool
+	if changed {
+		display.rememberState(previous)
+		display.resetUndo()
+		corners = display.cornersFor(source)
+		view, remembered = display.rememberedView(source)
+	}
+
+	display.renderMu.Lock()
+	display.source = source
+	display.placeholder = placeholder
+	display.errorPanel = nil
+	display.image = decoded.image
+	display.vector = decoded.vector
+	display.contrast = contrast
+	display.frames = decoded.frames
+	display.frameCache = frameCache{}
+	display.plays = decoded.plays
+	display.info = decoded.info
+	display.infoCache = nil
+	display.fullResolution = false
+	display.playsDone = 0
+	display.frameIndex = 0
+	display.nextFrame = time.Time{}
+
+	if changed {
+		display.corners = corners
+		if display.editCorners && corners == nil {
+			display.corners = display.placedCorners()
+		}
+
+		if remembered {
+			display.view = view
+		}
+	}
+	display.renderMu.Unlock()
+
+	if display.options.LockAspect && !placeholder {
+		display.lockAspect(decoded.image.Bounds().Size())
+	}
+
+	display.requestRedraw()
+	display.emit(Event{Kind: EventImage, Source: source})
+}
+
+func newWindow(connection *Connection, options Options, loaded decodedImage) (*Window, error) {
+	// placeholders are shown as they are, cropping or rotating them is
+	// meant for the image
+	decoded := loaded
+	if !options.asynchronous() {
+		decoded = options.prepare(loaded, 0, options.Filters)
+	}
+
+	source := ""
+	images := options.Images
+	switch {
+	case options.board():
+		// all images are shown at once, there is nothing to cycle through
+		images = nil
+	case options.Image == nil && len(options.Images) > 0:
+		source = options.Images[options.firstImage]
+	}
+
+	imageWindow := &Window{
+		options:       options,
+		imageOpacity:  options.InitialOpacity,
+		source:        source,
+		images:        images,
+		imageIndex:    options.firstImage,
+		image:         decoded.image,
+		vector:        decoded.vector,
+		frames:        decoded.frames,
+		plays:         decoded.plays,
+		info:          decoded.info,
+		showInfo:      options.ShowInfo,
+		showGuides:    options.Grid > 0 || len(options.Guides) > 0 || options.Ruler,
+		gammaLevel:    defaultGammaLevel,
+		group:         controlGroup{name: options.Group},
+		loaded:        loaded,
+		filters:       options.Filters,
+		placeholder:   options.asynchronous(),
+		draggedCorner: -1,
+		windowWidth:   decoded.image.Bounds().Dx(),
+		windowHeight:  decoded.image.Bounds().Dy(),
+		xevents:       make(chan xEvent, windowEventQueueSize),
+		events:        make(chan Event, eventBufferSize),
+		renderWake:    make(chan struct{}, 1),
+		now:           time.Now,
+		closed:        make(chan struct{}),
+	}
+
+	imageWindow.render = imageWindow.renderImage
+
+	imageWindow.corners = imageWindow.cornersFor(source)
+	imageWindow.view, _ = imageWindow.rememberedView(source)
+
+	if options.History > 0 && options.live() {
+		imageWindow.history = newFrameHistory(options.History)
+	}
+
+	if options.FadeIn > 0 {
+		imageWindow.imageOpacity = 0
+		imageWindow.opacityFade = opacityFade{
+			active:   true,
+			to:       options.InitialOpacity,
+			duration: options.FadeIn,
+		}
+	}
+
+	err := imageWindow.setupX(connection)
+	if err != nil {
+		return nil, fmt.Errorf("setup x: %w", err)
+	}
+
+	imageWindow.ctx, imageWindow.cancel = context.WithCancel(context.Background())
+
+	go imageWindow.startRenderer(imageWindow.ctx)
+
+	if options.Limits.enabled() {
+		go imageWindow.enforceLimits(imageWindow.ctx, options.Limits)
+	}
+
+	return imageWindow, nil
+}
+
+const (
+	redrawDebounce     = 50 * time.Millisecond
+	minPreviewDebounce = 10 * time.Millisecond
+	maxPreviewDebounce = 100 * time.Millisecond
+	resizeSettleDelay  = 200 * time.Millisecond
+
+	// every redraw has to go over the network in remote mode
+	remoteDebounceFactor = 4
+)
+
+// debounce scales a debounce delay for the current mode.
+func (display *Window) debounce(delay time.Duration) time.Duration {
+	if display.options.Remote {
+		return delay * remoteDebounceFactor
+	}
+
+	return delay
+}
+
+// wakeRenderer makes the renderer look at what changed, unless it is about
+// to anyway.
+func (display *Window) wakeRenderer() {
+	select {
+	case display.renderWake <- struct{}{}:
+	default:
+	}
+}
+
+func (display *Window) requestRedraw() {
+	display.renderMu.Lock()
+	display.dirty = true
+	display.previewRedraw = false
+	display.settleRedraw = time.Time{}
+	display.nextRedraw = display.now().Add(display.debounce(redrawDebounce))
+	display.renderMu.Unlock()
+
+	display.wakeRenderer()
+}
+
+// requestResizeRedraw schedules a cheap preview render while the window is
+// being resized and a high quality render once the resizing has settled. The
+// faster the window is resized the longer we wait between previews, so that
+// rendering does not fall behind the stream of configure events.
+func (display *Window) requestResizeRedraw(deltaPixels int) {
+	now := display.now()
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	debounce := maxPreviewDebounce
+	if !display.lastResize.IsZero() {
+		elapsed := now.Sub(display.lastResize)
+		// pixels per millisecond
+		velocity := float64(deltaPixels) / max(1, float64(elapsed)/float64(time.Millisecond))
+		debounce = min(maxPreviewDebounce, minPreviewDebounce+time.Duration(velocity*float64(minPreviewDebounce)))
+	}
+
+	display.lastResize = now
+
+	if !display.dirty {
+		display.nextRedraw = now.Add(display.debounce(debounce))
+	}
+
+	display.dirty = true
+	display.previewRedraw = true
+	display.settleRedraw = now.Add(display.debounce(resizeSettleDelay))
+
+	display.wakeRenderer()
+}
+
+func (display *Window) startRenderer(ctx context.Context) {
+	display.wg.Add(1)
+	defer display.wg.Done()
+
+	// the X server drops its attachment when the connection is closed and
+	// the segment is already marked for removal, we only have to unmap it
+	defer func() {
+		if display.shmBuffer != nil {
+			shmDetach(display.shmBuffer.Bytes())
+		}
+	}()
+
+	// the first render happens right away
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	wasVisible := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-display.renderWake:
+		case <-timer.C:
+		}
+
+		next := display.renderPending(&wasVisible)
+
+		// renders that are due are only postponed by other requests, a
+		// burst of resizes ends up in a single render
+		timer.Stop()
+		if !next.IsZero() {
+			timer.Reset(next.Sub(display.now()))
+		}
+	}
+}
+
+// renderPending renders if a render is due and returns when the renderer has
+// to look again, or the zero time if only a wake up brings more work.
+func (display *Window) renderPending(wasVisible *bool) time.Time {
+	display.renderMu.Lock()
+	dirty := display.dirty
+	nextRedraw := display.nextRedraw
+	previewRedraw := display.previewRedraw
+	settleRedraw := display.settleRedraw
+	visible := display.mapped && !display.obscured
+	throttle := display.throttle
+	dropCaches := display.dropCaches
+	display.dropCaches = false
+	display.renderMu.Unlock()
+
+	if dropCaches {
+		display.dropCachedFrames()
+	}
+
+	// keep everything as it is until the minimum time between renders
+	// has passed, the resource limits slow us down this way
+	if throttle > 0 && display.now().Sub(display.lastRender) < throttle {
+		return display.lastRender.Add(throttle)
+	}
+
+	// there is no point in rendering or playing animations while nobody
+	// can see the window, showing it wakes us up again
+	if !visible {
+		*wasVisible = false
+		return time.Time{}
+	}
+
+	if !*wasVisible {
+		*wasVisible = true
+		// restart the delay of the current frame instead of skipping
+		// ahead by the time we were hidden
+		display.renderMu.Lock()
+		display.nextFrame = time.Time{}
+		display.renderMu.Unlock()
+	}
+
+	now := display.now()
+	frameChanged := display.advanceFrame(now)
+	fadeChanged := display.advanceFade(now)
+	streamed := display.takeStreamFrame()
+	ticked := display.advanceTick(now)
+	resizing := !settleRedraw.IsZero()
+
+	var render, highQuality bool
+
+	switch {
+	case dirty && !now.Before(nextRedraw):
+		render = true
+		highQuality = !previewRedraw
+		display.renderMu.Lock()
+		display.dirty = false
+		display.renderMu.Unlock()
+	case resizing && !now.Before(settleRedraw):
+		render = true
+		highQuality = true
+		display.renderMu.Lock()
+		display.settleRedraw = time.Time{}
+		display.lastResize = time.Time{}
+		display.renderMu.Unlock()
+	case frameChanged || fadeChanged || streamed || ticked:
+		render = true
+		highQuality = !resizing
+	}
+
+	if render {
+		display.lastRender = now
+
+		err := display.render(highQuality)
+		if err != nil {
+			slog.Error("render image", "err", err)
+		}
+
+		display.renderMu.Lock()
+		display.renderErr = err
+		display.renderMu.Unlock()
+	}
+
+	return display.nextRenderTime(now)
+}
+
+// nextRenderTime returns the earliest time a pending render, animation frame
+// or fade step is due, or the zero time if there is none.
+func (display *Window) nextRenderTime(now time.Time) time.Time {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	var next time.Time
+	due := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	if display.dirty {
+		due(display.nextRedraw)
+	}
+
+	if !display.settleRedraw.IsZero() {
+		due(display.settleRedraw)
+	}
+
+	if len(display.frames) > 1 && (display.plays == 0 || display.playsDone < display.plays) {
+		// the delay of the frame starts with the next look
+		if display.nextFrame.IsZero() {
+			due(now)
+		} else {
+			due(display.nextFrame)
+		}
+	}
+
+	if display.opacityFade.active {
+		due(display.opacityFade.lastStep.Add(display.debounce(minFadeStep)))
+	}
+
+	if display.streamFrame {
+		due(now)
+	}
+
+	if !display.nextTick.IsZero() {
+		due(display.nextTick)
+	}
+
+	return next
+}
+
+// advanceFrame moves to the next animation frame once the delay of the
+// current one has passed and reports whether the frame changed.
+func (display *Window) advanceFrame(now time.Time) bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if len(display.frames) < 2 {
+		return false
+	}
+
+	if display.plays > 0 && display.playsDone >= display.plays {
+		return false
+	}
+
+	if display.nextFrame.IsZero() {
+		display.nextFrame = now.Add(display.frames[display.frameIndex].delay)
+		return false
+	}
+
+	if now.Before(display.nextFrame) {
+		return false
+	}
+
+	next := display.frameIndex + 1
+	if next == len(display.frames) {
+		display.playsDone++
+		if display.plays > 0 && display.playsDone >= display.plays {
+			// keep showing the last frame
+			return false
+		}
+
+		next = 0
+	}
+
+	display.frameIndex = next
+	display.image = display.frames[next].image
+	display.nextFrame = now.Add(display.frames[next].delay)
+
+	return true
+}
+
+func (display *Window) setMapped(mapped bool) {
+	display.renderMu.Lock()
+	display.mapped = mapped
+	display.renderMu.Unlock()
+
+	if mapped {
+		display.requestRedraw()
+		display.emit(Event{Kind: EventShown})
+	} else {
+		display.emit(Event{Kind: EventHidden})
+	}
+}
+
+func (display *Window) setObscured(obscured bool) {
+	display.renderMu.Lock()
+	wasObscured := display.obscured
+	display.obscured = obscured
+	display.renderMu.Unlock()
+
+	if wasObscured && !obscured {
+		display.requestRedraw()
+	}
+}
+
+// Close closes the window, and the connection to the X server if it was
+// opened by New.
+func (display *Window) Close() {
+	display.closeOnce.Do(func() {
+		// unless it was closed already, the window is still there
+		select {
+		case <-display.closed:
+		default:
+			display.rememberFinalState()
+		}
+
+		if display.mirror != nil {
+			display.mirror.destroyed()
+		}
+
+		display.cancel()
+
+		// what was recorded so far is kept
+		if rec := display.takeRecording(); rec != nil {
+			_, err := rec.stop()
+			if err != nil {
+				slog.Error("stop recording", "err", err)
+			}
+		}
+
+		if display.ownsConnection {
+			display.connection.Close()
+			display.wg.Wait()
+
+			return
+		}
+
+		display.connection.remove(display)
+		display.wg.Wait()
+		display.release()
+	})
+}
+
+// release frees what the window created on a connection that stays open.
+// Pixmaps of mirrors and privacy zones are left to the connection, there
+// are few of them.
+func (display *Window) release() {
+	if display.windowID == 0 {
+		return
+	}
+
+	// grabs are shared by all windows of the connection, ungrabbing would
+	// take the keys from the others
+	xproto.DestroyWindow(display.conn, display.windowID)
+
+	if display.backBuffer.pixmap != 0 {
+		xproto.FreePixmap(display.conn, display.backBuffer.pixmap)
+	}
+
+	if display.xrender != nil {
+		display.xrender.release(display)
+	}
+
+	// the renderer unmapped the segment already
+	if display.shmBuffer != nil {
+		shm.Detach(display.conn, display.shmBuffer.segID)
+	}
+}
+
+// selectVisual picks the visual for the transparency, and the transparency
+// that works with the visuals there are.
+func (display *Window) selectVisual() (*xproto.VisualInfo, error) {
+	transparency, err := display.resolveTransparency()
+	if err != nil {
+		return nil, fmt.Errorf("detect compositor: %w", err)
+	}
+
+	var visualInfo *xproto.VisualInfo
+	if transparency == TransparencyARGB {
+		visualInfo = matchVisualInfo(display.screen.AllowedDepths, depthWithAlpha, classTrueColor)
+	}
+
+	display.depth = depthWithAlpha
+
+	// without an alpha channel the premultiplied pixels are drawn onto
+	// black, so the image still gets darker with less opacity
+	if visualInfo == nil {
+		visualInfo = matchVisualInfo(display.screen.AllowedDepths, depthOpaque, classTrueColor)
+		display.depth = depthOpaque
+
+		if transparency == TransparencyARGB {
+			transparency = TransparencyNone
+		}
+	}
+
+	display.transparency = transparency
+
+	slog.Debug("select visual", "transparency", transparency, "depth", display.depth)
+
+	if visualInfo == nil {
+		return nil, ErrNoVisual
+	}
+
+	return visualInfo, nil
+}
+
+func (display *Window) createWindow() error {
+	visualInfo, err := display.selectVisual()
+	if err != nil {
+		return err
+	}
+
+	display.visual = visualInfo.VisualId
+
+	colorMapID, err := display.resources.colormap(visualInfo.VisualId)
+	if err != nil {
+		return fmt.Errorf("get colormap: %w", err)
+	}
+
+	windowID, err := xproto.NewWindowId(display.conn)
+	if err != nil {
+		return fmt.Errorf("new window id: %w", err)
+	}
+
+	display.windowID = windowID
+
+	err = display.connection.add(display)
+	if err != nil {
+		return err
+	}
+
+	// without a background the server doesn't clear the window when it is
+	// resized, which would flash before the next frame is copied onto it
+	mask := uint32(xproto.CwBackPixmap | xproto.CwBorderPixel | xproto.CwEventMask | xproto.CwColormap)
+	values := []uint32{
+		xproto.BackPixmapNone,
+		0, // black border
+	}
+
+	// values have to be in the same order as the bits in the mask
+	if display.options.OverrideRedirect {
+		mask |= xproto.CwOverrideRedirect
+		values = append(values, 1)
+	}
+
+	values = append(values,
+		xproto.EventMaskStructureNotify|
+			xproto.EventMaskExposure|
+			xproto.EventMaskVisibilityChange|
+			xproto.EventMaskKeyPress|
+			xproto.EventMaskButtonPress|
+			xproto.EventMaskButtonRelease|
+			xproto.EventMaskPointerMotion|
+			xproto.EventMaskLeaveWindow|
+			xproto.EventMaskButton1Motion|
+			xproto.EventMaskButton2Motion|
+			xproto.EventMaskButton3Motion,
+		uint32(colorMapID),
+	)
+
+	imageWidth := display.image.Bounds().Dx()
+	imageHeight := display.image.Bounds().Dy()
+
+	geometry := display.options.Geometry
+	if display.options.LockSize {
+		geometry.Width = imageWidth
+		geometry.Height = imageHeight
+	}
+
+	x, y, width, height := display.place(geometry, imageWidth, imageHeight)
+
+	// everything up to mapping the window is sent without waiting for
+	// replies, errors are collected by a single sync at the end. Over
+	// forwarded connections every round trip is noticeable.
+	err = display.internAtoms(display.startupAtoms()...)
+	if err != nil {
+		return err
+	}
+
+	area, err := xRectangle(image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return fmt.Errorf("create window: %w", err)
+	}
+
+	xproto.CreateWindow(
+		display.conn,
+		display.depth,
+		windowID,
+		display.screen.Root,           // parent
+		area.X,                        // x
+		area.Y,                        // y
+		area.Width,                    // width
+		area.Height,                   // height
+		0,                             // border width
+		xproto.WindowClassInputOutput, // class
+		visualInfo.VisualId,
+		mask,
+		values,
+	)
+
+	display.windowWidth = width
+	display.windowHeight = height
+
+	// without these hints most window managers ignore the position we asked
+	// for and place the window wherever they like
+	hints := sizeHints{
+		flags:      sizeHintUSSize | sizeHintPWinGravity,
+		width:      int32(width),
+		height:     int32(height),
+		winGravity: gravityStatic,
+	}
+
+	if display.options.Geometry.HasPosition {
+		hints.flags |= sizeHintUSPosition
+		hints.x = int32(x)
+		hints.y = int32(y)
+	}
+
+	if display.options.LockSize {
+		hints.flags |= sizeHintPMinSize | sizeHintPMaxSize
+		hints.minWidth = int32(width)
+		hints.minHeight = int32(height)
+		hints.maxWidth = int32(width)
+		hints.maxHeight = int32(height)
+	} else {
+		hints.flags |= sizeHintPMinSize
+		hints.minWidth = minWindowSize
+		hints.minHeight = minWindowSize
+	}
+
+	// placeholders have an aspect ratio of their own, the first image sets
+	// it
+	if display.options.LockAspect && !display.placeholder {
+		display.aspect = image.Pt(imageWidth, imageHeight)
+		hints = hints.withAspect(display.aspect)
+	}
+
+	display.hints = hints
+
+	display.setNormalHints(hints)
+
+	if display.options.NoDecorations {
+		err = display.setNoDecorations()
+		if err != nil {
+			return fmt.Errorf("disable decorations: %w", err)
+		}
+	}
+
+	if display.options.Layer != "" {
+		err = display.setWindowType(display.options.Layer)
+		if err != nil {
+			return fmt.Errorf("set window type: %w", err)
+		}
+	}
+
+	// window managers read the initial state when the window is mapped, the
+	// client messages below are for the ones that only react to those
+	states := display.initialStates()
+	if len(states) > 0 {
+		err = display.setAtomsProperty("_NET_WM_STATE", states)
+		if err != nil {
+			return fmt.Errorf("set initial window state: %w", err)
+		}
+	}
+
+	display.setClass()
+
+	err = display.setAtomsProperty("WM_PROTOCOLS", display.protocols())
+	if err != nil {
+		return fmt.Errorf("set protocols: %w", err)
+	}
+
+	if display.options.RestartArgs != nil {
+		err = display.setupSession()
+		if err != nil {
+			return fmt.Errorf("set up session management: %w", err)
+		}
+	}
+
+	// a screensaver waits for the user to be idle
+	if display.options.Idle <= 0 {
+		xproto.MapWindow(display.conn, windowID)
+	}
+
+	err = display.syncRequests()
+	if err != nil {
+		return fmt.Errorf("create window: %w", err)
+	}
+
+	for _, state := range states {
+		err = display.changeNetWmState(netWmStateAdd, state)
+		if err != nil {
+			return fmt.Errorf("change window state: %w", err)
+		}
+	}
+
+	// some window managers only look at the hints when the window is first
+	// mapped and still place it themselves, so we ask again explicitly
+	if display.options.Geometry.HasPosition {
+		err = display.moveWindow(x, y)
+		if err != nil {
+			return fmt.Errorf("move window: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (display *Window) renderImage(highQuality bool) error {
+	geom, err := xproto.GetGeometry(display.conn, xproto.Drawable(display.windowID)).Reply()
+	if err != nil {
+		return fmt.Errorf("get geometry: %w", err)
+	}
+
+	display.renderMu.Lock()
+	img := display.image
+	opacity := display.imageOpacity
+	frameIndex := display.frameIndex
+	animated := len(display.frames) > 1
+	var animation image.Image
+	if animated {
+		animation = display.frames[0].image
+	}
+	vector := display.vector
+	view := display.view
+	corners := display.corners
+	editCorners := display.editCorners
+	selectedCorner := display.selectedCorner
+	splitPosition := display.splitPosition
+	display.renderMu.Unlock()
+
+	// split, the image is compared with what is below at full opacity
+	if display.options.Split != SplitNone {
+		opacity = 1
+	}
+
+	// the compositor applies the opacity to the whole window instead
+	if display.transparency == TransparencyOpacityHint {
+		err = display.setOpacityHint(opacity)
+		if err != nil {
+			return err
+		}
+
+		opacity = 1
+	}
+
+	originalBounds := img.Bounds()
+	imageWidth := originalBounds.Dx()
+	imageHeight := originalBounds.Dy()
+
+	window := image.Rect(0, 0, int(geom.Width), int(geom.Height))
+	mode := display.options.Scale
+
+	var splitSide image.Rectangle
+	if display.options.Split != SplitNone {
+		splitSide = display.splitSide(window, splitPosition)
+
+		err = display.setSplitShape(splitSide)
+		if err != nil {
+			return err
+		}
+	}
+	placed := view.apply(placeImage(mode, display.options.Align, imageWidth, imageHeight, window.Dx(), window.Dy()))
+
+	// projected onto the corners, the image is scaled to the rectangle
+	// around them and warped from there, over the whole window
+	warped := corners != nil
+	if warped {
+		placed = cornerBounds(corners)
+	}
+
+	// zoomed in or resized beyond what the image was decoded at, it is
+	// shown enlarged until the full resolution is there
+	if placed.Dx() > imageWidth || placed.Dy() > imageHeight {
+		display.loadFullResolution()
+	}
+
+	// the part of the window we draw into, tiles cover all of it
+	visible := placed.Intersect(window)
+	if mode == ScaleTile || warped {
+		visible = window
+	}
+
+	// the graphics context is only created once we actually draw something
+	gc, err := display.resources.gc(display.depth, xproto.Drawable(display.windowID))
+	if err != nil {
+		return fmt.Errorf("get graphics context: %w", err)
+	}
+
+	if visible.Empty() {
+		return display.presentEmpty(gc, window)
+	}
+
+	xOffset := visible.Min.X
+	yOffset := visible.Min.Y
+	width := visible.Dx()
+	height := visible.Dy()
+
+	// svgs are rasterized at the target size instead of scaling a bitmap, so
+	// they stay sharp
+	if vector != nil {
+		size := vectorSize(placed)
+		img = display.vectorRaster.get(vector, size.X, size.Y)
+	}
+
+	// previews while resizing always use the fastest filter
+	filter := FilterNearest
+	if highQuality {
+		filter = display.options.Filter.resolve(img.Bounds().Size(), placed.Size())
+	}
+
+	if display.canRenderOnServer(mode, warped, editCorners) {
+		err = display.renderOnServer(img, placed, visible, window, opacity, filter, gc)
+		if err == nil {
+			if display.wantsFrames() {
+				display.emitServerFrame()
+			}
+
+			return nil
+		}
+
+		// e.g. a filter the server doesn't know, the frame is rendered on
+		// the cpu from now on
+		slog.Warn("render on the server, falling back to the cpu", "err", err)
+		display.xrender.release(display)
+		display.xrender = nil
+	}
+
+	// the image as a whole, the loupe marks its pixels
+	magnified, magnifiedPlaced := img, placed
+
+	if view.scale() > 1 && mode != ScaleTile && !warped {
+		src, dst := visibleSource(img, placed, visible)
+		if cropped, ok := cropImage(img, src); ok {
+			img = cropped
+			placed = dst
+		}
+	}
+
+	// the pixels we produce from the image: the visible part of the scaled
+	// image, or a single tile that is repeated afterwards
+	srcWidth := width
+	srcHeight := height
+	scaled := placed.Sub(visible.Min)
+
+	if mode == ScaleTile && !warped {
+		srcWidth = placed.Dx()
+		srcHeight = placed.Dy()
+		scaled = image.Rect(0, 0, srcWidth, srcHeight)
+	}
+
+	cacheKey := frameCacheKey{
+		width:   srcWidth,
+		height:  srcHeight,
+		scaled:  scaled,
+		opacity: opacity,
+		filter:  filter,
+	}
+
+	// images that are shown at their original size and are stored in a
+	// format we can convert directly are cropped and written straight into
+	// the shared memory segment without scaling them first
+	unscaled := false
+	if !warped && img.Bounds().Size() == scaled.Size() && canWriteUnscaled(img) {
+		crop := image.Rect(0, 0, srcWidth, srcHeight).Sub(scaled.Min).Add(img.Bounds().Min)
+		img, unscaled = cropImage(img, crop)
+	}
+
+	size, err := pixelBytes(width, height)
+	if err != nil {
+		return err
+	}
+
+	var shmBuffer *shmSegment
+	var buf []byte
+
+	if display.useShm {
+		shmBuffer, err = display.shmBufferFor(size)
+		// the server can't attach our segments if it runs on another
+		// machine, e.g. over ssh -X, even though it has the extension
+		if err != nil {
+			slog.Warn("get shared memory buffer, falling back to sending the pixels", "err", err)
+			display.useShm = false
+		}
+	}
+
+	if shmBuffer == nil {
+		buf = display.pixelBufferFor(size)
+	} else {
+		buf = shmBuffer.Bytes()[:size]
+	}
+
+	threads := display.options.RenderThreads
+
+	dst := buf
+	if mode == ScaleTile && !warped {
+		tileSize, err := pixelBytes(srcWidth, srcHeight)
+		if err != nil {
+			return err
+		}
+
+		dst = display.tileBufferFor(tileSize)
+	}
+
+	switch {
+	case warped:
+		warpImage(dst, width, height, display.warpSource.get(img, placed.Size(), filter), corners, opacity, threads)
+	case unscaled:
+		writeUnscaled(dst, img, opacity, threads)
+	case animated:
+		// show resets the cache when another image is shown
+		animationKey := animationCacheKey{frameCacheKey: cacheKey, animation: animation}
+		display.renderMu.Lock()
+		data := display.frameCache.get(frameIndex, animationKey)
+		display.renderMu.Unlock()
+
+		if data != nil {
+			copy(dst, data)
+			break
+		}
+
+		scaleImage(dst, img, cacheKey, filter.scaler(), nil, threads)
+		display.renderMu.Lock()
+		display.frameCache.put(frameIndex, animationKey, bytes.Clone(dst))
+		display.renderMu.Unlock()
+	default:
+		scaleImage(dst, img, cacheKey, filter.scaler(), &display.bandCache, threads)
+	}
+
+	if mode == ScaleTile && !warped {
+		tileImage(buf, dst, srcWidth, srcHeight, width, height, placed.Min)
+	}
+
+	if display.backdrop != nil {
+		backdrop, backdropSize := display.backdrop.get()
+		var diff *diffView
+		if view, ok := display.diffView(time.Now()); ok {
+			diff = &view
+		}
+
+		blendBackdrop(buf, visible, backdrop, backdropSize, display.options.Blend, diff, threads)
+	}
+
+	if display.staleDesaturated() {
+		desaturate(buf, threads)
+	}
+
+	// done after caching so that the cached pixels keep their full depth
+	reduceColorDepth(buf, display.options.ColorBits, threads)
+
+	if layer := display.guideLayer(); !layer.empty() {
+		drawGuides(buf, visible, layer)
+	}
+
+	if display.magnifierDecorated() {
+		display.drawMagnifier(buf, visible, magnified, magnifiedPlaced)
+	}
+
+	if checks := display.contrastResults(); len(checks) > 0 && !warped && mode != ScaleTile {
+		drawContrastChecks(buf, visible, placed, image.Pt(imageWidth, imageHeight), checks, display.textFace())
+	}
+
+	if display.hasAnnotations() {
+		display.drawAnnotations(buf, visible, window.Size())
+	}
+
+	if display.options.Split != SplitNone {
+		drawSplitDivider(buf, visible, display.splitDivider(splitSide))
+	}
+
+	if editCorners {
+		drawCornerHandles(buf, width, height, corners, selectedCorner)
+	}
+
+	if panel := display.infoPanel(); panel != nil {
+		drawInfoPanel(buf, width, height, panel)
+	}
+
+	if panel := display.timelinePanel(width); panel != nil {
+		drawPanel(buf, width, height, panel, image.Pt(infoMargin, height-infoMargin-panel.Bounds().Dy()))
+	}
+
+	if panel := display.sourceErrorPanel(); panel != nil {
+		drawPanel(buf, width, height, panel, image.Pt(infoMargin, height-infoMargin-panel.Bounds().Dy()))
+	}
+
+	if panel := display.staleBadge(); panel != nil {
+		drawPanel(buf, width, height, panel, image.Pt(width-infoMargin-panel.Bounds().Dx(), infoMargin))
+	}
+
+	if panel, pointer := display.pickerPanel(); panel != nil {
+		drawPanel(buf, width, height, panel, pickerOrigin(pointer.Sub(visible.Min), panel.Bounds(), width, height))
+	}
+
+	if panel := display.helpPanel(height); panel != nil {
+		drawPanel(buf, width, height, panel, image.Pt((width-panel.Bounds().Dx())/2, (height-panel.Bounds().Dy())/2))
+	}
+
+	if panel := display.noticePanel(); panel != nil {
+		drawPanel(buf, width, height, panel, image.Pt((width-panel.Bounds().Dx())/2, infoMargin))
+	}
+
+	if display.wantsFrames() {
+		display.emitFrame(display.frameFromBuffer(buf, window, visible))
+	}
+
+	// the frame is completed off-screen and shown at once
+	pixmap, err := display.backBufferFor(window.Size())
+	if err != nil {
+		return fmt.Errorf("get back buffer: %w", err)
+	}
+
+	err = display.clearMargins(xproto.Drawable(pixmap), gc, window, visible)
+	if err != nil {
+		return err
+	}
+
+	if shmBuffer == nil {
+		err = display.putImageBands(xproto.Drawable(pixmap), display.depth, gc, buf, width, height, xOffset, yOffset)
+		if err != nil {
+			return err
+		}
+
+		return display.presentFrame(gc, window.Size())
+	}
+
+	area, err := xRectangle(image.Rect(xOffset, yOffset, xOffset+width, yOffset+height))
+	if err != nil {
+		return fmt.Errorf("put image: %w", err)
+	}
+
+	err = shm.PutImageChecked(
+		display.conn,
+		xproto.Drawable(pixmap),
+		gc,
+		area.Width,
+		area.Height,
+		0, // src x
+		0, // src y
+		area.Width,
+		area.Height,
+		area.X, // dst x
+		area.Y, // dst y
+		display.depth,
+		xproto.ImageFormatZPixmap,
+		0,
+		shmBuffer.segID,
+		0,
+	).Check()
+	// the segment was attached but the server still can't read it, e.g.
+	// when it runs in another container, which left the window black
+	if err != nil {
+		slog.Warn("put image from shared memory, falling back to sending the pixels", "err", describeXError(display.conn, err))
+		display.useShm = false
+
+		err = display.putImageBands(xproto.Drawable(pixmap), display.depth, gc, buf, width, height, xOffset, yOffset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return display.presentFrame(gc, window.Size())
+}
+
+func (display *Window) setClass() {
+	class := "overlay\x00overlay\x00"
+
+	const format8Bit = 8
+
+	xproto.ChangeProperty(
+		display.conn,
+		xproto.PropModeReplace,
+		display.windowID,
+		xproto.AtomWmClass,
+		xproto.AtomString,
+		format8Bit,
+		uint32(len(class)),
+		[]byte(class),
+	)
+}
+
+// startupAtoms returns the atoms CreateWindow needs, so that they can be
+// interned up front.
+func (display *Window) startupAtoms() []string {
+	names := display.initialStates()
+
+	if len(names) > 0 {
+		names = append(names, "_NET_WM_STATE")
+	}
+
+	if display.options.NoDecorations {
+		names = append(names, "_MOTIF_WM_HINTS")
+	}
+
+	if display.options.Layer != "" {
+		names = append(names, "_NET_WM_WINDOW_TYPE")
+		names = append(names, windowTypes[display.options.Layer]...)
+	}
+
+	if display.options.Geometry.HasPosition && !display.options.OverrideRedirect {
+		names = append(names, "_NET_MOVERESIZE_WINDOW")
+	}
+
+	names = append(names, "WM_PROTOCOLS")
+	names = append(names, display.protocols()...)
+
+	return names
+}
+
+// syncRequests waits until the server has processed all requests sent so
+// far and returns the errors caused by unchecked ones. Events that arrive in
+// the meantime are kept for HandleEvents. It has to be called before the
+// events are handled.
+func (display *Window) syncRequests() error {
+	marker, err := display.atom("_XOVERLAY_SYNC")
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	// a client message to ourselves, the server processes requests in
+	// order and the connection hands it to us after the errors of all
+	// requests before it
+	event := xproto.ClientMessageEvent{
+		Format: 32,
+		Window: display.windowID,
+		Type:   marker,
+		Data:   xproto.ClientMessageDataUnionData32New(make([]uint32, 5)),
+	}
+
+	err = xproto.SendEventChecked(display.conn, false, display.windowID, xproto.EventMaskNoEvent, string(event.Bytes())).Check()
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	var errs []error
+
+	for item := range display.xevents {
+		if item.err != nil {
+			errs = append(errs, describeXError(display.conn, item.err))
+			continue
+		}
+
+		if message, ok := item.event.(xproto.ClientMessageEvent); ok && message.Type == marker {
+			return errors.Join(errs...)
+		}
+
+		display.pendingEvents = append(display.pendingEvents, item.event)
+	}
+
+	return fmt.Errorf("sync: connection closed")
+}
+
+// nextEvent returns the events queued by syncRequests before waiting for
+// new ones.
+func (display *Window) nextEvent() (xgb.Event, xgb.Error) {
+	if len(display.pendingEvents) > 0 {
+		ev := display.pendingEvents[0]
+		display.pendingEvents = display.pendingEvents[1:]
+
+		return ev, nil
+	}
+
+	select {
+	case item, ok := <-display.xevents:
+		if !ok {
+			return nil, nil
+		}
+
+		return item.event, item.err
+	case <-display.ctx.Done():
+		return nil, nil
+	}
+}
+
+func (display *Window) handleEvents() error {
+	for {
+		ev, xerr := display.nextEvent()
+		if ev == nil && xerr == nil {
+			return fmt.Errorf("got no event but err is nil, exiting")
+		}
+
+		// of a request that wasn't checked, nothing waits for it
+		if xerr != nil {
+			slog.Warn("x error", "err", describeXError(display.conn, xerr))
+			continue
+		}
+
+		switch event := ev.(type) {
+		case xproto.ConfigureNotifyEvent:
+			if event.Window != display.windowID {
+				if display.isMirrored(event.Window) {
+					display.mirror.configured()
+				}
+
+				if display.isFollowed(event.Window) {
+					err := display.followTarget()
+					if err != nil {
+						slog.Error("follow window", "err", err)
+					}
+				}
+
+				continue
+			}
+
+			// moving the window changes what is below it
+			if display.backdrop != nil {
+				display.backdrop.moved()
+			}
+
+			display.broadcastChanges()
+
+			if display.windowWidth != int(event.Width) || display.windowHeight != int(event.Height) {
+				deltaPixels := abs(display.windowWidth-int(event.Width)) + abs(display.windowHeight-int(event.Height))
+				display.windowWidth = int(event.Width)
+				display.windowHeight = int(event.Height)
+				display.requestResizeRedraw(deltaPixels)
+				display.emit(Event{Kind: EventResized, Width: display.windowWidth, Height: display.windowHeight})
+
+				if display.options.TestPattern != "" {
+					display.showPattern()
+				}
+			}
+		case randr.ScreenChangeNotifyEvent, randr.NotifyEvent:
+			if display.output != nil {
+				err := display.outputsChanged()
+				if err != nil {
+					slog.Error("place window on output", "err", err)
+				}
+			}
+		case xproto.ButtonPressEvent:
+			if display.options.LockInput {
+				continue
+			}
+
+			if event.Detail != buttonScrollUp && event.Detail != buttonScrollDown {
+				display.emit(Event{Kind: EventClick, X: int(event.EventX), Y: int(event.EventY), Button: int(event.Detail)})
+			}
+
+			alt := event.State&xproto.ModMask1 != 0
+
+			switch {
+			case alt && (event.Detail == buttonLeft || event.Detail == buttonRight):
+				display.checkpoint("drag")
+				err := display.startDrag(event, event.Detail == buttonRight)
+				if err != nil {
+					slog.Error("drag window", "err", err)
+				}
+			case event.Detail == buttonLeft && display.startSplitDrag(int(event.EventX), int(event.EventY)):
+				// the divider is dragged until the button is released
+			case display.pickerActive() && (event.Detail == buttonLeft || event.Detail == buttonRight):
+				err := display.copyPicked(event.Detail == buttonRight)
+				if err != nil {
+					slog.Error("copy color", "err", err)
+				}
+			case event.Detail == buttonLeft && display.startCornerDrag(int(event.EventX), int(event.EventY)):
+				// corners are being edited, the click doesn't set the opacity,
+				// they only move once the mouse does
+				display.checkpoint("drag-corner")
+			case event.Detail == buttonLeft:
+				display.checkpoint("opacity")
+				x := min(display.windowWidth, max(0, int(event.EventX)))
+				display.fadeOpacity(display.options.clickOpacity(float64(x) / float64(display.windowWidth)))
+				display.shareOpacity()
+			case event.Detail == buttonMiddle:
+				display.checkpoint("pan")
+				display.startPan(int(event.EventX), int(event.EventY))
+			case event.Detail == buttonScrollUp || event.Detail == buttonScrollDown:
+				display.checkpoint("zoom")
+				display.zoom(event.Detail == buttonScrollUp, int(event.EventX), int(event.EventY))
+			}
+		case xproto.ButtonReleaseEvent:
+			if display.options.LockInput {
+				continue
+			}
+
+			switch event.Detail {
+			case buttonMiddle:
+				display.endPan()
+			case buttonLeft, buttonRight:
+				display.drag = nil
+				display.splitDragging = false
+				display.endCornerDrag()
+			}
+		case xproto.MotionNotifyEvent:
+			if display.options.LockInput {
+				continue
+			}
+
+			if display.drag != nil {
+				err := display.dragTo(int(event.RootX), int(event.RootY))
+				if err != nil {
+					slog.Error("drag window", "err", err)
+				}
+
+				continue
+			}
+
+			if display.dragCorner(int(event.EventX), int(event.EventY)) {
+				continue
+			}
+
+			if display.dragSplit(int(event.EventX), int(event.EventY)) {
+				continue
+			}
+
+			display.hover(int(event.EventX), int(event.EventY), int(event.RootX), int(event.RootY))
+
+			display.pan(int(event.EventX), int(event.EventY))
+		case xproto.LeaveNotifyEvent:
+			display.leave()
+		case xproto.KeyPressEvent:
+			if display.options.LockInput {
+				continue
+			}
+
+			combo := display.keyboard.lookup(event.Detail, event.State)
+
+			// reported for the grab on the root window as well as for our
+			// own window when it has the focus
+			if toggle := display.options.ToggleKey; toggle != nil && combo == *toggle {
+				err := display.toggleVisible()
+				if err != nil {
+					slog.Error("toggle window", "err", err)
+				}
+
+				continue
+			}
+
+			if privacy := display.options.PrivacyKey; privacy != nil && combo == *privacy {
+				err := display.togglePrivacy()
+				if err != nil {
+					slog.Error("toggle privacy", "err", err)
+				}
+
+				continue
+			}
+
+			a, ok := display.boundAction(combo)
+			if !ok {
+				continue
+			}
+
+			if a == actionQuit {
+				display.rememberFinalState()
+				return nil
+			}
+
+			err := display.runAction(a)
+			if err != nil {
+				slog.Error("run action", "err", err)
+			}
+		case xproto.MappingNotifyEvent:
+			if event.Request != xproto.MappingKeyboard {
+				continue
+			}
+
+			keyboard, err := loadKeyboardMapping(display.conn)
+			if err != nil {
+				return fmt.Errorf("reload keyboard mapping: %w", err)
+			}
+
+			display.grabMu.Lock()
+			display.keyboard = keyboard
+			display.grabMu.Unlock()
+
+			err = display.grabGlobalKeys()
+			if err != nil {
+				slog.Error("grab global keys", "err", err)
+			}
+		case xproto.MapNotifyEvent:
+			if event.Window == display.windowID {
+				display.setMapped(true)
+			}
+
+			// the overlay is hidden while the followed window is, e.g.
+			// when it is minimized or on another workspace
+			if display.isFollowed(event.Window) {
+				err := display.setVisible(true)
+				if err != nil {
+					slog.Error("show window", "err", err)
+				}
+			}
+		case xproto.UnmapNotifyEvent:
+			if event.Window == display.windowID {
+				display.setMapped(false)
+			}
+
+			if display.isFollowed(event.Window) {
+				err := display.setVisible(false)
+				if err != nil {
+					slog.Error("hide window", "err", err)
+				}
+			}
+		case xproto.ExposeEvent:
+			if event.Window == display.windowID {
+				display.exposed(image.Rect(int(event.X), int(event.Y), int(event.X)+int(event.Width), int(event.Y)+int(event.Height)))
+			}
+		case xproto.VisibilityNotifyEvent:
+			display.setObscured(event.State == xproto.VisibilityFullyObscured)
+		case xproto.ClientMessageEvent:
+			switch {
+			case display.isProtocol(event, "WM_DELETE_WINDOW"):
+				// closed by the window manager, e.g. with the button in
+				// the title bar
+				display.rememberFinalState()
+				return nil
+			case display.isProtocol(event, "_NET_WM_PING"):
+				err := display.answerPing(event)
+				if err != nil {
+					slog.Error("answer ping", "err", err)
+				}
+			case display.isProtocol(event, "WM_SAVE_YOURSELF"):
+				err := display.saveSession()
+				if err != nil {
+					slog.Error("save session", "err", err)
+				}
+			}
+		case xproto.SelectionRequestEvent:
+			err := display.handleSelectionRequest(event)
+			if err != nil {
+				slog.Error("answer selection request", "err", err)
+			}
+		case xproto.SelectionClearEvent:
+			display.handleSelectionClear(event)
+		case xproto.PropertyNotifyEvent:
+			err := display.handlePropertyNotify(event)
+			if err != nil {
+				slog.Error("transfer selection", "err", err)
+			}
+		case damage.NotifyEvent:
+			if display.isMirrored(xproto.Window(event.Drawable)) {
+				display.mirror.damaged()
+			}
+		case xproto.DestroyNotifyEvent:
+			if display.isMirrored(event.Window) {
+				display.mirror.destroyed()
+			}
+
+			// nothing left to follow, the overlay stays where it is
+			if display.isFollowed(event.Window) {
+				display.follower = nil
+			}
+
+			if event.Window == display.windowID {
+				return nil
+			}
+		}
+	}
+}
+
+func (display *Window) opacity() float64 {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.targetOpacity()
+}
+
+func (display *Window) setOpacity(opacity float64) {
+	display.renderMu.Lock()
+	display.imageOpacity = min(1.0, max(0.0, opacity))
+	display.opacityFade.active = false
+	opacity = display.imageOpacity
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+	display.emit(Event{Kind: EventOpacity, Opacity: opacity})
+}
+
+// clickOpacity returns the opacity a click at fraction of the width of the
+// window sets, from OpacityMin at the left edge to OpacityMax at the right.
+// The range keeps reviewers from making the image invisible or covering the
+// UI by accident.
+func (options Options) clickOpacity(fraction float64) float64 {
+	low, high := options.opacityRange()
+	return low + fraction*(high-low)
+}
+
+// clampOpacity returns opacity within OpacityMin and OpacityMax, where the
+// keys stop.
+func (options Options) clampOpacity(opacity float64) float64 {
+	low, high := options.opacityRange()
+	return min(high, max(low, opacity))
+}
+
+// opacityRange returns OpacityMin and OpacityMax, or 0 and 1 if they don't
+// form a range, like in Options that weren't made by DefaultOptions.
+func (options Options) opacityRange() (float64, float64) {
+	if options.OpacityMin < 0 || options.OpacityMax > 1 || options.OpacityMin >= options.OpacityMax {
+		return 0, 1
+	}
+
+	return options.OpacityMin, options.OpacityMax
+}
+
+// nudgeDirection returns the direction a nudge action moves in.
+func nudgeDirection(a action) (int, int) {
+	switch a {
+	case actionNudgeLeft:
+		return -1, 0
+	case actionNudgeRight:
+		return 1, 0
+	case actionNudgeUp:
+		return 0, -1
+	default:
+		return 0, 1
+	}
+}
+
+func (display *Window) nudge(dx int, dy int) error {
+	x, y, err := display.windowPosition()
+	if err != nil {
+		return fmt.Errorf("get window position: %w", err)
+	}
+
+	err = display.moveWindow(x+dx, y+dy)
+	if err != nil {
+		return fmt.Errorf("move window: %w", err)
+	}
+
+	return nil
+}
+
+func (display *Window) resizeWindow(width int, height int) error {
+	xWidth, err := xSize(width)
+	if err != nil {
+		return err
+	}
+
+	xHeight, err := xSize(height)
+	if err != nil {
+		return err
+	}
+
+	err = xproto.ConfigureWindowChecked(
+		display.conn,
+		display.windowID,
+		xproto.ConfigWindowWidth|xproto.ConfigWindowHeight,
+		[]uint32{uint32(xWidth), uint32(xHeight)},
+	).Check()
+	if err != nil {
+		return fmt.Errorf("configure window: %w", err)
+	}
+
+	return nil
+}
+
+func (display *Window) isMapped() bool {
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.mapped
+}
+
+func (display *Window) setVisible(visible bool) error {
+	if visible {
+		err := xproto.MapWindowChecked(display.conn, display.windowID).Check()
+		if err != nil {
+			return fmt.Errorf("map window: %w", err)
+		}
+
+		return nil
+	}
+
+	err := xproto.UnmapWindowChecked(display.conn, display.windowID).Check()
+	if err != nil {
+		return fmt.Errorf("unmap window: %w", err)
+	}
+
+	return nil
+}
+
+func (display *Window) loadImageFile(filename string) error {
+	decoded, err := loadImage(filename, display.options.Animate, display.decodeTarget())
+	if err != nil {
+		return err
+	}
+
+	display.setImage(filename, decoded)
+
+	return nil
+}
+
+// cycleImage shows the image delta positions away from the current one in
+// the list of images given on the command line, wrapping around at the ends.
+func (display *Window) cycleImage(delta int) error {
+	if display.options.TestPattern != "" {
+		display.cyclePattern(delta)
+		return nil
+	}
+
+	display.renderMu.Lock()
+	images := display.images
+	count := len(images)
+	index := 0
+	switch {
+	case display.random != nil && count > 1:
+		index = randomIndex(display.random, count, display.imageIndex)
+	case count > 0:
+		index = ((display.imageIndex+delta)%count + count) % count
+	}
+	display.renderMu.Unlock()
+
+	if count < 2 {
+		return nil
+	}
+
+	err := display.loadImageFile(images[index])
+	if err != nil {
+		return err
+	}
+
+	display.renderMu.Lock()
+	display.imageIndex = index
+	display.renderMu.Unlock()
+
+	return nil
+}
+
+// SetImageFiles replaces the images that next-image, previous-image and the
+// slideshow cycle through, e.g. when files were added to a directory. The
+// current image stays, and so does its position in the list if it is still
+// part of it.
+func (display *Window) SetImageFiles(paths []string) {
+	// all images are shown at once
+	if display.options.board() {
+		return
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	if index := slices.Index(paths, display.source); index >= 0 {
+		display.imageIndex = index
+	} else {
+		display.imageIndex = min(display.imageIndex, max(0, len(paths)-1))
+	}
+
+	display.images = paths
+}
+
+// quit destroys the window, which ends HandleEvents.
+func (display *Window) quit() error {
+	display.rememberFinalState()
+
+	err := xproto.DestroyWindowChecked(display.conn, display.windowID).Check()
+	if err != nil {
+		return fmt.Errorf("destroy window: %w", err)
+	}
+
+	return nil
+}
+
+// ReloadImage loads source again if it is the image currently shown, or
+// part of the composed images of a Layout.
+func (display *Window) ReloadImage(source string) error {
+	if display.options.board() {
+		if !slices.Contains(display.options.Images, source) {
+			return nil
+		}
+
+		decoded, err := display.options.loadBoard()
+		if err != nil {
+			return err
+		}
+
+		display.setImage("", decoded)
+
+		return nil
+	}
+
+	display.renderMu.Lock()
+	current := display.source
+	display.renderMu.Unlock()
+
+	if current != source {
+		return nil
+	}
+
+	return display.loadImageFile(source)
+}
+
+func (display *Window) runAction(a action) error {
+	step := display.options.NudgeStep
+
+	// the help is only shown until the next key
+	if a != actionToggleHelp {
+		display.hideHelp()
+	}
+
+	if n, save, ok := a.bookmark(); ok {
+		if save {
+			display.saveBookmark(n)
+			return nil
+		}
+
+		return display.recallBookmark(n)
+	}
+
+	if slices.Contains(undoableActions, a) {
+		display.checkpoint(string(a))
+	}
+
+	switch a {
+	case actionNudgeLeft, actionNudgeRight, actionNudgeUp, actionNudgeDown:
+		dx, dy := nudgeDirection(a)
+
+		// while editing the corners the nudge keys move the selected one
+		// pixel by pixel instead of the window
+		if display.nudgeCorner(dx, dy) {
+			return nil
+		}
+
+		// the gamma pattern fills the screen, its gray changes instead, by
+		// ten levels at a time sideways
+		if display.adjustGamma(10*dx - dy) {
+			return nil
+		}
+
+		return display.nudge(dx*step, dy*step)
+	case actionOpacityUp:
+		display.fadeOpacity(display.options.clampOpacity(display.opacity() + display.options.OpacityStep))
+		display.shareOpacity()
+	case actionOpacityDown:
+		display.fadeOpacity(display.options.clampOpacity(display.opacity() - display.options.OpacityStep))
+		display.shareOpacity()
+	case actionFullscreen:
+		return display.toggleFullscreen()
+	case actionToggleSticky:
+		return display.toggleSticky()
+	case actionNextImage:
+		return display.cycleImage(1)
+	case actionPreviousImage:
+		return display.cycleImage(-1)
+	case actionCopyImage:
+		return display.copyImage()
+	case actionCopyColor:
+		return display.copyColor()
+	case actionCopyGeometry:
+		return display.copyGeometry()
+	case actionResetZoom:
+		display.resetZoom()
+	case actionRotate:
+		display.rotate()
+	case actionToggleFilters:
+		display.toggleColorFilters()
+	case actionToggleInvert:
+		display.toggleInvert()
+	case actionCycleChannel:
+		display.cycleChannel()
+	case actionToggleOutline:
+		display.toggleOutline()
+	case actionToggleBlueprint:
+		display.toggleBlueprint()
+	case actionToggleInfo:
+		display.toggleInfo()
+	case actionEditCorners:
+		display.toggleCorners()
+	case actionResetCorners:
+		display.resetCorners()
+	case actionToggleGuides:
+		display.toggleGuides()
+	case actionTogglePicker:
+		return display.togglePicker()
+	case actionTogglePrivacy:
+		return display.togglePrivacy()
+	case actionHistoryBack:
+		display.scrubHistory(-1)
+	case actionHistoryForward:
+		display.scrubHistory(1)
+	case actionToggleRecording:
+		return display.toggleRecording()
+	case actionScreenshot:
+		return display.takeScreenshot(false)
+	case actionScreenshotBlended:
+		return display.takeScreenshot(true)
+	case actionUndo:
+		_, err := display.Undo()
+		return err
+	case actionRedo:
+		_, err := display.Redo()
+		return err
+	case actionBlank:
+		return display.toggleVisible()
+	case actionToggleHelp:
+		display.toggleHelp()
+	}
+
+	return nil
+}
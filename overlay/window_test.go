@@ -0,0 +1,202 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// fakeRenderer runs the render loop of startRenderer against a clock that
+// only moves when the test says so, and records the renders instead of
+// drawing.
+type fakeRenderer struct {
+	display *Window
+	now     time.Time
+	// when the loop would look again, zero if only a wake up brings work
+	next       time.Time
+	wasVisible bool
+	// the quality of every render, true for high quality
+	renders []bool
+	at      []time.Time
+}
+
+func newFakeRenderer() *fakeRenderer {
+	f := &fakeRenderer{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	f.display = &Window{
+		mapped:     true,
+		renderWake: make(chan struct{}, 1),
+		now:        func() time.Time { return f.now },
+	}
+
+	f.display.render = func(highQuality bool) error {
+		f.renders = append(f.renders, highQuality)
+		f.at = append(f.at, f.now)
+		return nil
+	}
+
+	return f
+}
+
+// advance lets the timer of the loop fire until to.
+func (f *fakeRenderer) advance(to time.Time) {
+	for !f.next.IsZero() && !f.next.After(to) {
+		f.now = f.next
+		f.next = f.display.renderPending(&f.wasVisible)
+	}
+
+	f.now = to
+}
+
+// wake is the loop being woken up by a request.
+func (f *fakeRenderer) wake() {
+	select {
+	case <-f.display.renderWake:
+	default:
+		return
+	}
+
+	f.next = f.display.renderPending(&f.wasVisible)
+}
+
+func (f *fakeRenderer) highQuality() int {
+	n := 0
+	for _, highQuality := range f.renders {
+		if highQuality {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestRedrawBurst(t *testing.T) {
+	f := newFakeRenderer()
+	start := f.now
+
+	for i := range 100 {
+		f.advance(start.Add(time.Duration(i) * time.Millisecond))
+		f.display.requestRedraw()
+		f.wake()
+	}
+
+	last := f.now
+	f.advance(last.Add(time.Second))
+
+	if len(f.renders) != 1 || !f.renders[0] {
+		t.Fatalf("renders %v, want a single high quality render", f.renders)
+	}
+
+	if want := last.Add(redrawDebounce); !f.at[0].Equal(want) {
+		t.Errorf("rendered %v after the last request, want %v", f.at[0].Sub(last), redrawDebounce)
+	}
+
+	if !f.next.IsZero() {
+		t.Errorf("renderer looks again at %v with nothing to do", f.next)
+	}
+}
+
+func TestResizeBurst(t *testing.T) {
+	f := newFakeRenderer()
+	start := f.now
+
+	// 200 configure events over 400ms, 20 pixels apart
+	const events = 200
+	for i := range events {
+		f.advance(start.Add(time.Duration(i) * 2 * time.Millisecond))
+		f.display.requestResizeRedraw(20)
+		f.wake()
+	}
+
+	last := f.now
+	f.advance(last.Add(time.Second))
+
+	if n := f.highQuality(); n != 1 {
+		t.Fatalf("%d high quality renders, want 1", n)
+	}
+
+	if !f.renders[len(f.renders)-1] {
+		t.Error("the last render is a preview")
+	}
+
+	if want := last.Add(resizeSettleDelay); !f.at[len(f.at)-1].Equal(want) {
+		t.Errorf("refined %v after the last resize, want %v", f.at[len(f.at)-1].Sub(last), resizeSettleDelay)
+	}
+
+	// a preview at most every maxPreviewDebounce at this speed
+	previews := len(f.renders) - 1
+	if previews < 1 || previews > int(last.Sub(start)/maxPreviewDebounce)+1 {
+		t.Errorf("%d previews for %d resizes over %v", previews, events, last.Sub(start))
+	}
+
+	if !f.next.IsZero() {
+		t.Errorf("renderer looks again at %v with nothing to do", f.next)
+	}
+}
+
+func TestRenderHidden(t *testing.T) {
+	f := newFakeRenderer()
+	f.display.mapped = false
+
+	for range 10 {
+		f.display.requestRedraw()
+		f.wake()
+	}
+
+	f.advance(f.now.Add(time.Second))
+
+	if len(f.renders) != 0 {
+		t.Errorf("%d renders while the window is unmapped", len(f.renders))
+	}
+
+	// showing the window again renders what was requested
+	f.display.renderMu.Lock()
+	f.display.mapped = true
+	f.display.renderMu.Unlock()
+	f.display.wakeRenderer()
+	f.wake()
+	f.advance(f.now.Add(time.Second))
+
+	if len(f.renders) != 1 {
+		t.Errorf("%d renders once the window is mapped, want 1", len(f.renders))
+	}
+}
+
+func TestShowAnimationOfSameSize(t *testing.T) {
+	display := newFakeRenderer().display
+
+	animation := func(c color.Color) decodedImage {
+		var frames []animationFrame
+		for range 2 {
+			frame := image.NewRGBA(image.Rect(0, 0, 4, 4))
+			draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+			frames = append(frames, animationFrame{image: frame, delay: defaultFrameDelay})
+		}
+
+		return decodedImage{image: frames[0].image, frames: frames}
+	}
+
+	// what renderImage keys the scaled frames on
+	key := func() animationCacheKey {
+		size := frameCacheKey{width: 4, height: 4, scaled: image.Rect(0, 0, 4, 4), opacity: 1}
+		return animationCacheKey{frameCacheKey: size, animation: display.frames[0].image}
+	}
+
+	display.show("", animation(color.White), true)
+	first := key()
+	display.frameCache.put(0, first, []byte("white"))
+
+	display.show("", animation(color.Black), true)
+	if data := display.frameCache.get(0, key()); data != nil {
+		t.Errorf("the second animation plays %q of the first", data)
+	}
+
+	// the first frames don't come back even if the cache is not reset
+	display.frameCache.put(0, first, []byte("white"))
+	if data := display.frameCache.get(0, key()); data != nil {
+		t.Errorf("the second animation plays %q of the first without a reset", data)
+	}
+}
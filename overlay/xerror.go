@@ -0,0 +1,208 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xproto"
+)
+
+// The X server reports a failed request with little more than its opcode, and
+// errors of unchecked requests arrive with the events, long after the request.
+// xError names the request and, for the errors that come from the setup of
+// the machine rather than from a bug, what to do about it.
+
+// the core requests the overlay sends, by major opcode
+var coreRequests = map[byte]string{
+	1:  "CreateWindow",
+	2:  "ChangeWindowAttributes",
+	3:  "GetWindowAttributes",
+	4:  "DestroyWindow",
+	8:  "MapWindow",
+	10: "UnmapWindow",
+	12: "ConfigureWindow",
+	14: "GetGeometry",
+	15: "QueryTree",
+	16: "InternAtom",
+	18: "ChangeProperty",
+	19: "DeleteProperty",
+	20: "GetProperty",
+	22: "SetSelectionOwner",
+	24: "ConvertSelection",
+	25: "SendEvent",
+	28: "GrabButton",
+	33: "GrabKey",
+	34: "UngrabKey",
+	38: "QueryPointer",
+	40: "TranslateCoordinates",
+	42: "SetInputFocus",
+	53: "CreatePixmap",
+	54: "FreePixmap",
+	55: "CreateGC",
+	56: "ChangeGC",
+	60: "FreeGC",
+	62: "CopyArea",
+	70: "PolyFillRectangle",
+	72: "PutImage",
+	73: "GetImage",
+	78: "CreateColormap",
+	79: "FreeColormap",
+}
+
+// the requests of MIT-SHM, by minor opcode
+var shmRequests = map[uint16]string{
+	0: "QueryVersion",
+	1: "Attach",
+	2: "Detach",
+	3: "PutImage",
+	4: "GetImage",
+	5: "CreatePixmap",
+}
+
+// the requests that fail with BadMatch if the visual or depth doesn't fit
+var visualRequests = []string{"CreateWindow", "CreateColormap", "ChangeWindowAttributes", "PutImage", "MIT-SHM PutImage", "CopyArea"}
+
+const (
+	noShmHint  = "the X server can't read our shared memory, e.g. because it runs in another container or on another machine, --quirks no-shm sends the pixels instead"
+	visualHint = "the X server doesn't accept the visual or depth of the window, --transparency opacity-hint or --quirks no-argb use one without an alpha channel"
+)
+
+// xError is a protocol error of the X server.
+type xError struct {
+	err     xgb.Error
+	name    string
+	request string
+	hint    string
+}
+
+func (e *xError) Error() string {
+	message := fmt.Sprintf("%s in %s", e.name, e.request)
+	if e.hint != "" {
+		message += ": " + e.hint
+	}
+
+	return message
+}
+
+func (e *xError) Unwrap() error {
+	return e.err
+}
+
+// describeXError returns err with the request that failed and a hint if it
+// is an X protocol error, and err as it is otherwise.
+func describeXError(conn *xgb.Conn, err error) error {
+	var protocolErr xgb.Error
+	if !errors.As(err, &protocolErr) {
+		return err
+	}
+
+	fields, ok := xErrorFields(protocolErr)
+	if !ok {
+		return err
+	}
+
+	// the errors of extensions have the prefix already
+	name := fields.NiceName
+	if !strings.HasPrefix(name, "Bad") {
+		name = "Bad" + name
+	}
+
+	extension, request := requestName(conn, fields.MajorOpcode, fields.MinorOpcode)
+
+	described := &xError{
+		err:     protocolErr,
+		name:    name,
+		request: request,
+	}
+
+	switch {
+	case extension == "MIT-SHM" && (name == "BadAccess" || name == "BadSeg"):
+		described.hint = noShmHint
+	case name == "BadMatch" && slices.Contains(visualRequests, request):
+		described.hint = visualHint
+	case name == "BadAccess" && (request == "GrabKey" || request == "GrabButton"):
+		described.hint = "another program grabbed it already"
+	case name == "BadLength" && request == "PutImage":
+		described.hint = "the image is larger than the server accepts in one request, --quirks small-requests sends it in smaller bands"
+	case name == "BadAlloc":
+		described.hint = "the X server ran out of memory, e.g. for a very large window"
+	}
+
+	return described
+}
+
+// requestName returns the extension the request with the opcodes is from, ""
+// for the core protocol, and its name.
+func requestName(conn *xgb.Conn, major byte, minor uint16) (string, string) {
+	if name, ok := coreRequests[major]; ok {
+		return "", name
+	}
+
+	extension := ""
+
+	conn.ExtLock.RLock()
+	for name, opcode := range conn.Extensions {
+		if opcode == major {
+			extension = name
+		}
+	}
+	conn.ExtLock.RUnlock()
+
+	switch {
+	case extension == "MIT-SHM" && shmRequests[minor] != "":
+		return extension, "MIT-SHM " + shmRequests[minor]
+	case extension != "":
+		return extension, fmt.Sprintf("%s request %d", extension, minor)
+	default:
+		return "", fmt.Sprintf("request %d", major)
+	}
+}
+
+// xErrorFields returns what the server reported about err, for the errors
+// of the core protocol and MIT-SHM, which all have the same fields.
+func xErrorFields(err xgb.Error) (xproto.RequestError, bool) {
+	switch err := err.(type) {
+	case xproto.RequestError:
+		return err, true
+	case xproto.AccessError:
+		return xproto.RequestError(err), true
+	case xproto.AllocError:
+		return xproto.RequestError(err), true
+	case xproto.ImplementationError:
+		return xproto.RequestError(err), true
+	case xproto.LengthError:
+		return xproto.RequestError(err), true
+	case xproto.MatchError:
+		return xproto.RequestError(err), true
+	case xproto.NameError:
+		return xproto.RequestError(err), true
+	case xproto.ValueError:
+		return xproto.RequestError(err), true
+	case xproto.AtomError:
+		return xproto.RequestError(err), true
+	case xproto.ColormapError:
+		return xproto.RequestError(err), true
+	case xproto.CursorError:
+		return xproto.RequestError(err), true
+	case xproto.DrawableError:
+		return xproto.RequestError(err), true
+	case xproto.FontError:
+		return xproto.RequestError(err), true
+	case xproto.GContextError:
+		return xproto.RequestError(err), true
+	case xproto.IDChoiceError:
+		return xproto.RequestError(err), true
+	case xproto.PixmapError:
+		return xproto.RequestError(err), true
+	case xproto.WindowError:
+		return xproto.RequestError(err), true
+	case shm.BadSegError:
+		return xproto.RequestError(err), true
+	default:
+		return xproto.RequestError{}, false
+	}
+}
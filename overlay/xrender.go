@@ -0,0 +1,392 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/jezek/xgb/render"
+	"github.com/jezek/xgb/xproto"
+)
+
+// With Options.XRender the image is uploaded to the X server once, and the
+// server scales it and applies the opacity whenever a frame is drawn. Resizing
+// and fading then only cost a Composite request instead of scaling on the
+// cpu and sending every pixel again, which matters most on forwarded
+// connections. Frames with anything drawn over the image, e.g. panels or
+// guides, are still rendered on the cpu.
+
+// xrenderer is the state of the server side rendering of a window, only used
+// by the render loop.
+type xrenderer struct {
+	// the formats of the window and of the uploaded image
+	windowFormat render.Pictformat
+	imageFormat  render.Pictformat
+
+	// the uploaded image and what it was uploaded from
+	pixmap   xproto.Pixmap
+	picture  render.Picture
+	size     image.Point
+	uploaded image.Image
+	// the filter set on picture
+	filter string
+
+	// the back buffer, as a picture, and the pixmap it was created for
+	target       render.Picture
+	targetPixmap xproto.Pixmap
+
+	// a solid fill with the opacity as its alpha, 0 at full opacity
+	mask        render.Picture
+	maskOpacity float64
+}
+
+// initRender initializes the render extension once for all windows.
+func (connection *Connection) initRender() error {
+	connection.renderOnce.Do(func() {
+		connection.renderErr = render.Init(connection.conn)
+	})
+
+	return connection.renderErr
+}
+
+// setupXRender looks up the picture formats for rendering on the server.
+func (display *Window) setupXRender() error {
+	err := display.connection.initRender()
+	if err != nil {
+		return err
+	}
+
+	formats, err := render.QueryPictFormats(display.conn).Reply()
+	if err != nil {
+		return fmt.Errorf("query picture formats: %w", err)
+	}
+
+	renderer := &xrenderer{}
+
+	for _, screen := range formats.Screens {
+		for _, depth := range screen.Depths {
+			for _, visual := range depth.Visuals {
+				if visual.Visual == display.visual {
+					renderer.windowFormat = visual.Format
+				}
+			}
+		}
+	}
+
+	// the byte order the image is converted to for the window anyway, bgra
+	// with alpha in the top byte of a little endian word
+	for _, format := range formats.Formats {
+		direct := format.Direct
+		if format.Type == render.PictTypeDirect && format.Depth == depthWithAlpha &&
+			direct.AlphaShift == 24 && direct.RedShift == 16 && direct.GreenShift == 8 && direct.BlueShift == 0 &&
+			direct.AlphaMask == 0xff && direct.RedMask == 0xff && direct.GreenMask == 0xff && direct.BlueMask == 0xff {
+			renderer.imageFormat = format.Id
+		}
+	}
+
+	if renderer.windowFormat == 0 || renderer.imageFormat == 0 {
+		return fmt.Errorf("no picture format for the window")
+	}
+
+	display.xrender = renderer
+
+	return nil
+}
+
+// canRenderOnServer reports whether nothing but the image is drawn in the
+// frame, which the server can then scale and blend on its own.
+func (display *Window) canRenderOnServer(mode ScaleMode, warped bool, editCorners bool) bool {
+	options := display.options
+
+	switch {
+	case display.xrender == nil, warped, editCorners, mode == ScaleTile:
+		return false
+	case display.backdrop != nil, options.ColorBits < 8, options.Split != SplitNone:
+		return false
+	case !display.guideLayer().empty(), display.hasAnnotations(), display.magnifierDecorated(), display.staleDesaturated():
+		return false
+	}
+
+	if display.infoPanel() != nil || display.sourceErrorPanel() != nil || display.staleBadge() != nil || display.noticePanel() != nil {
+		return false
+	}
+
+	if panel, _ := display.pickerPanel(); panel != nil {
+		return false
+	}
+
+	display.renderMu.Lock()
+	defer display.renderMu.Unlock()
+
+	return display.history == nil || display.history.back == 0
+}
+
+// renderOnServer draws img scaled to placed into the visible part of the
+// window, with the opacity, and shows the frame.
+func (display *Window) renderOnServer(img image.Image, placed image.Rectangle, visible image.Rectangle, window image.Rectangle, opacity float64, filter ScaleFilter, gc xproto.Gcontext) error {
+	renderer := display.xrender
+
+	err := display.uploadImage(img)
+	if err != nil {
+		return err
+	}
+
+	// the transform maps the pixels of the visible part of the window to
+	// those of the image. The offset is part of it, zoomed in it is too
+	// large for the coordinates of Composite.
+	size := img.Bounds().Size()
+	scaleX := float64(size.X) / float64(placed.Dx())
+	scaleY := float64(size.Y) / float64(placed.Dy())
+	transform := render.Transform{
+		Matrix11: fixedPoint(scaleX),
+		Matrix13: fixedPoint(float64(visible.Min.X-placed.Min.X) * scaleX),
+		Matrix22: fixedPoint(scaleY),
+		Matrix23: fixedPoint(float64(visible.Min.Y-placed.Min.Y) * scaleY),
+		Matrix33: fixedPoint(1),
+	}
+
+	err = render.SetPictureTransformChecked(display.conn, renderer.picture, transform).Check()
+	if err != nil {
+		return fmt.Errorf("set picture transform: %w", err)
+	}
+
+	name := xrenderFilter(filter)
+	if name != renderer.filter {
+		err = render.SetPictureFilterChecked(display.conn, renderer.picture, uint16(len(name)), name, nil).Check()
+		if err != nil {
+			return fmt.Errorf("set picture filter: %w", err)
+		}
+
+		renderer.filter = name
+	}
+
+	mask, err := display.opacityMask(opacity)
+	if err != nil {
+		return err
+	}
+
+	pixmap, err := display.backBufferFor(window.Size())
+	if err != nil {
+		return fmt.Errorf("get back buffer: %w", err)
+	}
+
+	target, err := display.targetPicture(pixmap)
+	if err != nil {
+		return err
+	}
+
+	err = display.clearMargins(xproto.Drawable(pixmap), gc, window, visible)
+	if err != nil {
+		return err
+	}
+
+	area, err := xRectangle(visible)
+	if err != nil {
+		return fmt.Errorf("composite: %w", err)
+	}
+
+	err = render.CompositeChecked(
+		display.conn,
+		render.PictOpSrc,
+		renderer.picture,
+		mask,
+		target,
+		0,      // src x
+		0,      // src y
+		0,      // mask x
+		0,      // mask y
+		area.X, // dst x
+		area.Y, // dst y
+		area.Width,
+		area.Height,
+	).Check()
+	if err != nil {
+		return fmt.Errorf("composite: %w", err)
+	}
+
+	return display.presentFrame(gc, window.Size())
+}
+
+// uploadImage sends img to the server unless it is there already.
+func (display *Window) uploadImage(img image.Image) error {
+	renderer := display.xrender
+
+	if img == renderer.uploaded {
+		return nil
+	}
+
+	size := img.Bounds().Size()
+
+	if size != renderer.size {
+		renderer.free(display)
+
+		area, err := xRectangle(image.Rectangle{Max: size})
+		if err != nil {
+			return fmt.Errorf("create pixmap: %w", err)
+		}
+
+		pixmap, err := xproto.NewPixmapId(display.conn)
+		if err != nil {
+			return fmt.Errorf("new pixmap id: %w", err)
+		}
+
+		err = xproto.CreatePixmapChecked(display.conn, depthWithAlpha, pixmap, xproto.Drawable(display.windowID), area.Width, area.Height).Check()
+		if err != nil {
+			return fmt.Errorf("create pixmap: %w", err)
+		}
+
+		renderer.pixmap = pixmap
+
+		picture, err := render.NewPictureId(display.conn)
+		if err != nil {
+			return fmt.Errorf("new picture id: %w", err)
+		}
+
+		// the edges are sampled from the pixels next to them instead of
+		// fading out to transparent when the image is scaled
+		err = render.CreatePictureChecked(display.conn, picture, xproto.Drawable(pixmap), renderer.imageFormat, render.CpRepeat, []uint32{render.RepeatPad}).Check()
+		if err != nil {
+			return fmt.Errorf("create picture: %w", err)
+		}
+
+		renderer.picture = picture
+		renderer.size = size
+		renderer.filter = ""
+	}
+
+	// converted to the byte order of the server at its own size, like the
+	// cpu renderer does when it doesn't scale
+	length, err := pixelBytes(size.X, size.Y)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, length)
+	if canWriteUnscaled(img) {
+		writeUnscaled(data, img, 1, display.options.RenderThreads)
+	} else {
+		key := frameCacheKey{width: size.X, height: size.Y, scaled: image.Rectangle{Max: size}, opacity: 1, filter: FilterNearest}
+		scaleImage(data, img, key, FilterNearest.scaler(), nil, display.options.RenderThreads)
+	}
+
+	gc, err := display.resources.gc(depthWithAlpha, xproto.Drawable(renderer.pixmap))
+	if err != nil {
+		return fmt.Errorf("get graphics context: %w", err)
+	}
+
+	err = display.putImageBands(xproto.Drawable(renderer.pixmap), depthWithAlpha, gc, data, size.X, size.Y, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	renderer.uploaded = img
+
+	return nil
+}
+
+// opacityMask returns the mask that applies opacity, 0 for none.
+func (display *Window) opacityMask(opacity float64) (render.Picture, error) {
+	renderer := display.xrender
+
+	if opacity == renderer.maskOpacity && renderer.mask != 0 {
+		return renderer.mask, nil
+	}
+
+	if renderer.mask != 0 {
+		render.FreePicture(display.conn, renderer.mask)
+		renderer.mask = 0
+	}
+
+	if opacity >= 1 {
+		return 0, nil
+	}
+
+	mask, err := render.NewPictureId(display.conn)
+	if err != nil {
+		return 0, fmt.Errorf("new picture id: %w", err)
+	}
+
+	err = render.CreateSolidFillChecked(display.conn, mask, render.Color{Alpha: uint16(opacity * 0xffff)}).Check()
+	if err != nil {
+		return 0, fmt.Errorf("create solid fill: %w", err)
+	}
+
+	renderer.mask = mask
+	renderer.maskOpacity = opacity
+
+	return mask, nil
+}
+
+// targetPicture returns the back buffer as a picture.
+func (display *Window) targetPicture(pixmap xproto.Pixmap) (render.Picture, error) {
+	renderer := display.xrender
+
+	if renderer.target != 0 && renderer.targetPixmap == pixmap {
+		return renderer.target, nil
+	}
+
+	// the old back buffer is only kept alive by its picture
+	if renderer.target != 0 {
+		render.FreePicture(display.conn, renderer.target)
+		renderer.target = 0
+	}
+
+	target, err := render.NewPictureId(display.conn)
+	if err != nil {
+		return 0, fmt.Errorf("new picture id: %w", err)
+	}
+
+	err = render.CreatePictureChecked(display.conn, target, xproto.Drawable(pixmap), renderer.windowFormat, 0, nil).Check()
+	if err != nil {
+		return 0, fmt.Errorf("create picture: %w", err)
+	}
+
+	renderer.target = target
+	renderer.targetPixmap = pixmap
+
+	return target, nil
+}
+
+// free releases the uploaded image.
+func (renderer *xrenderer) free(display *Window) {
+	if renderer.picture != 0 {
+		render.FreePicture(display.conn, renderer.picture)
+		renderer.picture = 0
+	}
+
+	if renderer.pixmap != 0 {
+		xproto.FreePixmap(display.conn, renderer.pixmap)
+		renderer.pixmap = 0
+	}
+
+	renderer.size = image.Point{}
+	renderer.uploaded = nil
+}
+
+// release frees everything the renderer created.
+func (renderer *xrenderer) release(display *Window) {
+	renderer.free(display)
+
+	for _, picture := range []render.Picture{renderer.target, renderer.mask} {
+		if picture != 0 {
+			render.FreePicture(display.conn, picture)
+		}
+	}
+}
+
+// xrenderFilter returns the render filter closest to filter.
+func xrenderFilter(filter ScaleFilter) string {
+	switch filter {
+	case FilterNearest:
+		return "nearest"
+	case FilterBilinear:
+		return "bilinear"
+	}
+
+	// a convolution when scaling down on current servers
+	return "best"
+}
+
+// fixedPoint converts to the 16.16 fixed point numbers of render.
+func fixedPoint(value float64) render.Fixed {
+	return render.Fixed(value * 0x10000)
+}
@@ -0,0 +1,185 @@
+package overlay
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// each step of the scroll wheel zooms in or out by this factor
+	zoomStep = 1.25
+	// 1 is the image as placed by the scale mode
+	minZoom = 1
+	maxZoom = 64
+
+	// svgs are rasterized at most this large, zooming in further scales the
+	// raster
+	maxVectorPixels = 4096 * 4096
+)
+
+// mouse buttons as reported by X, 4 and 5 are the scroll wheel
+const (
+	buttonLeft       = 1
+	buttonMiddle     = 2
+	buttonRight      = 3
+	buttonScrollUp   = 4
+	buttonScrollDown = 5
+)
+
+// viewport is the zoom and pan applied on top of the scale mode, in window
+// pixels. The zero value shows the image as placed.
+type viewport struct {
+	zoom    float64
+	offsetX float64
+	offsetY float64
+}
+
+func (view viewport) scale() float64 {
+	if view.zoom == 0 {
+		return 1
+	}
+
+	return view.zoom
+}
+
+// apply zooms and pans the rectangle the image was placed at.
+func (view viewport) apply(placed image.Rectangle) image.Rectangle {
+	zoom := view.scale()
+	if zoom == 1 && view.offsetX == 0 && view.offsetY == 0 {
+		return placed
+	}
+
+	transform := func(v int, offset float64) int {
+		return int(math.Round(float64(v)*zoom + offset))
+	}
+
+	return image.Rect(
+		transform(placed.Min.X, view.offsetX),
+		transform(placed.Min.Y, view.offsetY),
+		transform(placed.Max.X, view.offsetX),
+		transform(placed.Max.Y, view.offsetY),
+	)
+}
+
+// zoomAt changes the zoom by factor, keeping the point at x, y where it is.
+func (view viewport) zoomAt(factor float64, x int, y int) viewport {
+	zoom := view.scale()
+	next := min(maxZoom, max(minZoom, zoom*factor))
+
+	if next == minZoom {
+		return viewport{}
+	}
+
+	ratio := next / zoom
+
+	return viewport{
+		zoom:    next,
+		offsetX: float64(x) - (float64(x)-view.offsetX)*ratio,
+		offsetY: float64(y) - (float64(y)-view.offsetY)*ratio,
+	}
+}
+
+// zoom zooms in or out by one step around x, y in the window.
+func (display *Window) zoom(in bool, x int, y int) {
+	factor := zoomStep
+	if !in {
+		factor = 1 / zoomStep
+	}
+
+	display.renderMu.Lock()
+	display.view = display.view.zoomAt(factor, x, y)
+	display.renderMu.Unlock()
+
+	display.requestResizeRedraw(display.windowWidth + display.windowHeight)
+}
+
+// startPan starts moving the image along with the pointer, until endPan.
+func (display *Window) startPan(x int, y int) {
+	display.renderMu.Lock()
+	display.panning = true
+	display.panFrom = image.Pt(x, y)
+	display.renderMu.Unlock()
+}
+
+func (display *Window) pan(x int, y int) {
+	display.renderMu.Lock()
+	if !display.panning {
+		display.renderMu.Unlock()
+		return
+	}
+
+	delta := image.Pt(x, y).Sub(display.panFrom)
+	display.panFrom = image.Pt(x, y)
+
+	// panning only makes sense when zoomed in, otherwise the image
+	// stays where the scale mode put it
+	if display.view.scale() != 1 {
+		display.view.offsetX += float64(delta.X)
+		display.view.offsetY += float64(delta.Y)
+	}
+	display.renderMu.Unlock()
+
+	display.requestResizeRedraw(abs(delta.X) + abs(delta.Y))
+}
+
+func (display *Window) endPan() {
+	display.renderMu.Lock()
+	display.panning = false
+	display.renderMu.Unlock()
+}
+
+// resetZoom shows the whole image as placed by the scale mode again.
+func (display *Window) resetZoom() {
+	display.renderMu.Lock()
+	display.view = viewport{}
+	display.renderMu.Unlock()
+
+	display.requestRedraw()
+}
+
+// visibleSource returns the part of img that ends up within visible when
+// the whole image is scaled to placed, with a margin for the filter kernels,
+// and the rectangle that part is scaled to. Zoomed in images are cropped to
+// it, scaling the whole image would take time and memory for pixels that are
+// not shown.
+func visibleSource(img image.Image, placed image.Rectangle, visible image.Rectangle) (image.Rectangle, image.Rectangle) {
+	const kernelMargin = 2
+
+	bounds := img.Bounds()
+	toSource := func(v int, placedMin int, placedSize int, srcMin int, srcSize int) int {
+		return srcMin + int(math.Floor(float64(v-placedMin)*float64(srcSize)/float64(placedSize)))
+	}
+
+	src := image.Rect(
+		toSource(visible.Min.X, placed.Min.X, placed.Dx(), bounds.Min.X, bounds.Dx())-kernelMargin,
+		toSource(visible.Min.Y, placed.Min.Y, placed.Dy(), bounds.Min.Y, bounds.Dy())-kernelMargin,
+		toSource(visible.Max.X, placed.Min.X, placed.Dx(), bounds.Min.X, bounds.Dx())+1+kernelMargin,
+		toSource(visible.Max.Y, placed.Min.Y, placed.Dy(), bounds.Min.Y, bounds.Dy())+1+kernelMargin,
+	).Intersect(bounds)
+
+	toPlaced := func(v int, srcMin int, srcSize int, placedMin int, placedSize int) int {
+		return placedMin + int(math.Round(float64(v-srcMin)*float64(placedSize)/float64(srcSize)))
+	}
+
+	dst := image.Rect(
+		toPlaced(src.Min.X, bounds.Min.X, bounds.Dx(), placed.Min.X, placed.Dx()),
+		toPlaced(src.Min.Y, bounds.Min.Y, bounds.Dy(), placed.Min.Y, placed.Dy()),
+		toPlaced(src.Max.X, bounds.Min.X, bounds.Dx(), placed.Min.X, placed.Dx()),
+		toPlaced(src.Max.Y, bounds.Min.Y, bounds.Dy(), placed.Min.Y, placed.Dy()),
+	)
+
+	return src, dst
+}
+
+// vectorSize limits the size svgs are rasterized at.
+func vectorSize(placed image.Rectangle) image.Point {
+	size := placed.Size()
+	pixels := float64(size.X) * float64(size.Y)
+	if pixels <= maxVectorPixels {
+		return size
+	}
+
+	scale := math.Sqrt(maxVectorPixels / pixels)
+
+	return image.Pt(max(1, int(float64(size.X)*scale)), max(1, int(float64(size.Y)*scale)))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+)
+
+// placement is an image that --at shows in a window of its own.
+type placement struct {
+	path     string
+	geometry overlay.Geometry
+}
+
+// parsePlacement parses FILE:GEOMETRY, or just FILE to let the window
+// manager place the window. The geometry comes after the last colon, paths
+// may contain colons but geometries don't.
+func parsePlacement(value string) (placement, error) {
+	path, err := filePath(value)
+	if err != nil {
+		return placement{}, err
+	}
+
+	var geometry overlay.Geometry
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		geometry, err = overlay.ParseGeometry(path[i+1:])
+		if err != nil {
+			return placement{}, fmt.Errorf("parse --at %q: %w", value, err)
+		}
+
+		path = path[:i]
+	}
+
+	return placement{path: path, geometry: geometry}, nil
+}
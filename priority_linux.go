@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// forEachThread calls fn for every thread of the process. Scheduling
+// attributes are per thread on linux, threads created later inherit them
+// from the thread that creates them.
+func forEachThread(fn func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("list threads: %w", err)
+	}
+
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		err = fn(tid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setNiceness(niceness int) error {
+	return forEachThread(func(tid int) error {
+		err := unix.Setpriority(unix.PRIO_PROCESS, tid, niceness)
+		if err != nil {
+			return fmt.Errorf("set priority of thread %d: %w", tid, err)
+		}
+
+		return nil
+	})
+}
+
+func setIdlePriority() error {
+	return forEachThread(func(tid int) error {
+		attr := unix.SchedAttr{
+			Size:   unix.SizeofSchedAttr,
+			Policy: unix.SCHED_IDLE,
+		}
+
+		err := unix.SchedSetAttr(tid, &attr, 0)
+		if err != nil {
+			return fmt.Errorf("set scheduling policy of thread %d: %w", tid, err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func setNiceness(niceness int) error {
+	err := unix.Setpriority(unix.PRIO_PROCESS, 0, niceness)
+	if err != nil {
+		return fmt.Errorf("set priority: %w", err)
+	}
+
+	return nil
+}
+
+func setIdlePriority() error {
+	return fmt.Errorf("idle priority is only supported on linux")
+}
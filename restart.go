@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// flags that are replaced by the saved state
+var sessionStateFlags = map[string]bool{
+	"opacity":  true,
+	"geometry": true,
+	"x":        true,
+	"y":        true,
+	"width":    true,
+	"height":   true,
+}
+
+// restartArgs returns the command line that starts the overlay again with
+// the flags it was started with, except for the ones describing its state.
+func restartArgs(flags *pflag.FlagSet) ([]string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	args := []string{executable}
+
+	flags.Visit(func(flag *pflag.Flag) {
+		if sessionStateFlags[flag.Name] {
+			return
+		}
+
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, fmt.Sprintf("--%s=%s", flag.Name, value))
+			}
+
+			return
+		}
+
+		args = append(args, fmt.Sprintf("--%s=%s", flag.Name, flag.Value.String()))
+	})
+
+	return args, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestRestartArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("xoverlay", pflag.ContinueOnError)
+	flags.String("url", "", "")
+	flags.Duration("poll", 10*time.Second, "")
+	flags.Float64("opacity", 0.5, "")
+	flags.StringSlice("header", nil, "")
+	flags.Bool("info", false, "")
+
+	err := flags.Parse([]string{"--opacity=0.3", "--header=A: 1", "--header=B: 2", "--info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what main does with a url given as the image
+	for _, set := range [][2]string{{"url", "https://example.com/a.png"}, {"poll", "-1ns"}} {
+		err = flags.Set(set[0], set[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args, err := restartArgs(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sorted by name, without the opacity the session saves
+	want := []string{"--header=A: 1", "--header=B: 2", "--info=true", "--poll=-1ns", "--url=https://example.com/a.png"}
+	if !reflect.DeepEqual(args[1:], want) {
+		t.Errorf("restartArgs = %q, want %q", args[1:], want)
+	}
+}
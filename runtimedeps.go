@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// X extensions that are used when the server has them, and what for.
+var runtimeExtensions = []struct {
+	name string
+	use  string
+}{
+	{"MIT-SHM", "fast uploads, the pixels are sent over the connection without it"},
+	{"RENDER", "--xrender"},
+	{"Composite", "--blend, the windows below the overlay"},
+	{"DAMAGE", "--window"},
+	{"SHAPE", "--split, --privacy-zone and clicks going through"},
+	{"XFIXES", "--privacy-zone"},
+	{"RANDR", "--output and following monitor changes"},
+	{"MIT-SCREEN-SAVER", "--idle and keeping the screen awake"},
+}
+
+// programs that are run for some options, if they are installed.
+var runtimePrograms = []struct {
+	name string
+	use  string
+}{
+	{"ffmpeg", "webm recordings"},
+	{"update-desktop-database", "install-desktop"},
+	{"gtk-update-icon-cache", "install-desktop"},
+}
+
+// printRuntimeDeps writes what the binary needs to run: no libc if it was
+// built without cgo, an X server and, optionally, some of its extensions
+// and a few programs. The X server of $DISPLAY is asked which extensions it
+// has.
+func printRuntimeDeps(w io.Writer) error {
+	cgo := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "CGO_ENABLED" {
+				cgo = setting.Value
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "build: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	switch cgo {
+	case "0":
+		fmt.Fprintln(w, "libc: none, statically linked")
+	case "1":
+		fmt.Fprintln(w, "libc: linked dynamically, build with CGO_ENABLED=0 for a static binary")
+	default:
+		fmt.Fprintln(w, "libc: unknown")
+	}
+
+	if runtime.GOOS == "linux" {
+		fmt.Fprintln(w, "kernel: linux 5.13 or newer with landlock for --sandbox, which needs CGO_ENABLED=0 too")
+	}
+
+	fmt.Fprintln(w, "x server:")
+
+	conn, err := xgb.NewConn()
+	if err != nil {
+		fmt.Fprintf(w, "  can't connect to check the extensions: %v\n", err)
+	} else {
+		defer conn.Close()
+
+		fmt.Fprintf(w, "  %s, release %d\n", xproto.Setup(conn).Vendor, xproto.Setup(conn).ReleaseNumber)
+	}
+
+	for _, extension := range runtimeExtensions {
+		status := "-"
+		if conn != nil {
+			reply, err := xproto.QueryExtension(conn, uint16(len(extension.name)), extension.name).Reply()
+			switch {
+			case err != nil:
+				return fmt.Errorf("query extension %s: %w", extension.name, err)
+			case reply.Present:
+				status = "present"
+			default:
+				status = "missing"
+			}
+		}
+
+		fmt.Fprintf(w, "  %-16s  %-7s  %s\n", extension.name, status, extension.use)
+	}
+
+	fmt.Fprintln(w, "programs:")
+
+	for _, program := range runtimePrograms {
+		path, err := exec.LookPath(program.name)
+		if err != nil {
+			path = "missing"
+		}
+
+		fmt.Fprintf(w, "  %-23s  %s, %s\n", program.name, program.use, path)
+	}
+
+	return nil
+}
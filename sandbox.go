@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+)
+
+// sandboxPaths are the files and directories the process can still use once
+// it entered the sandbox. Everything else is refused, the images it decodes
+// can come from anywhere and a bug in a decoder shouldn't be able to read or
+// change other files.
+type sandboxPaths struct {
+	read  []string
+	write []string
+}
+
+// newSandboxPaths allows reading the images and the directories they are in,
+// and the config, and writing the control socket directory and the state.
+func newSandboxPaths(images []string, dirs []string, socket bool, state bool) sandboxPaths {
+	var paths sandboxPaths
+
+	for _, dir := range dirs {
+		paths.allowRead(dir)
+	}
+
+	for _, filename := range images {
+		if filename == "-" || slices.Contains(dirs, filename) {
+			continue
+		}
+
+		// the directory instead of the file, editors replace files by
+		// renaming a new one over them when they are saved
+		paths.allowRead(filepath.Dir(filename))
+	}
+
+	path, err := configPath()
+	if err == nil {
+		paths.allowRead(filepath.Dir(path))
+	}
+
+	// for the memory limits, and for resolving host names and verifying
+	// certificates of images that are downloaded
+	paths.allowRead("/proc/self")
+	paths.allowRead("/etc/hosts")
+	paths.allowRead("/etc/resolv.conf")
+	paths.allowRead("/etc/nsswitch.conf")
+	paths.allowRead("/etc/ssl")
+	paths.allowRead("/etc/pki")
+
+	if socket {
+		// removed when the overlay is closed
+		paths.allowWrite(overlay.ControlSocketDir())
+	}
+
+	if state {
+		dir, err := overlay.StateDir()
+		if err == nil {
+			// it can't be created once the sandbox is entered
+			os.MkdirAll(dir, 0o700)
+			paths.allowWrite(dir)
+		}
+	}
+
+	return paths
+}
+
+func (paths *sandboxPaths) allowRead(path string) {
+	path, err := filepath.Abs(path)
+	if err == nil && !slices.Contains(paths.read, path) {
+		paths.read = append(paths.read, path)
+	}
+}
+
+func (paths *sandboxPaths) allowWrite(path string) {
+	path, err := filepath.Abs(path)
+	if err == nil && !slices.Contains(paths.write, path) {
+		paths.write = append(paths.write, path)
+	}
+}
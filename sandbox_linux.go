@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// enterSandbox restricts the process to paths with landlock, and forbids
+// running other programs and looking into other processes with seccomp.
+// Connections and files that are already open, like the one to the X server
+// and the listeners, can still be used. It applies to every thread and can't
+// be undone.
+func enterSandbox(paths sandboxPaths) error {
+	// required for both, and keeps anything from gaining privileges
+	_, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0)
+	if errno == syscall.ENOTSUP {
+		return fmt.Errorf("the sandbox needs a build without cgo, CGO_ENABLED=0")
+	}
+	if errno != 0 {
+		return fmt.Errorf("set no new privileges: %w", errno)
+	}
+
+	err := restrictPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	return restrictSyscalls()
+}
+
+// landlockRights returns the rights that are handled by landlock with the
+// version abi, and the ones that are granted for paths to read and write.
+func landlockRights(abi int) (handled uint64, read uint64, write uint64) {
+	// every right of the first version
+	handled = unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1
+
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	read = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	write = read | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		handled&unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+	return handled, read, write
+}
+
+func restrictPaths(paths sandboxPaths) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not available: %w", errno)
+	}
+
+	handled, read, write := landlockRights(int(abi))
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	if abi >= 4 {
+		// the listeners are open already, no others are needed
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range paths.read {
+		err := allowPath(int(fd), path, read)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths.write {
+		err := allowPath(int(fd), path, write)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("restrict paths: %w", errno)
+	}
+
+	return nil
+}
+
+// allowPath adds a rule to the ruleset that grants access to path, and
+// everything in it if it is a directory. Paths that don't exist are skipped.
+func allowPath(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+
+	err = unix.Fstat(fd, &stat)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		// rights for directories can't be granted for files
+		access &= unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	rule := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd),
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("allow %s: %w", path, errno)
+	}
+
+	return nil
+}
+
+// auditArchs are the architectures seccomp reports for the ones of go.
+var auditArchs = map[string]uint32{
+	"386":     unix.AUDIT_ARCH_I386,
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm":     unix.AUDIT_ARCH_ARM,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"loong64": unix.AUDIT_ARCH_LOONGARCH64,
+	"ppc64le": unix.AUDIT_ARCH_PPC64LE,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+	"s390x":   unix.AUDIT_ARCH_S390X,
+}
+
+// deniedSyscalls fail with EPERM in the sandbox.
+var deniedSyscalls = []uint32{
+	unix.SYS_EXECVE,
+	unix.SYS_EXECVEAT,
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+}
+
+// restrictSyscalls installs a seccomp filter for every thread that denies
+// deniedSyscalls, and kills the process on system calls of another
+// architecture, which have other numbers.
+func restrictSyscalls() error {
+	arch, ok := auditArchs[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("no seccomp filter for %s", runtime.GOARCH)
+	}
+
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		ret  = unix.BPF_RET | unix.BPF_K
+		// the offsets in struct seccomp_data
+		offsetNr   = 0
+		offsetArch = 4
+		// set for the x32 system calls on amd64
+		x32Bit = 0x40000000
+	)
+
+	denied := len(deniedSyscalls)
+
+	// the instructions jump to the last two, allow and deny, relative to
+	// the next one
+	filter := []unix.SockFilter{
+		{Code: load, K: offsetArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: ret, K: unix.SECCOMP_RET_KILL_PROCESS},
+		{Code: load, K: offsetNr},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: uint8(denied + 1), K: x32Bit},
+	}
+
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: uint8(denied - i), K: nr})
+	}
+
+	filter = append(filter,
+		unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ALLOW},
+		unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+	)
+
+	program := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	// returns the id of a thread it couldn't be installed for
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&program)))
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("install seccomp filter: thread %d can't be synchronized", tid)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func enterSandbox(paths sandboxPaths) error {
+	return fmt.Errorf("the sandbox is only supported on linux")
+}
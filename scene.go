@@ -0,0 +1,784 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// how long a window that was started gets to listen on its socket
+	sceneStartTimeout = 10 * time.Second
+	// how long a window gets to quit before it is killed
+	sceneStopTimeout = 5 * time.Second
+	// how often the rules are checked again
+	sceneRuleInterval = time.Minute
+)
+
+// options a scene sets itself or that don't show a window
+var sceneReserved = []string{"socket", "no-socket", "check", "print-runtime-deps", "help"}
+
+// the options that can go into the transforms of a window
+var sceneTransforms = []string{"rotate", "flip-h", "flip-v", "crop", "corners", "auto-trim", "scale", "align", "filter", "invert", "grayscale", "channel", "threshold", "pixelate", "cvd", "blueprint", "outline"}
+
+// scene is a scene file, the windows it describes from the bottom to the
+// top:
+//
+//	layers: [reference, mockups]
+//
+//	windows:
+//	  grid:
+//	    layer: reference
+//	    source: grid.png
+//	    geometry: 1920x1080+0+0
+//	    opacity: 0.2
+//	  mockup:
+//	    layer: mockups
+//	    sources: [designs/]
+//	    slideshow: 10s
+//	    transforms:
+//	      flip-h: true
+//	    rules:
+//	      days: mon-fri
+//	      time: 09:00-18:00
+type scene struct {
+	windows []sceneWindow
+}
+
+// sceneWindow is an overlay of a scene, started as xoverlay with args and
+// the sources.
+type sceneWindow struct {
+	name    string
+	sources []string
+	// changed on the running overlay instead of starting it again
+	opacity string
+	layer   int
+	args    []string
+	rules   sceneRules
+}
+
+// sameProcess reports whether window can be turned into other without
+// starting it again.
+func (window sceneWindow) sameProcess(other sceneWindow) bool {
+	return slices.Equal(window.sources, other.sources) && slices.Equal(window.args, other.args)
+}
+
+func (window sceneWindow) equal(other sceneWindow) bool {
+	return window.sameProcess(other) && window.opacity == other.opacity && window.layer == other.layer && window.rules.equal(other.rules)
+}
+
+// sceneRules say when a window is shown, always without any.
+type sceneRules struct {
+	days []time.Weekday
+	// minutes after midnight, the same without a time
+	from int
+	to   int
+}
+
+func (rules sceneRules) equal(other sceneRules) bool {
+	return slices.Equal(rules.days, other.days) && rules.from == other.from && rules.to == other.to
+}
+
+func (rules sceneRules) match(now time.Time) bool {
+	if len(rules.days) > 0 && !slices.Contains(rules.days, now.Weekday()) {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+
+	switch {
+	case rules.from < rules.to:
+		return minute >= rules.from && minute < rules.to
+	case rules.from > rules.to:
+		// past midnight
+		return minute >= rules.from || minute < rules.to
+	default:
+		return true
+	}
+}
+
+// parseScene parses a scene file whose options are those of flags.
+func parseScene(data string, flags *pflag.FlagSet) (scene, error) {
+	root, err := parseYAML(data)
+	if err != nil {
+		return scene{}, err
+	}
+
+	var layers []string
+	var windows *yamlNode
+
+	for _, key := range root.keys {
+		node := root.fields[key]
+
+		switch key {
+		case "layers":
+			layers, err = yamlValues(node)
+			if err != nil {
+				return scene{}, fmt.Errorf("line %d: layers: %w", node.line, err)
+			}
+
+			for i, layer := range layers {
+				if slices.Contains(layers[:i], layer) {
+					return scene{}, fmt.Errorf("line %d: layer %q is given twice", node.line, layer)
+				}
+			}
+		case "windows":
+			if node.kind != yamlMapping {
+				return scene{}, fmt.Errorf("line %d: windows has to be a mapping of names to windows", node.line)
+			}
+
+			windows = node
+		default:
+			return scene{}, fmt.Errorf("line %d: unknown key %q, expected layers or windows", node.line, key)
+		}
+	}
+
+	var s scene
+	if windows == nil {
+		return s, nil
+	}
+
+	for _, name := range windows.keys {
+		window, err := parseSceneWindow(name, windows.fields[name], layers, flags)
+		if err != nil {
+			return scene{}, fmt.Errorf("window %s: %w", name, err)
+		}
+
+		s.windows = append(s.windows, window)
+	}
+
+	// the windows of a layer go above the ones of the layers before it, in
+	// the order they are written
+	slices.SortStableFunc(s.windows, func(a sceneWindow, b sceneWindow) int {
+		return a.layer - b.layer
+	})
+
+	return s, nil
+}
+
+func parseSceneWindow(name string, node *yamlNode, layers []string, flags *pflag.FlagSet) (sceneWindow, error) {
+	window := sceneWindow{name: name}
+
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return r > 0x7f || !isKeyChar(byte(r)) }) >= 0 {
+		return window, fmt.Errorf("line %d: names can only have letters, digits, - and _", node.line)
+	}
+
+	if node.kind != yamlMapping {
+		return window, fmt.Errorf("line %d: expected a mapping of options", node.line)
+	}
+
+	options := map[string][]string{}
+
+	addOption := func(key string, value *yamlNode) error {
+		name := strings.ReplaceAll(key, "_", "-")
+
+		flag := flags.Lookup(name)
+		if flag == nil || slices.Contains(sceneReserved, name) {
+			return fmt.Errorf("line %d: %q can't be set in a scene", value.line, key)
+		}
+
+		if _, ok := options[name]; ok {
+			return fmt.Errorf("line %d: %q is set twice", value.line, name)
+		}
+
+		values, err := yamlValues(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", value.line, name, err)
+		}
+
+		if _, ok := flag.Value.(pflag.SliceValue); !ok && len(values) != 1 {
+			return fmt.Errorf("line %d: %s takes a single value", value.line, name)
+		}
+
+		for _, v := range values {
+			err := checkConfigValue(flag, v)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", value.line, name, err)
+			}
+		}
+
+		options[name] = values
+
+		return nil
+	}
+
+	for _, key := range node.keys {
+		value := node.fields[key]
+
+		switch key {
+		case "source", "sources":
+			sources, err := yamlValues(value)
+			if err != nil {
+				return window, fmt.Errorf("line %d: %s: %w", value.line, key, err)
+			}
+
+			window.sources = append(window.sources, sources...)
+		case "layer":
+			if value.kind != yamlScalar {
+				return window, fmt.Errorf("line %d: layer has to be a name", value.line)
+			}
+
+			window.layer = slices.Index(layers, value.value)
+			if window.layer < 0 {
+				return window, fmt.Errorf("line %d: no layer %q in layers", value.line, value.value)
+			}
+		case "opacity":
+			if value.kind != yamlScalar {
+				return window, fmt.Errorf("line %d: opacity has to be a number", value.line)
+			}
+
+			_, err := strconv.ParseFloat(value.value, 64)
+			if err != nil {
+				return window, fmt.Errorf("line %d: opacity: %w", value.line, err)
+			}
+
+			window.opacity = value.value
+		case "transforms":
+			if value.kind != yamlMapping {
+				return window, fmt.Errorf("line %d: transforms has to be a mapping", value.line)
+			}
+
+			for _, transform := range value.keys {
+				if !slices.Contains(sceneTransforms, strings.ReplaceAll(transform, "_", "-")) {
+					return window, fmt.Errorf("line %d: %q is not a transform, expected one of %s", value.fields[transform].line, transform, strings.Join(sceneTransforms, ", "))
+				}
+
+				err := addOption(transform, value.fields[transform])
+				if err != nil {
+					return window, err
+				}
+			}
+		case "rules":
+			rules, err := parseSceneRules(value)
+			if err != nil {
+				return window, err
+			}
+
+			window.rules = rules
+		default:
+			err := addOption(key, value)
+			if err != nil {
+				return window, err
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(options)) {
+		for _, value := range options[name] {
+			window.args = append(window.args, fmt.Sprintf("--%s=%s", name, value))
+		}
+	}
+
+	return window, nil
+}
+
+// yamlValues returns the values of a value or a list of them.
+func yamlValues(node *yamlNode) ([]string, error) {
+	switch node.kind {
+	case yamlScalar:
+		return []string{node.value}, nil
+	case yamlList:
+		values := make([]string, 0, len(node.items))
+		for _, item := range node.items {
+			if item.kind != yamlScalar {
+				return nil, fmt.Errorf("expected a list of values, not of %s", item.kind)
+			}
+
+			values = append(values, item.value)
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected a value or a list, not %s", node.kind)
+	}
+}
+
+func parseSceneRules(node *yamlNode) (sceneRules, error) {
+	var rules sceneRules
+
+	if node.kind != yamlMapping {
+		return rules, fmt.Errorf("line %d: rules has to be a mapping", node.line)
+	}
+
+	for _, key := range node.keys {
+		value := node.fields[key]
+
+		switch key {
+		case "days":
+			values, err := yamlValues(value)
+			if err != nil {
+				return rules, fmt.Errorf("line %d: days: %w", value.line, err)
+			}
+
+			for _, days := range values {
+				weekdays, err := parseWeekdays(days)
+				if err != nil {
+					return rules, fmt.Errorf("line %d: days: %w", value.line, err)
+				}
+
+				rules.days = append(rules.days, weekdays...)
+			}
+		case "time":
+			from, to, ok := strings.Cut(value.value, "-")
+			if value.kind != yamlScalar || !ok {
+				return rules, fmt.Errorf("line %d: time has to be a range like 09:00-17:00", value.line)
+			}
+
+			var err error
+			rules.from, err = parseClock(from)
+			if err != nil {
+				return rules, fmt.Errorf("line %d: time: %w", value.line, err)
+			}
+
+			rules.to, err = parseClock(to)
+			if err != nil {
+				return rules, fmt.Errorf("line %d: time: %w", value.line, err)
+			}
+
+			if rules.from == rules.to {
+				return rules, fmt.Errorf("line %d: time: the range is empty", value.line)
+			}
+		default:
+			return rules, fmt.Errorf("line %d: unknown rule %q, expected days or time", value.line, key)
+		}
+	}
+
+	return rules, nil
+}
+
+// parseWeekdays parses a day like mon or monday, or a range like mon-fri.
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	parse := func(name string) (time.Weekday, error) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if len(name) >= 3 && strings.HasPrefix(strings.ToLower(day.String()), name) {
+				return day, nil
+			}
+		}
+
+		return 0, fmt.Errorf("unknown day %q", name)
+	}
+
+	first, last, isRange := strings.Cut(value, "-")
+
+	start, err := parse(first)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRange {
+		return []time.Weekday{start}, nil
+	}
+
+	end, err := parse(last)
+	if err != nil {
+		return nil, err
+	}
+
+	// fri-mon goes over the weekend
+	days := []time.Weekday{start}
+	for day := start; day != end; {
+		day = (day + 1) % 7
+		days = append(days, day)
+	}
+
+	return days, nil
+}
+
+// parseClock returns the minutes after midnight of a time like 09:30.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// sceneProcess is a running window of the scene.
+type sceneProcess struct {
+	window sceneWindow
+	cmd    *exec.Cmd
+	socket string
+	// closed when the process exited, with err
+	done chan struct{}
+	err  error
+}
+
+// sceneRunner keeps the windows of a scene file running the way the file
+// describes them.
+type sceneRunner struct {
+	path       string
+	executable string
+	flags      *pflag.FlagSet
+
+	scene   scene
+	running map[string]*sceneProcess
+	// the windows the user closed, they stay closed until they change
+	closed map[string]sceneWindow
+	exited chan *sceneProcess
+}
+
+func newSceneCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "scene <scene.yaml>",
+		Short: "show the windows of a scene file and keep them as it describes",
+		Long: `Show the windows described by a scene file, each an overlay with its own
+sources, options and transforms, stacked by layer. Windows can have rules
+to only be shown on some days or at some times of the day.
+
+When the file changes, or on SIGHUP, the windows are brought in line with
+it: new ones are started, removed ones closed, and changed ones started
+again, except that a change of the opacity or the layer is applied to the
+running window. A window that was closed stays closed until it changes.
+A scene with a mistake is shown in the windows, which stay as they were.
+
+The options of a window are the long options of xoverlay:
+
+  layers: [reference, mockups]
+
+  windows:
+    grid:
+      layer: reference
+      source: grid.png
+      geometry: 1920x1080+0+0
+      opacity: 0.2
+      override-redirect: true
+    mockup:
+      layer: mockups
+      sources: [designs/]
+      slideshow: 10s
+      transforms:
+        rotate: 90
+        crop: 0,0,800,600
+      rules:
+        days: mon-fri
+        time: 09:00-18:00
+
+Relative paths are relative to the directory of the scene file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				return fmt.Errorf("resolve scene path: %w", err)
+			}
+
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("find executable: %w", err)
+			}
+
+			runner := &sceneRunner{
+				path:       path,
+				executable: executable,
+				flags:      cmd.Root().Flags(),
+				running:    map[string]*sceneProcess{},
+				closed:     map[string]sceneWindow{},
+				exited:     make(chan *sceneProcess),
+			}
+
+			return runner.run()
+		},
+	}
+}
+
+// run shows the scene until it gets SIGINT or SIGTERM.
+func (runner *sceneRunner) run() error {
+	err := runner.load()
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGHUP, unix.SIGINT, unix.SIGTERM)
+	defer signal.Stop(signals)
+
+	changed := make(chan struct{}, 1)
+
+	watcher, err := watchFile(runner.path, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		slog.Warn("watch scene, reload it with SIGHUP instead", "err", err)
+	} else {
+		defer watcher.Close()
+	}
+
+	ticker := time.NewTicker(sceneRuleInterval)
+	defer ticker.Stop()
+
+	runner.reconcile(time.Now())
+
+	for {
+		select {
+		case <-changed:
+			runner.reload()
+		case sig := <-signals:
+			if sig != unix.SIGHUP {
+				runner.stopAll()
+				return nil
+			}
+
+			runner.reload()
+		case <-ticker.C:
+			runner.reconcile(time.Now())
+		case process := <-runner.exited:
+			if runner.running[process.window.name] != process {
+				// stopped by us
+				continue
+			}
+
+			delete(runner.running, process.window.name)
+			runner.closed[process.window.name] = process.window
+
+			if process.err != nil {
+				slog.Error("scene window failed", "window", process.window.name, "err", process.err)
+			} else {
+				slog.Info("scene window closed", "window", process.window.name)
+			}
+		}
+	}
+}
+
+func (runner *sceneRunner) load() error {
+	data, err := os.ReadFile(runner.path)
+	if err != nil {
+		return fmt.Errorf("read scene: %w", err)
+	}
+
+	s, err := parseScene(string(data), runner.flags)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", runner.path, err)
+	}
+
+	runner.scene = s
+
+	return nil
+}
+
+// reload loads the scene again, a broken one leaves the windows as they are
+// until it is fixed and the error is shown in them.
+func (runner *sceneRunner) reload() {
+	err := runner.load()
+	if err != nil {
+		slog.Error("reload scene", "err", err)
+
+		message := err.Error()
+		for _, process := range runner.running {
+			err := runner.send(process, overlay.ControlRequest{Command: "notify", Message: message})
+			if err != nil {
+				slog.Error("notify scene window", "window", process.window.name, "err", err)
+			}
+		}
+
+		return
+	}
+
+	runner.reconcile(time.Now())
+}
+
+// reconcile starts, updates and closes windows until they are what the
+// scene describes at now.
+func (runner *sceneRunner) reconcile(now time.Time) {
+	restack := false
+	wanted := map[string]bool{}
+
+	for _, window := range runner.scene.windows {
+		if !window.rules.match(now) {
+			// shown again the next time the rules match
+			delete(runner.closed, window.name)
+			continue
+		}
+
+		if closed, ok := runner.closed[window.name]; ok {
+			if closed.equal(window) {
+				continue
+			}
+
+			delete(runner.closed, window.name)
+		}
+
+		wanted[window.name] = true
+
+		process := runner.running[window.name]
+		if process != nil && !process.window.sameProcess(window) {
+			runner.stop(process)
+			process = nil
+		}
+
+		if process == nil {
+			started, err := runner.start(window)
+			if err != nil {
+				slog.Error("start scene window", "window", window.name, "err", err)
+				continue
+			}
+
+			runner.running[window.name] = started
+			restack = true
+
+			continue
+		}
+
+		if process.window.opacity != window.opacity && window.opacity != "" {
+			opacity, _ := strconv.ParseFloat(window.opacity, 64)
+
+			err := runner.send(process, overlay.ControlRequest{Command: "opacity", Opacity: &opacity})
+			if err != nil {
+				slog.Error("set opacity of scene window", "window", window.name, "err", err)
+			}
+		}
+
+		restack = restack || process.window.layer != window.layer
+		process.window = window
+	}
+
+	for name, process := range runner.running {
+		if !wanted[name] {
+			runner.stop(process)
+			delete(runner.running, name)
+		}
+	}
+
+	if restack {
+		runner.restack()
+	}
+}
+
+func (runner *sceneRunner) start(window sceneWindow) (*sceneProcess, error) {
+	socket := filepath.Join(overlay.ControlSocketDir(), fmt.Sprintf("scene-%d-%s.sock", os.Getpid(), window.name))
+
+	// a socket left behind would look like the window already listens
+	err := os.Remove(socket)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	// a config without a socket would leave the window out of reach
+	args := append([]string{"--socket=" + socket, "--no-socket=false"}, window.args...)
+	if window.opacity != "" {
+		args = append(args, "--opacity="+window.opacity)
+	}
+
+	args = append(args, "--")
+	args = append(args, window.sources...)
+
+	cmd := exec.Command(runner.executable, args...)
+	cmd.Dir = filepath.Dir(runner.path)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("start overlay: %w", err)
+	}
+
+	process := &sceneProcess{window: window, cmd: cmd, socket: socket, done: make(chan struct{})}
+
+	go func() {
+		process.err = cmd.Wait()
+		close(process.done)
+		runner.exited <- process
+	}()
+
+	return process, nil
+}
+
+// stop asks the window to quit and kills it if it doesn't.
+func (runner *sceneRunner) stop(process *sceneProcess) {
+	err := runner.send(process, overlay.ControlRequest{Command: "quit"})
+	if err != nil {
+		process.cmd.Process.Kill()
+	}
+
+	select {
+	case <-process.done:
+	case <-time.After(sceneStopTimeout):
+		slog.Warn("scene window doesn't quit, killing it", "window", process.window.name)
+		process.cmd.Process.Kill()
+		<-process.done
+	}
+
+	os.Remove(process.socket)
+}
+
+func (runner *sceneRunner) stopAll() {
+	for name, process := range runner.running {
+		runner.stop(process)
+		delete(runner.running, name)
+	}
+}
+
+// restack raises the windows from the bottom to the top, once they listen
+// on their sockets.
+func (runner *sceneRunner) restack() {
+	for _, window := range runner.scene.windows {
+		process := runner.running[window.name]
+		if process == nil {
+			continue
+		}
+
+		err := runner.waitListening(process)
+		if err != nil {
+			slog.Error("raise scene window", "window", window.name, "err", err)
+			continue
+		}
+
+		err = runner.send(process, overlay.ControlRequest{Command: "raise"})
+		if err != nil {
+			slog.Error("raise scene window", "window", window.name, "err", err)
+		}
+	}
+}
+
+// waitListening waits until a window that was just started listens on its
+// socket.
+func (runner *sceneRunner) waitListening(process *sceneProcess) error {
+	deadline := time.Now().Add(sceneStartTimeout)
+
+	for {
+		_, err := overlay.SendControl(process.socket, overlay.ControlRequest{Command: "state"})
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not listening after %v: %w", sceneStartTimeout, err)
+		}
+
+		select {
+		case <-process.done:
+			return fmt.Errorf("exited")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (runner *sceneRunner) send(process *sceneProcess, request overlay.ControlRequest) error {
+	response, err := overlay.SendControl(process.socket, request)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	return nil
+}
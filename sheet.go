@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+)
+
+func newSheetCommand() *cobra.Command {
+	columns := 6
+	size := 160
+	out := ""
+
+	cmd := &cobra.Command{
+		Use:   "sheet <dir>",
+		Short: "show or write a contact sheet of the images in a directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			if columns < 1 {
+				return fmt.Errorf("--columns has to be at least 1")
+			}
+
+			if size < 1 {
+				return fmt.Errorf("--size has to be at least 1")
+			}
+
+			files, err := directoryImages(args[0], sortName, "")
+			if err != nil {
+				return err
+			}
+
+			if len(files) == 0 {
+				return fmt.Errorf("no images in %s", args[0])
+			}
+
+			sheet, err := overlay.ContactSheet(files, columns, size)
+			if err != nil {
+				return fmt.Errorf("build contact sheet: %w", err)
+			}
+
+			if out != "" {
+				file, err := os.Create(out)
+				if err != nil {
+					return fmt.Errorf("create %s: %w", out, err)
+				}
+				defer file.Close()
+
+				err = png.Encode(file, sheet)
+				if err != nil {
+					return fmt.Errorf("write %s: %w", out, err)
+				}
+
+				return file.Close()
+			}
+
+			display, err := overlay.New(overlay.WithImage(sheet), overlay.WithOpacity(1))
+			if err != nil {
+				return err
+			}
+			defer display.Close()
+
+			return display.Wait()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&columns, "columns", columns, "number of thumbnails per row")
+	flags.IntVar(&size, "size", size, "maximum width and height of the thumbnails")
+	flags.StringVarP(&out, "out", "o", "", "write the sheet to this png file instead of showing it")
+
+	return cmd
+}
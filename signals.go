@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"golang.org/x/sys/unix"
+)
+
+// handleSignals controls the overlay with signals, for window manager key
+// bindings like "pkill -USR1 xoverlay": SIGUSR1 and SIGUSR2 change the
+// opacity by step, SIGHUP reloads the image. The returned function stops
+// handling them.
+func handleSignals(display *overlay.Window, step float64) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGUSR1, unix.SIGUSR2, unix.SIGHUP)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case unix.SIGUSR1:
+				display.SetOpacity(display.Opacity() + step)
+			case unix.SIGUSR2:
+				display.SetOpacity(display.Opacity() - step)
+			case unix.SIGHUP:
+				err := display.Reload()
+				if err != nil {
+					slog.Error("reload image", "err", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+)
+
+func newSnapCommand() *cobra.Command {
+	opacity := 1.0
+
+	cmd := &cobra.Command{
+		Use:   "snap",
+		Short: "select a region of the screen and keep showing it where it was",
+		Long: `Drag a rectangle over the screen with the left mouse button, what is in it
+is shown in an overlay right on top of it, frozen, while what is below
+changes. Escape or the right mouse button cancel.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			img, region, err := overlay.Snap()
+			if errors.Is(err, overlay.ErrSnapCancelled) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("snap: %w", err)
+			}
+
+			options := overlay.DefaultOptions()
+			options.Image = img
+			options.InitialOpacity = opacity
+			options.Geometry = overlay.Geometry{
+				X:           region.Min.X,
+				Y:           region.Min.Y,
+				Width:       region.Dx(),
+				Height:      region.Dy(),
+				HasPosition: true,
+			}
+			// pinned where the region is, decorations would move it
+			options.NoDecorations = true
+			options.Above = true
+
+			display, err := overlay.New(overlay.WithOptions(options))
+			if err != nil {
+				return fmt.Errorf("show region: %w", err)
+			}
+			defer display.Close()
+
+			return display.Wait()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Float64Var(&opacity, "opacity", opacity, "initial opacity of the region")
+
+	return cmd
+}
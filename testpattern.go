@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+	"github.com/spf13/cobra"
+)
+
+func newTestPatternCommand() *cobra.Command {
+	output := "primary"
+
+	cmd := &cobra.Command{
+		Use:       "testpattern {smpte,grid,gradient,sharpness,dead-pixel,gamma}",
+		Short:     "show a test pattern on a whole monitor, n and p cycle through solid colors",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"smpte", "grid", "gradient", "sharpness", "dead-pixel", "gamma"},
+		RunE: func(_ *cobra.Command, args []string) error {
+			pattern, err := overlay.ParseTestPattern(args[0])
+			if err != nil {
+				return err
+			}
+
+			options := overlay.DefaultOptions()
+			options.TestPattern = pattern
+			options.InitialOpacity = 1
+			options.FullscreenOutput = output
+			options.NoDecorations = true
+			options.Above = true
+
+			display, err := overlay.New(overlay.WithOptions(options))
+			if err != nil {
+				return fmt.Errorf("show test pattern: %w", err)
+			}
+			defer display.Close()
+
+			return display.Wait()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&output, "output", output, "monitor to fill, e.g. HDMI-1")
+
+	return cmd
+}
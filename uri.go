@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/merlinzerbe/xoverlay/overlay"
+)
+
+const uriScheme = "xoverlay"
+
+// overlayURI is a link like xoverlay://open?file=/path/img.png&opacity=0.4,
+// which lets other tools, e.g. web based design tools, open images in the
+// overlay.
+type overlayURI struct {
+	file    string
+	opacity *float64
+}
+
+func isOverlayURI(arg string) bool {
+	return strings.HasPrefix(arg, uriScheme+"://")
+}
+
+// isImageURL reports whether arg is an image on a web server rather than a
+// path.
+func isImageURL(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+}
+
+func parseOverlayURI(raw string) (overlayURI, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return overlayURI{}, fmt.Errorf("parse uri: %w", err)
+	}
+
+	if u.Host != "open" {
+		return overlayURI{}, fmt.Errorf("unknown uri action %q, expected open", u.Host)
+	}
+
+	query := u.Query()
+
+	file, err := filePath(query.Get("file"))
+	if err != nil {
+		return overlayURI{}, err
+	}
+
+	if file == "" {
+		return overlayURI{}, fmt.Errorf("uri has no file")
+	}
+
+	result := overlayURI{file: file}
+
+	if value := query.Get("opacity"); value != "" {
+		opacity, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return overlayURI{}, fmt.Errorf("parse opacity: %w", err)
+		}
+
+		result.opacity = &opacity
+	}
+
+	return result, nil
+}
+
+// filePath turns file:// uris, which file managers pass to the desktop
+// entry, into paths. Everything else is taken as a path already.
+func filePath(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "file://") {
+		return arg, nil
+	}
+
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", fmt.Errorf("parse file uri: %w", err)
+	}
+
+	return u.Path, nil
+}
+
+// forwardURI sends the uri to the overlay that was started last and reports
+// whether there was one.
+func forwardURI(uri overlayURI) (bool, error) {
+	paths, err := overlay.ControlSockets()
+	if err != nil {
+		return false, err
+	}
+
+	newest := ""
+	var newestTime int64
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().UnixNano() > newestTime {
+			newest = path
+			newestTime = info.ModTime().UnixNano()
+		}
+	}
+
+	if newest == "" {
+		return false, nil
+	}
+
+	path, err := filepath.Abs(uri.file)
+	if err != nil {
+		return false, fmt.Errorf("resolve image path: %w", err)
+	}
+
+	requests := []overlay.ControlRequest{{Command: "image", Path: path}}
+	if uri.opacity != nil {
+		requests = append(requests, overlay.ControlRequest{Command: "opacity", Opacity: uri.opacity})
+	}
+
+	for _, request := range requests {
+		response, err := overlay.SendControl(newest, request)
+		if err != nil {
+			// most likely a socket left behind by a crashed overlay
+			return false, nil
+		}
+
+		if !response.OK {
+			return true, fmt.Errorf("%s: %s", newest, response.Error)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// exporters often write a file in several steps or replace it with a
+// rename, so we wait until things calm down before reloading
+const watchDebounce = 100 * time.Millisecond
+
+type fileWatcher struct {
+	file  *os.File
+	timer *time.Timer
+	wg    sync.WaitGroup
+
+	// names of the files that changed since onChange was last called
+	mu      sync.Mutex
+	changed []string
+}
+
+// watchFile calls onChange whenever path has been written to or replaced.
+// The directory is watched instead of the file itself because editors and
+// exporters usually replace files instead of writing to them, which would
+// leave us watching the old inode.
+func watchFile(path string, onChange func()) (*fileWatcher, error) {
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE
+
+	return watch(filepath.Dir(path), filepath.Base(path), mask, func([]string) { onChange() })
+}
+
+// watchDirectory calls onChange with the names of the files in dir that have
+// been written, added, removed or renamed.
+func watchDirectory(dir string, onChange func(names []string)) (*fileWatcher, error) {
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE
+
+	return watch(dir, "", mask, onChange)
+}
+
+// watch calls onChange for events in dir about the file name, or about any
+// file if name is empty.
+func watch(dir string, name string, mask uint32, onChange func(names []string)) (*fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("init inotify: %w", err)
+	}
+
+	_, err = unix.InotifyAddWatch(fd, dir, mask)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("watch %s: %w", dir, err)
+	}
+
+	watcher := &fileWatcher{
+		// non blocking so that the runtime poller can interrupt reads when
+		// the watcher is closed
+		file: os.NewFile(uintptr(fd), "inotify"),
+	}
+
+	watcher.timer = time.AfterFunc(time.Hour, func() {
+		watcher.mu.Lock()
+		changed := watcher.changed
+		watcher.changed = nil
+		watcher.mu.Unlock()
+
+		onChange(changed)
+	})
+	watcher.timer.Stop()
+
+	watcher.wg.Add(1)
+	go watcher.read(name)
+
+	return watcher, nil
+}
+
+func (watcher *fileWatcher) read(name string) {
+	defer watcher.wg.Done()
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := watcher.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("read inotify events", "err", err)
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			// names are padded with zero bytes
+			changed := unix.ByteSliceToString(nameBytes)
+			if name != "" && changed != name {
+				continue
+			}
+
+			watcher.mu.Lock()
+			if !slices.Contains(watcher.changed, changed) {
+				watcher.changed = append(watcher.changed, changed)
+			}
+			watcher.mu.Unlock()
+
+			watcher.timer.Reset(watchDebounce)
+		}
+	}
+}
+
+func (watcher *fileWatcher) Close() {
+	watcher.file.Close()
+	watcher.wg.Wait()
+	watcher.timer.Stop()
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+type fileWatcher struct{}
+
+func watchFile(path string, onChange func()) (*fileWatcher, error) {
+	return nil, fmt.Errorf("watching files is only supported on linux")
+}
+
+func watchDirectory(dir string, onChange func(names []string)) (*fileWatcher, error) {
+	return nil, fmt.Errorf("watching directories is only supported on linux")
+}
+
+func (watcher *fileWatcher) Close() {}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scene files are written in the subset of yaml that describes them: block
+// mappings and lists, lists in brackets, plain and quoted strings and
+// comments. Anchors, multiline strings, nested flow collections and lists
+// of mappings are not supported.
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlMapping
+	yamlList
+)
+
+func (kind yamlKind) String() string {
+	switch kind {
+	case yamlMapping:
+		return "a mapping"
+	case yamlList:
+		return "a list"
+	default:
+		return "a value"
+	}
+}
+
+type yamlNode struct {
+	kind yamlKind
+	line int
+
+	value string
+	// the keys of a mapping in the order of the file
+	keys   []string
+	fields map[string]*yamlNode
+	items  []*yamlNode
+}
+
+// yamlLine is a line without its indentation and comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses data into a mapping, which is empty for an empty file.
+func parseYAML(data string) (*yamlNode, error) {
+	parser := &yamlParser{}
+
+	for i, text := range strings.Split(data, "\n") {
+		line, err := splitYAMLLine(i+1, text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		if line.text == "" || line.indent == 0 && line.text == "---" && len(parser.lines) == 0 {
+			continue
+		}
+
+		parser.lines = append(parser.lines, line)
+	}
+
+	if len(parser.lines) == 0 {
+		return &yamlNode{kind: yamlMapping, line: 1, fields: map[string]*yamlNode{}}, nil
+	}
+
+	if parser.lines[0].indent != 0 {
+		return nil, fmt.Errorf("line %d: the first line is indented", parser.lines[0].number)
+	}
+
+	root, err := parser.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos < len(parser.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", parser.lines[parser.pos].number)
+	}
+
+	if root.kind != yamlMapping {
+		return nil, fmt.Errorf("line %d: expected a mapping", root.line)
+	}
+
+	return root, nil
+}
+
+// splitYAMLLine measures the indentation of text and removes the comment.
+func splitYAMLLine(number int, text string) (yamlLine, error) {
+	text = strings.TrimRight(text, " \t\r")
+
+	indent := len(text) - len(strings.TrimLeft(text, " "))
+	if strings.HasPrefix(text[indent:], "\t") {
+		return yamlLine{}, fmt.Errorf("indent with spaces, not tabs")
+	}
+
+	// a # starts a comment unless it is quoted or part of a word
+	var quote byte
+	for i := indent; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quote != 0:
+			switch {
+			case c == '\\' && quote == '"':
+				i++
+			case c == '\'' && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+				// '' is a quote in a single quoted string
+				i++
+			case c == quote:
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == indent || strings.ContainsRune(" [,:-", rune(text[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == indent || text[i-1] == ' '):
+			text = strings.TrimRight(text[:i], " ")
+		}
+	}
+
+	return yamlLine{number: number, indent: indent, text: text[min(indent, len(text)):]}, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock parses the mapping or list whose lines start at indent.
+func (parser *yamlParser) parseBlock(indent int) (*yamlNode, error) {
+	if isYAMLListItem(parser.lines[parser.pos].text) {
+		return parser.parseList(indent)
+	}
+
+	return parser.parseMapping(indent)
+}
+
+func (parser *yamlParser) parseMapping(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlMapping, line: parser.lines[parser.pos].number, fields: map[string]*yamlNode{}}
+
+	for parser.pos < len(parser.lines) {
+		line := parser.lines[parser.pos]
+		if line.indent < indent {
+			break
+		}
+
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		if isYAMLListItem(line.text) {
+			return nil, fmt.Errorf("line %d: expected a key, not a list item", line.number)
+		}
+
+		key, rest, ok := cutYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+
+		key, err := parseYAMLScalar(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+
+		if _, ok := node.fields[key]; ok {
+			return nil, fmt.Errorf("line %d: %q is set twice", line.number, key)
+		}
+
+		parser.pos++
+
+		value, err := parser.parseValue(line, rest, indent)
+		if err != nil {
+			return nil, err
+		}
+
+		node.keys = append(node.keys, key)
+		node.fields[key] = value
+	}
+
+	return node, nil
+}
+
+func (parser *yamlParser) parseList(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlList, line: parser.lines[parser.pos].number}
+
+	for parser.pos < len(parser.lines) {
+		line := parser.lines[parser.pos]
+		if line.indent < indent || line.indent == indent && !isYAMLListItem(line.text) {
+			break
+		}
+
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if _, _, ok := cutYAMLKey(rest); ok {
+			return nil, fmt.Errorf("line %d: lists of mappings are not supported", line.number)
+		}
+
+		parser.pos++
+
+		item, err := parser.parseValue(line, rest, indent)
+		if err != nil {
+			return nil, err
+		}
+
+		node.items = append(node.items, item)
+	}
+
+	return node, nil
+}
+
+// parseValue parses the value of a key or list item on line, rest if it is
+// on the same line or the block indented below it.
+func (parser *yamlParser) parseValue(line yamlLine, rest string, indent int) (*yamlNode, error) {
+	if rest != "" {
+		value, err := parseYAMLInline(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+
+		value.line = line.number
+
+		return value, nil
+	}
+
+	if parser.pos < len(parser.lines) {
+		next := parser.lines[parser.pos]
+
+		// lists are often not indented below their key
+		if next.indent > indent || next.indent == indent && isYAMLListItem(next.text) && !isYAMLListItem(line.text) {
+			return parser.parseBlock(next.indent)
+		}
+	}
+
+	return &yamlNode{kind: yamlScalar, line: line.number}, nil
+}
+
+// cutYAMLKey splits key: value at the first colon that is followed by a
+// space or ends the line, outside of quotes.
+func cutYAMLKey(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == '[':
+			return "", "", false
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+
+	return "", "", false
+}
+
+// parseYAMLInline parses a value written on the line of its key.
+func parseYAMLInline(text string) (*yamlNode, error) {
+	if !strings.HasPrefix(text, "[") {
+		value, err := parseYAMLScalar(text)
+		if err != nil {
+			return nil, err
+		}
+
+		return &yamlNode{kind: yamlScalar, value: value}, nil
+	}
+
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unterminated list")
+	}
+
+	node := &yamlNode{kind: yamlList}
+
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return node, nil
+	}
+
+	// split at the commas outside of quotes
+	start := 0
+	var quote byte
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				} else if c == '\\' && quote == '"' {
+					i++
+				}
+
+				continue
+			case (c == '"' || c == '\'') && strings.TrimSpace(inner[start:i]) == "":
+				// only items that start with a quote are quoted
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+
+		value, err := parseYAMLScalar(strings.TrimSpace(inner[start:i]))
+		if err != nil {
+			return nil, err
+		}
+
+		node.items = append(node.items, &yamlNode{kind: yamlScalar, value: value})
+		start = i + 1
+	}
+
+	return node, nil
+}
+
+// parseYAMLScalar returns the string text stands for, unquoted.
+func parseYAMLScalar(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+
+	switch text[0] {
+	case '"':
+		return parseYAMLDoubleQuoted(text)
+	case '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '[', '{':
+		return "", fmt.Errorf("nested lists and inline mappings are not supported")
+	case '|', '>':
+		return "", fmt.Errorf("multiline strings are not supported")
+	case '&', '*', '!':
+		return "", fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+
+	return text, nil
+}
+
+func parseYAMLDoubleQuoted(text string) (string, error) {
+	var value strings.Builder
+
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+
+		switch c {
+		case '"':
+			if i != len(text)-1 {
+				return "", fmt.Errorf("unexpected %q after the string", text[i+1:])
+			}
+
+			return value.String(), nil
+		case '\\':
+			i++
+			if i >= len(text) {
+				return "", fmt.Errorf("unterminated string")
+			}
+
+			switch text[i] {
+			case '"', '\\':
+				value.WriteByte(text[i])
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				return "", fmt.Errorf("unsupported escape \\%c", text[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// yamlString writes node on one line, with quoted values and mappings in
+// the order of the file.
+func yamlString(node *yamlNode) string {
+	var parts []string
+
+	switch node.kind {
+	case yamlMapping:
+		for _, key := range node.keys {
+			parts = append(parts, strconv.Quote(key)+": "+yamlString(node.fields[key]))
+		}
+
+		return "{" + strings.Join(parts, ", ") + "}"
+	case yamlList:
+		for _, item := range node.items {
+			parts = append(parts, yamlString(item))
+		}
+
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return strconv.Quote(node.value)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", `{}`},
+		{"only comments", "---\n# a scene\n\n", `{}`},
+		{"values", "name: review\nopacity: 0.5\nempty:\n", `{"name": "review", "opacity": "0.5", "empty": ""}`},
+		{"nested", "windows:\n  mockup:\n    image: a.png\n  grid:\n    pattern: grid\nlayout: row\n", `{"windows": {"mockup": {"image": "a.png"}, "grid": {"pattern": "grid"}}, "layout": "row"}`},
+		{"list", "args:\n  - --scale\n  - fill\n", `{"args": ["--scale", "fill"]}`},
+		{"list not indented", "args:\n- a\n- b\nnext: c\n", `{"args": ["a", "b"], "next": "c"}`},
+		{"inline list", "args: [a, \"b, c\", 'd', '']\nnone: []\n", `{"args": ["a", "b, c", "d", ""], "none": []}`},
+		{"apostrophe in an inline list", "args: [don't, b]\n", `{"args": ["don't", "b"]}`},
+		{"double quoted", `label: "a \"b\"\tc\\d\n: #e"`, `{"label": "a \"b\"\tc\\d\n: #e"}`},
+		{"single quoted", `label: 'it''s C:\a # b'`, `{"label": "it's C:\\a # b"}`},
+		{"quoted keys", "\"a: b\": 1\n'c': 2\n\"d\\\"e: f\": 3\n", `{"a: b": "1", "c": "2", "d\"e: f": "3"}`},
+		{"comments", "# scene\nname: a # the name\nurl: http://host/#top\ntag: a#b\nitems: [a, b] # two\n", `{"name": "a", "url": "http://host/#top", "tag": "a#b", "items": ["a", "b"]}`},
+		{"colon without a space", "url: http://host:80/a\n", `{"url": "http://host:80/a"}`},
+		{"windows line breaks", "a: 1\r\nb:\r\n  - c\r\n", `{"a": "1", "b": ["c"]}`},
+	}
+
+	for _, test := range tests {
+		node, err := parseYAML(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if got := yamlString(node); got != test.want {
+			t.Errorf("%s: %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseYAMLLines(t *testing.T) {
+	node, err := parseYAML("# scene\n\nwindows:\n  a:\n    args:\n      - x\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	windows := node.fields["windows"]
+	args := windows.fields["a"].fields["args"]
+	if node.line != 3 || windows.line != 4 || args.line != 6 || args.items[0].line != 6 {
+		t.Errorf("lines %d, %d, %d and %d", node.line, windows.line, args.line, args.items[0].line)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: "a" is set twice`},
+		{"duplicate quoted key", "a: 1\n\"a\": 2\n", `line 2: "a" is set twice`},
+		{"duplicate nested key", "windows:\n  a:\n    x: 1\n    x: 2\n", `line 4: "x" is set twice`},
+		{"tab", "a:\n\tb: 1\n", "line 2: indent with spaces, not tabs"},
+		{"indented first line", "\n  a: 1\n", "line 2: the first line is indented"},
+		{"unexpected indentation", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"list at the top", "- a\n", "line 1: expected a mapping"},
+		{"list item in a mapping", "a:\n  b: 1\n  - c\n", "line 3: expected a key, not a list item"},
+		{"no key", "a: 1\nb\n", "line 2: expected key: value"},
+		{"list of mappings", "a:\n  - b: 1\n", "line 2: lists of mappings are not supported"},
+		{"unterminated string", "a: \"b\n", "line 1: unterminated string"},
+		{"unterminated single quoted string", "\na: 'b\n", "line 2: unterminated string"},
+		{"text after the string", "a: \"b\" c\n", `line 1: unexpected " c" after the string`},
+		{"unsupported escape", "a: 1\nb: \"\\x41\"\n", `line 2: unsupported escape \x`},
+		{"unterminated list", "a: [b, c\n", "line 1: unterminated list"},
+		{"nested list", "a: [b, [c]]\n", "line 1: nested lists and inline mappings are not supported"},
+		{"inline mapping", "a: {b: c}\n", "line 1: nested lists and inline mappings are not supported"},
+		{"multiline string", "a: |\n  b\n", "line 1: multiline strings are not supported"},
+		{"anchor", "a: &b c\n", "line 1: anchors, aliases and tags are not supported"},
+	}
+
+	for _, test := range tests {
+		_, err := parseYAML(test.data)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: err %v, want %s", test.name, err, test.err)
+		}
+	}
+}